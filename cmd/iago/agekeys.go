@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/agecrypt"
+	"github.com/andreweick/iago/internal/butane"
+	"github.com/andreweick/iago/internal/fleet"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/urfave/cli/v2"
+)
+
+// loadSecretsMachine loads the configuration and the named machine for the secrets commands
+func (e *env) loadSecretsMachine(machineName string) (machine.Config, machine.Defaults, error) {
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return machine.Config{}, machine.Defaults{}, fmt.Errorf("failed to load defaults: %w", err)
+	}
+	if err := loader.LoadMachines(); err != nil {
+		return machine.Config{}, machine.Defaults{}, fmt.Errorf("failed to load machines: %w", err)
+	}
+	m, err := loader.GetMachine(machineName)
+	if err != nil {
+		return machine.Config{}, machine.Defaults{}, err
+	}
+	// Read the machine's files, such as recipients.txt, below the repository root
+	m.Dir = loader.MachineDir(m)
+	defaults, err := loader.DefaultsFor(m)
+	if err != nil {
+		return machine.Config{}, machine.Defaults{}, err
+	}
+	return m, defaults, nil
+}
+
+// newSecretsCommand returns iago secrets and its subcommands
+func newSecretsCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:  "secrets",
+		Usage: "Manage the age keys that encrypt generated secrets ([secrets] encryption = \"age\")",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "keygen",
+				Usage:     "Create a machine's age identity and add its public key to machines/<name>/recipients.txt",
+				ArgsUsage: "[machine-name]",
+				Action:    e.secretsKeygenCommand,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "Replace an existing identity; secrets encrypted to the old one can no longer be decrypted by the machine",
+					},
+				},
+			},
+			{
+				Name:      "recipients",
+				Usage:     "List who can decrypt a machine's secrets",
+				ArgsUsage: "[machine-name]",
+				Action:    e.secretsRecipientsCommand,
+			},
+			{
+				Name:      "add-recipient",
+				Usage:     "Let another age key, e.g. an operator's, decrypt a machine's secrets",
+				ArgsUsage: "[machine-name] [age1...]",
+				Action:    e.secretsAddRecipientCommand,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "comment",
+						Usage: "Comment written above the recipient, e.g. whose key it is",
+					},
+				},
+			},
+			{
+				Name:      "remove-recipient",
+				Usage:     "Stop encrypting a machine's secrets to an age key",
+				ArgsUsage: "[machine-name] [age1...]",
+				Action:    e.secretsRemoveRecipientCommand,
+			},
+			{
+				Name:      "install-key",
+				Usage:     "Copy a machine's age identity to it over SSH so it decrypts its secrets",
+				ArgsUsage: "[machine-name]",
+				Action:    e.secretsInstallKeyCommand,
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "timeout",
+						Usage: "Give up after this long",
+						Value: fleet.DefaultTimeout,
+					},
+					&cli.BoolFlag{
+						Name:  "insecure-ignore-host-key",
+						Usage: "Don't check host keys against ~/.ssh/known_hosts",
+					},
+				},
+			},
+			{
+				Name:      "decrypt",
+				Usage:     "Print a machine's secrets from its generated ignition, or decrypt an age file",
+				ArgsUsage: "[machine-name] [file]",
+				Action:    e.secretsDecryptCommand,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "identity",
+						Aliases: []string{"i"},
+						Usage:   "age identity file to decrypt with (default: the machine's, from [secrets] identity_dir)",
+					},
+				},
+			},
+		},
+	}
+}
+
+func (e *env) secretsKeygenCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago secrets keygen [flags] [machine-name]", 1)
+	}
+	m, defaults, err := e.loadSecretsMachine(ctx.Args().Get(0))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	identityPath := agecrypt.IdentityPath(defaults.Secrets.IdentityDir, m.Name)
+	recipientsPath := agecrypt.RecipientsPath(m.Directory())
+	if existing, err := os.ReadFile(identityPath); err == nil {
+		if !ctx.Bool("force") {
+			return exitWithError(fmt.Sprintf("Error: %s already has an identity at %s (use --force to replace it)", m.Name, identityPath), 1)
+		}
+		if old, err := agecrypt.ParseIdentities(existing); err == nil {
+			for _, identity := range old {
+				if _, err := agecrypt.RemoveRecipient(recipientsPath, identity.Recipient().String()); err != nil {
+					return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+				}
+			}
+		}
+	}
+
+	identity, err := agecrypt.GenerateIdentity()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if err := os.MkdirAll(filepath.Dir(identityPath), 0700); err != nil {
+		return exitWithError(fmt.Sprintf("Error creating %s: %v", filepath.Dir(identityPath), err), 1)
+	}
+	content := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), identity.Recipient(), identity)
+	if err := os.WriteFile(identityPath, []byte(content), 0600); err != nil {
+		return exitWithError(fmt.Sprintf("Error writing identity: %v", err), 1)
+	}
+	if _, err := agecrypt.AddRecipient(recipientsPath, identity.Recipient().String(), m.Name+" machine key (iago secrets keygen)"); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	fmt.Fprintf(e.stdout, "Wrote %s's identity to %s; keep it out of the repository\n", m.Name, identityPath)
+	fmt.Fprintf(e.stdout, "Added %s to %s\n", identity.Recipient(), recipientsPath)
+	fmt.Fprintf(e.stdout, "\nAfter provisioning, run: iago secrets install-key %s\n", m.Name)
+	return nil
+}
+
+func (e *env) secretsRecipientsCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago secrets recipients [machine-name]", 1)
+	}
+	m, defaults, err := e.loadSecretsMachine(ctx.Args().Get(0))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	recipients, err := agecrypt.MachineRecipients(m.Directory(), defaults.Secrets.Recipients)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if len(recipients) == 0 {
+		fmt.Fprintf(e.stdout, "%s has no recipients; run iago secrets keygen %s\n", m.Name, m.Name)
+		return nil
+	}
+	fleetRecipients := make(map[string]bool)
+	for _, r := range defaults.Secrets.Recipients {
+		fleetRecipients[strings.TrimSpace(r)] = true
+	}
+	for _, r := range recipients {
+		source := agecrypt.RecipientsPath(m.Directory())
+		if fleetRecipients[r.String()] {
+			source = "[secrets] recipients"
+		}
+		fmt.Fprintf(e.stdout, "%s  (%s)\n", r, source)
+	}
+	if defaults.Secrets.Encryption != butane.SecretsEncryptionAge {
+		fmt.Fprintf(e.stdout, "\nNote: [secrets] encryption isn't \"%s\", so %s's secrets are not encrypted\n", butane.SecretsEncryptionAge, m.Name)
+	}
+	return nil
+}
+
+func (e *env) secretsAddRecipientCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		return exitWithError("Error: requires a machine name and an age recipient. Usage: iago secrets add-recipient [flags] [machine-name] [age1...]", 1)
+	}
+	m, _, err := e.loadSecretsMachine(ctx.Args().Get(0))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	added, err := agecrypt.AddRecipient(agecrypt.RecipientsPath(m.Directory()), ctx.Args().Get(1), ctx.String("comment"))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if !added {
+		fmt.Fprintf(e.stdout, "%s is already a recipient of %s's secrets\n", ctx.Args().Get(1), m.Name)
+		return nil
+	}
+	fmt.Fprintf(e.stdout, "Added %s to %s; run iago ignite %s to re-encrypt\n", ctx.Args().Get(1), agecrypt.RecipientsPath(m.Directory()), m.Name)
+	return nil
+}
+
+func (e *env) secretsRemoveRecipientCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		return exitWithError("Error: requires a machine name and an age recipient. Usage: iago secrets remove-recipient [machine-name] [age1...]", 1)
+	}
+	m, _, err := e.loadSecretsMachine(ctx.Args().Get(0))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	removed, err := agecrypt.RemoveRecipient(agecrypt.RecipientsPath(m.Directory()), ctx.Args().Get(1))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if !removed {
+		return exitWithError(fmt.Sprintf("Error: %s is not listed in %s", ctx.Args().Get(1), agecrypt.RecipientsPath(m.Directory())), 1)
+	}
+	fmt.Fprintf(e.stdout, "Removed %s from %s; run iago ignite %s to re-encrypt. Secrets it could already decrypt should be rotated.\n", ctx.Args().Get(1), agecrypt.RecipientsPath(m.Directory()), m.Name)
+	return nil
+}
+
+func (e *env) secretsInstallKeyCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago secrets install-key [flags] [machine-name]", 1)
+	}
+	m, defaults, err := e.loadSecretsMachine(ctx.Args().Get(0))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	host := fleet.Host(m)
+	if host == "" {
+		return exitWithError(fmt.Sprintf("Error: %s has no fqdn or ip_address", m.Name), 1)
+	}
+
+	identityPath := agecrypt.IdentityPath(defaults.Secrets.IdentityDir, m.Name)
+	identity, err := os.ReadFile(identityPath)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error reading %s's identity (run iago secrets keygen %s first): %v", m.Name, m.Name, err), 1)
+	}
+	if _, err := agecrypt.ParseIdentities(identity); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %s: %v", identityPath, err), 1)
+	}
+
+	client, err := fleet.NewClient(fleet.ClientOptions{
+		User:                  defaults.User.Username,
+		GitHubUsername:        defaults.User.GitHubUsername,
+		Timeout:               ctx.Duration("timeout"),
+		InsecureIgnoreHostKey: ctx.Bool("insecure-ignore-host-key"),
+	})
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if err := fleet.InstallFile(ctx.Context, client, m.Name, host, agecrypt.HostIdentityPath, identity, 0600); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "Installed %s's identity at %s:%s; iago-decrypt-secrets.path decrypts its secrets now and at every boot\n", m.Name, host, agecrypt.HostIdentityPath)
+	return nil
+}
+
+func (e *env) secretsDecryptCommand(ctx *cli.Context) error {
+	if ctx.NArg() < 1 || ctx.NArg() > 2 {
+		return exitWithError("Error: requires a machine name and optionally an age file. Usage: iago secrets decrypt [flags] [machine-name] [file]", 1)
+	}
+	m, defaults, err := e.loadSecretsMachine(ctx.Args().Get(0))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	identityPath := ctx.String("identity")
+	if identityPath == "" {
+		identityPath = agecrypt.IdentityPath(defaults.Secrets.IdentityDir, m.Name)
+	}
+	identityFile, err := os.ReadFile(identityPath)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error reading identity: %v", err), 1)
+	}
+	identities, err := agecrypt.ParseIdentities(identityFile)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %s: %v", identityPath, err), 1)
+	}
+
+	if ctx.NArg() == 2 {
+		var ciphertext []byte
+		if file := ctx.Args().Get(1); file == "-" {
+			ciphertext, err = io.ReadAll(os.Stdin)
+		} else {
+			ciphertext, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error reading %s: %v", ctx.Args().Get(1), err), 1)
+		}
+		plaintext, err := agecrypt.Decrypt(ciphertext, identities...)
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		e.stdout.Write(plaintext)
+		return nil
+	}
+
+	ignitionFile := e.ignitionFile(m.Name)
+	ignitionJSON, err := os.ReadFile(ignitionFile)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error reading %s (run iago ignite %s first): %v", ignitionFile, m.Name, err), 1)
+	}
+	files, err := fleet.IgnitionFiles(ignitionJSON, butane.SecretsDir)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %s: %v", ignitionFile, err), 1)
+	}
+	var paths []string
+	for p := range files {
+		if strings.HasSuffix(p, ".age") {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		fmt.Fprintf(e.stdout, "%s has no encrypted secrets\n", ignitionFile)
+		return nil
+	}
+	slices.Sort(paths)
+	for _, p := range paths {
+		plaintext, err := agecrypt.Decrypt(files[p], identities...)
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error decrypting %s: %v", p, err), 1)
+		}
+		fmt.Fprintf(e.stdout, "%s: %s\n", strings.TrimSuffix(p, ".age"), strings.TrimRight(string(plaintext), "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/andreweick/iago/internal/build"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/urfave/cli/v2"
+)
+
+// env is what commands are built with: where they write and how they load
+// the repository. main uses the process's own; tests inject theirs to run a
+// command against a temporary repository and read what it printed.
+type env struct {
+	stdout io.Writer
+	stderr io.Writer
+
+	// newLoader returns a loader for the repository, before anything is loaded
+	newLoader func() *machine.ConfigLoader
+	// newBuilder returns an ignition builder with the repository loaded
+	newBuilder func() (*build.Builder, error)
+
+	// environment is the --env whose config/defaults.<environment>.toml
+	// loaders merge over the defaults
+	environment string
+	// ctx is the running command's context, which builders look secrets up with
+	ctx context.Context
+}
+
+// newEnv returns the environment of the iago process: its stdout and stderr
+// and the repository in the working directory
+func newEnv() *env {
+	e := &env{stdout: os.Stdout, stderr: os.Stderr}
+	e.newLoader = func() *machine.ConfigLoader {
+		loader := machine.NewConfigLoader()
+		loader.SetEnvironment(e.environment)
+		return loader
+	}
+	e.newBuilder = func() (*build.Builder, error) {
+		builder, err := build.NewBuilderFor(e.newLoader())
+		if err != nil {
+			return nil, err
+		}
+		builder.SetOutput(e.stdout)
+		if e.ctx != nil {
+			builder.SetContext(e.ctx)
+		}
+		return builder, nil
+	}
+	return e
+}
+
+// useEnvironment selects the --env given to ctx's command, if any. It's the
+// Before of iago and of commands with an --env of their own, whose flag then
+// overrides iago's; theirs reads "" when only iago's --env or IAGO_ENV is set.
+func (e *env) useEnvironment(ctx *cli.Context) error {
+	if ctx.IsSet("env") {
+		e.environment = ctx.String("env")
+	}
+	return nil
+}
+
+// progressToStderr returns a copy of e that prints to stderr, builders
+// included. Commands that write a report use it to keep stdout for the
+// report alone.
+func (e *env) progressToStderr() *env {
+	progress := *e
+	progress.stdout = e.stderr
+	progress.newBuilder = func() (*build.Builder, error) {
+		builder, err := e.newBuilder()
+		if err != nil {
+			return nil, err
+		}
+		builder.SetOutput(e.stderr)
+		return builder, nil
+	}
+	return &progress
+}
+
+// newApp returns the iago CLI with every command built from e
+func newApp(e *env) *cli.App {
+	app := &cli.App{
+		Name:  "iago",
+		Usage: "Fedora CoreOS machine management with bootc containers",
+		Description: `Iago helps you create, manage, and update Fedora CoreOS machines
+   with bootc containers for your homelab and VPS infrastructure.`,
+		Version:   "1.0.0",
+		Writer:    e.stdout,
+		ErrWriter: e.stderr,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "Output format for list, validate and build --all: table, json or yaml",
+				Value: "table",
+			},
+			&cli.StringFlag{
+				Name:    "env",
+				Usage:   "Merge config/defaults.<env>.toml over the defaults, e.g. staging",
+				EnvVars: []string{"IAGO_ENV"},
+			},
+		},
+		Before: func(ctx *cli.Context) error {
+			e.ctx = ctx.Context
+			return e.useEnvironment(ctx)
+		},
+		Commands: []*cli.Command{
+			newInitCommand(e),
+			newAdoptCommand(e),
+			newListCommand(e),
+			newStatusCommand(e),
+			newDeployCommand(e),
+			newRemoveCommand(e),
+			newRenameCommand(e),
+			newCloneCommand(e),
+			newEditCommand(e),
+			newIgniteCommand(e),
+			newDiffCommand(e),
+			newRenderCommand(e),
+			newFreezeCommand(e),
+			newVerifyIgnitionCommand(e),
+			newVerifyOutputsCommand(e),
+			newKeygenCommand(e),
+			newValidateCommand(e),
+			newDoctorCommand(e),
+			newScanSecretsCommand(e),
+			newSecretsCommand(e),
+			newCICommand(e),
+			newConsoleCommand(e),
+			newPXECommand(e),
+			newISOCommand(e),
+			newSysextCommand(e),
+			newIncludeCommand(e),
+			newCACommand(e),
+			newExplainCommand(e),
+			newMigrateCommand(e),
+			newScaffoldCommand(e),
+			newSchemaCommand(e),
+			newSnippetsCommand(e),
+			newHardeningCommand(e),
+			newHistoryCommand(e),
+			newStoreCommand(e),
+			newGCCommand(e),
+			newExportCommand(e),
+			newImportCommand(e),
+		},
+	}
+	app.Commands = append(app.Commands, containerCommands(e)...)
+	app.Commands = append(app.Commands, provisionCommands(e)...)
+	return app
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/hypervisor"
+	"github.com/andreweick/iago/internal/iso"
+	"github.com/andreweick/iago/internal/pxe"
+	"github.com/urfave/cli/v2"
+)
+
+// newConsoleCommand returns iago console
+func newConsoleCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "console",
+		Usage:     "Open a serial or VNC console for a machine through the configured hypervisor",
+		ArgsUsage: "[machine-name]",
+		Action:    e.consoleCommand,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "vnc",
+				Usage: "Open the graphical (VNC) console instead of the serial console",
+			},
+		},
+	}
+}
+
+func (e *env) consoleCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago console [flags] [machine-name]", 1)
+	}
+
+	machineName := ctx.Args().Get(0)
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	if err := loader.LoadMachines(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading machines: %v", err), 1)
+	}
+
+	m, err := loader.GetMachine(machineName)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	defaults, err := loader.DefaultsFor(m)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+
+	backend, err := hypervisor.NewBackend(defaults.Hypervisor)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	consoleType := hypervisor.ConsoleSerial
+	if ctx.Bool("vnc") {
+		consoleType = hypervisor.ConsoleVNC
+	}
+
+	fmt.Fprintf(e.stdout, "Opening %s console for %s via %s...\n", consoleType, machineName, backend.Name())
+	if err := hypervisor.OpenConsole(ctx.Context, backend, m, consoleType); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	return nil
+}
+
+// newISOCommand returns iago iso
+func newISOCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "iso",
+		Usage:     "Write a Fedora CoreOS live ISO with the machine's ignition embedded, for USB installs",
+		ArgsUsage: "[machine-name]",
+		Action:    e.isoCommand,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "dest-device",
+				Usage: "Install to this disk when booted instead of running from RAM (e.g. /dev/sda)",
+			},
+			&cli.StringFlag{
+				Name:  "base-iso",
+				Usage: "Embed into this live ISO instead of downloading the newest one for [updates] stream",
+			},
+			&cli.StringFlag{
+				Name:  "arch",
+				Usage: "Architecture of the downloaded ISO",
+				Value: "x86_64",
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "Directory for <machine>.iso",
+				Value: "output/iso",
+			},
+		},
+	}
+}
+
+func (e *env) isoCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago iso [flags] [machine-name]", 1)
+	}
+
+	machineName := ctx.Args().Get(0)
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	if err := loader.LoadMachines(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading machines: %v", err), 1)
+	}
+
+	m, err := loader.GetMachine(machineName)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	defaults, err := loader.DefaultsFor(m)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+
+	installer, err := iso.NewInstaller(e.stderr)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	builder, err := e.newBuilder()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error creating builder: %v", err), 1)
+	}
+	ignitionFile := e.ignitionFile(machineName)
+	if err := builder.GenerateMachine(machineName, ignitionFile); err != nil {
+		return exitWithError(fmt.Sprintf("Error generating machine: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "  ✓ Ignition file: %s\n", ignitionFile)
+
+	baseISO := ctx.String("base-iso")
+	if baseISO == "" {
+		stream := defaults.Updates.Stream
+		if stream == "" {
+			stream = "stable"
+		}
+		fmt.Fprintf(e.stdout, "Fetching the Fedora CoreOS %s live ISO...\n", stream)
+		if baseISO, err = installer.Download(ctx.Context, stream, ctx.String("arch"), iso.DefaultCacheDir); err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		fmt.Fprintf(e.stdout, "  ✓ Live ISO: %s\n", baseISO)
+	}
+
+	outputFile := filepath.Join(ctx.String("output"), machineName+".iso")
+	if err := installer.Embed(ctx.Context, baseISO, ignitionFile, outputFile, ctx.String("dest-device")); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "  ✓ ISO: %s\n", outputFile)
+
+	if device := ctx.String("dest-device"); device != "" {
+		fmt.Fprintf(e.stdout, "\n💿 Booting %s installs Fedora CoreOS to %s and erases it\n", outputFile, device)
+	} else {
+		fmt.Fprintf(e.stdout, "\n💿 Booting %s runs '%s' from RAM; use --dest-device to install to disk instead\n", outputFile, machineName)
+	}
+	fmt.Fprintf(e.stdout, "Write it to a USB stick with: sudo dd if=%s of=/dev/sdX bs=4M status=progress conv=fsync\n", outputFile)
+	return nil
+}
+
+// newPXECommand returns iago pxe
+func newPXECommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "pxe",
+		Usage:     "Write an iPXE script and kernel arguments that netboot a bare metal machine, optionally serving its ignition",
+		ArgsUsage: "[machine-name]",
+		Action:    e.pxeCommand,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "base-url",
+				Usage: "URL the machine fetches <name>.ign below (overrides [pxe] base_url)",
+			},
+			&cli.StringFlag{
+				Name:  "install-device",
+				Usage: "Install to this disk instead of running from RAM (overrides [pxe] install_device)",
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "Directory for the iPXE script",
+				Value: "output/pxe",
+			},
+			&cli.BoolFlag{
+				Name:  "serve",
+				Usage: "Serve the ignition and iPXE script over HTTP until interrupted",
+			},
+			&cli.StringFlag{
+				Name:  "listen",
+				Usage: "Address to serve on with --serve",
+				Value: ":8080",
+			},
+		},
+	}
+}
+
+func (e *env) pxeCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago pxe [flags] [machine-name]", 1)
+	}
+
+	machineName := ctx.Args().Get(0)
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	if err := loader.LoadMachines(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading machines: %v", err), 1)
+	}
+
+	m, err := loader.GetMachine(machineName)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	defaults, err := loader.DefaultsFor(m)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+
+	cfg := defaults.PXE
+	if ctx.IsSet("install-device") {
+		cfg.InstallDevice = ctx.String("install-device")
+	}
+	baseURL := cfg.BaseURL
+	if ctx.IsSet("base-url") {
+		baseURL = ctx.String("base-url")
+	}
+	if baseURL == "" && ctx.Bool("serve") {
+		// Only a concrete listen address tells us what the machine can reach
+		if host, port, err := net.SplitHostPort(ctx.String("listen")); err == nil && host != "" && !net.ParseIP(host).IsUnspecified() {
+			baseURL = "http://" + net.JoinHostPort(host, port)
+		}
+	}
+
+	boot, err := pxe.NewBoot(m, cfg, defaults.Updates.Stream, pxe.IgnitionURL(baseURL, m))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	builder, err := e.newBuilder()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error creating builder: %v", err), 1)
+	}
+	ignitionFile := e.ignitionFile(machineName)
+	if err := builder.GenerateMachine(machineName, ignitionFile); err != nil {
+		return exitWithError(fmt.Sprintf("Error generating machine: %v", err), 1)
+	}
+
+	script := boot.Script()
+	if err := os.MkdirAll(ctx.String("output"), 0755); err != nil {
+		return exitWithError(fmt.Sprintf("Error creating output directory: %v", err), 1)
+	}
+	scriptFile := filepath.Join(ctx.String("output"), machineName+".ipxe")
+	if err := os.WriteFile(scriptFile, []byte(script), 0644); err != nil {
+		return exitWithError(fmt.Sprintf("Error writing iPXE script: %v", err), 1)
+	}
+
+	fmt.Fprintf(e.stdout, "  ✓ Ignition file: %s\n", ignitionFile)
+	fmt.Fprintf(e.stdout, "  ✓ iPXE script: %s\n", scriptFile)
+	fmt.Fprintf(e.stdout, "\nKernel: %s\nInitramfs: %s\nKernel arguments:\n  %s\n", boot.Kernel, boot.Initramfs, strings.Join(boot.KernelArgs, " "))
+	if !ctx.Bool("serve") {
+		return nil
+	}
+
+	ignition, err := os.ReadFile(ignitionFile)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error reading ignition: %v", err), 1)
+	}
+	server := &http.Server{
+		Addr: ctx.String("listen"),
+		Handler: pxe.Handler(machineName, ignition, []byte(script), func(format string, args ...any) {
+			fmt.Fprintf(e.stdout, "  "+format, args...)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	serveCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt)
+	defer stop()
+	go func() {
+		<-serveCtx.Done()
+		server.Close()
+	}()
+
+	fmt.Fprintf(e.stdout, "\nServing %s.ign and %s.ipxe on %s (Ctrl-C to stop)\n", machineName, machineName, server.Addr)
+	if baseURL != "" {
+		fmt.Fprintf(e.stdout, "Chain-load %s/%s.ipxe from iPXE\n", strings.TrimRight(baseURL, "/"), machineName)
+	}
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return exitWithError(fmt.Sprintf("Error serving: %v", err), 1)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/andreweick/iago/internal/bundle"
+	"github.com/andreweick/iago/internal/ca"
+	"github.com/andreweick/iago/internal/humanize"
+	"github.com/andreweick/iago/internal/store"
+	"github.com/urfave/cli/v2"
+)
+
+// newExportCommand returns iago export
+func newExportCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:  "export",
+		Usage: "Export the workspace for backup or another workstation",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "bundle",
+				Usage:     "Write configs, templates, iago.lock and the store to one archive (default iago-bundle-<time>.tar.gz)",
+				ArgsUsage: "[file]",
+				Action:    e.exportBundleCommand,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "include-secrets",
+						Usage: "Also include the CA key, issued certificate keys, generated output and secrets frozen in the store; keep the bundle somewhere safe",
+					},
+					&cli.BoolFlag{
+						Name:  "images",
+						Usage: "Also include each machine's container image as an OCI layout, pulled from its registry",
+					},
+				},
+			},
+		},
+	}
+}
+
+// newImportCommand returns iago import
+func newImportCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:  "import",
+		Usage: "Restore an exported workspace",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "bundle",
+				Usage:     "Restore a bundle from iago export bundle into the current directory",
+				ArgsUsage: "[file]",
+				Action:    e.importBundleCommand,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "Replace files that already exist",
+					},
+					&cli.BoolFlag{
+						Name:  "push-images",
+						Usage: "Push the bundle's images back to the references they were exported from",
+					},
+				},
+			},
+		},
+	}
+}
+
+func (e *env) exportBundleCommand(ctx *cli.Context) error {
+	if ctx.NArg() > 1 {
+		return exitWithError("Error: takes at most one argument (bundle file). Usage: iago export bundle [flags] [file]", 1)
+	}
+	file := ctx.Args().Get(0)
+	if file == "" {
+		file = fmt.Sprintf("iago-bundle-%s.tar.gz", time.Now().Format("20060102-150405"))
+	}
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	defaults := loader.GetDefaults()
+	opts := bundle.Options{
+		// Rendered ignition and butane in output/ hold machine secrets in plain text
+		SecretPaths:    []string{defaults.CA.KeyPath(), ca.DefaultCertsDir, "output"},
+		IncludeSecrets: ctx.Bool("include-secrets"),
+		Exclude:        []string{file},
+		StorePath:      store.DefaultPath,
+	}
+
+	if ctx.Bool("images") {
+		if err := loader.LoadMachines(); err != nil {
+			return exitWithError(fmt.Sprintf("Error loading machines: %v", err), 1)
+		}
+		seen := make(map[string]bool)
+		var refs []string
+		for _, m := range loader.GetMachines() {
+			if ref := m.ImageReference(); ref != "" && !seen[ref] {
+				seen[ref] = true
+				refs = append(refs, ref)
+			}
+		}
+		sort.Strings(refs)
+
+		dir, err := os.MkdirTemp("", "iago-bundle-images-")
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		defer os.RemoveAll(dir)
+		for _, ref := range refs {
+			fmt.Fprintf(e.stderr, "Saving %s\n", ref)
+		}
+		if opts.Images, err = saveBundleImages(ctx, defaults, refs, dir); err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		opts.ImagesDir = dir
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error creating %s: %v", file, err), 1)
+	}
+	manifest, err := bundle.Export(f, opts)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	size := ""
+	if info, err := os.Stat(file); err == nil {
+		size = fmt.Sprintf(" (%s)", humanize.Bytes(info.Size()))
+	}
+	fmt.Fprintf(e.stdout, "✓ Exported %d file(s) to %s%s\n", manifest.Files, file, size)
+	if manifest.Store {
+		fmt.Fprintf(e.stdout, "  Store records from %s\n", store.DefaultPath)
+	}
+	for _, image := range manifest.Images {
+		fmt.Fprintf(e.stdout, "  Image %s@%s\n", image.Reference, image.Digest)
+	}
+	if manifest.Secrets {
+		fmt.Fprintf(e.stdout, "⚠️  The bundle holds the CA key, generated output and frozen secrets; store it encrypted\n")
+	} else {
+		fmt.Fprintf(e.stdout, "  Secrets are left out; re-run with --include-secrets for a full disaster-recovery copy\n")
+	}
+	return nil
+}
+
+func (e *env) importBundleCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (bundle file). Usage: iago import bundle [flags] [file]", 1)
+	}
+	file := ctx.Args().Get(0)
+
+	f, err := os.Open(file)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error opening %s: %v", file, err), 1)
+	}
+	defer f.Close()
+
+	result, err := bundle.Import(f, bundle.ImportOptions{
+		Dir:       ".",
+		StorePath: store.DefaultPath,
+		Force:     ctx.Bool("force"),
+	})
+	if errors.Is(err, bundle.ErrConflict) {
+		return exitWithError(fmt.Sprintf("Error: %v\nRestore into an empty directory, or pass --force to replace them", err), 1)
+	}
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	manifest := result.Manifest
+	fmt.Fprintf(e.stdout, "✓ Restored %d file(s) from %s (exported %s", result.Files, file, manifest.Created.Local().Format(time.DateTime))
+	if manifest.Host != "" {
+		fmt.Fprintf(e.stdout, " on %s", manifest.Host)
+	}
+	fmt.Fprintln(e.stdout, ")")
+	if manifest.Store {
+		fmt.Fprintf(e.stdout, "  %d store record(s) loaded into %s\n", result.Records, store.DefaultPath)
+	}
+	if !manifest.Secrets {
+		fmt.Fprintf(e.stdout, "  The bundle has no secrets: restore the CA key and frozen secrets separately, or re-run 'iago freeze'\n")
+	}
+	for _, image := range manifest.Images {
+		fmt.Fprintf(e.stdout, "  Image %s saved to %s/%s\n", image.Reference, bundle.DefaultImagesDir, image.Dir)
+	}
+
+	if ctx.Bool("push-images") && len(manifest.Images) > 0 {
+		loader := e.newLoader()
+		if err := loader.LoadDefaults(); err != nil {
+			return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+		}
+		if err := pushBundleImages(ctx, loader.GetDefaults(), manifest.Images, bundle.DefaultImagesDir); err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		for _, image := range manifest.Images {
+			fmt.Fprintf(e.stdout, "✓ Pushed %s\n", image.Reference)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/butane"
+	"github.com/andreweick/iago/internal/ca"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/urfave/cli/v2"
+)
+
+// newCACommand returns iago ca
+func newCACommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:  "ca",
+		Usage: "Manage a private CA for the homelab: machines and registry pulls trust it, and it issues certificates for the registry and services",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "init",
+				Usage:  "Create the CA: its certificate (commit it) and key (keep it off the repository)",
+				Action: e.caInitCommand,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "name",
+						Usage: "Common name of the CA",
+						Value: "iago homelab CA",
+					},
+					&cli.IntFlag{
+						Name:  "days",
+						Usage: "Days the CA is valid for",
+						Value: int(ca.DefaultCAValidity / (24 * time.Hour)),
+					},
+				},
+			},
+			{
+				Name:      "issue",
+				Usage:     "Issue a certificate and key signed by the CA, e.g. for the local registry",
+				ArgsUsage: "[name]",
+				Action:    e.caIssueCommand,
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "dns",
+						Usage: "DNS name the certificate is valid for (repeatable; default the name)",
+					},
+					&cli.StringSliceFlag{
+						Name:  "ip",
+						Usage: "IP address the certificate is valid for (repeatable)",
+					},
+					&cli.IntFlag{
+						Name:  "days",
+						Usage: "Days the certificate is valid for",
+						Value: int(ca.DefaultCertValidity / (24 * time.Hour)),
+					},
+					&cli.BoolFlag{
+						Name:  "client",
+						Usage: "Also allow TLS client authentication, e.g. for a registry's cert_file",
+					},
+					&cli.StringFlag{
+						Name:  "output",
+						Usage: "Directory for <name>.crt (with the CA certificate) and <name>.key",
+						Value: ca.DefaultCertsDir,
+					},
+				},
+			},
+		},
+	}
+}
+
+func (e *env) caInitCommand(ctx *cli.Context) error {
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	cfg := loader.GetDefaults().CA
+	certPath := cfg.Certificate
+	if certPath == "" {
+		certPath = machine.DefaultCACertificate
+	}
+
+	authority, err := ca.Init(certPath, cfg.KeyPath(), ctx.String("name"), time.Duration(ctx.Int("days"))*24*time.Hour)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "  ✓ Certificate: %s (commit it)\n", certPath)
+	fmt.Fprintf(e.stdout, "  ✓ Key: %s (back it up; never commit it)\n", cfg.KeyPath())
+	fmt.Fprintf(e.stdout, "\n%s expires %s. Machines rendered from now on trust it in %s,\n", authority.Certificate.Subject.CommonName, authority.Certificate.NotAfter.Format(time.DateOnly), butane.CATrustPath)
+	fmt.Fprintln(e.stdout, "and iago trusts it for the local registry and [container_registry] url.")
+	fmt.Fprintf(e.stdout, "Trust it on this workstation too, e.g. sudo trust anchor %s\n", certPath)
+	return nil
+}
+
+func (e *env) caIssueCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (certificate name). Usage: iago ca issue [flags] [name]", 1)
+	}
+	name := ctx.Args().First()
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	cfg := loader.GetDefaults().CA
+	certPath := cfg.CertificatePath()
+	if certPath == "" {
+		certPath = machine.DefaultCACertificate
+	}
+	authority, err := ca.Load(certPath, cfg.KeyPath())
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	req := ca.Request{
+		CommonName: name,
+		DNSNames:   ctx.StringSlice("dns"),
+		Validity:   time.Duration(ctx.Int("days")) * 24 * time.Hour,
+		Client:     ctx.Bool("client"),
+	}
+	for _, value := range ctx.StringSlice("ip") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return exitWithError(fmt.Sprintf("Error: invalid --ip %q", value), 1)
+		}
+		req.IPs = append(req.IPs, ip)
+	}
+	if len(req.DNSNames) == 0 && len(req.IPs) == 0 {
+		req.DNSNames = []string{name}
+	}
+
+	certPEM, keyPEM, err := authority.Issue(req)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	crt, key, err := ca.WriteCertificate(ctx.String("output"), name, certPEM, keyPEM)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	var names []string
+	names = append(names, req.DNSNames...)
+	for _, ip := range req.IPs {
+		names = append(names, ip.String())
+	}
+	fmt.Fprintf(e.stdout, "  ✓ Certificate: %s (%s)\n", crt, strings.Join(names, ", "))
+	fmt.Fprintf(e.stdout, "  ✓ Key: %s\n", key)
+	dir, err := filepath.Abs(ctx.String("output"))
+	if err != nil {
+		dir = ctx.String("output")
+	}
+	fmt.Fprintf(e.stdout, "\nServe a registry with it:\n  docker run -d -p 5000:5000 --name registry -v %s:/certs:ro -e REGISTRY_HTTP_TLS_CERTIFICATE=/certs/%s.crt -e REGISTRY_HTTP_TLS_KEY=/certs/%s.key registry:2\n", dir, name, name)
+	return nil
+}
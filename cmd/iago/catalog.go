@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andreweick/iago/internal/hardening"
+	"github.com/andreweick/iago/internal/humanize"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/schema"
+	"github.com/andreweick/iago/internal/snippets"
+	"github.com/andreweick/iago/internal/store"
+	"github.com/urfave/cli/v2"
+)
+
+// newSchemaCommand returns iago schema
+func newSchemaCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "schema",
+		Usage:     "Print the JSON schema for machine.toml or defaults.toml (for editor completion and validation)",
+		ArgsUsage: "[machine|defaults]",
+		Action:    e.schemaCommand,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "output-dir",
+				Aliases: []string{"o"},
+				Usage:   "Write machine.schema.json and defaults.schema.json to this directory instead of stdout",
+			},
+		},
+	}
+}
+
+func (e *env) schemaCommand(ctx *cli.Context) error {
+	if outputDir := ctx.String("output-dir"); outputDir != "" {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return exitWithError(fmt.Sprintf("Error creating output directory: %v", err), 1)
+		}
+		for _, name := range []string{"machine", "defaults"} {
+			content, err := schema.Marshal(name)
+			if err != nil {
+				return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+			}
+			path := filepath.Join(outputDir, name+".schema.json")
+			if err := os.WriteFile(path, content, 0644); err != nil {
+				return exitWithError(fmt.Sprintf("Error writing %s: %v", path, err), 1)
+			}
+			fmt.Fprintf(e.stdout, "✅ Wrote %s\n", path)
+		}
+		return nil
+	}
+
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine or defaults). Usage: iago schema [machine|defaults]", 1)
+	}
+
+	content, err := schema.Marshal(ctx.Args().Get(0))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	fmt.Fprint(e.stdout, string(content))
+	return nil
+}
+
+// newSnippetsCommand returns iago snippets and its subcommands
+func newSnippetsCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:  "snippets",
+		Usage: "Discover curated butane snippets that machines can include via `snippets = [...]`",
+		Subcommands: []*cli.Command{
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Usage:   "List available snippets",
+				Action:  e.snippetsListCommand,
+			},
+			{
+				Name:      "show",
+				Usage:     "Print the butane content of a snippet",
+				ArgsUsage: "[snippet-name]",
+				Action:    e.snippetsShowCommand,
+			},
+		},
+	}
+}
+
+func (e *env) snippetsListCommand(ctx *cli.Context) error {
+	all, err := snippets.List()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	fmt.Fprintf(e.stdout, "%-18s %s\n", "NAME", "DESCRIPTION")
+	fmt.Fprintln(e.stdout, "--------------------------------------------------------------------------------------")
+	for _, snippet := range all {
+		fmt.Fprintf(e.stdout, "%-18s %s\n", snippet.Name, snippet.Description)
+	}
+	fmt.Fprintf(e.stdout, "\nAdd to machine.toml: snippets = [\"%s\"]\n", all[0].Name)
+	return nil
+}
+
+func (e *env) snippetsShowCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (snippet name). Usage: iago snippets show [snippet-name]", 1)
+	}
+
+	snippet, err := snippets.Get(ctx.Args().Get(0))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	fmt.Fprint(e.stdout, snippet.Content)
+	return nil
+}
+
+// newHardeningCommand returns iago hardening and its subcommands
+func newHardeningCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:  "hardening",
+		Usage: "Inspect hardening presets and which controls each machine opts into",
+		Subcommands: []*cli.Command{
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Usage:   "List hardening presets and their controls",
+				Action:  e.hardeningListCommand,
+			},
+			{
+				Name:      "report",
+				Usage:     "Report the hardening controls applied to each machine",
+				ArgsUsage: "[machine-name]",
+				Action:    e.hardeningReportCommand,
+			},
+		},
+	}
+}
+
+func (e *env) hardeningListCommand(ctx *cli.Context) error {
+	controls, err := hardening.Controls()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	fmt.Fprintf(e.stdout, "%-12s %s\n", "PRESET", "DESCRIPTION")
+	fmt.Fprintln(e.stdout, "--------------------------------------------------------------------------------------")
+	for _, preset := range hardening.Presets() {
+		fmt.Fprintf(e.stdout, "%-12s %s\n", preset.Name, preset.Description)
+		fmt.Fprintf(e.stdout, "%-12s controls: %s\n", "", strings.Join(preset.Controls, ", "))
+	}
+
+	fmt.Fprintf(e.stdout, "\n%-22s %-9s %s\n", "CONTROL", "CATEGORY", "TITLE")
+	fmt.Fprintln(e.stdout, "--------------------------------------------------------------------------------------")
+	for _, control := range controls {
+		fmt.Fprintf(e.stdout, "%-22s %-9s %s\n", control.ID, control.Category, control.Title)
+	}
+	fmt.Fprintln(e.stdout, "\nAdd to defaults.toml or machine.toml: [hardening] presets = [\"baseline\"]")
+	return nil
+}
+
+// newStoreCommand returns iago store and its subcommands
+func newStoreCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:  "store",
+		Usage: "Inspect, export and import the workstation store (" + store.DefaultPath + ")",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "info",
+				Usage:  "Show the store's schema version and record counts",
+				Action: e.storeInfoCommand,
+			},
+			{
+				Name:      "export",
+				Usage:     "Write every record as JSON (to stdout without a file)",
+				ArgsUsage: "[file]",
+				Action:    e.storeExportCommand,
+			},
+			{
+				Name:      "import",
+				Usage:     "Load records from an export, replacing records with the same key",
+				ArgsUsage: "[file]",
+				Action:    e.storeImportCommand,
+			},
+		},
+	}
+}
+
+func (e *env) storeInfoCommand(ctx *cli.Context) error {
+	db, err := store.Open(store.DefaultPath)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	defer db.Close()
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	buckets, err := db.Buckets()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	fmt.Fprintf(e.stdout, "Store:          %s\n", store.DefaultPath)
+	if info, err := os.Stat(store.DefaultPath); err == nil {
+		fmt.Fprintf(e.stdout, "Size:           %s\n", humanize.Bytes(info.Size()))
+	}
+	fmt.Fprintf(e.stdout, "Schema version: %d\n", version)
+	for _, bucket := range buckets {
+		count := 0
+		if err := db.ForEach(bucket, func(string, []byte) error { count++; return nil }); err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		fmt.Fprintf(e.stdout, "  %-12s  %d record(s)\n", bucket, count)
+	}
+	return nil
+}
+
+func (e *env) storeExportCommand(ctx *cli.Context) error {
+	db, err := store.Open(store.DefaultPath)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	defer db.Close()
+
+	if ctx.NArg() == 0 {
+		if err := store.Export(db, e.stdout); err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		return nil
+	}
+
+	file := ctx.Args().Get(0)
+	f, err := os.Create(file)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error creating %s: %v", file, err), 1)
+	}
+	defer f.Close()
+	if err := store.Export(db, f); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "✓ Exported %s to %s\n", store.DefaultPath, file)
+	return nil
+}
+
+func (e *env) storeImportCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (export file). Usage: iago store import [file]", 1)
+	}
+	file := ctx.Args().Get(0)
+
+	f, err := os.Open(file)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error opening %s: %v", file, err), 1)
+	}
+	defer f.Close()
+
+	db, err := store.Open(store.DefaultPath)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	defer db.Close()
+
+	count, err := store.Import(db, f)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "✓ Imported %d record(s) into %s\n", count, store.DefaultPath)
+	return nil
+}
+
+func (e *env) hardeningReportCommand(ctx *cli.Context) error {
+	loader := e.newLoader()
+	if err := loader.LoadAll(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading configuration: %v", err), 1)
+	}
+
+	machines := loader.GetMachines()
+	if ctx.NArg() == 1 {
+		m, err := loader.GetMachine(ctx.Args().Get(0))
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		machines = []machine.Config{m}
+	}
+
+	for i, m := range machines {
+		defaults, err := loader.DefaultsFor(m)
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error loading defaults for %s: %v", m.Name, err), 1)
+		}
+		plan, err := hardening.PlanFor(hardening.Settings(m, defaults))
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %s: %v", m.Name, err), 1)
+		}
+
+		if i > 0 {
+			fmt.Fprintln(e.stdout)
+		}
+		if len(plan.Presets) == 0 {
+			fmt.Fprintf(e.stdout, "%s: no hardening presets\n", m.Name)
+			continue
+		}
+		fmt.Fprintf(e.stdout, "%s (%s)\n", m.Name, strings.Join(plan.Presets, ", "))
+		for _, control := range plan.Controls {
+			fmt.Fprintf(e.stdout, "  ✓ %-22s %-9s %s\n", control.ID, control.Category, control.Title)
+		}
+		for _, control := range plan.Excluded {
+			fmt.Fprintf(e.stdout, "  ✗ %-22s %-9s excluded\n", control.ID, control.Category)
+		}
+	}
+	return nil
+}
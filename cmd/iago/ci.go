@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/andreweick/iago/internal/github"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/policy"
+	"github.com/andreweick/iago/internal/summary"
+	"github.com/urfave/cli/v2"
+)
+
+// newCICommand returns iago ci and its subcommands
+func newCICommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:  "ci",
+		Usage: "Commands for CI pipelines",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "report",
+				Usage:  "Validate the configuration and report the results and the machines a change affects",
+				Action: e.ciReportCommand,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "github-pr",
+						Usage: "Post the report as a comment on the pull request, updating iago's earlier comment",
+					},
+					&cli.BoolFlag{
+						Name:  "check-run",
+						Usage: "Also post the report as a check run on the pull request's head commit (needs checks: write)",
+					},
+					&cli.StringFlag{
+						Name:  "base",
+						Usage: "Git ref the change is compared with (default: origin/$GITHUB_BASE_REF, or origin/main)",
+					},
+					&cli.StringFlag{
+						Name:  "repo",
+						Usage: "GitHub repository as owner/name (default: $GITHUB_REPOSITORY)",
+					},
+					&cli.IntFlag{
+						Name:  "pr",
+						Usage: "Pull request number (default: from the GitHub Actions event)",
+					},
+					&cli.StringFlag{
+						Name:  "token",
+						Usage: "GitHub token (default: GITHUB_TOKEN or 1Password, like the registry token)",
+					},
+					&cli.StringFlag{
+						Name:  "summary-file",
+						Usage: "Append the report to this file (e.g. $GITHUB_STEP_SUMMARY)",
+					},
+				},
+			},
+		},
+	}
+}
+
+func (e *env) ciReportCommand(ctx *cli.Context) error {
+	// Validation problems are printed to stderr; stdout is the report
+	out := e.stdout
+	e = e.progressToStderr()
+
+	loader := e.newLoader()
+	var report *summary.Summary
+	var machines []machine.Config
+	if err := loader.LoadAll(); err != nil {
+		report = summary.New("iago validate")
+		report.Add("configuration", summary.StatusFailed, err.Error(), "")
+	} else {
+		report = e.runValidation(loader)
+		machines = loader.GetMachines()
+	}
+
+	base := ctx.String("base")
+	if base == "" {
+		base = "origin/main"
+		if ref := os.Getenv("GITHUB_BASE_REF"); ref != "" {
+			base = "origin/" + ref
+		}
+	}
+	var changes string
+	files, err := changedFiles(base)
+	if err != nil {
+		fmt.Fprintf(e.stderr, "Warning: %v\n", err)
+		changes = fmt.Sprintf("Couldn't compare with `%s`: %v\n", base, err)
+	} else {
+		changes = affectedMachinesMarkdown(base, affectedMachines(files, machines))
+	}
+	body := report.Markdown() + changes
+	fmt.Fprint(out, body)
+
+	if path := ctx.String("summary-file"); path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: failed to open summary file %s: %v", path, err), 1)
+		}
+		_, err = f.WriteString(body + "\n")
+		f.Close()
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: failed to write summary file %s: %v", path, err), 1)
+		}
+	}
+
+	if ctx.Bool("github-pr") {
+		pr, err := github.PullRequestFromEnv()
+		if ctx.IsSet("repo") {
+			pr.Repo = ctx.String("repo")
+		}
+		if ctx.IsSet("pr") {
+			pr.Number = ctx.Int("pr")
+		}
+		if pr.Repo == "" || pr.Number == 0 {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+
+		token, err := githubToken(ctx, loader.GetDefaults())
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: no GitHub token: %v", err), 1)
+		}
+
+		url, err := github.CommentOnPullRequest(ctx.Context, token, pr, body)
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		fmt.Fprintf(e.stderr, "Posted report to %s\n", url)
+
+		if ctx.Bool("check-run") {
+			title := "Configuration is valid"
+			if report.Failed() {
+				title = "Configuration validation failed"
+			}
+			url, err := github.CreateCheckRun(ctx.Context, token, pr, github.CheckRun{
+				Name:    "iago validate",
+				Success: !report.Failed(),
+				Title:   title,
+				Summary: body,
+			})
+			if err != nil {
+				return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+			}
+			fmt.Fprintf(e.stderr, "Created check run %s\n", url)
+		}
+	}
+
+	if report.Failed() {
+		return exitWithError("Configuration validation failed", 1)
+	}
+	return nil
+}
+
+// changedFiles lists the files changed since the change branched from base
+func changedFiles(base string) ([]string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "diff", "--name-only", base+"...HEAD")
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff against %s failed: %s", base, strings.TrimSpace(stderr.String()))
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// affectedMachines maps each machine a change touches to the changed files
+// that affect it: its own machines/ and containers/ directories, its site and
+// role overlays, and the configuration every machine shares
+func affectedMachines(files []string, machines []machine.Config) map[string][]string {
+	affected := make(map[string][]string)
+	for _, file := range files {
+		dir, rest, _ := strings.Cut(file, "/")
+		first, _, _ := strings.Cut(rest, "/")
+		for _, m := range machines {
+			var match bool
+			switch {
+			case dir == "machines" || (dir == "containers" && first != "_shared"):
+				match = first == m.Name
+			case strings.HasPrefix(file, machine.SitesDir+"/"):
+				match = filepath.Base(file) == m.Site+".toml"
+			case strings.HasPrefix(file, machine.RolesDir+"/"):
+				match = slices.Contains(m.Tags, strings.TrimSuffix(filepath.Base(file), ".toml"))
+			case dir == "config" || dir == "containers" || dir == policy.DefaultDir:
+				match = true
+			}
+			if match {
+				affected[m.Name] = append(affected[m.Name], file)
+			}
+		}
+	}
+	return affected
+}
+
+// affectedMachinesMarkdown renders affectedMachines as a table for the report
+func affectedMachinesMarkdown(base string, affected map[string][]string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### Machines affected (compared with `%s`)\n\n", base)
+	if len(affected) == 0 {
+		b.WriteString("No machine configuration changed.\n\n")
+		return b.String()
+	}
+
+	names := make([]string, 0, len(affected))
+	for name := range affected {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	b.WriteString("| Machine | Changed files |\n|---|---|\n")
+	for _, name := range names {
+		files := affected[name]
+		shown := files
+		if len(shown) > 5 {
+			shown = shown[:5]
+		}
+		list := "`" + strings.Join(shown, "`, `") + "`"
+		if len(files) > len(shown) {
+			list += fmt.Sprintf(" and %d more", len(files)-len(shown))
+		}
+		fmt.Fprintf(&b, "| %s | %s |\n", name, list)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAffectedMachines(t *testing.T) {
+	machines := []machine.Config{
+		{Name: "web", Site: "home", Tags: []string{"edge"}},
+		{Name: "db", Site: "hetzner"},
+	}
+	affected := affectedMachines([]string{
+		"machines/web/machine.toml",
+		"containers/db/Containerfile",
+		"config/sites/hetzner.toml",
+		"config/roles/edge.toml",
+		"README.md",
+	}, machines)
+	assert.Equal(t, map[string][]string{
+		"web": {"machines/web/machine.toml", "config/roles/edge.toml"},
+		"db":  {"containers/db/Containerfile", "config/sites/hetzner.toml"},
+	}, affected)
+
+	affected = affectedMachines([]string{"config/defaults.toml", "containers/_shared/motd"}, machines)
+	assert.Len(t, affected["web"], 2, "shared configuration affects every machine")
+	assert.Len(t, affected["db"], 2)
+
+	markdown := affectedMachinesMarkdown("origin/main", affected)
+	assert.Contains(t, markdown, "| db | `config/defaults.toml`, `containers/_shared/motd` |")
+	assert.Contains(t, affectedMachinesMarkdown("origin/main", nil), "No machine configuration changed")
+}
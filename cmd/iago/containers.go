@@ -0,0 +1,1483 @@
+//go:build !lite
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andreweick/iago/internal/auth"
+	"github.com/andreweick/iago/internal/build"
+	"github.com/andreweick/iago/internal/bundle"
+	"github.com/andreweick/iago/internal/container"
+	"github.com/andreweick/iago/internal/humanize"
+	"github.com/andreweick/iago/internal/hypervisor"
+	"github.com/andreweick/iago/internal/lock"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/pipeline"
+	"github.com/andreweick/iago/internal/sbom"
+	"github.com/andreweick/iago/internal/state"
+	"github.com/andreweick/iago/internal/store"
+	"github.com/andreweick/iago/internal/summary"
+	"github.com/urfave/cli/v2"
+)
+
+// containerCommands are the commands that build, push and pull images. They
+// pull in the registry client and secret providers, so iago-lite leaves them out.
+func containerCommands(e *env) []*cli.Command {
+	return []*cli.Command{
+		{
+			Name:  "auth",
+			Usage: "Check and manage registry authentication",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "test",
+					Usage:  "Resolve push credentials and check they can push, without building anything",
+					Action: e.authTestCommand,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "token",
+							Usage: "Registry token/password for authentication (takes precedence over env vars and 1Password)",
+						},
+						&cli.BoolFlag{
+							Name:  "local",
+							Usage: "Test against the local registry (localhost:5000)",
+						},
+						&cli.StringFlag{
+							Name:  "workload",
+							Usage: "Repository to test push permission on",
+							Value: "iago-auth-test",
+						},
+					},
+				},
+				{
+					Name:   "clear-cache",
+					Usage:  "Remove secrets cached on disk by " + auth.CacheTTLEnv,
+					Action: e.authClearCacheCommand,
+				},
+			},
+		},
+		{
+			Name:      "up",
+			Usage:     "Build, ignite, deploy and verify a machine in one step",
+			ArgsUsage: "[machine-name]",
+			Action:    e.upCommand,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "from",
+					Usage: "Resume the pipeline at this step (build, ignite, deploy, wait, status)",
+				},
+				&cli.BoolFlag{
+					Name:  "force-build",
+					Usage: "Rebuild and push the container even if it hasn't changed",
+				},
+				&cli.BoolFlag{
+					Name:  "sign",
+					Usage: "Sign the container with cosign when it is rebuilt",
+				},
+				&cli.DurationFlag{
+					Name:  "timeout",
+					Value: 10 * time.Minute,
+					Usage: "How long to wait for the machine to accept SSH connections",
+				},
+				&cli.StringFlag{
+					Name:  "token",
+					Usage: "Registry token/password for authentication (takes precedence over env vars and 1Password)",
+				},
+				&cli.BoolFlag{
+					Name:  "i-know-what-im-doing",
+					Usage: "Allow the action on a protected machine without a second approver",
+				},
+				&cli.StringFlag{
+					Name:  "approved-by",
+					Usage: "Name of the second person approving the action on a protected machine",
+				},
+			},
+		},
+		{
+			Name:      "build",
+			Usage:     e.getContainerBuildHelpText(),
+			ArgsUsage: "[workload-name]",
+			Before:    e.useEnvironment,
+			Action:    e.containerBuildCommand,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "local",
+					Aliases: []string{"l"},
+					Usage:   "Push to local registry (localhost:5000)",
+				},
+				&cli.BoolFlag{
+					Name:  "no-push",
+					Usage: "Build in memory only for testing, don't push to registry (image is discarded after build)",
+				},
+				&cli.BoolFlag{
+					Name:  "sign",
+					Usage: "Sign container with cosign (supports both key-based and keyless signing)",
+				},
+				&cli.StringFlag{
+					Name:  "cosign-key",
+					Usage: "Path to cosign private key for key-based signing (defaults to ~/.config/sigstore/cosign.key)",
+				},
+				&cli.StringFlag{
+					Name:  "tag",
+					Usage: "Override default tag",
+					Value: "latest",
+				},
+				&cli.BoolFlag{
+					Name:  "all",
+					Usage: "Build all workloads",
+				},
+				&cli.StringFlag{
+					Name:  "summary-file",
+					Usage: "With --all, append a markdown summary of the results to this file (e.g. $GITHUB_STEP_SUMMARY)",
+				},
+				&cli.IntFlag{
+					Name:  "parallel",
+					Usage: "With --all, build this many workloads at once; their output is prefixed with the workload name",
+					Value: 1,
+				},
+				&cli.BoolFlag{
+					Name:  "fail-fast",
+					Usage: "With --all, start no more workloads once one fails; the rest are reported as skipped",
+				},
+				&cli.StringFlag{
+					Name:  "env",
+					Usage: "Merge config/defaults.<env>.toml over the defaults, e.g. to push to a staging registry",
+				},
+				&cli.IntFlag{
+					Name:  "push-concurrency",
+					Usage: "Concurrent blob uploads when pushing (overrides [container_registry.performance])",
+				},
+				&cli.StringFlag{
+					Name:  "limit-rate",
+					Usage: "Cap registry transfers at this many bytes per second, e.g. 500K or 2M (overrides [container_registry.performance])",
+				},
+				&cli.StringFlag{
+					Name:  "backend",
+					Usage: "Build tool: auto, podman, buildah, docker or simple (overrides [container_registry.build])",
+				},
+				&cli.StringSliceFlag{
+					Name:    "platforms",
+					Aliases: []string{"arch"},
+					Usage:   "Platforms to build, e.g. linux/amd64,linux/arm64 or amd64,arm64; more than one pushes a manifest list (overrides [container_registry.build])",
+				},
+				&cli.StringFlag{
+					Name:  "compression",
+					Usage: "Layer compression: gzip or zstd (overrides [container_registry.build])",
+				},
+				&cli.BoolFlag{
+					Name:  "estargz",
+					Usage: "Push eStargz layers for lazy pulling (overrides [container_registry.build])",
+				},
+				&cli.StringFlag{
+					Name:  "sbom",
+					Usage: "Write an SBOM of the built image: cyclonedx or spdx",
+				},
+				&cli.StringFlag{
+					Name:  "sbom-output",
+					Usage: "Directory the SBOM is written to",
+					Value: container.DefaultSBOMDir,
+				},
+				&cli.BoolFlag{
+					Name:  "sbom-attach",
+					Usage: "Also push the SBOM to the registry as a referrer of the image (CycloneDX unless --sbom is given)",
+				},
+				&cli.StringFlag{
+					Name:  "token",
+					Usage: "Registry token/password for authentication (takes precedence over env vars and 1Password)",
+				},
+			},
+		},
+		{
+			Name:      "push",
+			Usage:     "Push and sign an image built outside iago, e.g. with podman, as a workload's image",
+			ArgsUsage: "[workload-name]",
+			Before:    e.useEnvironment,
+			Action:    e.pushCommand,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "from",
+					Usage:    "Image to push: docker-daemon:<image>, containers-storage:<image>, docker-archive:<path>, oci-archive:<path> or oci:<dir>",
+					Required: true,
+				},
+				&cli.BoolFlag{
+					Name:    "local",
+					Aliases: []string{"l"},
+					Usage:   "Push to local registry (localhost:5000)",
+				},
+				&cli.BoolFlag{
+					Name:  "sign",
+					Usage: "Sign container with cosign (supports both key-based and keyless signing)",
+				},
+				&cli.StringFlag{
+					Name:  "cosign-key",
+					Usage: "Path to cosign private key for key-based signing (defaults to ~/.config/sigstore/cosign.key)",
+				},
+				&cli.StringFlag{
+					Name:  "tag",
+					Usage: "Override default tag",
+					Value: "latest",
+				},
+				&cli.StringFlag{
+					Name:  "env",
+					Usage: "Merge config/defaults.<env>.toml over the defaults, e.g. to push to a staging registry",
+				},
+				&cli.IntFlag{
+					Name:  "push-concurrency",
+					Usage: "Concurrent blob uploads when pushing (overrides [container_registry.performance])",
+				},
+				&cli.StringFlag{
+					Name:  "limit-rate",
+					Usage: "Cap registry transfers at this many bytes per second, e.g. 500K or 2M (overrides [container_registry.performance])",
+				},
+				&cli.StringFlag{
+					Name:  "token",
+					Usage: "Registry token/password for authentication (takes precedence over env vars and 1Password)",
+				},
+			},
+		},
+		{
+			Name:      "verify",
+			Usage:     "Check a workload image's cosign signature before deploying it",
+			ArgsUsage: "[workload-name|image-ref]",
+			Action:    e.verifyCommand,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "key",
+					Usage: "Cosign public key (defaults to [signing] container_public_key, then ~/.config/sigstore/cosign.pub)",
+				},
+				&cli.StringFlag{
+					Name:  "tag",
+					Usage: "Tag to verify when given a workload name",
+					Value: "latest",
+				},
+				&cli.BoolFlag{
+					Name:    "local",
+					Aliases: []string{"l"},
+					Usage:   "Verify the image in the local registry (localhost:5000)",
+				},
+			},
+		},
+		{
+			Name:  "image",
+			Usage: "Inspect pushed workload images",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "inspect",
+					Usage:     "Show the last pushed image of a workload and its build provenance",
+					ArgsUsage: "[workload-name]",
+					Action:    e.imageInspectCommand,
+				},
+				{
+					Name:      "consumers",
+					Usage:     "Show which machines run an image: its workload, from the annotation iago stamps when pushing, and the machines in this repository that run it",
+					ArgsUsage: "[workload-name|image-ref]",
+					Action:    e.imageConsumersCommand,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "tag",
+							Usage: "Tag to look up when given a workload name",
+							Value: "latest",
+						},
+						&cli.BoolFlag{
+							Name:    "local",
+							Aliases: []string{"l"},
+							Usage:   "Look up the image in the local registry (localhost:5000)",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "registry",
+			Usage: "Manage the registry holding workload images",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "mirror",
+					Usage:     "Copy the fleet's workload images, with their signatures and SBOMs, to a backup registry",
+					ArgsUsage: "[machine-name...]",
+					Action:    e.registryMirrorCommand,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "to",
+							Usage:    "Backup registry, optionally with a path prefix (e.g. backup.lan:5000 or quay.io/me-dr)",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:  "site",
+							Usage: "Only mirror images of machines in this site",
+						},
+						&cli.StringFlag{
+							Name:  "group",
+							Usage: "Only mirror images of machines in this group",
+						},
+						&cli.StringSliceFlag{
+							Name:  "tag",
+							Usage: "Only mirror images of machines with this tag (repeat to require several)",
+						},
+						&cli.BoolFlag{
+							Name:    "dry-run",
+							Aliases: []string{"n"},
+							Usage:   "List what would be copied without copying",
+						},
+						&cli.StringFlag{
+							Name:  "to-username",
+							Usage: "Username for the backup registry (defaults to docker/podman login credentials)",
+						},
+						&cli.StringFlag{
+							Name:  "to-token",
+							Usage: "Token or password for the backup registry",
+						},
+						&cli.StringFlag{
+							Name:  "limit-rate",
+							Usage: "Cap transfers at this many bytes per second, e.g. 500K or 2M (overrides [container_registry.performance])",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// getContainerBuildHelpText returns formatted help text with current registry info
+func (e *env) getContainerBuildHelpText() string {
+	baseHelp := `Build and push container for workload.
+
+Format: {registry}/{workload-name}:{tag}
+Example: ghcr.io/andreweick/my-app:latest
+
+Flags:
+  --all              Build all workloads
+  --local, -l        Push to local registry (localhost:5000)
+  --no-push          Build in memory only for testing, don't push to registry
+  --sign             Sign container with cosign after building
+  --tag value        Override default tag (default: "latest")
+  --token value      Registry token/password for authentication`
+
+	// Try to load current registry from defaults.toml
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err == nil {
+		defaults := loader.GetDefaults()
+		if defaults.ContainerRegistry.URL != "" {
+			baseHelp += fmt.Sprintf("\n\nWith the current config as set in defaults.toml, the registry is: %s", defaults.ContainerRegistry.URL)
+		}
+	}
+
+	return baseHelp
+}
+
+func (e *env) authTestCommand(ctx *cli.Context) error {
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+
+	defaults := loader.GetDefaults()
+	registryURL := defaults.ContainerRegistry.URL
+	if ctx.Bool("local") {
+		registryURL = "localhost:5000"
+	}
+	fmt.Fprintf(e.stdout, "Registry:  %s\n", registryURL)
+
+	provider, err := auth.NewSecretProvider(defaults)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("❌ %v", err), 1)
+	}
+	authCfg, err := auth.GetRegistryAuthConfig(ctx.Context, defaults.ContainerRegistry, registryURL, provider, "", ctx.String("token"))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("❌ %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "Source:    %s\n", authCfg.Source)
+	if authCfg.Username != "" {
+		fmt.Fprintf(e.stdout, "Username:  %s\n", authCfg.Username)
+	}
+	fmt.Fprintf(e.stdout, "Token:     %s\n", auth.MaskToken(authCfg.Secret()))
+
+	warnings, err := auth.CheckPushToken(ctx.Context, registryURL, authCfg)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("❌ %v", err), 1)
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(e.stdout, "⚠️  %s\n", warning)
+	}
+
+	repository := fmt.Sprintf("%s/%s", registryURL, ctx.String("workload"))
+	registries := container.RegistryTransports(defaults.RegistryTLSConfigs())
+	if err := container.CheckPushPermission(repository, authCfg.ToContainerAuthConfig(), registries); err != nil {
+		return exitWithError(fmt.Sprintf("❌ %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "✅ Can push to %s\n", repository)
+	return nil
+}
+
+func (e *env) authClearCacheCommand(ctx *cli.Context) error {
+	if err := auth.ClearDiskCache(); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	fmt.Fprintln(e.stdout, "✅ Cleared cached registry credentials")
+	return nil
+}
+
+func (e *env) upCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago up [flags] [machine-name]", 1)
+	}
+
+	machineName := ctx.Args().Get(0)
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	if err := loader.LoadMachines(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading machines: %v", err), 1)
+	}
+
+	m, err := loader.GetMachine(machineName)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if err := guardProtected(ctx, m, "up"); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	defaults, err := loader.DefaultsFor(m)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+
+	st, err := e.loadState()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error loading state: %v", err), 1)
+	}
+
+	outputFile := e.ignitionFile(machineName)
+	tag := m.ContainerTag
+	if tag == "" {
+		tag = "latest"
+	}
+
+	p := &pipeline.Pipeline{
+		Out: e.stdout,
+		OnStepComplete: func(step string) {
+			st.RecordStep(machineName, step)
+			if err := e.saveState(st); err != nil {
+				fmt.Fprintf(e.stdout, "Warning: could not save state: %v\n", err)
+			}
+		},
+		Steps: []pipeline.Step{
+			{
+				Name:        "build",
+				Description: "Build and push container (if changed)",
+				Run: func(runCtx context.Context) error {
+					contextPath := defaults.ContainerDir(machineName)
+					if _, err := os.Stat(contextPath); os.IsNotExist(err) {
+						fmt.Fprintf(e.stdout, "      no container directory at %s, skipping\n", contextPath)
+						return nil
+					}
+
+					digest, err := container.ContextDigest(contextPath)
+					if err != nil {
+						return err
+					}
+					if previous, ok := st.Workloads[machineName]; ok && previous.ContextDigest == digest && !ctx.Bool("force-build") {
+						fmt.Fprintf(e.stdout, "      container unchanged since last push (%s), skipping\n", previous.ImageRef)
+						return nil
+					}
+
+					buildOptions, err := workloadBuildOptions(ctx, e.stdout, machineName, defaults, false, false, ctx.Bool("sign"), "", tag, "", ctx.String("token"))
+					if err != nil {
+						return fmt.Errorf("authentication error: %w", err)
+					}
+					builder := container.NewBuilder(buildOptions)
+					startedAt := time.Now()
+					if err := builder.BuildAndPush(runCtx); err != nil {
+						return err
+					}
+
+					st.RecordPush(machineName, digest, builder.ImageRef(), buildProvenance(ctx, contextPath, "", builder, startedAt))
+					return e.saveState(st)
+				},
+			},
+			{
+				Name:        "ignite",
+				Description: "Generate ignition file",
+				Run: func(runCtx context.Context) error {
+					builder, err := e.newBuilder()
+					if err != nil {
+						return err
+					}
+					if err := builder.GenerateMachineWithOptions(machineName, outputFile, true); err != nil {
+						return err
+					}
+					fmt.Fprintf(e.stdout, "      wrote %s\n", outputFile)
+					return nil
+				},
+			},
+			{
+				Name:        "deploy",
+				Description: "Upload ignition and (re)start the VM",
+				Run: func(runCtx context.Context) error {
+					if err := build.VerifySignedChecksums(filepath.Dir(outputFile), defaults.Signing.IgnitionPublicKey); err != nil {
+						return err
+					}
+					if err := build.VerifyChecksum(outputFile); err != nil {
+						return err
+					}
+					backend, err := hypervisor.NewBackend(defaults.Hypervisor)
+					if err != nil {
+						return err
+					}
+					commands, err := backend.DeployCommands(m, outputFile)
+					if err != nil {
+						return err
+					}
+					return hypervisor.RunCommands(runCtx, commands, e.stdout, e.stderr)
+				},
+			},
+			{
+				Name:        "wait",
+				Description: fmt.Sprintf("Wait for SSH on %s", m.FQDN),
+				Run: func(runCtx context.Context) error {
+					waitCtx, cancel := context.WithTimeout(runCtx, ctx.Duration("timeout"))
+					defer cancel()
+					return pipeline.WaitForTCP(waitCtx, net.JoinHostPort(m.FQDN, "22"), 5*time.Second)
+				},
+			},
+			{
+				Name:        "status",
+				Description: "Report VM status",
+				Run: func(runCtx context.Context) error {
+					backend, err := hypervisor.NewBackend(defaults.Hypervisor)
+					if err != nil {
+						return err
+					}
+					args, err := backend.StatusCommand(m)
+					if err != nil {
+						return err
+					}
+					return hypervisor.RunCommands(runCtx, []hypervisor.Command{{Args: args}}, e.stdout, e.stderr)
+				},
+			},
+		},
+	}
+
+	fmt.Fprintf(e.stdout, "Bringing up %s\n", machineName)
+	if err := p.Run(ctx.Context, ctx.String("from")); err != nil {
+		var stepErr *pipeline.StepError
+		if errors.As(err, &stepErr) {
+			return exitWithError(fmt.Sprintf("Error: %v\nResume with: iago up --from %s %s", err, stepErr.Step, machineName), 1)
+		}
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	fmt.Fprintf(e.stdout, "\n🚀 Machine '%s' is up\n", machineName)
+	return nil
+}
+
+func (e *env) containerBuildCommand(ctx *cli.Context) error {
+	buildAll := ctx.Bool("all")
+	local := ctx.Bool("local")
+	noPush := ctx.Bool("no-push")
+	sign := ctx.Bool("sign")
+	cosignKey := ctx.String("cosign-key")
+	tag := ctx.String("tag")
+	token := ctx.String("token")
+
+	// Load defaults to get registry configuration
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	defaults := loader.GetDefaults()
+	if e.environment != "" {
+		fmt.Fprintf(e.stderr, "Environment: %s (%s)\n", e.environment, machine.EnvironmentPath(e.environment))
+	}
+
+	if buildAll {
+		return e.buildAllWorkloads(ctx, defaults, local, noPush, sign, cosignKey, tag, "", token)
+	}
+
+	// Single workload build
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (workload name) or use --all flag. Usage: iago build [flags] [workload-name]", 1)
+	}
+
+	workloadName := ctx.Args().Get(0)
+	return e.buildSingleWorkload(ctx, workloadName, defaults, local, noPush, sign, cosignKey, tag, "", token)
+}
+
+func (e *env) buildSingleWorkload(ctx *cli.Context, workloadName string, defaults machine.Defaults, local, noPush, sign bool, cosignKey, tag, username, token string) error {
+	contextPath := defaults.ContainerDir(workloadName)
+
+	// Check if container directory exists
+	if _, err := os.Stat(contextPath); os.IsNotExist(err) {
+		return exitWithError(fmt.Sprintf("Container directory not found: %s\nTip: Run 'iago init %s' first", contextPath, workloadName), 1)
+	}
+
+	// Validate local registry if needed
+	if local && !noPush {
+		if err := validateLocalRegistry(ctx, defaults); err != nil {
+			return exitWithError(err.Error(), 1)
+		}
+	}
+
+	if err := e.buildWorkload(ctx, e.stdout, workloadName, defaults, local, noPush, sign, cosignKey, tag, username, token); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "\n✅ Container build completed for %s\n", workloadName)
+	return nil
+}
+
+// buildWorkload builds, signs and pushes one workload, writing progress to out
+func (e *env) buildWorkload(ctx *cli.Context, out io.Writer, workloadName string, defaults machine.Defaults, local, noPush, sign bool, cosignKey, tag, username, token string) error {
+	contextPath := defaults.ContainerDir(workloadName)
+	buildOptions, err := workloadBuildOptions(ctx, out, workloadName, defaults, local, noPush, sign, cosignKey, tag, username, token)
+	if err != nil {
+		return fmt.Errorf("authentication error: %w", err)
+	}
+
+	// Create builder and build
+	builder := container.NewBuilder(buildOptions)
+
+	fmt.Fprintf(out, "Building container for workload: %s\n", workloadName)
+	if local {
+		fmt.Fprintf(out, "Target registry: localhost:5000\n")
+	} else {
+		fmt.Fprintf(out, "Target registry: %s\n", defaults.ContainerRegistry.URL)
+	}
+
+	startedAt := time.Now()
+	if err := builder.BuildAndPush(ctx.Context); err != nil {
+		return fmt.Errorf("container build failed: %w", err)
+	}
+
+	// Only pushes to the real registry are recorded; they are what machines run
+	if !local && !noPush {
+		if err := e.recordWorkloadPush(ctx, workloadName, contextPath, "", builder, startedAt); err != nil {
+			fmt.Fprintf(out, "Warning: could not record build provenance: %v\n", err)
+		}
+	}
+	return nil
+}
+
+func (e *env) pushCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (workload name). Usage: iago push --from <image> [flags] [workload-name]", 1)
+	}
+	workloadName := ctx.Args().First()
+	local := ctx.Bool("local")
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	defaults := loader.GetDefaults()
+	if e.environment != "" {
+		fmt.Fprintf(e.stderr, "Environment: %s (%s)\n", e.environment, machine.EnvironmentPath(e.environment))
+	}
+
+	// The workload names the repository, as for iago build
+	contextPath := defaults.ContainerDir(workloadName)
+	if _, err := os.Stat(contextPath); os.IsNotExist(err) {
+		return exitWithError(fmt.Sprintf("Container directory not found: %s\nTip: Run 'iago init %s' first", contextPath, workloadName), 1)
+	}
+	if local {
+		if err := validateLocalRegistry(ctx, defaults); err != nil {
+			return exitWithError(err.Error(), 1)
+		}
+	}
+
+	buildOptions, err := workloadBuildOptions(ctx, e.stdout, workloadName, defaults, local, false, ctx.Bool("sign"), ctx.String("cosign-key"), ctx.String("tag"), "", ctx.String("token"))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: authentication error: %v", err), 1)
+	}
+	builder := container.NewBuilder(buildOptions)
+
+	fmt.Fprintf(e.stdout, "Pushing %s as %s\n", ctx.String("from"), builder.ImageRef())
+	startedAt := time.Now()
+	if err := builder.PushFrom(ctx.Context, ctx.String("from")); err != nil {
+		return exitWithError(fmt.Sprintf("Error: push failed: %v", err), 1)
+	}
+	if !local {
+		if err := e.recordWorkloadPush(ctx, workloadName, contextPath, ctx.String("from"), builder, startedAt); err != nil {
+			fmt.Fprintf(e.stdout, "Warning: could not record push provenance: %v\n", err)
+		}
+	}
+	fmt.Fprintf(e.stdout, "\n✅ Pushed %s\n", builder.ImageRef())
+	return nil
+}
+
+// printBuildSummary lists each workload's outcome once every build has finished
+func printBuildSummary(w io.Writer, report *summary.Summary) {
+	width := 0
+	counts := map[summary.Status]int{}
+	for _, r := range report.Results {
+		width = max(width, len(r.Name))
+		counts[r.Status]++
+	}
+	fmt.Fprintf(w, "\nBuild summary: %d succeeded, %d failed, %d skipped\n", counts[summary.StatusOK], counts[summary.StatusFailed], counts[summary.StatusSkipped])
+	for _, r := range report.Results {
+		icon, detail := "✅", r.Detail
+		switch {
+		case r.Status == summary.StatusFailed:
+			icon = "❌"
+		case r.Status == summary.StatusSkipped:
+			icon = "⏭️"
+		case r.Artifact != "":
+			detail += ", " + r.Artifact
+		}
+		fmt.Fprintf(w, "  %s %-*s  %s\n", icon, width, r.Name, detail)
+	}
+}
+
+// prefixWriter prefixes every line with a name and writes whole lines under a
+// mutex shared with the other writers on out, so parallel output interleaves
+// by line rather than mid-line
+type prefixWriter struct {
+	out    io.Writer
+	mu     *sync.Mutex
+	prefix string
+	buf    []byte
+}
+
+func newPrefixWriter(out io.Writer, mu *sync.Mutex, name string) *prefixWriter {
+	return &prefixWriter{out: out, mu: mu, prefix: "[" + name + "] "}
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		// Progress bars redraw with \r; treat it as a line end too
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			return len(p), nil
+		}
+		if err := w.writeLine(w.buf[:i]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// Flush writes a trailing partial line
+func (w *prefixWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.writeLine(w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *prefixWriter) writeLine(line []byte) error {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := fmt.Fprintf(w.out, "%s%s\n", w.prefix, line)
+	return err
+}
+
+// loadState reads workstation state, holding the store's lock only while reading
+func (e *env) loadState() (*state.State, error) {
+	db, err := store.Open(e.storePath())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return state.Load(db)
+}
+
+// saveState writes workstation state, holding the store's lock only while writing
+func (e *env) saveState(st *state.State) error {
+	db, err := store.Open(e.storePath())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return st.Save(db)
+}
+
+// stateMu serializes read-modify-write updates of workstation state by parallel builds
+var stateMu sync.Mutex
+
+// recordWorkloadPush stores the pushed image and its provenance in workstation
+// state. An image pushed from source was not built from contextPath, so no
+// context digest is recorded for it and the next build of the workload runs.
+func (e *env) recordWorkloadPush(ctx *cli.Context, workloadName, contextPath, source string, builder *container.Builder, startedAt time.Time) error {
+	var digest string
+	if source == "" {
+		var err error
+		if digest, err = container.ContextDigest(contextPath); err != nil {
+			return err
+		}
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	st, err := e.loadState()
+	if err != nil {
+		return err
+	}
+	st.RecordPush(workloadName, digest, builder.ImageRef(), buildProvenance(ctx, contextPath, source, builder, startedAt))
+	return e.saveState(st)
+}
+
+// buildProvenance combines builder output with git, host and flag details, or
+// with the source of an image pushed with --from
+func buildProvenance(ctx *cli.Context, contextPath, source string, builder *container.Builder, startedAt time.Time) *state.Provenance {
+	var flags []string
+	for _, flag := range ctx.Command.Flags {
+		name := flag.Names()[0]
+		if name == "token" || !ctx.IsSet(name) {
+			continue
+		}
+		flags = append(flags, fmt.Sprintf("--%s=%v", name, ctx.Value(name)))
+	}
+
+	var provenance *state.Provenance
+	if source != "" {
+		provenance = state.ExternalProvenance(source, startedAt, flags)
+	} else {
+		provenance = state.NewProvenance(contextPath, startedAt, flags)
+	}
+	info := builder.BuildInfo()
+	provenance.BaseImage = info.BaseImage
+	provenance.BaseImageDigest = info.BaseImageDigest
+	provenance.ImageDigest = info.ImageDigest
+	return provenance
+}
+
+// validateLocalRegistry checks the localhost:5000 registry answers, with the
+// credentials [container_registry.registries] configures for it
+func validateLocalRegistry(ctx *cli.Context, defaults machine.Defaults) error {
+	var authConfig *container.AuthConfig
+	if _, ok := defaults.ContainerRegistry.RegistryAuth("localhost:5000"); ok {
+		provider, err := auth.NewSecretProvider(defaults)
+		if err != nil {
+			return err
+		}
+		authCfg, err := auth.GetRegistryAuthConfig(ctx.Context, defaults.ContainerRegistry, "localhost:5000", provider, "", "")
+		if err != nil {
+			return err
+		}
+		authConfig = authCfg.ToContainerAuthConfig()
+	}
+	return container.ValidateLocalRegistry(ctx.Context, container.RegistryTransports(defaults.RegistryTLSConfigs()), authConfig)
+}
+
+// workloadBuildOptions resolves authentication (only when pushing) and assembles build options
+func workloadBuildOptions(ctx *cli.Context, out io.Writer, workloadName string, defaults machine.Defaults, local, noPush, sign bool, cosignKey, tag, username, token string) (container.BuildOptions, error) {
+	var authConfig *container.AuthConfig
+	if !noPush {
+		registryURL := defaults.ContainerRegistry.URL
+		if local {
+			registryURL = "localhost:5000"
+		}
+		provider, err := auth.NewSecretProvider(defaults)
+		if err != nil {
+			return container.BuildOptions{}, err
+		}
+		authCfg, err := auth.GetRegistryAuthConfig(ctx.Context, defaults.ContainerRegistry, registryURL, provider, username, token)
+		if err != nil {
+			return container.BuildOptions{}, err
+		}
+		authConfig = authCfg.ToContainerAuthConfig()
+		fmt.Fprintf(out, "Using authentication: %s\n", authCfg.Source)
+
+		warnings, err := auth.CheckPushToken(ctx.Context, registryURL, authCfg)
+		if err != nil {
+			return container.BuildOptions{}, err
+		}
+		for _, warning := range warnings {
+			fmt.Fprintf(out, "⚠️  %s\n", warning)
+		}
+	}
+
+	var registryAuth map[string]*container.AuthConfig
+	if len(defaults.ContainerRegistry.Registries) > 0 {
+		provider, err := auth.NewSecretProvider(defaults)
+		if err != nil {
+			return container.BuildOptions{}, err
+		}
+		if registryAuth, err = auth.GetRegistryPullAuth(ctx.Context, defaults.ContainerRegistry, provider); err != nil {
+			return container.BuildOptions{}, err
+		}
+	}
+
+	performance := defaults.ContainerRegistry.Performance
+	if jobs := ctx.Int("push-concurrency"); jobs > 0 {
+		performance.PushConcurrency = jobs
+	}
+	if flag := ctx.String("limit-rate"); flag != "" {
+		performance.LimitRate = flag
+	}
+	limitRate, err := container.ParseRate(performance.LimitRate)
+	if err != nil {
+		return container.BuildOptions{}, err
+	}
+	backend := defaults.ContainerRegistry.Build.Backend
+	if flag := ctx.String("backend"); flag != "" {
+		backend = flag
+	}
+	platforms := defaults.ContainerRegistry.Build.Platforms
+	if flag := ctx.StringSlice("platforms"); len(flag) > 0 {
+		platforms = flag
+	}
+	layers := defaults.ContainerRegistry.Build.Layers(workloadName)
+	if flag := ctx.String("compression"); flag != "" {
+		layers.Compression = flag
+	}
+	if ctx.IsSet("estargz") {
+		layers.Estargz = ctx.Bool("estargz")
+	}
+	sbomFormat := ctx.String("sbom")
+	if sbomFormat == "" && ctx.Bool("sbom-attach") {
+		sbomFormat = sbom.FormatCycloneDX
+	}
+	if sbomFormat != "" {
+		if err := sbom.ValidateFormat(sbomFormat); err != nil {
+			return container.BuildOptions{}, err
+		}
+	}
+
+	return container.BuildOptions{
+		WorkloadName:  workloadName,
+		ContextPath:   defaults.ContainerDir(workloadName),
+		Tag:           tag,
+		RegistryURL:   defaults.ContainerRegistry.URL,
+		Local:         local,
+		NoPush:        noPush,
+		Sign:          sign,
+		CosignKeyPath: cosignKey,
+		AuthConfig:    authConfig,
+		Registries:    container.RegistryTransports(defaults.RegistryTLSConfigs()),
+		Pull:          defaults.ContainerRegistry.Pull,
+		Mirrors:       defaults.ContainerRegistry.Mirrors(),
+		BaseImages:    defaults.ContainerRegistry.Build.BaseImages,
+		PullAuth:      auth.GetPullAuthConfig().ToContainerAuthConfig(),
+		RegistryAuth:  registryAuth,
+		Performance:   performance,
+		Backend:       backend,
+		Platforms:     platforms,
+		Compression:   layers.Compression,
+		Estargz:       layers.Estargz,
+		RateLimit:     container.LimitRate(limitRate),
+		Output:        out,
+		FulcioURL:     defaults.Signing.FulcioURL,
+		RekorURL:      defaults.Signing.RekorURL,
+		Annotations:   container.WorkloadAnnotations(workloadName),
+		SBOM:          sbomFormat,
+		SBOMDir:       ctx.String("sbom-output"),
+		AttachSBOM:    ctx.Bool("sbom-attach"),
+	}, nil
+}
+
+func (e *env) verifyCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (workload name or image reference). Usage: iago verify [flags] [workload-name|image-ref]", 1)
+	}
+	target := ctx.Args().Get(0)
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	defaults := loader.GetDefaults()
+
+	keyPath := ctx.String("key")
+	if keyPath == "" {
+		keyPath = defaults.Signing.ContainerPublicKey
+	}
+	if keyPath == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			keyPath = filepath.Join(home, ".config", "sigstore", "cosign.pub")
+		}
+	}
+
+	// A bare workload name means the image iago build pushes for it
+	imageRef := target
+	if !strings.ContainsAny(target, "/:@") {
+		registryURL := defaults.ContainerRegistry.URL
+		if ctx.Bool("local") {
+			registryURL = "localhost:5000"
+		}
+		imageRef = fmt.Sprintf("%s/%s:%s", registryURL, target, ctx.String("tag"))
+	}
+
+	// Private registries need credentials to read; public ones don't
+	var authConfig *container.AuthConfig
+	registries := container.RegistryTransports(defaults.RegistryTLSConfigs())
+	if ref, err := registries.ParseReference(imageRef); err == nil {
+		provider, _ := auth.NewSecretProvider(defaults)
+		if authCfg, err := auth.GetRegistryAuthConfig(ctx.Context, defaults.ContainerRegistry, ref.Context().RegistryStr(), provider, "", ""); err == nil {
+			authConfig = authCfg.ToContainerAuthConfig()
+		}
+	}
+
+	digest, err := container.VerifyImageRef(ctx.Context, imageRef, keyPath, registries, authConfig)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("❌ Verification failed: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "✅ %s (%s) is signed by %s\n", imageRef, digest, keyPath)
+	return nil
+}
+
+func (e *env) imageConsumersCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (workload name or image reference). Usage: iago image consumers [flags] [workload-name|image-ref]", 1)
+	}
+	target := ctx.Args().Get(0)
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	if err := loader.LoadMachines(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading machines: %v", err), 1)
+	}
+	defaults := loader.GetDefaults()
+
+	// A bare workload name means the image iago build pushes for it
+	imageRef := target
+	if !strings.ContainsAny(target, "/:@") {
+		registryURL := defaults.ContainerRegistry.URL
+		if ctx.Bool("local") {
+			registryURL = "localhost:5000"
+		}
+		imageRef = fmt.Sprintf("%s/%s:%s", registryURL, target, ctx.String("tag"))
+	}
+
+	var authConfig *container.AuthConfig
+	registries := container.RegistryTransports(defaults.RegistryTLSConfigs())
+	if ref, err := registries.ParseReference(imageRef); err == nil {
+		provider, _ := auth.NewSecretProvider(defaults)
+		if authCfg, err := auth.GetRegistryAuthConfig(ctx.Context, defaults.ContainerRegistry, ref.Context().RegistryStr(), provider, "", ""); err == nil {
+			authConfig = authCfg.ToContainerAuthConfig()
+		}
+	}
+
+	metadata, err := container.ReadImageMetadata(ctx.Context, imageRef, registries, authConfig)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "Image:     %s\n", imageRef)
+	fmt.Fprintf(e.stdout, "Digest:    %s\n", metadata.Digest)
+	if metadata.Workload == "" {
+		fmt.Fprintln(e.stdout, "\nNo iago annotations (pushed by another tool or an older iago)")
+		return nil
+	}
+	fmt.Fprintf(e.stdout, "Workload:  %s\n", metadata.Workload)
+
+	// Machines come from the repository; a frozen machine runs the digest it was frozen on
+	consumers := loader.WorkloadConsumers(metadata.Workload)
+	if len(consumers) == 0 {
+		fmt.Fprintf(e.stdout, "\nNo machine in this repository runs %s\n", metadata.Workload)
+		return nil
+	}
+	lockFile, err := lock.Load(lock.DefaultPath)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "\nMachines running %s (%d):\n", metadata.Workload, len(consumers))
+	for _, name := range consumers {
+		note := ""
+		if frozen, ok := lockFile.Frozen(name); ok && frozen.ImageDigest != "" {
+			if frozen.ImageDigest == metadata.Digest {
+				note = " (frozen on this digest)"
+			} else {
+				note = fmt.Sprintf(" (frozen on %s)", frozen.ImageDigest)
+			}
+		}
+		fmt.Fprintf(e.stdout, "  %s%s\n", name, note)
+	}
+	return nil
+}
+
+func (e *env) registryMirrorCommand(ctx *cli.Context) error {
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	if err := loader.LoadMachines(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading machines: %v", err), 1)
+	}
+	defaults := loader.GetDefaults()
+
+	machines := loader.SelectMachines(machineSelector(ctx))
+	if ctx.NArg() > 0 {
+		machines = nil
+		for _, name := range ctx.Args().Slice() {
+			m, err := loader.GetMachine(name)
+			if err != nil {
+				return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+			}
+			machines = append(machines, m)
+		}
+	}
+
+	// Machines sharing a workload image are mirrored once
+	var images []string
+	for _, m := range machines {
+		if m.ContainerImage == "" {
+			continue
+		}
+		tag := m.ContainerTag
+		if tag == "" {
+			tag = "latest"
+		}
+		if image := m.ContainerImage + ":" + tag; !slices.Contains(images, image) {
+			images = append(images, image)
+		}
+	}
+	if len(images) == 0 {
+		fmt.Fprintln(e.stdout, "No workload images to mirror")
+		return nil
+	}
+
+	limitRate := defaults.ContainerRegistry.Performance.LimitRate
+	if flag := ctx.String("limit-rate"); flag != "" {
+		limitRate = flag
+	}
+	rate, err := container.ParseRate(limitRate)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	registries := container.RegistryTransports(defaults.RegistryTLSConfigs())
+	options := container.MirrorOptions{
+		Registries: registries,
+		RateLimit:  container.LimitRate(rate),
+		DryRun:     ctx.Bool("dry-run"),
+	}
+	if token := ctx.String("to-token"); token != "" {
+		options.TargetAuth = &container.AuthConfig{Token: token}
+		if username := ctx.String("to-username"); username != "" {
+			options.TargetAuth = &container.AuthConfig{Username: username, Password: token}
+		}
+	}
+
+	failed := 0
+	for _, image := range images {
+		target, result, err := mirrorImage(ctx, image, defaults, options)
+		if err != nil {
+			fmt.Fprintf(e.stdout, "❌ %s: %v\n", image, err)
+			failed++
+			continue
+		}
+		status := "copied"
+		switch {
+		case result.UpToDate:
+			status = "up to date"
+		case options.DryRun:
+			status = "would copy"
+		}
+		fmt.Fprintf(e.stdout, "✅ %s -> %s (%s, %s)\n", image, target, result.Digest, status)
+		for _, artifact := range result.Artifacts {
+			fmt.Fprintf(e.stdout, "   + %s\n", artifact)
+		}
+	}
+
+	if failed > 0 {
+		return exitWithError(fmt.Sprintf("Error: %d of %d images could not be mirrored", failed, len(images)), 1)
+	}
+	return nil
+}
+
+// mirrorImage copies one image to the --to registry, reading it with the same
+// credentials iago pushes with, since workload repositories are often private
+func mirrorImage(ctx *cli.Context, image string, defaults machine.Defaults, options container.MirrorOptions) (string, container.MirrorResult, error) {
+	target, err := container.MirrorTarget(image, ctx.String("to"), options.Registries)
+	if err != nil {
+		return "", container.MirrorResult{}, err
+	}
+	if ref, err := options.Registries.ParseReference(image); err == nil {
+		provider, _ := auth.NewSecretProvider(defaults)
+		if authCfg, err := auth.GetRegistryAuthConfig(ctx.Context, defaults.ContainerRegistry, ref.Context().RegistryStr(), provider, "", ""); err == nil {
+			options.SourceAuth = authCfg.ToContainerAuthConfig()
+		}
+	}
+	result, err := container.Mirror(ctx.Context, image, target, options)
+	return target.String(), result, err
+}
+
+func (e *env) imageInspectCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (workload name). Usage: iago image inspect [workload-name]", 1)
+	}
+	workloadName := ctx.Args().Get(0)
+
+	st, err := e.loadState()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error loading state: %v", err), 1)
+	}
+
+	ws, ok := st.Workloads[workloadName]
+	if !ok {
+		return exitWithError(fmt.Sprintf("No recorded push for workload '%s'. Push it with 'iago build %s' or 'iago up'", workloadName, workloadName), 1)
+	}
+
+	fmt.Fprintf(e.stdout, "Workload:        %s\n", workloadName)
+	fmt.Fprintf(e.stdout, "Image:           %s\n", ws.ImageRef)
+	fmt.Fprintf(e.stdout, "Pushed at:       %s (%s)\n", ws.PushedAt.Local().Format(time.RFC3339), humanize.Since(ws.PushedAt))
+	fmt.Fprintf(e.stdout, "Context digest:  %s\n", ws.ContextDigest)
+
+	p := ws.Provenance
+	if p == nil {
+		fmt.Fprintln(e.stdout, "\nNo provenance recorded (pushed by an older iago)")
+		return nil
+	}
+
+	commit := p.GitCommit
+	if commit == "" {
+		commit = "(not in a git repository)"
+	} else if p.GitDirty {
+		commit += " (with uncommitted changes)"
+	}
+
+	fmt.Fprintf(e.stdout, "\nProvenance:\n")
+	fmt.Fprintf(e.stdout, "  Image digest:  %s\n", p.ImageDigest)
+	if p.Source != "" {
+		fmt.Fprintf(e.stdout, "  Source:        %s (external, pushed with --from)\n", p.Source)
+		commit = "(not built by iago)"
+	}
+	fmt.Fprintf(e.stdout, "  Git commit:    %s\n", commit)
+	fmt.Fprintf(e.stdout, "  Base image:    %s\n", p.BaseImage)
+	fmt.Fprintf(e.stdout, "  Base digest:   %s\n", p.BaseImageDigest)
+	fmt.Fprintf(e.stdout, "  Built on:      %s\n", p.BuilderHost)
+	took := p.Duration
+	if d, err := time.ParseDuration(p.Duration); err == nil {
+		took = humanize.Duration(d)
+	}
+	fmt.Fprintf(e.stdout, "  Built at:      %s (%s, took %s)\n", p.BuiltAt.Local().Format(time.RFC3339), humanize.Since(p.BuiltAt), took)
+	if len(p.Flags) > 0 {
+		fmt.Fprintf(e.stdout, "  Flags:         %s\n", strings.Join(p.Flags, " "))
+	}
+	return nil
+}
+
+func (e *env) buildAllWorkloads(ctx *cli.Context, defaults machine.Defaults, local, noPush, sign bool, cosignKey, tag, username, token string) error {
+	// Find all container directories, including those of [[include]] repositories
+	if _, err := os.Stat(machine.ContainersDir); os.IsNotExist(err) && len(defaults.Include) == 0 {
+		// Machine-only repositories deploy images built elsewhere
+		fmt.Fprintln(e.stdout, "No containers directory found; nothing to build. Create containers with 'iago init --container-only'")
+		return nil
+	}
+	workloads, err := defaults.ContainerNames()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error reading containers directory: %v", err), 1)
+	}
+
+	if len(workloads) == 0 {
+		return exitWithError("No containers found in containers directory", 1)
+	}
+
+	// Validate local registry once if needed
+	if local && !noPush {
+		if err := validateLocalRegistry(ctx, defaults); err != nil {
+			return exitWithError(err.Error(), 1)
+		}
+	}
+
+	format, err := outputFormat(ctx)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	// With --output json|yaml, progress messages go to stderr so stdout is only the report
+	stdout := e.stdout
+	if format != "table" {
+		e = e.progressToStderr()
+	}
+
+	parallel := max(ctx.Int("parallel"), 1)
+	if parallel > 1 {
+		fmt.Fprintf(e.stdout, "Building %d workloads, %d at a time: %v\n", len(workloads), parallel, workloads)
+	} else {
+		fmt.Fprintf(e.stdout, "Building %d workloads: %v\n", len(workloads), workloads)
+	}
+
+	outcomes := runWorkloadBuilds(e.stdout, workloads, parallel, ctx.Bool("fail-fast"), func(workload string, out io.Writer) error {
+		return e.buildWorkload(ctx, out, workload, defaults, local, noPush, sign, cosignKey, tag, username, token)
+	})
+
+	report := summary.New("iago build --all")
+	for i, workload := range workloads {
+		switch outcome := outcomes[i]; {
+		case !outcome.Started:
+			report.Add(workload, summary.StatusSkipped, "not started after a failure (--fail-fast)", "")
+		case outcome.Err != nil:
+			report.Add(workload, summary.StatusFailed, outcome.Err.Error(), "")
+		default:
+			artifact := ""
+			if !noPush {
+				artifact = container.NewBuilder(container.BuildOptions{WorkloadName: workload, RegistryURL: defaults.ContainerRegistry.URL, Local: local, Tag: tag}).ImageRef()
+			}
+			report.Add(workload, summary.StatusOK, fmt.Sprintf("built in %s", humanize.Duration(outcome.Duration)), artifact)
+		}
+	}
+	printBuildSummary(e.stdout, report)
+	if format != "table" {
+		if err := writeStructured(stdout, format, buildReport{Succeeded: !report.Failed(), Results: report.Results}); err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+	}
+
+	if err := writeSummary(ctx, report); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	if report.Failed() {
+		failures := 0
+		for _, r := range report.Results {
+			if r.Status == summary.StatusFailed {
+				failures++
+			}
+		}
+		return exitWithError(fmt.Sprintf("\n%d of %d workload builds failed", failures, len(workloads)), 1)
+	}
+	fmt.Fprintf(e.stdout, "\n🎉 All workload builds completed!\n")
+	return nil
+}
+
+// buildOutcome is how one workload of iago build --all went
+type buildOutcome struct {
+	Started  bool
+	Err      error
+	Duration time.Duration
+}
+
+// runWorkloadBuilds runs build for every workload, at most parallel at a time
+// and started in order; in parallel each workload's output is prefixed
+// with its name and written a line at a time. With failFast no workload is
+// started after one fails, and those already running finish.
+func runWorkloadBuilds(stdout io.Writer, workloads []string, parallel int, failFast bool, build func(workload string, out io.Writer) error) []buildOutcome {
+	outcomes := make([]buildOutcome, len(workloads))
+	var failed atomic.Bool
+	// A slot is only given back once its outcome is recorded, so with
+	// failFast the next workload sees every failure that finished before it
+	slots := make(chan struct{}, parallel)
+	var outputMu sync.Mutex
+	var wg sync.WaitGroup
+	for i, workload := range workloads {
+		slots <- struct{}{}
+		if failFast && failed.Load() {
+			break
+		}
+		outcomes[i].Started = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			var out io.Writer = stdout
+			var prefixed *prefixWriter
+			if parallel > 1 {
+				prefixed = newPrefixWriter(stdout, &outputMu, workload)
+				out = prefixed
+			} else {
+				fmt.Fprintf(stdout, "\n--- Building %s ---\n", workload)
+			}
+
+			started := time.Now()
+			err := build(workload, out)
+			outcomes[i].Err, outcomes[i].Duration = err, time.Since(started).Round(time.Second)
+			if err != nil {
+				failed.Store(true)
+				fmt.Fprintf(out, "❌ Failed to build %s: %v\n", workload, err)
+			} else {
+				fmt.Fprintf(out, "✅ Completed %s in %s\n", workload, humanize.Duration(outcomes[i].Duration))
+			}
+			if prefixed != nil {
+				prefixed.Flush()
+			}
+		}()
+	}
+	wg.Wait()
+	return outcomes
+}
+
+// buildReport is the --output json|yaml form of iago build --all
+type buildReport struct {
+	Succeeded bool             `json:"succeeded" yaml:"succeeded"`
+	Results   []summary.Result `json:"results" yaml:"results"`
+}
+
+// resolveImageDigest returns the digest image points to now, for iago freeze
+func (e *env) resolveImageDigest(ctx *cli.Context, defaults machine.Defaults, image string) (string, error) {
+	// Private registries need credentials to read; public ones don't
+	registries := container.RegistryTransports(defaults.RegistryTLSConfigs())
+	metadata, err := container.ReadImageMetadata(ctx.Context, image, registries, registryAuth(ctx, defaults, registries, image))
+	if err != nil {
+		return "", err
+	}
+	return metadata.Digest, nil
+}
+
+// registryAuth returns the credentials configured for image's registry, or
+// nil to access it anonymously
+func registryAuth(ctx *cli.Context, defaults machine.Defaults, registries container.RegistryTransports, image string) *container.AuthConfig {
+	ref, err := registries.ParseReference(image)
+	if err != nil {
+		return nil
+	}
+	provider, _ := auth.NewSecretProvider(defaults)
+	authCfg, err := auth.GetRegistryAuthConfig(ctx.Context, defaults.ContainerRegistry, ref.Context().RegistryStr(), provider, "", "")
+	if err != nil {
+		return nil
+	}
+	return authCfg.ToContainerAuthConfig()
+}
+
+// saveBundleImages pulls each image into its own OCI layout under dir
+func saveBundleImages(ctx *cli.Context, defaults machine.Defaults, refs []string, dir string) ([]bundle.Image, error) {
+	registries := container.RegistryTransports(defaults.RegistryTLSConfigs())
+	var images []bundle.Image
+	for _, ref := range refs {
+		image := bundle.Image{Reference: ref, Dir: bundle.ImageDir(ref)}
+		digest, err := container.SaveImage(ctx.Context, ref, filepath.Join(dir, image.Dir), registries, registryAuth(ctx, defaults, registries, ref))
+		if err != nil {
+			return nil, err
+		}
+		image.Digest = digest
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// pushBundleImages pushes each image restored under dir to its reference
+func pushBundleImages(ctx *cli.Context, defaults machine.Defaults, images []bundle.Image, dir string) error {
+	registries := container.RegistryTransports(defaults.RegistryTLSConfigs())
+	for _, image := range images {
+		err := container.PushLayout(ctx.Context, filepath.Join(dir, image.Dir), image.Reference, registries, registryAuth(ctx, defaults, registries, image.Reference))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// doctorRegistry checks the [container_registry] host answers the registry API
+func doctorRegistry(ctx *cli.Context, d *doctor, defaults machine.Defaults) {
+	if defaults.ContainerRegistry.URL == "" {
+		d.skip("registry", "no [container_registry] url")
+		return
+	}
+	host, _, _ := strings.Cut(defaults.ContainerRegistry.URL, "/")
+	registries := container.RegistryTransports(defaults.RegistryTLSConfigs())
+	if err := registries.Ping(ctx.Context, host); err != nil {
+		d.fail("registry", err.Error(), fmt.Sprintf("check the network or VPN, and [container_registry.tls.%q] for private CAs or plain HTTP", host))
+		return
+	}
+	d.ok("registry", host+" is reachable")
+}
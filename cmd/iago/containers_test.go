@@ -0,0 +1,67 @@
+//go:build !lite
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andreweick/iago/internal/summary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	web := newPrefixWriter(&out, &mu, "web")
+	db := newPrefixWriter(&out, &mu, "db")
+
+	fmt.Fprint(web, "Building web")
+	fmt.Fprint(db, "Building db\nSTEP 1/3\r")
+	fmt.Fprint(web, " with podman\n\n")
+	fmt.Fprint(db, "done")
+	assert.Equal(t, "[db] Building db\n[db] STEP 1/3\n[web] Building web with podman\n", out.String(), "only whole lines are written")
+
+	require.NoError(t, db.Flush())
+	require.NoError(t, web.Flush())
+	assert.True(t, strings.HasSuffix(out.String(), "[db] done\n"))
+}
+
+func TestRunWorkloadBuilds(t *testing.T) {
+	workloads := []string{"web", "db", "cache"}
+	build := func(workload string, out io.Writer) error {
+		if workload == "db" {
+			return errors.New("no Containerfile")
+		}
+		return nil
+	}
+
+	outcomes := runWorkloadBuilds(io.Discard, workloads, 1, false, build)
+	require.Len(t, outcomes, 3)
+	assert.True(t, outcomes[2].Started, "without --fail-fast every workload is built")
+	assert.NoError(t, outcomes[0].Err)
+	assert.ErrorContains(t, outcomes[1].Err, "no Containerfile")
+
+	outcomes = runWorkloadBuilds(io.Discard, workloads, 1, true, build)
+	assert.True(t, outcomes[1].Started)
+	assert.False(t, outcomes[2].Started, "--fail-fast starts nothing after a failure")
+}
+
+func TestPrintBuildSummary(t *testing.T) {
+	report := summary.New("iago build --all")
+	report.Add("web", summary.StatusOK, "built in 3s", "ghcr.io/acme/web:latest")
+	report.Add("db", summary.StatusFailed, "no Containerfile", "")
+	report.Add("cache", summary.StatusSkipped, "not started after a failure (--fail-fast)", "")
+
+	var out bytes.Buffer
+	printBuildSummary(&out, report)
+	assert.Contains(t, out.String(), "1 succeeded, 1 failed, 1 skipped")
+	assert.Contains(t, out.String(), "ghcr.io/acme/web:latest")
+	assert.Contains(t, out.String(), "no Containerfile")
+}
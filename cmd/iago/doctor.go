@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+
+	"github.com/andreweick/iago/internal/butane"
+	"github.com/andreweick/iago/internal/github"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/store"
+	"github.com/urfave/cli/v2"
+)
+
+// newDoctorCommand returns iago doctor
+func newDoctorCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:   "doctor",
+		Usage:  "Check the workstation can run iago: repository layout, registry, signing keys, secret provider, GitHub SSH keys and butane versions",
+		Action: e.doctorCommand,
+	}
+}
+
+// doctor prints the result of each check as it runs, with how to fix problems
+type doctor struct {
+	out      io.Writer
+	failures int
+	warnings int
+}
+
+func (d *doctor) ok(name, detail string) {
+	fmt.Fprintf(d.out, "  ✓ %s: %s\n", name, detail)
+}
+
+// warn reports a problem that only some commands run into
+func (d *doctor) warn(name, problem, fix string) {
+	d.warnings++
+	fmt.Fprintf(d.out, "  ⚠ %s: %s\n      → %s\n", name, problem, fix)
+}
+
+func (d *doctor) fail(name, problem, fix string) {
+	d.failures++
+	fmt.Fprintf(d.out, "  ✗ %s: %s\n      → %s\n", name, problem, fix)
+}
+
+func (d *doctor) skip(name, reason string) {
+	fmt.Fprintf(d.out, "  - %s: %s\n", name, reason)
+}
+
+func (e *env) doctorCommand(ctx *cli.Context) error {
+	d := &doctor{out: e.stdout}
+
+	fmt.Fprintln(e.stdout, "Repository")
+	loader := e.newLoader()
+	configured := doctorLayout(d, loader)
+
+	fmt.Fprintln(e.stdout, "\nButane")
+	doctorButane(d, loader, configured)
+
+	fmt.Fprintln(e.stdout, "\nNetwork and credentials")
+	if !configured {
+		d.skip("checks", "the configuration doesn't load")
+	} else {
+		defaults := loader.GetDefaults()
+		doctorGitHub(d, "GitHub SSH keys", defaults.User.GitHubUsername)
+		if defaults.Admin.GitHubUsername != "" {
+			doctorGitHub(d, "GitHub admin SSH keys", defaults.Admin.GitHubUsername)
+		}
+		doctorRegistry(ctx, d, defaults)
+		doctorSigningKeys(d, defaults)
+		doctorSecrets(ctx, d, defaults)
+	}
+
+	fmt.Fprintf(e.stdout, "\n%d problem(s), %d warning(s)\n", d.failures, d.warnings)
+	if d.failures > 0 {
+		return exitWithError("iago doctor found problems", 1)
+	}
+	return nil
+}
+
+// doctorLayout checks the repository's directories and configuration, and
+// reports whether the configuration loads
+func doctorLayout(d *doctor, loader *machine.ConfigLoader) bool {
+	const defaultsPath = "config/defaults.toml"
+	if _, err := os.Stat(filepath.Join(loader.Root(), defaultsPath)); err != nil {
+		d.fail(defaultsPath, "not found", "run iago from the repository root, or create one with 'iago init'")
+		return false
+	}
+	d.ok(defaultsPath, "found")
+	for _, notice := range machine.DetectRepoShapeAt(loader.Root()).Notices() {
+		d.skip("layout", notice)
+	}
+
+	// Only inspected: doctor neither creates the store nor migrates it
+	version, err := store.Inspect(filepath.Join(loader.Root(), store.DefaultPath))
+	switch {
+	case os.IsNotExist(err):
+		d.skip(store.DefaultPath, "not created yet; the first command that records state creates it")
+	case err != nil:
+		d.fail(store.DefaultPath, err.Error(), "close other iago processes and check .iago/ is readable")
+	case version > store.LatestSchemaVersion():
+		d.fail(store.DefaultPath, fmt.Sprintf("schema version %d is newer than this iago supports (%d)", version, store.LatestSchemaVersion()), "upgrade iago")
+	case version < store.LatestSchemaVersion():
+		d.ok(store.DefaultPath, fmt.Sprintf("schema version %d, migrated to %d by the next command that uses it", version, store.LatestSchemaVersion()))
+	default:
+		d.ok(store.DefaultPath, fmt.Sprintf("schema version %d", version))
+	}
+
+	if err := loader.LoadAll(); err != nil {
+		d.fail("configuration", err.Error(), "fix the file and line above; 'iago validate' checks the rest")
+		return false
+	}
+	d.ok("configuration", fmt.Sprintf("%d machine(s), %d workload(s)", len(loader.GetMachines()), len(loader.GetWorkloads())))
+	return true
+}
+
+// doctorButane reports the butane and ignition libraries iago was built with
+// and checks every machine template targets a spec they translate
+func doctorButane(d *doctor, loader *machine.ConfigLoader, configured bool) {
+	d.ok("libraries", fmt.Sprintf("butane %s, ignition %s (ignition specs %s)",
+		moduleVersion("github.com/coreos/butane"), moduleVersion("github.com/coreos/ignition/v2"), joinVersions(butane.IgnitionVersions())))
+	if !configured {
+		d.skip("templates", "the configuration doesn't load")
+		return
+	}
+
+	for _, m := range loader.GetMachines() {
+		path := filepath.Join(loader.MachineDir(m), "butane.yaml.tmpl")
+		content, err := os.ReadFile(path)
+		if err != nil {
+			d.fail(m.Name, err.Error(), fmt.Sprintf("create %s", path))
+			continue
+		}
+		spec, err := butane.TemplateIgnitionVersion(string(content))
+		if err != nil {
+			d.fail(m.Name, fmt.Sprintf("%s: %v", path, err), "start the template with 'variant: fcos' and a version listed above")
+			continue
+		}
+		if m.IgnitionVersion != "" {
+			if _, err := butane.TargetIgnitionVersion(string(content), m.IgnitionVersion); err != nil {
+				d.fail(m.Name, err.Error(), "lower ignition_version in machine.toml or the template's version")
+				continue
+			}
+			spec = m.IgnitionVersion
+		}
+		d.ok(m.Name, "ignition spec "+spec)
+	}
+}
+
+// doctorGitHub checks the SSH keys templates get from a github_username can be fetched
+func doctorGitHub(d *doctor, name, username string) {
+	if username == "" {
+		d.skip(name, "no [user] github_username")
+		return
+	}
+	keys, err := github.FetchSSHKeys(username)
+	switch {
+	case err != nil:
+		d.fail(name, err.Error(), "check the network and github_username; 'iago freeze' lets ignite run without GitHub")
+	case len(keys) == 0:
+		d.warn(name, fmt.Sprintf("%s has no public keys", username), "add one at https://github.com/settings/keys, or machines will have no SSH access")
+	default:
+		d.ok(name, fmt.Sprintf("%d key(s) for %s", len(keys), username))
+	}
+}
+
+// doctorSigningKeys checks the keys image and ignition signing use are present
+func doctorSigningKeys(d *doctor, defaults machine.Defaults) {
+	home, _ := os.UserHomeDir()
+	cosignKey := filepath.Join(home, ".config", "sigstore", "cosign.key")
+	if _, err := os.Stat(cosignKey); err == nil {
+		d.ok("cosign key", cosignKey)
+	} else {
+		d.warn("cosign key", cosignKey+" not found", "'iago build --sign' falls back to keyless signing; create a key with 'cosign generate-key-pair --output-key-prefix ~/.config/sigstore/cosign'")
+	}
+
+	cosignPublicKey := defaults.Signing.ContainerPublicKey
+	if cosignPublicKey == "" {
+		cosignPublicKey = filepath.Join(home, ".config", "sigstore", "cosign.pub")
+	}
+	if _, err := os.Stat(cosignPublicKey); err == nil {
+		d.ok("cosign public key", cosignPublicKey)
+	} else {
+		d.warn("cosign public key", cosignPublicKey+" not found", "'iago verify' needs it; set [signing] container_public_key")
+	}
+
+	for _, key := range []struct{ name, path string }{
+		{"ignition signing key", defaults.Signing.IgnitionKey},
+		{"ignition public key", defaults.Signing.IgnitionPublicKey},
+	} {
+		if key.path == "" {
+			continue
+		}
+		if _, err := os.Stat(key.path); err != nil {
+			d.fail(key.name, key.path+" not found", "generate a pair with 'iago keygen' or fix [signing] in defaults.toml")
+		} else {
+			d.ok(key.name, key.path)
+		}
+	}
+}
+
+// moduleVersion returns the version of a module iago was built with
+func moduleVersion(path string) string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == path {
+				return dep.Version
+			}
+		}
+	}
+	return "(unknown version)"
+}
+
+// joinVersions shortens a version list to its first and last entries
+func joinVersions(versions []string) string {
+	if len(versions) == 0 {
+		return "none"
+	}
+	return versions[0] + "–" + versions[len(versions)-1]
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoctor(t *testing.T) {
+	root := writeRepo(t, map[string]string{
+		"config/defaults.toml":          "domain = \"example.com\"\n",
+		"machines/web/machine.toml":     machineTOML("web", "02:00:00:00:00:01", "") + "ignition_version = \"3.2.0\"\n",
+		"machines/web/butane.yaml.tmpl": "variant: fcos\nversion: 1.5.0\n",
+	})
+	home := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(home, ".config", "sigstore"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".config", "sigstore", "cosign.pub"), []byte("key"), 0644))
+	t.Setenv("HOME", home)
+	t.Setenv("OP_SERVICE_ACCOUNT_TOKEN", "")
+
+	out := runApp(t, root, "doctor")
+	assert.Contains(t, out, "✓ config/defaults.toml: found")
+	assert.Contains(t, out, "- layout: no containers/ directory")
+	assert.Contains(t, out, "✓ configuration: 1 machine(s)")
+	assert.Contains(t, out, "✓ web: ignition spec 3.2.0")
+	assert.Contains(t, out, "- GitHub SSH keys: no [user] github_username")
+	assert.Contains(t, out, "- registry: ")
+	assert.Contains(t, out, "⚠ cosign key: "+filepath.Join(home, ".config", "sigstore", "cosign.key")+" not found\n      → ")
+	assert.Contains(t, out, "✓ cosign public key: ")
+	assert.Contains(t, out, "0 problem(s), ")
+	assert.Contains(t, out, "- .iago/iago.db: not created yet")
+	assert.NoDirExists(t, filepath.Join(root, ".iago"), "doctor doesn't create the store")
+}
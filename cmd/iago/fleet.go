@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/andreweick/iago/internal/build"
+	"github.com/andreweick/iago/internal/fleet"
+	"github.com/andreweick/iago/internal/humanize"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/urfave/cli/v2"
+)
+
+// newStatusCommand returns iago status
+func newStatusCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "status",
+		Usage:     "Show the workload, image and update state of deployed machines over SSH",
+		ArgsUsage: "[machine-name...]",
+		Action:    e.statusCommand,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "site",
+				Usage: "Only check machines in this site",
+			},
+			&cli.StringFlag{
+				Name:  "group",
+				Usage: "Only check machines in this group",
+			},
+			&cli.StringSliceFlag{
+				Name:  "tag",
+				Usage: "Only check machines with this tag (repeat to require several)",
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "Give up on a machine after this long",
+				Value: fleet.DefaultTimeout,
+			},
+			&cli.BoolFlag{
+				Name:  "insecure-ignore-host-key",
+				Usage: "Don't check host keys against ~/.ssh/known_hosts",
+			},
+			&cli.IntFlag{
+				Name:  "parallel",
+				Usage: "Machines to check at once",
+				Value: fleet.DefaultParallelism,
+			},
+			&cli.BoolFlag{
+				Name:  "refresh",
+				Usage: "Ignore results cached by a run in the last minute",
+			},
+		},
+	}
+}
+
+func (e *env) statusCommand(ctx *cli.Context) error {
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	if err := loader.LoadMachines(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading machines: %v", err), 1)
+	}
+	defaults := loader.GetDefaults()
+
+	machines := loader.SelectMachines(machineSelector(ctx))
+	if ctx.NArg() > 0 {
+		machines = nil
+		for _, name := range ctx.Args().Slice() {
+			m, err := loader.GetMachine(name)
+			if err != nil {
+				return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+			}
+			machines = append(machines, m)
+		}
+	}
+	if len(machines) == 0 {
+		fmt.Fprintln(e.stdout, "No machines configured")
+		return nil
+	}
+
+	client, err := fleet.NewClient(fleet.ClientOptions{
+		User:                  defaults.User.Username,
+		GitHubUsername:        defaults.User.GitHubUsername,
+		Timeout:               ctx.Duration("timeout"),
+		InsecureIgnoreHostKey: ctx.Bool("insecure-ignore-host-key"),
+	})
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	engine := &fleet.Engine{
+		Runner:      client,
+		Parallelism: ctx.Int("parallel"),
+		OnResult: func(result fleet.Result) {
+			if result.Err != nil {
+				fmt.Fprintf(e.stderr, "  %s: failed\n", result.Machine)
+			} else if !result.Cached {
+				fmt.Fprintf(e.stderr, "  %s: ok (%s)\n", result.Machine, humanize.Duration(result.Duration))
+			}
+		},
+	}
+	if !ctx.Bool("refresh") {
+		if cache, err := fleet.NewResultCache(fleet.DefaultCacheTTL); err == nil {
+			engine.Cache = cache
+		}
+	}
+
+	// Ctrl-C stops waiting on slow machines but still prints what came back
+	runCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt)
+	defer stop()
+	fmt.Fprintf(e.stderr, "Checking %d machine(s)...\n", len(machines))
+	statuses := fleet.FleetStatus(runCtx, engine, machines)
+	fmt.Fprintln(e.stderr)
+
+	fmt.Fprintf(e.stdout, "%-18s %-27s %-12s %-21s %-14s %-10s\n", "NAME", "HOST", "CONTAINER", "IMAGE", "LAST UPDATE", "ZINCATI")
+	fmt.Fprintln(e.stdout, "--------------------------------------------------------------------------------------------------------")
+	var unreachable []fleet.Status
+	cached := 0
+	for _, status := range statuses {
+		if status.Cached {
+			cached++
+		}
+		if status.Err != nil {
+			unreachable = append(unreachable, status)
+			fmt.Fprintf(e.stdout, "%-18s %-27s %-12s\n", status.Machine, status.Host, "unreachable")
+			continue
+		}
+		lastUpdate := status.LastUpdate
+		if t, ok := status.LastUpdateTime(); ok {
+			lastUpdate = humanize.Since(t)
+		}
+		fmt.Fprintf(e.stdout, "%-18s %-27s %-12s %-21s %-14s %-10s\n",
+			status.Machine,
+			status.Host,
+			orDash(status.Container),
+			orDash(shortDigest(status.ImageDigest)),
+			orDash(lastUpdate),
+			orDash(status.Zincati))
+	}
+
+	if cached > 0 {
+		fmt.Fprintf(e.stdout, "\n%d machine(s) reported from the last minute's results; use --refresh to check again\n", cached)
+	}
+
+	if len(unreachable) > 0 {
+		fmt.Fprintln(e.stdout)
+		for _, status := range unreachable {
+			fmt.Fprintf(e.stdout, "%s: %v\n", status.Machine, status.Err)
+		}
+		return exitWithError(fmt.Sprintf("%d of %d machines could not be checked", len(unreachable), len(statuses)), 1)
+	}
+	return nil
+}
+
+// newDeployCommand returns iago deploy
+func newDeployCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "deploy",
+		Usage:     "Apply config changes (env files, scripts, systemd units) to running machines over SSH, rendered from their frozen inputs",
+		ArgsUsage: "[machine-name...]",
+		Action:    e.deployCommand,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Deploy to every machine",
+			},
+			&cli.StringFlag{
+				Name:  "site",
+				Usage: "Deploy to every machine in this site",
+			},
+			&cli.StringFlag{
+				Name:  "group",
+				Usage: "Deploy to every machines in this group",
+			},
+			&cli.StringSliceFlag{
+				Name:  "tag",
+				Usage: "Deploy to every machines with this tag (repeat to require several)",
+			},
+			&cli.BoolFlag{
+				Name:    "dry-run",
+				Aliases: []string{"n"},
+				Usage:   "Show what would change without changing anything",
+			},
+			&cli.BoolFlag{
+				Name:  "restart",
+				Usage: "Restart services whose units or env files changed",
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "Give up on a machine after this long",
+				Value: fleet.DefaultTimeout,
+			},
+			&cli.BoolFlag{
+				Name:  "insecure-ignore-host-key",
+				Usage: "Don't check host keys against ~/.ssh/known_hosts",
+			},
+			&cli.BoolFlag{
+				Name:  "i-know-what-im-doing",
+				Usage: "Allow the action on a protected machine without a second approver",
+			},
+			&cli.StringFlag{
+				Name:  "approved-by",
+				Usage: "Name of the second person approving the action on a protected machine",
+			},
+		},
+	}
+}
+
+func (e *env) deployCommand(ctx *cli.Context) error {
+	selector := machineSelector(ctx)
+	if ctx.Bool("all") || !selector.IsZero() {
+		if ctx.NArg() != 0 {
+			return exitWithError("Error: --all, --site, --group and --tag take no machine name. Usage: iago deploy --all|--site [site]|--group [group]|--tag [tag] [flags]", 1)
+		}
+	} else if ctx.NArg() == 0 {
+		return exitWithError("Error: requires a machine name, --site, --group, --tag or --all. Usage: iago deploy [flags] [machine-name...]", 1)
+	}
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	if err := loader.LoadMachines(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading machines: %v", err), 1)
+	}
+	defaults := loader.GetDefaults()
+
+	var machines []machine.Config
+	switch {
+	case ctx.Bool("all"):
+		machines = loader.GetMachines()
+	case !selector.IsZero():
+		machines = loader.SelectMachines(selector)
+	default:
+		for _, name := range ctx.Args().Slice() {
+			m, err := loader.GetMachine(name)
+			if err != nil {
+				return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+			}
+			machines = append(machines, m)
+		}
+	}
+	if len(machines) == 0 {
+		fmt.Fprintln(e.stdout, "No machines configured")
+		return nil
+	}
+
+	dryRun := ctx.Bool("dry-run")
+	if !dryRun {
+		for _, m := range machines {
+			if err := guardProtected(ctx, m, "deploy"); err != nil {
+				return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+			}
+		}
+	}
+
+	builder, closeStore, err := e.newFrozenBuilder()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	defer closeStore()
+	client, err := fleet.NewClient(fleet.ClientOptions{
+		User:                  defaults.User.Username,
+		GitHubUsername:        defaults.User.GitHubUsername,
+		Timeout:               ctx.Duration("timeout"),
+		InsecureIgnoreHostKey: ctx.Bool("insecure-ignore-host-key"),
+	})
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	runCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt)
+	defer stop()
+
+	failed := 0
+	for _, m := range machines {
+		if err := e.deployMachine(runCtx, builder, client, m, dryRun, ctx.Bool("restart")); err != nil {
+			fmt.Fprintf(e.stdout, "❌ %s: %v\n\n", m.Name, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return exitWithError(fmt.Sprintf("%d of %d machines failed to deploy", failed, len(machines)), 1)
+	}
+	return nil
+}
+
+// deployMachine renders m, shows how it differs from the running machine and,
+// unless dryRun, applies the safe changes
+func (e *env) deployMachine(ctx context.Context, builder *build.Builder, client *fleet.Client, m machine.Config, dryRun, restart bool) error {
+	host := fleet.Host(m)
+	if host == "" {
+		return fmt.Errorf("no fqdn or ip_address")
+	}
+	_, ignitionJSON, err := builder.RenderMachine(m.Name, true)
+	if err != nil {
+		return err
+	}
+	plan, err := fleet.PlanDeploy(ctx, client, m.Name, host, ignitionJSON)
+	if err != nil {
+		return err
+	}
+	plan.Exempt(m.DriftExempted)
+
+	if len(plan.Changes) == 0 {
+		fmt.Fprintf(e.stdout, "✅ %s: up to date%s\n\n", m.Name, exemptNote(plan))
+		return nil
+	}
+
+	fmt.Fprintf(e.stdout, "📦 %s (%s)%s\n", m.Name, host, exemptNote(plan))
+	for _, change := range plan.Safe() {
+		fmt.Fprintf(e.stdout, "  %-8s %s\n", change.Kind, change.Path)
+		if change.Diff != "" {
+			for _, line := range strings.Split(strings.TrimRight(change.Diff, "\n"), "\n") {
+				fmt.Fprintf(e.stdout, "           %s\n", line)
+			}
+		}
+	}
+	unsafe := plan.Unsafe()
+	if len(unsafe) > 0 {
+		fmt.Fprintln(e.stdout, "  Not deployable; re-provision the machine to apply:")
+		for _, change := range unsafe {
+			fmt.Fprintf(e.stdout, "  %-8s %s\n", change.Kind, change.Path)
+		}
+	}
+
+	safe := plan.Safe()
+	switch {
+	case len(safe) == 0:
+		fmt.Fprintln(e.stdout)
+		return nil
+	case dryRun:
+		fmt.Fprintf(e.stdout, "  Dry run: %d change(s) not applied\n\n", len(safe))
+		return nil
+	}
+
+	if err := plan.Apply(ctx, client, restart); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "  Applied %d change(s)\n", len(safe))
+	if units := plan.RestartUnits(); len(units) > 0 {
+		if restart {
+			fmt.Fprintf(e.stdout, "  Restarted %s (where running)\n", strings.Join(units, ", "))
+		} else {
+			fmt.Fprintf(e.stdout, "  Restart to pick up the changes: sudo systemctl restart %s (or rerun with --restart)\n", strings.Join(units, " "))
+		}
+	}
+	fmt.Fprintln(e.stdout)
+	return nil
+}
+
+// exemptNote says how many drift_exempt paths differ from the rendered config
+func exemptNote(plan *fleet.Plan) string {
+	if len(plan.Exempted) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%d drift_exempt path(s) differ, left alone)", len(plan.Exempted))
+}
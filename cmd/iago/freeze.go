@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/andreweick/iago/internal/build"
+	"github.com/andreweick/iago/internal/butane"
+	"github.com/andreweick/iago/internal/lock"
+	"github.com/andreweick/iago/internal/store"
+	"github.com/urfave/cli/v2"
+)
+
+// newFreezeCommand returns iago freeze
+func newFreezeCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "freeze",
+		Usage:     "Record a machine's GitHub SSH keys, generated password, fetched content and image digest so 'iago ignite --frozen' reproduces its ignition",
+		ArgsUsage: "[machine-name]",
+		Action:    e.freezeCommand,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "update-lock",
+				Usage: "Accept remote sources and fetchURL content that changed since they were pinned",
+			},
+		},
+	}
+}
+
+// newFrozenBuilder returns a builder that renders machines from the inputs
+// 'iago freeze' recorded, failing for machines that aren't frozen, so what a
+// command compares or ships matches what 'iago ignite --frozen' writes. closeStore
+// releases the store.
+func (e *env) newFrozenBuilder() (builder *build.Builder, closeStore func(), err error) {
+	if builder, err = e.newBuilder(); err != nil {
+		return nil, nil, fmt.Errorf("failed to create builder: %w", err)
+	}
+	db, err := store.Open(e.storePath())
+	if err != nil {
+		return nil, nil, err
+	}
+	builder.EnableFrozen(lock.DefaultPath, db)
+	return builder, func() { db.Close() }, nil
+}
+
+func (e *env) freezeCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago freeze [machine-name]", 1)
+	}
+	machineName := ctx.Args().First()
+
+	loader := e.newLoader()
+	if err := loader.LoadAll(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading configuration: %v", err), 1)
+	}
+	m, err := loader.GetMachine(machineName)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	defaults, err := loader.DefaultsFor(m)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	digest := ""
+	if image := m.ImageReference(); image != "" {
+		if digest, err = e.resolveImageDigest(ctx, defaults, image); err != nil {
+			return exitWithError(fmt.Sprintf("Error resolving %s: %v", image, err), 1)
+		}
+	}
+
+	builder, err := e.newBuilder()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error creating builder: %v", err), 1)
+	}
+	db, err := store.Open(e.storePath())
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	defer db.Close()
+
+	frozen, err := builder.Freeze(m.Name, lock.DefaultPath, db, digest, ctx.Bool("update-lock"))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error freezing %s: %v", m.Name, err), 1)
+	}
+
+	fmt.Fprintf(e.stdout, "Froze %s\n", m.Name)
+	if defaults.User.GitHubUsername != "" {
+		fmt.Fprintf(e.stdout, "  ✓ %d SSH key(s) of GitHub user %s\n", len(frozen.SSHKeys), defaults.User.GitHubUsername)
+	}
+	if defaults.Admin.GitHubUsername != "" {
+		fmt.Fprintf(e.stdout, "  ✓ %d admin SSH key(s) of GitHub user %s\n", len(frozen.AdminSSHKeys), defaults.Admin.GitHubUsername)
+	}
+	if len(frozen.Fetches) > 0 {
+		fmt.Fprintf(e.stdout, "  ✓ %d fetchURL source(s)\n", len(frozen.Fetches))
+	}
+	if frozen.ImageDigest != "" {
+		fmt.Fprintf(e.stdout, "  ✓ %s is %s\n", frozen.Image, frozen.ImageDigest)
+	}
+	fmt.Fprintf(e.stdout, "  ✓ Generated password and fetched content in %s (not committed)\n", store.DefaultPath)
+	if defaults.Secrets.Encryption == butane.SecretsEncryptionAge {
+		fmt.Fprintln(e.stdout, "Note: age encryption is randomized, so encrypted [secrets] differ between runs even when frozen")
+	}
+	fmt.Fprintf(e.stdout, "\nPinned in %s; reproduce the ignition with 'iago ignite --frozen %s'\n", lock.DefaultPath, m.Name)
+	return nil
+}
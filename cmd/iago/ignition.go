@@ -0,0 +1,742 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/archive"
+	"github.com/andreweick/iago/internal/build"
+	"github.com/andreweick/iago/internal/butane"
+	"github.com/andreweick/iago/internal/fleet"
+	"github.com/andreweick/iago/internal/humanize"
+	"github.com/andreweick/iago/internal/lock"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/signing"
+	"github.com/andreweick/iago/internal/store"
+	"github.com/andreweick/iago/internal/summary"
+	"github.com/urfave/cli/v2"
+)
+
+// newDiffCommand returns iago diff
+func newDiffCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "diff",
+		Usage:     "Show how a machine's ignition would change if it were generated again from its frozen inputs",
+		ArgsUsage: "[machine-name]",
+		Action:    e.diffCommand,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "previous",
+				Usage: "Ignition file to compare with (defaults to output/ignition/<machine-name>.ign, or output/ignition/<env>/ with --env)",
+			},
+			&cli.BoolFlag{
+				Name:  "exit-code",
+				Usage: "Exit with status 1 when there are differences",
+			},
+		},
+	}
+}
+
+func (e *env) diffCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago diff [flags] [machine-name]", 1)
+	}
+	machineName := ctx.Args().Get(0)
+
+	previousFile := ctx.String("previous")
+	if previousFile == "" {
+		previousFile = e.ignitionFile(machineName)
+	}
+	previous, err := os.ReadFile(previousFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return exitWithError(fmt.Sprintf("Error: %s doesn't exist; run 'iago ignite %s' first or pass --previous", previousFile, machineName), 1)
+		}
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	builder, closeStore, err := e.newFrozenBuilder()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	defer closeStore()
+	_, rendered, err := builder.RenderMachine(machineName, false)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	diff, err := fleet.DiffIgnition(previous, rendered)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if diff.Empty() {
+		fmt.Fprintf(e.stdout, "✅ %s: no changes since %s\n", machineName, previousFile)
+		return nil
+	}
+
+	fmt.Fprintf(e.stdout, "📦 %s: changes since %s\n", machineName, previousFile)
+	printChanges := func(title string, changes []fleet.Change) {
+		if len(changes) == 0 {
+			return
+		}
+		fmt.Fprintf(e.stdout, "\n%s:\n", title)
+		for _, change := range changes {
+			note := ""
+			if !change.Safe {
+				note = " (re-provision)"
+			}
+			fmt.Fprintf(e.stdout, "  %-8s %s%s\n", change.Kind, change.Path, note)
+			if change.Diff != "" {
+				for _, line := range strings.Split(strings.TrimRight(change.Diff, "\n"), "\n") {
+					fmt.Fprintf(e.stdout, "           %s\n", line)
+				}
+			}
+		}
+	}
+	printChanges("Files", diff.Files)
+	printChanges("Units", diff.Units)
+	if len(diff.Other) > 0 {
+		fmt.Fprintf(e.stdout, "\nAlso changed (re-provision): %s\n", strings.Join(diff.Other, ", "))
+	}
+	fmt.Fprintf(e.stdout, "\nChanges not marked (re-provision) can be applied to the running machine with 'iago deploy %s'\n", machineName)
+
+	if ctx.Bool("exit-code") {
+		closeStore()
+		os.Exit(1)
+	}
+	return nil
+}
+
+// newRenderCommand returns iago render
+func newRenderCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "render",
+		Usage:     "Print a machine's rendered butane, or the data its templates see",
+		ArgsUsage: "[machine-name]",
+		Action:    e.renderCommand,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "debug-data",
+				Usage: "Print the template data (.Machine, .User, .Vars, ...) as YAML instead, secrets redacted",
+			},
+			&cli.BoolFlag{
+				Name:  "trace",
+				Usage: "Report the templates and template functions executed, and the line a template failed on, to stderr",
+			},
+		},
+	}
+}
+
+func (e *env) renderCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago render [flags] [machine-name]", 1)
+	}
+	machineName := ctx.Args().Get(0)
+
+	builder, err := e.newBuilder()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error creating builder: %v", err), 1)
+	}
+	if ctx.Bool("trace") {
+		builder.TraceRendering(e.stderr)
+	}
+
+	if ctx.Bool("debug-data") {
+		data, err := builder.DebugData(machineName)
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		out, err := butane.MarshalDebugData(data)
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		fmt.Fprint(e.stdout, out)
+		return nil
+	}
+
+	rendered, _, err := builder.RenderMachine(machineName, false)
+	if rendered != "" {
+		fmt.Fprint(e.stdout, rendered)
+	}
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	return nil
+}
+
+// newIgniteCommand returns iago ignite
+func newIgniteCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "ignite",
+		Aliases:   []string{"gen"},
+		Usage:     "Generate ignition file for an existing machine",
+		ArgsUsage: "[machine-name]",
+		Before:    e.useEnvironment,
+		Action:    e.igniteCommand,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Output file path (defaults to output/ignition/<machine-name>.ign, or output/ignition/<env>/ with --env)",
+			},
+			&cli.BoolFlag{
+				Name:    "strict",
+				Aliases: []string{"s"},
+				Value:   true,
+				Usage:   "Enable strict mode (treat warnings as errors)",
+			},
+			&cli.BoolFlag{
+				Name:  "pin-sources",
+				Usage: "Resolve remote source: URLs in storage.files, inject verification hashes and check them against iago.lock",
+			},
+			&cli.BoolFlag{
+				Name:  "update-lock",
+				Usage: "With --pin-sources, accept changed remote content and update iago.lock",
+			},
+			&cli.BoolFlag{
+				Name:  "frozen",
+				Usage: "Use only the inputs 'iago freeze' recorded, fetching nothing, so the output is byte-identical on every run",
+			},
+			&cli.StringFlag{
+				Name:  "sign-key",
+				Usage: "Minisign secret key used to write <output>.minisig (overrides [signing] ignition_key)",
+			},
+			&cli.BoolFlag{
+				Name:  "resolve-conflicts",
+				Usage: "Ask which version wins when snippets or hardening controls redefine a file, unit or user, and record the answer in machines/<name>/resolutions.toml",
+			},
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Generate ignition for every machine into output/ignition",
+			},
+			&cli.StringFlag{
+				Name:  "site",
+				Usage: "Generate ignition for every machine in this site into output/ignition",
+			},
+			&cli.StringFlag{
+				Name:  "group",
+				Usage: "Generate ignition for machines in this group",
+			},
+			&cli.StringSliceFlag{
+				Name:  "tag",
+				Usage: "Generate ignition for machines with this tag (repeat to require several)",
+			},
+			&cli.StringFlag{
+				Name:  "summary-file",
+				Usage: "Append a markdown summary of the results to this file (e.g. $GITHUB_STEP_SUMMARY)",
+			},
+			&cli.StringFlag{
+				Name:  "env",
+				Usage: "Merge config/defaults.<env>.toml over the defaults and write into output/ignition/<env>",
+			},
+		},
+	}
+}
+
+// ignitionDir is where ignite writes ignition files; each --env gets its own
+// directory so staging files never replace the ones other commands use
+func (e *env) ignitionDir() string {
+	if e.environment == "" {
+		return "output/ignition"
+	}
+	return filepath.Join("output/ignition", e.environment)
+}
+
+// ignitionFile returns where ignite writes a machine's ignition
+func (e *env) ignitionFile(machineName string) string {
+	return filepath.Join(e.ignitionDir(), machineName+".ign")
+}
+
+// storePath returns the workstation store of the repository e works in
+func (e *env) storePath() string {
+	return filepath.Join(e.newLoader().Root(), store.DefaultPath)
+}
+
+func (e *env) igniteCommand(ctx *cli.Context) error {
+	fleetMode := ctx.Bool("all") || !machineSelector(ctx).IsZero()
+	if fleetMode {
+		if ctx.NArg() != 0 {
+			return exitWithError("Error: --all, --site, --group and --tag take no machine name. Usage: iago ignite --all|--site [site]|--group [group]|--tag [tag] [flags]", 1)
+		}
+	} else if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name) or use --all flag. Usage: iago ignite [flags] [machine-name]", 1)
+	}
+
+	builder, err := e.newBuilder()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error creating builder: %v", err), 1)
+	}
+
+	if ctx.Bool("frozen") {
+		if ctx.Bool("update-lock") {
+			return exitWithError("Error: --frozen uses the pins in iago.lock as they are; re-run 'iago freeze' instead of --update-lock", 1)
+		}
+		db, err := store.Open(e.storePath())
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		defer db.Close()
+		builder.EnableFrozen(lock.DefaultPath, db)
+	} else if ctx.Bool("pin-sources") {
+		builder.EnableSourcePinning(lock.DefaultPath, ctx.Bool("update-lock"))
+	}
+
+	if ctx.IsSet("sign-key") {
+		builder.EnableSigning(ctx.String("sign-key"))
+	}
+
+	if ctx.Bool("resolve-conflicts") {
+		builder.ResolveConflicts(e.promptConflict)
+	}
+
+	if e.environment != "" {
+		fmt.Fprintf(e.stderr, "Environment: %s (%s)\n", e.environment, machine.EnvironmentPath(e.environment))
+	}
+
+	strictMode := ctx.Bool("strict")
+	if fleetMode {
+		return igniteAll(ctx, builder, e.ignitionDir(), strictMode)
+	}
+
+	machineName := ctx.Args().Get(0)
+	outputFile := ctx.String("output")
+
+	// If no output file specified, use machine name
+	if outputFile == "" {
+		outputFile = e.ignitionFile(machineName)
+	}
+
+	if err := builder.GenerateMachineWithOptions(machineName, outputFile, strictMode); err != nil {
+		return exitWithError(fmt.Sprintf("Error generating machine: %v", err), 1)
+	}
+
+	fmt.Fprintf(e.stdout, "Generated ignition for %s -> %s\n", machineName, outputFile)
+	if _, err := os.Stat(outputFile + signing.SignatureExt); err == nil {
+		fmt.Fprintf(e.stdout, "Signed -> %s%s\n", outputFile, signing.SignatureExt)
+	}
+	return nil
+}
+
+// promptConflict shows both versions of a conflicting entry and asks which wins
+func (e *env) promptConflict(c butane.Conflict) (string, error) {
+	fmt.Fprintf(e.stdout, "\n%s: %s %s is defined differently\n", c.Machine, c.Section, c.Key)
+	fmt.Fprintf(e.stdout, "\n[1] %s:\n%s\n[2] %s:\n%s\n", c.Base, c.BaseYAML, c.Other, c.OtherYAML)
+	for {
+		fmt.Fprintf(e.stdout, "Keep which version? [1/2] ")
+		var response string
+		if _, err := fmt.Scanln(&response); err != nil && response == "" {
+			if errors.Is(err, io.EOF) {
+				return "", fmt.Errorf("no answer for conflict on %s %s", c.Section, c.Key)
+			}
+			continue
+		}
+		switch response {
+		case "1":
+			return c.Base, nil
+		case "2":
+			return c.Other, nil
+		}
+	}
+}
+
+// igniteAll generates every machine and reports the results
+func igniteAll(ctx *cli.Context, builder *build.Builder, outputDir string, strictMode bool) error {
+	selector := machineSelector(ctx)
+	if site := selector.Site; site != "" && !slices.Contains(builder.Sites(), site) {
+		return exitWithError(fmt.Sprintf("Error: unknown site '%s' (no %s/%s.toml)", site, machine.SitesDir, site), 1)
+	}
+	results, err := builder.BuildAll(build.BuildOptions{OutputDir: outputDir, StrictMode: strictMode, Machines: selector})
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	title := "iago ignite --all"
+	if !selector.IsZero() {
+		title = "iago ignite " + selector.String()
+	}
+	report := summary.New(title)
+	for _, r := range results {
+		if r.Err != nil {
+			report.Add(r.Name, summary.StatusFailed, r.Err.Error(), "")
+			continue
+		}
+		report.Add(r.Name, summary.StatusOK, "", r.OutputFile)
+	}
+	if err := writeSummary(ctx, report); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	if report.Failed() {
+		return exitWithError("Some machines failed to generate", 1)
+	}
+	return nil
+}
+
+// newVerifyIgnitionCommand returns iago verify-ignition
+func newVerifyIgnitionCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "verify-ignition",
+		Usage:     "Verify an ignition file against its minisign signature",
+		ArgsUsage: "[file.ign]",
+		Action:    e.verifyIgnitionCommand,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "public-key",
+				Usage: "Minisign public key (defaults to [signing] ignition_public_key)",
+			},
+			&cli.StringFlag{
+				Name:  "signature",
+				Usage: "Signature file (defaults to <file>.minisig)",
+			},
+		},
+	}
+}
+
+func (e *env) verifyIgnitionCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (ignition file). Usage: iago verify-ignition [flags] [file.ign]", 1)
+	}
+	file := ctx.Args().Get(0)
+
+	publicKeyPath := ctx.String("public-key")
+	if publicKeyPath == "" {
+		loader := e.newLoader()
+		if err := loader.LoadDefaults(); err != nil {
+			return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+		}
+		publicKeyPath = loader.GetDefaults().Signing.IgnitionPublicKey
+	}
+	if publicKeyPath == "" {
+		return exitWithError("Error: no public key; pass --public-key or set [signing] ignition_public_key in defaults.toml", 1)
+	}
+
+	signaturePath := ctx.String("signature")
+	if signaturePath == "" {
+		signaturePath = file + signing.SignatureExt
+	}
+
+	publicKey, err := signing.LoadPublicKey(publicKeyPath)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error reading %s: %v", file, err), 1)
+	}
+	signature, err := os.ReadFile(signaturePath)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error reading signature %s: %v", signaturePath, err), 1)
+	}
+
+	comment, err := publicKey.Verify(content, signature)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("✗ %s: %v", file, err), 1)
+	}
+	fmt.Fprintf(e.stdout, "✓ %s: signature valid (key %s)\n", file, signing.KeyIDString(publicKey.ID))
+	fmt.Fprintf(e.stdout, "  trusted comment: %s\n", comment)
+	return nil
+}
+
+// newVerifyOutputsCommand returns iago verify-outputs
+func newVerifyOutputsCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "verify-outputs",
+		Usage:     "Check generated ignition files against output SHA256SUMS before shipping them",
+		ArgsUsage: "[dir]",
+		Action:    e.verifyOutputsCommand,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "public-key",
+				Usage: "Minisign public key for SHA256SUMS.minisig (defaults to [signing] ignition_public_key)",
+			},
+		},
+	}
+}
+
+func (e *env) verifyOutputsCommand(ctx *cli.Context) error {
+	dir := e.ignitionDir()
+	if ctx.NArg() == 1 {
+		dir = ctx.Args().Get(0)
+	}
+
+	publicKeyPath := ctx.String("public-key")
+	if publicKeyPath == "" {
+		loader := e.newLoader()
+		if err := loader.LoadDefaults(); err == nil {
+			publicKeyPath = loader.GetDefaults().Signing.IgnitionPublicKey
+		}
+	}
+
+	sumsPath := filepath.Join(dir, build.ChecksumsFile)
+	if publicKeyPath != "" {
+		publicKey, err := signing.LoadPublicKey(publicKeyPath)
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		content, err := os.ReadFile(sumsPath)
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error reading %s: %v", sumsPath, err), 1)
+		}
+		signature, err := os.ReadFile(sumsPath + signing.SignatureExt)
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error reading signature %s: %v", sumsPath+signing.SignatureExt, err), 1)
+		}
+		if _, err := publicKey.Verify(content, signature); err != nil {
+			return exitWithError(fmt.Sprintf("✗ %s: %v", sumsPath, err), 1)
+		}
+		fmt.Fprintf(e.stdout, "✓ %s: signature valid (key %s)\n", sumsPath, signing.KeyIDString(publicKey.ID))
+	}
+
+	problems, err := build.VerifyChecksums(dir)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Fprintf(e.stdout, "✗ %s\n", problem)
+		}
+		return exitWithError(fmt.Sprintf("%d output problem(s) found", len(problems)), 1)
+	}
+	fmt.Fprintf(e.stdout, "✓ %s: all ignition files match %s\n", dir, build.ChecksumsFile)
+	return nil
+}
+
+// newKeygenCommand returns iago keygen
+func newKeygenCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "keygen",
+		Usage:     "Generate an unencrypted minisign key pair for signing ignition files",
+		ArgsUsage: "[prefix]",
+		Action:    e.keygenCommand,
+	}
+}
+
+func (e *env) keygenCommand(ctx *cli.Context) error {
+	prefix := "iago-ignition"
+	if ctx.NArg() == 1 {
+		prefix = ctx.Args().Get(0)
+	}
+
+	for _, path := range []string{prefix + ".key", prefix + ".pub"} {
+		if _, err := os.Stat(path); err == nil {
+			return exitWithError(fmt.Sprintf("Error: %s already exists", path), 1)
+		}
+	}
+
+	publicKey, secretKey, err := signing.GenerateKey()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if err := os.WriteFile(prefix+".key", secretKey, 0600); err != nil {
+		return exitWithError(fmt.Sprintf("Error writing secret key: %v", err), 1)
+	}
+	if err := os.WriteFile(prefix+".pub", publicKey, 0644); err != nil {
+		return exitWithError(fmt.Sprintf("Error writing public key: %v", err), 1)
+	}
+
+	fmt.Fprintf(e.stdout, "Wrote %s.key (keep secret, don't commit) and %s.pub\n", prefix, prefix)
+	fmt.Fprintln(e.stdout, "For a password-protected key use: minisign -G -p iago-ignition.pub -s iago-ignition.key")
+	return nil
+}
+
+// newHistoryCommand returns iago history and its subcommands
+func newHistoryCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:  "history",
+		Usage: "Inspect archived ignition generations",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "list",
+				Aliases:   []string{"ls"},
+				Usage:     "List archived generations of a machine",
+				ArgsUsage: "[machine-name]",
+				Action:    e.historyListCommand,
+			},
+			{
+				Name:      "show",
+				Usage:     "Print the ignition a machine was provisioned with at a point in time",
+				ArgsUsage: "[machine-name]",
+				Action:    e.historyShowCommand,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "at",
+						Usage: "Show the newest generation at or before this time (RFC 3339 or 2006-01-02[T15:04]; defaults to now)",
+					},
+					&cli.BoolFlag{
+						Name:  "butane",
+						Usage: "Print the final butane instead of the ignition",
+					},
+					&cli.BoolFlag{
+						Name:  "metadata",
+						Usage: "Print the generation's metadata instead of its content",
+					},
+				},
+			},
+		},
+	}
+}
+
+func (e *env) historyListCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago history list [machine-name]", 1)
+	}
+	machineName := ctx.Args().Get(0)
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	dir, _ := archive.Retention(loader.GetDefaults().Output)
+
+	gens, err := archive.List(dir, machineName)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if len(gens) == 0 {
+		fmt.Fprintf(e.stdout, "No archived generations for %s in %s\n", machineName, dir)
+		return nil
+	}
+
+	for _, gen := range gens {
+		line := fmt.Sprintf("%s  %-8s", gen.Time.Format(time.RFC3339), humanize.Since(gen.Time))
+		if meta, err := gen.Metadata(); err == nil {
+			if meta.User != "" {
+				line += "  " + meta.User
+			}
+			if meta.GitCommit != "" {
+				commit := meta.GitCommit
+				if len(commit) > 12 {
+					commit = commit[:12]
+				}
+				if meta.GitDirty {
+					commit += "-dirty"
+				}
+				line += "  " + commit
+			}
+		}
+		fmt.Fprintln(e.stdout, strings.TrimRight(line, " "))
+	}
+	return nil
+}
+
+func (e *env) historyShowCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago history show [--at time] [machine-name]", 1)
+	}
+	machineName := ctx.Args().Get(0)
+
+	at := time.Now()
+	if ctx.IsSet("at") {
+		parsed, err := archive.ParseTime(ctx.String("at"))
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		at = parsed
+	}
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	dir, _ := archive.Retention(loader.GetDefaults().Output)
+
+	gen, err := archive.Find(dir, machineName, at)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	if ctx.Bool("metadata") {
+		meta, err := gen.Metadata()
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		data, err := json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		fmt.Fprintln(e.stdout, string(data))
+		return nil
+	}
+
+	name := machineName + ".ign"
+	if ctx.Bool("butane") {
+		name = machineName + "-final-butane.yaml"
+	}
+	content, err := gen.ReadFile(name)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	fmt.Fprintf(e.stderr, "# %s generation %s\n", machineName, gen.Time.Format(time.RFC3339))
+	e.stdout.Write(content)
+	return nil
+}
+
+// newGCCommand returns iago gc and its subcommands
+func newGCCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:  "gc",
+		Usage: "Remove generated artifacts beyond the configured retention",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "output",
+				Usage:  "Prune archived ignition generations to the newest [output] keep per machine",
+				Action: e.gcOutputCommand,
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "keep",
+						Usage: "Generations to keep per machine (overrides [output] keep)",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Show what would be removed without removing it",
+					},
+				},
+			},
+		},
+	}
+}
+
+func (e *env) gcOutputCommand(ctx *cli.Context) error {
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	dir, keep := archive.Retention(loader.GetDefaults().Output)
+	if ctx.IsSet("keep") {
+		keep = ctx.Int("keep")
+	}
+
+	machines, err := archive.Machines(dir)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	dryRun := ctx.Bool("dry-run")
+	removed := 0
+	for _, name := range machines {
+		expired, err := archive.Prune(dir, name, keep, dryRun)
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		for _, gen := range expired {
+			if dryRun {
+				fmt.Fprintf(e.stdout, "Would remove %s\n", gen.Path)
+			} else {
+				fmt.Fprintf(e.stdout, "Removed %s\n", gen.Path)
+			}
+		}
+		removed += len(expired)
+	}
+
+	if removed == 0 {
+		fmt.Fprintf(e.stdout, "Nothing to remove; keeping %d generation(s) per machine in %s\n", keep, dir)
+	}
+	return nil
+}
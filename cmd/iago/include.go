@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/urfave/cli/v2"
+)
+
+// newIncludeCommand returns iago include
+func newIncludeCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:  "include",
+		Usage: "Manage the repositories whose machines and containers [[include]] overlays on this one",
+		Subcommands: []*cli.Command{
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Usage:   "List includes with the machines and containers each contributes",
+				Action:  e.includeListCommand,
+			},
+			{
+				Name:   "sync",
+				Usage:  "Clone or fetch git includes into .iago/include/ and check out their ref",
+				Action: e.includeSyncCommand,
+			},
+		},
+	}
+}
+
+func (e *env) includeListCommand(ctx *cli.Context) error {
+	loader := e.newLoader()
+	if err := loader.LoadAll(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading configuration: %v", err), 1)
+	}
+	includes := loader.GetDefaults().Include
+	if len(includes) == 0 {
+		fmt.Fprintln(e.stdout, "No includes; add [[include]] entries to config/defaults.toml")
+		return nil
+	}
+
+	for _, include := range includes {
+		source := include.Path
+		if include.Git != "" {
+			source = include.Git
+			if include.Ref != "" {
+				source += "@" + include.Ref
+			}
+		}
+		fmt.Fprintf(e.stdout, "%s (%s)\n", include.Name, source)
+
+		var machines, containers []string
+		for _, m := range loader.GetMachines() {
+			if m.Include == include.Name {
+				machines = append(machines, m.Name)
+			}
+		}
+		prefix := filepath.ToSlash(include.Root()) + "/"
+		for _, w := range loader.GetWorkloads() {
+			if strings.HasPrefix(w.BootcSource, prefix) {
+				containers = append(containers, w.Name)
+			}
+		}
+		fmt.Fprintf(e.stdout, "  Machines:   %s\n", listOrNone(machines))
+		fmt.Fprintf(e.stdout, "  Containers: %s\n", listOrNone(containers))
+	}
+	return nil
+}
+
+func (e *env) includeSyncCommand(ctx *cli.Context) error {
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+
+	synced := 0
+	for _, include := range loader.GetDefaults().Include {
+		if include.Git == "" {
+			fmt.Fprintf(e.stdout, "  - %s: %s (path, nothing to sync)\n", include.Name, include.Path)
+			continue
+		}
+		commit, err := machine.SyncInclude(ctx.Context, loader.Root(), include)
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error syncing %s: %v", include.Name, err), 1)
+		}
+		fmt.Fprintf(e.stdout, "  ✓ %s: %s at %s\n", include.Name, include.Root(), shortCommit(commit))
+		synced++
+	}
+	if synced > 0 {
+		fmt.Fprintln(e.stdout, "\nReview the included machines with 'iago include list' and 'iago validate'")
+	}
+	return nil
+}
+
+// listOrNone joins names with commas, or returns "none"
+func listOrNone(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// shortCommit abbreviates a git commit hash
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
@@ -0,0 +1,70 @@
+//go:build lite
+
+// iago-lite is built with -tags lite. It generates and deploys ignition
+// without the container builder, registry client or secret providers, so the
+// op and secret template functions and [container_registry.host_auth] report
+// that they aren't configured.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/andreweick/iago/internal/bundle"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/urfave/cli/v2"
+)
+
+// containerCommands has nothing to add in iago-lite
+func containerCommands(e *env) []*cli.Command {
+	return nil
+}
+
+// provisionCommands has nothing to add in iago-lite, which can't resolve the API token
+func provisionCommands(e *env) []*cli.Command {
+	return nil
+}
+
+// validateSecrets notes that iago-lite can't check secret references
+func (e *env) validateSecrets(defaults machine.Defaults, check func(name string, err error)) {
+	fmt.Fprintln(e.stderr, "Note: iago-lite doesn't check [secrets] references or registry host_auth")
+}
+
+// githubToken takes the token from --token or GITHUB_TOKEN only
+func githubToken(ctx *cli.Context, defaults machine.Defaults) (string, error) {
+	if token := ctx.String("token"); token != "" {
+		return token, nil
+	}
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		return token, nil
+	}
+	return "", errors.New("set --token or GITHUB_TOKEN")
+}
+
+// resolveImageDigest can't reach registries in iago-lite, so freeze records no digest
+func (e *env) resolveImageDigest(ctx *cli.Context, defaults machine.Defaults, image string) (string, error) {
+	fmt.Fprintf(e.stderr, "Note: iago-lite doesn't resolve image digests; %s isn't pinned\n", image)
+	return "", nil
+}
+
+// doctorRegistry has no registry client to check with in iago-lite
+func doctorRegistry(ctx *cli.Context, d *doctor, defaults machine.Defaults) {
+	d.skip("registry", "iago-lite has no registry client")
+}
+
+// doctorSecrets has no secret providers to check in iago-lite
+func doctorSecrets(ctx *cli.Context, d *doctor, defaults machine.Defaults) {
+	d.skip("secret provider", "iago-lite has no secret providers")
+}
+
+// saveBundleImages has no registry client to pull with in iago-lite
+func saveBundleImages(ctx *cli.Context, defaults machine.Defaults, refs []string, dir string) ([]bundle.Image, error) {
+	return nil, errors.New("iago-lite can't pull images; export the bundle without --images")
+}
+
+// pushBundleImages has no registry client to push with in iago-lite
+func pushBundleImages(ctx *cli.Context, defaults machine.Defaults, images []bundle.Image, dir string) error {
+	return errors.New("iago-lite can't push images; push them with 'iago push <workload> --from oci:<dir>' from the full iago")
+}
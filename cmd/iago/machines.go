@@ -0,0 +1,1102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andreweick/iago/internal/agecrypt"
+	"github.com/andreweick/iago/internal/butane"
+	"github.com/andreweick/iago/internal/fleet"
+	"github.com/andreweick/iago/internal/humanize"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/scaffold"
+	"github.com/andreweick/iago/internal/signing"
+	"github.com/urfave/cli/v2"
+)
+
+// newInitCommand returns iago init
+func newInitCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "init",
+		Aliases:   []string{"i"},
+		Usage:     "Initialize a new machine: create config, container scaffold, and ignition file",
+		ArgsUsage: "[machine-name]",
+		Action:    e.initCommand,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "domain",
+				Aliases: []string{"d"},
+				Value:   "spouterinn.org",
+				Usage:   "Domain suffix for FQDN",
+			},
+			&cli.BoolFlag{
+				Name:  "generate-mac",
+				Value: true,
+				Usage: "Generate MAC address for homelab machines",
+			},
+			&cli.BoolFlag{
+				Name:    "machine-only",
+				Aliases: []string{"m"},
+				Usage:   "Create only machine configuration (config, template, ignition)",
+			},
+			&cli.BoolFlag{
+				Name:    "container-only",
+				Aliases: []string{"c"},
+				Usage:   "Create only container scaffold (directory, Containerfile, prompt)",
+			},
+			&cli.StringFlag{
+				Name:  "role",
+				Usage: "Container scaffold for a kind of workload: web, db or cache (Containerfile, config, tests)",
+			},
+			&cli.StringFlag{
+				Name:  "template",
+				Usage: "Container scaffold for an application: caddy, compose, immich, postgres or wireguard (see iago scaffold templates)",
+			},
+		},
+	}
+}
+
+func (e *env) initCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago init [flags] [machine-name]", 1)
+	}
+
+	machineName := ctx.Args().Get(0)
+	domain := ctx.String("domain")
+	generateMAC := ctx.Bool("generate-mac")
+	machineOnly := ctx.Bool("machine-only")
+	containerOnly := ctx.Bool("container-only")
+
+	// Validate flag combinations
+	if machineOnly && containerOnly {
+		return exitWithError("Error: --machine-only and --container-only flags are mutually exclusive", 1)
+	}
+	role := ctx.String("role")
+	if role != "" {
+		if machineOnly {
+			return exitWithError("Error: --role only applies to the container scaffold and can't be used with --machine-only", 1)
+		}
+		if _, err := scaffold.GetRole(role); err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+	}
+	template := ctx.String("template")
+	if template != "" {
+		if machineOnly {
+			return exitWithError("Error: --template only applies to the container scaffold and can't be used with --machine-only", 1)
+		}
+		if role != "" {
+			return exitWithError("Error: --role and --template are mutually exclusive", 1)
+		}
+		if _, err := scaffold.GetTemplate(template); err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+	}
+
+	// Load defaults to get MAC prefix
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+
+	defaults := loader.GetDefaults()
+
+	// Refuse to bake placeholder or plaintext passwords into a new machine
+	if !containerOnly {
+		if errs := machine.ValidatePasswordHashes(defaults); len(errs) > 0 {
+			msg := "Error: unusable password hashes in defaults:"
+			for _, err := range errs {
+				msg += "\n  " + err.Error()
+			}
+			return exitWithError(msg, 1)
+		}
+	}
+
+	// Generate MAC if requested (only needed for machine config)
+	var macAddress string
+	if generateMAC && !containerOnly {
+		var err error
+		macAddress, err = machine.GenerateMAC(machine.DefaultMACPrefix)
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error generating MAC address: %v", err), 1)
+		}
+	}
+
+	// Generate FQDN using machine name (only needed for machine config)
+	var fqdn string
+	if !containerOnly {
+		fqdn = fmt.Sprintf("%s.%s", machineName, domain)
+	}
+
+	// Create scaffolder
+	scaffolder := scaffold.NewScaffolder(defaults)
+
+	// Prepare scaffold options
+	opts := scaffold.ScaffoldOptions{
+		MachineName: machineName,
+		FQDN:        fqdn,
+		MACAddress:  macAddress,
+		OutputDir:   e.ignitionDir(),
+		Role:        role,
+		Template:    template,
+	}
+
+	// Display what will be created
+	fmt.Fprintf(e.stdout, "Initializing machine: %s\n", machineName)
+	if !containerOnly {
+		fmt.Fprintf(e.stdout, "  FQDN: %s\n", fqdn)
+		if macAddress != "" {
+			fmt.Fprintf(e.stdout, "  MAC Address: %s\n", macAddress)
+		}
+	}
+	if role != "" {
+		fmt.Fprintf(e.stdout, "  Container role: %s\n", role)
+	}
+	if template != "" {
+		fmt.Fprintf(e.stdout, "  Container template: %s\n", template)
+	}
+
+	fmt.Fprintf(e.stdout, "\nCreating:\n")
+
+	var err error
+	switch {
+	case containerOnly:
+		fmt.Fprintf(e.stdout, "  ✓ Container scaffold: containers/%s/\n", machineName)
+		err = scaffolder.CreateContainerScaffoldOnly(opts)
+	case machineOnly:
+		fmt.Fprintf(e.stdout, "  ✓ Machine config: machines/%s/machine.toml\n", machineName)
+		fmt.Fprintf(e.stdout, "  ✓ Butane template: machines/%s/butane.yaml.tmpl\n", machineName)
+		fmt.Fprintf(e.stdout, "  ✓ Ignition file: %s\n", e.ignitionFile(machineName))
+		err = scaffolder.CreateMachineConfigOnly(opts)
+	default:
+		// Default behavior: create both
+		fmt.Fprintf(e.stdout, "  ✓ Container scaffold: containers/%s/\n", machineName)
+		fmt.Fprintf(e.stdout, "  ✓ Machine config: machines/%s/machine.toml\n", machineName)
+		fmt.Fprintf(e.stdout, "  ✓ Butane template: machines/%s/butane.yaml.tmpl\n", machineName)
+		fmt.Fprintf(e.stdout, "  ✓ Ignition file: %s\n", e.ignitionFile(machineName))
+		err = scaffolder.CreateMachineScaffold(opts)
+	}
+
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error creating scaffold: %v", err), 1)
+	}
+
+	// Generate ignition file (only for machine-only and default modes)
+	if !containerOnly {
+		builder, err := e.newBuilder()
+		if err != nil {
+			fmt.Fprintf(e.stdout, "Warning: Could not generate ignition file: %v\n", err)
+		} else {
+			outputFile := e.ignitionFile(machineName)
+			if err := builder.GenerateMachine(machineName, outputFile); err != nil {
+				fmt.Fprintf(e.stdout, "Warning: Could not generate ignition file: %v\n", err)
+			}
+		}
+	}
+
+	// Success message and next steps
+	fmt.Fprintf(e.stdout, "\n🎉 Machine '%s' initialized successfully!\n", machineName)
+	fmt.Fprintf(e.stdout, "\nNext steps:\n")
+
+	switch {
+	case containerOnly:
+		fmt.Fprintf(e.stdout, "1. Customize the container: containers/%s/\n", machineName)
+		fmt.Fprintf(e.stdout, "2. Build the container: iago container build %s\n", machineName)
+		fmt.Fprintf(e.stdout, "3. Create machine config: iago init --machine-only %s\n", machineName)
+	case machineOnly:
+		fmt.Fprintf(e.stdout, "1. Create container scaffold: iago init --container-only %s\n", machineName)
+		fmt.Fprintf(e.stdout, "2. Build the container: iago container build %s\n", machineName)
+		fmt.Fprintf(e.stdout, "3. Use ignition file: %s\n", e.ignitionFile(machineName))
+	default:
+		fmt.Fprintf(e.stdout, "1. Customize the container: containers/%s/\n", machineName)
+		fmt.Fprintf(e.stdout, "2. Build the container: iago container build %s\n", machineName)
+		fmt.Fprintf(e.stdout, "3. Use ignition file: %s\n", e.ignitionFile(machineName))
+	}
+
+	return nil
+}
+
+// newAdoptCommand returns iago adopt
+func newAdoptCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "adopt",
+		Usage:     "Generate machine and container scaffolds for a host provisioned outside iago",
+		ArgsUsage: "[fqdn]",
+		Action:    e.adoptCommand,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "name",
+				Usage: "Machine name (defaults to the first label of the FQDN)",
+			},
+			&cli.StringFlag{
+				Name:  "user",
+				Usage: "SSH user on the host (defaults to [user] username, e.g. core)",
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "Give up on the host after this long",
+				Value: fleet.DefaultTimeout,
+			},
+			&cli.BoolFlag{
+				Name:  "insecure-ignore-host-key",
+				Usage: "Don't check the host key against ~/.ssh/known_hosts",
+			},
+		},
+	}
+}
+
+func (e *env) adoptCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (the host's FQDN). Usage: iago adopt [flags] [fqdn]", 1)
+	}
+	host := ctx.Args().Get(0)
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	defaults := loader.GetDefaults()
+
+	user := ctx.String("user")
+	if user == "" {
+		user = defaults.User.Username
+	}
+	client, err := fleet.NewClient(fleet.ClientOptions{
+		User:                  user,
+		GitHubUsername:        defaults.User.GitHubUsername,
+		Timeout:               ctx.Duration("timeout"),
+		InsecureIgnoreHostKey: ctx.Bool("insecure-ignore-host-key"),
+	})
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	fmt.Fprintf(e.stdout, "Inspecting %s...\n", host)
+	info, err := fleet.Inspect(ctx.Context, client, host)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	// Given an address, the host's own name becomes the FQDN
+	fqdn := host
+	if net.ParseIP(host) != nil {
+		fqdn = info.Hostname
+		if info.IPAddress == "" {
+			info.IPAddress = host
+		}
+	}
+	machineName := ctx.String("name")
+	if machineName == "" {
+		machineName, _, _ = strings.Cut(fqdn, ".")
+	}
+	if machineName == "" {
+		return exitWithError("Error: could not work out a machine name; pass --name", 1)
+	}
+
+	files := make(map[string][]byte)
+	for name, content := range info.Units {
+		files["units/"+name] = content
+	}
+	for name, content := range info.Quadlets {
+		files["quadlets/"+name] = content
+	}
+	notes := info.Notes(machineName, fqdn)
+
+	scaffolder := scaffold.NewScaffolder(defaults)
+	err = scaffolder.CreateAdoptedScaffold(scaffold.AdoptOptions{
+		ScaffoldOptions: scaffold.ScaffoldOptions{
+			MachineName: machineName,
+			FQDN:        fqdn,
+			MACAddress:  info.MACAddress,
+			OutputDir:   e.ignitionDir(),
+		},
+		IPAddress:        info.IPAddress,
+		NetworkInterface: info.NetworkInterface,
+		BaseImage:        info.Image,
+		Files:            files,
+		Notes:            notes,
+	})
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error creating scaffold: %v", err), 1)
+	}
+
+	fmt.Fprintf(e.stdout, "\nAdopted %s as %s\n", fqdn, machineName)
+	fmt.Fprintf(e.stdout, "  OS: %s\n", orDash(info.OS))
+	fmt.Fprintf(e.stdout, "  Image: %s\n", orDash(info.Image))
+	fmt.Fprintf(e.stdout, "  Address: %s on %s (%s)\n", orDash(info.IPAddress), orDash(info.NetworkInterface), orDash(info.MACAddress))
+	fmt.Fprintf(e.stdout, "\nCreated:\n")
+	fmt.Fprintf(e.stdout, "  ✓ Machine config: machines/%s/machine.toml (adopted = true)\n", machineName)
+	fmt.Fprintf(e.stdout, "  ✓ Butane template: machines/%s/butane.yaml.tmpl\n", machineName)
+	if len(files) > 0 {
+		fmt.Fprintf(e.stdout, "  ✓ %d unit(s) and quadlet(s) copied to machines/%s/adopted/\n", len(files), machineName)
+	}
+	fmt.Fprintf(e.stdout, "  ✓ Container scaffold: containers/%s/\n", machineName)
+	fmt.Fprintf(e.stdout, "\n%d item(s) to reconcile by hand: see machines/%s/ADOPTED.md\n", len(notes), machineName)
+	return nil
+}
+
+// newListCommand returns iago list
+func newListCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:    "list",
+		Aliases: []string{"ls"},
+		Usage:   "List all configured machines",
+		Action:  e.listCommand,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "site",
+				Usage: "Only list machines in this site",
+			},
+			&cli.StringFlag{
+				Name:  "group",
+				Usage: "Only list machines in this group",
+			},
+			&cli.StringSliceFlag{
+				Name:  "tag",
+				Usage: "Only list machines with this tag (repeat to require several)",
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "Output format: table, json or yaml",
+			},
+			&cli.BoolFlag{
+				Name:  "wide",
+				Usage: "Also show each machine's site, group, generated ignition and image",
+			},
+		},
+	}
+}
+
+func (e *env) listCommand(ctx *cli.Context) error {
+	loader := e.newLoader()
+	// Defaults supply site domains for machines without an fqdn, but aren't required to list
+	if err := loader.LoadDefaults(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	if err := loader.LoadMachines(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading machines: %v", err), 1)
+	}
+
+	format, err := outputFormat(ctx)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	machines := loader.SelectMachines(machineSelector(ctx))
+	if format != "table" {
+		listings := make([]machineListing, 0, len(machines))
+		for _, m := range machines {
+			listings = append(listings, machineListing{
+				Name:             m.Name,
+				FQDN:             m.FQDN,
+				Site:             m.Site,
+				IPAddress:        m.IPAddress,
+				MACAddress:       m.MACAddress,
+				NetworkInterface: m.NetworkInterface,
+				ContainerImage:   m.ContainerImage,
+				ContainerTag:     m.ContainerTag,
+				Group:            m.Group,
+				Tags:             m.Tags,
+				Owners:           m.Owners,
+				Protected:        m.Protected,
+				Adopted:          m.Adopted,
+			})
+		}
+		if err := writeStructured(e.stdout, format, listings); err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		return nil
+	}
+	if len(machines) == 0 {
+		fmt.Fprintln(e.stdout, "No machines configured")
+		return nil
+	}
+
+	wide := ctx.Bool("wide")
+	if wide {
+		fmt.Fprintf(e.stdout, "%-18s %-27s %-18s %-12s %-10s %-10s %-22s %s\n", "NAME", "FQDN", "MAC ADDRESS", "INTERFACE", "SITE", "GROUP", "IGNITION", "IMAGE")
+		fmt.Fprintln(e.stdout, "------------------------------------------------------------------------------------------------------------------------------------")
+	} else {
+		fmt.Fprintf(e.stdout, "%-18s %-27s %-18s %-12s\n", "NAME", "FQDN", "MAC ADDRESS", "INTERFACE")
+		fmt.Fprintln(e.stdout, "--------------------------------------------------------------------------------------")
+	}
+	for _, machine := range machines {
+		macAddress := machine.MACAddress
+		if macAddress == "" {
+			macAddress = "-"
+		}
+		networkInterface := machine.NetworkInterface
+		if networkInterface == "" {
+			networkInterface = "-"
+		}
+		if !wide {
+			fmt.Fprintf(e.stdout, "%-18s %-27s %-18s %-12s\n",
+				machine.Name,
+				machine.FQDN,
+				macAddress,
+				networkInterface)
+			continue
+		}
+
+		image := machine.ContainerImage
+		if image != "" && machine.ContainerTag != "" {
+			image += ":" + machine.ContainerTag
+		}
+		fmt.Fprintf(e.stdout, "%-18s %-27s %-18s %-12s %-10s %-10s %-22s %s\n",
+			machine.Name,
+			machine.FQDN,
+			macAddress,
+			networkInterface,
+			orDash(machine.Site),
+			orDash(machine.Group),
+			e.ignitionSummary(machine.Name),
+			orDash(image))
+	}
+
+	return nil
+}
+
+// ignitionSummary describes a machine's generated ignition for list --wide,
+// e.g. "12 KiB, 3h ago", or "-" before it is generated
+func (e *env) ignitionSummary(machineName string) string {
+	info, err := os.Stat(e.ignitionFile(machineName))
+	if err != nil {
+		return "-"
+	}
+	return humanize.Bytes(info.Size()) + ", " + humanize.Since(info.ModTime())
+}
+
+// machineListing is a machine as list prints it for --output json|yaml
+type machineListing struct {
+	Name             string   `json:"name" yaml:"name"`
+	FQDN             string   `json:"fqdn" yaml:"fqdn"`
+	Site             string   `json:"site,omitempty" yaml:"site,omitempty"`
+	IPAddress        string   `json:"ip_address,omitempty" yaml:"ip_address,omitempty"`
+	MACAddress       string   `json:"mac_address,omitempty" yaml:"mac_address,omitempty"`
+	NetworkInterface string   `json:"network_interface,omitempty" yaml:"network_interface,omitempty"`
+	ContainerImage   string   `json:"container_image,omitempty" yaml:"container_image,omitempty"`
+	ContainerTag     string   `json:"container_tag,omitempty" yaml:"container_tag,omitempty"`
+	Group            string   `json:"group,omitempty" yaml:"group,omitempty"`
+	Tags             []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Owners           []string `json:"owners,omitempty" yaml:"owners,omitempty"`
+	Protected        bool     `json:"protected,omitempty" yaml:"protected,omitempty"`
+	Adopted          bool     `json:"adopted,omitempty" yaml:"adopted,omitempty"`
+}
+
+// newRemoveCommand returns iago rm
+func newRemoveCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "rm",
+		Aliases:   []string{"remove", "delete"},
+		Usage:     "Remove a machine and all its associated files",
+		ArgsUsage: "[machine-name]",
+		Action:    e.removeCommand,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:    "force",
+				Aliases: []string{"f"},
+				Usage:   "Skip confirmation prompt",
+			},
+			&cli.BoolFlag{
+				Name:  "i-know-what-im-doing",
+				Usage: "Allow the action on a protected machine without a second approver",
+			},
+			&cli.StringFlag{
+				Name:  "approved-by",
+				Usage: "Name of the second person approving the action on a protected machine",
+			},
+		},
+	}
+}
+
+func (e *env) removeCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago rm [flags] [machine-name]", 1)
+	}
+
+	machineName := ctx.Args().Get(0)
+	force := ctx.Bool("force")
+
+	// Load machines to verify it exists
+	loader := e.newLoader()
+	if err := loader.LoadMachines(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading machines: %v", err), 1)
+	}
+
+	// Check if machine exists
+	m, err := loader.GetMachine(machineName)
+	if err != nil {
+		if errors.Is(err, machine.ErrMachineNotFound) {
+			fmt.Fprintf(e.stdout, "Machine '%s' not found\n", machineName)
+			return nil
+		}
+		return exitWithError(fmt.Sprintf("Error checking machine: %v", err), 1)
+	}
+	if err := guardProtected(ctx, m, "rm"); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	// Show what will be removed
+	fmt.Fprintf(e.stdout, "The following will be removed:\n")
+	fmt.Fprintf(e.stdout, "  ✓ Machine config: machines/%s/\n", machineName)
+	fmt.Fprintf(e.stdout, "  ✓ Container directory: containers/%s/\n", machineName)
+	fmt.Fprintf(e.stdout, "  ✓ Ignition file: %s\n", e.ignitionFile(machineName))
+
+	// Confirm unless force flag is set
+	if !force {
+		fmt.Fprintf(e.stdout, "\nAre you sure you want to remove machine '%s'? [y/N] ", machineName)
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" && response != "Y" {
+			fmt.Fprintln(e.stdout, "Aborted")
+			return nil
+		}
+	}
+
+	// Remove from config
+	if err := loader.RemoveMachine(machineName); err != nil {
+		if errors.Is(err, machine.ErrMachineNotFound) {
+			fmt.Fprintf(e.stdout, "Machine '%s' not found in configuration\n", machineName)
+			return nil
+		}
+		return exitWithError(fmt.Sprintf("Error removing machine from config: %v", err), 1)
+	}
+
+	// Remove container directory
+	containerDir := filepath.Join(loader.Root(), machine.ContainersDir, machineName)
+	if err := os.RemoveAll(containerDir); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(e.stdout, "Warning: Could not remove container directory: %v\n", err)
+	}
+
+	// Remove machine directory
+	machineDir := filepath.Join(loader.Root(), machine.MachinesDir, machineName)
+	if err := os.RemoveAll(machineDir); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(e.stdout, "Warning: Could not remove machine directory: %v\n", err)
+	}
+
+	// Remove ignition file
+	ignitionFile := e.ignitionFile(machineName)
+	if err := os.Remove(ignitionFile); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(e.stdout, "Warning: Could not remove ignition file: %v\n", err)
+	}
+
+	fmt.Fprintf(e.stdout, "\n🗑️  Machine '%s' removed successfully!\n", machineName)
+	return nil
+}
+
+// newRenameCommand returns iago rename
+func newRenameCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "rename",
+		Aliases:   []string{"mv"},
+		Usage:     "Rename a machine, its directories and its ignition file",
+		ArgsUsage: "[old-name] [new-name]",
+		Action:    e.renameCommand,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "i-know-what-im-doing",
+				Usage: "Allow the action on a protected machine without a second approver",
+			},
+			&cli.StringFlag{
+				Name:  "approved-by",
+				Usage: "Name of the second person approving the action on a protected machine",
+			},
+		},
+	}
+}
+
+func (e *env) renameCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		return exitWithError("Error: requires exactly two arguments (old and new machine name). Usage: iago rename [flags] [old-name] [new-name]", 1)
+	}
+
+	oldName, newName := ctx.Args().Get(0), ctx.Args().Get(1)
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	if err := loader.LoadMachines(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading machines: %v", err), 1)
+	}
+
+	m, err := loader.GetMachine(oldName)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if _, err := loader.GetMachine(newName); err == nil {
+		return exitWithError(fmt.Sprintf("Error: machine '%s' already exists", newName), 1)
+	}
+	containerDir, newContainerDir := filepath.Join(loader.Root(), machine.ContainersDir, oldName), filepath.Join(loader.Root(), machine.ContainersDir, newName)
+	if _, err := os.Stat(newContainerDir); err == nil {
+		return exitWithError(fmt.Sprintf("Error: %s already exists", newContainerDir), 1)
+	}
+	identityDir := loader.GetDefaults().Secrets.IdentityDir
+	identity, newIdentity := agecrypt.IdentityPath(identityDir, oldName), agecrypt.IdentityPath(identityDir, newName)
+	if _, err := os.Stat(newIdentity); err == nil {
+		return exitWithError(fmt.Sprintf("Error: %s already exists", newIdentity), 1)
+	}
+	if err := guardProtected(ctx, m, "rename"); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	if err := loader.RenameMachine(oldName, newName); err != nil {
+		return exitWithError(fmt.Sprintf("Error renaming machine: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "  ✓ Machine config: machines/%s/ -> machines/%s/\n", oldName, newName)
+
+	// The age identity is looked up by machine name
+	if _, err := os.Stat(identity); err == nil {
+		if err := os.Rename(identity, newIdentity); err != nil {
+			return exitWithError(fmt.Sprintf("Error moving age identity: %v", err), 1)
+		}
+		fmt.Fprintf(e.stdout, "  ✓ Age identity: %s -> %s\n", identity, newIdentity)
+	}
+
+	if _, err := os.Stat(containerDir); err == nil {
+		if err := os.Rename(containerDir, newContainerDir); err != nil {
+			return exitWithError(fmt.Sprintf("Error moving container directory: %v", err), 1)
+		}
+		fmt.Fprintf(e.stdout, "  ✓ Container directory: %s/ -> %s/\n", containerDir, newContainerDir)
+	}
+
+	// The old ignition names the old host, so replace it rather than keep both
+	oldIgnition := e.ignitionFile(oldName)
+	for _, f := range []string{oldIgnition, oldIgnition + signing.SignatureExt} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(e.stdout, "Warning: Could not remove %s: %v\n", f, err)
+		}
+	}
+	builder, err := e.newBuilder()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error creating builder: %v", err), 1)
+	}
+	outputFile := e.ignitionFile(newName)
+	if err := builder.GenerateMachine(newName, outputFile); err != nil {
+		return exitWithError(fmt.Sprintf("Error generating machine: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "  ✓ Ignition file: %s\n", outputFile)
+
+	fmt.Fprintf(e.stdout, "\n✏️  Machine '%s' renamed to '%s'\n", oldName, newName)
+	return nil
+}
+
+// newEditCommand returns iago edit
+func newEditCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "edit",
+		Usage:     "Search and replace across machines' butane templates and machine.toml files",
+		ArgsUsage: "[machine-name]",
+		Action:    e.editCommand,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "replace",
+				Usage: "Replacement to make, as old=new",
+			},
+			&cli.BoolFlag{
+				Name:  "regex",
+				Usage: "Treat old as a regular expression; new may refer to groups as $1 or ${name}",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Show the diff without changing files",
+			},
+			&cli.BoolFlag{
+				Name:    "yes",
+				Aliases: []string{"y"},
+				Usage:   "Apply to every machine without asking",
+			},
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Edit every machine",
+			},
+			&cli.StringFlag{
+				Name:  "site",
+				Usage: "Edit the machines in this site",
+			},
+			&cli.StringFlag{
+				Name:  "group",
+				Usage: "Edit the machines in this group",
+			},
+			&cli.StringSliceFlag{
+				Name:  "tag",
+				Usage: "Edit the machines with this tag (repeat to require several)",
+			},
+		},
+	}
+}
+
+func (e *env) editCommand(ctx *cli.Context) error {
+	const usage = "Usage: iago edit --replace old=new [--regex] [--dry-run] [--all|--site [site]|--group [group]|--tag [tag]|machine-name]"
+	selector := machineSelector(ctx)
+	fleetMode := ctx.Bool("all") || !selector.IsZero()
+	if ctx.String("replace") == "" || (fleetMode && ctx.NArg() != 0) || (!fleetMode && ctx.NArg() != 1) {
+		return exitWithError("Error: requires --replace and one machine name or --all, --site, --group or --tag. "+usage, 1)
+	}
+	replacement, err := machine.ParseReplacement(ctx.String("replace"), ctx.Bool("regex"))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	loader := e.newLoader()
+	if err := loader.LoadAll(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading configuration: %v", err), 1)
+	}
+	machines := loader.SelectMachines(selector)
+	if !fleetMode {
+		m, err := loader.GetMachine(ctx.Args().First())
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		machines = []machine.Config{m}
+	}
+
+	edits, err := loader.PlanReplace(machines, replacement)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	for _, edit := range edits {
+		if strings.HasSuffix(edit.Path, ".tmpl") {
+			if err := butane.ParseTemplate(edit.Path, string(edit.After)); err != nil {
+				return exitWithError(fmt.Sprintf("Error: the replacement breaks %s: %v", edit.Path, err), 1)
+			}
+		}
+	}
+	if len(edits) == 0 {
+		fmt.Fprintf(e.stdout, "No matches for %q in %d machine(s)\n", replacement.Old, len(machines))
+		return nil
+	}
+
+	// Edits are ordered by machine; each machine's are confirmed together
+	applyAll := ctx.Bool("yes")
+	files, edited := 0, 0
+	for start := 0; start < len(edits); {
+		end := start
+		for end < len(edits) && edits[end].Machine == edits[start].Machine {
+			end++
+		}
+		name, group := edits[start].Machine, edits[start:end]
+		start = end
+
+		for _, edit := range group {
+			fmt.Fprint(e.stdout, edit.Diff())
+		}
+		if ctx.Bool("dry-run") {
+			files += len(group)
+			edited++
+			continue
+		}
+		if !applyAll {
+			fmt.Fprintf(e.stdout, "Apply to %s? [y/N/a(ll)/q(uit)] ", name)
+			var response string
+			fmt.Scanln(&response)
+			switch strings.ToLower(response) {
+			case "y", "yes":
+			case "a", "all":
+				applyAll = true
+			case "q", "quit":
+				fmt.Fprintln(e.stdout, "Stopped")
+				start = len(edits)
+				continue
+			default:
+				fmt.Fprintf(e.stdout, "  Skipped %s\n", name)
+				continue
+			}
+		}
+		for _, edit := range group {
+			if err := edit.Apply(); err != nil {
+				return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+			}
+			fmt.Fprintf(e.stdout, "  ✓ %s: %d replacement(s)\n", edit.Path, edit.Count)
+		}
+		files += len(group)
+		edited++
+	}
+
+	if ctx.Bool("dry-run") {
+		fmt.Fprintf(e.stdout, "\nWould change %d file(s) in %d machine(s); run without --dry-run to apply\n", files, edited)
+		return nil
+	}
+	fmt.Fprintf(e.stdout, "\nChanged %d file(s) in %d machine(s); review with 'git diff' and 'iago validate'\n", files, edited)
+	return nil
+}
+
+// newCloneCommand returns iago clone
+func newCloneCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "clone",
+		Aliases:   []string{"cp"},
+		Usage:     "Create a machine by copying an existing one",
+		ArgsUsage: "[source-name] [new-name]",
+		Action:    e.cloneCommand,
+	}
+}
+
+func (e *env) cloneCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		return exitWithError("Error: requires exactly two arguments (source and new machine name). Usage: iago clone [source-name] [new-name]", 1)
+	}
+
+	source, newName := ctx.Args().Get(0), ctx.Args().Get(1)
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	if err := loader.LoadMachines(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading machines: %v", err), 1)
+	}
+
+	sourceMachine, err := loader.GetMachine(source)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if _, err := loader.GetMachine(newName); err == nil {
+		return exitWithError(fmt.Sprintf("Error: machine '%s' already exists", newName), 1)
+	}
+	containerDir, newContainerDir := filepath.Join(loader.Root(), machine.ContainersDir, source), filepath.Join(loader.Root(), machine.ContainersDir, newName)
+	if _, err := os.Stat(newContainerDir); err == nil {
+		return exitWithError(fmt.Sprintf("Error: %s already exists", newContainerDir), 1)
+	}
+
+	if err := loader.CloneMachine(source, newName); err != nil {
+		return exitWithError(fmt.Sprintf("Error cloning machine: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "  ✓ Machine config: machines/%s/ -> machines/%s/\n", source, newName)
+
+	if _, err := os.Stat(containerDir); err == nil {
+		if err := os.CopyFS(newContainerDir, os.DirFS(containerDir)); err != nil {
+			return exitWithError(fmt.Sprintf("Error copying container directory: %v", err), 1)
+		}
+		fmt.Fprintf(e.stdout, "  ✓ Container directory: %s/ -> %s/\n", containerDir, newContainerDir)
+	}
+
+	m, err := loader.GetMachine(newName)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if m.MACAddress != "" {
+		fmt.Fprintf(e.stdout, "  MAC Address: %s\n", m.MACAddress)
+	}
+	if m.IPAddress != "" || m.VMID != 0 {
+		fmt.Fprintf(e.stdout, "Warning: ip_address and vm_id were copied from %s; change them in machines/%s/machine.toml before provisioning\n", source, newName)
+	}
+	if _, err := os.Stat(filepath.Join(loader.MachineDir(sourceMachine), agecrypt.RecipientsFile)); err == nil {
+		fmt.Fprintf(e.stdout, "Note: %s's age recipients were not copied; run 'iago secrets keygen %s' to give %s keys of its own\n", source, newName, newName)
+	}
+
+	builder, err := e.newBuilder()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error creating builder: %v", err), 1)
+	}
+	outputFile := e.ignitionFile(newName)
+	if err := builder.GenerateMachine(newName, outputFile); err != nil {
+		return exitWithError(fmt.Sprintf("Error generating machine: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "  ✓ Ignition file: %s\n", outputFile)
+
+	fmt.Fprintf(e.stdout, "\n🎉 Machine '%s' cloned from '%s'\n", newName, source)
+	return nil
+}
+
+// newExplainCommand returns iago explain
+func newExplainCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "explain",
+		Usage:     "Show the resolved configuration of a machine and which file set each value",
+		ArgsUsage: "[machine-name] [key-prefix]",
+		Action:    e.explainCommand,
+	}
+}
+
+func (e *env) explainCommand(ctx *cli.Context) error {
+	if ctx.NArg() < 1 || ctx.NArg() > 2 {
+		return exitWithError("Error: requires a machine name and an optional key prefix. Usage: iago explain [machine-name] [key-prefix]", 1)
+	}
+	machineName := ctx.Args().Get(0)
+	prefix := ctx.Args().Get(1)
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	if err := loader.LoadMachines(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading machines: %v", err), 1)
+	}
+
+	m, err := loader.GetMachine(machineName)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	settings, err := loader.Explain(m)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	var lines [][2]string
+	width := 0
+	for _, setting := range settings {
+		if !strings.HasPrefix(setting.Key, prefix) {
+			continue
+		}
+		line := fmt.Sprintf("%s = %s", setting.Key, machine.FormatValue(setting.Value))
+		// Long values such as password hashes shouldn't push every source column out
+		if len(line) > width && len(line) <= 72 {
+			width = len(line)
+		}
+		lines = append(lines, [2]string{line, setting.Source})
+	}
+
+	if len(lines) == 0 {
+		return exitWithError(fmt.Sprintf("No settings match '%s'", prefix), 1)
+	}
+	for _, line := range lines {
+		fmt.Fprintf(e.stdout, "%-*s  # %s\n", width, line[0], line[1])
+	}
+	return nil
+}
+
+// newMigrateCommand returns iago migrate
+func newMigrateCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:   "migrate",
+		Usage:  "Move deprecated config layouts (config/machines.toml, butane.yaml/.yml) to the current structure",
+		Action: e.migrateCommand,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Show what would be migrated without changing files",
+			},
+		},
+	}
+}
+
+func (e *env) migrateCommand(ctx *cli.Context) error {
+	dryRun := ctx.Bool("dry-run")
+
+	deprecations, err := machine.CheckDeprecations()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if len(deprecations) == 0 {
+		fmt.Fprintln(e.stdout, "Nothing to migrate, configuration uses the current layout")
+		return nil
+	}
+
+	migrated, err := machine.Migrate(dryRun)
+	for _, d := range migrated {
+		if dryRun {
+			fmt.Fprintf(e.stdout, "Would migrate %s: %s\n", d.Path, d.Fix)
+		} else {
+			fmt.Fprintf(e.stdout, "✓ Migrated %s: %s\n", d.Path, d.Fix)
+		}
+	}
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	for _, d := range deprecations {
+		if d.ManualOnly() {
+			fmt.Fprintf(e.stdout, "Needs manual fix: %s: %s\n", d.Path, d.Message)
+		}
+	}
+	return nil
+}
+
+// newScaffoldCommand returns iago scaffold and its subcommands
+func newScaffoldCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:  "scaffold",
+		Usage: "Compare and update machine templates against the current scaffold, and list container scaffolds",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "diff",
+				Usage:     "Show how a machine's butane template differs from the current scaffold",
+				ArgsUsage: "[machine-name]",
+				Action:    e.scaffoldDiffCommand,
+			},
+			{
+				Name:   "templates",
+				Usage:  "List the container scaffolds iago init --role and --template write",
+				Action: e.scaffoldTemplatesCommand,
+			},
+			{
+				Name:      "update",
+				Usage:     "Merge scaffold improvements into a machine's template (3-way merge against its baseline)",
+				ArgsUsage: "[machine-name]",
+				Action:    e.scaffoldUpdateCommand,
+			},
+		},
+	}
+}
+
+func (e *env) scaffoldTemplatesCommand(ctx *cli.Context) error {
+	roles, err := scaffold.Roles()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	templates, err := scaffold.Templates()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	fmt.Fprintln(e.stdout, "Roles (iago init --role):")
+	for _, role := range roles {
+		fmt.Fprintf(e.stdout, "  %-10s %s\n", role.Name, role.Description)
+	}
+	fmt.Fprintln(e.stdout, "\nTemplates (iago init --template):")
+	for _, template := range templates {
+		fmt.Fprintf(e.stdout, "  %-10s %s\n", template.Name, template.Description)
+	}
+	return nil
+}
+
+func (e *env) scaffoldDiffCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago scaffold diff [machine-name]", 1)
+	}
+	machineName := ctx.Args().Get(0)
+
+	diff, err := scaffold.Diff(machineName)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if diff == "" {
+		fmt.Fprintf(e.stdout, "%s matches the current scaffold\n", machineName)
+		return nil
+	}
+
+	fmt.Fprint(e.stdout, diff)
+	if baseline, err := scaffold.LoadBaseline(machineName); err == nil && baseline.Version < scaffold.Version {
+		fmt.Fprintf(e.stdout, "\n%s was scaffolded from v%d (current v%d); run 'iago scaffold update %s' to merge improvements\n",
+			machineName, baseline.Version, scaffold.Version, machineName)
+	}
+	return nil
+}
+
+func (e *env) scaffoldUpdateCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago scaffold update [machine-name]", 1)
+	}
+	machineName := ctx.Args().Get(0)
+
+	result, err := scaffold.Update(machineName)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	switch {
+	case result.UpToDate:
+		fmt.Fprintf(e.stdout, "%s is already based on the current scaffold\n", machineName)
+	case result.Conflicts > 0:
+		return exitWithError(fmt.Sprintf("Merged scaffold into machines/%s/butane.yaml.tmpl with %d conflict(s); resolve the <<<<<<< markers before running 'iago ignite'", machineName, result.Conflicts), 1)
+	default:
+		fmt.Fprintf(e.stdout, "✅ Merged scaffold v%d into machines/%s/butane.yaml.tmpl\n", scaffold.Version, machineName)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+func TestListCommand_OutputFormat(t *testing.T) {
+	root := writeRepo(t, map[string]string{
+		"machines/proxmox-vm/machine.toml":        machineTOML("proxmox-vm", "02:05:56:12:34:56", "ens18"),
+		"machines/bare-metal/machine.toml":        machineTOML("bare-metal", "02:05:56:78:90:12", "enp0s31f6"),
+		"machines/default-interface/machine.toml": machineTOML("default-interface", "02:05:56:34:56:78", ""),
+		"machines/no-mac/machine.toml":            machineTOML("no-mac", "", ""),
+	})
+	output := runApp(t, root, "list")
+
+	// Verify output format and content
+	assert.Contains(t, output, "NAME", "Should contain NAME header")
+	assert.Contains(t, output, "FQDN", "Should contain FQDN header")
+	assert.Contains(t, output, "MAC ADDRESS", "Should contain MAC ADDRESS header")
+	assert.Contains(t, output, "INTERFACE", "Should contain INTERFACE header")
+	assert.Contains(t, output, "proxmox-vm", "Should contain proxmox-vm entry")
+	assert.Contains(t, output, "ens18", "Should contain ens18 interface")
+	assert.Contains(t, output, "enp0s31f6", "Should contain enp0s31f6 interface")
+	assert.Contains(t, output, "02:05:56:12:34:56", "Should contain MAC address")
+
+	// Verify dash placeholders for missing values
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "no-mac") {
+			assert.Regexp(t, `no-mac\s+.*\s+-\s+-`, line, "no-mac should have dashes for MAC and interface")
+		} else if strings.HasPrefix(line, "default-interface") {
+			assert.Regexp(t, `default-interface\s+.*\s+02:05:56:34:56:78\s+-`, line, "default-interface should have dash for interface")
+		}
+	}
+}
+
+// machineTOML returns a machine.toml with an optional MAC address and interface
+func machineTOML(name, mac, intf string) string {
+	config := fmt.Sprintf("name = %q\nfqdn = \"%s.example.com\"\ncontainer_image = \"registry.example.com/%s\"\ncontainer_tag = \"latest\"\n", name, name, name)
+	if mac != "" {
+		config += fmt.Sprintf("mac_address = %q\n", mac)
+	}
+	if intf != "" {
+		config += fmt.Sprintf("network_interface = %q\n", intf)
+	}
+	return config
+}
+
+func TestListCommand_StructuredOutput(t *testing.T) {
+	root := writeRepo(t, map[string]string{
+		"machines/web/machine.toml": `name = "web"
+fqdn = "web.example.com"
+tags = ["prod"]`,
+	})
+
+	var listings []machineListing
+	require.NoError(t, json.Unmarshal([]byte(runApp(t, root, "--output", "json", "list")), &listings))
+	assert.Equal(t, []machineListing{{Name: "web", FQDN: "web.example.com", Tags: []string{"prod"}}}, listings)
+
+	listings = nil
+	require.NoError(t, yaml.Unmarshal([]byte(runApp(t, root, "list", "--output", "yaml")), &listings))
+	assert.Equal(t, "web", listings[0].Name)
+
+	assert.Contains(t, runApp(t, root, "list"), "MAC ADDRESS")
+	assert.Contains(t, runApp(t, root, "list", "--group", "db"), "No machines configured")
+}
+
+func TestListCommand_Wide(t *testing.T) {
+	root := writeRepo(t, map[string]string{
+		"machines/web/machine.toml": `name = "web"
+fqdn = "web.example.com"
+site = "home"
+container_image = "ghcr.io/acme/web"
+container_tag = "v2"`,
+	})
+
+	output := runApp(t, root, "list", "--wide")
+	assert.Contains(t, output, "IGNITION")
+	assert.Regexp(t, `web\s+web\.example\.com\s+-\s+-\s+home\s+-\s+-\s+ghcr\.io/acme/web:v2`, output)
+	assert.NotContains(t, runApp(t, root, "list"), "IGNITION")
+}
+
+func TestScaffoldTemplatesCommand(t *testing.T) {
+	output := runApp(t, t.TempDir(), "scaffold", "templates")
+	assert.Contains(t, output, "Roles (iago init --role):")
+	assert.Regexp(t, `(?m)^  db\s+PostgreSQL`, output)
+	assert.Contains(t, output, "Templates (iago init --template):")
+	assert.Regexp(t, `(?m)^  wireguard\s+WireGuard`, output)
+}
+
+func TestEnvironmentFlag(t *testing.T) {
+	root := writeRepo(t, map[string]string{
+		"config/defaults.toml":         "[container_registry]\nurl = \"ghcr.io/me\"\n",
+		"config/defaults.staging.toml": "[container_registry]\nurl = \"registry.staging.lab\"\n",
+		"machines/web/machine.toml":    machineTOML("web", "", ""),
+	})
+
+	assert.Contains(t, runApp(t, root, "explain", "web", "container_registry"), `"ghcr.io/me"  # config/defaults.toml`)
+	assert.Contains(t, runApp(t, root, "--env", "staging", "explain", "web", "container_registry"), `"registry.staging.lab"  # config/defaults.staging.toml`)
+
+	// ignite and build take an --env of their own; environment runs iago with
+	// args, replacing the command's action with one that records the
+	// environment and ignition directory it would use
+	environment := func(t *testing.T, args ...string) (string, string) {
+		t.Helper()
+		e, _ := testEnv(root)
+		app := newApp(e)
+		var selected, dir string
+		for _, cmd := range app.Commands {
+			cmd.Action = func(*cli.Context) error {
+				selected, dir = e.environment, e.ignitionDir()
+				return nil
+			}
+		}
+		require.NoError(t, app.Run(append([]string{"iago"}, args...)))
+		return selected, dir
+	}
+
+	for _, command := range []string{"ignite", "build"} {
+		t.Run(command, func(t *testing.T) {
+			selected, dir := environment(t, command, "web")
+			assert.Empty(t, selected)
+			assert.Equal(t, "output/ignition", dir)
+
+			selected, dir = environment(t, "--env", "staging", command, "web")
+			assert.Equal(t, "staging", selected, "iago's --env reaches the command")
+			assert.Equal(t, filepath.Join("output/ignition", "staging"), dir)
+
+			selected, _ = environment(t, command, "--env", "staging", "web")
+			assert.Equal(t, "staging", selected)
+
+			selected, _ = environment(t, "--env", "prod", command, "--env", "staging", "web")
+			assert.Equal(t, "staging", selected, "the command's own --env wins")
+
+			t.Setenv("IAGO_ENV", "staging")
+			selected, _ = environment(t, command, "web")
+			assert.Equal(t, "staging", selected)
+		})
+	}
+
+	t.Setenv("IAGO_ENV", "staging")
+	assert.Contains(t, runApp(t, root, "explain", "web", "container_registry"), "config/defaults.staging.toml")
+}
+
+func TestEditCommand(t *testing.T) {
+	root := writeRepo(t, map[string]string{
+		"config/defaults.toml":          "",
+		"machines/web/machine.toml":     machineTOML("web", "", ""),
+		"machines/web/butane.yaml.tmpl": "variant: fcos\nversion: 1.5.0\n# registry.example.com/web\n",
+		"machines/db/machine.toml":      machineTOML("db", "", ""),
+	})
+	read := func(path string) string {
+		content, err := os.ReadFile(filepath.Join(root, path))
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	output := runApp(t, root, "edit", "--all", "--dry-run", "--replace", "registry.example.com=ghcr.io/me")
+	assert.Contains(t, output, "+++ b/machines/web/butane.yaml.tmpl\n")
+	assert.Contains(t, output, "+container_image = \"ghcr.io/me/db\"\n")
+	assert.Contains(t, output, "Would change 3 file(s) in 2 machine(s)")
+	assert.Contains(t, read("machines/db/machine.toml"), "registry.example.com/db")
+
+	output = runApp(t, root, "edit", "--yes", "--regex", "--replace", `registry\.example\.com/(\w+)=ghcr.io/me/$1`, "web")
+	assert.Contains(t, output, "Changed 2 file(s) in 1 machine(s)")
+	assert.Contains(t, read("machines/web/machine.toml"), `container_image = "ghcr.io/me/web"`)
+	assert.Contains(t, read("machines/db/machine.toml"), "registry.example.com/db", "only the named machine is edited")
+
+	assert.Contains(t, runApp(t, root, "edit", "--all", "--replace", "quay.io=ghcr.io"), `No matches for "quay.io" in 2 machine(s)`)
+}
+
+func TestRemoveCommand(t *testing.T) {
+	root := writeRepo(t, map[string]string{
+		"config/defaults.toml":         "",
+		"machines/web/machine.toml":    machineTOML("web", "", ""),
+		"containers/web/Containerfile": "FROM quay.io/fedora/fedora-bootc:42\n",
+		"machines/db/machine.toml":     machineTOML("db", "", ""),
+		"containers/db/Containerfile":  "FROM quay.io/fedora/fedora-bootc:42\n",
+	})
+
+	assert.Contains(t, runApp(t, root, "rm", "--force", "web"), "Machine 'web' removed successfully")
+	assert.NoDirExists(t, filepath.Join(root, "machines/web"))
+	assert.NoDirExists(t, filepath.Join(root, "containers/web"), "the container directory is found below the repository")
+	assert.DirExists(t, filepath.Join(root, "containers/db"))
+}
@@ -0,0 +1,220 @@
+//go:build !lite
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/andreweick/iago/internal/hypervisor"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/urfave/cli/v2"
+)
+
+// newPreviewCommand returns iago preview and its subcommands
+func newPreviewCommand(e *env) *cli.Command {
+	prFlag := &cli.IntFlag{
+		Name:  "pr",
+		Usage: "Pull request the preview belongs to",
+	}
+	return &cli.Command{
+		Name:      "preview",
+		Usage:     "Boot a copy of a machine from a pull request's templates as <name>-pr<n> on the hypervisor",
+		ArgsUsage: "[machine-name]",
+		Action:    e.previewCommand,
+		Flags: []cli.Flag{
+			prFlag,
+			&cli.BoolFlag{
+				Name:  "no-deploy",
+				Usage: "Only generate the preview's ignition into output/preview/pr-<n>/",
+			},
+			&cli.BoolFlag{
+				Name:  "no-start",
+				Usage: "Create the VM without starting it",
+			},
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name:      "destroy",
+				Usage:     "Delete a pull request's preview VMs and files",
+				ArgsUsage: "[machine-name]",
+				Flags:     []cli.Flag{prFlag},
+				Action:    e.previewDestroyCommand,
+			},
+			{
+				Name:   "list",
+				Usage:  "List previews and their VMs",
+				Flags:  []cli.Flag{prFlag},
+				Action: e.previewListCommand,
+			},
+		},
+	}
+}
+
+func (e *env) previewCommand(ctx *cli.Context) error {
+	pr := ctx.Int("pr")
+	if ctx.NArg() != 1 || pr <= 0 {
+		return exitWithError("Error: requires a machine name and --pr. Usage: iago preview --pr [number] [machine-name]", 1)
+	}
+	source := ctx.Args().First()
+
+	builder, err := e.newBuilder()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error creating builder: %v", err), 1)
+	}
+	m, err := builder.AddPreview(source, pr)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "  ✓ Preview config: %s/\n", m.Directory())
+
+	outputFile := filepath.Join(machine.PreviewDir(pr), m.Name+".ign")
+	if err := builder.GenerateMachine(m.Name, outputFile); err != nil {
+		return exitWithError(fmt.Sprintf("Error generating machine: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "  ✓ Ignition file: %s\n", outputFile)
+	fmt.Fprintf(e.stdout, "  FQDN: %s\n  MAC Address: %s\n", orDash(m.FQDN), m.MACAddress)
+
+	if ctx.Bool("no-deploy") {
+		fmt.Fprintf(e.stdout, "\n🔍 Preview '%s' generated; boot it with %s\n", m.Name, outputFile)
+		return nil
+	}
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	defaults, err := loader.DefaultsFor(m)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if defaults.Hypervisor.Backend != "proxmox" {
+		return exitWithError(fmt.Sprintf("Error: previews are deployed with the proxmox backend, but [hypervisor] backend is %q; pass --no-deploy to only generate them", defaults.Hypervisor.Backend), 1)
+	}
+	cfg := defaults.Hypervisor.Proxmox
+	client, err := proxmoxClient(ctx, defaults)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	upload := hypervisor.SnippetUploadCommand(cfg, m, outputFile)
+	if err := hypervisor.RunCommands(ctx.Context, []hypervisor.Command{upload}, e.stdout, e.stderr); err != nil {
+		return exitWithError(fmt.Sprintf("Error uploading ignition: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "  ✓ Uploaded to the %s snippets\n", cfg.Host)
+
+	// Ignition only runs on first boot, so an earlier preview VM is replaced
+	vmid := m.VMID
+	if vmid != 0 {
+		exists, err := client.VMExists(ctx.Context, vmid)
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		if exists {
+			if err := client.DestroyVM(ctx.Context, vmid, e.stdout); err != nil {
+				return exitWithError(fmt.Sprintf("Error replacing the previous preview: %v", err), 1)
+			}
+		}
+	} else if vmid, err = client.NextID(ctx.Context); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if err := client.ProvisionVM(ctx.Context, m, vmid, !ctx.Bool("no-start"), e.stdout); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if err := loader.SetPreviewVMID(m, vmid); err != nil {
+		fmt.Fprintf(e.stdout, "Warning: VM %d created but not recorded: %v\nDelete it by hand when the pull request closes\n", vmid, err)
+	}
+
+	fmt.Fprintf(e.stdout, "\n🔍 Preview '%s' booting as VM %d on %s; remove it with 'iago preview destroy --pr %d'\n", m.Name, vmid, cfg.Host, pr)
+	return nil
+}
+
+func (e *env) previewDestroyCommand(ctx *cli.Context) error {
+	pr := ctx.Int("pr")
+	if ctx.NArg() > 1 || pr <= 0 {
+		return exitWithError("Error: requires --pr and at most one machine name. Usage: iago preview destroy --pr [number] [machine-name]", 1)
+	}
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	previews, err := loader.Previews(pr)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if name := ctx.Args().First(); name != "" {
+		var selected []machine.Config
+		for _, m := range previews {
+			if m.Name == name || m.Name == machine.PreviewName(name, pr) {
+				selected = append(selected, m)
+			}
+		}
+		previews = selected
+	}
+	if len(previews) == 0 {
+		fmt.Fprintf(e.stdout, "No previews for pull request %d\n", pr)
+		return nil
+	}
+
+	var client *hypervisor.ProxmoxClient
+	for _, m := range previews {
+		if m.VMID != 0 {
+			defaults, err := loader.DefaultsFor(m)
+			if err != nil {
+				return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+			}
+			if client == nil {
+				if client, err = proxmoxClient(ctx, defaults); err != nil {
+					return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+				}
+			}
+			exists, err := client.VMExists(ctx.Context, m.VMID)
+			if err != nil {
+				return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+			}
+			if exists {
+				if err := client.DestroyVM(ctx.Context, m.VMID, e.stdout); err != nil {
+					return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+				}
+			}
+			remove := hypervisor.SnippetRemoveCommand(defaults.Hypervisor.Proxmox, m)
+			if err := hypervisor.RunCommands(ctx.Context, []hypervisor.Command{remove}, e.stdout, e.stderr); err != nil {
+				fmt.Fprintf(e.stderr, "Warning: could not remove the snippet of %s: %v\n", m.Name, err)
+			}
+			fmt.Fprintf(e.stdout, "  ✓ VM %d deleted\n", m.VMID)
+		}
+		if err := loader.RemovePreview(m); err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		fmt.Fprintf(e.stdout, "  ✓ Removed preview %s\n", m.Name)
+	}
+	return nil
+}
+
+func (e *env) previewListCommand(ctx *cli.Context) error {
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	previews, err := loader.Previews(ctx.Int("pr"))
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if len(previews) == 0 {
+		fmt.Fprintln(e.stdout, "No previews")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(e.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PR\tNAME\tFQDN\tMAC ADDRESS\tVM")
+	for _, m := range previews {
+		vm := "-"
+		if m.VMID != 0 {
+			vm = fmt.Sprint(m.VMID)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", filepath.Base(filepath.Dir(m.Directory())), m.Name, orDash(m.FQDN), orDash(m.MACAddress), vm)
+	}
+	return w.Flush()
+}
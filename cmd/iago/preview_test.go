@@ -0,0 +1,32 @@
+//go:build !lite
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviewListAndDestroy(t *testing.T) {
+	root := writeRepo(t, map[string]string{
+		"config/defaults.toml":                       "domain = \"example.com\"\n",
+		"machines/web/machine.toml":                  machineTOML("web", "02:00:00:00:00:01", ""),
+		"output/preview/pr-42/web-pr42/machine.toml": machineTOML("web-pr42", "02:00:00:00:00:42", ""),
+		"output/preview/pr-42/web-pr42.ign":          "{}",
+		"output/preview/pr-7/web-pr7/machine.toml":   machineTOML("web-pr7", "02:00:00:00:00:07", ""),
+	})
+
+	out := runApp(t, root, "preview", "list")
+	assert.Contains(t, out, "PR")
+	assert.Regexp(t, `pr-42\s+web-pr42\s+web-pr42.example.com\s+02:00:00:00:00:42\s+-`, out)
+	assert.Contains(t, out, "web-pr7")
+	assert.NotContains(t, runApp(t, root, "preview", "list", "--pr", "7"), "web-pr42")
+
+	out = runApp(t, root, "preview", "destroy", "--pr", "42", "web")
+	assert.Contains(t, out, "Removed preview web-pr42")
+	assert.NoDirExists(t, filepath.Join(root, "output/preview/pr-42"))
+	assert.Contains(t, runApp(t, root, "preview", "destroy", "--pr", "42"), "No previews for pull request 42")
+	assert.FileExists(t, filepath.Join(root, "machines/web/machine.toml"))
+}
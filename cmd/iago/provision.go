@@ -0,0 +1,134 @@
+//go:build !lite
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/andreweick/iago/internal/auth"
+	"github.com/andreweick/iago/internal/hypervisor"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/tomledit"
+	"github.com/urfave/cli/v2"
+)
+
+// provisionCommands create VMs through hypervisor APIs, which need the
+// [secrets] provider for their tokens
+func provisionCommands(e *env) []*cli.Command {
+	return []*cli.Command{
+		{
+			Name:  "provision",
+			Usage: "Create a VM for a machine on the hypervisor",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "proxmox",
+					Usage:     "Clone the Fedora CoreOS template into a new Proxmox VM with the machine's MAC address and ignition",
+					ArgsUsage: "[machine-name]",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "no-start",
+							Usage: "Create and configure the VM without starting it",
+						},
+					},
+					Action: e.provisionProxmoxCommand,
+				},
+			},
+		},
+		newPreviewCommand(e),
+	}
+}
+
+func (e *env) provisionProxmoxCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago provision proxmox [machine-name]", 1)
+	}
+	machineName := ctx.Args().First()
+
+	loader := e.newLoader()
+	if err := loader.LoadDefaults(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	if err := loader.LoadMachines(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading machines: %v", err), 1)
+	}
+	m, err := loader.GetMachine(machineName)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	defaults, err := loader.DefaultsFor(m)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	cfg := defaults.Hypervisor.Proxmox
+
+	client, err := proxmoxClient(ctx, defaults)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	builder, err := e.newBuilder()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error creating builder: %v", err), 1)
+	}
+	outputFile := e.ignitionFile(machineName)
+	if err := builder.GenerateMachine(machineName, outputFile); err != nil {
+		return exitWithError(fmt.Sprintf("Error generating machine: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "  ✓ Ignition file: %s\n", outputFile)
+
+	upload := hypervisor.SnippetUploadCommand(cfg, m, outputFile)
+	if err := hypervisor.RunCommands(ctx.Context, []hypervisor.Command{upload}, e.stdout, e.stderr); err != nil {
+		return exitWithError(fmt.Sprintf("Error uploading ignition: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "  ✓ Uploaded to the %s snippets\n", cfg.Host)
+
+	vmid := m.VMID
+	if vmid == 0 {
+		if vmid, err = client.NextID(ctx.Context); err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+	}
+	if err := client.ProvisionVM(ctx.Context, m, vmid, !ctx.Bool("no-start"), e.stdout); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	if m.VMID == 0 {
+		// Record the allocated ID so console, status and up find the VM
+		if err := setMachineVMID(loader.MachineDir(m), vmid); err != nil {
+			fmt.Fprintf(e.stdout, "Warning: VM %d created but not recorded: %v\nSet vm_id = %d in machines/%s/machine.toml\n", vmid, err, vmid, machineName)
+		} else {
+			fmt.Fprintf(e.stdout, "  ✓ vm_id = %d written to machines/%s/machine.toml\n", vmid, machineName)
+		}
+	}
+
+	fmt.Fprintf(e.stdout, "\n🖥️  Machine '%s' provisioned as VM %d on %s\n", machineName, vmid, cfg.Host)
+	return nil
+}
+
+// proxmoxClient returns a client for [hypervisor.proxmox] with the API token
+// from the [secrets] provider
+func proxmoxClient(ctx *cli.Context, defaults machine.Defaults) (*hypervisor.ProxmoxClient, error) {
+	provider, err := auth.NewSecretProvider(defaults)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := auth.ResolveSecret(ctx.Context, provider, auth.PurposeProxmox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the Proxmox API token: %w", err)
+	}
+	return hypervisor.NewProxmoxClient(defaults.Hypervisor.Proxmox, secret)
+}
+
+// setMachineVMID writes vm_id into the machine.toml in machineDir, keeping its formatting
+func setMachineVMID(machineDir string, vmid int) error {
+	path := filepath.Join(machineDir, "machine.toml")
+	doc, err := tomledit.Load(path)
+	if err != nil {
+		return err
+	}
+	if err := doc.Set("vm_id", vmid); err != nil {
+		return err
+	}
+	return doc.Save(path)
+}
@@ -0,0 +1,89 @@
+//go:build !lite
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/andreweick/iago/internal/auth"
+	"github.com/andreweick/iago/internal/butane"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	butane.RegisterSecretResolvers(func(defaults machine.Defaults) (func(context.Context, string) (string, error), func(context.Context, string) (string, error)) {
+		onePassword := auth.NewOnePasswordProvider(defaults.OnePassword, nil)
+		provider, providerErr := auth.NewSecretProvider(defaults)
+		resolveSecret := func(ctx context.Context, ref string) (string, error) {
+			if providerErr != nil {
+				return "", providerErr
+			}
+			return provider.Resolve(ctx, ref)
+		}
+		return onePassword.Resolve, resolveSecret
+	})
+}
+
+// validateSecrets checks the [secrets] references and registry host_auth and
+// registries auth entries are well formed for the configured provider
+func (e *env) validateSecrets(defaults machine.Defaults, check func(name string, err error)) {
+	err := auth.ValidateProvider(defaults)
+	check("secret references", err)
+	if err != nil {
+		fmt.Fprintf(e.stderr, "Secret reference validation failed: %v\n", err)
+	}
+	if provider, err := auth.NewSecretProvider(defaults); err == nil {
+		err = errors.Join(
+			auth.ValidateHostAuth(defaults.ContainerRegistry.HostAuth, provider),
+			auth.ValidateRegistryAuth(defaults.ContainerRegistry.Registries, provider),
+		)
+		check("registry host auth", err)
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Registry host auth validation failed: %v\n", err)
+		}
+	}
+}
+
+// githubToken resolves a GitHub API token like a registry token: --token,
+// GITHUB_TOKEN, then the [secrets] provider
+func githubToken(ctx *cli.Context, defaults machine.Defaults) (string, error) {
+	provider, err := auth.NewSecretProvider(defaults)
+	if err != nil {
+		return "", err
+	}
+	authConfig, err := auth.GetAuthConfig(ctx.Context, "", provider, "", ctx.String("token"))
+	if err != nil {
+		return "", err
+	}
+	return authConfig.Token, nil
+}
+
+// doctorSecrets checks the [secrets] provider's credentials are present and
+// resolve the registry token
+func doctorSecrets(ctx *cli.Context, d *doctor, defaults machine.Defaults) {
+	provider, err := auth.NewSecretProvider(defaults)
+	if err != nil {
+		d.fail("secret provider", err.Error(), "fix [secrets] provider in defaults.toml")
+		return
+	}
+	if !provider.Available() {
+		fix := "set the provider's credentials"
+		if provider.Name() == "1password" {
+			fix = "export OP_SERVICE_ACCOUNT_TOKEN with a 1Password service account token"
+		}
+		d.warn(provider.Name(), "credentials are not set", fix+"; op and secret references and registry auth through it fail without them")
+		return
+	}
+	if _, err := auth.ResolveSecret(ctx.Context, provider, auth.PurposeRegistry); err != nil {
+		if errors.Is(err, auth.ErrNoRef) {
+			d.ok(provider.Name(), "credentials set; no registry reference to test them with")
+			return
+		}
+		d.fail(provider.Name(), err.Error(), "check the token hasn't expired or been revoked and can read the registry reference")
+		return
+	}
+	d.ok(provider.Name(), "credentials resolve the registry token")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andreweick/iago/internal/sysext"
+	"github.com/urfave/cli/v2"
+)
+
+// newSysextCommand returns iago sysext
+func newSysextCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:  "sysext",
+		Usage: "Deliver configuration changes as signed systemd-sysext/confext images that hosts pull, without re-provisioning",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "build",
+				Usage:     "Build a machine's extension images and signed SHA256SUMS from its configuration, rendered from its frozen inputs",
+				ArgsUsage: "[machine-name]",
+				Action:    e.sysextBuildCommand,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "output",
+						Usage: "Directory for <machine>/sysext.raw, confext.raw, SHA256SUMS and SHA256SUMS.sig; serve it at [sysext] url",
+						Value: "output/sysext",
+					},
+				},
+			},
+		},
+	}
+}
+
+func (e *env) sysextBuildCommand(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return exitWithError("Error: requires exactly one argument (machine name). Usage: iago sysext build [flags] [machine-name]", 1)
+	}
+	machineName := ctx.Args().First()
+
+	loader := e.newLoader()
+	if err := loader.LoadAll(); err != nil {
+		return exitWithError(fmt.Sprintf("Error loading configuration: %v", err), 1)
+	}
+	m, err := loader.GetMachine(machineName)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	defaults, err := loader.DefaultsFor(m)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error loading defaults: %v", err), 1)
+	}
+	if defaults.Sysext.URL == "" {
+		fmt.Fprintln(e.stderr, "Warning: [sysext] url isn't set, so machines don't pull extension images")
+	}
+
+	images, err := sysext.NewBuilder(defaults.Sysext.SigningKey, defaults.Sysext.Certificate, e.stderr)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	builder, closeStore, err := e.newFrozenBuilder()
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	defer closeStore()
+	_, ignitionJSON, err := builder.RenderMachine(m.Name, false)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error rendering %s: %v", m.Name, err), 1)
+	}
+	extensions, err := sysext.FromIgnition(m.Name, ignitionJSON)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	staging, err := os.MkdirTemp("", "iago-sysext-")
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	defer os.RemoveAll(staging)
+
+	outputDir := filepath.Join(ctx.String("output"), m.Name)
+	var built []string
+	for _, kind := range []string{sysext.KindSysext, sysext.KindConfext} {
+		image := kind + ".raw"
+		dir := filepath.Join(staging, kind)
+		count, err := extensions.Stage(kind, dir)
+		if err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		if count == 0 {
+			// Left out of SHA256SUMS, so hosts remove the image they have
+			if err := os.Remove(filepath.Join(outputDir, image)); err != nil && !os.IsNotExist(err) {
+				return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+			}
+			continue
+		}
+		if err := images.Build(ctx.Context, kind, dir, filepath.Join(outputDir, image)); err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+		built = append(built, image)
+		fmt.Fprintf(e.stdout, "  ✓ %s: %d file(s)\n", filepath.Join(outputDir, image), count)
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if err := sysext.WriteSums(outputDir, built); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if err := images.SignSums(outputDir); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	fmt.Fprintf(e.stdout, "  ✓ %s and %s\n", filepath.Join(outputDir, sysext.SumsFile), sysext.SumsSignatureFile)
+
+	if len(extensions.Skipped) > 0 {
+		fmt.Fprintf(e.stdout, "\nNot in the images (these only change by re-provisioning):\n")
+		for _, path := range extensions.Skipped {
+			fmt.Fprintf(e.stdout, "  - %s\n", path)
+		}
+	}
+	if defaults.Sysext.URL != "" {
+		fmt.Fprintf(e.stdout, "\n📦 Publish %s/ at %s/%s/; %s merges it within [sysext] interval\n", outputDir, strings.TrimSuffix(defaults.Sysext.URL, "/"), m.Name, m.Name)
+	}
+	return nil
+}
@@ -0,0 +1,732 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andreweick/iago/internal/butane"
+	"github.com/andreweick/iago/internal/containerfile"
+	"github.com/andreweick/iago/internal/github"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/policy"
+	"github.com/andreweick/iago/internal/secretscan"
+	"github.com/andreweick/iago/internal/summary"
+	"github.com/andreweick/iago/internal/validator"
+	"github.com/andreweick/iago/internal/workload"
+	"github.com/urfave/cli/v2"
+)
+
+// newValidateCommand returns iago validate
+func newValidateCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:    "validate",
+		Aliases: []string{"val"},
+		Usage:   "Validate configuration",
+		Action:  e.validateCommand,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "summary-file",
+				Usage: "Append a markdown summary of the results to this file (e.g. $GITHUB_STEP_SUMMARY)",
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "Output format: table, json or yaml",
+			},
+		},
+	}
+}
+
+func (e *env) validateCommand(ctx *cli.Context) error {
+	format, err := outputFormat(ctx)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	// With --output json|yaml, progress messages go to stderr so stdout is only the report
+	out := e.stdout
+	if format != "table" {
+		e = e.progressToStderr()
+	}
+
+	loader := e.newLoader()
+	if err := loader.LoadAll(); err != nil {
+		if format != "table" {
+			report := summary.New("iago validate")
+			report.Add("configuration", summary.StatusFailed, err.Error(), "")
+			writeValidation(out, format, report)
+		}
+		return exitWithError(fmt.Sprintf("Configuration validation failed: %v", err), 1)
+	}
+
+	report := e.runValidation(loader)
+	if err := writeSummary(ctx, report); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	if format != "table" {
+		if err := writeValidation(out, format, report); err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+	}
+
+	if report.Failed() {
+		return exitWithError("Configuration validation failed", 1)
+	}
+
+	fmt.Fprintln(e.stdout, "Configuration is valid")
+	return nil
+}
+
+// runValidation checks every machine and the repository-wide rules, printing
+// problems to stderr as it goes, and returns a summary with one row per
+// machine and check
+func (e *env) runValidation(loader *machine.ConfigLoader) *summary.Summary {
+	// Validate workload definitions
+	workloadDefs := make([]workload.WorkloadDefinition, len(loader.GetWorkloads()))
+	for i, def := range loader.GetWorkloads() {
+		workloadDefs[i] = workload.WorkloadDefinition{
+			Name:           def.Name,
+			ContainerImage: def.ContainerImage,
+			ContainerTag:   def.ContainerTag,
+			BootcSource:    def.BootcSource,
+		}
+	}
+	registry := workload.CreateDefaultRegistry(workloadDefs)
+
+	machines := loader.GetMachines()
+	report := summary.New("iago validate")
+
+	for _, m := range machines {
+		var problems []string
+		// Get default workload implementation
+		workloadImpl := registry.GetDefault(m.Name)
+
+		if err := machine.ValidateRequired(m); err != nil {
+			fmt.Fprintf(e.stderr, "Machine %s: %v\n", m.Name, err)
+			problems = append(problems, err.Error())
+		}
+
+		// Validate naming consistency
+		if m.FQDN != "" && !strings.HasPrefix(m.FQDN, m.Name+".") {
+			fmt.Fprintf(e.stderr, "Machine %s: FQDN '%s' should start with machine name '%s.'\n",
+				m.Name, m.FQDN, m.Name)
+			problems = append(problems, fmt.Sprintf("FQDN '%s' should start with '%s.'", m.FQDN, m.Name))
+		}
+
+		// Check MAC address format if present
+		if m.MACAddress != "" && !machine.ValidateMAC(m.MACAddress) {
+			fmt.Fprintf(e.stderr, "Machine %s: Invalid MAC address format: %s\n",
+				m.Name, m.MACAddress)
+			problems = append(problems, fmt.Sprintf("invalid MAC address %s", m.MACAddress))
+		}
+
+		// Check static network settings if present
+		if err := machine.ValidateStaticNetwork(m); err != nil {
+			fmt.Fprintf(e.stderr, "Machine %s: %v\n", m.Name, err)
+			problems = append(problems, err.Error())
+		}
+
+		if err := machine.ValidateDriftExempt(m); err != nil {
+			fmt.Fprintf(e.stderr, "Machine %s: %v\n", m.Name, err)
+			problems = append(problems, err.Error())
+		}
+
+		// Check the ignition spec override, if any
+		if m.IgnitionVersion != "" {
+			if err := butane.ValidateIgnitionVersion(m.IgnitionVersion); err != nil {
+				fmt.Fprintf(e.stderr, "Machine %s: %v\n", m.Name, err)
+				problems = append(problems, err.Error())
+			}
+		}
+
+		// Validate workload-specific requirements
+		workloadConfig := workload.MachineConfig{
+			Name:         m.Name,
+			MACAddress:   m.MACAddress,
+			FQDN:         m.FQDN,
+			ContainerTag: m.ContainerTag,
+		}
+
+		if err := workloadImpl.Validate(workloadConfig); err != nil {
+			fmt.Fprintf(e.stderr, "Machine %s validation failed: %v\n", m.Name, err)
+			problems = append(problems, err.Error())
+		}
+
+		// Accounts and password hashes can differ per machine through site and role overlays
+		defaults, err := loader.DefaultsFor(m)
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Machine %s: %v\n", m.Name, err)
+			problems = append(problems, err.Error())
+		} else {
+			for _, err := range append(machine.ValidatePasswordHashes(defaults), machine.ValidateAccounts(defaults)...) {
+				fmt.Fprintf(e.stderr, "Machine %s: %v\n", m.Name, err)
+				problems = append(problems, err.Error())
+			}
+			if err := machine.ValidateMaintenance(machine.ResolveMaintenance(m, defaults)); err != nil {
+				fmt.Fprintf(e.stderr, "Machine %s: %v\n", m.Name, err)
+				problems = append(problems, err.Error())
+			}
+			if err := machine.ValidateFormats(defaults); err != nil {
+				fmt.Fprintf(e.stderr, "Machine %s: %v\n", m.Name, err)
+				problems = append(problems, err.Error())
+			}
+			if err := machine.ValidateContainers(defaults.Containers); err != nil {
+				fmt.Fprintf(e.stderr, "Machine %s: %v\n", m.Name, err)
+				problems = append(problems, err.Error())
+			}
+		}
+
+		if len(problems) > 0 {
+			report.Add(m.Name, summary.StatusFailed, strings.Join(problems, "\n"), "")
+		} else {
+			report.Add(m.Name, summary.StatusOK, "", "")
+		}
+	}
+
+	// check records a repository-wide check in the summary
+	check := func(name string, err error) {
+		if err != nil {
+			report.Add(name, summary.StatusFailed, err.Error(), "")
+		} else {
+			report.Add(name, summary.StatusOK, "", "")
+		}
+	}
+	// skip records a check that doesn't apply to this repository's shape
+	skip := func(name, reason string) {
+		report.Add(name, summary.StatusSkipped, reason, "")
+	}
+	shape := machine.DetectRepoShapeAt(loader.Root())
+	for _, notice := range shape.Notices() {
+		fmt.Fprintf(e.stderr, "Note: %s\n", notice)
+	}
+
+	// Validate rules that span machines; without containers/ every image is external
+	registryURL := loader.GetDefaults().ContainerRegistry.URL
+	if !shape.Containers {
+		registryURL = ""
+	}
+	fleetErrs := machine.ValidateFleet(machines, loader.GetWorkloads(), registryURL)
+	for _, err := range fleetErrs {
+		fmt.Fprintf(e.stderr, "Fleet validation failed: %v\n", err)
+	}
+	check("fleet", errors.Join(fleetErrs...))
+
+	// Validate base Butane template contains required constants
+	if shape.Machines {
+		err := validateBaseButaneTemplate(loader.Root())
+		check("base template", err)
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Base Butane template validation failed: %v\n", err)
+		}
+	} else {
+		skip("base template", "there is no machines/ directory")
+	}
+
+	// Validate script files
+	if shape.Scripts {
+		err := e.validateScriptFiles(loader.Root())
+		check("scripts", err)
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Script files validation failed: %v\n", err)
+		}
+	} else {
+		skip("scripts", "there is no config/scripts/ directory")
+	}
+
+	// Validate template local references
+	if shape.Machines {
+		err := e.validateTemplateLocalReferences(loader.Root())
+		check("template references", err)
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Template local references validation failed: %v\n", err)
+		}
+	} else {
+		skip("template references", "there is no machines/ directory")
+	}
+
+	// Check workloads only build FROM allowed base images
+	if shape.Containers {
+		err := e.validateBaseImages(loader.Root(), loader.GetDefaults().ContainerRegistry.Build.BaseImages)
+		check("base images", err)
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Base image check failed: %v\n", err)
+		}
+	} else {
+		skip("base images", "there is no containers/ directory")
+	}
+
+	// Evaluate repository policies against each machine's rendered config
+	err := e.validatePolicies(loader.Root(), machines)
+	check("policies", err)
+	if err != nil {
+		fmt.Fprintf(e.stderr, "Policy check failed: %v\n", err)
+	}
+
+	// Run the repository's own validators against each machine's rendered config
+	if validators, err := validator.Load(filepath.Join(loader.Root(), validator.DefaultDir)); err != nil || len(validators) > 0 {
+		if err == nil {
+			err = e.runValidators(validators, machines)
+		}
+		check("validators", err)
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Validator check failed: %v\n", err)
+		}
+	}
+
+	// Look for plaintext secrets committed by accident
+	scanDirs := make([]string, len(secretscan.DefaultDirs))
+	for i, dir := range secretscan.DefaultDirs {
+		scanDirs[i] = filepath.Join(loader.Root(), dir)
+	}
+	findings, err := secretscan.Scan(scanDirs)
+	if err == nil && len(findings) > 0 {
+		for _, f := range findings {
+			fmt.Fprintf(e.stderr, "Possible secret: %s\n", f)
+		}
+		err = fmt.Errorf("%d possible plaintext secret(s); see iago scan-secrets", len(findings))
+	}
+	check("secrets", err)
+	if err != nil {
+		fmt.Fprintf(e.stderr, "Secret scan failed: %v\n", err)
+	}
+
+	// Validate secret references without resolving them
+	defaults := loader.GetDefaults()
+	e.validateSecrets(defaults, check)
+
+	// Validate GitHub SSH keys if configured
+	if defaults.User.GitHubUsername != "" {
+		fmt.Fprintf(e.stdout, "Validating GitHub SSH keys for user '%s'...\n", defaults.User.GitHubUsername)
+		keys, err := github.FetchSSHKeys(defaults.User.GitHubUsername)
+		check("github ssh keys", err)
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Failed to fetch SSH keys from GitHub: %v\n", err)
+		} else {
+			fmt.Fprintf(e.stdout, "Found %d SSH key(s) for GitHub user '%s'\n", len(keys), defaults.User.GitHubUsername)
+		}
+	}
+	if defaults.Admin.GitHubUsername != "" {
+		fmt.Fprintf(e.stdout, "Validating GitHub SSH keys for admin '%s'...\n", defaults.Admin.GitHubUsername)
+		keys, err := github.FetchSSHKeys(defaults.Admin.GitHubUsername)
+		check("github admin ssh keys", err)
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Failed to fetch SSH keys from GitHub: %v\n", err)
+		} else {
+			fmt.Fprintf(e.stdout, "Found %d SSH key(s) for GitHub user '%s'\n", len(keys), defaults.Admin.GitHubUsername)
+		}
+	}
+	return report
+}
+
+// validationReport is validate's --output json|yaml document
+type validationReport struct {
+	Valid   bool             `json:"valid" yaml:"valid"`
+	Results []summary.Result `json:"results" yaml:"results"`
+}
+
+// writeValidation encodes report to w as json or yaml
+func writeValidation(w io.Writer, format string, report *summary.Summary) error {
+	return writeStructured(w, format, validationReport{
+		Valid:   !report.Failed(),
+		Results: report.Results,
+	})
+}
+
+// newScanSecretsCommand returns iago scan-secrets
+func newScanSecretsCommand(e *env) *cli.Command {
+	return &cli.Command{
+		Name:      "scan-secrets",
+		Usage:     "Look for plaintext secrets in templates, machine configs, container sources and generated ignition",
+		ArgsUsage: "[dir...]",
+		Action:    e.scanSecretsCommand,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "Output format: table, json or yaml",
+			},
+			&cli.StringFlag{
+				Name:  "summary-file",
+				Usage: "Append a markdown summary of the findings to this file (e.g. $GITHUB_STEP_SUMMARY)",
+			},
+		},
+	}
+}
+
+func (e *env) scanSecretsCommand(ctx *cli.Context) error {
+	format, err := outputFormat(ctx)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+	dirs := secretscan.DefaultDirs
+	if ctx.NArg() > 0 {
+		dirs = ctx.Args().Slice()
+	}
+
+	findings, err := secretscan.Scan(dirs)
+	if err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	report := summary.New("iago scan-secrets")
+	for _, f := range findings {
+		report.Add(fmt.Sprintf("%s:%d", f.Path, f.Line), summary.StatusFailed, f.Rule, "")
+	}
+	if err := writeSummary(ctx, report); err != nil {
+		return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+	}
+
+	if format != "table" {
+		if findings == nil {
+			findings = []secretscan.Finding{}
+		}
+		if err := writeStructured(e.stdout, format, findings); err != nil {
+			return exitWithError(fmt.Sprintf("Error: %v", err), 1)
+		}
+	} else {
+		for _, f := range findings {
+			fmt.Fprintf(e.stdout, "❌ %s\n", f)
+		}
+	}
+
+	if len(findings) > 0 {
+		return exitWithError(fmt.Sprintf("Found %d possible plaintext secret(s). Move them to 1Password or sops, or mark example values with # %s", len(findings), secretscan.AllowMarker), 1)
+	}
+	if format == "table" {
+		fmt.Fprintf(e.stdout, "✅ No plaintext secrets found in %s\n", strings.Join(dirs, ", "))
+	}
+	return nil
+}
+
+// validatePolicies renders every machine and checks it against the policies
+// in root's policies/, printing each violation
+func (e *env) validatePolicies(root string, machines []machine.Config) error {
+	engine, err := policy.LoadEngine(filepath.Join(root, policy.DefaultDir))
+	if err != nil {
+		return err
+	}
+	if engine.Len() == 0 {
+		return nil
+	}
+
+	builder, err := e.newBuilder()
+	if err != nil {
+		return err
+	}
+
+	var count int
+	for _, m := range machines {
+		butaneConfig, ignitionConfig, err := builder.RenderMachine(m.Name, false)
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.Name, err)
+		}
+		violations, err := engine.Evaluate(m.Name, policy.Input{Machine: m, Butane: butaneConfig, Ignition: ignitionConfig})
+		if err != nil {
+			return err
+		}
+		for _, v := range violations {
+			fmt.Fprintf(e.stderr, "Policy violation: %s\n", v)
+		}
+		count += len(violations)
+	}
+
+	if count > 0 {
+		return fmt.Errorf("%d violation(s) of %d policies", count, engine.Len())
+	}
+	fmt.Fprintf(e.stdout, "%d policies passed for %d machine(s)\n", engine.Len(), len(machines))
+	return nil
+}
+
+// runValidators renders every machine and runs the validators in
+// config/validators/ on it, printing each finding
+func (e *env) runValidators(validators []validator.Validator, machines []machine.Config) error {
+	builder, err := e.newBuilder()
+	if err != nil {
+		return err
+	}
+
+	var failed, warnings int
+	for _, m := range machines {
+		butaneConfig, ignitionConfig, err := builder.RenderMachine(m.Name, false)
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.Name, err)
+		}
+		input, err := validator.NewInput(m.Name, m, butaneConfig, ignitionConfig)
+		if err != nil {
+			return err
+		}
+		for _, v := range validators {
+			findings, err := v.Run(context.Background(), input, validator.DefaultTimeout)
+			if err != nil {
+				return err
+			}
+			for _, f := range findings {
+				if f.Failed() {
+					fmt.Fprintf(e.stderr, "Validator finding: %s\n", f)
+					failed++
+				} else {
+					fmt.Fprintf(e.stderr, "Warning: %s\n", f)
+					warnings++
+				}
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d finding(s) from %d validator(s)", failed, len(validators))
+	}
+	fmt.Fprintf(e.stdout, "%d validator(s) passed for %d machine(s)", len(validators), len(machines))
+	if warnings > 0 {
+		fmt.Fprintf(e.stdout, " with %d warning(s)", warnings)
+	}
+	fmt.Fprintln(e.stdout)
+	return nil
+}
+
+// validateBaseButaneTemplate checks the machine templates in the repository at root
+func validateBaseButaneTemplate(root string) error {
+	// With the new per-machine template system, we validate that machines directory exists
+	// and contains at least one valid machine template
+	const machinesDir = "machines"
+
+	// Check if machines directory exists
+	if _, err := os.Stat(filepath.Join(root, machinesDir)); os.IsNotExist(err) {
+		return fmt.Errorf("machines directory '%s' does not exist", machinesDir)
+	}
+
+	// Read machines directory to find machine subdirectories
+	entries, err := os.ReadDir(filepath.Join(root, machinesDir))
+	if err != nil {
+		return fmt.Errorf("failed to read machines directory: %w", err)
+	}
+
+	foundValidTemplate := false
+	for _, entry := range entries {
+		if entry.IsDir() {
+			templatePath := filepath.Join(machinesDir, entry.Name(), "butane.yaml.tmpl")
+			if _, err := os.Stat(filepath.Join(root, templatePath)); err == nil {
+				// Validate this machine template
+				if err := validateMachineTemplate(filepath.Join(root, templatePath)); err != nil {
+					return fmt.Errorf("invalid template %s: %w", templatePath, err)
+				}
+				foundValidTemplate = true
+			}
+		}
+	}
+
+	if !foundValidTemplate {
+		return fmt.Errorf("no valid machine templates found in %s - each machine should have a butane.yaml.tmpl file", machinesDir)
+	}
+
+	return nil
+}
+
+// validateMachineTemplate validates a specific machine template file
+func validateMachineTemplate(templatePath string) error {
+	content, err := os.ReadFile(templatePath)
+	if err != nil {
+		return fmt.Errorf("failed to read template: %w", err)
+	}
+
+	templateContent := string(content)
+
+	// Check for expected Go template variables
+	requiredVariables := []string{
+		"{{ .User.Username }}",
+		"{{ .Machine.Name }}",
+		"{{ .ContainerRegistry.URL }}",
+		"variant: fcos", // Ensure it's a valid butane file
+	}
+
+	for _, variable := range requiredVariables {
+		if !strings.Contains(templateContent, variable) {
+			return fmt.Errorf("required template variable '%s' not found", variable)
+		}
+	}
+
+	return nil
+}
+
+// validateScriptFiles checks that all required script files exist and are
+// readable in the repository at root
+func (e *env) validateScriptFiles(root string) error {
+	scriptsDir := filepath.Join(root, "config/scripts")
+
+	// Check if scripts directory exists
+	if _, err := os.Stat(scriptsDir); os.IsNotExist(err) {
+		return fmt.Errorf("scripts directory '%s' does not exist", scriptsDir)
+	}
+
+	// List of required script files
+	requiredScripts := []string{
+		"bootc-update.sh",
+		"motd.sh",
+	}
+
+	var errors []string
+
+	for _, script := range requiredScripts {
+		scriptPath := filepath.Join(scriptsDir, script)
+
+		// Check if file exists
+		fileInfo, err := os.Stat(scriptPath)
+		if os.IsNotExist(err) {
+			// Check for common misspellings
+			commonMisspellings := []string{
+				strings.Replace(script, "-", "_", -1),  // bootc_update.sh
+				strings.Replace(script, ".sh", "", -1), // bootc-update
+			}
+
+			for _, misspelling := range commonMisspellings {
+				if misspellingPath := filepath.Join(scriptsDir, misspelling); misspellingPath != scriptPath {
+					if _, err := os.Stat(misspellingPath); err == nil {
+						errors = append(errors, fmt.Sprintf("script '%s' not found, but found '%s' - check spelling", script, misspelling))
+						continue
+					}
+				}
+			}
+			errors = append(errors, fmt.Sprintf("required script '%s' not found", script))
+			continue
+		} else if err != nil {
+			errors = append(errors, fmt.Sprintf("error accessing script '%s': %v", script, err))
+			continue
+		}
+
+		// Check if file is readable
+		if fileInfo.Mode().Perm()&0044 == 0 {
+			errors = append(errors, fmt.Sprintf("script '%s' is not readable", script))
+		}
+
+		// Basic validation - should start with shebang
+		content, err := os.ReadFile(scriptPath)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("cannot read script '%s': %v", script, err))
+			continue
+		}
+
+		if !strings.HasPrefix(string(content), "#!") {
+			errors = append(errors, fmt.Sprintf("script '%s' should start with shebang (#!)", script))
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("script validation errors:\n  - %s", strings.Join(errors, "\n  - "))
+	}
+
+	fmt.Fprintf(e.stdout, "Script files validation passed (%d files checked)\n", len(requiredScripts))
+	return nil
+}
+
+// validateBaseImages checks every workload's FROM lines in the repository at
+// root against the base image allowlist; in warn mode images outside it are
+// only reported
+func (e *env) validateBaseImages(root string, policy machine.BaseImagePolicy) error {
+	if err := machine.ValidateBaseImagePolicy(policy); err != nil {
+		return err
+	}
+	if policy.IsZero() {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Join(root, "containers"))
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		buildFile, err := containerfile.Find(filepath.Join(root, "containers", entry.Name()))
+		if err != nil {
+			continue
+		}
+		errs = append(errs, containerfile.CheckBaseImages(buildFile, policy))
+	}
+	err = errors.Join(errs...)
+	if err != nil && policy.Warn() {
+		fmt.Fprintf(e.stderr, "Warning: %v\n", err)
+		return nil
+	}
+	return err
+}
+
+// validateTemplateLocalReferences checks that all local: references in the
+// templates of the repository at root point to existing files
+func (e *env) validateTemplateLocalReferences(root string) error {
+	var templatePaths []string
+
+	// Add machine-specific templates (now using .tmpl extension)
+	if entries, err := os.ReadDir(filepath.Join(root, "machines")); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				machineName := entry.Name()
+				templatePath := filepath.Join("machines", machineName, "butane.yaml.tmpl")
+				if _, err := os.Stat(filepath.Join(root, templatePath)); err == nil {
+					templatePaths = append(templatePaths, templatePath)
+				}
+			}
+		}
+	}
+
+	// If no machine templates found, that's an error
+	if len(templatePaths) == 0 {
+		return fmt.Errorf("no machine templates found - each machine should have a butane.yaml.tmpl file")
+	}
+
+	var errors []string
+	localReferences := make(map[string][]string) // file -> templates that reference it
+
+	for _, templatePath := range templatePaths {
+		content, err := os.ReadFile(filepath.Join(root, templatePath))
+		if err != nil {
+			continue // Skip if template doesn't exist or can't be read
+		}
+
+		// Find all local: references
+		lines := strings.Split(string(content), "\n")
+		for i, line := range lines {
+			if strings.Contains(line, "local:") {
+				// Extract the filename after "local:"
+				parts := strings.Split(line, "local:")
+				if len(parts) > 1 {
+					filename := strings.TrimSpace(parts[1])
+					// Remove quotes if present
+					filename = strings.Trim(filename, "\"'")
+
+					if filename != "" {
+						localReferences[filename] = append(localReferences[filename], fmt.Sprintf("%s:%d", templatePath, i+1))
+
+						// Check if the referenced file exists
+						scriptPath := filepath.Join(root, "config/scripts", filename)
+						if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
+							errors = append(errors, fmt.Sprintf("template %s:%d references missing file 'config/scripts/%s'", templatePath, i+1, filename))
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Check for unused script files
+	if entries, err := os.ReadDir(filepath.Join(root, "config/scripts")); err == nil {
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".sh") {
+				if _, referenced := localReferences[entry.Name()]; !referenced {
+					fmt.Fprintf(e.stdout, "Warning: script file '%s' is not referenced by any template\n", entry.Name())
+				}
+			}
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("template local reference errors:\n  - %s", strings.Join(errors, "\n  - "))
+	}
+
+	if len(localReferences) > 0 {
+		fmt.Fprintf(e.stdout, "Template local references validation passed (%d references checked)\n", len(localReferences))
+	}
+	return nil
+}
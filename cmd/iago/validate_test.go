@@ -0,0 +1,165 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateMachineTemplate_Success(t *testing.T) {
+	// Create temporary directory structure
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "test-machine")
+	err := os.MkdirAll(machineDir, 0755)
+	require.NoError(t, err)
+
+	// Create machine template file with required Go template variables
+	templatePath := filepath.Join(machineDir, "butane.yaml.tmpl")
+	templateContent := `variant: fcos
+version: 1.5.0
+passwd:
+  users:
+    - name: "{{ .User.Username }}"
+      groups:
+{{ range .User.Groups }}        - {{ . }}
+{{ end }}
+      password_hash: "{{ .User.PasswordHash }}"
+storage:
+  files:
+    - path: /etc/hostname
+      contents:
+        inline: "{{ .Machine.Name }}"
+{{ if .Machine.MACAddress }}    - path: /etc/NetworkManager/system-connections/eth0.nmconnection
+      mode: 0600
+      contents:
+        inline: |
+          [connection]
+          id=eth0
+          cloned-mac-address={{ .Machine.MACAddress }}
+{{ end }}    - path: /etc/registry-config
+      contents:
+        inline: "{{ .ContainerRegistry.URL }}"
+systemd:
+  units:
+    - name: podman.service
+      enabled: true`
+
+	err = os.WriteFile(templatePath, []byte(templateContent), 0644)
+	require.NoError(t, err)
+
+	// Test validation passes
+	err = validateBaseButaneTemplate(tempDir)
+	assert.NoError(t, err)
+}
+
+func TestValidateBaseButaneTemplate_MissingFile(t *testing.T) {
+	// Create temporary directory without machines directory
+	tempDir := t.TempDir()
+
+	// Test validation fails for missing machines directory
+	err := validateBaseButaneTemplate(tempDir)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "machines directory 'machines' does not exist")
+}
+
+func TestValidateBaseButaneTemplate_WithNetworkInterface(t *testing.T) {
+	// Create temporary directory structure
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "test-machine")
+	err := os.MkdirAll(machineDir, 0755)
+	require.NoError(t, err)
+
+	// Create machine template file with NetworkInterface support
+	templatePath := filepath.Join(machineDir, "butane.yaml.tmpl")
+	templateContent := `variant: fcos
+version: 1.5.0
+passwd:
+  users:
+    - name: "{{ .User.Username }}"
+      password_hash: "{{ .User.PasswordHash }}"
+storage:
+  files:
+    - path: /etc/hostname
+      contents:
+        inline: "{{ .Machine.Name }}"
+{{ if .Machine.MACAddress }}    - path: /etc/NetworkManager/system-connections/{{ default .Network.DefaultNetworkInterface .Machine.NetworkInterface }}.nmconnection
+      mode: 0600
+      contents:
+        inline: |
+          [connection]
+          id={{ default .Network.DefaultNetworkInterface .Machine.NetworkInterface }}
+          type=ethernet
+          interface-name={{ default .Network.DefaultNetworkInterface .Machine.NetworkInterface }}
+          
+          [ethernet]
+          cloned-mac-address={{ .Machine.MACAddress }}
+{{ end }}    - path: /etc/registry-config
+      contents:
+        inline: "{{ .ContainerRegistry.URL }}"
+systemd:
+  units:
+    - name: podman.service
+      enabled: true`
+
+	err = os.WriteFile(templatePath, []byte(templateContent), 0644)
+	require.NoError(t, err)
+
+	// Test validation
+	err = validateBaseButaneTemplate(tempDir)
+	assert.NoError(t, err, "Template with NetworkInterface support should validate successfully")
+}
+
+func TestValidateBaseButaneTemplate_MissingConstant(t *testing.T) {
+	// Create temporary directory structure
+	tempDir := t.TempDir()
+	machinesDir := filepath.Join(tempDir, "machines")
+	err := os.MkdirAll(machinesDir, 0755)
+	require.NoError(t, err)
+
+	// Create machine template file WITHOUT required constant
+	machineDir := filepath.Join(machinesDir, "test-machine")
+	require.NoError(t, os.MkdirAll(machineDir, 0755))
+	templatePath := filepath.Join(machineDir, "butane.yaml.tmpl")
+	templateContent := `variant: fcos
+version: 1.5.0
+storage:
+  files:
+    - path: /etc/hostname
+      contents:
+        inline: "{{ .Machine.Name }}"
+    - path: /usr/local/bin/script.sh
+      mode: 0755`
+
+	err = os.WriteFile(templatePath, []byte(templateContent), 0644)
+	require.NoError(t, err)
+
+	// Test validation fails for missing template variables
+	err = validateBaseButaneTemplate(tempDir)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "required template variable '{{ .User.Username }}' not found")
+}
+
+func TestValidateBaseImages(t *testing.T) {
+	root := writeRepo(t, map[string]string{
+		"containers/web/Containerfile":   "FROM quay.io/fedora/fedora-bootc:42\n",
+		"containers/cache/Containerfile": "FROM docker.io/valkey/valkey:8\n",
+	})
+	e, _ := testEnv(root)
+	assert.NoError(t, e.validateBaseImages(root, machine.BaseImagePolicy{}))
+	assert.NoError(t, e.validateBaseImages(root, machine.BaseImagePolicy{Allow: []string{"quay.io/fedora", "docker.io/valkey"}}))
+
+	err := e.validateBaseImages(root, machine.BaseImagePolicy{Allow: []string{"quay.io/fedora"}})
+	assert.ErrorContains(t, err, "containers/cache/Containerfile builds FROM docker.io/valkey/valkey:8")
+
+	var stderr strings.Builder
+	e.stderr = &stderr
+	assert.NoError(t, e.validateBaseImages(root, machine.BaseImagePolicy{Allow: []string{"quay.io/fedora"}, Mode: machine.BaseImageModeWarn}))
+	assert.Contains(t, stderr.String(), "containers/cache/Containerfile builds FROM docker.io/valkey/valkey:8")
+
+	assert.ErrorContains(t, e.validateBaseImages(root, machine.BaseImagePolicy{Mode: "audit"}), `mode "audit"`)
+}
@@ -35,6 +35,13 @@ start_container_service() {
         return
     fi
     
+    # Rootless containers run as user-level quadlets started by the user's systemd
+    local rootless_file="$CONTAINER_CONFIG_DIR/$container_name.rootless"
+    if [ -f "$rootless_file" ]; then
+        echo "[$(date)] $container_name runs rootless ($(grep "^ROOTLESS_USER=" "$rootless_file" | cut -d= -f2)), skipping bootc@${container_name}.service"
+        return
+    fi
+    
     # Enable and start the service
     systemctl enable "bootc@${container_name}.service" || true
     
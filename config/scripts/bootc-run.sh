@@ -28,6 +28,17 @@ CONTAINER_NAME_ACTUAL="${CONTAINER_NAME:-bootc-$CONTAINER_NAME}"
 HEALTH_CHECK_WAIT="${HEALTH_CHECK_WAIT:-30}"
 RESTART_POLICY="${RESTART_POLICY:-always}"
 
+# Containers updated by podman-auto-update carry its label and their systemd unit
+# (uses $1, since the env file may redefine CONTAINER_NAME)
+AUTO_UPDATE_ARGS=()
+if [ -f "$CONTAINER_CONFIG_DIR/$1.autoupdate" ]; then
+    source "$CONTAINER_CONFIG_DIR/$1.autoupdate"
+    AUTO_UPDATE_ARGS=(
+        --label "io.containers.autoupdate=${AUTO_UPDATE:-registry}"
+        --label "PODMAN_SYSTEMD_UNIT=bootc@$1.service"
+    )
+fi
+
 echo "[$(date)] Starting container: $CONTAINER_IMAGE"
 echo "[$(date)] Container name: $CONTAINER_NAME_ACTUAL"
 
@@ -52,4 +63,5 @@ exec /usr/bin/podman run \
     --health-interval=30s \
     --health-retries=3 \
     --health-start-period=60s \
+    "${AUTO_UPDATE_ARGS[@]}" \
     "$CONTAINER_IMAGE"
\ No newline at end of file
@@ -45,28 +45,46 @@ update_container() {
         return
     fi
     
+    # podman-auto-update.timer owns containers that opted into it
+    if [ -f "$CONTAINER_CONFIG_DIR/$container_name.autoupdate" ]; then
+        echo "[$(date)] Container $container_name is updated by podman-auto-update, skipping"
+        return
+    fi
+    
+    # Rootless containers live in the user's image store and systemd instance
+    local PODMAN=(podman)
+    local SYSTEMCTL=(systemctl)
+    local rootless_file="$CONTAINER_CONFIG_DIR/$container_name.rootless"
+    if [ -f "$rootless_file" ]; then
+        local rootless_user
+        rootless_user=$(grep "^ROOTLESS_USER=" "$rootless_file" | cut -d= -f2)
+        PODMAN=(runuser -u "$rootless_user" -- env XDG_RUNTIME_DIR="/run/user/$(id -u "$rootless_user")" podman)
+        SYSTEMCTL=(systemctl --user -M "${rootless_user}@")
+        SERVICE="${container_name}.service"
+    fi
+    
     # Save current image as :previous for rollback
-    podman tag "${CONTAINER_IMAGE}" "${CONTAINER_IMAGE%:*}:previous" 2>/dev/null || true
+    "${PODMAN[@]}" tag "${CONTAINER_IMAGE}" "${CONTAINER_IMAGE%:*}:previous" 2>/dev/null || true
     
     # Pull latest image
-    if ! podman pull "${CONTAINER_IMAGE}"; then
+    if ! "${PODMAN[@]}" pull "${CONTAINER_IMAGE}"; then
         echo "[$(date)] Failed to pull ${CONTAINER_IMAGE}"
         return
     fi
     
     # Check if service is running and restart if needed
-    if systemctl is-active --quiet "${SERVICE}"; then
+    if "${SYSTEMCTL[@]}" is-active --quiet "${SERVICE}"; then
         echo "[$(date)] Restarting ${SERVICE} with new image"
-        systemctl restart "${SERVICE}"
+        "${SYSTEMCTL[@]}" restart "${SERVICE}"
         
         # Wait for service to stabilize
         sleep "$HEALTH_CHECK_WAIT_OVERRIDE"
         
         # Verify service is healthy
-        if ! systemctl is-active --quiet "${SERVICE}"; then
+        if ! "${SYSTEMCTL[@]}" is-active --quiet "${SERVICE}"; then
             echo "[$(date)] Service $SERVICE failed to start with new image, rolling back"
-            podman tag "${CONTAINER_IMAGE%:*}:previous" "${CONTAINER_IMAGE}"
-            systemctl restart "${SERVICE}"
+            "${PODMAN[@]}" tag "${CONTAINER_IMAGE%:*}:previous" "${CONTAINER_IMAGE}"
+            "${SYSTEMCTL[@]}" restart "${SERVICE}"
             echo "[$(date)] Rollback completed for $container_name"
         else
             echo "[$(date)] Successfully updated $container_name"
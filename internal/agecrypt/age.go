@@ -0,0 +1,162 @@
+// Package agecrypt wraps filippo.io/age for iago's machine secrets: X25519
+// keys, ASCII-armored files the age CLI can decrypt on a machine or an
+// operator's workstation, and the recipients and identity files they're kept in.
+package agecrypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// ErrNoIdentityMatched means none of the identities can decrypt the file
+var ErrNoIdentityMatched = errors.New("no identity matched any of the file's recipients")
+
+// Recipient is an X25519 public key, written age1...
+type Recipient struct {
+	key *age.X25519Recipient
+}
+
+// Identity is an X25519 secret key, written AGE-SECRET-KEY-1...
+type Identity struct {
+	key *age.X25519Identity
+}
+
+// GenerateIdentity returns a new random identity
+func GenerateIdentity() (*Identity, error) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return &Identity{key: key}, nil
+}
+
+// ParseRecipient parses an age1... public key
+func ParseRecipient(s string) (*Recipient, error) {
+	key, err := age.ParseX25519Recipient(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age recipient %q: %w", s, err)
+	}
+	return &Recipient{key: key}, nil
+}
+
+// ParseIdentity parses an AGE-SECRET-KEY-1... secret key
+func ParseIdentity(s string) (*Identity, error) {
+	key, err := age.ParseX25519Identity(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age identity: %w", err)
+	}
+	return &Identity{key: key}, nil
+}
+
+// ParseRecipients parses a recipients file: one key per line, # comments
+func ParseRecipients(content []byte) ([]*Recipient, error) {
+	var recipients []*Recipient
+	for _, line := range keyLines(content) {
+		r, err := ParseRecipient(line)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, nil
+}
+
+// ParseIdentities parses an identity file as written by age-keygen
+func ParseIdentities(content []byte) ([]*Identity, error) {
+	var identities []*Identity
+	for _, line := range keyLines(content) {
+		i, err := ParseIdentity(line)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no age identities found")
+	}
+	return identities, nil
+}
+
+func keyLines(content []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func (r *Recipient) String() string {
+	return r.key.String()
+}
+
+// Recipient returns the public key that encrypts to i
+func (i *Identity) Recipient() *Recipient {
+	return &Recipient{key: i.key.Recipient()}
+}
+
+func (i *Identity) String() string {
+	return i.key.String()
+}
+
+// Encrypt encrypts plaintext to every recipient and returns an ASCII-armored file
+func Encrypt(plaintext []byte, recipients ...*Recipient) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipients")
+	}
+	keys := make([]age.Recipient, len(recipients))
+	for n, r := range recipients {
+		keys[n] = r.key
+	}
+
+	var out bytes.Buffer
+	armored := armor.NewWriter(&out)
+	w, err := age.Encrypt(armored, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if err := armored.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// Decrypt decrypts an armored or binary age file with the first matching identity
+func Decrypt(ciphertext []byte, identities ...*Identity) ([]byte, error) {
+	var in io.Reader = bytes.NewReader(ciphertext)
+	if bytes.HasPrefix(bytes.TrimSpace(ciphertext), []byte(armor.Header)) {
+		in = armor.NewReader(bytes.NewReader(bytes.TrimSpace(ciphertext)))
+	}
+	keys := make([]age.Identity, len(identities))
+	for n, i := range identities {
+		keys[n] = i.key
+	}
+
+	r, err := age.Decrypt(in, keys...)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, ErrNoIdentityMatched
+		}
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,104 @@
+package agecrypt
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"filippo.io/age/armor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyEncoding(t *testing.T) {
+	identity, err := GenerateIdentity()
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(identity.String(), "AGE-SECRET-KEY-1"))
+	assert.True(t, strings.HasPrefix(identity.Recipient().String(), "age1"))
+
+	parsed, err := ParseIdentity(identity.String())
+	require.NoError(t, err)
+	assert.Equal(t, identity.Recipient().String(), parsed.Recipient().String())
+
+	recipient, err := ParseRecipient(identity.Recipient().String())
+	require.NoError(t, err)
+	assert.Equal(t, identity.Recipient().String(), recipient.String())
+
+	_, err = ParseRecipient(identity.String())
+	assert.Error(t, err, "an identity isn't a recipient")
+	_, err = ParseRecipient(identity.Recipient().String()[:20] + "q" + identity.Recipient().String()[21:])
+	assert.Error(t, err, "a corrupted key fails its checksum")
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	machine, err := GenerateIdentity()
+	require.NoError(t, err)
+	operator, err := GenerateIdentity()
+	require.NoError(t, err)
+	stranger, err := GenerateIdentity()
+	require.NoError(t, err)
+
+	// age encrypts the payload in 64 KiB chunks
+	const chunkSize = 64 * 1024
+	for _, size := range []int{0, 5, chunkSize, chunkSize + 1, 3*chunkSize - 7} {
+		plaintext := bytes.Repeat([]byte("s3cret!"), size/7+1)[:size]
+		ciphertext, err := Encrypt(plaintext, machine.Recipient(), operator.Recipient())
+		require.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(ciphertext, []byte(armor.Header+"\n")))
+		assert.NotContains(t, string(ciphertext), "s3cret!")
+
+		for _, identity := range []*Identity{machine, operator} {
+			decrypted, err := Decrypt(ciphertext, identity)
+			require.NoError(t, err, "size %d", size)
+			assert.Equal(t, plaintext, decrypted, "size %d", size)
+		}
+
+		_, err = Decrypt(ciphertext, stranger)
+		assert.ErrorIs(t, err, ErrNoIdentityMatched)
+	}
+
+	_, err = Encrypt([]byte("x"))
+	assert.Error(t, err)
+}
+
+func TestDecryptDetectsTampering(t *testing.T) {
+	identity, err := GenerateIdentity()
+	require.NoError(t, err)
+	ciphertext, err := Encrypt([]byte("password"), identity.Recipient())
+	require.NoError(t, err)
+
+	binary, err := io.ReadAll(armor.NewReader(bytes.NewReader(ciphertext)))
+	require.NoError(t, err)
+	decrypted, err := Decrypt(binary, identity)
+	require.NoError(t, err, "binary files decrypt too")
+	assert.Equal(t, "password", string(decrypted))
+
+	tampered := append([]byte{}, binary...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = Decrypt(tampered, identity)
+	assert.ErrorContains(t, err, "failed to decrypt")
+
+	_, err = Decrypt(binary[:len(binary)-20], identity)
+	assert.Error(t, err)
+}
+
+func TestParseRecipients(t *testing.T) {
+	a, err := GenerateIdentity()
+	require.NoError(t, err)
+	b, err := GenerateIdentity()
+	require.NoError(t, err)
+
+	recipients, err := ParseRecipients([]byte("# web\n" + a.Recipient().String() + "\n\n  " + b.Recipient().String() + "  \n"))
+	require.NoError(t, err)
+	require.Len(t, recipients, 2)
+	assert.Equal(t, b.Recipient().String(), recipients[1].String())
+
+	identities, err := ParseIdentities([]byte("# created: 2026-01-01\n# public key: " + a.Recipient().String() + "\n" + a.String() + "\n"))
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+
+	_, err = ParseIdentities([]byte("# empty\n"))
+	assert.Error(t, err)
+}
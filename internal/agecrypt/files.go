@@ -0,0 +1,122 @@
+package agecrypt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RecipientsFile lists who can decrypt a machine's secrets, in the machine's directory
+const RecipientsFile = "recipients.txt"
+
+// DefaultIdentityDir is where iago secrets keygen keeps machine identities
+const DefaultIdentityDir = "~/.config/iago/age"
+
+// HostIdentityPath is where a machine's identity is installed on the machine
+const HostIdentityPath = "/etc/iago/age/identity.key"
+
+// RecipientsPath returns <machineDir>/recipients.txt
+func RecipientsPath(machineDir string) string {
+	return filepath.Join(machineDir, RecipientsFile)
+}
+
+// IdentityPath returns <dir>/<name>.key, expanding a leading ~/ in dir
+func IdentityPath(dir, machineName string) string {
+	if dir == "" {
+		dir = DefaultIdentityDir
+	}
+	if strings.HasPrefix(dir, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, dir[2:])
+		}
+	}
+	return filepath.Join(dir, machineName+".key")
+}
+
+// MachineRecipients returns the recipients in <machineDir>/recipients.txt
+// followed by the fleet-wide ones, without duplicates
+func MachineRecipients(machineDir string, fleetRecipients []string) ([]*Recipient, error) {
+	content, err := os.ReadFile(RecipientsPath(machineDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", RecipientsPath(machineDir), err)
+	}
+	lines := append(keyLines(content), fleetRecipients...)
+
+	var recipients []*Recipient
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		r, err := ParseRecipient(strings.TrimSpace(line))
+		if err != nil {
+			return nil, err
+		}
+		if !seen[r.String()] {
+			seen[r.String()] = true
+			recipients = append(recipients, r)
+		}
+	}
+	return recipients, nil
+}
+
+// AddRecipient appends recipient to a recipients file, preceded by comment,
+// reporting false if it's already listed
+func AddRecipient(path, recipient, comment string) (bool, error) {
+	r, err := ParseRecipient(recipient)
+	if err != nil {
+		return false, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	for _, line := range keyLines(content) {
+		if line == r.String() {
+			return false, nil
+		}
+	}
+
+	if len(content) > 0 && !strings.HasSuffix(string(content), "\n") {
+		content = append(content, '\n')
+	}
+	if comment != "" {
+		content = append(content, "# "+comment+"\n"...)
+	}
+	content = append(content, r.String()+"\n"...)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// RemoveRecipient deletes recipient and the comment directly above it from a
+// recipients file, reporting false if it isn't listed
+func RemoveRecipient(path, recipient string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	lines := strings.Split(string(content), "\n")
+	var kept []string
+	removed := false
+	for _, line := range lines {
+		if strings.TrimSpace(line) == recipient {
+			removed = true
+			if n := len(kept); n > 0 && strings.HasPrefix(strings.TrimSpace(kept[n-1]), "#") {
+				kept = kept[:n-1]
+			}
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if !removed {
+		return false, nil
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(kept, "\n")), 0644); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,274 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/machine"
+)
+
+// DefaultDir holds one directory per machine with a timestamped directory per generation
+const DefaultDir = "output/archive"
+
+// DefaultKeep is how many generations per machine iago gc output keeps when retention isn't configured
+const DefaultKeep = 10
+
+// timestampFormat sorts lexically in generation order
+const timestampFormat = "20060102T150405.000000000Z"
+
+// Retention resolves the archive directory and generations to keep from [output];
+// keep is 0 when archiving is disabled
+func Retention(cfg machine.OutputConfig) (dir string, keep int) {
+	dir, keep = cfg.ArchiveDir, cfg.Keep
+	if dir == "" {
+		dir = DefaultDir
+	}
+	if keep == 0 {
+		keep = DefaultKeep
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	return dir, keep
+}
+
+// Generation is one archived ignite of a machine: gzip-compressed files plus metadata.json
+type Generation struct {
+	Machine string
+	Time    time.Time
+	Path    string
+}
+
+// MetadataFile describes a generation next to its compressed files
+const MetadataFile = "metadata.json"
+
+// Metadata records where and from what a generation was produced
+type Metadata struct {
+	Machine   string            `json:"machine"`
+	Time      time.Time         `json:"time"`
+	User      string            `json:"user,omitempty"`
+	Host      string            `json:"host,omitempty"`
+	GitCommit string            `json:"git_commit,omitempty"` // HEAD of the config repository
+	GitDirty  bool              `json:"git_dirty,omitempty"`  // Uncommitted changes in the config repository
+	Files     map[string]string `json:"files"`                // File name -> SHA-256 of the uncompressed content
+}
+
+// NewMetadata captures host and git details for a generation of machineName
+// produced at at. Git details are left empty outside a repository.
+func NewMetadata(machineName, user string, at time.Time) Metadata {
+	meta := Metadata{Machine: machineName, Time: at.UTC(), User: user}
+	if host, err := os.Hostname(); err == nil {
+		meta.Host = host
+	}
+	if out, err := exec.Command("git", "rev-parse", "HEAD").Output(); err == nil {
+		meta.GitCommit = strings.TrimSpace(string(out))
+	}
+	if out, err := exec.Command("git", "status", "--porcelain").Output(); err == nil {
+		meta.GitDirty = len(strings.TrimSpace(string(out))) > 0
+	}
+	return meta
+}
+
+// Save archives files (name -> content) gzip-compressed as a new generation
+// of meta.Machine, alongside meta with the checksum of each file
+func Save(dir string, meta Metadata, files map[string][]byte) (Generation, error) {
+	meta.Time = meta.Time.UTC()
+	gen := Generation{
+		Machine: meta.Machine,
+		Time:    meta.Time,
+		Path:    filepath.Join(dir, meta.Machine, meta.Time.Format(timestampFormat)),
+	}
+	if err := os.MkdirAll(gen.Path, 0755); err != nil {
+		return Generation{}, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	meta.Files = make(map[string]string, len(files))
+	for name, content := range files {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(content); err != nil {
+			return Generation{}, fmt.Errorf("failed to compress %s: %w", name, err)
+		}
+		if err := zw.Close(); err != nil {
+			return Generation{}, fmt.Errorf("failed to compress %s: %w", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(gen.Path, name+".gz"), buf.Bytes(), 0644); err != nil {
+			return Generation{}, fmt.Errorf("failed to archive %s: %w", name, err)
+		}
+		meta.Files[name] = fmt.Sprintf("%x", sha256.Sum256(content))
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return Generation{}, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(gen.Path, MetadataFile), append(data, '\n'), 0644); err != nil {
+		return Generation{}, fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return gen, nil
+}
+
+// Metadata reads the generation's metadata.json
+func (g Generation) Metadata() (Metadata, error) {
+	data, err := os.ReadFile(filepath.Join(g.Path, MetadataFile))
+	if err != nil {
+		return Metadata{}, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	var meta Metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return Metadata{}, fmt.Errorf("failed to parse %s: %w", filepath.Join(g.Path, MetadataFile), err)
+	}
+	return meta, nil
+}
+
+// ReadFile returns the uncompressed content of an archived file, checking it
+// against the recorded checksum. Uncompressed files from older archives are read as-is.
+func (g Generation) ReadFile(name string) ([]byte, error) {
+	path := filepath.Join(g.Path, name)
+	compressed, err := os.ReadFile(path + ".gz")
+	if os.IsNotExist(err) {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not in generation %s", name, g.Path)
+		}
+		return content, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+
+	if meta, err := g.Metadata(); err == nil {
+		if expected, ok := meta.Files[name]; ok && expected != fmt.Sprintf("%x", sha256.Sum256(content)) {
+			return nil, fmt.Errorf("%s in %s does not match its recorded checksum", name, g.Path)
+		}
+	}
+	return content, nil
+}
+
+// Find returns the newest generation of machineName produced at or before at
+func Find(dir, machineName string, at time.Time) (Generation, error) {
+	gens, err := List(dir, machineName)
+	if err != nil {
+		return Generation{}, err
+	}
+	for i := len(gens) - 1; i >= 0; i-- {
+		if !gens[i].Time.After(at) {
+			return gens[i], nil
+		}
+	}
+	if len(gens) == 0 {
+		return Generation{}, fmt.Errorf("no archived generations for %s in %s", machineName, dir)
+	}
+	return Generation{}, fmt.Errorf("no generation of %s at or before %s (oldest is %s)",
+		machineName, at.UTC().Format(time.RFC3339), gens[0].Time.Format(time.RFC3339))
+}
+
+// ParseTime accepts RFC 3339 timestamps and the shorter local forms people type
+func ParseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			if layout == "2006-01-02" {
+				// A bare date means "as of the end of that day"
+				t = t.Add(24*time.Hour - time.Nanosecond)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q; use RFC 3339 (2026-01-02T15:04:05Z) or 2026-01-02[T15:04]", value)
+}
+
+// List returns the archived generations of machineName, oldest first
+func List(dir, machineName string) ([]Generation, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, machineName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read archive for %s: %w", machineName, err)
+	}
+
+	var gens []Generation
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		at, err := time.Parse(timestampFormat, entry.Name())
+		if err != nil {
+			continue // not a generation directory
+		}
+		gens = append(gens, Generation{
+			Machine: machineName,
+			Time:    at,
+			Path:    filepath.Join(dir, machineName, entry.Name()),
+		})
+	}
+	sort.Slice(gens, func(i, j int) bool { return gens[i].Time.Before(gens[j].Time) })
+	return gens, nil
+}
+
+// Machines returns the names of machines with archived generations
+func Machines(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read archive directory %s: %w", dir, err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// Prune removes all but the newest keep generations of machineName and returns
+// what was (or, with dryRun, would be) removed
+func Prune(dir, machineName string, keep int, dryRun bool) ([]Generation, error) {
+	gens, err := List(dir, machineName)
+	if err != nil {
+		return nil, err
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	if len(gens) <= keep {
+		return nil, nil
+	}
+
+	expired := gens[:len(gens)-keep]
+	if dryRun {
+		return expired, nil
+	}
+	for _, gen := range expired {
+		if err := os.RemoveAll(gen.Path); err != nil {
+			return nil, fmt.Errorf("failed to remove %s: %w", gen.Path, err)
+		}
+	}
+	return expired, nil
+}
@@ -0,0 +1,129 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveListPrune(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for i := 0; i < 4; i++ {
+		_, err := Save(dir, Metadata{Machine: "web", Time: start.Add(time.Duration(i) * time.Hour)}, map[string][]byte{"web.ign": []byte("{}")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "web", "not-a-generation"), 0755))
+
+	gens, err := List(dir, "web")
+	require.NoError(t, err)
+	require.Len(t, gens, 4)
+	assert.Equal(t, start, gens[0].Time)
+	assert.FileExists(t, filepath.Join(gens[3].Path, "web.ign.gz"))
+
+	expired, err := Prune(dir, "web", 3, true)
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	assert.DirExists(t, expired[0].Path, "dry run should not remove anything")
+
+	expired, err = Prune(dir, "web", 1, false)
+	require.NoError(t, err)
+	assert.Len(t, expired, 3)
+	gens, err = List(dir, "web")
+	require.NoError(t, err)
+	require.Len(t, gens, 1)
+	assert.Equal(t, start.Add(3*time.Hour), gens[0].Time, "the newest generation should survive")
+
+	machines, err := Machines(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web"}, machines)
+
+	gens, err = List(dir, "missing")
+	require.NoError(t, err)
+	assert.Empty(t, gens)
+}
+
+func TestGenerationReadFile(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	gen, err := Save(dir, Metadata{Machine: "web", Time: at, User: "alice"}, map[string][]byte{
+		"web.ign":               []byte(`{"ignition":{"version":"3.4.0"}}`),
+		"web-final-butane.yaml": []byte("variant: fcos\n"),
+	})
+	require.NoError(t, err)
+
+	content, err := gen.ReadFile("web.ign")
+	require.NoError(t, err)
+	assert.Equal(t, `{"ignition":{"version":"3.4.0"}}`, string(content))
+
+	meta, err := gen.Metadata()
+	require.NoError(t, err)
+	assert.Equal(t, "alice", meta.User)
+	assert.Equal(t, at, meta.Time)
+	assert.Len(t, meta.Files, 2)
+
+	_, err = gen.ReadFile("missing.ign")
+	assert.ErrorContains(t, err, "is not in generation")
+
+	// Generations archived before compression are still readable
+	require.NoError(t, os.WriteFile(filepath.Join(gen.Path, "legacy.ign"), []byte("{}"), 0644))
+	content, err = gen.ReadFile("legacy.ign")
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(content))
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	jan := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mar := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, at := range []time.Time{jan, mar} {
+		_, err := Save(dir, Metadata{Machine: "web", Time: at}, map[string][]byte{"web.ign": []byte("{}")})
+		require.NoError(t, err)
+	}
+
+	gen, err := Find(dir, "web", time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, jan, gen.Time)
+
+	gen, err = Find(dir, "web", mar)
+	require.NoError(t, err)
+	assert.Equal(t, mar, gen.Time, "a generation at exactly --at should match")
+
+	_, err = Find(dir, "web", jan.Add(-time.Hour))
+	assert.ErrorContains(t, err, "oldest is 2026-01-01T00:00:00Z")
+
+	_, err = Find(dir, "db", mar)
+	assert.ErrorContains(t, err, "no archived generations for db")
+}
+
+func TestParseTime(t *testing.T) {
+	got, err := ParseTime("2026-01-02T15:04:05Z")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC), got)
+
+	got, err = ParseTime("2026-01-02")
+	require.NoError(t, err)
+	assert.Equal(t, 23, got.Hour(), "a bare date covers the whole day")
+
+	_, err = ParseTime("last tuesday")
+	assert.ErrorContains(t, err, "invalid time")
+}
+
+func TestRetention(t *testing.T) {
+	dir, keep := Retention(machine.OutputConfig{})
+	assert.Equal(t, DefaultDir, dir)
+	assert.Equal(t, DefaultKeep, keep)
+
+	dir, keep = Retention(machine.OutputConfig{ArchiveDir: "archive", Keep: 3})
+	assert.Equal(t, "archive", dir)
+	assert.Equal(t, 3, keep)
+
+	_, keep = Retention(machine.OutputConfig{Keep: -1})
+	assert.Equal(t, 0, keep, "negative keep disables archiving")
+}
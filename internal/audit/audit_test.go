@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "audit.log")
+
+	require.NoError(t, Append(path, Entry{User: "alice", Machine: "nas", Action: "rm", Override: true}))
+	require.NoError(t, Append(path, Entry{
+		Time:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		User:       "alice",
+		Machine:    "nas",
+		Action:     "up",
+		ApprovedBy: "bob",
+	}))
+
+	entries, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	assert.False(t, entries[0].Time.IsZero(), "missing times should be filled in")
+	assert.True(t, entries[0].Override)
+	assert.Equal(t, "bob", entries[1].ApprovedBy)
+	assert.Equal(t, "up", entries[1].Action)
+}
+
+func TestLoad_MissingFile(t *testing.T) {
+	entries, err := Load(filepath.Join(t.TempDir(), "audit.log"))
+
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(path, []byte("{\"user\":\"a\"}\nnot json\n"), 0644))
+
+	_, err := Load(path)
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestCurrentUser(t *testing.T) {
+	t.Setenv("IAGO_USER", "carol")
+	assert.Equal(t, "carol", CurrentUser())
+}
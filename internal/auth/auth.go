@@ -2,28 +2,39 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/1password/onepassword-sdk-go"
 	"github.com/andreweick/iago/internal/container"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
 )
 
-// DefaultOnePasswordSecretRef is the default 1Password secret reference for GitHub tokens
+// DefaultOnePasswordSecretRef is the registry token reference used when
+// [onepassword.refs] doesn't set one
 const DefaultOnePasswordSecretRef = "op://iago/yq55ghqtbgmwvxbnc2xpzix4xm/credential"
 
 // AuthConfig contains registry authentication configuration
 type AuthConfig struct {
-	Username string
-	Token    string
-	Source   string // For debugging: "cli", "env", "1password"
+	Username      string
+	Token         string // Bearer token, e.g. a GitHub token
+	Password      string // Password for Basic auth, e.g. from docker login
+	IdentityToken string // OAuth2 refresh token from docker login, exchanged by the registry
+	Source        string // For debugging: "cli", "env", "docker-config", or the secret provider's name
 }
 
-// GetAuthConfig resolves authentication using priority chain:
-// 1. CLI flags (highest priority)
-// 2. Environment variables
-// 3. 1Password (if OP_SERVICE_ACCOUNT_TOKEN is set)
-func GetAuthConfig(ctx context.Context, cliUsername, cliToken string) (*AuthConfig, error) {
+// GetAuthConfig resolves authentication for registryURL using priority chain:
+//  1. CLI flags (highest priority)
+//  2. Environment variables
+//  3. docker/podman credentials: ~/.docker/config.json auths and credential
+//     helpers, or containers auth.json (skipped when registryURL is empty)
+//  4. The [secrets] provider's registry reference, when the provider is
+//     available (1Password: OP_SERVICE_ACCOUNT_TOKEN is set)
+func GetAuthConfig(ctx context.Context, registryURL string, provider SecretProvider, cliUsername, cliToken string) (*AuthConfig, error) {
 	// Priority 1: CLI flags
 	if cliToken != "" {
 		return &AuthConfig{
@@ -42,17 +53,194 @@ func GetAuthConfig(ctx context.Context, cliUsername, cliToken string) (*AuthConf
 		}, nil
 	}
 
-	// Priority 3: 1Password
-	if opToken := os.Getenv("OP_SERVICE_ACCOUNT_TOKEN"); opToken != "" {
-		return getAuthFrom1Password(ctx, cliUsername, opToken)
+	// Priority 3: credentials from docker login, podman login or gh auth setup-docker
+	if registryURL != "" {
+		host := strings.SplitN(registryURL, "/", 2)[0]
+		authCfg, err := cachedAuth("docker-config:"+host, func() (*AuthConfig, error) {
+			return getAuthFromDockerConfig(host)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if authCfg != nil {
+			return authCfg, nil
+		}
+	}
+
+	// Priority 4: secret provider
+	if provider != nil && provider.Available() {
+		secretRef, err := provider.Ref(PurposeRegistry)
+		switch {
+		case err == nil:
+			return getAuthFromProvider(ctx, cliUsername, provider, secretRef)
+		case !errors.Is(err, ErrNoRef):
+			return nil, err
+		}
 	}
 
 	// No authentication available
-	return nil, fmt.Errorf("no authentication available: set --token flag, GITHUB_TOKEN env var, or OP_SERVICE_ACCOUNT_TOKEN for 1Password integration")
+	return nil, fmt.Errorf("no authentication available: set --token flag, GITHUB_TOKEN env var, run 'docker login', or set OP_SERVICE_ACCOUNT_TOKEN for 1Password integration")
+}
+
+// GetPullAuthConfig returns optional Docker Hub credentials for base image pulls
+// from IAGO_PULL_USERNAME and IAGO_PULL_TOKEN, or nil for anonymous pulls
+func GetPullAuthConfig() *AuthConfig {
+	username := os.Getenv("IAGO_PULL_USERNAME")
+	token := os.Getenv("IAGO_PULL_TOKEN")
+	if username == "" || token == "" {
+		return nil
+	}
+	return &AuthConfig{
+		Username: username,
+		Token:    token,
+		Source:   "env",
+	}
+}
+
+// GetRegistryAuthConfig resolves authentication for registryURL like
+// GetAuthConfig, except that credentials set for its registry in
+// [container_registry.registries] come right after CLI flags
+func GetRegistryAuthConfig(ctx context.Context, registry machine.ContainerRegistryConfig, registryURL string, provider SecretProvider, cliUsername, cliToken string) (*AuthConfig, error) {
+	if entry, ok := registry.RegistryAuth(registryURL); ok && cliToken == "" {
+		return getAuthFromEntry(ctx, registryURL, entry, provider)
+	}
+	return GetAuthConfig(ctx, registryURL, provider, cliUsername, cliToken)
+}
+
+// GetRegistryPullAuth resolves the credentials of every registry in
+// [container_registry.registries] that has them, keyed by the host image
+// references name, for pulling FROM images from them and their mirrors
+func GetRegistryPullAuth(ctx context.Context, registry machine.ContainerRegistryConfig, provider SecretProvider) (map[string]*container.AuthConfig, error) {
+	pullAuth := make(map[string]*container.AuthConfig)
+	for host := range registry.Registries {
+		entry, ok := registry.RegistryAuth(host)
+		if !ok {
+			continue
+		}
+		authCfg, err := getAuthFromEntry(ctx, host, entry, provider)
+		if err != nil {
+			return nil, err
+		}
+		pullAuth[machine.RegistryHost(host)] = authCfg.ToContainerAuthConfig()
+	}
+	return pullAuth, nil
+}
+
+// getAuthFromEntry resolves a configured registry credential: the token at
+// its ref with provider, or in its token_env variable. With a username the
+// token is sent as its password with Basic auth, as registries such as Harbor
+// and Quay expect of robot tokens.
+func getAuthFromEntry(ctx context.Context, registryURL string, entry machine.HostAuthConfig, provider SecretProvider) (*AuthConfig, error) {
+	host, _, _ := strings.Cut(registryURL, "/")
+	switch {
+	case entry.Ref != "" && entry.TokenEnv == "":
+		if provider == nil {
+			return nil, fmt.Errorf("registries auth for %s: no [secrets] provider to resolve %s", host, entry.Ref)
+		}
+		token, err := provider.Resolve(ctx, entry.Ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve the token for %s: %w", host, err)
+		}
+		return entryAuth(entry.Username, token, provider.Name()), nil
+	case entry.TokenEnv != "" && entry.Ref == "":
+		token := os.Getenv(entry.TokenEnv)
+		if token == "" {
+			return nil, fmt.Errorf("token for %s: %s is not set", host, entry.TokenEnv)
+		}
+		return entryAuth(entry.Username, token, "env"), nil
+	default:
+		return nil, fmt.Errorf("registries auth for %s needs either ref or token_env", host)
+	}
+}
+
+// entryAuth returns token as the password of username, or as a bearer token
+// without one
+func entryAuth(username, token, source string) *AuthConfig {
+	if username == "" {
+		return &AuthConfig{Token: token, Source: source}
+	}
+	return &AuthConfig{Username: username, Password: token, Source: source}
 }
 
-// getAuthFrom1Password retrieves GitHub token from 1Password using the SDK
-func getAuthFrom1Password(ctx context.Context, username, serviceAccountToken string) (*AuthConfig, error) {
+// getAuthFromDockerConfig looks up host in the docker config (including
+// credHelpers/credsStore helpers) and podman's auth.json, returning nil when
+// neither has credentials for it
+func getAuthFromDockerConfig(host string) (*AuthConfig, error) {
+	registry, err := name.NewRegistry(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry %q: %w", host, err)
+	}
+
+	// An unreadable config or failing helper shouldn't hide the sources below it
+	authenticator, err := authn.DefaultKeychain.Resolve(registry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: skipping docker credentials for %s: %v\n", host, err)
+		return nil, nil
+	}
+	if authenticator == authn.Anonymous {
+		return nil, nil
+	}
+	cfg, err := authenticator.Authorization()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: skipping docker credentials for %s: %v\n", host, err)
+		return nil, nil
+	}
+
+	if cfg.Password == "" && cfg.IdentityToken == "" && cfg.RegistryToken == "" {
+		return nil, nil
+	}
+	return &AuthConfig{
+		Username:      cfg.Username,
+		Token:         cfg.RegistryToken,
+		Password:      cfg.Password,
+		IdentityToken: cfg.IdentityToken,
+		Source:        "docker-config",
+	}, nil
+}
+
+// getAuthFromProvider retrieves the registry token at secretRef from provider
+func getAuthFromProvider(ctx context.Context, username string, provider SecretProvider, secretRef string) (*AuthConfig, error) {
+	token, err := provider.Resolve(ctx, secretRef)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthConfig{
+		Username: username, // Use CLI username if provided, empty otherwise
+		Token:    token,
+		Source:   provider.Name(),
+	}, nil
+}
+
+// resolveOnePassword resolves secretRef with the 1Password SDK once per process,
+// reusing it from disk for IAGO_AUTH_CACHE_TTL when that is set
+func resolveOnePassword(ctx context.Context, serviceAccountToken, secretRef string) (string, error) {
+	key := fingerprint("1password", serviceAccountToken, secretRef)
+	cached, err := cachedAuth(key, func() (*AuthConfig, error) {
+		token, err := resolveOnePasswordSecret(ctx, key, serviceAccountToken, secretRef)
+		if err != nil {
+			return nil, err
+		}
+		return &AuthConfig{Token: token, Source: "1password"}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return cached.Token, nil
+}
+
+// resolveOnePasswordSecret reads secretRef from the on-disk cache when enabled,
+// falling back to the 1Password SDK
+func resolveOnePasswordSecret(ctx context.Context, key, serviceAccountToken, secretRef string) (string, error) {
+	ttl, err := diskCacheTTL()
+	if err != nil {
+		return "", err
+	}
+	if ttl > 0 {
+		if secret := readDiskCache(key); secret != "" {
+			return secret, nil
+		}
+	}
+
 	// Create 1Password client
 	client, err := onepassword.NewClient(
 		ctx,
@@ -60,23 +248,29 @@ func getAuthFrom1Password(ctx context.Context, username, serviceAccountToken str
 		onepassword.WithIntegrationInfo("Iago Container Registry Auth", "v1.0.0"),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create 1Password client: %w", err)
+		return "", fmt.Errorf("failed to create 1Password client: %w", err)
 	}
 
-	// Use the default 1Password secret reference - change DefaultOnePasswordSecretRef constant to customize
-	secretRef := DefaultOnePasswordSecretRef
-
 	// Resolve the secret
 	token, err := client.Secrets().Resolve(ctx, secretRef)
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve 1Password secret '%s': %w", secretRef, err)
+		return "", fmt.Errorf("failed to resolve 1Password secret '%s': %w", secretRef, err)
 	}
 
-	return &AuthConfig{
-		Username: username, // Use CLI username if provided, empty otherwise
-		Token:    token,
-		Source:   "1password",
-	}, nil
+	if ttl > 0 {
+		if err := writeDiskCache(key, token, ttl); err != nil {
+			return "", err
+		}
+	}
+	return token, nil
+}
+
+// Secret returns the token, or the password for username and password credentials
+func (ac *AuthConfig) Secret() string {
+	if ac.Token != "" {
+		return ac.Token
+	}
+	return ac.Password
 }
 
 // ToContainerAuthConfig converts to the container package's AuthConfig format
@@ -85,8 +279,17 @@ func (ac *AuthConfig) ToContainerAuthConfig() *container.AuthConfig {
 		return nil
 	}
 	return &container.AuthConfig{
-		Username: ac.Username,
-		Password: ac.Token, // GitHub uses token in password field
-		Token:    ac.Token,
+		Username:      ac.Username,
+		Password:      ac.Secret(), // GitHub uses token in password field
+		Token:         ac.Token,
+		IdentityToken: ac.IdentityToken,
+	}
+}
+
+// MaskToken shows enough of a token to tell tokens apart without revealing it
+func MaskToken(token string) string {
+	if len(token) <= 8 {
+		return strings.Repeat("*", len(token))
 	}
+	return token[:4] + strings.Repeat("*", len(token)-8) + token[len(token)-4:]
 }
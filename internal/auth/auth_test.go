@@ -3,16 +3,19 @@ package auth
 import (
 	"context"
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/andreweick/iago/internal/machine"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetAuthConfig_Priority(t *testing.T) {
 	ctx := context.Background()
 
 	// Test CLI token takes precedence
-	config, err := GetAuthConfig(ctx, "testuser", "cli-token")
+	config, err := GetAuthConfig(ctx, "", NewOnePasswordProvider(machine.OnePasswordConfig{}, nil), "testuser", "cli-token")
 	assert.NoError(t, err)
 	assert.Equal(t, "cli-token", config.Token)
 	assert.Equal(t, "testuser", config.Username)
@@ -22,7 +25,7 @@ func TestGetAuthConfig_Priority(t *testing.T) {
 	os.Setenv("GITHUB_TOKEN", "env-token")
 	defer os.Unsetenv("GITHUB_TOKEN")
 
-	config, err = GetAuthConfig(ctx, "testuser", "")
+	config, err = GetAuthConfig(ctx, "", NewOnePasswordProvider(machine.OnePasswordConfig{}, nil), "testuser", "")
 	assert.NoError(t, err)
 	assert.Equal(t, "env-token", config.Token)
 	assert.Equal(t, "testuser", config.Username)
@@ -38,7 +41,7 @@ func TestGetAuthConfig_Priority(t *testing.T) {
 		}
 	}()
 
-	config, err = GetAuthConfig(ctx, "", "")
+	config, err = GetAuthConfig(ctx, "", NewOnePasswordProvider(machine.OnePasswordConfig{}, nil), "", "")
 	assert.Error(t, err)
 	assert.Nil(t, config)
 	assert.Contains(t, err.Error(), "no authentication available")
@@ -60,3 +63,124 @@ func TestAuthConfig_ToContainerAuthConfig(t *testing.T) {
 	var nilAuth *AuthConfig
 	assert.Nil(t, nilAuth.ToContainerAuthConfig())
 }
+
+func TestGetPullAuthConfig(t *testing.T) {
+	t.Setenv("IAGO_PULL_USERNAME", "")
+	t.Setenv("IAGO_PULL_TOKEN", "")
+	assert.Nil(t, GetPullAuthConfig(), "pulls are anonymous by default")
+
+	t.Setenv("IAGO_PULL_USERNAME", "hubuser")
+	assert.Nil(t, GetPullAuthConfig(), "username alone is not enough")
+
+	t.Setenv("IAGO_PULL_TOKEN", "hubtoken")
+	config := GetPullAuthConfig()
+	assert.Equal(t, "hubuser", config.Username)
+	assert.Equal(t, "hubtoken", config.Token)
+}
+
+func TestGetAuthConfig_DockerConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("REGISTRY_AUTH_FILE", "")
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("OP_SERVICE_ACCOUNT_TOKEN", "")
+	t.Cleanup(ResetCache)
+
+	// "dockeruser:dockertoken" for ghcr.io, a credential helper for registry.lab
+	helper := filepath.Join(dir, "docker-credential-iagotest")
+	require.NoError(t, os.WriteFile(helper, []byte("#!/bin/sh\necho '{\"Username\":\"helperuser\",\"Secret\":\"helpertoken\"}'\n"), 0755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{
+  "auths": {"ghcr.io": {"auth": "ZG9ja2VydXNlcjpkb2NrZXJ0b2tlbg=="}},
+  "credHelpers": {"registry.lab": "iagotest"}
+}`), 0644))
+
+	ctx := context.Background()
+	config, err := GetAuthConfig(ctx, "ghcr.io/andreweick", NewOnePasswordProvider(machine.OnePasswordConfig{}, nil), "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "docker-config", config.Source)
+	assert.Equal(t, "dockeruser", config.Username)
+	assert.Equal(t, "dockertoken", config.Password, "docker passwords are sent with Basic auth")
+	assert.Empty(t, config.Token)
+
+	config, err = GetAuthConfig(ctx, "registry.lab/team", NewOnePasswordProvider(machine.OnePasswordConfig{}, nil), "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "helperuser", config.Username)
+	assert.Equal(t, "helpertoken", config.Password)
+
+	_, err = GetAuthConfig(ctx, "quay.io/other", NewOnePasswordProvider(machine.OnePasswordConfig{}, nil), "", "")
+	assert.ErrorContains(t, err, "no authentication available")
+
+	t.Setenv("GITHUB_TOKEN", "env-token")
+	config, err = GetAuthConfig(ctx, "ghcr.io/andreweick", NewOnePasswordProvider(machine.OnePasswordConfig{}, nil), "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "env", config.Source, "GITHUB_TOKEN takes precedence over docker credentials")
+}
+
+func TestGetAuthConfig_DockerConfigIdentityToken(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("REGISTRY_AUTH_FILE", "")
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Cleanup(ResetCache)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{
+  "auths": {"registry.azurecr.io": {"auth": "MDAwMDAwMDAtMDAwMC0wMDAwLTAwMDAtMDAwMDAwMDAwMDAwOg==", "identitytoken": "refresh-token"}}
+}`), 0644))
+
+	config, err := GetAuthConfig(context.Background(), "registry.azurecr.io/team", nil, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "refresh-token", config.IdentityToken)
+	assert.Empty(t, config.Token, "identity tokens are refresh tokens, not bearer tokens")
+	assert.Equal(t, "refresh-token", config.ToContainerAuthConfig().IdentityToken)
+}
+
+func TestGetAuthConfig_MalformedDockerConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("REGISTRY_AUTH_FILE", "")
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("REGISTRY_TOKEN", "env-provider-token")
+	t.Cleanup(ResetCache)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"auths": `), 0644))
+
+	config, err := GetAuthConfig(context.Background(), "ghcr.io/andreweick", NewEnvProvider(map[string]string{PurposeRegistry: "REGISTRY_TOKEN"}), "", "")
+	require.NoError(t, err, "a broken config.json falls through to the secret provider")
+	assert.Equal(t, "env-provider-token", config.Token)
+}
+
+func TestGetRegistryAuthConfig(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("GITHUB_TOKEN", "github-token")
+	t.Setenv("LAB_TOKEN", "lab-token")
+	registry := machine.ContainerRegistryConfig{Registries: map[string]machine.RegistryConfig{
+		"registry.lab:5000": {PlainHTTP: true, Auth: machine.HostAuthConfig{Username: "robot", TokenEnv: "LAB_TOKEN"}},
+		"quay.io":           {Mirrors: []string{"harbor.lab/quay"}},
+	}}
+	provider := NewEnvProvider(nil)
+
+	config, err := GetRegistryAuthConfig(ctx, registry, "registry.lab:5000/team", provider, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "robot", config.Username)
+	assert.Equal(t, "lab-token", config.Password, "configured credentials beat GITHUB_TOKEN")
+	assert.Empty(t, config.Token, "a token with a username is a Basic auth password")
+
+	config, err = GetRegistryAuthConfig(ctx, registry, "registry.lab:5000/team", provider, "me", "cli-token")
+	require.NoError(t, err)
+	assert.Equal(t, "cli", config.Source, "--token still wins")
+
+	config, err = GetRegistryAuthConfig(ctx, registry, "quay.io/team", provider, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "github-token", config.Token, "registries without auth use the usual chain")
+
+	pullAuth, err := GetRegistryPullAuth(ctx, registry, provider)
+	require.NoError(t, err)
+	require.Len(t, pullAuth, 1)
+	assert.Equal(t, "lab-token", pullAuth["registry.lab:5000"].Password)
+
+	t.Setenv("LAB_TOKEN", "")
+	_, err = GetRegistryPullAuth(ctx, registry, provider)
+	assert.ErrorContains(t, err, "LAB_TOKEN is not set")
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CacheTTLEnv enables the on-disk cache of 1Password secrets for the given
+// duration (e.g. "15m"), so back-to-back iago runs don't each call 1Password
+const CacheTTLEnv = "IAGO_AUTH_CACHE_TTL"
+
+// processCache holds expensive lookups (credential helpers, 1Password) for the
+// life of the process so `build --all` resolves them once, not per workload.
+// Tokens never leave memory unless CacheTTLEnv is set.
+var processCache = struct {
+	sync.Mutex
+	auth   map[string]*AuthConfig
+	checks map[string]checkResult
+}{
+	auth:   make(map[string]*AuthConfig),
+	checks: make(map[string]checkResult),
+}
+
+type checkResult struct {
+	warnings []string
+	err      error
+}
+
+// cachedAuth returns the cached result for key, calling resolve on a miss.
+// A nil result ("no credentials here") is cached too; errors are not.
+func cachedAuth(key string, resolve func() (*AuthConfig, error)) (*AuthConfig, error) {
+	processCache.Lock()
+	defer processCache.Unlock()
+
+	if cfg, ok := processCache.auth[key]; ok {
+		return cfg.clone(), nil
+	}
+	cfg, err := resolve()
+	if err != nil {
+		return nil, err
+	}
+	processCache.auth[key] = cfg.clone()
+	return cfg, nil
+}
+
+// cachedCheck memoizes a push token check per token and registry
+func cachedCheck(key string, check func() ([]string, error)) ([]string, error) {
+	processCache.Lock()
+	defer processCache.Unlock()
+
+	if result, ok := processCache.checks[key]; ok {
+		return result.warnings, result.err
+	}
+	warnings, err := check()
+	processCache.checks[key] = checkResult{warnings: warnings, err: err}
+	return warnings, err
+}
+
+// ResetCache forgets everything resolved by this process; the on-disk cache is left alone
+func ResetCache() {
+	processCache.Lock()
+	defer processCache.Unlock()
+	processCache.auth = make(map[string]*AuthConfig)
+	processCache.checks = make(map[string]checkResult)
+}
+
+func (ac *AuthConfig) clone() *AuthConfig {
+	if ac == nil {
+		return nil
+	}
+	c := *ac
+	return &c
+}
+
+// fingerprint keys caches by secrets without keeping the secrets themselves as keys
+func fingerprint(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// diskCacheEntry is one cached secret; the file is only readable by the user
+type diskCacheEntry struct {
+	Secret    string    `json:"secret"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// diskCacheTTL returns the configured on-disk cache lifetime, or 0 when disabled
+func diskCacheTTL() (time.Duration, error) {
+	value := os.Getenv(CacheTTLEnv)
+	if value == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid %s %q: use a duration such as 15m", CacheTTLEnv, value)
+	}
+	return ttl, nil
+}
+
+// DiskCacheDir is where cached secrets live when CacheTTLEnv is set
+func DiskCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find user cache directory: %w", err)
+	}
+	return filepath.Join(dir, "iago", "auth"), nil
+}
+
+// readDiskCache returns the unexpired secret cached under key, or ""
+func readDiskCache(key string) string {
+	dir, err := DiskCacheDir()
+	if err != nil {
+		return ""
+	}
+	path := filepath.Join(dir, key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var entry diskCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || time.Now().After(entry.ExpiresAt) {
+		os.Remove(path)
+		return ""
+	}
+	return entry.Secret
+}
+
+// writeDiskCache stores secret under key until ttl passes
+func writeDiskCache(key, secret string, ttl time.Duration) error {
+	dir, err := DiskCacheDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create auth cache directory: %w", err)
+	}
+	data, err := json.Marshal(diskCacheEntry{Secret: secret, ExpiresAt: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, key), data, 0600); err != nil {
+		return fmt.Errorf("failed to write auth cache: %w", err)
+	}
+	return nil
+}
+
+// ClearDiskCache removes every cached secret
+func ClearDiskCache() error {
+	dir, err := DiskCacheDir()
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to clear auth cache: %w", err)
+	}
+	return nil
+}
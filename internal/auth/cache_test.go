@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedAuth(t *testing.T) {
+	t.Cleanup(ResetCache)
+
+	calls := 0
+	resolve := func() (*AuthConfig, error) {
+		calls++
+		return &AuthConfig{Token: "secret", Source: "1password"}, nil
+	}
+
+	first, err := cachedAuth("key", resolve)
+	require.NoError(t, err)
+	first.Username = "changed"
+
+	second, err := cachedAuth("key", resolve)
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls, "second lookup should come from the cache")
+	assert.Equal(t, "secret", second.Token)
+	assert.Empty(t, second.Username, "callers get their own copy")
+
+	_, err = cachedAuth("failing", func() (*AuthConfig, error) { return nil, errors.New("boom") })
+	assert.Error(t, err)
+	_, err = cachedAuth("failing", resolve)
+	require.NoError(t, err, "errors are not cached")
+
+	ResetCache()
+	_, err = cachedAuth("key", resolve)
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestDiskCache(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	t.Setenv(CacheTTLEnv, "")
+	ttl, err := diskCacheTTL()
+	require.NoError(t, err)
+	assert.Zero(t, ttl, "disabled by default")
+
+	t.Setenv(CacheTTLEnv, "soon")
+	_, err = diskCacheTTL()
+	assert.ErrorContains(t, err, "invalid "+CacheTTLEnv)
+
+	key := fingerprint("1password", "ops_token", DefaultOnePasswordSecretRef)
+	assert.Empty(t, readDiskCache(key))
+
+	require.NoError(t, writeDiskCache(key, "ghp_secret", time.Minute))
+	assert.Equal(t, "ghp_secret", readDiskCache(key))
+
+	dir, err := DiskCacheDir()
+	require.NoError(t, err)
+	info, err := os.Stat(filepath.Join(dir, key))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "cached secrets are private to the user")
+
+	require.NoError(t, writeDiskCache(key, "ghp_secret", -time.Minute))
+	assert.Empty(t, readDiskCache(key), "expired entries are ignored")
+	_, err = os.Stat(filepath.Join(dir, key))
+	assert.True(t, os.IsNotExist(err), "expired entries are removed")
+
+	require.NoError(t, writeDiskCache(key, "ghp_secret", time.Minute))
+	require.NoError(t, ClearDiskCache())
+	assert.Empty(t, readDiskCache(key))
+}
+
+func TestMaskToken(t *testing.T) {
+	assert.Equal(t, "ghp_********cdef", MaskToken("ghp_12345678cdef"))
+	assert.Equal(t, "*****", MaskToken("short"))
+	assert.Equal(t, "", MaskToken(""))
+}
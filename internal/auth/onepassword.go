@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/andreweick/iago/internal/machine"
+)
+
+// Purposes a 1Password reference can be configured for in [onepassword.refs]
+const (
+	PurposeRegistry   = "registry"   // Container registry push token
+	PurposeProxmox    = "proxmox"    // Proxmox API token
+	PurposeCloudflare = "cloudflare" // Cloudflare DNS API token
+)
+
+// ErrNoServiceAccount is returned when a secret is needed but OP_SERVICE_ACCOUNT_TOKEN is unset
+var ErrNoServiceAccount = errors.New("OP_SERVICE_ACCOUNT_TOKEN is not set")
+
+// SecretRef returns the op:// reference configured for purpose. References may
+// be written as "item/field" (or "item/section/field") to use [onepassword]
+// vault. The registry purpose falls back to DefaultOnePasswordSecretRef.
+func SecretRef(cfg machine.OnePasswordConfig, purpose string) (string, error) {
+	ref := cfg.Refs[purpose]
+	if ref == "" {
+		if purpose == PurposeRegistry {
+			return DefaultOnePasswordSecretRef, nil
+		}
+		return "", fmt.Errorf("no 1Password reference for %q; add it to [onepassword.refs] in defaults.toml", purpose)
+	}
+	return qualifyRef(cfg.Vault, purpose, ref)
+}
+
+// ValidateRefs checks that every configured reference is well formed
+func ValidateRefs(cfg machine.OnePasswordConfig) error {
+	purposes := make([]string, 0, len(cfg.Refs))
+	for purpose := range cfg.Refs {
+		purposes = append(purposes, purpose)
+	}
+	sort.Strings(purposes)
+
+	var errs []error
+	for _, purpose := range purposes {
+		if _, err := qualifyRef(cfg.Vault, purpose, cfg.Refs[purpose]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OnePasswordProvider resolves op:// references with the service account in
+// OP_SERVICE_ACCOUNT_TOKEN, sharing the process and disk caches used for
+// registry auth
+type OnePasswordProvider struct {
+	cfg machine.OnePasswordConfig
+}
+
+// NewOnePasswordProvider returns a provider reading [onepassword] refs, with
+// [secrets.refs] taking precedence
+func NewOnePasswordProvider(cfg machine.OnePasswordConfig, refs map[string]string) *OnePasswordProvider {
+	merged := make(map[string]string, len(cfg.Refs)+len(refs))
+	maps.Copy(merged, cfg.Refs)
+	maps.Copy(merged, refs)
+	cfg.Refs = merged
+	return &OnePasswordProvider{cfg: cfg}
+}
+
+func (p *OnePasswordProvider) Name() string { return "1password" }
+
+func (p *OnePasswordProvider) Available() bool {
+	return os.Getenv("OP_SERVICE_ACCOUNT_TOKEN") != ""
+}
+
+func (p *OnePasswordProvider) Ref(purpose string) (string, error) {
+	if p.cfg.Refs[purpose] == "" && purpose != PurposeRegistry {
+		return "", fmt.Errorf("%w for %q; add it to [onepassword.refs] in defaults.toml", ErrNoRef, purpose)
+	}
+	return SecretRef(p.cfg, purpose)
+}
+
+func (p *OnePasswordProvider) CheckRef(ref string) error {
+	_, err := qualifyRef(p.cfg.Vault, "", ref)
+	return err
+}
+
+// Resolve accepts op://vault/item/field or item/field in [onepassword] vault
+func (p *OnePasswordProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	qualified, err := qualifyRef(p.cfg.Vault, "", ref)
+	if err != nil {
+		return "", err
+	}
+	serviceAccountToken := os.Getenv("OP_SERVICE_ACCOUNT_TOKEN")
+	if serviceAccountToken == "" {
+		return "", fmt.Errorf("cannot resolve %s: %w", qualified, ErrNoServiceAccount)
+	}
+	return resolveOnePassword(ctx, serviceAccountToken, qualified)
+}
+
+// ValidateHostAuth checks every [container_registry.host_auth] entry names a
+// user and exactly one token source, with a ref well formed for provider
+func ValidateHostAuth(hostAuth map[string]machine.HostAuthConfig, provider SecretProvider) error {
+	return validateAuthEntries("host_auth", hostAuth, provider)
+}
+
+// ValidateRegistryAuth checks the auth of every [container_registry.registries]
+// entry that has one, as ValidateHostAuth does
+func ValidateRegistryAuth(registries map[string]machine.RegistryConfig, provider SecretProvider) error {
+	entries := make(map[string]machine.HostAuthConfig)
+	for host, registry := range registries {
+		if registry.Auth != (machine.HostAuthConfig{}) {
+			entries[host] = registry.Auth
+		}
+	}
+	return validateAuthEntries("registries auth", entries, provider)
+}
+
+// validateAuthEntries checks credential entries by host; section names them in errors
+func validateAuthEntries(section string, entries map[string]machine.HostAuthConfig, provider SecretProvider) error {
+	hosts := make([]string, 0, len(entries))
+	for host := range entries {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var errs []error
+	for _, host := range hosts {
+		entry := entries[host]
+		switch {
+		case entry.Username == "":
+			errs = append(errs, fmt.Errorf("%s for %s has no username", section, host))
+		case (entry.Ref == "") == (entry.TokenEnv == ""):
+			errs = append(errs, fmt.Errorf("%s for %s needs either ref or token_env", section, host))
+		case entry.Ref != "":
+			if err := provider.CheckRef(entry.Ref); err != nil {
+				errs = append(errs, fmt.Errorf("%s for %s: %w", section, host, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// qualifyRef expands "item/field" against vault and checks the result has a
+// vault, an item and a field. purpose, when set, names the reference in errors.
+func qualifyRef(vault, purpose, ref string) (string, error) {
+	described := fmt.Sprintf("%q", ref)
+	if purpose != "" {
+		described += " for " + purpose
+	}
+	path, full := strings.CutPrefix(ref, "op://")
+	if !full {
+		if vault == "" {
+			return "", fmt.Errorf("1Password reference %s has no vault; write op://vault/item/field or set [onepassword] vault", described)
+		}
+		path = vault + "/" + path
+	}
+
+	segments := strings.Split(path, "/")
+	if len(segments) < 3 || len(segments) > 4 || slices.Contains(segments, "") {
+		return "", fmt.Errorf("invalid 1Password reference %s: expected op://vault/item/[section/]field", described)
+	}
+	return "op://" + path, nil
+}
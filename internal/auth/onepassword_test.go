@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretRef(t *testing.T) {
+	ref, err := SecretRef(machine.OnePasswordConfig{}, PurposeRegistry)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultOnePasswordSecretRef, ref, "registry falls back to the built-in reference")
+
+	_, err = SecretRef(machine.OnePasswordConfig{}, PurposeProxmox)
+	assert.ErrorContains(t, err, `no 1Password reference for "proxmox"`)
+
+	cfg := machine.OnePasswordConfig{
+		Vault: "homelab",
+		Refs: map[string]string{
+			PurposeRegistry:   "ghcr/token",
+			PurposeProxmox:    "op://infra/pve/api/token",
+			PurposeCloudflare: "cloudflare/dns/token",
+		},
+	}
+	ref, err = SecretRef(cfg, PurposeRegistry)
+	require.NoError(t, err)
+	assert.Equal(t, "op://homelab/ghcr/token", ref)
+
+	ref, err = SecretRef(cfg, PurposeProxmox)
+	require.NoError(t, err)
+	assert.Equal(t, "op://infra/pve/api/token", ref, "full references ignore the vault")
+
+	ref, err = SecretRef(cfg, PurposeCloudflare)
+	require.NoError(t, err)
+	assert.Equal(t, "op://homelab/cloudflare/dns/token", ref)
+}
+
+func TestValidateRefs(t *testing.T) {
+	assert.NoError(t, ValidateRefs(machine.OnePasswordConfig{}))
+
+	err := ValidateRefs(machine.OnePasswordConfig{Refs: map[string]string{
+		PurposeRegistry:   "ghcr/token",
+		PurposeProxmox:    "op://infra/pve",
+		PurposeCloudflare: "op://infra/cloudflare/token",
+	}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"ghcr/token" for registry has no vault`)
+	assert.Contains(t, err.Error(), `invalid 1Password reference "op://infra/pve" for proxmox`)
+	assert.NotContains(t, err.Error(), "cloudflare")
+}
+
+func TestResolveSecret_NoServiceAccount(t *testing.T) {
+	t.Setenv("OP_SERVICE_ACCOUNT_TOKEN", "")
+	provider := NewOnePasswordProvider(machine.OnePasswordConfig{Refs: map[string]string{PurposeCloudflare: "op://infra/cloudflare/token"}}, nil)
+	assert.False(t, provider.Available())
+
+	_, err := ResolveSecret(context.Background(), provider, PurposeCloudflare)
+	assert.ErrorIs(t, err, ErrNoServiceAccount)
+
+	_, err = ResolveSecret(context.Background(), provider, PurposeProxmox)
+	assert.ErrorIs(t, err, ErrNoRef)
+	assert.ErrorContains(t, err, "[onepassword.refs]")
+}
+
+func TestOnePasswordProvider_Resolve(t *testing.T) {
+	t.Setenv("OP_SERVICE_ACCOUNT_TOKEN", "")
+
+	provider := NewOnePasswordProvider(machine.OnePasswordConfig{Vault: "homelab"}, map[string]string{PurposeProxmox: "pve/token"})
+	_, err := provider.Resolve(context.Background(), "wifi/password")
+	assert.ErrorIs(t, err, ErrNoServiceAccount)
+	assert.ErrorContains(t, err, "op://homelab/wifi/password")
+
+	ref, err := provider.Ref(PurposeProxmox)
+	require.NoError(t, err)
+	assert.Equal(t, "op://homelab/pve/token", ref, "[secrets.refs] apply to 1Password too")
+
+	_, err = NewOnePasswordProvider(machine.OnePasswordConfig{}, nil).Resolve(context.Background(), "wifi/password")
+	assert.ErrorContains(t, err, "has no vault")
+}
+
+func TestValidateHostAuth(t *testing.T) {
+	assert.NoError(t, ValidateHostAuth(nil, NewOnePasswordProvider(machine.OnePasswordConfig{}, nil)))
+	assert.NoError(t, ValidateHostAuth(map[string]machine.HostAuthConfig{
+		"ghcr.io":      {Username: "andreweick", Ref: "ghcr-pull/credential"},
+		"registry.lab": {Username: "robot", TokenEnv: "LAB_PULL_TOKEN"},
+	}, NewOnePasswordProvider(machine.OnePasswordConfig{Vault: "homelab"}, nil)))
+
+	err := ValidateHostAuth(map[string]machine.HostAuthConfig{
+		"ghcr.io":      {Ref: "ghcr-pull/credential"},
+		"quay.io":      {Username: "robot"},
+		"registry.lab": {Username: "robot", Ref: "op://infra/pull", TokenEnv: "LAB_PULL_TOKEN"},
+		"docker.io":    {Username: "robot", Ref: "hub/token"},
+	}, NewOnePasswordProvider(machine.OnePasswordConfig{}, nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "host_auth for ghcr.io has no username")
+	assert.Contains(t, err.Error(), "host_auth for quay.io needs either ref or token_env")
+	assert.Contains(t, err.Error(), "host_auth for registry.lab needs either ref or token_env")
+	assert.Contains(t, err.Error(), `host_auth for docker.io: 1Password reference "hub/token" has no vault`)
+}
+
+func TestValidateRegistryAuth(t *testing.T) {
+	provider := NewOnePasswordProvider(machine.OnePasswordConfig{Vault: "homelab"}, nil)
+	assert.NoError(t, ValidateRegistryAuth(map[string]machine.RegistryConfig{
+		"registry.lab": {PlainHTTP: true},
+		"harbor.lab":   {Auth: machine.HostAuthConfig{Username: "robot", Ref: "harbor/token"}},
+	}, provider))
+
+	err := ValidateRegistryAuth(map[string]machine.RegistryConfig{
+		"harbor.lab": {Auth: machine.HostAuthConfig{Username: "robot"}},
+	}, provider)
+	assert.ErrorContains(t, err, "registries auth for harbor.lab needs either ref or token_env")
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/andreweick/iago/internal/machine"
+)
+
+// Secret providers selectable with [secrets] provider
+const (
+	ProviderOnePassword = "onepassword"
+	ProviderVault       = "vault"
+	ProviderSOPS        = "sops"
+	ProviderEnv         = "env"
+)
+
+// ErrNoRef is returned by SecretProvider.Ref when nothing is configured for a purpose
+var ErrNoRef = errors.New("no secret reference configured")
+
+// SecretProvider resolves secret references from one backend
+type SecretProvider interface {
+	// Name identifies the provider in AuthConfig.Source and errors
+	Name() string
+	// Available reports whether the provider's credentials are present, so
+	// registry auth can fall through to other sources without them
+	Available() bool
+	// Ref returns the reference configured for purpose, or an error wrapping ErrNoRef
+	Ref(purpose string) (string, error)
+	// CheckRef validates a reference without resolving it
+	CheckRef(ref string) error
+	// Resolve returns the secret ref points at
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// NewSecretProvider returns the provider selected by [secrets] provider,
+// 1Password by default
+func NewSecretProvider(defaults machine.Defaults) (SecretProvider, error) {
+	secrets := defaults.Secrets
+	switch secrets.Provider {
+	case "", ProviderOnePassword:
+		return NewOnePasswordProvider(defaults.OnePassword, secrets.Refs), nil
+	case ProviderVault:
+		return NewVaultProvider(secrets.Vault, secrets.Refs), nil
+	case ProviderSOPS:
+		return NewSOPSProvider(secrets.SOPS, secrets.Refs), nil
+	case ProviderEnv:
+		return NewEnvProvider(secrets.Refs), nil
+	default:
+		return nil, fmt.Errorf("unknown [secrets] provider '%s' (expected %s, %s, %s or %s)", secrets.Provider, ProviderOnePassword, ProviderVault, ProviderSOPS, ProviderEnv)
+	}
+}
+
+// ResolveSecret resolves the secret configured for purpose with provider
+func ResolveSecret(ctx context.Context, provider SecretProvider, purpose string) (string, error) {
+	ref, err := provider.Ref(purpose)
+	if err != nil {
+		return "", err
+	}
+	return provider.Resolve(ctx, ref)
+}
+
+// ValidateProvider checks the configured provider exists and every reference
+// it's configured with is well formed, without resolving anything
+func ValidateProvider(defaults machine.Defaults) error {
+	provider, err := NewSecretProvider(defaults)
+	if err != nil {
+		return err
+	}
+	refs := defaults.Secrets.Refs
+	if provider.Name() == "1password" {
+		if err := ValidateRefs(defaults.OnePassword); err != nil {
+			return err
+		}
+	}
+
+	purposes := make([]string, 0, len(refs))
+	for purpose := range refs {
+		purposes = append(purposes, purpose)
+	}
+	sort.Strings(purposes)
+
+	var errs []error
+	for _, purpose := range purposes {
+		if err := provider.CheckRef(refs[purpose]); err != nil {
+			errs = append(errs, fmt.Errorf("[secrets.refs] %s: %w", purpose, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// configuredRef looks purpose up in refs for providers without a default
+func configuredRef(provider string, refs map[string]string, purpose string) (string, error) {
+	if ref := refs[purpose]; ref != "" {
+		return ref, nil
+	}
+	return "", fmt.Errorf("%w for %q; add it to [secrets.refs] for the %s provider", ErrNoRef, purpose, provider)
+}
+
+// cachedSecret resolves a secret once per process, like registry credentials
+func cachedSecret(key string, resolve func() (string, error)) (string, error) {
+	cached, err := cachedAuth(key, func() (*AuthConfig, error) {
+		secret, err := resolve()
+		if err != nil {
+			return nil, err
+		}
+		return &AuthConfig{Token: secret}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return cached.Token, nil
+}
+
+// EnvProvider reads secrets from environment variables named by the reference
+type EnvProvider struct {
+	refs map[string]string
+}
+
+// NewEnvProvider returns a provider whose references are variable names
+func NewEnvProvider(refs map[string]string) *EnvProvider {
+	return &EnvProvider{refs: refs}
+}
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (p *EnvProvider) Name() string    { return ProviderEnv }
+func (p *EnvProvider) Available() bool { return true }
+
+func (p *EnvProvider) Ref(purpose string) (string, error) {
+	return configuredRef(ProviderEnv, p.refs, purpose)
+}
+
+func (p *EnvProvider) CheckRef(ref string) error {
+	if !envName.MatchString(ref) {
+		return fmt.Errorf("invalid environment variable name %q", ref)
+	}
+	return nil
+}
+
+func (p *EnvProvider) Resolve(_ context.Context, ref string) (string, error) {
+	if err := p.CheckRef(ref); err != nil {
+		return "", err
+	}
+	value := os.Getenv(ref)
+	if value == "" {
+		return "", fmt.Errorf("secret %s is not set in the environment", ref)
+	}
+	return value, nil
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSecretProvider(t *testing.T) {
+	for provider, name := range map[string]string{
+		"":                  "1password",
+		ProviderOnePassword: "1password",
+		ProviderVault:       ProviderVault,
+		ProviderSOPS:        ProviderSOPS,
+		ProviderEnv:         ProviderEnv,
+	} {
+		p, err := NewSecretProvider(machine.Defaults{Secrets: machine.SecretsConfig{Provider: provider}})
+		require.NoError(t, err)
+		assert.Equal(t, name, p.Name())
+	}
+
+	_, err := NewSecretProvider(machine.Defaults{Secrets: machine.SecretsConfig{Provider: "keepass"}})
+	assert.ErrorContains(t, err, "unknown [secrets] provider 'keepass'")
+}
+
+func TestEnvProvider(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("IAGO_PUSH_TOKEN", "push-token")
+	t.Cleanup(ResetCache)
+	provider := NewEnvProvider(map[string]string{PurposeRegistry: "IAGO_PUSH_TOKEN"})
+
+	config, err := GetAuthConfig(context.Background(), "", provider, "robot", "")
+	require.NoError(t, err)
+	assert.Equal(t, "push-token", config.Token)
+	assert.Equal(t, "robot", config.Username)
+	assert.Equal(t, ProviderEnv, config.Source)
+
+	_, err = GetAuthConfig(context.Background(), "", NewEnvProvider(nil), "", "")
+	assert.ErrorContains(t, err, "no authentication available", "a provider without a registry ref is skipped")
+
+	_, err = provider.Resolve(context.Background(), "IAGO_MISSING")
+	assert.ErrorContains(t, err, "IAGO_MISSING is not set")
+	assert.Error(t, provider.CheckRef("not-a-name"))
+}
+
+func TestVaultProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" || r.Header.Get("X-Vault-Namespace") != "lab" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/data/iago/registry":
+			w.Write([]byte(`{"data":{"data":{"token":"ghcr-token"},"metadata":{"version":3}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer server.Close()
+	t.Setenv("VAULT_ADDR", "")
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("HOME", t.TempDir())
+	t.Cleanup(ResetCache)
+
+	provider := NewVaultProvider(machine.VaultConfig{Address: server.URL, Mount: "kv", Namespace: "lab"}, map[string]string{PurposeRegistry: "iago/registry#token"})
+	assert.False(t, provider.Available())
+	_, err := provider.Resolve(context.Background(), "iago/registry#token")
+	assert.ErrorIs(t, err, ErrNoVaultToken)
+
+	t.Setenv("VAULT_TOKEN", "vault-token")
+	assert.True(t, provider.Available())
+	secret, err := ResolveSecret(context.Background(), provider, PurposeRegistry)
+	require.NoError(t, err)
+	assert.Equal(t, "ghcr-token", secret)
+
+	_, err = provider.Resolve(context.Background(), "iago/registry#password")
+	assert.ErrorContains(t, err, `has no string field "password"`)
+	_, err = provider.Resolve(context.Background(), "iago/missing#token")
+	assert.ErrorContains(t, err, "404")
+	assert.ErrorContains(t, provider.CheckRef("iago/registry"), "expected path/to/secret#field")
+}
+
+func TestSOPSProvider(t *testing.T) {
+	dir := t.TempDir()
+	// A stand-in for sops that prints its arguments
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sops"), []byte("#!/bin/sh\necho \"$3 $4\"\n"), 0755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Cleanup(ResetCache)
+
+	provider := NewSOPSProvider(machine.SOPSConfig{File: "config/secrets.sops.yaml"}, nil)
+	assert.True(t, provider.Available())
+
+	secret, err := provider.Resolve(context.Background(), "registry.token")
+	require.NoError(t, err)
+	assert.Equal(t, `["registry"]["token"] config/secrets.sops.yaml`, secret)
+
+	secret, err = provider.Resolve(context.Background(), "lab.sops.json#proxmox")
+	require.NoError(t, err)
+	assert.Equal(t, `["proxmox"] lab.sops.json`, secret)
+
+	assert.ErrorContains(t, NewSOPSProvider(machine.SOPSConfig{}, nil).CheckRef("registry.token"), "names no file")
+	assert.ErrorContains(t, provider.CheckRef("registry..token"), "invalid SOPS reference")
+}
+
+func TestValidateProvider(t *testing.T) {
+	assert.NoError(t, ValidateProvider(machine.Defaults{}))
+
+	err := ValidateProvider(machine.Defaults{Secrets: machine.SecretsConfig{
+		Provider: ProviderVault,
+		Refs:     map[string]string{PurposeRegistry: "iago/registry#token", PurposeProxmox: "iago/pve"},
+	}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "[secrets.refs] proxmox")
+	assert.NotContains(t, err.Error(), "registry")
+
+	err = ValidateProvider(machine.Defaults{OnePassword: machine.OnePasswordConfig{Refs: map[string]string{PurposeRegistry: "ghcr/token"}}})
+	assert.ErrorContains(t, err, "has no vault")
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/github"
+)
+
+// ExpiryWarning is how close to expiry a token must be before pushes warn about it
+const ExpiryWarning = 7 * 24 * time.Hour
+
+// CheckPushToken verifies, before any layers are uploaded, that a GitHub token
+// can push packages to ghcr.io. Tokens for other registries aren't checked.
+// It returns warnings for soon-to-expire tokens or when GitHub can't be reached,
+// and an error only when the token definitely can't push. Each token is
+// checked once per process.
+func CheckPushToken(ctx context.Context, registryURL string, cfg *AuthConfig) ([]string, error) {
+	host := strings.SplitN(registryURL, "/", 2)[0]
+	if cfg == nil || cfg.Secret() == "" || host != "ghcr.io" {
+		return nil, nil
+	}
+	// GitHub Actions tokens can't call /user; their permissions come from the workflow
+	if strings.HasPrefix(cfg.Secret(), "ghs_") {
+		return nil, nil
+	}
+
+	return cachedCheck(fingerprint(host, cfg.Secret()), func() ([]string, error) {
+		return checkPushToken(ctx, cfg)
+	})
+}
+
+func checkPushToken(ctx context.Context, cfg *AuthConfig) ([]string, error) {
+	info, err := github.InspectToken(ctx, cfg.Secret())
+	if err != nil {
+		if errors.Is(err, github.ErrTokenRejected) {
+			return nil, fmt.Errorf("token from %s: %w", cfg.Source, err)
+		}
+		return []string{fmt.Sprintf("could not verify token scopes: %v", err)}, nil
+	}
+
+	if info.Classic && !info.HasScope("write:packages") {
+		return nil, fmt.Errorf("token from %s lacks the write:packages scope needed to push to ghcr.io (has: %s)", cfg.Source, scopeList(info.Scopes))
+	}
+
+	var warnings []string
+	if !info.ExpiresAt.IsZero() {
+		remaining := time.Until(info.ExpiresAt)
+		if remaining < ExpiryWarning {
+			warnings = append(warnings, fmt.Sprintf("token from %s expires %s (in %s); renew it soon",
+				cfg.Source, info.ExpiresAt.Format(time.RFC3339), remaining.Round(time.Hour)))
+		}
+	}
+	if !info.Classic {
+		// Fine-grained tokens don't report permissions; the push itself is the check
+		warnings = append(warnings, "fine-grained token permissions can't be checked up front; it needs Packages: write")
+	}
+	return warnings, nil
+}
+
+func scopeList(scopes []string) string {
+	if len(scopes) == 0 {
+		return "none"
+	}
+	return strings.Join(scopes, ", ")
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andreweick/iago/internal/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPushToken(t *testing.T) {
+	var requests int32
+	soon := time.Now().Add(48 * time.Hour).UTC().Format("2006-01-02 15:04:05 MST")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.Header.Get("Authorization") {
+		case "Bearer push":
+			w.Header().Set("X-OAuth-Scopes", "write:packages, read:packages")
+		case "Bearer read-only":
+			w.Header().Set("X-OAuth-Scopes", "read:packages")
+		case "Bearer expiring":
+			w.Header().Set("X-OAuth-Scopes", "write:packages")
+			w.Header().Set("GitHub-Authentication-Token-Expiration", soon)
+		case "Bearer unreachable":
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}))
+	defer server.Close()
+	original := github.APIURL
+	github.APIURL = server.URL
+	t.Cleanup(func() { github.APIURL = original })
+
+	t.Cleanup(ResetCache)
+
+	ctx := context.Background()
+	check := func(registry, token string) ([]string, error) {
+		return CheckPushToken(ctx, registry, &AuthConfig{Token: token, Source: "env"})
+	}
+
+	warnings, err := check("ghcr.io/andreweick", "push")
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	_, err = check("ghcr.io/andreweick", "push")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "a token is only checked once per process")
+
+	_, err = check("ghcr.io/andreweick", "read-only")
+	assert.ErrorContains(t, err, "lacks the write:packages scope")
+	assert.ErrorContains(t, err, "has: read:packages")
+
+	warnings, err = check("ghcr.io/andreweick", "expiring")
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "expires")
+
+	warnings, err = check("ghcr.io/andreweick", "unreachable")
+	require.NoError(t, err, "an unreachable API should not block the push")
+	assert.Contains(t, warnings[0], "could not verify")
+
+	_, err = check("ghcr.io/andreweick", "revoked")
+	assert.ErrorIs(t, err, github.ErrTokenRejected)
+
+	warnings, err = check("registry.lab/team", "revoked")
+	require.NoError(t, err, "only ghcr.io tokens are checked")
+	assert.Empty(t, warnings)
+
+	warnings, err = check("ghcr.io/andreweick", "ghs_actions")
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/andreweick/iago/internal/machine"
+)
+
+// SOPSProvider reads values from SOPS-encrypted YAML or JSON files with the
+// sops CLI, referenced as "key.nested" in [secrets.sops] file or as
+// "path/to/file.sops.yaml#key.nested"
+type SOPSProvider struct {
+	cfg  machine.SOPSConfig
+	refs map[string]string
+}
+
+// NewSOPSProvider returns a provider for [secrets.sops]
+func NewSOPSProvider(cfg machine.SOPSConfig, refs map[string]string) *SOPSProvider {
+	return &SOPSProvider{cfg: cfg, refs: refs}
+}
+
+func (p *SOPSProvider) Name() string { return ProviderSOPS }
+
+func (p *SOPSProvider) Available() bool {
+	_, err := exec.LookPath("sops")
+	return err == nil
+}
+
+func (p *SOPSProvider) Ref(purpose string) (string, error) {
+	return configuredRef(ProviderSOPS, p.refs, purpose)
+}
+
+func (p *SOPSProvider) CheckRef(ref string) error {
+	_, _, err := p.splitRef(ref)
+	return err
+}
+
+func (p *SOPSProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	file, keys, err := p.splitRef(ref)
+	if err != nil {
+		return "", err
+	}
+	if !p.Available() {
+		return "", fmt.Errorf("cannot resolve %s: sops is not installed", ref)
+	}
+
+	var extract strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&extract, "[%q]", key)
+	}
+	return cachedSecret(fingerprint("sops", file, extract.String()), func() (string, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sops", "--decrypt", "--extract", extract.String(), file)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("failed to decrypt %s from %s: %w: %s", strings.Join(keys, "."), file, err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimSuffix(stdout.String(), "\n"), nil
+	})
+}
+
+// splitRef returns the file and key path a reference names
+func (p *SOPSProvider) splitRef(ref string) (string, []string, error) {
+	file, path := p.cfg.File, ref
+	if f, k, ok := strings.Cut(ref, "#"); ok {
+		file, path = f, k
+	}
+	if file == "" {
+		return "", nil, fmt.Errorf("SOPS reference %q names no file; write file#key or set [secrets.sops] file", ref)
+	}
+	keys := strings.Split(path, ".")
+	for _, key := range keys {
+		if key == "" {
+			return "", nil, fmt.Errorf("invalid SOPS reference %q: expected key or key.nested", ref)
+		}
+	}
+	return file, keys, nil
+}
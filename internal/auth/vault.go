@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/machine"
+)
+
+// ErrNoVaultToken is returned when a Vault secret is needed but no token is available
+var ErrNoVaultToken = errors.New("VAULT_TOKEN is not set and ~/.vault-token does not exist")
+
+var vaultClient = &http.Client{Timeout: 30 * time.Second}
+
+// VaultProvider reads fields of HashiCorp Vault KV v2 secrets, referenced as
+// "path/to/secret#field" below the configured mount
+type VaultProvider struct {
+	cfg  machine.VaultConfig
+	refs map[string]string
+}
+
+// NewVaultProvider returns a provider for [secrets.vault]; the address and
+// namespace fall back to VAULT_ADDR and VAULT_NAMESPACE
+func NewVaultProvider(cfg machine.VaultConfig, refs map[string]string) *VaultProvider {
+	if cfg.Address == "" {
+		cfg.Address = os.Getenv("VAULT_ADDR")
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if cfg.Mount == "" {
+		cfg.Mount = "secret"
+	}
+	return &VaultProvider{cfg: cfg, refs: refs}
+}
+
+func (p *VaultProvider) Name() string { return ProviderVault }
+
+func (p *VaultProvider) Available() bool {
+	return p.cfg.Address != "" && vaultToken() != ""
+}
+
+func (p *VaultProvider) Ref(purpose string) (string, error) {
+	return configuredRef(ProviderVault, p.refs, purpose)
+}
+
+func (p *VaultProvider) CheckRef(ref string) error {
+	_, _, err := splitVaultRef(ref)
+	return err
+}
+
+func (p *VaultProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	path, field, err := splitVaultRef(ref)
+	if err != nil {
+		return "", err
+	}
+	if p.cfg.Address == "" {
+		return "", fmt.Errorf("cannot resolve %s: set [secrets.vault] address or VAULT_ADDR", ref)
+	}
+	token := vaultToken()
+	if token == "" {
+		return "", fmt.Errorf("cannot resolve %s: %w", ref, ErrNoVaultToken)
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimRight(p.cfg.Address, "/"), strings.Trim(p.cfg.Mount, "/"), path)
+	return cachedSecret(fingerprint("vault", token, url, field), func() (string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to create Vault request: %w", err)
+		}
+		req.Header.Set("X-Vault-Token", token)
+		if p.cfg.Namespace != "" {
+			req.Header.Set("X-Vault-Namespace", p.cfg.Namespace)
+		}
+
+		resp, err := vaultClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("failed to read Vault secret %s: %w", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			return "", fmt.Errorf("failed to read Vault secret %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+		}
+
+		var secret struct {
+			Data struct {
+				Data map[string]any `json:"data"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+			return "", fmt.Errorf("failed to decode Vault secret %s: %w", path, err)
+		}
+		value, ok := secret.Data.Data[field].(string)
+		if !ok {
+			return "", fmt.Errorf("vault secret %s has no string field %q", path, field)
+		}
+		return value, nil
+	})
+}
+
+// splitVaultRef splits "path#field"
+func splitVaultRef(ref string) (string, string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	path = strings.Trim(path, "/")
+	if !ok || path == "" || field == "" {
+		return "", "", fmt.Errorf("invalid Vault reference %q: expected path/to/secret#field", ref)
+	}
+	return path, field, nil
+}
+
+// vaultToken returns VAULT_TOKEN or the token the vault CLI saved at login
+func vaultToken() string {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
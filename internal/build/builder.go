@@ -1,12 +1,18 @@
 package build
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/andreweick/iago/internal/archive"
+	"github.com/andreweick/iago/internal/audit"
 	"github.com/andreweick/iago/internal/butane"
 	"github.com/andreweick/iago/internal/machine"
 	"github.com/andreweick/iago/internal/workload"
@@ -19,15 +25,24 @@ type Builder struct {
 	loader   *machine.ConfigLoader
 	renderer *butane.Renderer
 	registry *workload.Registry
+	pinning  *sourcePinning
+	signing  *ignitionSigning
+	out      io.Writer // Receives progress and warnings; os.Stdout when nil
 }
 
 type BuildOptions struct {
 	OutputDir  string
-	StrictMode bool // Enable strict validation (treat warnings as errors)
+	StrictMode bool             // Enable strict validation (treat warnings as errors)
+	Machines   machine.Selector // Only build the machines it selects (site, group, tags)
 }
 
 func NewBuilder() (*Builder, error) {
-	loader := machine.NewConfigLoader()
+	return NewBuilderFor(machine.NewConfigLoader())
+}
+
+// NewBuilderFor returns a builder for the repository loader reads, e.g. one
+// rooted elsewhere or with an environment selected
+func NewBuilderFor(loader *machine.ConfigLoader) (*Builder, error) {
 	if err := loader.LoadAll(); err != nil {
 		return nil, fmt.Errorf("failed to load configuration: %w", err)
 	}
@@ -44,42 +59,98 @@ func NewBuilder() (*Builder, error) {
 	}
 	registry := workload.CreateDefaultRegistry(workloadDefs)
 	renderer := butane.NewRenderer(loader.GetDefaults(), registry)
+	renderer.SetFleet(loader.GetMachines())
+	renderer.SetDefaultsResolver(loader.DefaultsFor)
 
-	return &Builder{
+	b := &Builder{
 		loader:   loader,
 		renderer: renderer,
 		registry: registry,
-	}, nil
+	}
+	b.EnableSigning(loader.GetDefaults().Signing.IgnitionKey)
+	return b, nil
+}
+
+// AddPreview writes a preview of machine source for pull request pr (see
+// machine.ConfigLoader.CreatePreview) and makes it available to GenerateMachine
+func (b *Builder) AddPreview(source string, pr int) (machine.Config, error) {
+	return b.loader.CreatePreview(source, pr)
+}
+
+// ResolveConflicts asks resolve about butane merge conflicts that have no
+// recorded resolution, recording its decisions in machines/<name>/resolutions.toml
+func (b *Builder) ResolveConflicts(resolve butane.ConflictResolver) {
+	b.renderer.SetConflictResolver(resolve)
+}
+
+// SetOutput makes the builder, its renderer and source pinning print
+// progress and warnings to w instead of os.Stdout
+func (b *Builder) SetOutput(w io.Writer) {
+	b.out = w
+	b.renderer.SetOutput(w)
+	if b.pinning != nil {
+		b.pinning.setOutput(w)
+	}
+}
+
+func (b *Builder) output() io.Writer {
+	if b.out == nil {
+		return os.Stdout
+	}
+	return b.out
+}
+
+// SetContext makes rendering look up op and secret references with ctx
+func (b *Builder) SetContext(ctx context.Context) {
+	b.renderer.SetContext(ctx)
+}
+
+// Sites returns the site overlays available to BuildOptions.Machines
+func (b *Builder) Sites() []string {
+	return b.loader.Sites()
+}
+
+// MachineResult is the outcome of generating one machine in BuildAll
+type MachineResult struct {
+	Name       string
+	OutputFile string
+	Err        error
 }
 
-func (b *Builder) BuildAll(opts BuildOptions) error {
-	machines := b.loader.GetMachines()
+// BuildAll generates every machine into opts.OutputDir, continuing past failures
+func (b *Builder) BuildAll(opts BuildOptions) ([]MachineResult, error) {
+	machines := b.loader.SelectMachines(opts.Machines)
 
 	if len(machines) == 0 {
-		fmt.Println("No machines to build")
-		return nil
+		fmt.Fprintln(b.output(), "No machines to build")
+		return nil, nil
 	}
 
 	// Ensure output directory exists
 	if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	fmt.Printf("Building %d machine(s)...\n", len(machines))
+	fmt.Fprintf(b.output(), "Building %d machine(s)...\n", len(machines))
 
+	results := make([]MachineResult, 0, len(machines))
+	generated := 0
 	for _, machine := range machines {
 		outputFile := filepath.Join(opts.OutputDir, machine.Name+".ign")
-		if err := b.GenerateMachineWithOptions(machine.Name, outputFile, opts.StrictMode); err != nil {
-			fmt.Printf("✗ %s - %v\n", machine.Name, err)
+		err := b.GenerateMachineWithOptions(machine.Name, outputFile, opts.StrictMode)
+		results = append(results, MachineResult{Name: machine.Name, OutputFile: outputFile, Err: err})
+		if err != nil {
+			fmt.Fprintf(b.output(), "✗ %s - %v\n", machine.Name, err)
 			continue
 		}
-		fmt.Printf("✓ %s\n", machine.Name)
+		generated++
+		fmt.Fprintf(b.output(), "✓ %s\n", machine.Name)
 	}
 
-	fmt.Printf("\nGenerated %d ignition files in %s\n", len(machines), opts.OutputDir)
+	fmt.Fprintf(b.output(), "\nGenerated %d of %d ignition files in %s\n", generated, len(machines), opts.OutputDir)
 	b.printSecretInstructions(machines)
 
-	return nil
+	return results, nil
 }
 
 func (b *Builder) GenerateMachine(machineName, outputFile string) error {
@@ -87,21 +158,67 @@ func (b *Builder) GenerateMachine(machineName, outputFile string) error {
 }
 
 func (b *Builder) GenerateMachineWithOptions(machineName, outputFile string, strictMode bool) error {
-	machineConfig, err := b.loader.GetMachine(machineName)
+	butaneConfig, ignitionConfig, err := b.RenderMachine(machineName, strictMode)
+	if butaneConfig != "" {
+		// Save combined butane YAML for debugging, even when conversion failed
+		butaneDebugFile := filepath.Join(filepath.Dir(outputFile), machineName+"-final-butane.yaml")
+		if err := os.WriteFile(butaneDebugFile, []byte(butaneConfig), 0644); err != nil {
+			fmt.Fprintf(b.output(), "Warning: Could not write debug butane file %s: %v\n", butaneDebugFile, err)
+		}
+	}
 	if err != nil {
-		if errors.Is(err, machine.ErrMachineNotFound) {
-			// Get list of available machines for a helpful error message
-			availableMachines := b.loader.GetMachines()
-			if len(availableMachines) > 0 {
-				machineNames := make([]string, len(availableMachines))
-				for i, m := range availableMachines {
-					machineNames[i] = m.Name
-				}
-				return fmt.Errorf("machine '%s' not found. Available machines: %s", machineName, strings.Join(machineNames, ", "))
-			}
-			return fmt.Errorf("machine '%s' not found. No machines configured. Use 'iago init %s' to create it", machineName, machineName)
+		return err
+	}
+
+	// Write ignition JSON to output file
+	if err := os.WriteFile(outputFile, ignitionConfig, 0644); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+
+	if b.signing != nil {
+		if err := b.signing.signFile(outputFile, machineName, ignitionConfig); err != nil {
+			return fmt.Errorf("failed to sign %s: %w", outputFile, err)
 		}
-		return fmt.Errorf("failed to get machine: %w", err)
+	}
+
+	if err := b.recordChecksum(outputFile, machineName, ignitionConfig); err != nil {
+		return err
+	}
+
+	return b.archiveGeneration(machineName, map[string][]byte{
+		filepath.Base(outputFile):          ignitionConfig,
+		machineName + "-final-butane.yaml": []byte(butaneConfig),
+	})
+}
+
+// archiveGeneration keeps a timestamped copy of a machine's outputs. Older
+// generations are pruned only when [output] keep is set; otherwise that is
+// left to iago gc output.
+func (b *Builder) archiveGeneration(machineName string, files map[string][]byte) error {
+	output := b.loader.GetDefaults().Output
+	dir, keep := archive.Retention(output)
+	if keep == 0 {
+		return nil
+	}
+	meta := archive.NewMetadata(machineName, audit.CurrentUser(), time.Now())
+	if _, err := archive.Save(dir, meta, files); err != nil {
+		return err
+	}
+	if output.Keep <= 0 {
+		return nil
+	}
+	if _, err := archive.Prune(dir, machineName, keep, false); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RenderMachine renders a machine's butane and converts it to validated ignition
+// without writing anything to disk. The butane is returned whenever rendering succeeded.
+func (b *Builder) RenderMachine(machineName string, strictMode bool) (string, []byte, error) {
+	machineConfig, err := b.getMachine(machineName)
+	if err != nil {
+		return "", nil, err
 	}
 
 	// Create a default workload implementation for the machine
@@ -115,42 +232,86 @@ func (b *Builder) GenerateMachineWithOptions(machineName, outputFile string, str
 	}
 
 	if err := workloadImpl.Validate(workloadConfig); err != nil {
-		return fmt.Errorf("workload validation failed: %w", err)
+		return "", nil, fmt.Errorf("workload validation failed: %w", err)
 	}
 
 	// Render butane configuration
-	butaneConfig, err := b.renderer.RenderMachine(machineConfig)
+	butaneConfig, sources, err := b.renderer.RenderMachineSources(machineConfig)
 	if err != nil {
-		return fmt.Errorf("failed to render butane: %w", err)
+		return "", nil, fmt.Errorf("failed to render butane: %w", err)
+	}
+
+	// Resolve and pin remote file sources if enabled
+	if b.pinning != nil {
+		pinned, err := b.pinning.pinRemoteSources([]byte(butaneConfig))
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to pin remote sources: %w", err)
+		}
+		butaneConfig = string(pinned)
 	}
 
-	// Save combined butane YAML for debugging
-	butaneDebugFile := filepath.Join(filepath.Dir(outputFile), machineConfig.Name+"-final-butane.yaml")
-	if err := os.WriteFile(butaneDebugFile, []byte(butaneConfig), 0644); err != nil {
-		fmt.Printf("Warning: Could not write debug butane file %s: %v\n", butaneDebugFile, err)
+	// Emit an older ignition spec for machines whose OS rejects the template's
+	if machineConfig.IgnitionVersion != "" {
+		butaneConfig, err = butane.TargetIgnitionVersion(butaneConfig, machineConfig.IgnitionVersion)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to target ignition spec %s: %w", machineConfig.IgnitionVersion, err)
+		}
 	}
 
 	// Convert butane YAML to ignition JSON
-	ignitionConfig, err := b.convertButaneToIgnition([]byte(butaneConfig), strictMode)
+	ignitionConfig, err := b.convertButaneToIgnition([]byte(butaneConfig), strictMode, machineConfig.IgnitionVersion, sources)
 	if err != nil {
-		return fmt.Errorf("failed to convert butane to ignition: %w", err)
+		return butaneConfig, nil, fmt.Errorf("failed to convert butane to ignition: %w", err)
 	}
 
 	// Validate the generated ignition configuration
 	if err := b.ValidateIgnitionConfig(ignitionConfig); err != nil {
-		return fmt.Errorf("failed to validate generated ignition config: %w", err)
+		return butaneConfig, nil, fmt.Errorf("failed to validate generated ignition config: %w", err)
 	}
 
-	// Write ignition JSON to output file
-	if err := os.WriteFile(outputFile, ignitionConfig, 0644); err != nil {
-		return fmt.Errorf("failed to write output file: %w", err)
+	return butaneConfig, ignitionConfig, nil
+}
+
+// getMachine returns a machine, listing the available ones when it doesn't exist
+func (b *Builder) getMachine(machineName string) (machine.Config, error) {
+	machineConfig, err := b.loader.GetMachine(machineName)
+	if err != nil {
+		if errors.Is(err, machine.ErrMachineNotFound) {
+			// Get list of available machines for a helpful error message
+			availableMachines := b.loader.GetMachines()
+			if len(availableMachines) > 0 {
+				machineNames := make([]string, len(availableMachines))
+				for i, m := range availableMachines {
+					machineNames[i] = m.Name
+				}
+				return machine.Config{}, fmt.Errorf("machine '%s' not found. Available machines: %s", machineName, strings.Join(machineNames, ", "))
+			}
+			return machine.Config{}, fmt.Errorf("machine '%s' not found. No machines configured. Use 'iago init %s' to create it", machineName, machineName)
+		}
+		return machine.Config{}, fmt.Errorf("failed to get machine: %w", err)
+	}
+	return machineConfig, nil
+}
+
+// DebugData returns the data a machine's templates are rendered with, secrets redacted
+func (b *Builder) DebugData(machineName string) (butane.TemplateData, error) {
+	machineConfig, err := b.getMachine(machineName)
+	if err != nil {
+		return butane.TemplateData{}, err
 	}
+	return b.renderer.DebugData(machineConfig)
+}
 
-	return nil
+// TraceRendering reports the templates and template functions rendering executes to w
+func (b *Builder) TraceRendering(w io.Writer) {
+	b.renderer.SetTrace(w)
 }
 
-// convertButaneToIgnition converts a Butane YAML configuration to Ignition JSON
-func (b *Builder) convertButaneToIgnition(butaneYAML []byte, strictMode bool) ([]byte, error) {
+// convertButaneToIgnition converts a Butane YAML configuration to Ignition JSON.
+// With ignitionVersion set the config was downgraded to that spec, and keys
+// the older spec doesn't know are errors rather than being dropped. Errors
+// point at the template lines sources maps the offending entries to.
+func (b *Builder) convertButaneToIgnition(butaneYAML []byte, strictMode bool, ignitionVersion string, sources *butane.SourceMap) ([]byte, error) {
 	// Configure translation options with strict validation
 	options := common.TranslateBytesOptions{
 		TranslateOptions: common.TranslateOptions{
@@ -163,8 +324,9 @@ func (b *Builder) convertButaneToIgnition(butaneYAML []byte, strictMode bool) ([
 	}
 
 	// Use the butane config package to translate from Butane to Ignition
+	// An invalid config comes with the report saying why, handled below
 	ignitionJSON, report, err := config.TranslateBytes(butaneYAML, options)
-	if err != nil {
+	if err != nil && len(report.Entries) == 0 {
 		return nil, fmt.Errorf("failed to translate butane config: %w", err)
 	}
 
@@ -172,31 +334,41 @@ func (b *Builder) convertButaneToIgnition(butaneYAML []byte, strictMode bool) ([
 	if len(report.Entries) > 0 {
 		var warnings []string
 		var errors []string
+		var errorExcerpts, warningExcerpts excerpts
 
 		for _, entry := range report.Entries {
 			entryStr := entry.String()
+			source, located := sources.Locate(entry.Context.Path)
+			if located {
+				entryStr += fmt.Sprintf(" (%s)", source)
+			}
 			if entry.Kind.IsFatal() {
 				errors = append(errors, entryStr)
+				errorExcerpts.add(sources, source)
+			} else if ignitionVersion != "" && strings.HasPrefix(entry.Message, "Unused key") {
+				errors = append(errors, fmt.Sprintf("%s (not supported by ignition spec %s)", entryStr, ignitionVersion))
+				errorExcerpts.add(sources, source)
 			} else {
 				// Treat non-fatal entries as warnings
 				warnings = append(warnings, entryStr)
+				warningExcerpts.add(sources, source)
 			}
 		}
 
 		// Always fail on errors
 		if len(errors) > 0 {
-			return nil, fmt.Errorf("butane translation failed with errors: %s", strings.Join(errors, ", "))
+			return nil, fmt.Errorf("butane translation failed with errors: %s%s", strings.Join(errors, ", "), errorExcerpts)
 		}
 
 		// In strict mode, fail on any warnings
 		if strictMode && len(warnings) > 0 {
-			fmt.Printf("Butane translation warnings (strict mode): %s\n", strings.Join(warnings, ", "))
-			return nil, fmt.Errorf("butane translation failed in strict mode due to warnings: %s", strings.Join(warnings, ", "))
+			fmt.Fprintf(b.output(), "Butane translation warnings (strict mode): %s\n", strings.Join(warnings, ", "))
+			return nil, fmt.Errorf("butane translation failed in strict mode due to warnings: %s%s", strings.Join(warnings, ", "), warningExcerpts)
 		}
 
 		// In non-strict mode, just log warnings
 		if len(warnings) > 0 {
-			fmt.Printf("Butane translation warnings: %s\n", strings.Join(warnings, ", "))
+			fmt.Fprintf(b.output(), "Butane translation warnings: %s\n", strings.Join(warnings, ", "))
 		}
 	}
 
@@ -204,10 +376,31 @@ func (b *Builder) convertButaneToIgnition(butaneYAML []byte, strictMode bool) ([
 	if report.IsFatal() {
 		return nil, fmt.Errorf("butane translation failed with fatal errors: %s", report.String())
 	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to translate butane config: %w", err)
+	}
 
 	return ignitionJSON, nil
 }
 
+// excerpts collects the template excerpts of translation report entries, once each
+type excerpts []string
+
+func (e *excerpts) add(sources *butane.SourceMap, source butane.Source) {
+	excerpt := sources.Excerpt(source)
+	if excerpt != "" && !slices.Contains(*e, excerpt) {
+		*e = append(*e, excerpt)
+	}
+}
+
+// String returns the excerpts on lines following an error message
+func (e excerpts) String() string {
+	if len(e) == 0 {
+		return ""
+	}
+	return "\n" + strings.Join(e, "\n")
+}
+
 // ValidateIgnitionConfig validates a generated ignition JSON configuration
 func (b *Builder) ValidateIgnitionConfig(ignitionJSON []byte) error {
 	// Parse and validate the ignition configuration
@@ -230,7 +423,7 @@ func (b *Builder) ValidateIgnitionConfig(ignitionJSON []byte) error {
 			}
 		}
 		if len(warnings) > 0 {
-			fmt.Printf("Ignition validation warnings: %s\n", strings.Join(warnings, ", "))
+			fmt.Fprintf(b.output(), "Ignition validation warnings: %s\n", strings.Join(warnings, ", "))
 		}
 	}
 
@@ -251,12 +444,12 @@ func (b *Builder) printSecretInstructions(machines []machine.Config) {
 		return
 	}
 
-	fmt.Println("\nSecrets generated for:")
+	fmt.Fprintln(b.output(), "\nSecrets generated for:")
 	for _, machine := range machines {
 		workloadImpl := b.registry.GetDefault(machine.Name)
 		secrets := workloadImpl.GetSecrets()
 		if len(secrets) > 0 {
-			fmt.Printf("- %s: SSH to %s, check /etc/iago/secrets/\n", machine.Name, machine.FQDN)
+			fmt.Fprintf(b.output(), "- %s: SSH to %s, check /etc/iago/secrets/\n", machine.Name, machine.FQDN)
 		}
 	}
 }
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/andreweick/iago/internal/archive"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -479,3 +480,135 @@ func TestBuilderValidationIntegration(t *testing.T) {
 	assert.Contains(t, ignitionObj, "ignition", "Should contain ignition section")
 	assert.Contains(t, ignitionObj, "storage", "Should contain storage section")
 }
+
+func TestGenerateMachineArchivesGenerations(t *testing.T) {
+	tempDir := t.TempDir()
+	configDir := filepath.Join(tempDir, "config")
+	outputDir := filepath.Join(tempDir, "output", "ignition")
+	require.NoError(t, os.MkdirAll(configDir, 0755))
+	require.NoError(t, os.MkdirAll(outputDir, 0755))
+	createDefaultsToml(t, configDir)
+	createMachineStructure(t, tempDir, "test-machine", "test-machine.example.com")
+
+	defaultsFile, err := os.OpenFile(filepath.Join(configDir, "defaults.toml"), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = defaultsFile.WriteString("\n[output]\nkeep = 2\n")
+	require.NoError(t, err)
+	require.NoError(t, defaultsFile.Close())
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	builder, err := NewBuilder()
+	require.NoError(t, err)
+
+	outputFile := filepath.Join(outputDir, "test-machine.ign")
+	for i := 0; i < 3; i++ {
+		require.NoError(t, builder.GenerateMachine("test-machine", outputFile))
+	}
+
+	gens, err := archive.List(archive.DefaultDir, "test-machine")
+	require.NoError(t, err)
+	require.Len(t, gens, 2, "only the newest generations should be kept")
+
+	archived, err := gens[1].ReadFile("test-machine.ign")
+	require.NoError(t, err)
+	current, err := os.ReadFile(outputFile)
+	require.NoError(t, err)
+	assert.Equal(t, current, archived)
+	meta, err := gens[1].Metadata()
+	require.NoError(t, err)
+	assert.Contains(t, meta.Files, "test-machine-final-butane.yaml")
+}
+
+func TestRenderMachineIgnitionVersion(t *testing.T) {
+	tempDir := t.TempDir()
+	configDir := filepath.Join(tempDir, "config")
+	require.NoError(t, os.MkdirAll(configDir, 0755))
+	createDefaultsToml(t, configDir)
+	createMachineStructure(t, tempDir, "old-node", "old-node.example.com")
+	machineDir := filepath.Join(tempDir, "machines", "old-node")
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	machineToml := filepath.Join(machineDir, "machine.toml")
+	original, err := os.ReadFile(machineToml)
+	require.NoError(t, err)
+	render := func(ignitionVersion string) (string, []byte, error) {
+		content := string(original) + fmt.Sprintf("\nignition_version = %q\n", ignitionVersion)
+		require.NoError(t, os.WriteFile(machineToml, []byte(content), 0644))
+		builder, err := NewBuilder()
+		require.NoError(t, err)
+		return builder.RenderMachine("old-node", false)
+	}
+
+	butaneConfig, ignitionJSON, err := render("3.3.0")
+	require.NoError(t, err)
+	assert.Contains(t, butaneConfig, "version: 1.4.0")
+	var parsed struct {
+		Ignition struct {
+			Version string `json:"version"`
+		} `json:"ignition"`
+	}
+	require.NoError(t, json.Unmarshal(ignitionJSON, &parsed))
+	assert.Equal(t, "3.3.0", parsed.Ignition.Version)
+
+	// kernel_arguments arrived in fcos 1.4.0 / ignition 3.3.0
+	template := filepath.Join(machineDir, "butane.yaml.tmpl")
+	content, err := os.ReadFile(template)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(template, append(content, "\nkernel_arguments:\n  should_exist:\n    - mitigations=auto\n"...), 0644))
+	_, _, err = render("3.2.0")
+	assert.ErrorContains(t, err, "not supported by ignition spec 3.2.0")
+
+	_, _, err = render("3.5.0")
+	assert.ErrorContains(t, err, "raise its version")
+}
+
+func TestRenderMachineTranslationErrorSource(t *testing.T) {
+	tempDir := t.TempDir()
+	configDir := filepath.Join(tempDir, "config")
+	require.NoError(t, os.MkdirAll(configDir, 0755))
+	createDefaultsToml(t, configDir)
+	createMachineStructure(t, tempDir, "web", "web.example.com")
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	// The bad entry is on line 10 of the template but further down the
+	// rendered config, after the range expands
+	template := `variant: fcos
+version: 1.5.0
+storage:
+  files:
+{{- range list "a" "b" "c" }}
+    - path: /etc/{{ . }}
+      contents:
+        inline: {{ . }}
+{{- end }}
+    - path: etc/relative
+      contents:
+        inline: oops
+`
+	require.NoError(t, os.WriteFile(filepath.Join("machines", "web", "butane.yaml.tmpl"), []byte(template), 0644))
+
+	builder, err := NewBuilder()
+	require.NoError(t, err)
+	_, _, err = builder.RenderMachine("web", false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "(machines/web/butane.yaml.tmpl:10)")
+	assert.Contains(t, err.Error(), "  >   10 |     - path: etc/relative\n")
+}
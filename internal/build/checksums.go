@@ -0,0 +1,196 @@
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/andreweick/iago/internal/signing"
+)
+
+// ChecksumsFile lists the SHA-256 of every ignition file in its directory,
+// in `sha256sum -c` format
+const ChecksumsFile = "SHA256SUMS"
+
+// recordChecksum rewrites the SHA256SUMS next to path with the checksum of
+// content. Entries of other outputs are carried over only while their file
+// exists and, with a loader, belongs to a configured machine, so removed and
+// renamed machines drop out.
+func (b *Builder) recordChecksum(path, machineName string, content []byte) error {
+	dir := filepath.Dir(path)
+	sumsPath := filepath.Join(dir, ChecksumsFile)
+	sums, err := readChecksums(sumsPath)
+	if err != nil {
+		return err
+	}
+	outputs := b.machineOutputs()
+	for name := range sums {
+		if outputs != nil && !outputs[name] {
+			delete(sums, name)
+		} else if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			delete(sums, name)
+		}
+	}
+	sums[filepath.Base(path)] = fmt.Sprintf("%x", sha256.Sum256(content))
+
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s  %s\n", sums[name], name)
+	}
+	if err := os.WriteFile(sumsPath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", sumsPath, err)
+	}
+
+	if b.signing != nil {
+		if err := b.signing.signFile(sumsPath, machineName, buf.Bytes()); err != nil {
+			return fmt.Errorf("failed to sign %s: %w", sumsPath, err)
+		}
+	}
+	return nil
+}
+
+// machineOutputs returns the ignition file names of the configured machines,
+// or nil without a loader
+func (b *Builder) machineOutputs() map[string]bool {
+	if b.loader == nil {
+		return nil
+	}
+	outputs := make(map[string]bool)
+	for _, m := range b.loader.GetMachines() {
+		outputs[m.Name+".ign"] = true
+	}
+	return outputs
+}
+
+// VerifyChecksum checks an ignition file against the SHA256SUMS in its directory,
+// catching files that were edited or only partially regenerated since ignite
+func VerifyChecksum(path string) error {
+	sumsPath := filepath.Join(filepath.Dir(path), ChecksumsFile)
+	sums, err := readChecksums(sumsPath)
+	if err != nil {
+		return err
+	}
+
+	expected, ok := sums[filepath.Base(path)]
+	if !ok {
+		return fmt.Errorf("%s is not listed in %s; regenerate it with 'iago ignite'", path, sumsPath)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if actual := fmt.Sprintf("%x", sha256.Sum256(content)); actual != expected {
+		return fmt.Errorf("%s does not match %s (expected %s, got %s); regenerate it with 'iago ignite'", path, sumsPath, expected, actual)
+	}
+	return nil
+}
+
+// VerifySignedChecksums checks the SHA256SUMS.minisig in dir, when ignite
+// signed the checksums, against the public key at publicKeyPath
+func VerifySignedChecksums(dir, publicKeyPath string) error {
+	sumsPath := filepath.Join(dir, ChecksumsFile)
+	signature, err := os.ReadFile(sumsPath + signing.SignatureExt)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", sumsPath+signing.SignatureExt, err)
+	}
+	if publicKeyPath == "" {
+		return fmt.Errorf("%s is signed but no public key is configured; set [signing] ignition_public_key in defaults.toml", sumsPath)
+	}
+	publicKey, err := signing.LoadPublicKey(expandHome(publicKeyPath))
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(sumsPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", sumsPath, err)
+	}
+	if _, err := publicKey.Verify(content, signature); err != nil {
+		return fmt.Errorf("%s: %w", sumsPath, err)
+	}
+	return nil
+}
+
+// VerifyChecksums checks every ignition file in dir against its SHA256SUMS,
+// returning one problem per stale, missing or unlisted file
+func VerifyChecksums(dir string) ([]string, error) {
+	sumsPath := filepath.Join(dir, ChecksumsFile)
+	if _, err := os.Stat(sumsPath); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", sumsPath, err)
+	}
+	sums, err := readChecksums(sumsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.ign"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	seen := make(map[string]bool)
+	var problems []string
+	for _, file := range files {
+		seen[filepath.Base(file)] = true
+		if err := VerifyChecksum(file); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !seen[name] {
+			problems = append(problems, fmt.Sprintf("%s is listed in %s but missing", filepath.Join(dir, name), sumsPath))
+		}
+	}
+	return problems, nil
+}
+
+// readChecksums parses a SHA256SUMS file into name -> hex digest, returning
+// an empty map if it doesn't exist
+func readChecksums(path string) (map[string]string, error) {
+	sums := make(map[string]string)
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return sums, nil
+		}
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		digest, name, ok := strings.Cut(line, "  ")
+		if !ok {
+			return nil, fmt.Errorf("invalid line in %s: %q", path, line)
+		}
+		sums[strings.TrimPrefix(name, "*")] = digest
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return sums, nil
+}
@@ -0,0 +1,124 @@
+package build
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/signing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordChecksum(t *testing.T) {
+	dir := t.TempDir()
+	b := &Builder{}
+
+	for name, content := range map[string]string{"web.ign": `{"a":1}`, "db.ign": `{"b":2}`} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		require.NoError(t, b.recordChecksum(path, name, []byte(content)))
+	}
+
+	sums, err := os.ReadFile(filepath.Join(dir, ChecksumsFile))
+	require.NoError(t, err)
+	assert.Regexp(t, `^[0-9a-f]{64}  db\.ign\n[0-9a-f]{64}  web\.ign\n$`, string(sums), "entries should be sorted by name")
+
+	assert.NoError(t, VerifyChecksum(filepath.Join(dir, "web.ign")))
+	problems, err := VerifyChecksums(dir)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+func TestRecordChecksum_DropsStaleEntries(t *testing.T) {
+	tempDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tempDir, "config"), 0755))
+	createDefaultsToml(t, filepath.Join(tempDir, "config"))
+	createMachineStructure(t, tempDir, "web", "web.example.com")
+	b, err := NewBuilderFor(machine.NewConfigLoaderAt(tempDir))
+	require.NoError(t, err)
+
+	dir := filepath.Join(tempDir, "output")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	for _, name := range []string{"web.ign", "db.ign", "old-web.ign"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644))
+	}
+	// db.ign belonged to a removed machine, old-web.ign to a renamed one
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ChecksumsFile),
+		[]byte(strings.Repeat("0", 64)+"  db.ign\n"+strings.Repeat("0", 64)+"  old-web.ign\n"), 0644))
+
+	require.NoError(t, b.recordChecksum(filepath.Join(dir, "web.ign"), "web", []byte("{}")))
+
+	sums, err := readChecksums(filepath.Join(dir, ChecksumsFile))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web.ign"}, slices.Sorted(maps.Keys(sums)))
+}
+
+func TestVerifyChecksums_Problems(t *testing.T) {
+	dir := t.TempDir()
+	b := &Builder{}
+
+	web := filepath.Join(dir, "web.ign")
+	require.NoError(t, os.WriteFile(web, []byte("{}"), 0644))
+	require.NoError(t, b.recordChecksum(web, "web", []byte("{}")))
+	db := filepath.Join(dir, "db.ign")
+	require.NoError(t, os.WriteFile(db, []byte("{}"), 0644))
+	require.NoError(t, b.recordChecksum(db, "db", []byte("{}")))
+
+	// Stale: regenerated without updating the manifest
+	require.NoError(t, os.WriteFile(web, []byte(`{"changed":true}`), 0644))
+	// Missing: listed but deleted
+	require.NoError(t, os.Remove(db))
+	// Unlisted: never produced by ignite
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "old.ign"), []byte("{}"), 0644))
+
+	assert.ErrorContains(t, VerifyChecksum(web), "does not match")
+
+	problems, err := VerifyChecksums(dir)
+	require.NoError(t, err)
+	require.Len(t, problems, 3)
+	assert.Contains(t, problems[0], "old.ign is not listed")
+	assert.Contains(t, problems[1], "web.ign does not match")
+	assert.Contains(t, problems[2], "db.ign is listed")
+
+	_, err = VerifyChecksums(t.TempDir())
+	assert.ErrorContains(t, err, "failed to read")
+}
+
+func TestRecordChecksum_Signed(t *testing.T) {
+	dir := t.TempDir()
+	publicKey, secretKey, err := signing.GenerateKey()
+	require.NoError(t, err)
+	keyPath := filepath.Join(dir, "iago.key")
+	require.NoError(t, os.WriteFile(keyPath, secretKey, 0600))
+
+	b := &Builder{}
+	b.EnableSigning(keyPath)
+	path := filepath.Join(dir, "web.ign")
+	require.NoError(t, os.WriteFile(path, []byte("{}"), 0644))
+	require.NoError(t, b.recordChecksum(path, "web", []byte("{}")))
+
+	sumsPath := filepath.Join(dir, ChecksumsFile)
+	sums, err := os.ReadFile(sumsPath)
+	require.NoError(t, err)
+	signature, err := os.ReadFile(sumsPath + signing.SignatureExt)
+	require.NoError(t, err)
+	pk, err := signing.ParsePublicKey(publicKey)
+	require.NoError(t, err)
+	_, err = pk.Verify(sums, signature)
+	assert.NoError(t, err)
+
+	publicKeyPath := filepath.Join(dir, "iago.pub")
+	require.NoError(t, os.WriteFile(publicKeyPath, publicKey, 0644))
+	assert.NoError(t, VerifySignedChecksums(dir, publicKeyPath))
+	assert.ErrorContains(t, VerifySignedChecksums(dir, ""), "no public key is configured")
+
+	require.NoError(t, os.WriteFile(sumsPath, append(sums, "0000  extra.ign\n"...), 0644))
+	assert.Error(t, VerifySignedChecksums(dir, publicKeyPath))
+
+	assert.NoError(t, VerifySignedChecksums(t.TempDir(), ""), "unsigned checksums need no key")
+}
@@ -0,0 +1,132 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/andreweick/iago/internal/butane"
+	"github.com/andreweick/iago/internal/fetch"
+	"github.com/andreweick/iago/internal/lock"
+	"github.com/andreweick/iago/internal/store"
+)
+
+// frozenSecrets are the frozen inputs kept in workstation state rather than
+// in iago.lock, which is committed
+type frozenSecrets struct {
+	Password string            `json:"password"`
+	Fetched  map[string][]byte `json:"fetched,omitempty"` // fetchURL content by URL
+}
+
+// Freeze renders a machine and records its inputs so EnableFrozen reproduces
+// its ignition: SSH keys, fetchURL checksums, remote source pins and
+// imageDigest, what the machine's image resolves to now, go in the lock at
+// lockPath; the generated password and fetched content go in st. Remote
+// sources and fetchURL content that changed since they were pinned fail
+// unless updateLock is set.
+func (b *Builder) Freeze(machineName, lockPath string, st store.Store, imageDigest string, updateLock bool) (lock.Frozen, error) {
+	m, err := b.getMachine(machineName)
+	if err != nil {
+		return lock.Frozen{}, err
+	}
+
+	var inputs butane.FrozenInputs
+	b.renderer.RecordInputs(func(_ string, recorded butane.FrozenInputs) {
+		inputs = recorded
+	})
+	defer b.renderer.RecordInputs(nil)
+	b.EnableSourcePinning(lockPath, updateLock)
+	if _, _, err := b.RenderMachine(m.Name, false); err != nil {
+		return lock.Frozen{}, err
+	}
+
+	frozen := lock.Frozen{Name: m.Name, SSHKeys: inputs.SSHKeys, AdminSSHKeys: inputs.AdminSSHKeys, ImageDigest: imageDigest}
+	if imageDigest != "" {
+		frozen.Image = m.ImageReference()
+	}
+	for url, content := range inputs.Fetched {
+		frozen.Fetches = append(frozen.Fetches, lock.Resource{URL: url, Checksum: fetch.Checksum(content)})
+	}
+
+	// Loaded after rendering, which pins remote sources in the same file
+	lockFile, err := lock.Load(lockPath)
+	if err != nil {
+		return lock.Frozen{}, err
+	}
+	if previous, ok := lockFile.Frozen(m.Name); ok && !updateLock {
+		for _, pinned := range previous.Fetches {
+			content, ok := inputs.Fetched[pinned.URL]
+			if ok && fetch.Checksum(content) != pinned.Checksum {
+				return lock.Frozen{}, fmt.Errorf("content of %s changed: frozen %s in %s, got %s (re-run with --update-lock to accept)",
+					pinned.URL, pinned.Checksum, lockPath, fetch.Checksum(content))
+			}
+		}
+	}
+	lockFile.SetFrozen(frozen)
+	if err := lockFile.Save(lockPath); err != nil {
+		return lock.Frozen{}, err
+	}
+
+	content, err := json.Marshal(frozenSecrets{Password: inputs.Password, Fetched: inputs.Fetched})
+	if err != nil {
+		return lock.Frozen{}, fmt.Errorf("failed to encode frozen secrets: %w", err)
+	}
+	if err := st.Put(store.BucketFrozen, m.Name, content); err != nil {
+		return lock.Frozen{}, fmt.Errorf("failed to store frozen secrets of %s: %w", m.Name, err)
+	}
+	return frozen, nil
+}
+
+// EnableFrozen makes generation use only what Freeze recorded in the lock at
+// lockPath and in st, so nothing is fetched and every run's ignition is
+// byte-identical. Machines that weren't frozen fail to render.
+func (b *Builder) EnableFrozen(lockPath string, st store.Store) {
+	b.EnableSourcePinning(lockPath, false)
+	b.pinning.frozen = true
+	b.renderer.UseFrozenInputs(func(machineName string) (butane.FrozenInputs, error) {
+		return loadFrozen(machineName, lockPath, st)
+	})
+}
+
+// loadFrozen returns a machine's frozen inputs, checking the content kept in
+// st against the checksums in the lock
+func loadFrozen(machineName, lockPath string, st store.Store) (butane.FrozenInputs, error) {
+	lockFile, err := lock.Load(lockPath)
+	if err != nil {
+		return butane.FrozenInputs{}, err
+	}
+	frozen, ok := lockFile.Frozen(machineName)
+	if !ok {
+		return butane.FrozenInputs{}, fmt.Errorf("%s is not frozen in %s; run 'iago freeze %s'", machineName, lockPath, machineName)
+	}
+
+	content, err := st.Get(store.BucketFrozen, machineName)
+	if err != nil {
+		return butane.FrozenInputs{}, err
+	}
+	if content == nil {
+		return butane.FrozenInputs{}, fmt.Errorf("%s was frozen on another workstation; its generated secrets aren't in %s, run 'iago freeze %s' here", machineName, store.DefaultPath, machineName)
+	}
+	var secrets frozenSecrets
+	if err := json.Unmarshal(content, &secrets); err != nil {
+		return butane.FrozenInputs{}, fmt.Errorf("failed to parse frozen secrets of %s: %w", machineName, err)
+	}
+
+	inputs := butane.FrozenInputs{
+		Password:     secrets.Password,
+		SSHKeys:      frozen.SSHKeys,
+		AdminSSHKeys: frozen.AdminSSHKeys,
+		Fetched:      make(map[string][]byte),
+		ImageDigest:  frozen.ImageDigest,
+	}
+	for _, pin := range frozen.Fetches {
+		content, ok := secrets.Fetched[pin.URL]
+		if !ok {
+			return butane.FrozenInputs{}, fmt.Errorf("%s is pinned for %s in %s but its content isn't in %s; run 'iago freeze %s'", pin.URL, machineName, lockPath, store.DefaultPath, machineName)
+		}
+		if err := fetch.VerifyChecksum(content, pin.Checksum); err != nil {
+			return butane.FrozenInputs{}, fmt.Errorf("frozen content of %s: %w", pin.URL, err)
+		}
+		inputs.Fetched[pin.URL] = content
+	}
+	return inputs, nil
+}
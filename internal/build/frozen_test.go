@@ -0,0 +1,127 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreweick/iago/internal/lock"
+	"github.com/andreweick/iago/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreezeAndFrozenGeneration(t *testing.T) {
+	tempDir := t.TempDir()
+	configDir := filepath.Join(tempDir, "config")
+	require.NoError(t, os.MkdirAll(configDir, 0755))
+	createDefaultsToml(t, configDir)
+	createMachineStructure(t, tempDir, "web", "web.example.com")
+	createMachineStructure(t, tempDir, "db", "db.example.com")
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "machines", "web", "butane.yaml.tmpl"), []byte(`variant: fcos
+version: 1.5.0
+storage:
+  files:
+    - path: /etc/iago/password
+      contents:
+        inline: "{{ .GeneratedSecrets.Password }}"
+`), 0644))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	db, err := store.Open(store.DefaultPath)
+	require.NoError(t, err)
+	defer db.Close()
+	builder, err := NewBuilder()
+	require.NoError(t, err)
+	frozen, err := builder.Freeze("web", lock.DefaultPath, db, "sha256:abc", false)
+	require.NoError(t, err)
+	assert.Equal(t, "registry.example.com/web:latest", frozen.Image)
+
+	lockFile, err := lock.Load(lock.DefaultPath)
+	require.NoError(t, err)
+	_, ok := lockFile.Frozen("web")
+	assert.True(t, ok)
+	content, err := os.ReadFile(lock.DefaultPath)
+	require.NoError(t, err)
+	stored, err := db.Get(store.BucketFrozen, "web")
+	require.NoError(t, err)
+	var secrets frozenSecrets
+	require.NoError(t, json.Unmarshal(stored, &secrets))
+	require.NotEmpty(t, secrets.Password)
+	assert.NotContains(t, string(content), secrets.Password, "the generated password stays out of the lock")
+
+	builder, err = NewBuilder()
+	require.NoError(t, err)
+	builder.EnableFrozen(lock.DefaultPath, db)
+	butaneYAML, first, err := builder.RenderMachine("web", false)
+	require.NoError(t, err)
+	_, second, err := builder.RenderMachine("web", false)
+	require.NoError(t, err)
+	assert.Equal(t, first, second, "frozen ignition is byte-identical")
+	assert.Contains(t, butaneYAML, secrets.Password, "the frozen password is used")
+
+	_, _, err = builder.RenderMachine("db", false)
+	assert.ErrorContains(t, err, "db is not frozen in iago.lock")
+
+	require.NoError(t, db.Delete(store.BucketFrozen, "web"))
+	_, _, err = builder.RenderMachine("web", false)
+	assert.ErrorContains(t, err, "was frozen on another workstation")
+}
+
+func TestFreeze_ChangedPins(t *testing.T) {
+	body := "net.ipv4.ip_forward = 1\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	tempDir := t.TempDir()
+	configDir := filepath.Join(tempDir, "config")
+	require.NoError(t, os.MkdirAll(configDir, 0755))
+	createDefaultsToml(t, configDir)
+	createMachineStructure(t, tempDir, "web", "web.example.com")
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "machines", "web", "butane.yaml.tmpl"), []byte(fmt.Sprintf(`variant: fcos
+version: 1.5.0
+storage:
+  files:
+    - path: /etc/sysctl.d/90-vendor.conf
+      contents:
+        source: %s/sysctl.conf
+`, server.URL)), 0644))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	db, err := store.Open(store.DefaultPath)
+	require.NoError(t, err)
+	defer db.Close()
+	freeze := func(updateLock bool) error {
+		builder, err := NewBuilder()
+		require.NoError(t, err)
+		_, err = builder.Freeze("web", lock.DefaultPath, db, "", updateLock)
+		return err
+	}
+	require.NoError(t, freeze(false))
+
+	body = "net.ipv4.ip_forward = 0\n"
+	err = freeze(false)
+	assert.ErrorContains(t, err, "changed")
+	assert.ErrorContains(t, err, "--update-lock")
+
+	require.NoError(t, freeze(true), "--update-lock accepts the new content")
+	require.NoError(t, freeze(false))
+}
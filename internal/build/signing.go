@@ -0,0 +1,53 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/signing"
+)
+
+// ignitionSigning signs generated ignition files, loading the key on first use
+// so commands that only render never need the key's password
+type ignitionSigning struct {
+	keyPath string
+	key     *signing.PrivateKey
+}
+
+// EnableSigning makes ignite write a minisign signature next to each ignition file
+func (b *Builder) EnableSigning(keyPath string) {
+	if keyPath == "" {
+		b.signing = nil
+		return
+	}
+	b.signing = &ignitionSigning{keyPath: expandHome(keyPath)}
+}
+
+// signFile writes <path>.minisig for the ignition file at path
+func (s *ignitionSigning) signFile(path, machineName string, content []byte) error {
+	if s.key == nil {
+		key, err := signing.LoadPrivateKey(s.keyPath)
+		if err != nil {
+			return err
+		}
+		s.key = &key
+	}
+
+	comment := fmt.Sprintf("timestamp:%d\tfile:%s\tmachine:%s", time.Now().Unix(), filepath.Base(path), machineName)
+	if err := os.WriteFile(path+signing.SignatureExt, s.key.Sign(content, comment), 0644); err != nil {
+		return fmt.Errorf("failed to write signature: %w", err)
+	}
+	return nil
+}
+
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
@@ -0,0 +1,48 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreweick/iago/internal/signing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignFile(t *testing.T) {
+	dir := t.TempDir()
+	publicKey, secretKey, err := signing.GenerateKey()
+	require.NoError(t, err)
+	keyPath := filepath.Join(dir, "iago.key")
+	require.NoError(t, os.WriteFile(keyPath, secretKey, 0600))
+
+	b := &Builder{}
+	b.EnableSigning(keyPath)
+	require.NotNil(t, b.signing)
+
+	ignPath := filepath.Join(dir, "web.ign")
+	content := []byte(`{"ignition":{"version":"3.4.0"}}`)
+	require.NoError(t, os.WriteFile(ignPath, content, 0644))
+	require.NoError(t, b.signing.signFile(ignPath, "web", content))
+
+	signature, err := os.ReadFile(ignPath + signing.SignatureExt)
+	require.NoError(t, err)
+	pk, err := signing.ParsePublicKey(publicKey)
+	require.NoError(t, err)
+	comment, err := pk.Verify(content, signature)
+	require.NoError(t, err)
+	assert.Contains(t, comment, "file:web.ign")
+	assert.Contains(t, comment, "machine:web")
+
+	b.EnableSigning("")
+	assert.Nil(t, b.signing)
+}
+
+func TestSignFile_MissingKey(t *testing.T) {
+	b := &Builder{}
+	b.EnableSigning(filepath.Join(t.TempDir(), "missing.key"))
+
+	err := b.signing.signFile(filepath.Join(t.TempDir(), "web.ign"), "web", []byte("{}"))
+	assert.ErrorContains(t, err, "failed to read secret key")
+}
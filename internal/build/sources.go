@@ -0,0 +1,176 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/fetch"
+	"github.com/andreweick/iago/internal/lock"
+	"gopkg.in/yaml.v3"
+)
+
+// sourcePinning resolves remote storage.files sources at ignite time and pins their checksums in iago.lock
+type sourcePinning struct {
+	lockPath   string
+	updateLock bool
+	frozen     bool // Use pins without fetching; unpinned sources are errors
+	fetcher    *fetch.Fetcher
+	out        io.Writer
+}
+
+// EnableSourcePinning makes ignite resolve http(s) source: URLs in storage.files,
+// inject verification hashes and check them against the pins in lockPath.
+// With updateLock, changed remote content re-pins instead of failing.
+func (b *Builder) EnableSourcePinning(lockPath string, updateLock bool) {
+	// A nanosecond TTL always revalidates against the remote so changes are detected
+	fetcher := fetch.NewFetcher(nil, "", time.Nanosecond)
+	fetcher.AllowAllHosts = true
+	b.pinning = &sourcePinning{
+		lockPath:   lockPath,
+		updateLock: updateLock,
+		fetcher:    fetcher,
+	}
+	b.pinning.setOutput(b.output())
+}
+
+func (p *sourcePinning) setOutput(w io.Writer) {
+	p.out = w
+	p.fetcher.Out = w
+}
+
+// pinRemoteSources rewrites butane YAML so every remote file source carries a verification hash
+func (p *sourcePinning) pinRemoteSources(butaneYAML []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(butaneYAML, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse butane for source pinning: %w", err)
+	}
+
+	lockFile, err := lock.Load(p.lockPath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := findPath(&doc, "storage", "files")
+	if files == nil || files.Kind != yaml.SequenceNode {
+		return butaneYAML, nil
+	}
+
+	changed := false
+	lockChanged := false
+	for _, file := range files.Content {
+		contents := mappingValue(file, "contents")
+		if contents == nil {
+			continue
+		}
+		source := mappingValue(contents, "source")
+		if source == nil || !isRemoteSource(source.Value) {
+			continue
+		}
+
+		pinned, ok := lockFile.ResourceChecksum(source.Value)
+		checksum := pinned
+		if p.frozen {
+			// Frozen ignition uses the pin without fetching
+			if !ok {
+				return nil, fmt.Errorf("remote source %s is not pinned in %s; freeze the machine again", source.Value, p.lockPath)
+			}
+		} else {
+			content, err := p.fetcher.Fetch(source.Value, "")
+			if err != nil {
+				return nil, err
+			}
+			checksum = fetch.Checksum(content)
+		}
+
+		switch {
+		case p.frozen:
+		case !ok:
+			fmt.Fprintf(p.out, "Pinning %s (%s)\n", source.Value, checksum)
+			lockFile.SetResourceChecksum(source.Value, checksum)
+			lockChanged = true
+		case pinned != checksum && p.updateLock:
+			fmt.Fprintf(p.out, "Re-pinning %s: %s -> %s\n", source.Value, pinned, checksum)
+			lockFile.SetResourceChecksum(source.Value, checksum)
+			lockChanged = true
+		case pinned != checksum:
+			return nil, fmt.Errorf("remote content of %s changed: pinned %s in %s, got %s (re-run with --update-lock to accept)",
+				source.Value, pinned, p.lockPath, checksum)
+		}
+
+		hash := "sha256-" + strings.TrimPrefix(checksum, "sha256:")
+		verification := mappingValue(contents, "verification")
+		if verification == nil {
+			verification = &yaml.Node{Kind: yaml.MappingNode}
+			contents.Content = append(contents.Content, scalarNode("verification"), verification)
+		}
+		if existing := mappingValue(verification, "hash"); existing != nil {
+			if existing.Value != hash {
+				return nil, fmt.Errorf("template verification hash for %s (%s) does not match remote content (%s)", source.Value, existing.Value, hash)
+			}
+			continue
+		}
+		verification.Content = append(verification.Content, scalarNode("hash"), scalarNode(hash))
+		changed = true
+	}
+
+	if lockChanged {
+		if err := lockFile.Save(p.lockPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if !changed {
+		return butaneYAML, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to encode pinned butane: %w", err)
+	}
+	encoder.Close()
+
+	return buf.Bytes(), nil
+}
+
+func isRemoteSource(source string) bool {
+	return strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://")
+}
+
+// findPath walks nested mapping keys from a document node
+func findPath(node *yaml.Node, keys ...string) *yaml.Node {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		node = node.Content[0]
+	}
+	for _, key := range keys {
+		node = mappingValue(node, key)
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// mappingValue returns the value for key in a mapping node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+}
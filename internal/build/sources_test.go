@@ -0,0 +1,79 @@
+package build
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreweick/iago/internal/fetch"
+	"github.com/andreweick/iago/internal/lock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPinningBuilder(t *testing.T, lockPath string, updateLock bool) *Builder {
+	b := &Builder{}
+	b.EnableSourcePinning(lockPath, updateLock)
+	b.pinning.fetcher.CacheDir = t.TempDir()
+	return b
+}
+
+func TestPinRemoteSources(t *testing.T) {
+	body := "net.ipv4.ip_forward = 1\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	butaneYAML := fmt.Sprintf(`variant: fcos
+version: 1.5.0
+storage:
+  files:
+    - path: /etc/sysctl.d/90-vendor.conf
+      mode: 0644
+      contents:
+        source: %s/sysctl.conf
+    - path: /etc/hostname
+      contents:
+        inline: test
+`, server.URL)
+
+	lockPath := filepath.Join(t.TempDir(), "iago.lock")
+	b := newPinningBuilder(t, lockPath, false)
+
+	// First run pins the checksum and injects the verification hash
+	pinned, err := b.pinning.pinRemoteSources([]byte(butaneYAML))
+	require.NoError(t, err)
+	expectedHash := "sha256-" + fetch.Checksum([]byte(body))[len("sha256:"):]
+	assert.Contains(t, string(pinned), "hash: "+expectedHash)
+	assert.Contains(t, string(pinned), "mode: 0644", "octal modes should be preserved")
+
+	lockFile, err := lock.Load(lockPath)
+	require.NoError(t, err)
+	checksum, ok := lockFile.ResourceChecksum(server.URL + "/sysctl.conf")
+	assert.True(t, ok)
+	assert.Equal(t, fetch.Checksum([]byte(body)), checksum)
+
+	// Changed remote content fails against the pin
+	body = "net.ipv4.ip_forward = 0\n"
+	_, err = b.pinning.pinRemoteSources([]byte(butaneYAML))
+	assert.ErrorContains(t, err, "changed")
+	assert.ErrorContains(t, err, "--update-lock")
+
+	// --update-lock accepts the new content
+	b = newPinningBuilder(t, lockPath, true)
+	pinned, err = b.pinning.pinRemoteSources([]byte(butaneYAML))
+	require.NoError(t, err)
+	assert.Contains(t, string(pinned), fetch.Checksum([]byte(body))[len("sha256:"):])
+}
+
+func TestPinRemoteSources_NoRemoteSources(t *testing.T) {
+	butaneYAML := []byte("variant: fcos\nversion: 1.5.0\nstorage:\n  files:\n    - path: /etc/hostname\n      contents:\n        inline: test\n")
+	b := newPinningBuilder(t, filepath.Join(t.TempDir(), "iago.lock"), false)
+
+	result, err := b.pinning.pinRemoteSources(butaneYAML)
+	require.NoError(t, err)
+	assert.Equal(t, butaneYAML, result, "configs without remote sources should pass through unchanged")
+}
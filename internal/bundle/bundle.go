@@ -0,0 +1,551 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/lock"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/store"
+)
+
+// FormatVersion is the bundle layout this iago writes and the newest it reads
+const FormatVersion = 1
+
+// DefaultImagesDir is where import puts the images of a bundle, one OCI layout each
+const DefaultImagesDir = "output/images"
+
+// Entries of a bundle: the manifest comes first, then workspace files, the
+// store and image layouts under their prefixes
+const (
+	ManifestFile    = "manifest.json"
+	StoreFile       = "store.json"
+	workspacePrefix = "workspace/"
+	imagesPrefix    = "images/"
+)
+
+// DefaultPaths are the workspace files and directories a bundle holds:
+// configuration, templates, the lock file and provenance. Missing ones are
+// skipped. Generated output isn't among them, as rendered ignition and butane
+// hold the machines' secrets in plain text.
+var DefaultPaths = []string{"config", "machines", machine.ContainersDir, lock.DefaultPath, "cosign.pub"}
+
+// Manifest describes a bundle's contents
+type Manifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Host    string    `json:"host,omitempty"`
+	Paths   []string  `json:"paths"`   // Workspace files and directories included
+	Files   int       `json:"files"`   // Workspace files, counting symlinks
+	Store   bool      `json:"store"`   // The store's records are in store.json
+	Secrets bool      `json:"secrets"` // Secret paths and the store's frozen secrets are included
+	Images  []Image   `json:"images,omitempty"`
+}
+
+// Image is a container image saved in a bundle as an OCI layout
+type Image struct {
+	Reference string `json:"reference"`
+	Digest    string `json:"digest"`
+	Dir       string `json:"dir"` // Layout directory, relative to the images directory
+}
+
+// Options selects what Export includes
+type Options struct {
+	Paths       []string // Workspace files and directories (DefaultPaths when nil)
+	SecretPaths []string // Included only with IncludeSecrets, e.g. the CA key
+	// IncludeSecrets adds SecretPaths and the store's frozen secrets; without
+	// it the bundle holds nothing that isn't safe to keep next to the repository
+	IncludeSecrets bool
+	Exclude        []string // Files left out, e.g. the bundle itself when written inside the workspace
+	StorePath      string   // Store whose records are included; skipped when it doesn't exist
+	ImagesDir      string   // Directory holding the Images layouts
+	Images         []Image  // Images saved under ImagesDir, see ImageDir
+}
+
+// ImageDir is the layout directory of an image reference, e.g.
+// registry.lab_5000_homelab_web_latest for registry.lab:5000/homelab/web:latest
+func ImageDir(reference string) string {
+	return strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(reference)
+}
+
+// Export writes a gzip-compressed tarball of the workspace to w and returns
+// its manifest. Paths are read relative to the working directory.
+func Export(w io.Writer, opts Options) (*Manifest, error) {
+	paths := opts.Paths
+	if paths == nil {
+		paths = DefaultPaths
+	}
+	if opts.IncludeSecrets {
+		paths = append(append([]string(nil), paths...), opts.SecretPaths...)
+	}
+
+	manifest := &Manifest{Version: FormatVersion, Created: time.Now().UTC(), Secrets: opts.IncludeSecrets, Images: opts.Images}
+	manifest.Host, _ = os.Hostname()
+	for _, p := range paths {
+		if _, err := os.Lstat(p); err == nil {
+			manifest.Paths = append(manifest.Paths, filepath.ToSlash(filepath.Clean(p)))
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", p, err)
+		}
+	}
+
+	var storeDump []byte
+	if opts.StorePath != "" {
+		if _, err := os.Stat(opts.StorePath); err == nil {
+			if storeDump, err = dumpStore(opts.StorePath, opts.IncludeSecrets); err != nil {
+				return nil, err
+			}
+			manifest.Store = true
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	// The manifest is written first so import can check it before extracting
+	// anything; the file count is known only after walking, so count first
+	exclude := make(map[string]bool)
+	for _, p := range opts.Exclude {
+		exclude[filepath.Clean(p)] = true
+	}
+	for _, p := range manifest.Paths {
+		err := walk(p, exclude, func(string, fs.FileInfo) error { manifest.Files++; return nil })
+		if err != nil {
+			return nil, err
+		}
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode bundle manifest: %w", err)
+	}
+	if err := writeEntry(tw, ManifestFile, content); err != nil {
+		return nil, err
+	}
+
+	for _, p := range manifest.Paths {
+		err := walk(p, exclude, func(file string, info fs.FileInfo) error {
+			return addFile(tw, workspacePrefix+filepath.ToSlash(file), file, info)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if storeDump != nil {
+		if err := writeEntry(tw, StoreFile, storeDump); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, image := range opts.Images {
+		root := filepath.Join(opts.ImagesDir, image.Dir)
+		err := walk(root, nil, func(file string, info fs.FileInfo) error {
+			rel, err := filepath.Rel(opts.ImagesDir, file)
+			if err != nil {
+				return err
+			}
+			return addFile(tw, imagesPrefix+filepath.ToSlash(rel), file, info)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return manifest, nil
+}
+
+// dumpStore exports the store's records, leaving out frozen secrets unless
+// includeSecrets is set
+func dumpStore(storePath string, includeSecrets bool) ([]byte, error) {
+	db, err := store.Open(storePath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var buf bytes.Buffer
+	if err := store.Export(db, &buf); err != nil {
+		return nil, err
+	}
+	if includeSecrets {
+		return buf.Bytes(), nil
+	}
+	var dump store.Dump
+	if err := json.Unmarshal(buf.Bytes(), &dump); err != nil {
+		return nil, fmt.Errorf("failed to read store export: %w", err)
+	}
+	delete(dump.Buckets, store.BucketFrozen)
+	content, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode store export: %w", err)
+	}
+	return content, nil
+}
+
+// walk calls fn for root and every regular file and symlink below it, in
+// lexical order; .git directories and excluded files are skipped
+func walk(root string, exclude map[string]bool, fn func(file string, info fs.FileInfo) error) error {
+	return filepath.Walk(root, func(file string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" || file == DefaultImagesDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if exclude[file] || !info.Mode().IsRegular() && info.Mode()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		return fn(file, info)
+	})
+}
+
+func addFile(tw *tar.Writer, name, file string, info fs.FileInfo) error {
+	link := ""
+	if info.Mode()&fs.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(file); err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", file, err)
+	}
+	header.Name = name
+	header.Uname, header.Gname = "", ""
+	header.Uid, header.Gid = 0, 0
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to add %s: %w", file, err)
+	}
+	if link != "" {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("failed to add %s: %w", file, err)
+	}
+	return nil
+}
+
+func writeEntry(tw *tar.Writer, name string, content []byte) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	return nil
+}
+
+// ImportOptions says where Import restores a bundle
+type ImportOptions struct {
+	Dir       string // Workspace root the files are restored to
+	StorePath string // Store the records are loaded into, relative to Dir
+	ImagesDir string // Where image layouts go, relative to Dir (DefaultImagesDir when empty)
+	Force     bool   // Replace workspace files that already exist
+}
+
+// ImportResult is what Import restored
+type ImportResult struct {
+	Manifest *Manifest
+	Files    int // Workspace files written
+	Records  int // Store records loaded
+}
+
+// ErrConflict means restoring would replace existing workspace files
+var ErrConflict = errors.New("the workspace already has files the bundle would replace")
+
+// Import restores the bundle read from r: workspace files under Dir, the
+// store's records and image layouts. It refuses to replace existing files
+// unless Force is set, checking all of them before writing anything.
+func Import(r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	imagesDir := opts.ImagesDir
+	if imagesDir == "" {
+		imagesDir = DefaultImagesDir
+	}
+
+	// Entries are checked, and conflicts found, in a first pass over the bundle
+	staged, cleanup, err := stage(r)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	result := &ImportResult{}
+	var conflicts []string
+	links := make(map[string]bool) // Symlink entries, by their target path
+	err = readBundle(staged, func(header *tar.Header, _ io.Reader) error {
+		if header.Name == ManifestFile {
+			return nil
+		}
+		if result.Manifest == nil {
+			return fmt.Errorf("not an iago bundle: %s is missing or isn't first", ManifestFile)
+		}
+		dir := opts.Dir
+		file, ok := strings.CutPrefix(header.Name, workspacePrefix)
+		if !ok {
+			if file, ok = strings.CutPrefix(header.Name, imagesPrefix); !ok {
+				return nil
+			}
+			dir = filepath.Join(opts.Dir, imagesDir)
+		}
+		target, err := localPath(dir, file)
+		if err != nil {
+			return err
+		}
+		if err := checkLink(header, file); err != nil {
+			return err
+		}
+		if err := checkParents(dir, file); err != nil {
+			return err
+		}
+		for parent := path.Dir(file); parent != "."; parent = path.Dir(parent) {
+			if links[filepath.Join(dir, parent)] {
+				return fmt.Errorf("bundle entry %s is below the symlink %s", file, parent)
+			}
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			links[target] = true
+		}
+		if dir == opts.Dir {
+			if _, err := os.Lstat(target); err == nil {
+				conflicts = append(conflicts, file)
+			}
+		}
+		return nil
+	}, func(m *Manifest) { result.Manifest = m })
+	if err != nil {
+		return nil, err
+	}
+	if len(conflicts) > 0 && !opts.Force {
+		sort.Strings(conflicts)
+		if len(conflicts) > 10 {
+			conflicts = append(conflicts[:10], fmt.Sprintf("and %d more", len(conflicts)-10))
+		}
+		return nil, fmt.Errorf("%w: %s", ErrConflict, strings.Join(conflicts, ", "))
+	}
+
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	err = readBundle(staged, func(header *tar.Header, content io.Reader) error {
+		switch {
+		case strings.HasPrefix(header.Name, workspacePrefix):
+			if err := extract(header, content, opts.Dir, strings.TrimPrefix(header.Name, workspacePrefix)); err != nil {
+				return err
+			}
+			result.Files++
+		case strings.HasPrefix(header.Name, imagesPrefix):
+			return extract(header, content, filepath.Join(opts.Dir, imagesDir), strings.TrimPrefix(header.Name, imagesPrefix))
+		case header.Name == StoreFile:
+			if opts.StorePath == "" {
+				return nil
+			}
+			db, err := store.Open(filepath.Join(opts.Dir, opts.StorePath))
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+			if result.Records, err = store.Import(db, content); err != nil {
+				return fmt.Errorf("failed to restore the store: %w", err)
+			}
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ReadManifest returns the manifest of the bundle read from r
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	var manifest *Manifest
+	errDone := errors.New("done")
+	err := readBundle(r, func(*tar.Header, io.Reader) error { return errDone }, func(m *Manifest) { manifest = m })
+	if err != nil && !errors.Is(err, errDone) {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("not an iago bundle: %s is missing", ManifestFile)
+	}
+	return manifest, nil
+}
+
+// readBundle calls fn for each entry of the bundle after the manifest, which
+// is checked and passed to onManifest first
+func readBundle(r io.Reader, fn func(header *tar.Header, content io.Reader) error, onManifest func(*Manifest)) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("not an iago bundle: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	first := true
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read bundle: %w", err)
+		}
+		if first && header.Name == ManifestFile {
+			var manifest Manifest
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return fmt.Errorf("failed to read bundle manifest: %w", err)
+			}
+			if manifest.Version > FormatVersion {
+				return fmt.Errorf("bundle has format version %d but this iago only reads up to %d; upgrade iago", manifest.Version, FormatVersion)
+			}
+			if onManifest != nil {
+				onManifest(&manifest)
+			}
+		}
+		first = false
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// stage returns r as a seekable reader, copying it to a temporary file when
+// it can't seek, e.g. a bundle piped to stdin
+func stage(r io.Reader) (io.ReadSeeker, func(), error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		if _, err := rs.Seek(0, io.SeekCurrent); err == nil {
+			return rs, func() {}, nil
+		}
+	}
+	tmp, err := os.CreateTemp("", "iago-bundle-*.tar.gz")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to stage bundle: %w", err)
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to stage bundle: %w", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to stage bundle: %w", err)
+	}
+	return tmp, cleanup, nil
+}
+
+// localPath joins a bundle entry name to dir, refusing names that escape it
+func localPath(dir, name string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) || path.Clean(name) != name {
+		return "", fmt.Errorf("bundle entry %s is outside the workspace", name)
+	}
+	return filepath.Join(dir, filepath.FromSlash(name)), nil
+}
+
+// checkLink refuses symlink entries that point outside the directory the
+// entry, name, is restored to
+func checkLink(header *tar.Header, name string) error {
+	if header.Typeflag != tar.TypeSymlink {
+		return nil
+	}
+	if filepath.IsAbs(header.Linkname) || !filepath.IsLocal(filepath.Join(filepath.Dir(filepath.FromSlash(name)), header.Linkname)) {
+		return fmt.Errorf("bundle entry %s links outside the workspace to %s", name, header.Linkname)
+	}
+	return nil
+}
+
+// checkParents refuses to restore name under dir through a directory that is
+// a symlink, which could point anywhere once followed
+func checkParents(dir, name string) error {
+	current := dir
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if part == "." {
+			break
+		}
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", current, err)
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("bundle entry %s is below the symlink %s", name, current)
+		}
+	}
+	return nil
+}
+
+// extract restores a regular file or symlink entry as name under dir
+func extract(header *tar.Header, content io.Reader, dir, name string) error {
+	target, err := localPath(dir, name)
+	if err != nil {
+		return err
+	}
+	if err := checkParents(dir, name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(target), err)
+	}
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		if err := checkLink(header, name); err != nil {
+			return err
+		}
+		os.Remove(target)
+		if err := os.Symlink(header.Linkname, target); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", target, err)
+		}
+	case tar.TypeReg:
+		// Replace a symlink rather than write through it
+		if info, err := os.Lstat(target); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			if err := os.Remove(target); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", target, err)
+			}
+		}
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, header.FileInfo().Mode().Perm())
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", target, err)
+		}
+		defer f.Close()
+		if _, err := io.Copy(f, content); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", target, err)
+		}
+		// OpenFile doesn't change the mode of an existing file, e.g. with Force
+		if err := f.Chmod(header.FileInfo().Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", target, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreweick/iago/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeWorkspace creates a small iago repository in dir, with a store
+// holding a workload record and a frozen secret
+func writeWorkspace(t *testing.T, dir string) {
+	t.Helper()
+	for name, content := range map[string]string{
+		"config/defaults.toml":               "[container_registry]\nurl = \"registry.lab:5000\"\n",
+		"config/scripts/motd.sh":             "#!/bin/sh\necho hi\n",
+		"machines/web/machine.toml":          "[[machines]]\nname = \"web\"\n",
+		"machines/web/.git/HEAD":             "ref: refs/heads/main\n",
+		"iago.lock":                          "version = 1\n",
+		"output/ignition/web.ign":            "{}\n",
+		"output/images/old/oci-layout":       "{}\n",
+		".iago/ca/ca.key":                    "secret key\n",
+		".iago/include/other/defaults.toml":  "# fetched, not exported\n",
+		"containers/web/Containerfile":       "FROM scratch\n",
+		"containers/web/rootfs/etc/web.conf": "listen 80\n",
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	require.NoError(t, os.Chmod(filepath.Join(dir, "config/scripts/motd.sh"), 0755))
+	require.NoError(t, os.Symlink("Containerfile", filepath.Join(dir, "containers/web/Dockerfile")))
+
+	db, err := store.Open(filepath.Join(dir, store.DefaultPath))
+	require.NoError(t, err)
+	require.NoError(t, db.Put(store.BucketWorkloads, "web", []byte(`{"image_ref":"registry.lab:5000/web:latest"}`)))
+	require.NoError(t, db.Put(store.BucketFrozen, "web/token", []byte(`"s3cret"`)))
+	require.NoError(t, db.Close())
+}
+
+func export(t *testing.T, opts Options) (*Manifest, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	manifest, err := Export(&buf, opts)
+	require.NoError(t, err)
+	return manifest, buf.Bytes()
+}
+
+func TestExportImport(t *testing.T) {
+	source := t.TempDir()
+	writeWorkspace(t, source)
+	t.Chdir(source)
+
+	manifest, content := export(t, Options{
+		SecretPaths: []string{".iago/ca/ca.key"},
+		StorePath:   store.DefaultPath,
+	})
+	assert.Equal(t, FormatVersion, manifest.Version)
+	assert.Equal(t, []string{"config", "machines", "containers", "iago.lock"}, manifest.Paths, "output holds rendered secrets")
+	assert.Equal(t, 7, manifest.Files)
+	assert.True(t, manifest.Store)
+	assert.False(t, manifest.Secrets)
+
+	read, err := ReadManifest(bytes.NewReader(content))
+	require.NoError(t, err)
+	assert.Equal(t, manifest.Files, read.Files)
+
+	target := t.TempDir()
+	result, err := Import(bytes.NewReader(content), ImportOptions{Dir: target, StorePath: store.DefaultPath})
+	require.NoError(t, err)
+	assert.Equal(t, 7, result.Files)
+	assert.Equal(t, 1, result.Records)
+
+	config, err := os.ReadFile(filepath.Join(target, "config/defaults.toml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(config), "registry.lab:5000")
+	info, err := os.Stat(filepath.Join(target, "config/scripts/motd.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	link, err := os.Readlink(filepath.Join(target, "containers/web/Dockerfile"))
+	require.NoError(t, err)
+	assert.Equal(t, "Containerfile", link)
+
+	assert.NoFileExists(t, filepath.Join(target, "machines/web/.git/HEAD"))
+	assert.NoFileExists(t, filepath.Join(target, "output/ignition/web.ign"))
+	assert.NoFileExists(t, filepath.Join(target, ".iago/ca/ca.key"))
+	assert.NoDirExists(t, filepath.Join(target, ".iago/include"))
+
+	db, err := store.Open(filepath.Join(target, store.DefaultPath))
+	require.NoError(t, err)
+	defer db.Close()
+	value, err := db.Get(store.BucketWorkloads, "web")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"image_ref":"registry.lab:5000/web:latest"}`, string(value))
+	value, err = db.Get(store.BucketFrozen, "web/token")
+	require.NoError(t, err)
+	assert.Nil(t, value, "frozen secrets should be left out without IncludeSecrets")
+}
+
+func TestExportImport_Secrets(t *testing.T) {
+	source := t.TempDir()
+	writeWorkspace(t, source)
+	t.Chdir(source)
+
+	manifest, content := export(t, Options{
+		Paths:          []string{"config"},
+		SecretPaths:    []string{".iago/ca/ca.key", ".iago/ca/certs"},
+		IncludeSecrets: true,
+		StorePath:      store.DefaultPath,
+	})
+	assert.True(t, manifest.Secrets)
+	assert.Equal(t, []string{"config", ".iago/ca/ca.key"}, manifest.Paths)
+
+	target := t.TempDir()
+	_, err := Import(bytes.NewReader(content), ImportOptions{Dir: target, StorePath: store.DefaultPath})
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(target, ".iago/ca/ca.key"))
+
+	db, err := store.Open(filepath.Join(target, store.DefaultPath))
+	require.NoError(t, err)
+	defer db.Close()
+	value, err := db.Get(store.BucketFrozen, "web/token")
+	require.NoError(t, err)
+	assert.Equal(t, `"s3cret"`, string(value))
+}
+
+func TestImport_Conflicts(t *testing.T) {
+	source := t.TempDir()
+	writeWorkspace(t, source)
+	t.Chdir(source)
+	// The bundle is written in the workspace, and leaves itself out
+	_, content := export(t, Options{Paths: []string{"config", "iago.lock"}, Exclude: []string{"config/backup.tar.gz"}})
+	require.NoError(t, os.WriteFile("config/backup.tar.gz", content, 0600))
+	manifest, content := export(t, Options{Paths: []string{"config", "iago.lock"}, Exclude: []string{"config/backup.tar.gz"}})
+	assert.Equal(t, 3, manifest.Files)
+
+	target := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(target, "iago.lock"), []byte("mine\n"), 0644))
+
+	_, err := Import(bytes.NewReader(content), ImportOptions{Dir: target})
+	require.ErrorIs(t, err, ErrConflict)
+	assert.ErrorContains(t, err, "iago.lock")
+	assert.NoFileExists(t, filepath.Join(target, "config/defaults.toml"), "nothing should be restored when a file conflicts")
+
+	_, err = Import(bytes.NewReader(content), ImportOptions{Dir: target, Force: true})
+	require.NoError(t, err)
+	lock, err := os.ReadFile(filepath.Join(target, "iago.lock"))
+	require.NoError(t, err)
+	assert.Equal(t, "version = 1\n", string(lock))
+}
+
+// writeBundle builds a bundle by hand, for entries Export never writes
+func writeBundle(t *testing.T, manifest Manifest, headers ...*tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	content, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	require.NoError(t, writeEntry(tw, ManifestFile, content))
+	for _, header := range headers {
+		require.NoError(t, tw.WriteHeader(header))
+		if header.Size > 0 {
+			_, err := tw.Write(bytes.Repeat([]byte("x"), int(header.Size)))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestImport_Rejects(t *testing.T) {
+	for name, tc := range map[string]struct {
+		content []byte
+		err     string
+	}{
+		"newer format": {
+			content: writeBundle(t, Manifest{Version: FormatVersion + 1}),
+			err:     "upgrade iago",
+		},
+		"path traversal": {
+			content: writeBundle(t, Manifest{Version: FormatVersion}, &tar.Header{Name: "workspace/../escape", Mode: 0644, Size: 1, Typeflag: tar.TypeReg}),
+			err:     "outside the workspace",
+		},
+		"symlink out of the workspace": {
+			content: writeBundle(t, Manifest{Version: FormatVersion}, &tar.Header{Name: "workspace/config/passwd", Linkname: "../../etc/passwd", Typeflag: tar.TypeSymlink}),
+			err:     "links outside the workspace",
+		},
+		"entry below a symlink in the bundle": {
+			content: writeBundle(t, Manifest{Version: FormatVersion},
+				&tar.Header{Name: "workspace/config/up", Linkname: ".", Typeflag: tar.TypeSymlink},
+				&tar.Header{Name: "workspace/config/escape", Linkname: "up/..", Typeflag: tar.TypeSymlink},
+				&tar.Header{Name: "workspace/config/escape/passwd", Mode: 0644, Size: 1, Typeflag: tar.TypeReg}),
+			err: "is below the symlink config/escape",
+		},
+		"absolute symlink": {
+			content: writeBundle(t, Manifest{Version: FormatVersion}, &tar.Header{Name: "workspace/config/passwd", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink}),
+			err:     "links outside the workspace",
+		},
+		"no manifest": {
+			content: func() []byte {
+				var buf bytes.Buffer
+				gz := gzip.NewWriter(&buf)
+				tw := tar.NewWriter(gz)
+				require.NoError(t, writeEntry(tw, "workspace/iago.lock", []byte("x")))
+				require.NoError(t, tw.Close())
+				require.NoError(t, gz.Close())
+				return buf.Bytes()
+			}(),
+			err: "not an iago bundle",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			target := t.TempDir()
+			_, err := Import(bytes.NewReader(tc.content), ImportOptions{Dir: target})
+			assert.ErrorContains(t, err, tc.err)
+			entries, _ := os.ReadDir(target)
+			assert.Empty(t, entries)
+		})
+	}
+}
+
+func TestImport_SymlinkedDirectory(t *testing.T) {
+	outside := t.TempDir()
+	target := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(target, "config")))
+
+	content := writeBundle(t, Manifest{Version: FormatVersion}, &tar.Header{Name: "workspace/config/defaults.toml", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+	_, err := Import(bytes.NewReader(content), ImportOptions{Dir: target, Force: true})
+	assert.ErrorContains(t, err, "is below the symlink")
+	entries, _ := os.ReadDir(outside)
+	assert.Empty(t, entries, "nothing is written through the symlink")
+}
+
+func TestImageDir(t *testing.T) {
+	assert.Equal(t, "registry.lab_5000_homelab_web_latest", ImageDir("registry.lab:5000/homelab/web:latest"))
+}
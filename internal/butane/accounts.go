@@ -0,0 +1,90 @@
+package butane
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/andreweick/iago/internal/machine"
+	"gopkg.in/yaml.v3"
+)
+
+// accountExpiryUnit applies expiry dates with chage on every boot, since
+// Ignition can't set them; chage is idempotent
+const accountExpiryUnit = "iago-account-expiry.service"
+
+// accountsOverlay renders the [user] and [admin] hardening options as a butane
+// overlay: locked passwords and shells in passwd, a sudoers drop-in per
+// account, and a unit that applies expiry dates. It returns "" when no
+// account sets any option.
+func accountsOverlay(accounts ...machine.Account) (string, error) {
+	type user struct {
+		Name         string `yaml:"name"`
+		PasswordHash string `yaml:"password_hash,omitempty"`
+		Shell        string `yaml:"shell,omitempty"`
+	}
+	type contents struct {
+		Inline string `yaml:"inline"`
+	}
+	type file struct {
+		Path     string   `yaml:"path"`
+		Mode     int      `yaml:"mode"`
+		Contents contents `yaml:"contents"`
+	}
+	type unit struct {
+		Name     string `yaml:"name"`
+		Enabled  bool   `yaml:"enabled"`
+		Contents string `yaml:"contents"`
+	}
+
+	var users []user
+	var files []file
+	var expiry []string
+	for _, account := range accounts {
+		if !account.Customized() {
+			continue
+		}
+		u := user{Name: account.Username, Shell: account.Shell}
+		if account.SSHOnly {
+			u.PasswordHash = "!"
+		}
+		if u.PasswordHash != "" || u.Shell != "" {
+			users = append(users, u)
+		}
+		if rule := account.SudoRule(); rule != "" {
+			files = append(files, file{
+				Path:     "/etc/sudoers.d/iago-" + account.Username,
+				Mode:     0440,
+				Contents: contents{Inline: rule + "\n"},
+			})
+		}
+		if account.Expires != "" {
+			expiry = append(expiry, fmt.Sprintf("ExecStart=/usr/bin/chage --expiredate %s %s", account.Expires, account.Username))
+		}
+	}
+
+	overlay := map[string]any{}
+	if len(users) > 0 {
+		overlay["passwd"] = map[string]any{"users": users}
+	}
+	if len(files) > 0 {
+		overlay["storage"] = map[string]any{"files": files}
+	}
+	if len(expiry) > 0 {
+		overlay["systemd"] = map[string]any{"units": []unit{{
+			Name:    accountExpiryUnit,
+			Enabled: true,
+			Contents: "[Unit]\nDescription=Apply iago account expiry dates\n\n" +
+				"[Service]\nType=oneshot\n" + strings.Join(expiry, "\n") + "\n\n" +
+				"[Install]\nWantedBy=multi-user.target\n",
+		}}}
+	}
+	if len(overlay) == 0 {
+		return "", nil
+	}
+
+	content, err := yaml.Marshal(overlay)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode account overlay: %w", err)
+	}
+	return string(content), nil
+}
@@ -0,0 +1,47 @@
+package butane
+
+// podmanAutoUpdateOverlay hands container updates to podman-auto-update.timer,
+// scheduled in the machine's maintenance window. The marker file makes bootc-run.sh label
+// the container with io.containers.autoupdate and tells bootc-update.sh to skip it.
+// Rootless containers get AutoUpdate=registry in their quadlet and the user's timer.
+const podmanAutoUpdateOverlay = `storage:
+  files:
+    - path: /etc/iago/containers/{{ .Machine.Name }}.autoupdate
+      mode: 0644
+      contents:
+        inline: |
+          AUTO_UPDATE=registry
+{{ if .Machine.Rootless }}    - path: /var/home/{{ .Machine.RootlessUserName }}/.config/systemd/user/podman-auto-update.timer.d/50-iago-schedule.conf
+      mode: 0644
+      user:
+        name: "{{ .Machine.RootlessUserName }}"
+      group:
+        name: "{{ .Machine.RootlessUserName }}"
+      contents:
+        inline: |
+          [Timer]
+{{ indent 10 .Schedule.TimerSettings }}
+  directories:
+{{ range list ".config/systemd" ".config/systemd/user" ".config/systemd/user/timers.target.wants" ".config/systemd/user/podman-auto-update.timer.d" }}    - path: /var/home/{{ $.Machine.RootlessUserName }}/{{ . }}
+      mode: 0755
+      user:
+        name: "{{ $.Machine.RootlessUserName }}"
+      group:
+        name: "{{ $.Machine.RootlessUserName }}"
+{{ end }}  links:
+    - path: /var/home/{{ .Machine.RootlessUserName }}/.config/systemd/user/timers.target.wants/podman-auto-update.timer
+      target: /usr/lib/systemd/user/podman-auto-update.timer
+      user:
+        name: "{{ .Machine.RootlessUserName }}"
+      group:
+        name: "{{ .Machine.RootlessUserName }}"
+{{ else }}systemd:
+  units:
+    - name: podman-auto-update.timer
+      enabled: true
+      dropins:
+        - name: 50-iago-schedule.conf
+          contents: |
+            [Timer]
+{{ indent 12 .Schedule.TimerSettings }}
+{{ end }}`
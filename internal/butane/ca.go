@@ -0,0 +1,51 @@
+package butane
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/andreweick/iago/internal/machine"
+	"gopkg.in/yaml.v3"
+)
+
+// CATrustPath is where machines get the iago CA certificate. Fedora CoreOS
+// adds anchors there to the system trust store at boot.
+const CATrustPath = "/etc/pki/ca-trust/source/anchors/iago-ca.crt"
+
+// caTrustOverlay makes a machine trust the iago CA: the system trust store,
+// for podman pulls from the local registry and curl, and ignition itself, for
+// remote files served by homelab services. It returns "" when there is no CA.
+func caTrustOverlay(cfg machine.CAConfig) (string, error) {
+	path := cfg.CertificatePath()
+	if path == "" {
+		return "", nil
+	}
+	certificate, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read [ca] certificate: %w", err)
+	}
+
+	type source struct {
+		Inline string `yaml:"inline"`
+	}
+	overlay, err := yaml.Marshal(map[string]any{
+		"ignition": map[string]any{
+			"security": map[string]any{
+				"tls": map[string]any{
+					"certificate_authorities": []source{{Inline: string(certificate)}},
+				},
+			},
+		},
+		"storage": map[string]any{
+			"files": []map[string]any{{
+				"path":     CATrustPath,
+				"mode":     0644,
+				"contents": source{Inline: string(certificate)},
+			}},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode CA trust overlay: %w", err)
+	}
+	return string(overlay), nil
+}
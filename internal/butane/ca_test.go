@@ -0,0 +1,54 @@
+package butane
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestCATrustOverlay(t *testing.T) {
+	overlay, err := caTrustOverlay(machine.CAConfig{Certificate: filepath.Join(t.TempDir(), "missing.crt")})
+	assert.ErrorContains(t, err, "failed to read [ca] certificate")
+	assert.Empty(t, overlay)
+
+	certificate := "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"
+	path := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(path, []byte(certificate), 0644))
+
+	overlay, err = caTrustOverlay(machine.CAConfig{Certificate: path})
+	require.NoError(t, err)
+
+	var config struct {
+		Ignition struct {
+			Security struct {
+				TLS struct {
+					CertificateAuthorities []struct {
+						Inline string `yaml:"inline"`
+					} `yaml:"certificate_authorities"`
+				} `yaml:"tls"`
+			} `yaml:"security"`
+		} `yaml:"ignition"`
+		Storage struct {
+			Files []struct {
+				Path     string `yaml:"path"`
+				Mode     int    `yaml:"mode"`
+				Contents struct {
+					Inline string `yaml:"inline"`
+				} `yaml:"contents"`
+			} `yaml:"files"`
+		} `yaml:"storage"`
+	}
+	require.NoError(t, yaml.Unmarshal([]byte(overlay), &config))
+	require.Len(t, config.Ignition.Security.TLS.CertificateAuthorities, 1)
+	assert.Equal(t, certificate, config.Ignition.Security.TLS.CertificateAuthorities[0].Inline)
+	require.Len(t, config.Storage.Files, 1)
+	assert.Equal(t, CATrustPath, config.Storage.Files[0].Path)
+	assert.Equal(t, 0644, config.Storage.Files[0].Mode)
+	assert.True(t, strings.HasPrefix(config.Storage.Files[0].Contents.Inline, "-----BEGIN CERTIFICATE-----"))
+}
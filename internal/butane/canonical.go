@@ -0,0 +1,118 @@
+package butane
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sectionOrder is the order top-level butane sections are written in, so a
+// section added by an overlay lands in the same place every time. Unknown
+// sections follow in their original order.
+var sectionOrder = []string{
+	"variant", "version", "metadata", "ignition", "kernel_arguments", "boot_device",
+	"storage", "systemd", "passwd", "grub",
+}
+
+// octalString matches file modes written as quoted strings, e.g. mode: "0755"
+var octalString = regexp.MustCompile(`^0[0-7]{3,4}$`)
+
+// canonicalize puts a parsed butane document in canonical form: top-level
+// sections in sectionOrder and every file mode written as a four-digit octal
+// number, whether the template wrote 0755, "0755" or 493
+func canonicalize(doc *yaml.Node) {
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return
+	}
+	sortSections(root)
+	normalizeModes(root)
+}
+
+// sortSections stably reorders a mapping's keys by sectionOrder
+func sortSections(mapping *yaml.Node) {
+	type pair struct{ key, value *yaml.Node }
+	pairs := make([]pair, 0, len(mapping.Content)/2)
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		pairs = append(pairs, pair{mapping.Content[i], mapping.Content[i+1]})
+	}
+
+	rank := func(key string) int {
+		if i := slices.Index(sectionOrder, key); i >= 0 {
+			return i
+		}
+		return len(sectionOrder)
+	}
+	slices.SortStableFunc(pairs, func(a, b pair) int {
+		return rank(a.key.Value) - rank(b.key.Value)
+	})
+
+	mapping.Content = mapping.Content[:0]
+	for _, p := range pairs {
+		mapping.Content = append(mapping.Content, p.key, p.value)
+	}
+}
+
+// normalizeModes rewrites every mode value in the tree as an octal integer
+func normalizeModes(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "mode" && value.Kind == yaml.ScalarNode {
+				normalizeMode(value)
+				continue
+			}
+			normalizeModes(value)
+		}
+	case yaml.SequenceNode, yaml.DocumentNode:
+		for _, child := range node.Content {
+			normalizeModes(child)
+		}
+	}
+}
+
+func normalizeMode(value *yaml.Node) {
+	var mode int64
+	switch {
+	case value.Tag == "!!int":
+		parsed, err := strconv.ParseInt(value.Value, 0, 64)
+		if err != nil {
+			return
+		}
+		mode = parsed
+	case value.Tag == "!!str" && octalString.MatchString(value.Value):
+		parsed, err := strconv.ParseInt(value.Value, 8, 64)
+		if err != nil {
+			return
+		}
+		mode = parsed
+	default:
+		return
+	}
+	value.Tag = "!!int"
+	value.Style = 0
+	value.Value = fmt.Sprintf("%04o", mode)
+}
+
+// encodeYAML writes a butane document with a fixed emitter configuration:
+// two-space indentation and no line wrapping, so long strings never reflow
+func encodeYAML(doc *yaml.Node) (string, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return "", fmt.Errorf("failed to marshal merged config: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return "", fmt.Errorf("failed to marshal merged config: %w", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,97 @@
+package butane
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func canonicalYAML(t *testing.T, content string) string {
+	t.Helper()
+	var doc yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte(content), &doc))
+	canonicalize(&doc)
+	out, err := encodeYAML(&doc)
+	require.NoError(t, err)
+	return out
+}
+
+func TestCanonicalize_Modes(t *testing.T) {
+	out := canonicalYAML(t, `variant: fcos
+version: 1.5.0
+storage:
+  files:
+    - path: /a
+      mode: 0755
+    - path: /b
+      mode: "0644"
+    - path: /c
+      mode: 288
+    - path: /d
+      mode: 0o600
+    - path: /e
+      mode: "u+x"
+`)
+	assert.Contains(t, out, "path: /a\n      mode: 0755\n")
+	assert.Contains(t, out, "path: /b\n      mode: 0644\n")
+	assert.Contains(t, out, "path: /c\n      mode: 0440\n")
+	assert.Contains(t, out, "path: /d\n      mode: 0600\n")
+	assert.Contains(t, out, `mode: "u+x"`, "values that aren't modes are left alone")
+	assert.Equal(t, out, canonicalYAML(t, out), "canonical output is a fixed point")
+}
+
+func TestCanonicalize_SectionOrder(t *testing.T) {
+	out := canonicalYAML(t, `passwd:
+  users:
+    - name: core
+systemd:
+  units:
+    - name: a.service
+custom: true
+version: 1.5.0
+storage:
+  files:
+    - path: /etc/hostname
+      contents:
+        inline: app
+        # kept comment
+variant: fcos
+`)
+	assert.Equal(t, `variant: fcos
+version: 1.5.0
+storage:
+  files:
+    - path: /etc/hostname
+      contents:
+        inline: app
+        # kept comment
+systemd:
+  units:
+    - name: a.service
+passwd:
+  users:
+    - name: core
+custom: true
+`, out)
+}
+
+func TestMergeYAMLNodes_StableKeyOrder(t *testing.T) {
+	renderer := &Renderer{}
+	var first string
+	for i := 0; i < 20; i++ {
+		var base, override yaml.Node
+		require.NoError(t, yaml.Unmarshal([]byte("variant: fcos\n"), &base))
+		require.NoError(t, yaml.Unmarshal([]byte("zeta: 1\nalpha: 2\nmid: 3\nomega: 4\nbeta: 5\n"), &override))
+		require.NoError(t, renderer.mergeYAMLNodes(&base, &override))
+
+		out, err := encodeYAML(&base)
+		require.NoError(t, err)
+		if i == 0 {
+			first = out
+			assert.Equal(t, "variant: fcos\nzeta: 1\nalpha: 2\nmid: 3\nomega: 4\nbeta: 5\n", out, "new keys keep the override's order")
+		}
+		assert.Equal(t, first, out)
+	}
+}
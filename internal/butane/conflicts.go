@@ -0,0 +1,257 @@
+package butane
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ResolutionsFile records a machine's merge conflict decisions, in its
+// machines/<name>/ directory
+const ResolutionsFile = "resolutions.toml"
+
+// keyedSections are the butane lists whose entries merge by path or name
+var keyedSections = [][]string{
+	{"storage", "files"},
+	{"storage", "directories"},
+	{"storage", "links"},
+	{"systemd", "units"},
+	{"passwd", "users"},
+}
+
+// Conflict is an entry that two sources of a machine's butane define
+// differently, such as the template and a snippet writing the same file
+type Conflict struct {
+	Machine   string
+	Section   string // e.g. storage.files
+	Key       string // File path, or unit or user name
+	Base      string // Source of the entry already merged, e.g. "template"
+	Other     string // Source merging a different version, e.g. "snippet motd"
+	BaseYAML  string
+	OtherYAML string
+}
+
+// ConflictResolver decides a conflict, returning c.Base or c.Other
+type ConflictResolver func(c Conflict) (string, error)
+
+// Resolution is a recorded decision: the entry from Keep wins
+type Resolution struct {
+	Section string `toml:"section"`
+	Key     string `toml:"key"`
+	Keep    string `toml:"keep"`
+}
+
+// Resolutions are the decisions recorded for one machine
+type Resolutions struct {
+	Resolution []Resolution `toml:"resolution"`
+
+	path    string
+	changed bool
+}
+
+// LoadResolutions reads resolutions.toml in a machine's directory (see
+// machine.Config.Directory), returning no resolutions if it doesn't exist
+func LoadResolutions(machineDir string) (*Resolutions, error) {
+	r := &Resolutions{path: filepath.Join(machineDir, ResolutionsFile)}
+	if _, err := toml.DecodeFile(r.path, r); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", r.path, err)
+	}
+	return r, nil
+}
+
+// Lookup returns the recorded decision for c. A decision naming neither
+// source is stale, e.g. after a snippet was renamed, and is ignored.
+func (r *Resolutions) Lookup(c Conflict) (string, bool) {
+	for _, res := range r.Resolution {
+		if res.Section == c.Section && res.Key == c.Key && (res.Keep == c.Base || res.Keep == c.Other) {
+			return res.Keep, true
+		}
+	}
+	return "", false
+}
+
+// Record stores a decision, replacing any earlier one for the same entry
+func (r *Resolutions) Record(c Conflict, keep string) {
+	r.changed = true
+	for i, res := range r.Resolution {
+		if res.Section == c.Section && res.Key == c.Key {
+			r.Resolution[i].Keep = keep
+			return
+		}
+	}
+	r.Resolution = append(r.Resolution, Resolution{Section: c.Section, Key: c.Key, Keep: keep})
+}
+
+// Save writes the resolutions back if Record changed them
+func (r *Resolutions) Save() error {
+	if !r.changed || r.path == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.WriteString("# Merge conflict decisions recorded by iago ignite --resolve-conflicts.\n")
+	buf.WriteString("# keep names the source whose entry wins: \"template\", \"snippet <name>\"\n")
+	buf.WriteString("# or \"hardening control <id>\".\n\n")
+	if err := toml.NewEncoder(&buf).Encode(r); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", r.path, err)
+	}
+	if err := os.WriteFile(r.path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", r.path, err)
+	}
+	r.changed = false
+	return nil
+}
+
+// SetConflictResolver asks resolve about conflicts that have no recorded
+// decision, and records its answers. Without one, such conflicts print a
+// warning and the later source wins.
+func (r *Renderer) SetConflictResolver(resolve ConflictResolver) {
+	r.resolveConflict = resolve
+}
+
+// mergeOrigins tracks which source added each keyed entry, by section and key
+type mergeOrigins map[[2]string]string
+
+// record notes source as the origin of config's keyed entries not seen before
+func (o mergeOrigins) record(config *yaml.Node, source string) {
+	for _, section := range keyedSections {
+		seq := lookupNode(config, section...)
+		if seq == nil || seq.Kind != yaml.SequenceNode {
+			continue
+		}
+		name := section[0] + "." + section[1]
+		for _, item := range seq.Content {
+			if key := itemKey(item); key != "" {
+				if _, seen := o[[2]string{name, key}]; !seen {
+					o[[2]string{name, key}] = source
+				}
+			}
+		}
+	}
+}
+
+// resolveConflicts applies decisions to the entries of fragment that conflict
+// with base: each conflicting entry is taken out of the fragment, and replaces
+// base's entry when the fragment's version wins
+func (r *Renderer) resolveConflicts(base, fragment *yaml.Node, source, machineName string, origins mergeOrigins, resolutions *Resolutions) error {
+	for _, section := range keyedSections {
+		baseSeq, fragmentSeq := lookupNode(base, section...), lookupNode(fragment, section...)
+		if baseSeq == nil || fragmentSeq == nil || baseSeq.Kind != yaml.SequenceNode || fragmentSeq.Kind != yaml.SequenceNode {
+			continue
+		}
+		name := section[0] + "." + section[1]
+
+		var kept []*yaml.Node
+		for _, item := range fragmentSeq.Content {
+			existing := findKeyedItem(baseSeq, item)
+			if existing == nil || !conflicting(existing, item) {
+				kept = append(kept, item)
+				continue
+			}
+
+			c := Conflict{
+				Machine:   machineName,
+				Section:   name,
+				Key:       itemKey(item),
+				Base:      origins[[2]string{name, itemKey(item)}],
+				Other:     source,
+				BaseYAML:  nodeYAML(existing),
+				OtherYAML: nodeYAML(item),
+			}
+			keep, ok := resolutions.Lookup(c)
+			if !ok {
+				if r.resolveConflict == nil {
+					fmt.Fprintf(r.output(), "Warning: %s: %s %s is defined differently by %s and %s; %s wins. Record a decision with iago ignite --resolve-conflicts %s\n",
+						machineName, name, c.Key, c.Base, c.Other, c.Other, machineName)
+					kept = append(kept, item)
+					continue
+				}
+				var err error
+				if keep, err = r.resolveConflict(c); err != nil {
+					return err
+				}
+				if keep != c.Base && keep != c.Other {
+					return fmt.Errorf("conflict on %s %s must keep %q or %q, not %q", name, c.Key, c.Base, c.Other, keep)
+				}
+				resolutions.Record(c, keep)
+			}
+
+			if keep == c.Other {
+				*existing = *item
+				origins[[2]string{name, c.Key}] = source
+			}
+		}
+		fragmentSeq.Content = kept
+	}
+	return nil
+}
+
+// conflicting reports whether merging b into a would change a value a sets
+func conflicting(a, b *yaml.Node) bool {
+	if a.Kind != b.Kind {
+		return true
+	}
+	switch a.Kind {
+	case yaml.ScalarNode:
+		return a.Value != b.Value
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(b.Content); i += 2 {
+			if value := lookupNode(a, b.Content[i].Value); value != nil && conflicting(value, b.Content[i+1]) {
+				return true
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range b.Content {
+			if existing := findKeyedItem(a, item); existing != nil && conflicting(existing, item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookupNode follows mapping keys from node, or returns nil
+func lookupNode(node *yaml.Node, keys ...string) *yaml.Node {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		node = node.Content[0]
+	}
+	for _, key := range keys {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = node.Content[i+1]
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+// itemKey is the path or name identifying a keyed entry
+func itemKey(item *yaml.Node) string {
+	if path := mappingValue(item, "path"); path != "" {
+		return path
+	}
+	return mappingValue(item, "name")
+}
+
+func nodeYAML(node *yaml.Node) string {
+	out, err := yaml.Marshal(node)
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
@@ -0,0 +1,103 @@
+package butane
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/workload"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const conflictTemplate = `storage:
+  files:
+    - path: /etc/motd
+      contents:
+        inline: from template
+systemd:
+  units:
+    - name: a.service
+      enabled: true
+`
+
+var conflictSnippet = fragment{kind: "snippet", name: "motd", content: `storage:
+  files:
+    - path: /etc/motd
+      contents:
+        inline: from snippet
+systemd:
+  units:
+    - name: a.service
+      dropins:
+        - name: override.conf
+          contents: "[Service]"
+`}
+
+func TestMergeFragmentsConflicts(t *testing.T) {
+	tempDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tempDir, "machines", "web"), 0755))
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+	data := TemplateData{Machine: machine.Config{Name: "web"}}
+
+	renderer := NewRenderer(machine.Defaults{}, &workload.Registry{})
+	merged, err := renderer.mergeFragments(conflictTemplate, []fragment{conflictSnippet}, data, nil)
+	require.NoError(t, err)
+	assert.Contains(t, merged, "from snippet", "without a decision the later source wins")
+	assert.NoFileExists(t, filepath.Join("machines", "web", ResolutionsFile))
+
+	var asked []Conflict
+	renderer.SetConflictResolver(func(c Conflict) (string, error) {
+		asked = append(asked, c)
+		return c.Base, nil
+	})
+	merged, err = renderer.mergeFragments(conflictTemplate, []fragment{conflictSnippet}, data, nil)
+	require.NoError(t, err)
+	require.Len(t, asked, 1, "adding a drop-in to a unit isn't a conflict")
+	assert.Equal(t, "storage.files", asked[0].Section)
+	assert.Equal(t, "/etc/motd", asked[0].Key)
+	assert.Equal(t, "template", asked[0].Base)
+	assert.Equal(t, "snippet motd", asked[0].Other)
+	assert.Contains(t, merged, "from template")
+	assert.NotContains(t, merged, "from snippet")
+	assert.Contains(t, merged, "override.conf")
+
+	resolutions, err := LoadResolutions(filepath.Join("machines", "web"))
+	require.NoError(t, err)
+	assert.Equal(t, []Resolution{{Section: "storage.files", Key: "/etc/motd", Keep: "template"}}, resolutions.Resolution)
+
+	// Later generations apply the recorded decision without asking
+	renderer.SetConflictResolver(nil)
+	merged, err = renderer.mergeFragments(conflictTemplate, []fragment{conflictSnippet}, data, nil)
+	require.NoError(t, err)
+	assert.Contains(t, merged, "from template")
+
+	require.NoError(t, os.WriteFile(filepath.Join("machines", "web", ResolutionsFile), []byte(`[[resolution]]
+section = "storage.files"
+key = "/etc/motd"
+keep = "snippet motd"
+`), 0644))
+	merged, err = renderer.mergeFragments(conflictTemplate, []fragment{conflictSnippet}, data, nil)
+	require.NoError(t, err)
+	assert.Contains(t, merged, "from snippet")
+	assert.Equal(t, 1, strings.Count(merged, "path: /etc/motd"))
+}
+
+func TestResolutionsLookupIgnoresStaleSources(t *testing.T) {
+	r := &Resolutions{Resolution: []Resolution{{Section: "systemd.units", Key: "a.service", Keep: "snippet renamed"}}}
+	_, ok := r.Lookup(Conflict{Section: "systemd.units", Key: "a.service", Base: "template", Other: "snippet a"})
+	assert.False(t, ok)
+
+	r.Record(Conflict{Section: "systemd.units", Key: "a.service"}, "template")
+	keep, ok := r.Lookup(Conflict{Section: "systemd.units", Key: "a.service", Base: "template", Other: "snippet a"})
+	assert.True(t, ok)
+	assert.Equal(t, "template", keep)
+	assert.Len(t, r.Resolution, 1)
+}
@@ -0,0 +1,116 @@
+package butane
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+	"github.com/andreweick/iago/internal/machine"
+	"gopkg.in/yaml.v3"
+)
+
+// Drop-ins iago writes the [containers] policy to, leaving the OS's own
+// registries.conf and containers.conf in place
+const (
+	RegistriesConfPath = "/etc/containers/registries.conf.d/50-iago.conf"
+	ContainersConfPath = "/etc/containers/containers.conf.d/50-iago.conf"
+)
+
+// registriesConf is the registries.conf(5) version 2 format
+type registriesConf struct {
+	UnqualifiedSearchRegistries []string        `toml:"unqualified-search-registries,omitempty"`
+	ShortNameMode               string          `toml:"short-name-mode,omitempty"`
+	Registry                    []registryEntry `toml:"registry,omitempty"`
+}
+
+type registryEntry struct {
+	Prefix             string           `toml:"prefix"`
+	Location           string           `toml:"location,omitempty"`
+	Insecure           bool             `toml:"insecure,omitempty"`
+	Blocked            bool             `toml:"blocked,omitempty"`
+	MirrorByDigestOnly bool             `toml:"mirror-by-digest-only,omitempty"`
+	Mirror             []registryMirror `toml:"mirror,omitempty"`
+}
+
+type registryMirror struct {
+	Location string `toml:"location"`
+}
+
+// containersConf is the part of containers.conf(5) [containers] iago manages
+type containersConf struct {
+	Containers struct {
+		LogDriver      string   `toml:"log_driver,omitempty"`
+		LogSizeMax     int64    `toml:"log_size_max,omitempty"`
+		PidsLimit      int64    `toml:"pids_limit,omitempty"`
+		DefaultUlimits []string `toml:"default_ulimits,omitempty"`
+	} `toml:"containers"`
+}
+
+// containersOverlay writes the fleet's [containers] policy to drop-ins under
+// /etc/containers. It returns "" when no policy is configured.
+func containersOverlay(policy machine.ContainersConfig) (string, error) {
+	if policy.IsZero() {
+		return "", nil
+	}
+	if err := machine.ValidateContainers(policy); err != nil {
+		return "", err
+	}
+
+	type file struct {
+		Path     string            `yaml:"path"`
+		Mode     int               `yaml:"mode"`
+		Contents map[string]string `yaml:"contents"`
+	}
+	var files []file
+
+	registries := registriesConf{
+		UnqualifiedSearchRegistries: policy.SearchRegistries,
+		ShortNameMode:               policy.ShortNameMode,
+	}
+	for _, r := range policy.Registries {
+		entry := registryEntry{Prefix: r.Prefix, Location: r.Location, Insecure: r.Insecure, MirrorByDigestOnly: r.MirrorByDigestOnly}
+		for _, mirror := range r.Mirrors {
+			entry.Mirror = append(entry.Mirror, registryMirror{Location: mirror})
+		}
+		registries.Registry = append(registries.Registry, entry)
+	}
+	for _, prefix := range policy.Blocked {
+		registries.Registry = append(registries.Registry, registryEntry{Prefix: prefix, Blocked: true})
+	}
+	if len(registries.UnqualifiedSearchRegistries) > 0 || registries.ShortNameMode != "" || len(registries.Registry) > 0 {
+		content, err := encodeContainersTOML(registries)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, file{Path: RegistriesConfPath, Mode: 0644, Contents: map[string]string{"inline": content}})
+	}
+
+	var containers containersConf
+	containers.Containers.LogDriver = policy.LogDriver
+	containers.Containers.LogSizeMax, _ = policy.LogSizeBytes()
+	containers.Containers.PidsLimit = policy.PidsLimit
+	containers.Containers.DefaultUlimits = policy.Ulimits
+	if containers.Containers.LogDriver != "" || containers.Containers.LogSizeMax != 0 || containers.Containers.PidsLimit != 0 || len(containers.Containers.DefaultUlimits) > 0 {
+		content, err := encodeContainersTOML(containers)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, file{Path: ContainersConfPath, Mode: 0644, Contents: map[string]string{"inline": content}})
+	}
+
+	overlay, err := yaml.Marshal(map[string]any{"storage": map[string]any{"files": files}})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode container policy overlay: %w", err)
+	}
+	return string(overlay), nil
+}
+
+// encodeContainersTOML writes a containers config file with iago's header
+func encodeContainersTOML(v any) (string, error) {
+	var b bytes.Buffer
+	b.WriteString("# Managed by iago from [containers] in defaults.toml\n")
+	if err := toml.NewEncoder(&b).Encode(v); err != nil {
+		return "", fmt.Errorf("failed to encode container policy: %w", err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,74 @@
+package butane
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestContainersOverlay(t *testing.T) {
+	overlay, err := containersOverlay(machine.ContainersConfig{})
+	require.NoError(t, err)
+	assert.Empty(t, overlay)
+
+	overlay, err = containersOverlay(machine.ContainersConfig{
+		ShortNameMode:    "enforcing",
+		SearchRegistries: []string{"registry.lab", "docker.io"},
+		Blocked:          []string{"docker.io/untrusted"},
+		Registries: []machine.RegistryPolicy{
+			{Prefix: "docker.io", Mirrors: []string{"mirror.lab:5000/docker"}},
+			{Prefix: "registry.lab", Insecure: true},
+		},
+		LogDriver:  "k8s-file",
+		LogSizeMax: "10M",
+		PidsLimit:  4096,
+		Ulimits:    []string{"nofile=65535:65535"},
+	})
+	require.NoError(t, err)
+
+	var parsed struct {
+		Storage struct {
+			Files []struct {
+				Path     string
+				Contents struct{ Inline string }
+			}
+		}
+	}
+	require.NoError(t, yaml.Unmarshal([]byte(overlay), &parsed))
+	require.Len(t, parsed.Storage.Files, 2)
+	assert.Equal(t, RegistriesConfPath, parsed.Storage.Files[0].Path)
+	assert.Equal(t, ContainersConfPath, parsed.Storage.Files[1].Path)
+
+	var registries registriesConf
+	_, err = toml.Decode(parsed.Storage.Files[0].Contents.Inline, &registries)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"registry.lab", "docker.io"}, registries.UnqualifiedSearchRegistries)
+	assert.Equal(t, "enforcing", registries.ShortNameMode)
+	assert.Equal(t, []registryEntry{
+		{Prefix: "docker.io", Mirror: []registryMirror{{Location: "mirror.lab:5000/docker"}}},
+		{Prefix: "registry.lab", Insecure: true},
+		{Prefix: "docker.io/untrusted", Blocked: true},
+	}, registries.Registry)
+
+	containers := parsed.Storage.Files[1].Contents.Inline
+	assert.Contains(t, containers, "# Managed by iago")
+	assert.Contains(t, containers, `log_driver = "k8s-file"`)
+	assert.Contains(t, containers, "log_size_max = 10485760")
+	assert.Contains(t, containers, "pids_limit = 4096")
+
+	_, report, err := config.TranslateBytes([]byte("variant: fcos\nversion: 1.5.0\n"+overlay), common.TranslateBytesOptions{})
+	require.NoError(t, err, report.String())
+
+	overlay, err = containersOverlay(machine.ContainersConfig{PidsLimit: 100})
+	require.NoError(t, err)
+	assert.NotContains(t, overlay, RegistriesConfPath, "only the files with settings are written")
+
+	_, err = containersOverlay(machine.ContainersConfig{ShortNameMode: "strict"})
+	assert.ErrorContains(t, err, "short_name_mode")
+}
@@ -0,0 +1,154 @@
+package butane
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/andreweick/iago/internal/machine"
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces secret values in debug output
+const Redacted = "<redacted>"
+
+// templateLocation finds name:line in text/template errors, e.g.
+// "template: machines/web/butane.yaml.tmpl:12:5: executing ..."
+var templateLocation = regexp.MustCompile(`template: (.+?):(\d+)(?::\d+)?: `)
+
+// SetTrace makes rendering report to w each template, overlay, snippet and
+// hardening control it executes, each template function call, and the source
+// line a template failed on
+func (r *Renderer) SetTrace(w io.Writer) {
+	r.trace = w
+}
+
+func (r *Renderer) tracef(format string, args ...any) {
+	if r.trace != nil {
+		fmt.Fprintf(r.trace, format+"\n", args...)
+	}
+}
+
+// traced wraps a template function to report its calls
+func (r *Renderer) traced(name string, fn any) any {
+	v := reflect.ValueOf(fn)
+	return reflect.MakeFunc(v.Type(), func(args []reflect.Value) []reflect.Value {
+		r.tracef("  %s %s", name, traceArgs(args, v.Type().IsVariadic()))
+		if v.Type().IsVariadic() {
+			return v.CallSlice(args)
+		}
+		return v.Call(args)
+	}).Interface()
+}
+
+// traceArgs formats call arguments on one line, shortening long strings
+func traceArgs(args []reflect.Value, variadic bool) string {
+	if variadic && len(args) > 0 {
+		rest := args[len(args)-1]
+		args = args[:len(args)-1]
+		for i := range rest.Len() {
+			args = append(args, rest.Index(i))
+		}
+	}
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		if s, ok := arg.Interface().(string); ok {
+			if len(s) > 40 {
+				s = s[:40] + "…"
+			}
+			parts[i] = strconv.Quote(s)
+			continue
+		}
+		parts[i] = fmt.Sprintf("%v", arg.Interface())
+	}
+	return strings.Join(parts, " ")
+}
+
+// traceError reports the source line a template failed on
+func (r *Renderer) traceError(content string, err error) {
+	if r.trace == nil {
+		return
+	}
+	match := templateLocation.FindStringSubmatch(err.Error())
+	if match == nil {
+		r.tracef("  ✗ %v", err)
+		return
+	}
+	line, _ := strconv.Atoi(match[2])
+	r.tracef("  ✗ %s:%d", match[1], line)
+	if lines := strings.Split(content, "\n"); line > 0 && line <= len(lines) {
+		r.tracef("    %4d | %s", line, lines[line-1])
+	}
+}
+
+// DebugData returns the data m's templates are rendered with, secrets and
+// password hashes redacted
+func (r *Renderer) DebugData(m machine.Config) (TemplateData, error) {
+	data, _, err := r.templateData(m)
+	if err != nil {
+		return TemplateData{}, err
+	}
+	data.GeneratedSecrets.Password = Redacted
+	if data.User.PasswordHash != "" {
+		data.User.PasswordHash = Redacted
+	}
+	if data.Admin.PasswordHash != "" {
+		data.Admin.PasswordHash = Redacted
+	}
+	return data, nil
+}
+
+// MarshalDebugData encodes template data as YAML keyed by the field names
+// templates use, e.g. Machine.ContainerImage rather than container_image
+func MarshalDebugData(data TemplateData) (string, error) {
+	out, err := yaml.Marshal(debugNode(reflect.ValueOf(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode template data: %w", err)
+	}
+	return string(out), nil
+}
+
+// debugNode converts v to a YAML node, naming struct fields as Go does
+func debugNode(v reflect.Value) *yaml.Node {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for i := range v.NumField() {
+			if field := v.Type().Field(i); field.IsExported() {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: field.Name}, debugNode(v.Field(i)))
+			}
+		}
+		return node
+	case reflect.Map:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, key := range keys {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(key)}, debugNode(v.MapIndex(key)))
+		}
+		return node
+	case reflect.Slice, reflect.Array:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for i := range v.Len() {
+			node.Content = append(node.Content, debugNode(v.Index(i)))
+		}
+		return node
+	}
+
+	var node yaml.Node
+	if err := node.Encode(v.Interface()); err != nil {
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(v.Interface())}
+	}
+	return &node
+}
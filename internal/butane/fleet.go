@@ -0,0 +1,44 @@
+package butane
+
+import (
+	"sort"
+
+	"github.com/andreweick/iago/internal/machine"
+)
+
+// Fleet exposes every machine to templates as .Fleet, so configs such as a
+// reverse proxy upstream list or a Prometheus scrape file can be generated
+// from machine.toml files instead of being maintained by hand
+type Fleet struct {
+	Machines []machine.Config
+}
+
+// NewFleet returns the machines sorted by name for stable rendering
+func NewFleet(machines []machine.Config) Fleet {
+	sorted := append([]machine.Config(nil), machines...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return Fleet{Machines: sorted}
+}
+
+// Tagged returns the machines carrying tag
+func (f Fleet) Tagged(tag string) []machine.Config {
+	var result []machine.Config
+	for _, m := range f.Machines {
+		if m.HasTag(tag) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// Get returns the named machine, or an empty config if it doesn't exist
+func (f Fleet) Get(name string) machine.Config {
+	for _, m := range f.Machines {
+		if m.Name == name {
+			return m
+		}
+	}
+	return machine.Config{}
+}
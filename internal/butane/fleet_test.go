@@ -0,0 +1,52 @@
+package butane
+
+import (
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/workload"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testFleet() []machine.Config {
+	return []machine.Config{
+		{Name: "web-02", FQDN: "web-02.example.com", IPAddress: "10.0.0.12", Tags: []string{"web"}},
+		{Name: "proxy", FQDN: "proxy.example.com", Tags: []string{"edge"}},
+		{Name: "web-01", FQDN: "web-01.example.com", IPAddress: "10.0.0.11", Tags: []string{"web", "monitored"}},
+	}
+}
+
+func TestNewFleet_SortsByName(t *testing.T) {
+	machines := testFleet()
+	fleet := NewFleet(machines)
+
+	require.Len(t, fleet.Machines, 3)
+	assert.Equal(t, "proxy", fleet.Machines[0].Name)
+	assert.Equal(t, "web-01", fleet.Machines[1].Name)
+	assert.Equal(t, "web-02", fleet.Machines[2].Name)
+	assert.Equal(t, "web-02", machines[0].Name, "input should not be reordered")
+}
+
+func TestFleet_TaggedAndGet(t *testing.T) {
+	fleet := NewFleet(testFleet())
+
+	web := fleet.Tagged("web")
+	require.Len(t, web, 2)
+	assert.Equal(t, "web-01", web[0].Name)
+	assert.Empty(t, fleet.Tagged("missing"))
+
+	assert.Equal(t, "proxy.example.com", fleet.Get("proxy").FQDN)
+	assert.Empty(t, fleet.Get("missing").Name)
+}
+
+func TestRenderer_FleetTemplateData(t *testing.T) {
+	renderer := NewRenderer(machine.Defaults{}, &workload.Registry{})
+	renderer.SetFleet(testFleet())
+
+	template := `{{ range .Fleet.Tagged "web" }}server {{ .IPAddress }}:80; # {{ .FQDN }}
+{{ end }}`
+	result, err := renderer.renderTemplateString(template, TemplateData{Fleet: renderer.fleet})
+	require.NoError(t, err)
+	assert.Equal(t, "server 10.0.0.11:80; # web-01.example.com\nserver 10.0.0.12:80; # web-02.example.com\n", result)
+}
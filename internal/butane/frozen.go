@@ -0,0 +1,77 @@
+package butane
+
+import (
+	"fmt"
+
+	"github.com/andreweick/iago/internal/github"
+	"github.com/andreweick/iago/internal/machine"
+)
+
+// FrozenInputs are the inputs of a machine's templates that would otherwise
+// change between runs: the generated password, GitHub SSH keys and fetchURL
+// content
+type FrozenInputs struct {
+	Password     string
+	SSHKeys      []string
+	AdminSSHKeys []string          // GitHub keys of [admin] github_username
+	Fetched      map[string][]byte // fetchURL content by URL
+	ImageDigest  string            // Digest of the machine's image; set only when frozen
+}
+
+// RecordInputs makes RenderMachine pass each machine's inputs to record after
+// rendering it; nil stops recording
+func (r *Renderer) RecordInputs(record func(machineName string, inputs FrozenInputs)) {
+	r.record = record
+}
+
+// UseFrozenInputs makes rendering take inputs from frozen instead of
+// generating or fetching them, so output is the same on every run; nil
+// restores fetching
+func (r *Renderer) UseFrozenInputs(frozen func(machineName string) (FrozenInputs, error)) {
+	r.frozen = frozen
+}
+
+// machineInputs returns the generated password and GitHub SSH keys of the
+// machine being rendered, and makes its frozen or recorded inputs current for
+// fetchURL
+func (r *Renderer) machineInputs(machineName string, defaults machine.Defaults) (machine.GeneratedSecrets, FrozenInputs, error) {
+	r.inputs = nil
+	if r.frozen != nil {
+		inputs, err := r.frozen(machineName)
+		if err != nil {
+			return machine.GeneratedSecrets{}, FrozenInputs{}, err
+		}
+		r.inputs = &inputs
+		return machine.GeneratedSecrets{Password: inputs.Password}, inputs, nil
+	}
+
+	secrets, err := r.generateMachineSecrets(machineName)
+	if err != nil {
+		return secrets, FrozenInputs{}, fmt.Errorf("failed to generate secrets: %w", err)
+	}
+
+	inputs := FrozenInputs{Password: secrets.Password, Fetched: make(map[string][]byte)}
+	if inputs.SSHKeys, err = fetchSSHKeys(defaults.User.GitHubUsername); err != nil {
+		return secrets, FrozenInputs{}, err
+	}
+	if inputs.AdminSSHKeys, err = fetchSSHKeys(defaults.Admin.GitHubUsername); err != nil {
+		return secrets, FrozenInputs{}, err
+	}
+
+	if r.record != nil {
+		r.inputs = &inputs
+	}
+	return secrets, inputs, nil
+}
+
+// fetchSSHKeys fetches a GitHub user's SSH keys; none when username is empty
+func fetchSSHKeys(username string) ([]string, error) {
+	if username == "" {
+		return nil, nil
+	}
+	keys, err := github.FetchSSHKeys(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch SSH keys from GitHub: %w", err)
+	}
+	return keys, nil
+}
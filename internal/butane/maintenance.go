@@ -0,0 +1,129 @@
+package butane
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/andreweick/iago/internal/machine"
+	"gopkg.in/yaml.v3"
+)
+
+// Schedule is when a machine's update timers fire, resolved from
+// [maintenance] and [bootc] update_time. Templates can write
+// OnCalendar={{ .Schedule.OnCalendar }} or the whole {{ .Schedule.TimerSettings }}.
+type Schedule struct {
+	OnCalendar         string
+	RandomizedDelaySec int // 0 when machines shouldn't be spread out
+	FixedRandomDelay   bool
+}
+
+// newSchedule validates a machine's maintenance settings into a Schedule
+func newSchedule(maintenance machine.MaintenanceConfig, bootc machine.BootcConfig) (Schedule, error) {
+	if err := machine.ValidateMaintenance(maintenance); err != nil {
+		return Schedule{}, err
+	}
+	schedule := Schedule{OnCalendar: "*-*-* " + bootc.UpdateTime, FixedRandomDelay: maintenance.FixedDelay}
+	if maintenance.Window != "" {
+		schedule.OnCalendar = maintenance.Window
+	}
+	delay, _ := maintenance.Delay()
+	schedule.RandomizedDelaySec = int(delay.Seconds())
+	return schedule, nil
+}
+
+// TimerSettings returns the [Timer] lines, without a final newline, that
+// replace a timer's OnCalendar with the schedule in a drop-in
+func (s Schedule) TimerSettings() string {
+	lines := []string{"OnCalendar=", "OnCalendar=" + s.OnCalendar}
+	if s.RandomizedDelaySec > 0 {
+		lines = append(lines, fmt.Sprintf("RandomizedDelaySec=%d", s.RandomizedDelaySec))
+		if s.FixedRandomDelay {
+			lines = append(lines, "FixedRandomDelay=true")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// maintenanceOverlay moves bootc-update.timer into the machine's maintenance
+// window and, with the periodic update strategy, gives Zincati the same window
+// for reboots. It returns "" when no [maintenance] settings apply.
+func maintenanceOverlay(settings machine.MaintenanceConfig, schedule Schedule, mechanism string, updates machine.UpdateConfig) (string, error) {
+	if settings.IsZero() {
+		return "", nil
+	}
+	type dropin struct {
+		Name     string `yaml:"name"`
+		Contents string `yaml:"contents"`
+	}
+	type unit struct {
+		Name    string   `yaml:"name"`
+		Dropins []dropin `yaml:"dropins"`
+	}
+	type file struct {
+		Path     string            `yaml:"path"`
+		Mode     int               `yaml:"mode"`
+		Contents map[string]string `yaml:"contents"`
+	}
+
+	overlay := map[string]any{}
+	if mechanism == "" || mechanism == machine.UpdateMechanismBootc {
+		overlay["systemd"] = map[string]any{"units": []unit{{
+			Name:    "bootc-update.timer",
+			Dropins: []dropin{{Name: "50-iago-maintenance.conf", Contents: "[Timer]\n" + schedule.TimerSettings() + "\n"}},
+		}}}
+	}
+
+	if settings.Window != "" && updates.Strategy == "periodic" {
+		zincati, err := zincatiWindow(settings.Window, schedule.RandomizedDelaySec)
+		if err != nil {
+			return "", err
+		}
+		overlay["storage"] = map[string]any{"files": []file{{
+			Path:     "/etc/zincati/config.d/55-iago-maintenance.toml",
+			Mode:     0644,
+			Contents: map[string]string{"inline": zincati},
+		}}}
+	}
+	if len(overlay) == 0 {
+		return "", nil
+	}
+
+	content, err := yaml.Marshal(overlay)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode maintenance window: %w", err)
+	}
+	return string(content), nil
+}
+
+// zincatiWindow converts an OnCalendar window into Zincati's periodic
+// strategy configuration, which only knows weekdays and a start time
+func zincatiWindow(window string, delaySec int) (string, error) {
+	cal, err := machine.ParseCalendar(window)
+	if err != nil {
+		return "", err
+	}
+	start, ok := cal.StartTime()
+	if !ok {
+		return "", fmt.Errorf("[maintenance] window %q can't schedule Zincati reboots: with strategy = \"periodic\" it must be weekdays and one time of day, e.g. \"Sat,Sun *-*-* 02:00\"", window)
+	}
+
+	days := []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+	if len(cal.Weekdays) > 0 {
+		days = days[:0]
+		for _, d := range cal.Weekdays {
+			days = append(days, d.String()[:3])
+		}
+	}
+	length := 60
+	if minutes := (delaySec + 59) / 60; minutes > length {
+		length = minutes
+	}
+
+	var b strings.Builder
+	b.WriteString("[updates]\nstrategy = \"periodic\"\n")
+	if cal.Location != "" {
+		fmt.Fprintf(&b, "\n[updates.periodic]\ntime_zone = %q\n", cal.Location)
+	}
+	fmt.Fprintf(&b, "\n[[updates.periodic.window]]\ndays = [ \"%s\" ]\nstart_time = %q\nlength_minutes = %d\n", strings.Join(days, `", "`), start, length)
+	return b.String(), nil
+}
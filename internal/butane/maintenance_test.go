@@ -0,0 +1,74 @@
+package butane
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/workload"
+	"github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderer_MaintenanceWindow(t *testing.T) {
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "web")
+	require.NoError(t, os.MkdirAll(machineDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte(`variant: fcos
+version: 1.5.0
+storage:
+  files:
+    - path: /etc/iago/window
+      contents:
+        inline: "{{ .Schedule.OnCalendar }}"
+`), 0644))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	defaults := machine.Defaults{
+		Bootc:   machine.BootcConfig{UpdateTime: "02:00:00"},
+		Updates: machine.UpdateConfig{Strategy: "periodic"},
+	}
+	rendered, err := NewRenderer(defaults, &workload.Registry{}).RenderMachine(machine.Config{Name: "web"})
+	require.NoError(t, err)
+	assert.Contains(t, rendered, `inline: "*-*-* 02:00:00"`)
+	assert.NotContains(t, rendered, "50-iago-maintenance.conf", "no drop-in without [maintenance]")
+
+	defaults.Maintenance = machine.MaintenanceConfig{Window: "Sat,Sun *-*-* 03:30", RandomizedDelay: "90m"}
+	m := machine.Config{Name: "web", Maintenance: machine.MaintenanceConfig{FixedDelay: true}}
+	rendered, err = NewRenderer(defaults, &workload.Registry{}).RenderMachine(m)
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "name: 50-iago-maintenance.conf")
+	assert.Contains(t, rendered, "OnCalendar=Sat,Sun *-*-* 03:30\n")
+	assert.Contains(t, rendered, "RandomizedDelaySec=5400\n")
+	assert.Contains(t, rendered, "FixedRandomDelay=true\n")
+	assert.Contains(t, rendered, "path: /etc/zincati/config.d/55-iago-maintenance.toml")
+	assert.Contains(t, rendered, `days = [ "Sat", "Sun" ]`)
+	assert.Contains(t, rendered, `start_time = "03:30"`)
+	assert.Contains(t, rendered, "length_minutes = 90")
+
+	_, report, err := config.TranslateBytes([]byte(rendered), common.TranslateBytesOptions{})
+	require.NoError(t, err, report.String())
+
+	podman := machine.Config{Name: "web", UpdateMechanism: machine.UpdateMechanismPodman}
+	rendered, err = NewRenderer(defaults, &workload.Registry{}).RenderMachine(podman)
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "            OnCalendar=Sat,Sun *-*-* 03:30\n            RandomizedDelaySec=5400\n")
+	assert.NotContains(t, rendered, "50-iago-maintenance.conf", "podman machines schedule podman-auto-update.timer instead")
+
+	defaults.Maintenance.Window = "*-*-01 03:30"
+	_, err = NewRenderer(defaults, &workload.Registry{}).RenderMachine(machine.Config{Name: "web"})
+	assert.ErrorContains(t, err, "can't schedule Zincati reboots")
+
+	defaults.Maintenance.Window = "whenever"
+	_, err = NewRenderer(defaults, &workload.Registry{}).RenderMachine(machine.Config{Name: "web"})
+	assert.ErrorContains(t, err, "invalid [maintenance] window")
+}
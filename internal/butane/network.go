@@ -0,0 +1,87 @@
+package butane
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/andreweick/iago/internal/machine"
+	"gopkg.in/yaml.v3"
+)
+
+// networkOverlay writes a NetworkManager keyfile giving a machine with
+// prefix_length or gateway its static address. It replaces the DHCP keyfile a
+// template writes for the same interface, and returns "" for DHCP machines.
+func networkOverlay(m machine.Config, network machine.NetworkConfig) (string, error) {
+	if !m.StaticNetwork() {
+		return "", nil
+	}
+	if err := machine.ValidateStaticNetwork(m); err != nil {
+		return "", err
+	}
+
+	iface := m.NetworkInterface
+	if iface == "" {
+		iface = network.DefaultNetworkInterface
+	}
+	if iface == "" {
+		return "", fmt.Errorf("a static address needs network_interface or [network] default_network_interface")
+	}
+
+	dnsServers := m.DNSServers
+	if len(dnsServers) == 0 {
+		dnsServers = network.DNSServers
+	}
+	var dns4, dns6 []string
+	for _, server := range dnsServers {
+		addr, err := netip.ParseAddr(server)
+		if err != nil {
+			return "", fmt.Errorf("invalid DNS server %q", server)
+		}
+		if addr.Is4() {
+			dns4 = append(dns4, server)
+		} else {
+			dns6 = append(dns6, server)
+		}
+	}
+
+	addr := netip.MustParseAddr(m.IPAddress)
+	static := fmt.Sprintf("method=manual\naddress1=%s/%d", m.IPAddress, m.PrefixLength)
+	if m.Gateway != "" {
+		static += "," + m.Gateway
+	}
+	ipv4, ipv6 := "method=auto", "method=auto"
+	if addr.Is4() {
+		ipv4 = static
+	} else {
+		ipv6 = static
+	}
+	if len(dns4) > 0 {
+		ipv4 += "\ndns=" + strings.Join(dns4, ";") + ";"
+	}
+	if len(dns6) > 0 {
+		ipv6 += "\ndns=" + strings.Join(dns6, ";") + ";"
+	}
+
+	var keyfile strings.Builder
+	fmt.Fprintf(&keyfile, "[connection]\nid=%s\ntype=ethernet\ninterface-name=%s\n", iface, iface)
+	if m.MACAddress != "" {
+		fmt.Fprintf(&keyfile, "\n[ethernet]\nmac-address=%s\n", m.MACAddress)
+	}
+	fmt.Fprintf(&keyfile, "\n[ipv4]\n%s\n\n[ipv6]\n%s\n", ipv4, ipv6)
+
+	type file struct {
+		Path     string            `yaml:"path"`
+		Mode     int               `yaml:"mode"`
+		Contents map[string]string `yaml:"contents"`
+	}
+	content, err := yaml.Marshal(map[string]any{"storage": map[string]any{"files": []file{{
+		Path:     fmt.Sprintf("/etc/NetworkManager/system-connections/%s.nmconnection", iface),
+		Mode:     0600,
+		Contents: map[string]string{"inline": keyfile.String()},
+	}}}})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode network keyfile: %w", err)
+	}
+	return string(content), nil
+}
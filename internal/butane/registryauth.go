@@ -0,0 +1,133 @@
+package butane
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/andreweick/iago/internal/machine"
+	"gopkg.in/yaml.v3"
+)
+
+// RegistryAuthFile is where machines keep their registry pull credentials
+const RegistryAuthFile = "/etc/containers/auth.json"
+
+// registryAuthOverlay writes the [container_registry.host_auth] credentials
+// into a containers-auth.json(5) file readable only by root, and points every
+// system service's podman at it through REGISTRY_AUTH_FILE. With age-encrypted
+// secrets the file lives under /etc/iago/secrets/ and is decrypted on the host.
+// Rootless machines also get a copy in the user's ~/.config/containers.
+// It returns "" when no registries are configured.
+func (r *Renderer) registryAuthOverlay(m machine.Config, defaults machine.Defaults) (string, error) {
+	hostAuth := defaults.ContainerRegistry.HostAuth
+	if len(hostAuth) == 0 {
+		return "", nil
+	}
+	encrypted := defaults.Secrets.Encryption == SecretsEncryptionAge
+	if encrypted && m.Rootless {
+		return "", fmt.Errorf("[container_registry.host_auth] can't reach rootless user %s when [secrets] encryption is %q", m.RootlessUserName(), SecretsEncryptionAge)
+	}
+
+	hosts := make([]string, 0, len(hostAuth))
+	for host := range hostAuth {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	type credential struct {
+		Auth string `json:"auth"`
+	}
+	auths := make(map[string]credential, len(hosts))
+	for _, host := range hosts {
+		entry := hostAuth[host]
+		token, err := r.hostAuthToken(host, entry)
+		if err != nil {
+			return "", err
+		}
+		auths[host] = credential{Auth: base64.StdEncoding.EncodeToString([]byte(entry.Username + ":" + token))}
+	}
+	content, err := json.MarshalIndent(map[string]any{"auths": auths}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode registry credentials: %w", err)
+	}
+
+	type owner struct {
+		Name string `yaml:"name"`
+	}
+	type file struct {
+		Path     string            `yaml:"path"`
+		Mode     int               `yaml:"mode"`
+		User     *owner            `yaml:"user,omitempty"`
+		Group    *owner            `yaml:"group,omitempty"`
+		Contents map[string]string `yaml:"contents"`
+	}
+	type dropin struct {
+		Name     string `yaml:"name"`
+		Contents string `yaml:"contents"`
+	}
+	type unit struct {
+		Name    string   `yaml:"name"`
+		Dropins []dropin `yaml:"dropins"`
+	}
+
+	authPath := RegistryAuthFile
+	if encrypted {
+		authPath = SecretsDir + "containers-auth.json"
+	}
+	files := []file{
+		{Path: authPath, Mode: 0600, Contents: map[string]string{"inline": string(content) + "\n"}},
+		{
+			Path:     "/etc/systemd/system.conf.d/50-iago-registry-auth.conf",
+			Mode:     0644,
+			Contents: map[string]string{"inline": "[Manager]\nDefaultEnvironment=REGISTRY_AUTH_FILE=" + authPath + "\n"},
+		},
+	}
+	if m.Rootless {
+		user := &owner{Name: m.RootlessUserName()}
+		files = append(files, file{
+			Path:     fmt.Sprintf("/var/home/%s/.config/containers/auth.json", user.Name),
+			Mode:     0600,
+			User:     user,
+			Group:    user,
+			Contents: map[string]string{"inline": string(content) + "\n"},
+		})
+	}
+	overlay := map[string]any{"storage": map[string]any{"files": files}}
+	if encrypted {
+		// Pull once the credentials are decrypted rather than failing first
+		overlay["systemd"] = map[string]any{"units": []unit{{
+			Name:    "bootc@.service",
+			Dropins: []dropin{{Name: "50-iago-registry-auth.conf", Contents: "[Unit]\nAfter=iago-decrypt-secrets.service\n"}},
+		}}}
+	}
+
+	rendered, err := yaml.Marshal(overlay)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode registry auth overlay: %w", err)
+	}
+	return string(rendered), nil
+}
+
+// hostAuthToken resolves a registry's pull token with the [secrets] provider or from the environment
+func (r *Renderer) hostAuthToken(host string, entry machine.HostAuthConfig) (string, error) {
+	switch {
+	case entry.Username == "":
+		return "", fmt.Errorf("host_auth for %s has no username", host)
+	case entry.Ref != "" && entry.TokenEnv == "":
+		token, err := r.lookupSecret(entry.Ref)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve the pull token for %s: %w", host, err)
+		}
+		return token, nil
+	case entry.TokenEnv != "" && entry.Ref == "":
+		token := os.Getenv(entry.TokenEnv)
+		if token == "" {
+			return "", fmt.Errorf("pull token for %s: %s is not set", host, entry.TokenEnv)
+		}
+		return token, nil
+	default:
+		return "", fmt.Errorf("host_auth for %s needs either ref or token_env", host)
+	}
+}
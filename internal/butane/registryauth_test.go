@@ -0,0 +1,76 @@
+package butane
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreweick/iago/internal/agecrypt"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/workload"
+	"github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderer_RegistryAuth(t *testing.T) {
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "web")
+	require.NoError(t, os.MkdirAll(machineDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte("variant: fcos\nversion: 1.5.0\n"), 0644))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	t.Setenv("LAB_PULL_TOKEN", "lab-token")
+	defaults := machine.Defaults{ContainerRegistry: machine.ContainerRegistryConfig{HostAuth: map[string]machine.HostAuthConfig{
+		"ghcr.io":      {Username: "andreweick", Ref: "op://infra/ghcr-pull/credential"},
+		"registry.lab": {Username: "robot", TokenEnv: "LAB_PULL_TOKEN"},
+	}}}
+	renderer := NewRenderer(defaults, &workload.Registry{})
+	renderer.resolveSecret = func(_ context.Context, ref string) (string, error) {
+		assert.Equal(t, "op://infra/ghcr-pull/credential", ref)
+		return "ghcr-token", nil
+	}
+
+	rendered, err := renderer.RenderMachine(machine.Config{Name: "web", Rootless: true})
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "path: "+RegistryAuthFile)
+	assert.Contains(t, rendered, base64.StdEncoding.EncodeToString([]byte("andreweick:ghcr-token")))
+	assert.Contains(t, rendered, base64.StdEncoding.EncodeToString([]byte("robot:lab-token")))
+	assert.Contains(t, rendered, "DefaultEnvironment=REGISTRY_AUTH_FILE="+RegistryAuthFile)
+	assert.Contains(t, rendered, "path: /var/home/web/.config/containers/auth.json")
+
+	_, report, err := config.TranslateBytes([]byte(rendered), common.TranslateBytesOptions{})
+	require.NoError(t, err, report.String())
+
+	t.Setenv("LAB_PULL_TOKEN", "")
+	_, err = renderer.RenderMachine(machine.Config{Name: "web"})
+	assert.ErrorContains(t, err, "pull token for registry.lab: LAB_PULL_TOKEN is not set")
+
+	t.Setenv("LAB_PULL_TOKEN", "lab-token")
+	machineKey, err := agecrypt.GenerateIdentity()
+	require.NoError(t, err)
+	_, err = agecrypt.AddRecipient(agecrypt.RecipientsPath(filepath.Join("machines", "web")), machineKey.Recipient().String(), "web")
+	require.NoError(t, err)
+	defaults.Secrets = machine.SecretsConfig{Encryption: SecretsEncryptionAge}
+	renderer = NewRenderer(defaults, &workload.Registry{})
+	renderer.resolveSecret = func(context.Context, string) (string, error) { return "ghcr-token", nil }
+
+	rendered, err = renderer.RenderMachine(machine.Config{Name: "web"})
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "path: /etc/iago/secrets/containers-auth.json.age")
+	assert.NotContains(t, rendered, base64.StdEncoding.EncodeToString([]byte("robot:lab-token")))
+	assert.Contains(t, rendered, "DefaultEnvironment=REGISTRY_AUTH_FILE=/etc/iago/secrets/containers-auth.json")
+	assert.Contains(t, rendered, "After=iago-decrypt-secrets.service")
+
+	_, err = renderer.RenderMachine(machine.Config{Name: "web", Rootless: true})
+	assert.ErrorContains(t, err, "can't reach rootless user web")
+}
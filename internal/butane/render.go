@@ -2,27 +2,119 @@ package butane
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
-	"regexp"
+	"path/filepath"
+	"slices"
 	"strings"
 	"text/template"
+	"time"
 
-	"github.com/andreweick/iago/internal/github"
+	"github.com/andreweick/iago/internal/fetch"
+	"github.com/andreweick/iago/internal/hardening"
 	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/snippets"
 	"github.com/andreweick/iago/internal/workload"
 	"gopkg.in/yaml.v3"
 )
 
 // getTemplateFuncs returns custom template functions for butane templates
 func (r *Renderer) getTemplateFuncs() template.FuncMap {
-	return template.FuncMap{
-		"indent":  indent,
-		"toYAML":  toYAML,
-		"default": defaultValue,
-		"hasKey":  hasKey,
-		"list":    list,
+	funcs := template.FuncMap{
+		"indent":   indent,
+		"toYAML":   toYAML,
+		"default":  defaultValue,
+		"hasKey":   hasKey,
+		"list":     list,
+		"fetchURL": r.fetchURL,
+		"op":       r.onePassword,
+		"secret":   r.secret,
 	}
+	if r.trace != nil {
+		for name, fn := range funcs {
+			funcs[name] = r.traced(name, fn)
+		}
+	}
+	return funcs
+}
+
+// onePassword inlines a secret from 1Password, given as op://vault/item/field
+// or item/field in [onepassword] vault. With age encryption it is sealed (see
+// sealedSecrets).
+func (r *Renderer) onePassword(ref string) (string, error) {
+	if r.resolveRef == nil {
+		return "", fmt.Errorf("op is not configured")
+	}
+	value, err := r.resolveRef(r.context(), ref)
+	if err != nil {
+		return "", err
+	}
+	return r.sealed.seal(value), nil
+}
+
+// secret inlines a secret from the [secrets] provider, in its reference
+// syntax. With age encryption it is sealed (see sealedSecrets).
+func (r *Renderer) secret(ref string) (string, error) {
+	value, err := r.lookupSecret(ref)
+	if err != nil {
+		return "", err
+	}
+	return r.sealed.seal(value), nil
+}
+
+// lookupSecret resolves ref with the [secrets] provider
+func (r *Renderer) lookupSecret(ref string) (string, error) {
+	if r.resolveSecret == nil {
+		return "", fmt.Errorf("secret is not configured")
+	}
+	return r.resolveSecret(r.context(), ref)
+}
+
+// context returns the context secret lookups run with
+func (r *Renderer) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// fetchURL inlines remote content from an allow-listed host, optionally pinned to a checksum
+func (r *Renderer) fetchURL(url string, checksum ...string) (string, error) {
+	if r.fetcher == nil {
+		return "", fmt.Errorf("fetchURL is not configured")
+	}
+	if len(checksum) > 1 {
+		return "", fmt.Errorf("fetchURL takes a URL and an optional checksum")
+	}
+
+	pinned := ""
+	if len(checksum) == 1 {
+		pinned = checksum[0]
+	}
+
+	if r.frozen != nil && r.inputs != nil {
+		content, ok := r.inputs.Fetched[url]
+		if !ok {
+			return "", fmt.Errorf("%s was not fetched by iago freeze; freeze the machine again", url)
+		}
+		if pinned != "" {
+			if err := fetch.VerifyChecksum(content, pinned); err != nil {
+				return "", fmt.Errorf("%s: %w", url, err)
+			}
+		}
+		return string(content), nil
+	}
+
+	content, err := r.fetcher.Fetch(url, pinned)
+	if err != nil {
+		return "", err
+	}
+	if r.record != nil && r.inputs != nil {
+		r.inputs.Fetched[url] = content
+	}
+	return string(content), nil
 }
 
 // indent adds the specified number of spaces to each line
@@ -96,59 +188,376 @@ type TemplateData struct {
 	ContainerRegistry machine.ContainerRegistryConfig
 	Machine           machine.Config
 	GeneratedSecrets  machine.GeneratedSecrets
-	UserSSHKeys       []string // SSH keys fetched from GitHub
+	Secrets           machine.SecretsConfig
+	Containers        machine.ContainersConfig
+	Schedule          Schedule       // When update timers fire, from [maintenance]
+	UserSSHKeys       []string       // SSH keys fetched from GitHub
+	AdminSSHKeys      []string       // [admin] github_username keys, then ssh_keys
+	Fleet             Fleet          // All machines, for fleet-wide configs
+	Vars              map[string]any // The machine's [vars] table
+	ImageDigest       string         // Digest iago freeze resolved the machine's image to; set with --frozen
 }
 
 type Renderer struct {
 	defaults machine.Defaults
 	registry *workload.Registry
+	fetcher  *fetch.Fetcher
+	fleet    Fleet
+	// resolveRef looks up op:// references for the op template function
+	resolveRef func(ctx context.Context, ref string) (string, error)
+	// resolveSecret looks up references with the [secrets] provider
+	resolveSecret func(ctx context.Context, ref string) (string, error)
+	// ctx is the command's context for secret lookups; nil uses context.Background
+	ctx context.Context
+	// sealed holds the machine being rendered's op and secret values when it
+	// encrypts secrets with age; nil otherwise
+	sealed *sealedSecrets
+	// defaultsFor resolves per-machine defaults (role overlays); nil uses defaults
+	defaultsFor func(machine.Config) (machine.Defaults, error)
+	// resolveConflict decides merge conflicts without a recorded resolution
+	resolveConflict ConflictResolver
+	// trace receives the templates and functions rendering executes; nil when not tracing
+	trace io.Writer
+	// record receives each rendered machine's inputs for iago freeze
+	record func(machineName string, inputs FrozenInputs)
+	// frozen supplies inputs instead of generating and fetching them
+	frozen func(machineName string) (FrozenInputs, error)
+	// inputs are the machine being rendered's frozen or recorded inputs; nil otherwise
+	inputs *FrozenInputs
+	// out receives warnings; os.Stdout when nil
+	out io.Writer
+	// configWarning is a problem with the defaults, printed before the first render
+	configWarning string
 }
 
 func NewRenderer(defaults machine.Defaults, registry *workload.Registry) *Renderer {
-	return &Renderer{
-		defaults: defaults,
-		registry: registry,
+	fetchConfig := defaults.Templates.Fetch
+	var cacheTTL time.Duration
+	var configWarning string
+	if fetchConfig.CacheTTL != "" {
+		ttl, err := time.ParseDuration(fetchConfig.CacheTTL)
+		if err != nil {
+			configWarning = fmt.Sprintf("Warning: invalid templates.fetch.cache_ttl '%s', using %s", fetchConfig.CacheTTL, fetch.DefaultCacheTTL)
+		} else {
+			cacheTTL = ttl
+		}
+	}
+
+	r := &Renderer{
+		defaults:      defaults,
+		registry:      registry,
+		fetcher:       fetch.NewFetcher(fetchConfig.AllowedHosts, fetchConfig.CacheDir, cacheTTL),
+		configWarning: configWarning,
+	}
+	if secretResolvers != nil {
+		r.resolveRef, r.resolveSecret = secretResolvers(defaults)
+	}
+	return r
+}
+
+// SecretResolvers returns the resolvers behind the op and secret template
+// functions for a fleet's defaults
+type SecretResolvers func(defaults machine.Defaults) (onePassword, secret func(ctx context.Context, ref string) (string, error))
+
+var secretResolvers SecretResolvers
+
+// RegisterSecretResolvers makes new renderers resolve op and secret references
+// with resolvers. The renderer doesn't depend on the secret providers itself,
+// so binaries built without them (iago-lite) render everything else.
+func RegisterSecretResolvers(resolvers SecretResolvers) {
+	secretResolvers = resolvers
+}
+
+// SetContext makes the op and secret template functions look secrets up with ctx
+func (r *Renderer) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// SetOutput makes the renderer and its fetcher print warnings to w
+func (r *Renderer) SetOutput(w io.Writer) {
+	r.out = w
+	r.fetcher.Out = w
+}
+
+func (r *Renderer) output() io.Writer {
+	if r.out == nil {
+		return os.Stdout
 	}
+	return r.out
+}
+
+// SetFleet makes machines available to templates as .Fleet
+func (r *Renderer) SetFleet(machines []machine.Config) {
+	r.fleet = NewFleet(machines)
+}
+
+// SetDefaultsResolver makes RenderMachine use per-machine defaults, such as
+// defaults with role overlays applied for the machine's tags
+func (r *Renderer) SetDefaultsResolver(defaultsFor func(machine.Config) (machine.Defaults, error)) {
+	r.defaultsFor = defaultsFor
 }
 
 func (r *Renderer) RenderMachine(machineConfig machine.Config) (string, error) {
-	// Generate secrets for the machine
-	secrets, err := r.generateMachineSecrets(machineConfig.Name)
+	rendered, _, err := r.RenderMachineSources(machineConfig)
+	return rendered, err
+}
+
+// RenderMachineSources renders a machine like RenderMachine and also returns
+// the map from the rendered butane back to the templates it came from
+func (r *Renderer) RenderMachineSources(machineConfig machine.Config) (string, *SourceMap, error) {
+	if r.configWarning != "" {
+		fmt.Fprintln(r.output(), r.configWarning)
+		r.configWarning = ""
+	}
+	templateData, defaults, err := r.templateData(machineConfig)
+	if err != nil {
+		return "", nil, err
+	}
+	r.sealed = nil
+	if templateData.Secrets.Encryption == SecretsEncryptionAge {
+		r.sealed = &sealedSecrets{}
+	}
+	sources := newSourceMap()
+	maintenance := machine.ResolveMaintenance(machineConfig, defaults)
+
+	// Render complete per-machine template
+	machineButanePath := filepath.Join(machineConfig.Directory(), "butane.yaml.tmpl")
+	r.tracef("template %s", machineButanePath)
+	rendered, err := r.renderPureYAMLTemplate(machineButanePath, templateData, sources)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to render machine butane template %s: %w", machineButanePath, err)
+	}
+
+	var fragments []fragment
+	if machineConfig.Rootless {
+		fragments = append(fragments, fragment{kind: "overlay", name: "rootless", content: rootlessOverlay})
+	}
+	switch machineConfig.UpdateMechanism {
+	case "", machine.UpdateMechanismBootc:
+	case machine.UpdateMechanismPodman:
+		fragments = append(fragments, fragment{kind: "overlay", name: "podman-auto-update", content: podmanAutoUpdateOverlay})
+	default:
+		return "", nil, fmt.Errorf("unknown update_mechanism '%s' (expected %s or %s)", machineConfig.UpdateMechanism, machine.UpdateMechanismBootc, machine.UpdateMechanismPodman)
+	}
+	maintenanceWindow, err := maintenanceOverlay(maintenance, templateData.Schedule, machineConfig.UpdateMechanism, defaults.Updates)
+	if err != nil {
+		return "", nil, err
+	}
+	if maintenanceWindow != "" {
+		fragments = append(fragments, fragment{kind: "overlay", name: "maintenance", content: maintenanceWindow, literal: true})
+	}
+	switch defaults.Secrets.Encryption {
+	case "", "none":
+	case SecretsEncryptionAge:
+		fragments = append(fragments, fragment{kind: "overlay", name: "secrets", content: ageSecretsOverlay})
+	default:
+		return "", nil, fmt.Errorf("unknown [secrets] encryption '%s' (expected none or %s)", defaults.Secrets.Encryption, SecretsEncryptionAge)
+	}
+	registryAuth, err := r.registryAuthOverlay(machineConfig, defaults)
+	if err != nil {
+		return "", nil, err
+	}
+	if registryAuth != "" {
+		fragments = append(fragments, fragment{kind: "overlay", name: "registry-auth", content: registryAuth, literal: true})
+	}
+	containers, err := containersOverlay(defaults.Containers)
+	if err != nil {
+		return "", nil, err
+	}
+	if containers != "" {
+		fragments = append(fragments, fragment{kind: "overlay", name: "containers", content: containers, literal: true})
+	}
+	accounts, err := accountsOverlay(defaults.User.Account(), defaults.Admin.Account())
+	if err != nil {
+		return "", nil, err
+	}
+	if accounts != "" {
+		fragments = append(fragments, fragment{kind: "overlay", name: "accounts", content: accounts})
+	}
+	network, err := networkOverlay(machineConfig, defaults.Network)
+	if err != nil {
+		return "", nil, err
+	}
+	if network != "" {
+		fragments = append(fragments, fragment{kind: "overlay", name: "network", content: network, literal: true})
+	}
+	units, err := unitsOverlay(machineConfig.Directory(), machineConfig.Units)
+	if err != nil {
+		return "", nil, err
+	}
+	if units != "" {
+		fragments = append(fragments, fragment{kind: "overlay", name: "units", content: units, literal: true})
+	}
+	sysextPull, err := sysextOverlay(machineConfig, defaults.Sysext)
+	if err != nil {
+		return "", nil, err
+	}
+	if sysextPull != "" {
+		fragments = append(fragments, fragment{kind: "overlay", name: "sysext", content: sysextPull, literal: true})
+	}
+	caTrust, err := caTrustOverlay(defaults.CA)
+	if err != nil {
+		return "", nil, err
+	}
+	if caTrust != "" {
+		fragments = append(fragments, fragment{kind: "overlay", name: "ca", content: caTrust, literal: true})
+	}
+	for _, name := range machineConfig.Snippets {
+		snippet, err := snippets.Get(name)
+		if err != nil {
+			return "", nil, err
+		}
+		fragments = append(fragments, fragment{kind: "snippet", name: name, content: snippet.Content})
+	}
+
+	plan, err := hardening.PlanFor(hardening.Settings(machineConfig, defaults))
+	if err != nil {
+		return "", nil, err
+	}
+	for _, control := range plan.Controls {
+		fragments = append(fragments, fragment{kind: "hardening control", name: control.ID, content: control.Content})
+	}
+
+	// Always re-encode, even without fragments, so every machine's output is canonical
+	merged, err := r.mergeFragments(rendered, fragments, templateData, sources)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate secrets: %w", err)
+		return "", nil, err
+	}
+	if r.record != nil {
+		r.record(machineConfig.Name, *r.inputs)
 	}
+	return merged, sources, nil
+}
 
-	// Fetch SSH keys from GitHub if username is configured
-	var userSSHKeys []string
-	if r.defaults.User.GitHubUsername != "" {
-		keys, err := github.FetchSSHKeys(r.defaults.User.GitHubUsername)
+// templateData returns the data a machine's templates are rendered with, and
+// the defaults that apply to the machine
+func (r *Renderer) templateData(machineConfig machine.Config) (TemplateData, machine.Defaults, error) {
+	defaults := r.defaults
+	if r.defaultsFor != nil {
+		resolved, err := r.defaultsFor(machineConfig)
 		if err != nil {
-			return "", fmt.Errorf("failed to fetch SSH keys from GitHub: %w", err)
+			return TemplateData{}, defaults, fmt.Errorf("failed to resolve defaults: %w", err)
 		}
-		userSSHKeys = keys
+		defaults = resolved
+	}
+
+	secrets, inputs, err := r.machineInputs(machineConfig.Name, defaults)
+	if err != nil {
+		return TemplateData{}, defaults, err
+	}
+
+	maintenance := machine.ResolveMaintenance(machineConfig, defaults)
+	schedule, err := newSchedule(maintenance, defaults.Bootc)
+	if err != nil {
+		return TemplateData{}, defaults, err
 	}
 
 	// Prepare template data
 	templateData := TemplateData{
-		User:              r.defaults.User,
-		Admin:             r.defaults.Admin,
-		Network:           r.defaults.Network,
-		Updates:           r.defaults.Updates,
-		Bootc:             r.defaults.Bootc,
-		ContainerRegistry: r.defaults.ContainerRegistry,
+		User:              defaults.User,
+		Admin:             defaults.Admin,
+		Network:           defaults.Network,
+		Updates:           defaults.Updates,
+		Bootc:             defaults.Bootc,
+		ContainerRegistry: defaults.ContainerRegistry,
 		Machine:           machineConfig,
 		GeneratedSecrets:  secrets,
-		UserSSHKeys:       userSSHKeys,
+		Secrets:           defaults.Secrets,
+		Containers:        defaults.Containers,
+		Schedule:          schedule,
+		UserSSHKeys:       inputs.SSHKeys,
+		AdminSSHKeys:      append(slices.Clone(inputs.AdminSSHKeys), defaults.Admin.SSHKeys...),
+		Fleet:             r.fleet,
+		Vars:              machineConfig.Vars,
+	}
+	if r.inputs != nil {
+		templateData.ImageDigest = r.inputs.ImageDigest
 	}
+	if defaults.Secrets.Encryption == SecretsEncryptionAge {
+		if err := checkAgeImage(defaults.Secrets.AgeImage); err != nil {
+			return TemplateData{}, defaults, err
+		}
+	}
+	return templateData, defaults, nil
+}
 
-	// Render complete per-machine template
-	machineButanePath := fmt.Sprintf("machines/%s/butane.yaml.tmpl", machineConfig.Name)
-	rendered, err := r.renderPureYAMLTemplate(machineButanePath, templateData)
-	if err != nil {
-		return "", fmt.Errorf("failed to render machine butane template %s: %w", machineButanePath, err)
+// fragment is a templated butane document merged into a machine's rendered config
+type fragment struct {
+	kind    string // "overlay", "snippet" or "hardening control", for error messages
+	name    string
+	content string
+	literal bool // content is final and isn't run through text/template
+}
+
+// mergeFragments renders catalog fragments with the machine's data, merges them
+// into the butane config and writes the result in canonical form
+func (r *Renderer) mergeFragments(base string, fragments []fragment, data TemplateData, sources *SourceMap) (string, error) {
+	var baseConfig yaml.Node
+	if err := yaml.Unmarshal([]byte(base), &baseConfig); err != nil {
+		return "", fmt.Errorf("failed to parse rendered butane: %w", sources.yamlError(sources.baseName(), err))
 	}
+	sources.record(sources.baseName(), &baseConfig)
 
-	return rendered, nil
+	resolutions := &Resolutions{}
+	if data.Machine.Name != "" {
+		var err error
+		if resolutions, err = LoadResolutions(data.Machine.Directory()); err != nil {
+			return "", err
+		}
+	}
+	origins := mergeOrigins{}
+	origins.record(&baseConfig, "template")
+
+	for _, f := range fragments {
+		source := f.kind + " " + f.name
+		rendered := f.content
+		if f.literal {
+			r.tracef("%s %s (not templated)", f.kind, f.name)
+			sources.addTemplate(source, "", nil)
+		} else {
+			r.tracef("%s %s", f.kind, f.name)
+			var lines []int
+			var err error
+			rendered, lines, err = r.renderMappedTemplate(source, f.content, data)
+			if err != nil {
+				return "", fmt.Errorf("failed to render %s %s: %w", f.kind, f.name, err)
+			}
+			sources.addTemplate(source, f.content, lines)
+		}
+
+		var fragmentConfig yaml.Node
+		if err := yaml.Unmarshal([]byte(rendered), &fragmentConfig); err != nil {
+			return "", fmt.Errorf("failed to parse %s %s: %w", f.kind, f.name, sources.yamlError(source, err))
+		}
+		sources.record(source, &fragmentConfig)
+		// Built-in overlays replace template entries on purpose; snippets and
+		// hardening controls that disagree with what's merged are conflicts
+		if f.kind != "overlay" {
+			if err := r.resolveConflicts(&baseConfig, &fragmentConfig, source, data.Machine.Name, origins, resolutions); err != nil {
+				return "", fmt.Errorf("failed to resolve conflicts with %s: %w", source, err)
+			}
+		}
+		if err := r.mergeYAMLNodes(&baseConfig, &fragmentConfig); err != nil {
+			return "", fmt.Errorf("failed to merge %s %s: %w", f.kind, f.name, err)
+		}
+		origins.record(&fragmentConfig, source)
+	}
+	if err := resolutions.Save(); err != nil {
+		return "", err
+	}
+
+	if data.Secrets.Encryption == SecretsEncryptionAge {
+		if err := r.sealed.unseal(&baseConfig); err != nil {
+			return "", err
+		}
+		if err := encryptSecrets(&baseConfig, data.Machine, data.Secrets); err != nil {
+			return "", err
+		}
+	}
+
+	canonicalize(&baseConfig)
+	sources.setRoot(&baseConfig)
+	return encodeYAML(&baseConfig)
 }
 
 func (r *Renderer) renderTemplate(templatePath string, data TemplateData) (string, error) {
@@ -157,27 +566,48 @@ func (r *Renderer) renderTemplate(templatePath string, data TemplateData) (strin
 		return "", fmt.Errorf("failed to read template %s: %w", templatePath, err)
 	}
 
-	return r.renderTemplateString(string(content), data)
+	return r.renderNamedTemplate(templatePath, string(content), data)
 }
 
 func (r *Renderer) renderTemplateString(templateContent string, data TemplateData) (string, error) {
+	return r.renderNamedTemplate("butane", templateContent, data)
+}
+
+// renderNamedTemplate renders a template named after where it came from, so
+// errors point at name:line:column
+func (r *Renderer) renderNamedTemplate(name, templateContent string, data TemplateData) (string, error) {
+	rendered, _, err := r.renderMappedTemplate(name, templateContent, data)
+	return rendered, err
+}
+
+// renderMappedTemplate renders a template like renderNamedTemplate and also
+// returns the template line each line of the output came from
+func (r *Renderer) renderMappedTemplate(name, templateContent string, data TemplateData) (string, []int, error) {
 	// Create template with custom functions
-	tmpl := template.New("butane").Funcs(r.getTemplateFuncs())
+	tmpl := template.New(name).Funcs(r.getTemplateFuncs())
 
 	tmpl, err := tmpl.Parse(templateContent)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse template: %w", err)
+		r.traceError(templateContent, err)
+		return "", nil, fmt.Errorf("failed to parse template: %w", err)
 	}
+	markLines(tmpl, templateContent)
 
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", fmt.Errorf("failed to execute template: %w", err)
+		r.traceError(templateContent, err)
+		return "", nil, fmt.Errorf("failed to execute template: %w", err)
 	}
+	rendered, lines := stripLineMarks(buf.String())
+	return rendered, lines, nil
+}
 
-	// Post-process to convert quoted octal strings back to proper octal notation
-	result := r.convertQuotedOctalToOctal(buf.String())
-
-	return result, nil
+// ParseTemplate checks content parses as a butane template, without executing it
+func ParseTemplate(name, content string) error {
+	if _, err := template.New(name).Funcs((&Renderer{}).getTemplateFuncs()).Parse(content); err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	return nil
 }
 
 func (r *Renderer) generateMachineSecrets(machineName string) (machine.GeneratedSecrets, error) {
@@ -249,19 +679,8 @@ func (r *Renderer) mergeButaneConfigs(base, machine, workload string) (string, e
 		}
 	}
 
-	// Convert back to YAML preserving the original order
-	var buf bytes.Buffer
-	encoder := yaml.NewEncoder(&buf)
-	encoder.SetIndent(2)
-	if err := encoder.Encode(&baseConfig); err != nil {
-		return "", fmt.Errorf("failed to marshal merged config: %w", err)
-	}
-	encoder.Close()
-
-	// Post-process to convert quoted octal strings back to proper octal notation
-	processedResult := r.convertQuotedOctalToOctal(buf.String())
-
-	return processedResult, nil
+	canonicalize(&baseConfig)
+	return encodeYAML(&baseConfig)
 }
 
 // mergeYAMLNodes merges YAML nodes while preserving key order from the base document
@@ -280,10 +699,8 @@ func (r *Renderer) mergeYAMLNodes(base, override *yaml.Node) error {
 
 	// Create a map of keys from override for quick lookup
 	overrideMap := make(map[string]*yaml.Node)
-	for i := 0; i < len(override.Content); i += 2 {
-		key := override.Content[i].Value
-		value := override.Content[i+1]
-		overrideMap[key] = value
+	for i := 0; i+1 < len(override.Content); i += 2 {
+		overrideMap[override.Content[i].Value] = override.Content[i+1]
 	}
 
 	// Merge existing keys and add new ones while preserving base order
@@ -298,29 +715,75 @@ func (r *Renderer) mergeYAMLNodes(base, override *yaml.Node) error {
 					return err
 				}
 			} else if baseValue.Kind == yaml.SequenceNode && overrideValue.Kind == yaml.SequenceNode {
-				// For sequences, append override items to base
-				baseValue.Content = append(baseValue.Content, overrideValue.Content...)
+				if err := r.mergeYAMLSequences(baseValue, overrideValue); err != nil {
+					return err
+				}
 			} else {
-				// For other types, override takes precedence
-				*baseValue = *overrideValue
+				// For other types, override takes precedence; the node is
+				// replaced rather than copied so it keeps its SourceMap origin
+				base.Content[i+1] = overrideValue
 			}
 			// Remove from override map since we've processed it
 			delete(overrideMap, key)
 		}
 	}
 
-	// Add any remaining keys from override
-	for key, value := range overrideMap {
-		keyNode := &yaml.Node{
-			Kind:  yaml.ScalarNode,
-			Value: key,
+	// Add any remaining keys in the override's order, so output is the same on every run
+	for i := 0; i+1 < len(override.Content); i += 2 {
+		key := override.Content[i]
+		if value, remaining := overrideMap[key.Value]; remaining {
+			base.Content = append(base.Content, key, value)
+		}
+	}
+
+	return nil
+}
+
+// mergeYAMLSequences appends override items to base, except that butane entries
+// identifying the same file, directory, unit or user (by path or name) are merged
+// so overlays can adjust an entry instead of duplicating it
+func (r *Renderer) mergeYAMLSequences(base, override *yaml.Node) error {
+	for _, item := range override.Content {
+		if existing := findKeyedItem(base, item); existing != nil {
+			if err := r.mergeYAMLNodes(existing, item); err != nil {
+				return err
+			}
+			continue
 		}
-		base.Content = append(base.Content, keyNode, value)
+		base.Content = append(base.Content, item)
 	}
+	return nil
+}
 
+// findKeyedItem returns the mapping in seq with the same path or name as item
+func findKeyedItem(seq, item *yaml.Node) *yaml.Node {
+	for _, key := range []string{"path", "name"} {
+		value := mappingValue(item, key)
+		if value == "" {
+			continue
+		}
+		for _, candidate := range seq.Content {
+			if mappingValue(candidate, key) == value {
+				return candidate
+			}
+		}
+		return nil
+	}
 	return nil
 }
 
+func mappingValue(node *yaml.Node, key string) string {
+	if node.Kind != yaml.MappingNode {
+		return ""
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key && node.Content[i+1].Kind == yaml.ScalarNode {
+			return node.Content[i+1].Value
+		}
+	}
+	return ""
+}
+
 // mergeYAMLMaps deeply merges two YAML maps, with values from 'override' taking precedence
 func (r *Renderer) mergeYAMLMaps(base, override map[string]interface{}) map[string]interface{} {
 	result := make(map[string]interface{})
@@ -355,21 +818,19 @@ func (r *Renderer) mergeYAMLMaps(base, override map[string]interface{}) map[stri
 	return result
 }
 
-func (r *Renderer) renderPureYAMLTemplate(templatePath string, data TemplateData) (string, error) {
+func (r *Renderer) renderPureYAMLTemplate(templatePath string, data TemplateData, sources *SourceMap) (string, error) {
 	content, err := os.ReadFile(templatePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to read template %s: %w", templatePath, err)
 	}
 
 	// Use Go template engine instead of string replacement
-	return r.renderTemplateString(string(content), data)
-}
-
-func (r *Renderer) convertQuotedOctalToOctal(yamlContent string) string {
-	// Convert quoted octal strings like mode: "0755" back to unquoted octal notation mode: 0755
-	// This preserves the octal representation in the final YAML output
-	re := regexp.MustCompile(`mode:\s+"(0[0-7]{3})"`)
-	return re.ReplaceAllString(yamlContent, "mode: $1")
+	rendered, lines, err := r.renderMappedTemplate(templatePath, string(content), data)
+	if err != nil {
+		return "", err
+	}
+	sources.addTemplate(templatePath, string(content), lines)
+	return rendered, nil
 }
 
 func (r *Renderer) mergeButane(base, overlay string) (string, error) {
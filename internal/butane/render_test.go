@@ -1,12 +1,19 @@
 package butane
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/andreweick/iago/internal/machine"
 	"github.com/andreweick/iago/internal/workload"
+	"github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -340,24 +347,24 @@ func TestRenderer_TemplateErrors(t *testing.T) {
 	}{
 		{
 			name:            "invalid template syntax",
-			templateContent: `variant: fcos\nversion: 1.5.0\nstorage:\n  files:\n    - path: /test\n      contents:\n        inline: "{{ .InvalidSyntax"`,
+			templateContent: "variant: fcos\nversion: 1.5.0\nstorage:\n  files:\n    - path: /test\n      contents:\n        inline: \"{{ .InvalidSyntax\"",
 			expectError:     true,
 			errorSubstring:  "failed to parse template",
 		},
 		{
 			name:            "missing local file",
-			templateContent: `variant: fcos\nversion: 1.5.0\nstorage:\n  files:\n    - path: /test\n      contents:\n        local: nonexistent-script.sh`,
+			templateContent: "variant: fcos\nversion: 1.5.0\nstorage:\n  files:\n    - path: /test\n      contents:\n        local: nonexistent-script.sh",
 			expectError:     false, // This would be caught during butane-to-ignition conversion, not template rendering
 		},
 		{
 			name:            "template with undefined variable",
-			templateContent: `variant: fcos\nversion: 1.5.0\nstorage:\n  files:\n    - path: /test\n      contents:\n        inline: "{{ .NonExistent.Field }}"`,
+			templateContent: "variant: fcos\nversion: 1.5.0\nstorage:\n  files:\n    - path: /test\n      contents:\n        inline: \"{{ .NonExistent.Field }}\"",
 			expectError:     true,
 			errorSubstring:  "failed to execute template",
 		},
 		{
 			name:            "valid template",
-			templateContent: `variant: fcos\nversion: 1.5.0\nstorage:\n  files:\n    - path: /etc/hostname\n      contents:\n        inline: "{{ .Machine.Name }}"`,
+			templateContent: "variant: fcos\nversion: 1.5.0\nstorage:\n  files:\n    - path: /etc/hostname\n      contents:\n        inline: \"{{ .Machine.Name }}\"",
 			expectError:     false,
 		},
 	}
@@ -436,8 +443,667 @@ func TestRenderer_getTemplateFuncs(t *testing.T) {
 	funcs := renderer.getTemplateFuncs()
 
 	// Test that all expected functions are present
-	expectedFuncs := []string{"indent", "toYAML", "default", "hasKey", "list"}
+	expectedFuncs := []string{"indent", "toYAML", "default", "hasKey", "list", "fetchURL", "op", "secret"}
 	for _, funcName := range expectedFuncs {
 		assert.Contains(t, funcs, funcName, "Template function %s should be available", funcName)
 	}
 }
+
+func TestRenderer_fetchURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "vm.swappiness = 10")
+	}))
+	defer server.Close()
+
+	defaults := machine.Defaults{
+		Templates: machine.TemplatesConfig{
+			Fetch: machine.FetchConfig{
+				AllowedHosts: []string{"127.0.0.1"},
+				CacheDir:     t.TempDir(),
+			},
+		},
+	}
+	renderer := NewRenderer(defaults, &workload.Registry{})
+
+	result, err := renderer.renderTemplateString(`inline: "{{ fetchURL "`+server.URL+`/sysctl.conf" }}"`, TemplateData{})
+	require.NoError(t, err)
+	assert.Equal(t, `inline: "vm.swappiness = 10"`, result)
+
+	_, err = renderer.renderTemplateString(`{{ fetchURL "`+server.URL+`/sysctl.conf" "sha256:0000" }}`, TemplateData{})
+	assert.ErrorContains(t, err, "checksum mismatch")
+
+	_, err = renderer.renderTemplateString(`{{ fetchURL "https://example.com/sysctl.conf" }}`, TemplateData{})
+	assert.ErrorContains(t, err, "not in allowed_hosts")
+}
+
+func TestRenderer_onePassword(t *testing.T) {
+	renderer := NewRenderer(machine.Defaults{OnePassword: machine.OnePasswordConfig{Vault: "homelab"}}, &workload.Registry{})
+
+	_, err := renderer.renderTemplateString(`{{ op "wifi/password" }}`, TemplateData{})
+	assert.ErrorContains(t, err, "op is not configured", "nothing is registered without secret providers")
+
+	var resolved []string
+	renderer.resolveRef = func(_ context.Context, ref string) (string, error) {
+		resolved = append(resolved, ref)
+		return "hunter2", nil
+	}
+	result, err := renderer.renderTemplateString(`inline: {{ op "op://infra/wifi/password" | toYAML }}`, TemplateData{})
+	require.NoError(t, err)
+	assert.Equal(t, "inline: hunter2", result)
+	assert.Equal(t, []string{"op://infra/wifi/password"}, resolved)
+}
+
+func TestRenderer_secret(t *testing.T) {
+	t.Cleanup(func() { RegisterSecretResolvers(nil) })
+	RegisterSecretResolvers(func(defaults machine.Defaults) (func(context.Context, string) (string, error), func(context.Context, string) (string, error)) {
+		secret := func(_ context.Context, ref string) (string, error) {
+			if defaults.Secrets.Provider != "env" {
+				return "", fmt.Errorf("unknown [secrets] provider '%s'", defaults.Secrets.Provider)
+			}
+			return "hunter2", nil
+		}
+		return nil, secret
+	})
+
+	renderer := NewRenderer(machine.Defaults{Secrets: machine.SecretsConfig{Provider: "env"}}, &workload.Registry{})
+	result, err := renderer.renderTemplateString(`inline: {{ secret "IAGO_TEST_WIFI" }}`, TemplateData{})
+	require.NoError(t, err)
+	assert.Equal(t, "inline: hunter2", result)
+
+	renderer = NewRenderer(machine.Defaults{Secrets: machine.SecretsConfig{Provider: "keepass"}}, &workload.Registry{})
+	_, err = renderer.renderTemplateString(`{{ secret "wifi" }}`, TemplateData{})
+	assert.ErrorContains(t, err, "unknown [secrets] provider")
+}
+
+func TestRenderer_MergesSnippets(t *testing.T) {
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "test-machine")
+	require.NoError(t, os.MkdirAll(machineDir, 0755))
+
+	template := `variant: fcos
+version: 1.5.0
+storage:
+  files:
+    - path: /etc/hostname
+      mode: 0644
+      contents:
+        inline: "{{ .Machine.Name }}"
+systemd:
+  units:
+    - name: podman.service
+      enabled: true`
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte(template), 0644))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	defaults := machine.Defaults{User: machine.UserConfig{Username: "testuser"}}
+	renderer := NewRenderer(defaults, &workload.Registry{})
+
+	rendered, err := renderer.RenderMachine(machine.Config{
+		Name:     "test-machine",
+		FQDN:     "test-machine.example.com",
+		Snippets: []string{"autologin", "journald-limits"},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "--autologin testuser", "snippets should be rendered with machine data")
+	assert.Contains(t, rendered, "/etc/systemd/journald.conf.d/50-iago-limits.conf")
+	assert.Contains(t, rendered, "podman.service", "base units should be preserved")
+	assert.Contains(t, rendered, "mode: 0644")
+
+	_, err = renderer.RenderMachine(machine.Config{Name: "test-machine", Snippets: []string{"missing"}})
+	assert.ErrorContains(t, err, "unknown snippet 'missing'")
+}
+
+func TestRenderer_MergesHardeningControls(t *testing.T) {
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "test-machine")
+	require.NoError(t, os.MkdirAll(machineDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte("variant: fcos\nversion: 1.5.0\n"), 0644))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	defaults := machine.Defaults{
+		User:      machine.UserConfig{Username: "testuser"},
+		Admin:     machine.AdminConfig{Username: "testadmin"},
+		Hardening: machine.HardeningConfig{Presets: []string{"baseline"}},
+	}
+	renderer := NewRenderer(defaults, &workload.Registry{})
+
+	rendered, err := renderer.RenderMachine(machine.Config{Name: "test-machine", FQDN: "test-machine.example.com"})
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "/etc/ssh/sshd_config.d/10-iago-hardening.conf")
+	assert.Contains(t, rendered, "AllowUsers testuser testadmin")
+	assert.NotContains(t, rendered, "kernel_arguments", "baseline should not include kernel arguments")
+
+	rendered, err = renderer.RenderMachine(machine.Config{
+		Name:      "test-machine",
+		FQDN:      "test-machine.example.com",
+		Hardening: machine.HardeningConfig{Presets: []string{"fips"}},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "fips=1")
+	assert.NotContains(t, rendered, "sshd_config.d", "machine presets replace the defaults")
+}
+
+func TestRenderer_RootlessOverlay(t *testing.T) {
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "app")
+	require.NoError(t, os.MkdirAll(machineDir, 0755))
+
+	template := `variant: fcos
+version: 1.5.0
+storage:
+  directories:
+    - path: /var/lib/{{ .Machine.Name }}
+      mode: "0755"
+`
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte(template), 0644))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	renderer := NewRenderer(machine.Defaults{}, &workload.Registry{})
+	rendered, err := renderer.RenderMachine(machine.Config{
+		Name:           "app",
+		FQDN:           "app.example.com",
+		ContainerImage: "ghcr.io/example/app",
+		Rootless:       true,
+		RootlessUser:   "appuser",
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, rendered, "/var/lib/systemd/linger/appuser")
+	assert.Contains(t, rendered, "/var/home/appuser/.config/containers/systemd/app.container")
+	assert.Contains(t, rendered, "Image=ghcr.io/example/app:latest")
+	assert.Contains(t, rendered, "appuser:1000000000:65536")
+	assert.Contains(t, rendered, "d /var/lib/app 0750 appuser appuser -")
+	assert.Contains(t, rendered, `graphroot = "/var/home/appuser/.local/share/containers/storage"`)
+	assert.Equal(t, 1, strings.Count(rendered, "- path: /var/lib/app\n"), "overlay should adjust the existing directory, not duplicate it")
+
+	_, report, err := config.TranslateBytes([]byte(rendered), common.TranslateBytesOptions{})
+	require.NoError(t, err, report.String())
+}
+
+func TestRenderer_PodmanAutoUpdate(t *testing.T) {
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "app")
+	require.NoError(t, os.MkdirAll(machineDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte("variant: fcos\nversion: 1.5.0\n"), 0644))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	renderer := NewRenderer(machine.Defaults{Bootc: machine.BootcConfig{UpdateTime: "02:00:00"}}, &workload.Registry{})
+	app := machine.Config{Name: "app", FQDN: "app.example.com", UpdateMechanism: machine.UpdateMechanismPodman}
+
+	rendered, err := renderer.RenderMachine(app)
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "/etc/iago/containers/app.autoupdate")
+	assert.Contains(t, rendered, "name: podman-auto-update.timer")
+	assert.Contains(t, rendered, "OnCalendar=*-*-* 02:00:00")
+	_, report, err := config.TranslateBytes([]byte(rendered), common.TranslateBytesOptions{})
+	require.NoError(t, err, report.String())
+
+	app.Rootless = true
+	rendered, err = renderer.RenderMachine(app)
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "AutoUpdate=registry")
+	assert.Contains(t, rendered, "/var/home/app/.config/systemd/user/timers.target.wants/podman-auto-update.timer")
+	assert.NotContains(t, rendered, "name: podman-auto-update.timer", "rootless containers use the user's timer")
+	_, report, err = config.TranslateBytes([]byte(rendered), common.TranslateBytesOptions{})
+	require.NoError(t, err, report.String())
+
+	app.UpdateMechanism = "watchtower"
+	_, err = renderer.RenderMachine(app)
+	assert.ErrorContains(t, err, "unknown update_mechanism 'watchtower'")
+}
+
+func TestMergeYAMLSequences_KeyedItems(t *testing.T) {
+	renderer := NewRenderer(machine.Defaults{}, &workload.Registry{})
+
+	merged, err := renderer.mergeFragments(`systemd:
+  units:
+    - name: a.service
+      enabled: true
+    - name: b.service
+`, []fragment{{kind: "snippet", name: "test", content: `systemd:
+  units:
+    - name: a.service
+      dropins:
+        - name: override.conf
+          contents: "[Service]"
+    - name: c.service
+`}}, TemplateData{}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, strings.Count(merged, "name: a.service"))
+	assert.Contains(t, merged, "override.conf")
+	assert.Contains(t, merged, "enabled: true")
+	assert.Contains(t, merged, "name: c.service")
+}
+
+func TestRenderer_DefaultsResolver(t *testing.T) {
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "edge-01")
+	require.NoError(t, os.MkdirAll(machineDir, 0755))
+	template := `variant: fcos
+version: 1.5.0
+passwd:
+  users:
+    - name: {{ .User.Username }}`
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte(template), 0644))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	renderer := NewRenderer(machine.Defaults{User: machine.UserConfig{Username: "core"}}, &workload.Registry{})
+	renderer.SetDefaultsResolver(func(m machine.Config) (machine.Defaults, error) {
+		return machine.Defaults{User: machine.UserConfig{Username: "edge-" + m.Tags[0]}}, nil
+	})
+
+	rendered, err := renderer.RenderMachine(machine.Config{Name: "edge-01", Tags: []string{"dmz"}})
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "name: edge-dmz")
+}
+
+func TestRenderer_AccountOptions(t *testing.T) {
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "app")
+	require.NoError(t, os.MkdirAll(machineDir, 0755))
+	template := `variant: fcos
+version: 1.5.0
+passwd:
+  users:
+    - name: "{{ .User.Username }}"
+      password_hash: "{{ .User.PasswordHash }}"
+    - name: "{{ .Admin.Username }}"
+      password_hash: "{{ .Admin.PasswordHash }}"
+storage:
+  files:
+    - path: /etc/hostname
+      contents:
+        inline: app
+`
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte(template), 0644))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	renderer := NewRenderer(machine.Defaults{
+		User:  machine.UserConfig{Username: "core", PasswordHash: "$6$user", SSHOnly: true, Sudo: machine.SudoNoPassword, Shell: "/bin/zsh"},
+		Admin: machine.AdminConfig{Username: "admin", PasswordHash: "$6$admin", Sudo: machine.SudoPassword, Expires: "2027-01-31"},
+	}, &workload.Registry{})
+	rendered, err := renderer.RenderMachine(machine.Config{Name: "app"})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, strings.Count(rendered, "name: core"), "options adjust the existing user")
+	assert.Contains(t, rendered, `password_hash: '!'`, "ssh_only locks the password")
+	assert.Contains(t, rendered, "shell: /bin/zsh")
+	assert.Contains(t, rendered, "$6$admin", "admin keeps its password")
+	assert.Contains(t, rendered, "/etc/sudoers.d/iago-core")
+	assert.Contains(t, rendered, "core ALL=(ALL) NOPASSWD: ALL")
+	assert.Contains(t, rendered, "admin ALL=(ALL) ALL")
+	assert.Contains(t, rendered, "ExecStart=/usr/bin/chage --expiredate 2027-01-31 admin")
+	assert.Contains(t, rendered, "path: /etc/hostname", "existing files are kept")
+
+	_, report, err := config.TranslateBytes([]byte(rendered), common.TranslateBytesOptions{})
+	require.NoError(t, err, report.String())
+
+	plain := NewRenderer(machine.Defaults{User: machine.UserConfig{Username: "core"}, Admin: machine.AdminConfig{Username: "admin"}}, &workload.Registry{})
+	rendered, err = plain.RenderMachine(machine.Config{Name: "app"})
+	require.NoError(t, err)
+	assert.NotContains(t, rendered, "sudoers.d", "no overlay without options")
+}
+
+func TestRenderer_Units(t *testing.T) {
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "app")
+	require.NoError(t, os.MkdirAll(filepath.Join(machineDir, "units"), 0755))
+	template := `variant: fcos
+version: 1.5.0
+systemd:
+  units:
+    - name: app.service
+      enabled: true
+      contents: |
+        [Service]
+        ExecStart=/usr/bin/podman run app
+`
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte(template), 0644))
+	backup := "[Service]\nType=oneshot\nExecStart=/usr/local/bin/backup\n"
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "units", "backup.service"), []byte(backup), 0644))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	enabled, disabled := true, false
+	renderer := NewRenderer(machine.Defaults{}, &workload.Registry{})
+	rendered, err := renderer.RenderMachine(machine.Config{Name: "app", Units: []machine.UnitConfig{
+		{Name: "backup.service", ContentsFile: "units/backup.service"},
+		{Name: "backup.timer", Enabled: &enabled, Contents: "[Timer]\nOnCalendar=daily\n\n[Install]\nWantedBy=timers.target\n"},
+		{Name: "app.service", Dropins: []machine.DropinConfig{{Name: "10-env.conf", Contents: "[Service]\nEnvironment=FORMAT={{.Name}}\n"}}},
+		{Name: "zincati.service", Enabled: &disabled},
+	}})
+	require.NoError(t, err)
+	assert.Regexp(t, `name: zincati.service\s+enabled: false`, rendered, "units can be disabled explicitly")
+
+	assert.Equal(t, 1, strings.Count(rendered, "name: app.service"), "units merge by name")
+	assert.Contains(t, rendered, "ExecStart=/usr/bin/podman run app", "template contents are kept")
+	assert.Contains(t, rendered, "name: 10-env.conf")
+	assert.Contains(t, rendered, "Environment=FORMAT={{.Name}}", "unit contents aren't templated")
+	assert.Contains(t, rendered, "ExecStart=/usr/local/bin/backup")
+	assert.Contains(t, rendered, "OnCalendar=daily")
+
+	_, report, err := config.TranslateBytes([]byte(rendered), common.TranslateBytesOptions{})
+	require.NoError(t, err, report.String())
+
+	for _, tc := range []struct {
+		unit machine.UnitConfig
+		err  string
+	}{
+		{machine.UnitConfig{Name: "backup"}, "needs a systemd unit suffix"},
+		{machine.UnitConfig{Name: "a.service", Contents: "x", ContentsFile: "units/backup.service"}, "sets both contents and contents_file"},
+		{machine.UnitConfig{Name: "a.service", ContentsFile: "../../etc/passwd"}, "must be a relative path inside"},
+		{machine.UnitConfig{Name: "a.service", ContentsFile: "units/missing.service"}, "failed to read contents_file"},
+		{machine.UnitConfig{Name: "a.service", Dropins: []machine.DropinConfig{{Name: "10-env"}}}, "must end in .conf"},
+	} {
+		_, err := renderer.RenderMachine(machine.Config{Name: "app", Units: []machine.UnitConfig{tc.unit}})
+		assert.ErrorContains(t, err, tc.err)
+	}
+}
+
+func TestRenderer_StaticNetwork(t *testing.T) {
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "vps")
+	require.NoError(t, os.MkdirAll(machineDir, 0755))
+	template := `variant: fcos
+version: 1.5.0
+storage:
+  files:
+    - path: /etc/NetworkManager/system-connections/eth0.nmconnection
+      mode: 0600
+      contents:
+        inline: |
+          [ipv4]
+          method=auto
+`
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte(template), 0644))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	defaults := machine.Defaults{Network: machine.NetworkConfig{
+		DefaultNetworkInterface: "eth0",
+		DNSServers:              []string{"185.12.64.1", "2a01:4ff:ff00::add:1"},
+	}}
+	renderer := NewRenderer(defaults, &workload.Registry{})
+	rendered, err := renderer.RenderMachine(machine.Config{
+		Name:         "vps",
+		MACAddress:   "02:05:56:00:00:01",
+		IPAddress:    "203.0.113.10",
+		PrefixLength: 32,
+		Gateway:      "172.31.1.1",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, strings.Count(rendered, "eth0.nmconnection"), "the static keyfile replaces the template's DHCP one")
+	assert.Contains(t, rendered, "interface-name=eth0")
+	assert.Contains(t, rendered, "[ethernet]\n          mac-address=02:05:56:00:00:01\n")
+	assert.Contains(t, rendered, "[ipv4]\n          method=manual\n          address1=203.0.113.10/32,172.31.1.1\n          dns=185.12.64.1;\n")
+	assert.Contains(t, rendered, "[ipv6]\n          method=auto\n          dns=2a01:4ff:ff00::add:1;\n")
+
+	_, report, err := config.TranslateBytes([]byte(rendered), common.TranslateBytesOptions{})
+	require.NoError(t, err, report.String())
+
+	rendered, err = renderer.RenderMachine(machine.Config{
+		Name:             "vps",
+		NetworkInterface: "ens3",
+		IPAddress:        "2001:db8::10",
+		PrefixLength:     64,
+		Gateway:          "fe80::1",
+		DNSServers:       []string{"2001:db8::53"},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "ens3.nmconnection")
+	assert.Contains(t, rendered, "[ipv4]\n          method=auto\n")
+	assert.Contains(t, rendered, "address1=2001:db8::10/64,fe80::1\n          dns=2001:db8::53;\n")
+
+	rendered, err = renderer.RenderMachine(machine.Config{Name: "vps", IPAddress: "10.0.0.5"})
+	require.NoError(t, err)
+	assert.NotContains(t, rendered, "method=manual", "ip_address alone keeps DHCP")
+
+	_, err = renderer.RenderMachine(machine.Config{Name: "vps", IPAddress: "10.0.0.5", PrefixLength: 33})
+	assert.ErrorContains(t, err, "prefix_length between 1 and 32")
+}
+
+func TestRenderer_Vars(t *testing.T) {
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "immich")
+	require.NoError(t, os.MkdirAll(machineDir, 0755))
+	template := `variant: fcos
+version: 1.5.0
+storage:
+  files:
+    - path: /etc/immich.env
+      contents:
+        inline: |
+          PORT={{ .Vars.port }}
+          UPLOAD_LOCATION={{ .Vars.storage.uploads }}
+          PUBLIC={{ default "false" (index .Vars "public") }}
+`
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte(template), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "machine.toml"), []byte(`name = "immich"
+
+[vars]
+port = 2283
+
+[vars.storage]
+uploads = "/var/srv/immich"
+`), 0644))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	loader := machine.NewConfigLoader()
+	require.NoError(t, loader.LoadMachines())
+	m, err := loader.GetMachine("immich")
+	require.NoError(t, err)
+
+	rendered, err := NewRenderer(machine.Defaults{}, &workload.Registry{}).RenderMachine(m)
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "PORT=2283\n")
+	assert.Contains(t, rendered, "UPLOAD_LOCATION=/var/srv/immich\n")
+	assert.Contains(t, rendered, "PUBLIC=false\n", "unset vars fall back with default")
+}
+
+func TestRenderer_TraceAndErrorLocation(t *testing.T) {
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "web")
+	require.NoError(t, os.MkdirAll(machineDir, 0755))
+	template := `variant: fcos
+version: 1.5.0
+storage:
+  files:
+    - path: /etc/web.env
+      contents:
+        inline: {{ default "8080" .Vars.port | toYAML }}
+`
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte(template), 0644))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	var trace strings.Builder
+	renderer := NewRenderer(machine.Defaults{}, &workload.Registry{})
+	renderer.SetTrace(&trace)
+	_, err = renderer.RenderMachine(machine.Config{Name: "web", Snippets: []string{"zram-swap"}})
+	require.NoError(t, err)
+	assert.Contains(t, trace.String(), "template machines/web/butane.yaml.tmpl\n")
+	assert.Contains(t, trace.String(), "  default \"8080\" <nil>\n")
+	assert.Contains(t, trace.String(), "  toYAML \"8080\"\n")
+	assert.Contains(t, trace.String(), "snippet zram-swap\n")
+
+	broken := strings.Replace(template, "toYAML", "toYAML | indent", 1)
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte(broken), 0644))
+	trace.Reset()
+	_, err = renderer.RenderMachine(machine.Config{Name: "web"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "machines/web/butane.yaml.tmpl:7:", "errors point at the template's file and line")
+	assert.Contains(t, trace.String(), "  ✗ machines/web/butane.yaml.tmpl:7\n       7 |         inline: {{ default")
+}
+
+func TestRenderer_DebugData(t *testing.T) {
+	renderer := NewRenderer(machine.Defaults{
+		User:  machine.UserConfig{Username: "core", PasswordHash: "$y$j9T$secret"},
+		Admin: machine.AdminConfig{Username: "admin"},
+	}, &workload.Registry{})
+	data, err := renderer.DebugData(machine.Config{Name: "web", Vars: map[string]any{"port": 8080}})
+	require.NoError(t, err)
+	assert.Equal(t, Redacted, data.User.PasswordHash)
+	assert.Empty(t, data.Admin.PasswordHash, "empty hashes stay empty")
+	assert.Equal(t, Redacted, data.GeneratedSecrets.Password)
+
+	out, err := MarshalDebugData(data)
+	require.NoError(t, err)
+	assert.Contains(t, out, "User:\n    Username: core\n")
+	assert.Contains(t, out, "PasswordHash: <redacted>")
+	assert.Contains(t, out, "Vars:\n    port: 8080\n")
+	assert.Contains(t, out, "Machine:\n    Name: web\n")
+	assert.NotContains(t, out, "secret")
+}
+
+func TestRenderer_FrozenInputs(t *testing.T) {
+	motd := "welcome"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, motd)
+	}))
+	defer server.Close()
+
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "web")
+	require.NoError(t, os.MkdirAll(machineDir, 0755))
+	template := `variant: fcos
+version: 1.5.0
+storage:
+  files:
+    - path: /etc/motd
+      contents:
+        inline: "{{ fetchURL "` + server.URL + `/motd" }} {{ .GeneratedSecrets.Password }} {{ .ImageDigest }}"
+`
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte(template), 0644))
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	renderer := NewRenderer(machine.Defaults{
+		Templates: machine.TemplatesConfig{
+			Fetch: machine.FetchConfig{AllowedHosts: []string{"127.0.0.1"}, CacheDir: t.TempDir(), CacheTTL: "1ns"},
+		},
+	}, &workload.Registry{})
+	m := machine.Config{Name: "web"}
+
+	var recorded FrozenInputs
+	renderer.RecordInputs(func(name string, inputs FrozenInputs) {
+		assert.Equal(t, "web", name)
+		recorded = inputs
+	})
+	first, err := renderer.RenderMachine(m)
+	require.NoError(t, err)
+	renderer.RecordInputs(nil)
+	assert.NotEmpty(t, recorded.Password)
+	assert.Equal(t, map[string][]byte{server.URL + "/motd": []byte("welcome")}, recorded.Fetched)
+
+	recorded.ImageDigest = "sha256:abc"
+	renderer.UseFrozenInputs(func(name string) (FrozenInputs, error) {
+		return recorded, nil
+	})
+	motd = "changed"
+	frozen, err := renderer.RenderMachine(m)
+	require.NoError(t, err)
+	again, err := renderer.RenderMachine(m)
+	require.NoError(t, err)
+	assert.Equal(t, frozen, again, "frozen rendering is byte-identical")
+	assert.Equal(t, strings.Replace(first, recorded.Password+" ", recorded.Password+" sha256:abc", 1), frozen)
+	assert.Contains(t, frozen, "welcome "+recorded.Password)
+
+	renderer.UseFrozenInputs(func(name string) (FrozenInputs, error) {
+		return FrozenInputs{}, nil
+	})
+	_, err = renderer.RenderMachine(m)
+	assert.ErrorContains(t, err, "was not fetched by iago freeze")
+}
+
+func TestRenderer_AdminSSHKeys(t *testing.T) {
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "web")
+	require.NoError(t, os.MkdirAll(machineDir, 0755))
+	template := `variant: fcos
+version: 1.5.0
+passwd:
+  users:
+    - name: "{{ .Admin.Username }}"
+{{ if .AdminSSHKeys }}      ssh_authorized_keys:
+{{ range .AdminSSHKeys }}        - {{ . }}
+{{ end }}{{ end }}`
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte(template), 0644))
+	t.Chdir(tempDir)
+
+	renderer := NewRenderer(machine.Defaults{
+		Admin: machine.AdminConfig{Username: "breakglass", SSHKeys: []string{"ssh-ed25519 AAAA admin@vault"}},
+	}, &workload.Registry{})
+	rendered, err := renderer.RenderMachine(machine.Config{Name: "web"})
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "- name: \"breakglass\"\n      ssh_authorized_keys:\n        - ssh-ed25519 AAAA admin@vault\n")
+
+	renderer.UseFrozenInputs(func(name string) (FrozenInputs, error) {
+		return FrozenInputs{AdminSSHKeys: []string{"ssh-ed25519 BBBB admin@github"}}, nil
+	})
+	rendered, err = renderer.RenderMachine(machine.Config{Name: "web"})
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "        - ssh-ed25519 BBBB admin@github\n        - ssh-ed25519 AAAA admin@vault\n", "GitHub keys come first, then ssh_keys")
+}
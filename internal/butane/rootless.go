@@ -0,0 +1,114 @@
+package butane
+
+// rootlessOverlay runs the machine's container as a user-level quadlet owned by
+// an unprivileged user with linger enabled, instead of the root bootc@ service.
+// The user gets an explicit subordinate ID range, its own storage.conf, and
+// tmpfiles rules that keep its data directories owned by it across boots.
+// The marker file tells bootc-manager.sh and bootc-update.sh to leave the
+// container to the user's systemd instance.
+const rootlessOverlay = `passwd:
+  users:
+    - name: "{{ .Machine.RootlessUserName }}"
+      home_dir: /var/home/{{ .Machine.RootlessUserName }}
+      shell: /usr/sbin/nologin
+storage:
+  directories:
+    - path: /var/lib/{{ .Machine.Name }}
+      mode: 0750
+      user:
+        name: "{{ .Machine.RootlessUserName }}"
+      group:
+        name: "{{ .Machine.RootlessUserName }}"
+    - path: /var/log/{{ .Machine.Name }}
+      mode: 0750
+      user:
+        name: "{{ .Machine.RootlessUserName }}"
+      group:
+        name: "{{ .Machine.RootlessUserName }}"
+    - path: /var/home/{{ .Machine.RootlessUserName }}/.config
+      mode: 0700
+      user:
+        name: "{{ .Machine.RootlessUserName }}"
+      group:
+        name: "{{ .Machine.RootlessUserName }}"
+    - path: /var/home/{{ .Machine.RootlessUserName }}/.config/containers
+      mode: 0755
+      user:
+        name: "{{ .Machine.RootlessUserName }}"
+      group:
+        name: "{{ .Machine.RootlessUserName }}"
+    - path: /var/home/{{ .Machine.RootlessUserName }}/.config/containers/systemd
+      mode: 0755
+      user:
+        name: "{{ .Machine.RootlessUserName }}"
+      group:
+        name: "{{ .Machine.RootlessUserName }}"
+  files:
+    - path: /var/lib/systemd/linger/{{ .Machine.RootlessUserName }}
+      mode: 0644
+    - path: /etc/subuid
+      append:
+        - inline: |
+            {{ .Machine.RootlessSubIDRange }}
+    - path: /etc/subgid
+      append:
+        - inline: |
+            {{ .Machine.RootlessSubIDRange }}
+    - path: /etc/tmpfiles.d/iago-{{ .Machine.Name }}.conf
+      mode: 0644
+      contents:
+        inline: |
+          d /var/lib/{{ .Machine.Name }} 0750 {{ .Machine.RootlessUserName }} {{ .Machine.RootlessUserName }} -
+          d /var/log/{{ .Machine.Name }} 0750 {{ .Machine.RootlessUserName }} {{ .Machine.RootlessUserName }} -
+          d /var/home/{{ .Machine.RootlessUserName }}/.local 0700 {{ .Machine.RootlessUserName }} {{ .Machine.RootlessUserName }} -
+          d /var/home/{{ .Machine.RootlessUserName }}/.local/share 0755 {{ .Machine.RootlessUserName }} {{ .Machine.RootlessUserName }} -
+          d {{ .Machine.RootlessGraphRoot }} 0700 {{ .Machine.RootlessUserName }} {{ .Machine.RootlessUserName }} -
+    - path: /var/home/{{ .Machine.RootlessUserName }}/.config/containers/storage.conf
+      mode: 0644
+      user:
+        name: "{{ .Machine.RootlessUserName }}"
+      group:
+        name: "{{ .Machine.RootlessUserName }}"
+      contents:
+        inline: |
+          [storage]
+          driver = "{{ .Machine.RootlessStorageDriver }}"
+          graphroot = "{{ .Machine.RootlessGraphRoot }}"
+    - path: /etc/iago/containers/{{ .Machine.Name }}.rootless
+      mode: 0644
+      contents:
+        inline: |
+          ROOTLESS_USER={{ .Machine.RootlessUserName }}
+    - path: /var/home/{{ .Machine.RootlessUserName }}/.config/containers/systemd/{{ .Machine.Name }}.container
+      mode: 0644
+      user:
+        name: "{{ .Machine.RootlessUserName }}"
+      group:
+        name: "{{ .Machine.RootlessUserName }}"
+      contents:
+        inline: |
+          [Unit]
+          Description=Rootless container {{ .Machine.Name }}
+          Wants=network-online.target
+          After=network-online.target
+
+          [Container]
+          Image={{ .Machine.ContainerImage }}:{{ default "latest" .Machine.ContainerTag }}
+          ContainerName=bootc-{{ .Machine.Name }}
+          Environment=MACHINE_NAME={{ .Machine.Name }}
+          Volume=/var/lib/{{ .Machine.Name }}:/var/lib/{{ .Machine.Name }}:Z
+          Volume=/var/log/{{ .Machine.Name }}:/var/log/{{ .Machine.Name }}:Z
+          HealthCmd=/usr/local/bin/health.sh
+          HealthInterval=30s
+          HealthRetries=3
+          HealthStartPeriod=60s
+{{ if .Machine.UsesPodmanAutoUpdate }}          AutoUpdate=registry
+{{ end }}
+          [Service]
+          Restart=always
+          RestartSec=30
+          TimeoutStartSec=300
+
+          [Install]
+          WantedBy=default.target
+`
@@ -0,0 +1,206 @@
+package butane
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/andreweick/iago/internal/agecrypt"
+	"github.com/andreweick/iago/internal/machine"
+	"gopkg.in/yaml.v3"
+)
+
+// SecretsDir holds the generated secrets that [secrets] encryption = "age" encrypts
+const SecretsDir = "/etc/iago/secrets/"
+
+// SecretsEncryptionAge encrypts generated secrets to the machine's age recipients
+const SecretsEncryptionAge = "age"
+
+// checkAgeImage refuses an age_image that isn't pinned by digest: it runs with
+// the machine's age identity mounted, so a moved tag must not change it
+func checkAgeImage(image string) error {
+	if image != "" && !strings.Contains(image, "@sha256:") {
+		return fmt.Errorf("[secrets] age_image %s must be pinned by digest (image@sha256:...), as it reads the machine's age identity", image)
+	}
+	return nil
+}
+
+// ageSecretsOverlay decrypts the .age files under /etc/iago/secrets/ once the
+// machine's identity is installed (iago secrets install-key), at every boot
+// after that, and as soon as the key appears on a machine that's already up
+const ageSecretsOverlay = `storage:
+  directories:
+    - path: /etc/iago/age
+      mode: 0700
+  files:
+    - path: /usr/local/bin/iago-decrypt-secrets
+      mode: 0755
+      contents:
+        inline: |
+          #!/bin/bash
+          # Decrypts /etc/iago/secrets/*.age with this machine's age identity
+          set -euo pipefail
+          umask 077
+          identity=/etc/iago/age/identity.key
+          shopt -s nullglob
+          for encrypted in /etc/iago/secrets/*.age; do
+            plain="${encrypted%.age}"
+            if [ -e "$plain" ] && [ "$plain" -nt "$encrypted" ]; then
+              continue
+            fi
+            if command -v age >/dev/null; then
+              age --decrypt -i "$identity" -o "$plain.tmp" "$encrypted"
+{{- if .Secrets.AgeImage }}
+            else
+              podman run --rm --network=none --security-opt label=disable -v /etc/iago:/etc/iago:ro \
+                --entrypoint age "{{ .Secrets.AgeImage }}" --decrypt -i "$identity" "$encrypted" > "$plain.tmp"
+{{- else }}
+            else
+              echo "age is not installed; add it to the image or set [secrets] age_image" >&2
+              exit 1
+{{- end }}
+            fi
+            mv "$plain.tmp" "$plain"
+          done
+systemd:
+  units:
+    - name: iago-decrypt-secrets.service
+      contents: |
+        [Unit]
+        Description=Decrypt iago secrets with the machine's age identity
+        Wants=network-online.target
+        After=network-online.target
+        ConditionPathExists=/etc/iago/age/identity.key
+
+        [Service]
+        Type=oneshot
+        RemainAfterExit=yes
+        ExecStart=/usr/local/bin/iago-decrypt-secrets
+    - name: iago-decrypt-secrets.path
+      enabled: true
+      contents: |
+        [Unit]
+        Description=Decrypt iago secrets once the age identity is installed
+
+        [Path]
+        PathExists=/etc/iago/age/identity.key
+        Unit=iago-decrypt-secrets.service
+
+        [Install]
+        WantedBy=paths.target
+`
+
+// encryptSecrets replaces each inline file under SecretsDir with <path>.age,
+// encrypted to the machine's recipients and the fleet's
+func encryptSecrets(config *yaml.Node, m machine.Config, settings machine.SecretsConfig) error {
+	files := lookupNode(config, "storage", "files")
+	if files == nil || files.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	var recipients []*agecrypt.Recipient
+	for _, file := range files.Content {
+		path := mappingValue(file, "path")
+		if !strings.HasPrefix(path, SecretsDir) || strings.HasSuffix(path, ".age") {
+			continue
+		}
+		inline := lookupNode(file, "contents", "inline")
+		if inline == nil || inline.Kind != yaml.ScalarNode {
+			continue
+		}
+
+		if recipients == nil {
+			var err error
+			recipients, err = agecrypt.MachineRecipients(m.Directory(), settings.Recipients)
+			if err != nil {
+				return err
+			}
+			if len(recipients) == 0 {
+				return fmt.Errorf("[secrets] encryption is %q but %s lists no recipients; run iago secrets keygen %s", SecretsEncryptionAge, agecrypt.RecipientsPath(m.Directory()), m.Name)
+			}
+		}
+		encrypted, err := agecrypt.Encrypt([]byte(inline.Value), recipients...)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", path, err)
+		}
+		inline.Value = string(encrypted)
+		inline.Tag = "!!str"
+		inline.Style = yaml.LiteralStyle
+		setMappingValue(file, "path", path+".age")
+	}
+	return nil
+}
+
+// setMappingValue sets an existing scalar key of a mapping
+func setMappingValue(node *yaml.Node, key, value string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1].Value = value
+			return
+		}
+	}
+}
+
+// sealedSecrets holds the values op and secret resolve while a machine renders
+// with age encryption. Templates get a placeholder for each, and unseal puts
+// the values back only into files under SecretsDir, which are encrypted next,
+// so none is left in plaintext in the butane or ignition.
+type sealedSecrets struct {
+	values []string
+}
+
+// sealedPlaceholder matches the placeholders seal returns
+var sealedPlaceholder = regexp.MustCompile(`iago-sealed-secret-([0-9]+)`)
+
+// seal returns a placeholder for value, or value itself when s is nil
+func (s *sealedSecrets) seal(value string) string {
+	if s == nil {
+		return value
+	}
+	s.values = append(s.values, value)
+	return fmt.Sprintf("iago-sealed-secret-%d", len(s.values)-1)
+}
+
+// unseal replaces the placeholders in inline files under SecretsDir with
+// their values, and fails if one is used anywhere else
+func (s *sealedSecrets) unseal(config *yaml.Node) error {
+	if s == nil || len(s.values) == 0 {
+		return nil
+	}
+	if files := lookupNode(config, "storage", "files"); files != nil && files.Kind == yaml.SequenceNode {
+		for _, file := range files.Content {
+			if !strings.HasPrefix(mappingValue(file, "path"), SecretsDir) {
+				continue
+			}
+			inline := lookupNode(file, "contents", "inline")
+			if inline == nil || inline.Kind != yaml.ScalarNode {
+				continue
+			}
+			inline.Value = sealedPlaceholder.ReplaceAllStringFunc(inline.Value, func(placeholder string) string {
+				i, err := strconv.Atoi(sealedPlaceholder.FindStringSubmatch(placeholder)[1])
+				if err != nil || i >= len(s.values) {
+					return placeholder
+				}
+				return s.values[i]
+			})
+		}
+	}
+	if sealedNode(config) {
+		return fmt.Errorf("a value from op or secret is used outside %s, where [secrets] encryption = %q can't encrypt it; write it to a file under %s", SecretsDir, SecretsEncryptionAge, SecretsDir)
+	}
+	return nil
+}
+
+// sealedNode reports whether a scalar in node still holds a placeholder
+func sealedNode(node *yaml.Node) bool {
+	if node.Kind == yaml.ScalarNode {
+		return sealedPlaceholder.MatchString(node.Value)
+	}
+	for _, child := range node.Content {
+		if sealedNode(child) {
+			return true
+		}
+	}
+	return false
+}
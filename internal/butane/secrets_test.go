@@ -0,0 +1,174 @@
+package butane
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreweick/iago/internal/agecrypt"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/workload"
+	"github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenderer_EncryptsSecrets(t *testing.T) {
+	tempDir := t.TempDir()
+	machineDir := filepath.Join(tempDir, "machines", "web")
+	require.NoError(t, os.MkdirAll(machineDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(machineDir, "butane.yaml.tmpl"), []byte(`variant: fcos
+version: 1.5.0
+storage:
+  files:
+    - path: /etc/iago/secrets/web-password
+      mode: 0600
+      contents:
+        inline: {{ .GeneratedSecrets.Password }}
+    - path: /etc/hostname
+      contents:
+        inline: web
+`), 0644))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	defaults := machine.Defaults{Secrets: machine.SecretsConfig{Encryption: SecretsEncryptionAge}}
+	renderer := NewRenderer(defaults, &workload.Registry{})
+	_, err = renderer.RenderMachine(machine.Config{Name: "web"})
+	assert.ErrorContains(t, err, "iago secrets keygen web")
+
+	machineKey, err := agecrypt.GenerateIdentity()
+	require.NoError(t, err)
+	operatorKey, err := agecrypt.GenerateIdentity()
+	require.NoError(t, err)
+	_, err = agecrypt.AddRecipient(agecrypt.RecipientsPath(filepath.Join("machines", "web")), machineKey.Recipient().String(), "web")
+	require.NoError(t, err)
+	renderer = NewRenderer(machine.Defaults{Secrets: machine.SecretsConfig{
+		Encryption: SecretsEncryptionAge,
+		Recipients: []string{operatorKey.Recipient().String()},
+	}}, &workload.Registry{})
+
+	rendered, err := renderer.RenderMachine(machine.Config{Name: "web"})
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "path: /etc/iago/secrets/web-password.age")
+	assert.NotContains(t, rendered, "path: /etc/iago/secrets/web-password\n")
+	assert.Contains(t, rendered, "inline: web", "files outside /etc/iago/secrets stay plaintext")
+	assert.Contains(t, rendered, "iago-decrypt-secrets.path")
+	assert.NotContains(t, rendered, "apk add", "nothing is installed at boot")
+
+	_, report, err := config.TranslateBytes([]byte(rendered), common.TranslateBytesOptions{})
+	require.NoError(t, err, report.String())
+
+	var parsed struct {
+		Storage struct {
+			Files []struct {
+				Path     string
+				Contents struct{ Inline string }
+			}
+		}
+	}
+	require.NoError(t, yaml.Unmarshal([]byte(rendered), &parsed))
+	var ciphertext string
+	for _, f := range parsed.Storage.Files {
+		if f.Path == "/etc/iago/secrets/web-password.age" {
+			ciphertext = f.Contents.Inline
+		}
+	}
+	for _, identity := range []*agecrypt.Identity{machineKey, operatorKey} {
+		password, err := agecrypt.Decrypt([]byte(ciphertext), identity)
+		require.NoError(t, err)
+		assert.Len(t, string(password), 44, "the generated password decrypts")
+	}
+
+	const pinned = "ghcr.io/example/age@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	renderer = NewRenderer(machine.Defaults{Secrets: machine.SecretsConfig{Encryption: SecretsEncryptionAge, AgeImage: pinned}}, &workload.Registry{})
+	rendered, err = renderer.RenderMachine(machine.Config{Name: "web"})
+	require.NoError(t, err)
+	assert.Contains(t, rendered, `--entrypoint age "`+pinned+`"`)
+
+	renderer = NewRenderer(machine.Defaults{Secrets: machine.SecretsConfig{Encryption: SecretsEncryptionAge, AgeImage: "ghcr.io/example/age:1"}}, &workload.Registry{})
+	_, err = renderer.RenderMachine(machine.Config{Name: "web"})
+	assert.ErrorContains(t, err, "must be pinned by digest")
+
+	renderer = NewRenderer(machine.Defaults{Secrets: machine.SecretsConfig{Encryption: "sops"}}, &workload.Registry{})
+	_, err = renderer.RenderMachine(machine.Config{Name: "web"})
+	assert.ErrorContains(t, err, "unknown [secrets] encryption 'sops'")
+}
+
+func TestRenderer_SealsTemplateSecrets(t *testing.T) {
+	tempDir := t.TempDir()
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	machineKey, err := agecrypt.GenerateIdentity()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join("machines", "web"), 0755))
+	_, err = agecrypt.AddRecipient(agecrypt.RecipientsPath(filepath.Join("machines", "web")), machineKey.Recipient().String(), "web")
+	require.NoError(t, err)
+	writeTemplate := func(path string) {
+		require.NoError(t, os.WriteFile(filepath.Join("machines", "web", "butane.yaml.tmpl"), []byte(`variant: fcos
+version: 1.5.0
+storage:
+  files:
+    - path: `+path+`
+      mode: 0600
+      contents:
+        inline: "psk={{ op "wifi/password" }}"
+`), 0644))
+	}
+
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "command")
+	renderer := NewRenderer(machine.Defaults{Secrets: machine.SecretsConfig{Encryption: SecretsEncryptionAge}}, &workload.Registry{})
+	renderer.SetContext(ctx)
+	renderer.resolveRef = func(ctx context.Context, ref string) (string, error) {
+		assert.Equal(t, "command", ctx.Value(key{}), "lookups run with the command's context")
+		return "hunter2", nil
+	}
+
+	writeTemplate("/etc/iago/secrets/wifi")
+	rendered, err := renderer.RenderMachine(machine.Config{Name: "web"})
+	require.NoError(t, err)
+	assert.NotContains(t, rendered, "hunter2")
+	assert.NotContains(t, rendered, "iago-sealed-secret")
+	var parsed struct {
+		Storage struct {
+			Files []struct {
+				Path     string
+				Contents struct{ Inline string }
+			}
+		}
+	}
+	require.NoError(t, yaml.Unmarshal([]byte(rendered), &parsed))
+	var ciphertext string
+	for _, f := range parsed.Storage.Files {
+		if f.Path == "/etc/iago/secrets/wifi.age" {
+			ciphertext = f.Contents.Inline
+		}
+	}
+	plaintext, err := agecrypt.Decrypt([]byte(ciphertext), machineKey)
+	require.NoError(t, err)
+	assert.Equal(t, "psk=hunter2", string(plaintext))
+
+	writeTemplate("/etc/wpa_supplicant.conf")
+	_, err = renderer.RenderMachine(machine.Config{Name: "web"})
+	assert.ErrorContains(t, err, "used outside /etc/iago/secrets/")
+
+	renderer = NewRenderer(machine.Defaults{}, &workload.Registry{})
+	renderer.resolveRef = func(context.Context, string) (string, error) { return "hunter2", nil }
+	rendered, err = renderer.RenderMachine(machine.Config{Name: "web"})
+	require.NoError(t, err)
+	assert.Contains(t, rendered, "psk=hunter2", "without encryption values are inlined")
+}
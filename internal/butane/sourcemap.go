@@ -0,0 +1,293 @@
+package butane
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Markers around a template source line number, written into rendered
+// output ahead of each line and action and stripped again afterwards
+const (
+	lineMarkStart = '\x1e'
+	lineMarkEnd   = '\x1f'
+)
+
+// excerptContext is how many lines an excerpt shows either side of the source line
+const excerptContext = 2
+
+// yamlErrorLine finds the line in yaml.v3 errors, e.g. "yaml: line 12: ..."
+var yamlErrorLine = regexp.MustCompile(`\bline (\d+)\b`)
+
+// Source is where part of a machine's rendered butane came from
+type Source struct {
+	Name string // Template path, or e.g. "snippet motd" or "overlay network"
+	Line int    // Line in the template, 0 for generated overlays
+}
+
+func (s Source) String() string {
+	if s.Line == 0 {
+		return s.Name
+	}
+	return fmt.Sprintf("%s:%d", s.Name, s.Line)
+}
+
+// SourceMap traces a machine's rendered butane back to the template lines
+// that produced it, through template expansion and the merging of overlays,
+// snippets and hardening controls. A nil SourceMap locates nothing.
+type SourceMap struct {
+	base    string
+	content map[string]string
+	lines   map[string][]int // Template line of each rendered line, by template
+	origins map[*yaml.Node]Source
+	root    *yaml.Node
+}
+
+// newSourceMap returns an empty map; the first template added is the base
+// the others merge into
+func newSourceMap() *SourceMap {
+	return &SourceMap{
+		content: make(map[string]string),
+		lines:   make(map[string][]int),
+		origins: make(map[*yaml.Node]Source),
+	}
+}
+
+// addTemplate notes a template's content and the template line each line of
+// its output came from; lines is nil for content that isn't templated
+func (s *SourceMap) addTemplate(name, content string, lines []int) {
+	if s == nil {
+		return
+	}
+	if s.base == "" {
+		s.base = name
+	}
+	s.content[name] = content
+	s.lines[name] = lines
+}
+
+// source returns where line of the named template's output came from
+func (s *SourceMap) source(name string, line int) Source {
+	lines := s.lines[name]
+	if line < 1 || line > len(lines) {
+		return Source{Name: name}
+	}
+	return Source{Name: name, Line: lines[line-1]}
+}
+
+// record notes the named template as the origin of every node in doc, its
+// rendered output
+func (s *SourceMap) record(name string, doc *yaml.Node) {
+	if s == nil {
+		return
+	}
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		s.origins[node] = s.source(name, node.Line)
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	walk(doc)
+}
+
+// yamlError adds the template line and an excerpt to an error parsing the
+// named template's output
+func (s *SourceMap) yamlError(name string, err error) error {
+	if s == nil {
+		return err
+	}
+	match := yamlErrorLine.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	line, _ := strconv.Atoi(match[1])
+	src := s.source(name, line)
+	if src.Line == 0 {
+		return err
+	}
+	return fmt.Errorf("%w (%s)\n%s", err, src, s.Excerpt(src))
+}
+
+// Locate returns the source of the deepest entry on path in the merged butane,
+// given as in butane reports, e.g. ["storage", "files", 2, "mode"]
+func (s *SourceMap) Locate(path []any) (Source, bool) {
+	if s == nil || s.root == nil {
+		return Source{}, false
+	}
+	node := s.root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	found, ok := s.origins[node]
+	for _, step := range path {
+		var next *yaml.Node
+		switch step := step.(type) {
+		case string:
+			if node.Kind != yaml.MappingNode {
+				break
+			}
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == step {
+					// The key's line, rather than where a nested value starts
+					if src, known := s.origins[node.Content[i]]; known {
+						found, ok = src, true
+					}
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && step >= 0 && step < len(node.Content) {
+				next = node.Content[step]
+				if src, known := s.origins[next]; known {
+					found, ok = src, true
+				}
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return found, ok
+}
+
+// Excerpt returns the lines of src's template around src.Line, with that line
+// marked, or "" when there's no template line to show
+func (s *SourceMap) Excerpt(src Source) string {
+	if s == nil || src.Line == 0 {
+		return ""
+	}
+	content, ok := s.content[src.Name]
+	if !ok || content == "" {
+		return ""
+	}
+	lines := strings.Split(content, "\n")
+	if src.Line > len(lines) {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "  %s:", src)
+	for line := max(1, src.Line-excerptContext); line <= min(len(lines), src.Line+excerptContext); line++ {
+		marker := " "
+		if line == src.Line {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "\n  %s %4d | %s", marker, line, lines[line-1])
+	}
+	return b.String()
+}
+
+// markLines rewrites a parsed template so its output carries the source line
+// of each line of text and each action, to be removed by stripLineMarks
+func markLines(tmpl *template.Template, content string) {
+	lineAt := func(pos parse.Pos) int {
+		return 1 + strings.Count(content[:min(int(pos), len(content))], "\n")
+	}
+	mark := func(line int) *parse.TextNode {
+		return &parse.TextNode{NodeType: parse.NodeText, Text: []byte(string(lineMarkStart) + strconv.Itoa(line) + string(lineMarkEnd))}
+	}
+
+	var walk func(list *parse.ListNode)
+	walk = func(list *parse.ListNode) {
+		if list == nil {
+			return
+		}
+		nodes := make([]parse.Node, 0, 2*len(list.Nodes))
+		for _, node := range list.Nodes {
+			switch node := node.(type) {
+			case *parse.TextNode:
+				// Mark where the text starts and each line it begins
+				line := lineAt(node.Pos)
+				var text []byte
+				text = append(text, mark(line).Text...)
+				for _, c := range node.Text {
+					text = append(text, c)
+					if c == '\n' {
+						line++
+						text = append(text, mark(line).Text...)
+					}
+				}
+				node.Text = text
+			case *parse.ActionNode:
+				nodes = append(nodes, mark(node.Line))
+			case *parse.TemplateNode:
+				nodes = append(nodes, mark(node.Line))
+			case *parse.IfNode:
+				walk(node.List)
+				walk(node.ElseList)
+			case *parse.RangeNode:
+				walk(node.List)
+				walk(node.ElseList)
+			case *parse.WithNode:
+				walk(node.List)
+				walk(node.ElseList)
+			}
+			nodes = append(nodes, node)
+		}
+		list.Nodes = nodes
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			walk(t.Tree.Root)
+		}
+	}
+}
+
+// stripLineMarks removes the marks markLines adds from output and returns the
+// template line each output line came from: the first mark on the line, or
+// the last one before it for lines an action wrote
+func stripLineMarks(output string) (string, []int) {
+	var b strings.Builder
+	var lines []int
+	current, lineSource := 0, 0
+	for i := 0; i < len(output); i++ {
+		c := output[i]
+		if c == lineMarkStart {
+			if end := strings.IndexByte(output[i:], lineMarkEnd); end > 0 {
+				if n, err := strconv.Atoi(output[i+1 : i+end]); err == nil {
+					current = n
+					if lineSource == 0 {
+						lineSource = n
+					}
+					i += end
+					continue
+				}
+			}
+		}
+		b.WriteByte(c)
+		if c == '\n' {
+			if lineSource == 0 {
+				lineSource = current
+			}
+			lines = append(lines, lineSource)
+			lineSource = 0
+		}
+	}
+	if lineSource == 0 {
+		lineSource = current
+	}
+	return b.String(), append(lines, lineSource)
+}
+
+// baseName returns the name of the template the others merge into
+func (s *SourceMap) baseName() string {
+	if s == nil {
+		return ""
+	}
+	return s.base
+}
+
+// setRoot sets the merged document Locate walks
+func (s *SourceMap) setRoot(doc *yaml.Node) {
+	if s != nil {
+		s.root = doc
+	}
+}
@@ -0,0 +1,85 @@
+package butane
+
+import (
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderMappedTemplate(t *testing.T) {
+	renderer := NewRenderer(machine.Defaults{}, nil)
+	template := `storage:
+  files:
+{{- range list "a" "b" }}
+    - path: /etc/{{ . }}
+{{- end }}
+  extra: {{ toYAML (list "x" "y") | indent 4 }}
+`
+	rendered, lines, err := renderer.renderMappedTemplate("t", template, TemplateData{})
+	require.NoError(t, err)
+	assert.Equal(t, "storage:\n  files:\n    - path: /etc/a\n    - path: /etc/b\n  extra:     - x\n    - \"y\"\n", rendered)
+	assert.Equal(t, []int{1, 2, 4, 4, 6, 6, 7}, lines)
+}
+
+func TestSourceMap(t *testing.T) {
+	renderer := NewRenderer(machine.Defaults{}, nil)
+	sources := newSourceMap()
+	template := `variant: fcos
+storage:
+  files:
+{{- range list "a" "b" "c" }}
+    - path: /etc/{{ . }}
+{{- end }}
+`
+	rendered, lines, err := renderer.renderMappedTemplate("machines/web/butane.yaml.tmpl", template, TemplateData{})
+	require.NoError(t, err)
+	sources.addTemplate("machines/web/butane.yaml.tmpl", template, lines)
+
+	snippet := fragment{kind: "snippet", name: "motd", content: "storage:\n  files:\n    - path: /etc/motd\n      mode: 0644\n"}
+	_, err = renderer.mergeFragments(rendered, []fragment{snippet}, TemplateData{}, sources)
+	require.NoError(t, err)
+
+	src, ok := sources.Locate([]any{"storage", "files", 2, "path"})
+	require.True(t, ok)
+	assert.Equal(t, "machines/web/butane.yaml.tmpl:5", src.String())
+	src, ok = sources.Locate([]any{"storage", "files", 3, "mode"})
+	require.True(t, ok)
+	assert.Equal(t, "snippet motd:4", src.String())
+	src, ok = sources.Locate([]any{"storage", "files", 9, "path"})
+	require.True(t, ok, "the deepest entry that exists")
+	assert.Equal(t, "machines/web/butane.yaml.tmpl:3", src.String())
+
+	assert.Equal(t, `  machines/web/butane.yaml.tmpl:5:
+       3 |   files:
+       4 | {{- range list "a" "b" "c" }}
+  >    5 |     - path: /etc/{{ . }}
+       6 | {{- end }}
+       7 | `, sources.Excerpt(Source{Name: "machines/web/butane.yaml.tmpl", Line: 5}))
+
+	var nilSources *SourceMap
+	_, ok = nilSources.Locate([]any{"storage"})
+	assert.False(t, ok)
+}
+
+func TestSourceMap_YAMLError(t *testing.T) {
+	renderer := NewRenderer(machine.Defaults{}, nil)
+	sources := newSourceMap()
+	template := `variant: fcos
+storage:
+  files:
+{{- range list "a" "b" "c" }}
+    - path: /etc/{{ . }}
+{{- end }}
+    - path: /etc/bad: value
+`
+	rendered, lines, err := renderer.renderMappedTemplate("machines/web/butane.yaml.tmpl", template, TemplateData{})
+	require.NoError(t, err)
+	sources.addTemplate("machines/web/butane.yaml.tmpl", template, lines)
+
+	_, err = renderer.mergeFragments(rendered, nil, TemplateData{}, sources)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "yaml: line 7: mapping values are not allowed in this context (machines/web/butane.yaml.tmpl:7)")
+	assert.Contains(t, err.Error(), "  >    7 |     - path: /etc/bad: value")
+}
@@ -0,0 +1,120 @@
+package butane
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// fcosSpecs pairs each fcos Butane version with the Ignition spec it
+// translates to, oldest first
+var fcosSpecs = []struct {
+	butane   string
+	ignition string
+}{
+	{"1.0.0", "3.0.0"},
+	{"1.1.0", "3.1.0"},
+	{"1.2.0", "3.2.0"},
+	{"1.3.0", "3.2.0"},
+	{"1.4.0", "3.3.0"},
+	{"1.5.0", "3.4.0"},
+	{"1.6.0", "3.5.0"},
+}
+
+var (
+	variantLine = regexp.MustCompile(`(?m)^variant:[ \t]*["']?([^"'\s]*)["']?[ \t]*$`)
+	versionLine = regexp.MustCompile(`(?m)^version:[ \t]*["']?([^"'\s]*)["']?[ \t]*$`)
+)
+
+// IgnitionVersions lists the Ignition specs a machine can target with
+// ignition_version
+func IgnitionVersions() []string {
+	var versions []string
+	for i, spec := range fcosSpecs {
+		if i == 0 || spec.ignition != fcosSpecs[i-1].ignition {
+			versions = append(versions, spec.ignition)
+		}
+	}
+	return versions
+}
+
+// ValidateIgnitionVersion checks that ignitionVersion is a spec iago can
+// translate to
+func ValidateIgnitionVersion(ignitionVersion string) error {
+	if specIndex(ignitionVersion) < 0 {
+		return fmt.Errorf("unsupported ignition_version %q (supported: %s)", ignitionVersion, strings.Join(IgnitionVersions(), ", "))
+	}
+	return nil
+}
+
+// TemplateIgnitionVersion returns the Ignition spec a butane template's fcos
+// version translates to. Only the variant and version lines are read, so
+// templates can be checked before they're rendered.
+func TemplateIgnitionVersion(butaneYAML string) (string, error) {
+	variant := variantLine.FindStringSubmatch(butaneYAML)
+	if variant == nil || variant[1] != "fcos" {
+		return "", fmt.Errorf("butane config has no variant: fcos")
+	}
+	version := versionLine.FindStringSubmatch(butaneYAML)
+	if version == nil {
+		return "", fmt.Errorf("butane config has no version")
+	}
+	var known []string
+	for _, spec := range fcosSpecs {
+		if spec.butane == version[1] {
+			return spec.ignition, nil
+		}
+		known = append(known, spec.butane)
+	}
+	return "", fmt.Errorf("fcos version %s isn't supported by this iago (supported: %s)", version[1], strings.Join(known, ", "))
+}
+
+// TargetIgnitionVersion rewrites a rendered fcos Butane config's version so
+// it translates to ignitionVersion, for machines whose OS rejects the spec
+// the template targets. Older Butane versions accept a subset of newer ones,
+// so fields the older spec lacks surface as unused keys when translating.
+// Raising the version is refused: the template should do that itself.
+func TargetIgnitionVersion(butaneYAML, ignitionVersion string) (string, error) {
+	target := specIndex(ignitionVersion)
+	if target < 0 {
+		return "", ValidateIgnitionVersion(ignitionVersion)
+	}
+
+	variant := variantLine.FindStringSubmatch(butaneYAML)
+	if variant == nil || variant[1] != "fcos" {
+		return "", fmt.Errorf("ignition_version needs a butane config with variant: fcos")
+	}
+	version := versionLine.FindStringSubmatch(butaneYAML)
+	if version == nil {
+		return "", fmt.Errorf("butane config has no version")
+	}
+	current := -1
+	for i, spec := range fcosSpecs {
+		if spec.butane == version[1] {
+			current = i
+		}
+	}
+	if current < 0 {
+		return "", fmt.Errorf("butane version %s can't be translated to ignition spec %s", version[1], ignitionVersion)
+	}
+
+	if fcosSpecs[current].ignition == ignitionVersion {
+		return butaneYAML, nil
+	}
+	if target > current {
+		return "", fmt.Errorf("the butane template targets ignition spec %s (fcos %s); raise its version to target %s", fcosSpecs[current].ignition, version[1], ignitionVersion)
+	}
+	return versionLine.ReplaceAllLiteralString(butaneYAML, "version: "+fcosSpecs[target].butane), nil
+}
+
+// specIndex returns the newest fcos version that translates to
+// ignitionVersion, or -1
+func specIndex(ignitionVersion string) int {
+	index := -1
+	for i, spec := range fcosSpecs {
+		if spec.ignition == ignitionVersion {
+			index = i
+		}
+	}
+	return index
+}
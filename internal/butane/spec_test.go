@@ -0,0 +1,42 @@
+package butane
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTargetIgnitionVersion(t *testing.T) {
+	config := "variant: fcos\nversion: 1.5.0\nstorage:\n  files:\n    - path: /etc/motd\n      contents:\n        inline: |\n          version: 2\n"
+
+	downgraded, err := TargetIgnitionVersion(config, "3.2.0")
+	require.NoError(t, err)
+	assert.Equal(t, "variant: fcos\nversion: 1.3.0\nstorage:\n  files:\n    - path: /etc/motd\n      contents:\n        inline: |\n          version: 2\n", downgraded, "only the top-level version changes")
+
+	unchanged, err := TargetIgnitionVersion(config, "3.4.0")
+	require.NoError(t, err)
+	assert.Equal(t, config, unchanged)
+
+	_, err = TargetIgnitionVersion(config, "3.5.0")
+	assert.ErrorContains(t, err, "raise its version")
+
+	_, err = TargetIgnitionVersion(config, "2.2.0")
+	assert.ErrorContains(t, err, "supported: 3.0.0, 3.1.0, 3.2.0, 3.3.0, 3.4.0, 3.5.0")
+
+	_, err = TargetIgnitionVersion("variant: flatcar\nversion: 1.1.0\n", "3.3.0")
+	assert.ErrorContains(t, err, "variant: fcos")
+}
+
+func TestTemplateIgnitionVersion(t *testing.T) {
+	version, err := TemplateIgnitionVersion("variant: fcos\nversion: 1.5.0\npasswd:\n  users:\n    - name: {{ .User.Username }}\n")
+	require.NoError(t, err)
+	assert.Equal(t, "3.4.0", version)
+
+	_, err = TemplateIgnitionVersion("variant: fcos\nversion: 1.9.0\n")
+	assert.ErrorContains(t, err, "fcos version 1.9.0 isn't supported by this iago (supported: 1.0.0,")
+	_, err = TemplateIgnitionVersion("variant: flatcar\nversion: 1.0.0\n")
+	assert.ErrorContains(t, err, "no variant: fcos")
+	_, err = TemplateIgnitionVersion("variant: fcos\n")
+	assert.ErrorContains(t, err, "no version")
+}
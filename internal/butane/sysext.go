@@ -0,0 +1,154 @@
+package butane
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/sysext"
+	"gopkg.in/yaml.v3"
+)
+
+// sysextPullScript fetches the machine's images when SHA256SUMS lists new
+// ones, installs them under the name their extension-release file has and
+// refreshes the merged hierarchies. An image SHA256SUMS no longer lists is
+// removed, so the list is only trusted when its signature checks out against
+// the certificate. %[1]s is the machine's image URL, %[2]s the image name,
+// %[3]s the certificate and %[4]s the image policy.
+const sysextPullScript = `#!/bin/bash
+# Managed by iago from [sysext] in defaults.toml
+set -euo pipefail
+
+url=%[1]s
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+curl -fsSL --retry 3 -o "$work/SHA256SUMS" "$url/SHA256SUMS"
+curl -fsSL --retry 3 -o "$work/SHA256SUMS.sig" "$url/SHA256SUMS.sig"
+openssl x509 -pubkey -noout -in %[3]s > "$work/key.pem"
+openssl dgst -sha256 -verify "$work/key.pem" -signature "$work/SHA256SUMS.sig" "$work/SHA256SUMS" > /dev/null
+changed=()
+for kind in sysext confext; do
+  dir=/var/lib/extensions
+  if [ "$kind" = confext ]; then
+    dir=/var/lib/confexts
+  fi
+  target="$dir/%[2]s.raw"
+  sum=$(awk -v image="$kind.raw" '$2 == image { print $1 }' "$work/SHA256SUMS")
+  if [ -z "$sum" ]; then
+    if [ -e "$target" ]; then
+      rm -f "$target"
+      changed+=("$kind")
+    fi
+    continue
+  fi
+  if [ -e "$target" ] && echo "$sum  $target" | sha256sum --quiet --check -; then
+    continue
+  fi
+  curl -fsSL --retry 3 -o "$work/$kind.raw" "$url/$kind.raw"
+  echo "$sum  $work/$kind.raw" | sha256sum --quiet --check -
+  install -D -m 0644 "$work/$kind.raw" "$target"
+  changed+=("$kind")
+done
+
+for kind in "${changed[@]}"; do
+  echo "Refreshing $kind"
+  systemd-$kind refresh --image-policy=%[4]s
+done
+`
+
+// sysextOverlay installs the timer that pulls the machine's extension images
+// from [sysext] url, and the certificate hosts verify them with. /etc stays
+// writable while a confext is merged; changes land in
+// /var/lib/extensions.mutable/etc. It returns "" when no url is set.
+func sysextOverlay(m machine.Config, cfg machine.SysextConfig) (string, error) {
+	if cfg.URL == "" {
+		return "", nil
+	}
+	if cfg.Certificate == "" {
+		return "", fmt.Errorf("[sysext] url needs certificate: hosts only merge images and SHA256SUMS signed with its key")
+	}
+	interval := cfg.Interval
+	if interval == "" {
+		interval = "15min"
+	}
+
+	type file struct {
+		Path     string            `yaml:"path"`
+		Mode     int               `yaml:"mode"`
+		Contents map[string]string `yaml:"contents"`
+	}
+	type directory struct {
+		Path string `yaml:"path"`
+		Mode int    `yaml:"mode"`
+	}
+	type unit struct {
+		Name     string `yaml:"name"`
+		Enabled  *bool  `yaml:"enabled,omitempty"`
+		Contents string `yaml:"contents"`
+	}
+
+	url := strings.TrimSuffix(cfg.URL, "/") + "/" + m.Name
+	certificate, err := os.ReadFile(cfg.Certificate)
+	if err != nil {
+		return "", fmt.Errorf("failed to read [sysext] certificate: %w", err)
+	}
+	script := fmt.Sprintf(sysextPullScript, shellQuote(url), sysext.ImageName(m.Name), sysext.CertificatePath, sysext.ImagePolicy)
+	files := []file{
+		{Path: sysext.PullScriptPath, Mode: 0755, Contents: map[string]string{"inline": script}},
+		{Path: sysext.CertificatePath, Mode: 0644, Contents: map[string]string{"inline": string(certificate)}},
+	}
+
+	enabled := true
+	overlay, err := yaml.Marshal(map[string]any{
+		"storage": map[string]any{
+			"files": files,
+			"directories": []directory{
+				{Path: "/var/lib/extensions", Mode: 0755},
+				{Path: "/var/lib/confexts", Mode: 0755},
+				{Path: "/var/lib/extensions.mutable/etc", Mode: 0755},
+			},
+		},
+		"systemd": map[string]any{
+			"units": []unit{
+				{
+					Name: sysext.PullUnit + ".service",
+					Contents: fmt.Sprintf(`[Unit]
+Description=Pull and merge iago extension images
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=%s
+`, sysext.PullScriptPath),
+				},
+				{
+					Name:    sysext.PullUnit + ".timer",
+					Enabled: &enabled,
+					Contents: fmt.Sprintf(`[Unit]
+Description=Check for new iago extension images
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=%s
+RandomizedDelaySec=1min
+
+[Install]
+WantedBy=timers.target
+`, interval),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode sysext overlay: %w", err)
+	}
+	return string(overlay), nil
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
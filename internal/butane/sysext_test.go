@@ -0,0 +1,65 @@
+package butane
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/andreweick/iago/internal/sysext"
+	"github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSysextOverlay(t *testing.T) {
+	web := machine.Config{Name: "web"}
+	overlay, err := sysextOverlay(web, machine.SysextConfig{})
+	require.NoError(t, err)
+	assert.Empty(t, overlay)
+
+	certificate := filepath.Join(t.TempDir(), "sysext.crt")
+	require.NoError(t, os.WriteFile(certificate, []byte("-----BEGIN CERTIFICATE-----\n"), 0644))
+	overlay, err = sysextOverlay(web, machine.SysextConfig{URL: "https://images.lab/sysext/", Interval: "1h", Certificate: certificate})
+	require.NoError(t, err)
+
+	var parsed struct {
+		Storage struct {
+			Files []struct {
+				Path     string
+				Contents struct{ Inline string }
+			}
+		}
+		Systemd struct {
+			Units []struct {
+				Name     string
+				Enabled  bool
+				Contents string
+			}
+		}
+	}
+	require.NoError(t, yaml.Unmarshal([]byte(overlay), &parsed))
+	require.Len(t, parsed.Storage.Files, 2)
+	assert.Equal(t, sysext.PullScriptPath, parsed.Storage.Files[0].Path)
+	assert.Contains(t, parsed.Storage.Files[0].Contents.Inline, "url='https://images.lab/sysext/web'\n")
+	assert.Contains(t, parsed.Storage.Files[0].Contents.Inline, `target="$dir/iago-web.raw"`)
+	assert.Contains(t, parsed.Storage.Files[0].Contents.Inline, "openssl x509 -pubkey -noout -in /etc/verity.d/iago-sysext.crt")
+	assert.Contains(t, parsed.Storage.Files[0].Contents.Inline, `-signature "$work/SHA256SUMS.sig" "$work/SHA256SUMS"`)
+	assert.Contains(t, parsed.Storage.Files[0].Contents.Inline, "systemd-$kind refresh --image-policy=root=signed+absent:usr=signed+absent\n")
+	assert.Equal(t, sysext.CertificatePath, parsed.Storage.Files[1].Path)
+	require.Len(t, parsed.Systemd.Units, 2)
+	assert.Equal(t, "iago-sysext-pull.timer", parsed.Systemd.Units[1].Name)
+	assert.True(t, parsed.Systemd.Units[1].Enabled)
+	assert.Contains(t, parsed.Systemd.Units[1].Contents, "OnUnitActiveSec=1h\n")
+
+	_, report, err := config.TranslateBytes([]byte("variant: fcos\nversion: 1.5.0\n"+overlay), common.TranslateBytesOptions{})
+	require.NoError(t, err, report.String())
+
+	_, err = sysextOverlay(web, machine.SysextConfig{URL: "https://images.lab"})
+	assert.ErrorContains(t, err, "[sysext] url needs certificate")
+
+	_, err = sysextOverlay(web, machine.SysextConfig{URL: "https://images.lab", Certificate: "missing.crt"})
+	assert.ErrorContains(t, err, "failed to read [sysext] certificate")
+}
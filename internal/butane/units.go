@@ -0,0 +1,91 @@
+package butane
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andreweick/iago/internal/machine"
+	"gopkg.in/yaml.v3"
+)
+
+// unitSuffixes are the systemd unit types a [[units]] name may have
+var unitSuffixes = []string{".service", ".socket", ".timer", ".path", ".mount", ".automount", ".target", ".slice", ".swap"}
+
+// unitsOverlay renders a machine's [[units]] as a butane overlay. Units merge
+// by name, so a unit the template already defines gets the TOML's fields and
+// drop-ins on top. It returns "" when the machine declares no units.
+func unitsOverlay(machineDir string, units []machine.UnitConfig) (string, error) {
+	type dropin struct {
+		Name     string `yaml:"name"`
+		Contents string `yaml:"contents,omitempty"`
+	}
+	type unit struct {
+		Name     string   `yaml:"name"`
+		Enabled  *bool    `yaml:"enabled,omitempty"`
+		Contents string   `yaml:"contents,omitempty"`
+		Dropins  []dropin `yaml:"dropins,omitempty"`
+	}
+
+	if len(units) == 0 {
+		return "", nil
+	}
+
+	rendered := make([]unit, 0, len(units))
+	for _, u := range units {
+		if !hasUnitSuffix(u.Name) {
+			return "", fmt.Errorf("unit %q needs a systemd unit suffix such as .service or .timer", u.Name)
+		}
+		contents, err := unitContents(machineDir, u.Name, u.Contents, u.ContentsFile)
+		if err != nil {
+			return "", err
+		}
+
+		out := unit{Name: u.Name, Enabled: u.Enabled, Contents: contents}
+		for _, d := range u.Dropins {
+			if !strings.HasSuffix(d.Name, ".conf") {
+				return "", fmt.Errorf("drop-in %q for unit %s must end in .conf", d.Name, u.Name)
+			}
+			dropinContents, err := unitContents(machineDir, u.Name+" drop-in "+d.Name, d.Contents, d.ContentsFile)
+			if err != nil {
+				return "", err
+			}
+			out.Dropins = append(out.Dropins, dropin{Name: d.Name, Contents: dropinContents})
+		}
+		rendered = append(rendered, out)
+	}
+
+	content, err := yaml.Marshal(map[string]any{"systemd": map[string]any{"units": rendered}})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode units: %w", err)
+	}
+	return string(content), nil
+}
+
+// unitContents returns inline contents or reads contents_file from the machine's directory
+func unitContents(machineDir, what, inline, file string) (string, error) {
+	switch {
+	case inline != "" && file != "":
+		return "", fmt.Errorf("%s sets both contents and contents_file", what)
+	case file == "":
+		return inline, nil
+	case !filepath.IsLocal(file):
+		return "", fmt.Errorf("contents_file %q for %s must be a relative path inside %s", file, what, machineDir)
+	}
+
+	content, err := os.ReadFile(filepath.Join(machineDir, file))
+	if err != nil {
+		return "", fmt.Errorf("failed to read contents_file for %s: %w", what, err)
+	}
+	return string(content), nil
+}
+
+func hasUnitSuffix(name string) bool {
+	for _, suffix := range unitSuffixes {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			return true
+		}
+	}
+	return false
+}
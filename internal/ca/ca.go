@@ -0,0 +1,254 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultCertsDir is where iago ca issue writes certificates and their keys
+const DefaultCertsDir = ".iago/ca/certs"
+
+// Default lifetimes of the CA and the certificates it issues
+const (
+	DefaultCAValidity   = 10 * 365 * 24 * time.Hour
+	DefaultCertValidity = 397 * 24 * time.Hour
+)
+
+// Authority is a CA certificate and the key that signs with it
+type Authority struct {
+	Certificate *x509.Certificate
+	PEM         []byte
+	key         crypto.Signer
+}
+
+// Init creates a CA named commonName, writing its certificate to certPath and
+// its key to keyPath (mode 0600). It refuses to replace an existing CA.
+func Init(certPath, keyPath, commonName string, validity time.Duration) (*Authority, error) {
+	for _, path := range []string{certPath, keyPath} {
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("%s already exists; remove it to create a new CA, and re-render every machine to trust it", path)
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		SubjectKeyId:          keyID(&key.PublicKey),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := writeFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := writeFile(certPath, certPEM, 0644); err != nil {
+		return nil, err
+	}
+	return &Authority{Certificate: cert, PEM: certPEM, key: key}, nil
+}
+
+// Load reads the CA certificate at certPath and its key at keyPath
+func Load(certPath, keyPath string) (*Authority, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no CA at %s; create one with 'iago ca init'", certPath)
+		}
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", certPath, err)
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key (only the workstation that ran 'iago ca init' has it): %w", err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM key found", keyPath)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to parse CA key: %w", keyPath, err)
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported CA key type %T", keyPath, parsed)
+	}
+	if !publicKeysEqual(key.Public(), cert.PublicKey) {
+		return nil, fmt.Errorf("%s is not the key of %s", keyPath, certPath)
+	}
+	return &Authority{Certificate: cert, PEM: certPEM, key: key}, nil
+}
+
+// Request describes a certificate to issue
+type Request struct {
+	CommonName string
+	DNSNames   []string
+	IPs        []net.IP
+	Validity   time.Duration
+	Client     bool // Also valid for TLS client authentication, e.g. a registry's cert_file
+}
+
+// Issue signs a new certificate for req and returns it, followed by the CA
+// certificate, and its private key, both PEM encoded
+func (a *Authority) Issue(req Request) (certPEM, keyPEM []byte, err error) {
+	if len(req.DNSNames) == 0 && len(req.IPs) == 0 {
+		return nil, nil, errors.New("a certificate needs at least one DNS name or IP address")
+	}
+	validity := req.Validity
+	if validity == 0 {
+		validity = DefaultCertValidity
+	}
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(a.Certificate.NotAfter) {
+		return nil, nil, fmt.Errorf("the certificate would outlive the CA, which expires %s", a.Certificate.NotAfter.Format(time.DateOnly))
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	usage := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	if req.Client {
+		usage = append(usage, x509.ExtKeyUsageClientAuth)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        pkix.Name{CommonName: req.CommonName},
+		DNSNames:       req.DNSNames,
+		IPAddresses:    req.IPs,
+		NotBefore:      now.Add(-time.Hour),
+		NotAfter:       notAfter,
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    usage,
+		AuthorityKeyId: a.Certificate.SubjectKeyId,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.Certificate, &key.PublicKey, a.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to issue certificate: %w", err)
+	}
+
+	keyPEM, err = encodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), a.PEM...)
+	return certPEM, keyPEM, nil
+}
+
+// WriteCertificate writes a certificate and key Issue returned to dir as
+// <name>.crt and <name>.key, and returns their paths
+func WriteCertificate(dir, name string, certPEM, keyPEM []byte) (certPath, keyPath string, err error) {
+	certPath = filepath.Join(dir, name+".crt")
+	keyPath = filepath.Join(dir, name+".key")
+	if err := writeFile(keyPath, keyPEM, 0600); err != nil {
+		return "", "", err
+	}
+	if err := writeFile(certPath, certPEM, 0644); err != nil {
+		return "", "", err
+	}
+	return certPath, keyPath, nil
+}
+
+func parseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, errors.New("certificate is not a CA")
+	}
+	return cert, nil
+}
+
+func serialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial, nil
+}
+
+// keyID is the subject key identifier of a public key (RFC 7093 method 1)
+func keyID(public *ecdsa.PublicKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(der)
+	return sum[:20]
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func writeFile(path string, content []byte, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, content, mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
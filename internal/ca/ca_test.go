@@ -0,0 +1,103 @@
+package ca
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInit(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "config", "ca", "ca.crt")
+	keyPath := filepath.Join(dir, ".iago", "ca", "ca.key")
+
+	authority, err := Init(certPath, keyPath, "test CA", DefaultCAValidity)
+	require.NoError(t, err)
+	assert.True(t, authority.Certificate.IsCA)
+	assert.Equal(t, "test CA", authority.Certificate.Subject.CommonName)
+
+	info, err := os.Stat(keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	_, err = Init(certPath, keyPath, "test CA", DefaultCAValidity)
+	assert.ErrorContains(t, err, "already exists")
+
+	loaded, err := Load(certPath, keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, authority.Certificate.SerialNumber, loaded.Certificate.SerialNumber)
+
+	// A key from another CA is refused
+	otherKey := filepath.Join(dir, "other.key")
+	_, err = Init(filepath.Join(dir, "other.crt"), otherKey, "other CA", DefaultCAValidity)
+	require.NoError(t, err)
+	_, err = Load(certPath, otherKey)
+	assert.ErrorContains(t, err, "is not the key of")
+
+	_, err = Load(filepath.Join(dir, "missing.crt"), keyPath)
+	assert.ErrorContains(t, err, "iago ca init")
+}
+
+func TestAuthority_Issue(t *testing.T) {
+	dir := t.TempDir()
+	authority, err := Init(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"), "test CA", DefaultCAValidity)
+	require.NoError(t, err)
+
+	certPEM, keyPEM, err := authority.Issue(Request{
+		CommonName: "registry",
+		DNSNames:   []string{"registry.lab"},
+		IPs:        []net.IP{net.ParseIP("10.0.0.5")},
+	})
+	require.NoError(t, err)
+
+	// The certificate is followed by the CA's, and pairs with the key
+	_, err = tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	block, rest := pem.Decode(certPEM)
+	require.NotNil(t, block)
+	assert.Equal(t, authority.PEM, rest)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(authority.Certificate)
+	for _, host := range []string{"registry.lab", "10.0.0.5"} {
+		_, err = cert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+		assert.NoError(t, err, host)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "other.lab", Roots: roots})
+	assert.Error(t, err)
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.Error(t, err, "not a client certificate unless asked")
+
+	certPEM, _, err = authority.Issue(Request{CommonName: "iago", DNSNames: []string{"iago"}, Client: true})
+	require.NoError(t, err)
+	block, _ = pem.Decode(certPEM)
+	cert, err = x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	assert.Contains(t, cert.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+
+	_, _, err = authority.Issue(Request{CommonName: "empty"})
+	assert.ErrorContains(t, err, "at least one DNS name")
+	_, _, err = authority.Issue(Request{CommonName: "long", DNSNames: []string{"long"}, Validity: 20 * 365 * 24 * time.Hour})
+	assert.ErrorContains(t, err, "outlive the CA")
+}
+
+func TestWriteCertificate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "certs")
+	certPath, keyPath, err := WriteCertificate(dir, "registry", []byte("cert"), []byte("key"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "registry.crt"), certPath)
+
+	info, err := os.Stat(keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
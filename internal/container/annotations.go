@@ -0,0 +1,68 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// AnnotationWorkload is the manifest annotation iago stamps on pushed workload
+// images: the workload (containers/<name>) the image was built from. Which
+// machines run it is read from the repository instead, so the digest doesn't
+// change when machines do.
+const AnnotationWorkload = "org.iago.workload"
+
+// ImageMetadata is what the iago annotations of a pushed image say about it
+type ImageMetadata struct {
+	Digest   string
+	Workload string
+}
+
+// WorkloadAnnotations returns the annotations linking an image to the
+// workload it was built from
+func WorkloadAnnotations(workload string) map[string]string {
+	return map[string]string{AnnotationWorkload: workload}
+}
+
+// annotate adds the build's annotations to an image or image index manifest
+func (b *Builder) annotate(img artifact) (artifact, error) {
+	if len(b.options.Annotations) == 0 {
+		return img, nil
+	}
+	annotated, ok := mutate.Annotations(img, b.options.Annotations).(artifact)
+	if !ok {
+		return nil, fmt.Errorf("failed to annotate image")
+	}
+	return annotated, nil
+}
+
+// ReadImageMetadata fetches the manifest of imageRef and returns its iago annotations
+func ReadImageMetadata(ctx context.Context, imageRef string, registries RegistryTransports, authCfg *AuthConfig) (ImageMetadata, error) {
+	ref, err := registries.ParseReference(imageRef)
+	if err != nil {
+		return ImageMetadata{}, fmt.Errorf("invalid image reference %s: %w", imageRef, err)
+	}
+	options, err := registries.RemoteOptions(ctx, ref)
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+	if authenticator := pushAuthenticator(authCfg); authenticator != nil {
+		options = append(options, remote.WithAuth(authenticator))
+	}
+
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return ImageMetadata{}, fmt.Errorf("failed to fetch manifest of %s: %w", imageRef, err)
+	}
+	var manifest struct {
+		Annotations map[string]string `json:"annotations"`
+	}
+	if err := json.Unmarshal(desc.Manifest, &manifest); err != nil {
+		return ImageMetadata{}, fmt.Errorf("failed to parse manifest of %s: %w", imageRef, err)
+	}
+
+	return ImageMetadata{Digest: desc.Digest.String(), Workload: manifest.Annotations[AnnotationWorkload]}, nil
+}
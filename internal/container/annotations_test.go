@@ -0,0 +1,61 @@
+package container
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadImageMetadata(t *testing.T) {
+	host, registries := newSigningRegistry(t)
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		name     string
+		artifact func(t *testing.T) artifact
+	}{
+		{"image", func(t *testing.T) artifact {
+			img, err := random.Image(64, 1)
+			require.NoError(t, err)
+			return img
+		}},
+		{"index", func(t *testing.T) artifact {
+			index, err := random.Index(64, 1, 2)
+			require.NoError(t, err)
+			return index
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := NewBuilder(BuildOptions{Annotations: WorkloadAnnotations("web")})
+			annotated, err := builder.annotate(tt.artifact(t))
+			require.NoError(t, err)
+
+			imageRef := host + "/web:" + tt.name
+			ref, err := registries.ParseReference(imageRef)
+			require.NoError(t, err)
+			require.NoError(t, remote.Push(ref, annotated))
+
+			metadata, err := ReadImageMetadata(ctx, imageRef, registries, nil)
+			require.NoError(t, err)
+			digest, err := annotated.Digest()
+			require.NoError(t, err)
+			assert.Equal(t, ImageMetadata{Digest: digest.String(), Workload: "web"}, metadata)
+		})
+	}
+
+	img, err := random.Image(64, 1)
+	require.NoError(t, err)
+	ref, err := registries.ParseReference(host + "/plain:latest")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img))
+	metadata, err := ReadImageMetadata(ctx, host+"/plain:latest", registries, nil)
+	require.NoError(t, err)
+	assert.Empty(t, metadata.Workload, "images pushed by other tools have no annotations")
+
+	_, err = ReadImageMetadata(ctx, host+"/missing:latest", registries, nil)
+	assert.ErrorContains(t, err, "failed to fetch manifest")
+}
@@ -0,0 +1,346 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/andreweick/iago/internal/containerfile"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// Build backends for [container_registry.build] backend
+const (
+	BackendAuto    = "auto"    // First of podman, buildah, docker found on PATH
+	BackendPodman  = "podman"  // podman build
+	BackendBuildah = "buildah" // buildah build
+	BackendDocker  = "docker"  // docker build (BuildKit)
+	BackendSimple  = "simple"  // FROM plus the context as one layer; other instructions are ignored
+)
+
+// autoBackends are tried in order by BackendAuto
+var autoBackends = []string{BackendPodman, BackendBuildah, BackendDocker}
+
+// lookPath finds build tools; replaced in tests
+var lookPath = exec.LookPath
+
+// resolveBackend turns the configured backend into the tool to run
+func resolveBackend(backend string) (string, error) {
+	switch backend {
+	case "", BackendAuto:
+		for _, tool := range autoBackends {
+			if _, err := lookPath(tool); err == nil {
+				return tool, nil
+			}
+		}
+		return "", fmt.Errorf("no container build tool found: install podman, buildah or docker, or set [container_registry.build] backend = %q to only add the context to the FROM image", BackendSimple)
+	case BackendPodman, BackendBuildah, BackendDocker:
+		if _, err := lookPath(backend); err != nil {
+			return "", fmt.Errorf("build backend %s is not installed: %w", backend, err)
+		}
+		return backend, nil
+	case BackendSimple:
+		return BackendSimple, nil
+	default:
+		return "", fmt.Errorf("unknown build backend %q: use auto, podman, buildah, docker or simple", backend)
+	}
+}
+
+// buildWithTool runs a real Containerfile build with podman, buildah or docker,
+// then loads the result so signing and pushing work the same for every backend
+func (b *Builder) buildWithTool(ctx context.Context, tool, buildFilePath string) (v1.Image, error) {
+	if err := b.ensureWorkDir(); err != nil {
+		return nil, err
+	}
+
+	tag := b.options.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	archive := filepath.Join(b.workDir, "image.tar")
+	build := []string{"build", "--file", buildFilePath}
+	if b.platform != nil {
+		// Cross-platform builds need qemu-user-static (or a buildx builder) on the host
+		tag += "-" + strings.ReplaceAll(b.platform.String(), "/", "-")
+		archive = filepath.Join(b.workDir, "image-"+strings.ReplaceAll(b.platform.String(), "/", "-")+".tar")
+		build = append(build, "--platform", b.platform.String())
+	}
+	localTag := fmt.Sprintf("localhost/iago-build/%s:%s", b.options.WorkloadName, tag)
+	// Build from a sanitized copy, so symlinks can't reach outside the context
+	contextDir, err := stageContext(b.options.ContextPath, b.workDir)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(contextDir)
+	build = append(build, "--tag", localTag, contextDir)
+
+	// podman and buildah read mirrors and insecure registries from
+	// registries.conf drop-ins, on top of the user's registries.conf; docker
+	// only from its daemon's configuration
+	var env []string
+	registriesConf, err := b.registriesConf()
+	if err != nil {
+		return nil, err
+	}
+	if registriesConf != "" {
+		if tool == BackendDocker {
+			fmt.Fprintln(b.out(), "Note: docker pulls FROM images with the daemon's registry-mirrors and insecure-registries (daemon.json), not [container_registry.registries]")
+		} else {
+			dir := filepath.Join(b.workDir, "registries.conf.d")
+			if err := writeRegistriesDropIns(dir, registriesConf); err != nil {
+				return nil, err
+			}
+			env = append(env, "CONTAINERS_REGISTRIES_CONF_DIR="+dir)
+		}
+	}
+
+	fmt.Fprintf(b.out(), "Building %s with %s\n", b.options.WorkloadName, tool)
+	if err := runTool(ctx, b.out(), b.errOut(), env, tool, build...); err != nil {
+		return nil, err
+	}
+
+	var export []string
+	switch tool {
+	case BackendBuildah:
+		export = []string{"push", localTag, "docker-archive:" + archive + ":" + localTag}
+	case BackendPodman:
+		export = []string{"save", "--format", "docker-archive", "--output", archive, localTag}
+	default:
+		export = []string{"save", "--output", archive, localTag}
+	}
+	if err := runTool(ctx, b.out(), b.errOut(), env, tool, export...); err != nil {
+		return nil, err
+	}
+
+	img, err := tarball.ImageFromPath(archive, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image built by %s: %w", tool, err)
+	}
+
+	b.recordFinalBase(ctx, buildFilePath)
+	return img, nil
+}
+
+// runTool runs a build tool with its output streamed to stdout and stderr and
+// env added to its environment
+func runTool(ctx context.Context, stdout, stderr io.Writer, env []string, tool string, args ...string) error {
+	cmd := exec.CommandContext(ctx, tool, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if tool == BackendDocker {
+		env = append(env, "DOCKER_BUILDKIT=1")
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s failed: %w", tool, args[0], err)
+	}
+	return nil
+}
+
+// recordFinalBase notes the final stage's FROM image for provenance. Its digest
+// is looked up best-effort; stages built from earlier stages have none.
+func (b *Builder) recordFinalBase(ctx context.Context, buildFilePath string) {
+	content, err := os.ReadFile(buildFilePath)
+	if err != nil {
+		return
+	}
+	base := finalBaseImage(string(content))
+	if base == "" {
+		return
+	}
+	b.info.BaseImage = base
+
+	ref, err := b.options.Registries.ParseReference(base)
+	if err != nil {
+		return
+	}
+	options, err := b.options.Registries.CraneOptions(ctx, ref.Context().RegistryStr())
+	if err != nil {
+		return
+	}
+	if b.platform != nil {
+		options = append(options, crane.WithPlatform(b.platform))
+	}
+	if digest, err := crane.Digest(ref.String(), options...); err == nil {
+		b.info.BaseImageDigest = digest
+	}
+}
+
+// finalBaseImage returns the image named by the last FROM, or "" when that
+// stage builds on an earlier stage or scratch or on a build argument
+func finalBaseImage(content string) string {
+	froms := containerfile.Froms(content)
+	if len(froms) == 0 || froms[len(froms)-1].Stage || froms[len(froms)-1].Unresolved {
+		return ""
+	}
+	return froms[len(froms)-1].Image
+}
+
+// ignoredInstructions lists the instructions the simple backend doesn't apply
+func ignoredInstructions(containerfile string) []string {
+	seen := make(map[string]bool)
+	var ignored []string
+	for _, line := range strings.Split(containerfile, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		instruction := strings.ToUpper(fields[0])
+		if instruction == "FROM" || seen[instruction] || !isInstruction(instruction) {
+			continue
+		}
+		seen[instruction] = true
+		ignored = append(ignored, instruction)
+	}
+	return ignored
+}
+
+func isInstruction(word string) bool {
+	switch word {
+	case "ADD", "ARG", "CMD", "COPY", "ENTRYPOINT", "ENV", "EXPOSE", "HEALTHCHECK", "LABEL",
+		"MAINTAINER", "ONBUILD", "RUN", "SHELL", "STOPSIGNAL", "USER", "VOLUME", "WORKDIR":
+		return true
+	}
+	return false
+}
+
+// ensureWorkDir creates the directory holding build files until they are pushed
+func (b *Builder) ensureWorkDir() error {
+	if b.workDir != "" {
+		return nil
+	}
+	workDir, err := os.MkdirTemp("", "iago-build-")
+	if err != nil {
+		return fmt.Errorf("failed to create build directory: %w", err)
+	}
+	b.workDir = workDir
+	return nil
+}
+
+// cleanup removes files kept for the last build
+func (b *Builder) cleanup() {
+	if b.workDir != "" {
+		os.RemoveAll(b.workDir)
+		b.workDir = ""
+	}
+}
+
+// registriesConf is the part of registries.conf(5) builds are given
+type registriesConf struct {
+	UnqualifiedSearchRegistries []string        `toml:"unqualified-search-registries"`
+	Registry                    []registryEntry `toml:"registry"`
+}
+
+type registryEntry struct {
+	Prefix   string           `toml:"prefix"`
+	Insecure bool             `toml:"insecure,omitempty"`
+	Mirror   []registryMirror `toml:"mirror,omitempty"`
+}
+
+type registryMirror struct {
+	Location string `toml:"location"`
+	Insecure bool   `toml:"insecure,omitempty"`
+}
+
+// registriesDropInDir returns the drop-in directory podman and buildah read
+// without CONTAINERS_REGISTRIES_CONF_DIR: the user's when they have their own
+// registries.conf, otherwise the system's
+var registriesDropInDir = func() string {
+	if config, err := os.UserConfigDir(); err == nil {
+		if _, err := os.Stat(filepath.Join(config, "containers", "registries.conf")); err == nil {
+			return filepath.Join(config, "containers", "registries.conf.d")
+		}
+	}
+	return "/etc/containers/registries.conf.d"
+}
+
+// writeRegistriesDropIns fills dir with the drop-ins of registriesDropInDir,
+// which CONTAINERS_REGISTRIES_CONF_DIR would otherwise hide, and conf as the
+// last one, so it only overrides the registries it names
+func writeRegistriesDropIns(dir, conf string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	existing := registriesDropInDir()
+	entries, err := os.ReadDir(existing)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", existing, err)
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".conf") || entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(existing, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", filepath.Join(existing, entry.Name()), err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, entry.Name()), content, 0644); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", entry.Name(), err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "zz-iago.conf"), []byte(conf), 0644); err != nil {
+		return fmt.Errorf("failed to write registries.conf drop-in: %w", err)
+	}
+	return nil
+}
+
+// registriesConf returns a registries.conf(5) with the mirrors of each
+// registry and the registries without TLS or with certificates that aren't
+// checked, or "" when there are none. Short names resolve on Docker Hub, as
+// in the simple backend.
+func (b *Builder) registriesConf() (string, error) {
+	insecure := func(host string) bool {
+		tls := b.options.Registries[host]
+		return tls.PlainHTTP || tls.InsecureSkipVerify
+	}
+
+	hosts := make(map[string]bool)
+	for host := range b.options.Registries {
+		if insecure(host) {
+			hosts[host] = true
+		}
+	}
+	for host := range b.options.Mirrors {
+		hosts[host] = true
+	}
+	if b.options.Pull.Mirror != "" {
+		hosts[name.DefaultRegistry] = true
+	}
+	if len(hosts) == 0 {
+		return "", nil
+	}
+
+	conf := registriesConf{UnqualifiedSearchRegistries: []string{"docker.io"}}
+	for _, host := range slices.Sorted(maps.Keys(hosts)) {
+		prefix := host
+		if host == name.DefaultRegistry {
+			prefix = "docker.io"
+		}
+		entry := registryEntry{Prefix: prefix, Insecure: insecure(host)}
+		for _, mirror := range b.mirrors(host) {
+			mirrorHost, _, _ := strings.Cut(mirror, "/")
+			entry.Mirror = append(entry.Mirror, registryMirror{Location: mirror, Insecure: insecure(mirrorHost)})
+		}
+		conf.Registry = append(conf.Registry, entry)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# Written by iago from [container_registry] for this build\n")
+	if err := toml.NewEncoder(&buf).Encode(conf); err != nil {
+		return "", fmt.Errorf("failed to encode registries.conf: %w", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,194 @@
+package container
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTools puts the named build tools on PATH. Each logs its arguments to
+// calls.log and, when asked to save or push an archive, copies archive there.
+func fakeTools(t *testing.T, archive string, tools ...string) (logPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	logPath = filepath.Join(dir, "calls.log")
+	script := `#!/bin/sh
+echo "${0##*/} $*" >> ` + logPath + `
+prev=""
+for arg; do
+  case "$prev" in --output) cp ` + archive + ` "$arg" ;; esac
+  case "$arg" in docker-archive:*) out=${arg#docker-archive:}; cp ` + archive + ` "${out%%:*}" ;; esac
+  prev="$arg"
+done
+`
+	for _, tool := range tools {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, tool), []byte(script), 0755))
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return logPath
+}
+
+func writeArchive(t *testing.T) (string, string) {
+	t.Helper()
+	img, err := random.Image(64, 2)
+	require.NoError(t, err)
+	digest, err := img.Digest()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "built.tar")
+	tag, err := name.NewTag("localhost/iago-build/app:latest")
+	require.NoError(t, err)
+	require.NoError(t, tarball.WriteToFile(path, tag, img))
+	return path, digest.String()
+}
+
+func TestResolveBackend(t *testing.T) {
+	installed := map[string]bool{BackendBuildah: true, BackendDocker: true}
+	original := lookPath
+	lookPath = func(file string) (string, error) {
+		if installed[file] {
+			return "/usr/bin/" + file, nil
+		}
+		return "", exec.ErrNotFound
+	}
+	t.Cleanup(func() { lookPath = original })
+
+	tool, err := resolveBackend("")
+	require.NoError(t, err)
+	assert.Equal(t, BackendBuildah, tool, "auto prefers podman, then buildah")
+
+	tool, err = resolveBackend(BackendDocker)
+	require.NoError(t, err)
+	assert.Equal(t, BackendDocker, tool)
+
+	_, err = resolveBackend(BackendPodman)
+	assert.ErrorContains(t, err, "build backend podman is not installed")
+
+	tool, err = resolveBackend(BackendSimple)
+	require.NoError(t, err)
+	assert.Equal(t, BackendSimple, tool)
+
+	_, err = resolveBackend("kaniko")
+	assert.ErrorContains(t, err, `unknown build backend "kaniko"`)
+
+	installed = nil
+	_, err = resolveBackend(BackendAuto)
+	assert.ErrorContains(t, err, "no container build tool found")
+}
+
+func TestBuildContainer_Tools(t *testing.T) {
+	for _, tool := range []string{BackendPodman, BackendBuildah, BackendDocker} {
+		t.Run(tool, func(t *testing.T) {
+			archive, digest := writeArchive(t)
+			logPath := fakeTools(t, archive, tool)
+
+			contextPath := t.TempDir()
+			containerfile := filepath.Join(contextPath, "Containerfile")
+			require.NoError(t, os.WriteFile(containerfile, []byte("FROM golang:1.24 AS build\nRUN go build\nFROM scratch\nCOPY --from=build /app /app\n"), 0644))
+
+			builder := NewBuilder(BuildOptions{WorkloadName: "app", ContextPath: contextPath, Backend: tool})
+			img, err := builder.BuildContainer(context.Background())
+			require.NoError(t, err)
+			defer builder.cleanup()
+
+			built, err := img.Digest()
+			require.NoError(t, err)
+			assert.Equal(t, digest, built.String(), "the image comes from the tool's archive")
+			assert.Empty(t, builder.BuildInfo().BaseImage, "scratch has no base image")
+
+			calls, err := os.ReadFile(logPath)
+			require.NoError(t, err)
+			assert.Contains(t, string(calls), tool+" build --file "+containerfile+" --tag localhost/iago-build/app:latest "+filepath.Join(builder.workDir, "context-"),
+				"tools build a staged copy of the context")
+
+			workDir := builder.workDir
+			builder.cleanup()
+			_, err = os.Stat(workDir)
+			assert.True(t, os.IsNotExist(err), "cleanup removes the image archive")
+		})
+	}
+}
+
+func TestFinalBaseImage(t *testing.T) {
+	assert.Equal(t, "quay.io/fedora/fedora-bootc:42", finalBaseImage("FROM quay.io/fedora/fedora-bootc:42\nRUN dnf install -y caddy\n"))
+	assert.Equal(t, "quay.io/fedora/fedora-bootc:42", finalBaseImage("FROM golang:1.24 AS build\nRUN go build\nFROM --platform=linux/amd64 quay.io/fedora/fedora-bootc:42\n"))
+	assert.Empty(t, finalBaseImage("FROM alpine AS base\nFROM base\n"), "stages building on earlier stages have no registry base")
+	assert.Empty(t, finalBaseImage("FROM scratch\n"))
+}
+
+func TestIgnoredInstructions(t *testing.T) {
+	containerfile := strings.Join([]string{
+		"# comment",
+		"FROM quay.io/fedora/fedora-bootc:42",
+		"RUN dnf install -y caddy \\",
+		"    && dnf clean all",
+		"COPY Caddyfile /etc/caddy/",
+		"RUN systemctl enable caddy",
+		"env LANG=C.UTF-8",
+	}, "\n")
+	assert.Equal(t, []string{"RUN", "COPY", "ENV"}, ignoredInstructions(containerfile))
+	assert.Empty(t, ignoredInstructions("FROM alpine\n"))
+}
+
+func TestBuilder_RegistriesConf(t *testing.T) {
+	builder := NewBuilder(BuildOptions{})
+	conf, err := builder.registriesConf()
+	require.NoError(t, err)
+	assert.Empty(t, conf, "nothing to configure")
+
+	builder = NewBuilder(BuildOptions{
+		Registries: RegistryTransports{
+			"registry.lab:5000": {PlainHTTP: true},
+			"harbor.lab":        {InsecureSkipVerify: true},
+			"ghcr.io":           {CAFile: "ca.pem"},
+		},
+		Pull:    machine.PullConfig{Mirror: "harbor.lab/hub"},
+		Mirrors: map[string][]string{"quay.io": {"harbor.lab/quay"}},
+	})
+	conf, err = builder.registriesConf()
+	require.NoError(t, err)
+
+	var parsed registriesConf
+	_, err = toml.Decode(conf, &parsed)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docker.io"}, parsed.UnqualifiedSearchRegistries)
+	assert.Equal(t, []registryEntry{
+		{Prefix: "harbor.lab", Insecure: true},
+		{Prefix: "docker.io", Mirror: []registryMirror{{Location: "harbor.lab/hub", Insecure: true}}},
+		{Prefix: "quay.io", Mirror: []registryMirror{{Location: "harbor.lab/quay", Insecure: true}}},
+		{Prefix: "registry.lab:5000", Insecure: true},
+	}, parsed.Registry)
+}
+
+func TestWriteRegistriesDropIns(t *testing.T) {
+	existing := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(existing, "10-aliases.conf"), []byte("[aliases]\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(existing, "README"), []byte("not a drop-in"), 0644))
+	original := registriesDropInDir
+	registriesDropInDir = func() string { return existing }
+	t.Cleanup(func() { registriesDropInDir = original })
+
+	dir := filepath.Join(t.TempDir(), "registries.conf.d")
+	require.NoError(t, writeRegistriesDropIns(dir, "# iago\n"))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"10-aliases.conf", "zz-iago.conf"}, names, "the user's drop-ins still apply, iago's last")
+	content, err := os.ReadFile(filepath.Join(dir, "zz-iago.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "# iago\n", string(content))
+}
@@ -0,0 +1,133 @@
+package container
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// contextEntry is one file, directory or symlink of a build context
+type contextEntry struct {
+	Path string      // Location on disk
+	Name string      // Clean slash-separated path relative to the context root
+	Info os.FileInfo // Lstat of Path
+	Link string      // Symlink target: absolute, or relative and inside the context
+}
+
+// walkContext visits the build context below root in lexical order. Absolute
+// symlink targets are passed on as they are, since they resolve inside the
+// image; a relative one that leads outside the context fails the walk, so a
+// workload can't pull host files into its image.
+func walkContext(root string, visit func(e contextEntry) error) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve context path: %w", err)
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve context path: %w", err)
+	}
+
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("error walking path %s: %w", p, err)
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %s: %w", p, err)
+		}
+		e := contextEntry{Path: p, Name: path.Clean(filepath.ToSlash(rel)), Info: info}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if e.Link, err = contextLink(realRoot, p, e.Name); err != nil {
+				return err
+			}
+		}
+		return visit(e)
+	})
+}
+
+// contextLink returns the target to archive for the symlink p named name
+func contextLink(realRoot, p, name string) (string, error) {
+	target, err := os.Readlink(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to read symlink %s: %w", p, err)
+	}
+	if filepath.IsAbs(target) {
+		return filepath.ToSlash(target), nil
+	}
+
+	if !insideContext(path.Join(path.Dir(name), filepath.ToSlash(target))) {
+		return "", fmt.Errorf("symlink %s points outside the build context (%s); copy the file into the context instead", name, target)
+	}
+
+	// Links through other links (a -> ., b -> a/..) can escape where the
+	// lexical path doesn't; check where existing targets really resolve
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		rel, err := filepath.Rel(realRoot, resolved)
+		if err != nil || !insideContext(rel) {
+			return "", fmt.Errorf("symlink %s resolves outside the build context (%s)", name, resolved)
+		}
+	}
+	return filepath.ToSlash(target), nil
+}
+
+// insideContext reports whether a clean relative path stays inside the context root
+func insideContext(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	return rel != ".." && !strings.HasPrefix(rel, "../") && !path.IsAbs(rel)
+}
+
+// stageContext copies the build context into a fresh directory below dir, with
+// symlinks checked as walkContext does, so external build tools see only the
+// context and concurrent builds of one workload don't share a tree. Absolute
+// symlinks are refused, as build tools would follow them on the host.
+func stageContext(root, dir string) (string, error) {
+	staged, err := os.MkdirTemp(dir, "context-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create build context directory: %w", err)
+	}
+	err = walkContext(root, func(e contextEntry) error {
+		dst := filepath.Join(staged, filepath.FromSlash(e.Name))
+		switch {
+		case e.Info.IsDir():
+			return os.Mkdir(dst, e.Info.Mode().Perm()|0700)
+		case e.Info.Mode()&os.ModeSymlink != 0:
+			if path.IsAbs(e.Link) {
+				return fmt.Errorf("symlink %s has an absolute target (%s), which build tools would follow on the host; make it relative", e.Name, e.Link)
+			}
+			return os.Symlink(filepath.FromSlash(e.Link), dst)
+		case e.Info.Mode().IsRegular():
+			return copyContextFile(e.Path, dst, e.Info.Mode().Perm())
+		default:
+			return nil
+		}
+	})
+	if err != nil {
+		os.RemoveAll(staged)
+		return "", fmt.Errorf("failed to stage build context: %w", err)
+	}
+	return staged, nil
+}
+
+func copyContextFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,118 @@
+package container
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateLayerFromContext_Symlinks(t *testing.T) {
+	contextPath := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(contextPath, "config", "nested"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(contextPath, "config", "app.conf"), []byte("x"), 0644))
+	require.NoError(t, os.Symlink("../app.conf", filepath.Join(contextPath, "config", "nested", "relative")))
+	require.NoError(t, os.Symlink(filepath.Join(contextPath, "config", "app.conf"), filepath.Join(contextPath, "absolute")))
+	require.NoError(t, os.Symlink("/usr/share/zoneinfo/UTC", filepath.Join(contextPath, "system")))
+	require.NoError(t, os.Symlink("missing", filepath.Join(contextPath, "dangling")))
+
+	layer, err := NewBuilder(BuildOptions{ContextPath: contextPath}).createLayerFromContext()
+	require.NoError(t, err)
+	rc, err := layer.Uncompressed()
+	require.NoError(t, err)
+	defer rc.Close()
+
+	links := make(map[string]string)
+	tr := tar.NewReader(rc)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if header.Typeflag == tar.TypeSymlink {
+			links[header.Name] = header.Linkname
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"config/nested/relative": "../app.conf",
+		"absolute":               filepath.ToSlash(filepath.Join(contextPath, "config", "app.conf")),
+		"system":                 "/usr/share/zoneinfo/UTC",
+		"dangling":               "missing",
+	}, links, "absolute targets resolve inside the image, so they're kept as they are")
+}
+
+func TestCreateLayerFromContext_MaliciousContexts(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dir string)
+	}{
+		{
+			name: "relative traversal",
+			setup: func(t *testing.T, dir string) {
+				require.NoError(t, os.MkdirAll(filepath.Join(dir, "a"), 0755))
+				require.NoError(t, os.Symlink("../../../etc/passwd", filepath.Join(dir, "a", "passwd")))
+			},
+		},
+		{
+			name: "escape through another link",
+			setup: func(t *testing.T, dir string) {
+				require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+				require.NoError(t, os.Symlink(".", filepath.Join(dir, "sub", "here")))
+				require.NoError(t, os.Symlink("here/..", filepath.Join(dir, "sub", "up")))
+				require.NoError(t, os.Symlink("../sub/up/..", filepath.Join(dir, "sub", "escape")))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contextPath := t.TempDir()
+			tt.setup(t, contextPath)
+
+			_, err := NewBuilder(BuildOptions{ContextPath: contextPath}).createLayerFromContext()
+			assert.ErrorContains(t, err, "outside the build context")
+
+			_, err = stageContext(contextPath, t.TempDir())
+			assert.ErrorContains(t, err, "outside the build context")
+		})
+	}
+}
+
+func TestStageContext(t *testing.T) {
+	contextPath := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(contextPath, "scripts"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(contextPath, "scripts", "run.sh"), []byte("#!/bin/sh\n"), 0755))
+	require.NoError(t, os.Symlink(filepath.Join("scripts", "run.sh"), filepath.Join(contextPath, "entrypoint")))
+
+	workDir := t.TempDir()
+	first, err := stageContext(contextPath, workDir)
+	require.NoError(t, err)
+	second, err := stageContext(contextPath, workDir)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second, "every build gets its own copy")
+
+	info, err := os.Stat(filepath.Join(first, "scripts", "run.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	target, err := os.Readlink(filepath.Join(first, "entrypoint"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("scripts", "run.sh"), target)
+}
+
+func TestStageContext_AbsoluteSymlinks(t *testing.T) {
+	for _, target := range []string{"/etc/shadow", "inside"} {
+		contextPath := t.TempDir()
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(contextPath, target)
+		}
+		require.NoError(t, os.Symlink(target, filepath.Join(contextPath, "link")))
+
+		_, err := stageContext(contextPath, t.TempDir())
+		assert.ErrorContains(t, err, "has an absolute target", target)
+	}
+}
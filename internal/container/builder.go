@@ -4,18 +4,24 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/andreweick/iago/internal/containerfile"
+	"github.com/andreweick/iago/internal/machine"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 )
 
@@ -40,18 +46,70 @@ type BuildOptions struct {
 	Sign          bool
 	CosignKeyPath string // Path to custom cosign private key (optional)
 	AuthConfig    *AuthConfig
+	Registries    RegistryTransports      // Per-registry TLS settings for pull and push
+	Pull          machine.PullConfig      // Mirror and rate-limit settings for FROM images
+	Mirrors       map[string][]string     // Pull-through mirrors for FROM images, by registry host
+	BaseImages    machine.BaseImagePolicy // Allowlist the FROM images are checked against
+	PullAuth      *AuthConfig             // Optional Docker Hub credentials, separate from push auth
+	RegistryAuth  map[string]*AuthConfig  // Pull credentials by registry host, for FROM images and mirrors
+	Performance   machine.PerformanceConfig
+	Backend       string            // Build backend (BackendAuto when empty)
+	Platforms     []string          // Target platforms, e.g. linux/amd64; more than one pushes an image index
+	Compression   string            // Layer compression: CompressionGzip (default) or CompressionZstd
+	Estargz       bool              // Rewrite layers as eStargz for lazy pulling
+	RateLimit     *RateLimiter      // Throttles pulls and pushes; nil for no limit
+	Output        io.Writer         // Progress and build tool output (os.Stdout and os.Stderr when nil)
+	FulcioURL     string            // Keyless signing certificate authority (DefaultFulcioURL when empty)
+	RekorURL      string            // Keyless signing transparency log (DefaultRekorURL when empty)
+	Annotations   map[string]string // Manifest annotations, e.g. MachineAnnotations
+	SBOM          string            // SBOM format written for the image: sbom.FormatCycloneDX or sbom.FormatSPDX; empty writes none
+	SBOMDir       string            // Where SBOMs are written (DefaultSBOMDir when empty)
+	AttachSBOM    bool              // Push the SBOM as a referrer of the image
 }
 
 // AuthConfig contains registry authentication details
 type AuthConfig struct {
-	Username string
-	Password string
-	Token    string
+	Username      string
+	Password      string
+	Token         string
+	IdentityToken string // docker login refresh token, exchanged with the registry's token service
 }
 
 // Builder handles container building operations
 type Builder struct {
-	options BuildOptions
+	options  BuildOptions
+	info     BuildInfo
+	workDir  string       // Holds image archives and recompressed layers until they are pushed
+	platform *v1.Platform // Platform being built; nil builds for the host
+}
+
+// artifact is a built image or image index
+type artifact interface {
+	remote.Taggable
+	Digest() (v1.Hash, error)
+}
+
+// BuildInfo describes the inputs and output of the last build, for provenance
+type BuildInfo struct {
+	BaseImage       string
+	BaseImageDigest string
+	ImageDigest     string
+}
+
+// out is where progress and build tool output go
+func (b *Builder) out() io.Writer {
+	if b.options.Output == nil {
+		return os.Stdout
+	}
+	return b.options.Output
+}
+
+// errOut is where build tool errors go
+func (b *Builder) errOut() io.Writer {
+	if b.options.Output == nil {
+		return os.Stderr
+	}
+	return b.options.Output
 }
 
 // NewBuilder creates a new container builder
@@ -63,33 +121,48 @@ func NewBuilder(options BuildOptions) *Builder {
 
 // BuildContainer builds a container image from a Dockerfile or Containerfile
 func (b *Builder) BuildContainer(ctx context.Context) (v1.Image, error) {
-	// Try Containerfile first, then Dockerfile for backwards compatibility
-	containerfilePath := filepath.Join(b.options.ContextPath, "Containerfile")
-	dockerfilePath := filepath.Join(b.options.ContextPath, "Dockerfile")
-
-	var buildFilePath string
-	if _, err := os.Stat(containerfilePath); err == nil {
-		buildFilePath = containerfilePath
-	} else if _, err := os.Stat(dockerfilePath); err == nil {
-		buildFilePath = dockerfilePath
-	} else {
-		return nil, fmt.Errorf("neither Containerfile nor Dockerfile found in %s", b.options.ContextPath)
+	buildFilePath, err := containerfile.Find(b.options.ContextPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := containerfile.CheckBaseImages(buildFilePath, b.options.BaseImages); err != nil {
+		if !b.options.BaseImages.Warn() {
+			return nil, err
+		}
+		fmt.Fprintf(b.out(), "⚠️  %v\n", err)
 	}
 
-	// For now, we'll use a simple approach with go-containerregistry
-	// This is a basic implementation - in a real scenario, you might want to use buildkit
-	return b.buildFromDockerfile(ctx, buildFilePath)
+	if err := ValidateLayerCompression(b.options.Compression, b.options.Estargz); err != nil {
+		return nil, err
+	}
+	tool, err := resolveBackend(b.options.Backend)
+	if err != nil {
+		return nil, err
+	}
+	var img v1.Image
+	if tool != BackendSimple {
+		img, err = b.buildWithTool(ctx, tool, buildFilePath)
+	} else {
+		img, err = b.buildFromDockerfile(ctx, buildFilePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.recompressLayers(img)
 }
 
-// buildFromDockerfile builds an image from a Dockerfile
-// Note: This is a simplified implementation. For full Dockerfile support,
-// consider using buildkit or implementing a more complete Docker parser
+// buildFromDockerfile is the simple backend: it pulls the FROM image and adds
+// the context directory as one layer, without running the other instructions
 func (b *Builder) buildFromDockerfile(ctx context.Context, dockerfilePath string) (v1.Image, error) {
 	// Read Dockerfile
 	dockerfile, err := os.ReadFile(dockerfilePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read Dockerfile: %w", err)
 	}
+	if ignored := ignoredInstructions(string(dockerfile)); len(ignored) > 0 {
+		fmt.Fprintf(b.out(), "Warning: the simple build backend ignores %s in %s; use podman, buildah or docker for a real build\n",
+			strings.Join(ignored, ", "), filepath.Base(dockerfilePath))
+	}
 
 	// Parse basic FROM instruction to get base image
 	baseImage, err := b.parseBaseImage(string(dockerfile))
@@ -98,10 +171,14 @@ func (b *Builder) buildFromDockerfile(ctx context.Context, dockerfilePath string
 	}
 
 	// Pull base image
-	img, err := remote.Image(baseImage, remote.WithContext(ctx))
+	img, err := b.pullBaseImage(ctx, baseImage)
 	if err != nil {
 		return nil, fmt.Errorf("failed to pull base image %s: %w", baseImage, err)
 	}
+	b.info.BaseImage = baseImage.String()
+	if digest, err := img.Digest(); err == nil {
+		b.info.BaseImageDigest = digest.String()
+	}
 
 	// Create a layer from the context directory
 	layer, err := b.createLayerFromContext()
@@ -127,7 +204,7 @@ func (b *Builder) parseBaseImage(dockerfile string) (name.Reference, error) {
 			parts := strings.Fields(line)
 			if len(parts) >= 2 {
 				baseImageStr := parts[1]
-				ref, err := name.ParseReference(baseImageStr)
+				ref, err := b.options.Registries.ParseReference(baseImageStr)
 				if err != nil {
 					return nil, fmt.Errorf("invalid base image reference %s: %w", baseImageStr, err)
 				}
@@ -149,72 +226,49 @@ func (b *Builder) createLayerFromContext() (v1.Layer, error) {
 		return nil, fmt.Errorf("context path is empty")
 	}
 
-	// Walk through all files in the context directory
-	err := filepath.Walk(contextPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return fmt.Errorf("error walking path %s: %w", path, err)
-		}
-
-		// Skip the root directory itself
-		if path == contextPath {
-			return nil
-		}
-
+	// Walk through all files in the context directory, refusing symlinks that escape it
+	err := walkContext(contextPath, func(e contextEntry) error {
 		// Skip Dockerfile as it's not included in the context layer
-		if info.Name() == "Dockerfile" || info.Name() == "Containerfile" {
+		if e.Info.Name() == "Dockerfile" || e.Info.Name() == "Containerfile" {
 			return nil
 		}
 
-		// Get relative path from context root
-		relPath, err := filepath.Rel(contextPath, path)
-		if err != nil {
-			return fmt.Errorf("failed to get relative path for %s: %w", path, err)
-		}
-
-		// Convert to forward slashes for tar archive (platform independent)
-		relPath = filepath.ToSlash(relPath)
-
 		// Create tar header
-		header, err := tar.FileInfoHeader(info, "")
+		header, err := tar.FileInfoHeader(e.Info, e.Link)
 		if err != nil {
-			return fmt.Errorf("failed to create tar header for %s: %w", path, err)
+			return fmt.Errorf("failed to create tar header for %s: %w", e.Path, err)
 		}
-		header.Name = relPath
+		header.Name = e.Name
 
 		// Handle different file types
 		switch {
-		case info.Mode().IsRegular():
+		case e.Info.Mode().IsRegular():
 			// Write header for regular file
 			if err := tw.WriteHeader(header); err != nil {
-				return fmt.Errorf("failed to write tar header for %s: %w", path, err)
+				return fmt.Errorf("failed to write tar header for %s: %w", e.Path, err)
 			}
 
 			// Copy file contents
-			file, err := os.Open(path)
+			file, err := os.Open(e.Path)
 			if err != nil {
-				return fmt.Errorf("failed to open file %s: %w", path, err)
+				return fmt.Errorf("failed to open file %s: %w", e.Path, err)
 			}
 			defer file.Close()
 
 			if _, err := io.Copy(tw, file); err != nil {
-				return fmt.Errorf("failed to copy file contents for %s: %w", path, err)
+				return fmt.Errorf("failed to copy file contents for %s: %w", e.Path, err)
 			}
 
-		case info.IsDir():
+		case e.Info.IsDir():
 			// Write header for directory
 			if err := tw.WriteHeader(header); err != nil {
-				return fmt.Errorf("failed to write tar header for directory %s: %w", path, err)
+				return fmt.Errorf("failed to write tar header for directory %s: %w", e.Path, err)
 			}
 
-		case info.Mode()&os.ModeSymlink != 0:
-			// Handle symbolic links
-			linkTarget, err := os.Readlink(path)
-			if err != nil {
-				return fmt.Errorf("failed to read symlink %s: %w", path, err)
-			}
-			header.Linkname = linkTarget
+		case e.Info.Mode()&os.ModeSymlink != 0:
+			// Symbolic links, with targets rewritten to stay inside the context
 			if err := tw.WriteHeader(header); err != nil {
-				return fmt.Errorf("failed to write tar header for symlink %s: %w", path, err)
+				return fmt.Errorf("failed to write tar header for symlink %s: %w", e.Path, err)
 			}
 
 		default:
@@ -240,8 +294,31 @@ func (b *Builder) createLayerFromContext() (v1.Layer, error) {
 	})
 }
 
+// build builds a single image, or an image index when several platforms are requested
+func (b *Builder) build(ctx context.Context) (artifact, error) {
+	platforms, err := ParsePlatforms(b.options.Platforms)
+	if err != nil {
+		return nil, err
+	}
+	switch len(platforms) {
+	case 0:
+		return b.BuildContainer(ctx)
+	case 1:
+		b.platform = &platforms[0]
+		defer func() { b.platform = nil }()
+		return b.BuildContainer(ctx)
+	default:
+		return b.BuildIndex(ctx)
+	}
+}
+
 // PushContainer pushes the built image to a registry
 func (b *Builder) PushContainer(ctx context.Context, img v1.Image) error {
+	return b.push(ctx, img)
+}
+
+// push pushes an image or image index to the registry
+func (b *Builder) push(ctx context.Context, img artifact) error {
 	var registryURL string
 
 	if b.options.Local {
@@ -253,40 +330,129 @@ func (b *Builder) PushContainer(ctx context.Context, img v1.Image) error {
 	// Construct full image reference
 	imageRef := fmt.Sprintf("%s/%s:%s", registryURL, b.options.WorkloadName, b.options.Tag)
 
-	ref, err := name.ParseReference(imageRef)
+	ref, err := b.options.Registries.ParseReference(imageRef)
 	if err != nil {
 		return fmt.Errorf("invalid image reference %s: %w", imageRef, err)
 	}
 
-	// Configure push options
-	pushOptions := []remote.Option{
-		remote.WithContext(ctx),
+	pushOptions, err := b.pushOptions(ctx, ref)
+	if err != nil {
+		return err
 	}
 
-	// Add authentication if provided
-	if b.options.AuthConfig != nil {
-		if b.options.AuthConfig.Token != "" {
-			pushOptions = append(pushOptions, remote.WithAuth(&authn.Bearer{
-				Token: b.options.AuthConfig.Token,
-			}))
-		} else if b.options.AuthConfig.Username != "" && b.options.AuthConfig.Password != "" {
-			pushOptions = append(pushOptions, remote.WithAuth(&authn.Basic{
-				Username: b.options.AuthConfig.Username,
-				Password: b.options.AuthConfig.Password,
-			}))
+	// Blobs the registry already has are never re-uploaded (remote.Write HEADs
+	// each one); skip_existing also avoids the manifest round trip entirely
+	if b.options.Performance.SkipExisting {
+		upToDate, err := imageUpToDate(ref, img, pushOptions)
+		if err != nil {
+			return err
+		}
+		if upToDate {
+			fmt.Fprintf(b.out(), "%s is already up to date, skipping push\n", imageRef)
+			return nil
 		}
 	}
 
-	// Push the image
-	err = remote.Write(ref, img, pushOptions...)
+	// Push the image, or the index and every image it lists
+	err = remote.Push(ref, img, pushOptions...)
 	if err != nil {
 		return fmt.Errorf("failed to push image to %s: %w", imageRef, err)
 	}
 
-	fmt.Printf("Successfully pushed %s\n", imageRef)
+	fmt.Fprintf(b.out(), "Successfully pushed %s\n", imageRef)
 	return nil
 }
 
+// pushOptions configures TLS, concurrency and push credentials for ref's registry
+func (b *Builder) pushOptions(ctx context.Context, ref name.Reference) ([]remote.Option, error) {
+	options, err := b.remoteOptions(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if jobs := b.options.Performance.PushConcurrency; jobs > 0 {
+		options = append(options, remote.WithJobs(jobs))
+	}
+	if authenticator := pushAuthenticator(b.options.AuthConfig); authenticator != nil {
+		options = append(options, remote.WithAuth(authenticator))
+	}
+	return options, nil
+}
+
+// remoteOptions returns the transport options for ref's registry, throttled
+// by the rate limit
+func (b *Builder) remoteOptions(ctx context.Context, ref name.Reference) ([]remote.Option, error) {
+	transport, err := b.options.Registries.Transport(ref.Context().RegistryStr())
+	if err != nil {
+		return nil, err
+	}
+	return []remote.Option{remote.WithContext(ctx), remote.WithTransport(b.options.RateLimit.Transport(transport))}, nil
+}
+
+// pushAuthenticator returns the authenticator for push credentials, or nil for anonymous
+func pushAuthenticator(cfg *AuthConfig) authn.Authenticator {
+	if cfg == nil {
+		return nil
+	}
+	if cfg.IdentityToken != "" {
+		return authn.FromConfig(authn.AuthConfig{Username: cfg.Username, IdentityToken: cfg.IdentityToken})
+	}
+	if cfg.Token != "" {
+		return &authn.Bearer{Token: cfg.Token}
+	}
+	if cfg.Username != "" && cfg.Password != "" {
+		return &authn.Basic{Username: cfg.Username, Password: cfg.Password}
+	}
+	return nil
+}
+
+// singleKeychain hands the same authenticator to every registry
+type singleKeychain struct{ authn.Authenticator }
+
+func (k singleKeychain) Resolve(authn.Resource) (authn.Authenticator, error) {
+	return k.Authenticator, nil
+}
+
+// CheckPushPermission asks the registry whether authCfg may push to repository
+// (e.g. ghcr.io/owner/name) without uploading anything
+func CheckPushPermission(repository string, authCfg *AuthConfig, registries RegistryTransports) error {
+	ref, err := registries.ParseReference(repository)
+	if err != nil {
+		return fmt.Errorf("invalid repository %s: %w", repository, err)
+	}
+	transport, err := registries.Transport(ref.Context().RegistryStr())
+	if err != nil {
+		return err
+	}
+
+	var keychain authn.Keychain = singleKeychain{authn.Anonymous}
+	if authenticator := pushAuthenticator(authCfg); authenticator != nil {
+		keychain = singleKeychain{authenticator}
+	}
+	if err := remote.CheckPushPermission(ref, keychain, transport); err != nil {
+		return fmt.Errorf("cannot push to %s: %w", ref.Context(), err)
+	}
+	return nil
+}
+
+// imageUpToDate reports whether ref already points at img's digest
+func imageUpToDate(ref name.Reference, img artifact, options []remote.Option) (bool, error) {
+	digest, err := img.Digest()
+	if err != nil {
+		return false, fmt.Errorf("failed to compute image digest: %w", err)
+	}
+
+	desc, err := remote.Head(ref, options...)
+	if err != nil {
+		var transportErr *transport.Error
+		if errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check existing image %s: %w", ref, err)
+	}
+
+	return desc.Digest == digest, nil
+}
+
 // SignContainer signs a container image using cosign (keyless or key-based)
 func (b *Builder) SignContainer(ctx context.Context, imageRef string) error {
 	if !b.options.Sign {
@@ -315,7 +481,7 @@ func (b *Builder) resolveSigningKeyPath() string {
 		if _, err := os.Stat(b.options.CosignKeyPath); err == nil {
 			return b.options.CosignKeyPath
 		}
-		fmt.Printf("Warning: specified cosign key path not found: %s\n", b.options.CosignKeyPath)
+		fmt.Fprintf(b.out(), "Warning: specified cosign key path not found: %s\n", b.options.CosignKeyPath)
 	}
 
 	// 2. Environment variable
@@ -336,95 +502,240 @@ func (b *Builder) resolveSigningKeyPath() string {
 	return ""
 }
 
-// signContainerKeyless implements keyless signing for CI environments
+// signContainerKeyless signs with an ephemeral key certified by Fulcio for the
+// OIDC identity of the GitHub Actions workflow (or SIGSTORE_ID_TOKEN) and
+// records the signature in Rekor
 func (b *Builder) signContainerKeyless(ctx context.Context, imageRef string) error {
-	fmt.Printf("Signing container %s with cosign keyless signing...\n", imageRef)
-
-	// TODO: Implement keyless cosign signing integration
-	// This would involve:
-	// 1. Setting up OIDC authentication flow (GitHub Actions provides OIDC token automatically)
-	// 2. Getting ephemeral certificate from Fulcio
-	// 3. Creating signature payload for the container digest
-	// 4. Signing with the ephemeral key
-	// 5. Uploading signature to the registry and Rekor transparency log
-
+	fmt.Fprintf(b.out(), "Signing container %s with cosign keyless signing...\n", imageRef)
 	if isGitHubActions() {
-		fmt.Printf("Using GitHub Actions OIDC token for keyless signing...\n")
-	} else {
-		fmt.Printf("Please authenticate via OIDC provider (Google/GitHub/Microsoft)...\n")
+		fmt.Fprintf(b.out(), "Using GitHub Actions OIDC token for keyless signing...\n")
 	}
 
-	fmt.Printf("Note: Keyless signing implementation is a placeholder - would integrate with sigstore keyless flow\n")
-	fmt.Printf("✅ Container %s would be signed successfully with keyless signing\n", imageRef)
+	ref, options, err := b.resolveDigest(ctx, imageRef)
+	if err != nil {
+		return err
+	}
+	signer := newKeylessSigner(b.options.FulcioURL, b.options.RekorURL)
+	signer.out = b.out()
+	if err := signer.sign(ctx, ref, options); err != nil {
+		return err
+	}
+	fmt.Fprintf(b.out(), "✅ Signed %s\n", ref)
 	return nil
 }
 
-// signContainerWithKey implements key-based signing for local development
+// signContainerWithKey signs the image digest with a cosign private key and
+// uploads the signature next to the image
 func (b *Builder) signContainerWithKey(ctx context.Context, imageRef string, keyPath string) error {
-	fmt.Printf("Signing container %s with cosign key-based signing...\n", imageRef)
-
-	// TODO: Implement key-based cosign signing integration
-	// This would involve:
-	// 1. Loading the private key from file or environment variable
-	// 2. Prompting for passphrase (or using COSIGN_PASSWORD env var)
-	// 3. Creating signature payload for the container digest
-	// 4. Signing with the loaded private key
-	// 5. Uploading signature to the registry
+	fmt.Fprintf(b.out(), "Signing container %s with cosign key-based signing...\n", imageRef)
 
 	if strings.HasPrefix(keyPath, "-----BEGIN") {
-		fmt.Printf("Using cosign private key from environment variable...\n")
+		fmt.Fprintf(b.out(), "Using cosign private key from environment variable...\n")
 	} else {
-		fmt.Printf("Using cosign private key from: %s\n", keyPath)
+		fmt.Fprintf(b.out(), "Using cosign private key from: %s\n", keyPath)
+	}
+	signer, err := LoadSigningKey(keyPath)
+	if err != nil {
+		return err
 	}
 
-	fmt.Printf("Note: Key-based signing implementation is a placeholder - would integrate with cosign key signing\n")
-	fmt.Printf("✅ Container %s would be signed successfully with key-based signing\n", imageRef)
+	ref, options, err := b.resolveDigest(ctx, imageRef)
+	if err != nil {
+		return err
+	}
+	if err := SignDigest(ref, signer, options); err != nil {
+		return err
+	}
+	fmt.Fprintf(b.out(), "✅ Signed %s\n", ref)
 	return nil
 }
 
+// resolveDigest parses imageRef, resolving a tag to its current digest, and
+// returns the push options for its registry
+func (b *Builder) resolveDigest(ctx context.Context, imageRef string) (name.Digest, []remote.Option, error) {
+	ref, err := b.options.Registries.ParseReference(imageRef)
+	if err != nil {
+		return name.Digest{}, nil, fmt.Errorf("invalid image reference %s: %w", imageRef, err)
+	}
+	options, err := b.pushOptions(ctx, ref)
+	if err != nil {
+		return name.Digest{}, nil, err
+	}
+	if digestRef, ok := ref.(name.Digest); ok {
+		return digestRef, options, nil
+	}
+	desc, err := remote.Head(ref, options...)
+	if err != nil {
+		return name.Digest{}, nil, fmt.Errorf("failed to resolve %s to a digest: %w", imageRef, err)
+	}
+	return ref.Context().Digest(desc.Digest.String()), options, nil
+}
+
 // BuildAndPush is a convenience method that builds and optionally pushes a container
 func (b *Builder) BuildAndPush(ctx context.Context) error {
-	// Build the container
-	img, err := b.BuildContainer(ctx)
+	defer b.cleanup()
+
+	// Build the container, or one per platform
+	img, err := b.build(ctx)
 	if err != nil {
 		return fmt.Errorf("build failed: %w", err)
 	}
+	if img, err = b.annotate(img); err != nil {
+		return err
+	}
 
-	fmt.Printf("Successfully built container for %s\n", b.options.WorkloadName)
+	fmt.Fprintf(b.out(), "Successfully built container for %s\n", b.options.WorkloadName)
+	return b.signAndPush(ctx, img)
+}
+
+// PushFrom pushes an image built outside iago, e.g. "containers-storage:localhost/web:dev"
+// (see ParseSource), to the workload's repository and signs it like a build.
+// The base image allowlist applies as to builds, using the image's
+// org.opencontainers.image.base.name annotation.
+func (b *Builder) PushFrom(ctx context.Context, source string) error {
+	defer b.cleanup()
 
-	// Sign if requested (before push to ensure no unsigned images reach registry)
-	if b.options.Sign {
+	img, err := b.loadImage(ctx, source)
+	if err != nil {
+		return err
+	}
+	if err := b.checkSourceBaseImage(source, img); err != nil {
+		if !b.options.BaseImages.Warn() {
+			return err
+		}
+		fmt.Fprintf(b.out(), "⚠️  %v\n", err)
+	}
+	if img, err = b.annotate(img); err != nil {
+		return err
+	}
+	fmt.Fprintf(b.out(), "Loaded %s for %s\n", source, b.options.WorkloadName)
+	return b.signAndPush(ctx, img)
+}
+
+// signAndPush signs and pushes img as the options ask
+func (b *Builder) signAndPush(ctx context.Context, img artifact) error {
+	if digest, err := img.Digest(); err == nil {
+		b.info.ImageDigest = digest.String()
+	}
+
+	var sboms []sbomDocument
+	if b.options.SBOM != "" {
+		var err error
+		if sboms, err = b.writeSBOMs(img); err != nil {
+			return fmt.Errorf("SBOM generation failed: %w", err)
+		}
+	}
+
+	// Sign if requested (before push to ensure no unsigned images reach registry).
+	// The signature covers the digest, which is known before the image is pushed.
+	if b.options.Sign && b.options.NoPush {
+		fmt.Fprintf(b.out(), "Skipping signing: nothing is pushed with --no-push\n")
+	} else if b.options.Sign {
 		var registryURL string
 		if b.options.Local {
 			registryURL = "localhost:5000"
 		} else {
 			registryURL = b.options.RegistryURL
 		}
-		imageRef := fmt.Sprintf("%s/%s:%s", registryURL, b.options.WorkloadName, b.options.Tag)
+		imageRef := fmt.Sprintf("%s/%s@%s", registryURL, b.options.WorkloadName, b.info.ImageDigest)
 
-		err = b.SignContainer(ctx, imageRef)
-		if err != nil {
+		if err := b.SignContainer(ctx, imageRef); err != nil {
 			return fmt.Errorf("signing failed: %w", err)
 		}
 	}
 
 	// Push if not disabled (only after successful signing, if requested)
 	if !b.options.NoPush {
-		err = b.PushContainer(ctx, img)
-		if err != nil {
+		if err := b.push(ctx, img); err != nil {
 			return fmt.Errorf("push failed: %w", err)
 		}
 	}
 
+	if b.options.AttachSBOM && b.options.NoPush {
+		fmt.Fprintf(b.out(), "Skipping SBOM attachment: nothing is pushed with --no-push\n")
+	} else if b.options.AttachSBOM {
+		if err := b.attachSBOMs(ctx, sboms); err != nil {
+			return fmt.Errorf("SBOM attachment failed: %w", err)
+		}
+	}
+
 	return nil
 }
 
-// ValidateLocalRegistry checks if a local registry is running
-func ValidateLocalRegistry(ctx context.Context) error {
+// ValidateLocalRegistry checks if a local registry is running, with the TLS
+// settings and credentials configured for it (nil authCfg reads anonymously)
+func ValidateLocalRegistry(ctx context.Context, registries RegistryTransports, authCfg *AuthConfig) error {
+	options, err := registries.CraneOptions(ctx, "localhost:5000")
+	if err != nil {
+		return err
+	}
+	if authenticator := pushAuthenticator(authCfg); authenticator != nil {
+		options = append(options, crane.WithAuth(authenticator))
+	}
+
 	// Try to connect to localhost:5000
-	_, err := crane.Catalog("localhost:5000", crane.WithContext(ctx))
+	_, err = crane.Catalog("localhost:5000", options...)
 	if err != nil {
+		if tip := tlsTip("localhost:5000", err); tip != "" {
+			return fmt.Errorf("local registry at localhost:5000 not accessible: %w\nTip: %s", err, tip)
+		}
 		return fmt.Errorf("local registry at localhost:5000 not accessible: %w\nTip: Start a local registry with: docker run -d -p 5000:5000 --name registry registry:2", err)
 	}
 	return nil
 }
+
+// ContextDigest returns a stable digest of the build context contents, used to
+// detect whether a workload changed since it was last pushed
+func ContextDigest(contextPath string) (string, error) {
+	hasher := sha256.New()
+
+	err := filepath.Walk(contextPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(contextPath, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hasher, "%s\x00%o\x00", filepath.ToSlash(relPath), info.Mode())
+
+		switch {
+		case info.Mode().IsRegular():
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			if _, err := io.Copy(hasher, file); err != nil {
+				return err
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			hasher.Write([]byte(target))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to digest build context %s: %w", contextPath, err)
+	}
+
+	return fmt.Sprintf("sha256:%x", hasher.Sum(nil)), nil
+}
+
+// BuildInfo returns details of the last build
+func (b *Builder) BuildInfo() BuildInfo {
+	return b.info
+}
+
+// ImageRef returns the full reference the builder pushes to
+func (b *Builder) ImageRef() string {
+	registryURL := b.options.RegistryURL
+	if b.options.Local {
+		registryURL = "localhost:5000"
+	}
+	return fmt.Sprintf("%s/%s:%s", registryURL, b.options.WorkloadName, b.options.Tag)
+}
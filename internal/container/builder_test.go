@@ -5,10 +5,17 @@ import (
 	"context"
 	"io"
 	"io/fs"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -50,7 +57,14 @@ func TestSignContainer_SkipWhenDisabled(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestSignContainer_PlaceholderWhenEnabled(t *testing.T) {
+func TestSignContainer_KeylessNeedsToken(t *testing.T) {
+	// No cosign key anywhere and no OIDC token, so keyless signing can't proceed
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("COSIGN_PRIVATE_KEY", "")
+	t.Setenv("GITHUB_ACTIONS", "")
+	t.Setenv("SIGSTORE_ID_TOKEN", "")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", "")
+
 	options := BuildOptions{
 		WorkloadName: "test-workload",
 		Sign:         true,
@@ -59,15 +73,15 @@ func TestSignContainer_PlaceholderWhenEnabled(t *testing.T) {
 	builder := NewBuilder(options)
 	ctx := context.Background()
 
-	err := builder.SignContainer(ctx, "test-image:latest")
+	err := builder.SignContainer(ctx, "test-image@sha256:0000000000000000000000000000000000000000000000000000000000000000")
 
-	assert.NoError(t, err)
+	assert.ErrorContains(t, err, "keyless signing needs an OIDC token")
 }
 
 func TestValidateLocalRegistry_NotRunning(t *testing.T) {
 	ctx := context.Background()
 
-	err := ValidateLocalRegistry(ctx)
+	err := ValidateLocalRegistry(ctx, nil, nil)
 
 	// Should fail since no local registry is running
 	assert.Error(t, err)
@@ -95,6 +109,20 @@ func TestBuildOptions_AuthConfig(t *testing.T) {
 	assert.Equal(t, "testtoken", builder.options.AuthConfig.Token)
 }
 
+func TestPushAuthenticator(t *testing.T) {
+	assert.Nil(t, pushAuthenticator(nil))
+
+	auth, err := pushAuthenticator(&AuthConfig{Username: "robot", Password: "secret"}).Authorization()
+	require.NoError(t, err)
+	assert.Equal(t, "secret", auth.Password)
+	assert.Empty(t, auth.RegistryToken)
+
+	auth, err = pushAuthenticator(&AuthConfig{Username: "robot", IdentityToken: "refresh"}).Authorization()
+	require.NoError(t, err)
+	assert.Equal(t, "refresh", auth.IdentityToken)
+	assert.Empty(t, auth.RegistryToken, "identity tokens aren't sent as bearer tokens")
+}
+
 func TestCreateLayerFromContext_EmptyContext(t *testing.T) {
 	options := BuildOptions{
 		ContextPath: "",
@@ -442,3 +470,111 @@ func TestCreateLayerFromContext_RealWorkloadStructure(t *testing.T) {
 	assert.Contains(t, foundFiles["systemd/caddy-work-app.service"], "Description=Caddy",
 		"Systemd service should contain expected content")
 }
+
+func TestContextDigest(t *testing.T) {
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "Containerfile"), []byte("FROM quay.io/fedora/fedora-bootc:42\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(tempDir, "scripts"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "scripts", "init.sh"), []byte("#!/bin/bash\n"), 0755))
+
+	first, err := ContextDigest(tempDir)
+	require.NoError(t, err)
+	assert.Regexp(t, `^sha256:[0-9a-f]{64}$`, first)
+
+	second, err := ContextDigest(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, first, second, "digest should be stable for unchanged contexts")
+
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "scripts", "init.sh"), []byte("#!/bin/bash\necho changed\n"), 0755))
+	third, err := ContextDigest(tempDir)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, third, "digest should change when file contents change")
+
+	_, err = ContextDigest(filepath.Join(tempDir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestImageRef(t *testing.T) {
+	builder := NewBuilder(BuildOptions{WorkloadName: "it-tools", Tag: "latest", RegistryURL: "ghcr.io/test"})
+	assert.Equal(t, "ghcr.io/test/it-tools:latest", builder.ImageRef())
+
+	builder = NewBuilder(BuildOptions{WorkloadName: "it-tools", Tag: "dev", RegistryURL: "ghcr.io/test", Local: true})
+	assert.Equal(t, "localhost:5000/it-tools:dev", builder.ImageRef())
+}
+
+func TestPushContainer_SkipExisting(t *testing.T) {
+	var manifestPuts int32
+	reg := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/manifests/") && r.Method == http.MethodPut {
+			atomic.AddInt32(&manifestPuts, 1)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	img, err := random.Image(64, 2)
+	require.NoError(t, err)
+
+	builder := NewBuilder(BuildOptions{
+		WorkloadName: "app",
+		Tag:          "latest",
+		RegistryURL:  host,
+		Registries:   RegistryTransports{host: {PlainHTTP: true}},
+		Performance:  machine.PerformanceConfig{PushConcurrency: 2, SkipExisting: true},
+	})
+
+	ctx := context.Background()
+	require.NoError(t, builder.PushContainer(ctx, img))
+	require.NoError(t, builder.PushContainer(ctx, img))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&manifestPuts), "unchanged image should not be pushed again")
+
+	changed, err := random.Image(64, 2)
+	require.NoError(t, err)
+	require.NoError(t, builder.PushContainer(ctx, changed))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&manifestPuts))
+}
+
+func TestBuildAndPush_RecordsBuildInfo(t *testing.T) {
+	host, _ := newTestRegistry(t, 0)
+
+	contextPath := t.TempDir()
+	containerfile := "FROM " + host + "/library/alpine:3\n"
+	require.NoError(t, os.WriteFile(filepath.Join(contextPath, "Containerfile"), []byte(containerfile), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(contextPath, "app.conf"), []byte("key=value\n"), 0644))
+
+	builder := NewBuilder(BuildOptions{
+		WorkloadName: "app",
+		ContextPath:  contextPath,
+		NoPush:       true,
+		Registries:   RegistryTransports{host: {PlainHTTP: true}},
+		Backend:      BackendSimple,
+	})
+	require.NoError(t, builder.BuildAndPush(context.Background()))
+
+	info := builder.BuildInfo()
+	assert.Equal(t, host+"/library/alpine:3", info.BaseImage)
+	assert.True(t, strings.HasPrefix(info.BaseImageDigest, "sha256:"))
+	assert.True(t, strings.HasPrefix(info.ImageDigest, "sha256:"))
+	assert.NotEqual(t, info.BaseImageDigest, info.ImageDigest)
+}
+
+func TestCheckPushPermission(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	registries := RegistryTransports{host: {PlainHTTP: true}}
+
+	require.NoError(t, CheckPushPermission(host+"/app", &AuthConfig{Token: "token"}, registries))
+	require.NoError(t, CheckPushPermission(host+"/app", nil, registries), "anonymous pushes are allowed by this registry")
+
+	denied := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer denied.Close()
+	deniedHost := strings.TrimPrefix(denied.URL, "http://")
+
+	err := CheckPushPermission(deniedHost+"/app", &AuthConfig{Token: "token"}, RegistryTransports{deniedHost: {PlainHTTP: true}})
+	assert.ErrorContains(t, err, "cannot push to "+deniedHost+"/app")
+}
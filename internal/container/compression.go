@@ -0,0 +1,244 @@
+package container
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+)
+
+// Layer compression for [container_registry.build] compression
+const (
+	CompressionGzip = "gzip" // Default; what the build tools produce
+	CompressionZstd = "zstd" // Smaller and faster to decompress; needs podman 4.x or containerd 1.5+ to pull
+)
+
+// ValidateLayerCompression checks a compression and estargz combination
+func ValidateLayerCompression(compression string, estargz bool) error {
+	switch compression {
+	case "", CompressionGzip:
+		return nil
+	case CompressionZstd:
+		if estargz {
+			return fmt.Errorf("estargz layers are gzip-compressed and can't be combined with zstd compression")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown layer compression %q: use gzip or zstd", compression)
+	}
+}
+
+// recompressLayers rewrites img's layers as zstd or eStargz when configured.
+// Each layer is written to the build directory once, so the digest that is
+// signed is the digest of the bytes that are pushed.
+func (b *Builder) recompressLayers(img v1.Image) (v1.Image, error) {
+	useEstargz := b.options.Estargz
+	if b.options.Compression != CompressionZstd && !useEstargz {
+		return img, nil
+	}
+	if err := b.ensureWorkDir(); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(b.workDir, "layers-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create layer directory: %w", err)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image layers: %w", err)
+	}
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image config: %w", err)
+	}
+
+	// zstd layers are only valid in OCI manifests
+	base := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	base = mutate.ConfigMediaType(base, types.OCIConfigJSON)
+	config := configFile.DeepCopy()
+	config.RootFS.DiffIDs = nil
+	config.History = nil
+	base, err = mutate.ConfigFile(base, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy image config: %w", err)
+	}
+
+	adds := make([]mutate.Addendum, 0, len(layers))
+	for i, layer := range layers {
+		path := filepath.Join(dir, fmt.Sprintf("layer-%d", i))
+		var add mutate.Addendum
+		if useEstargz {
+			add, err = estargzLayer(layer, path)
+		} else {
+			add, err = zstdLayer(layer, path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to recompress layer %d: %w", i, err)
+		}
+		adds = append(adds, add)
+	}
+
+	recompressed, err := mutate.Append(base, adds...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assemble recompressed image: %w", err)
+	}
+	// Append adds a history entry per layer; keep the build's own
+	config, err = recompressed.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image config: %w", err)
+	}
+	config = config.DeepCopy()
+	config.History = configFile.History
+	return mutate.ConfigFile(recompressed, config)
+}
+
+// zstdLayer writes layer to path compressed with zstd
+func zstdLayer(layer v1.Layer, path string) (mutate.Addendum, error) {
+	uncompressed, err := layer.Uncompressed()
+	if err != nil {
+		return mutate.Addendum{}, err
+	}
+	defer uncompressed.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return mutate.Addendum{}, err
+	}
+	defer f.Close()
+	encoder, err := zstd.NewWriter(f)
+	if err != nil {
+		return mutate.Addendum{}, err
+	}
+	if _, err := io.Copy(encoder, uncompressed); err != nil {
+		encoder.Close()
+		return mutate.Addendum{}, err
+	}
+	if err := encoder.Close(); err != nil {
+		return mutate.Addendum{}, err
+	}
+
+	compressed, err := tarball.LayerFromFile(path, tarball.WithMediaType(types.OCILayerZStd))
+	if err != nil {
+		return mutate.Addendum{}, err
+	}
+	return mutate.Addendum{Layer: compressed, MediaType: types.OCILayerZStd}, nil
+}
+
+// estargzLayer writes layer to path as eStargz, which snapshotters that
+// support lazy pulling can mount before the whole layer is downloaded
+func estargzLayer(layer v1.Layer, path string) (mutate.Addendum, error) {
+	uncompressed, err := layer.Uncompressed()
+	if err != nil {
+		return mutate.Addendum{}, err
+	}
+	defer uncompressed.Close()
+
+	// estargz needs random access to the tar to build its table of contents
+	tarFile, err := os.Create(path + ".tar")
+	if err != nil {
+		return mutate.Addendum{}, err
+	}
+	defer os.Remove(tarFile.Name())
+	defer tarFile.Close()
+	size, err := io.Copy(tarFile, uncompressed)
+	if err != nil {
+		return mutate.Addendum{}, err
+	}
+
+	blob, err := estargz.Build(io.NewSectionReader(tarFile, 0, size), estargz.WithCompression(estargzGzip{
+		GzipCompressor:   estargz.NewGzipCompressor(),
+		GzipDecompressor: &estargz.GzipDecompressor{},
+	}))
+	if err != nil {
+		return mutate.Addendum{}, err
+	}
+	defer blob.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return mutate.Addendum{}, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, blob); err != nil {
+		return mutate.Addendum{}, err
+	}
+
+	compressed, err := tarball.LayerFromFile(path, tarball.WithMediaType(types.OCILayer))
+	if err != nil {
+		return mutate.Addendum{}, err
+	}
+	return mutate.Addendum{
+		Layer:       compressed,
+		MediaType:   types.OCILayer,
+		Annotations: map[string]string{estargz.TOCJSONDigestAnnotation: blob.TOCDigest().String()},
+	}, nil
+}
+
+// estargzGzip is estargz's gzip compression with a footer that doesn't depend
+// on how compress/gzip encodes an empty stream; recent Go releases no longer
+// produce the 51 bytes estargz.GzipCompressor expects
+type estargzGzip struct {
+	*estargz.GzipCompressor
+	*estargz.GzipDecompressor
+}
+
+// WriteTOCAndFooter writes the table of contents as the blob's last tar entry,
+// then the footer pointing at it
+func (c estargzGzip) WriteTOCAndFooter(w io.Writer, off int64, toc *estargz.JTOC, diffHash hash.Hash) (digest.Digest, error) {
+	tocJSON, err := json.MarshalIndent(toc, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	gz, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	gw := io.Writer(gz)
+	if diffHash != nil {
+		gw = io.MultiWriter(gz, diffHash)
+	}
+	tw := tar.NewWriter(gw)
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: estargz.TOCTarName, Size: int64(len(tocJSON))}); err != nil {
+		return "", err
+	}
+	if _, err := tw.Write(tocJSON); err != nil {
+		return "", err
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	if _, err := w.Write(estargzFooter(off)); err != nil {
+		return "", err
+	}
+	return digest.FromBytes(tocJSON), nil
+}
+
+// estargzFooter is an empty gzip member whose extra field holds the TOC
+// offset, written by hand so it is always estargz.FooterSize bytes
+func estargzFooter(tocOffset int64) []byte {
+	subfield := fmt.Sprintf("%016xSTARGZ", tocOffset)
+	footer := []byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff} // gzip header with FEXTRA
+	footer = binary.LittleEndian.AppendUint16(footer, uint16(4+len(subfield)))
+	footer = append(footer, 'S', 'G')
+	footer = binary.LittleEndian.AppendUint16(footer, uint16(len(subfield)))
+	footer = append(footer, subfield...)
+	footer = append(footer, 1, 0, 0, 0xff, 0xff)  // final stored block, empty
+	return append(footer, 0, 0, 0, 0, 0, 0, 0, 0) // CRC-32 and size of no data
+}
@@ -0,0 +1,164 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateLayerCompression(t *testing.T) {
+	assert.NoError(t, ValidateLayerCompression("", false))
+	assert.NoError(t, ValidateLayerCompression(CompressionGzip, true))
+	assert.NoError(t, ValidateLayerCompression(CompressionZstd, false))
+	assert.ErrorContains(t, ValidateLayerCompression(CompressionZstd, true), "can't be combined with zstd")
+	assert.ErrorContains(t, ValidateLayerCompression("xz", false), `unknown layer compression "xz"`)
+}
+
+// writeTarArchive saves an image of two real tar layers as a docker archive,
+// as a build tool would
+func writeTarArchive(t *testing.T) (string, v1.Image) {
+	t.Helper()
+	var layers []v1.Layer
+	for _, files := range []map[string]string{
+		{"etc/app.conf": "key=value\n"},
+		{"usr/bin/app": strings.Repeat("binary ", 1000), "usr/share/app/README": "app\n"},
+	} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for name, content := range files {
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+			_, err := tw.Write([]byte(content))
+			require.NoError(t, err)
+		}
+		require.NoError(t, tw.Close())
+		layer, err := tarball.LayerFromReader(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		layers = append(layers, layer)
+	}
+	img, err := mutate.AppendLayers(empty.Image, layers...)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "built.tar")
+	tag, err := name.NewTag("localhost/iago-build/app:latest")
+	require.NoError(t, err)
+	require.NoError(t, tarball.WriteToFile(path, tag, img))
+	return path, img
+}
+
+func TestBuildContainer_Compression(t *testing.T) {
+	archive, original := writeTarArchive(t)
+	fakeTools(t, archive, BackendPodman)
+	originalConfig, err := original.ConfigFile()
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name      string
+		options   BuildOptions
+		mediaType types.MediaType
+	}{
+		{"zstd", BuildOptions{Compression: CompressionZstd}, types.OCILayerZStd},
+		{"estargz", BuildOptions{Estargz: true}, types.OCILayer},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			contextPath := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(contextPath, "Containerfile"), []byte("FROM scratch\n"), 0644))
+			options := tc.options
+			options.WorkloadName, options.ContextPath, options.Backend = "app", contextPath, BackendPodman
+
+			builder := NewBuilder(options)
+			img, err := builder.BuildContainer(context.Background())
+			require.NoError(t, err)
+			defer builder.cleanup()
+
+			manifest, err := img.Manifest()
+			require.NoError(t, err)
+			assert.Equal(t, types.OCIManifestSchema1, manifest.MediaType)
+			assert.Equal(t, types.OCIConfigJSON, manifest.Config.MediaType)
+			require.Len(t, manifest.Layers, 2)
+			for _, layer := range manifest.Layers {
+				assert.Equal(t, tc.mediaType, layer.MediaType)
+			}
+
+			config, err := img.ConfigFile()
+			require.NoError(t, err)
+			assert.Equal(t, originalConfig.History, config.History)
+			assert.Len(t, config.RootFS.DiffIDs, 2)
+
+			layers, err := img.Layers()
+			require.NoError(t, err)
+			uncompressed, err := layers[0].Uncompressed()
+			require.NoError(t, err)
+			contents, err := io.ReadAll(uncompressed)
+			require.NoError(t, err)
+			assert.Contains(t, string(contents), "key=value")
+
+			if tc.options.Estargz {
+				assert.Contains(t, string(contents), estargz.TOCTarName)
+				assert.NotEmpty(t, manifest.Layers[0].Annotations[estargz.TOCJSONDigestAnnotation])
+			} else {
+				assert.Equal(t, originalConfig.RootFS.DiffIDs, config.RootFS.DiffIDs, "zstd keeps the layer contents")
+			}
+
+			first, err := img.Digest()
+			require.NoError(t, err)
+			second, err := img.Digest()
+			require.NoError(t, err)
+			assert.Equal(t, first, second)
+		})
+	}
+}
+
+func TestBuildAndPush_CompressedDigestMatchesPush(t *testing.T) {
+	archive, _ := writeTarArchive(t)
+	fakeTools(t, archive, BackendPodman)
+	server := httptest.NewServer(registry.New())
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	contextPath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(contextPath, "Containerfile"), []byte("FROM scratch\n"), 0644))
+	builder := NewBuilder(BuildOptions{
+		WorkloadName: "app",
+		ContextPath:  contextPath,
+		Tag:          "latest",
+		RegistryURL:  host,
+		Registries:   RegistryTransports{host: {PlainHTTP: true}},
+		Backend:      BackendPodman,
+		Compression:  CompressionZstd,
+	})
+	require.NoError(t, builder.BuildAndPush(context.Background()))
+
+	ref, err := name.ParseReference(host+"/app:latest", name.Insecure)
+	require.NoError(t, err)
+	desc, err := remote.Head(ref)
+	require.NoError(t, err)
+	assert.Equal(t, builder.BuildInfo().ImageDigest, desc.Digest.String(), "the digest that is signed is the one pushed")
+	assert.Equal(t, types.OCIManifestSchema1, desc.MediaType)
+}
+
+func TestEstargzFooter(t *testing.T) {
+	footer := estargzFooter(0x1234)
+	require.Len(t, footer, estargz.FooterSize)
+
+	_, tocOffset, _, err := (&estargz.GzipDecompressor{}).ParseFooter(footer)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0x1234), tocOffset)
+}
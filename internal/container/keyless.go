@@ -0,0 +1,339 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/payload"
+)
+
+// Public-good Sigstore instances used for keyless signing
+const (
+	DefaultFulcioURL = "https://fulcio.sigstore.dev"
+	DefaultRekorURL  = "https://rekor.sigstore.dev"
+)
+
+// Annotations cosign adds to keyless signature layers
+const (
+	certificateAnnotation = "dev.sigstore.cosign/certificate"
+	chainAnnotation       = "dev.sigstore.cosign/chain"
+	bundleAnnotation      = "dev.sigstore.cosign/bundle"
+)
+
+// sigstoreAudience is the audience Fulcio accepts identity tokens for
+const sigstoreAudience = "sigstore"
+
+// keylessClient talks to the OIDC token endpoint, Fulcio and Rekor
+var keylessClient = &http.Client{Timeout: 30 * time.Second}
+
+// keylessSigner carries out cosign's keyless flow: an ephemeral key,
+// certified by Fulcio for the workflow's OIDC identity, whose signature is
+// recorded in Rekor
+type keylessSigner struct {
+	fulcioURL string
+	rekorURL  string
+	out       io.Writer
+}
+
+func newKeylessSigner(fulcioURL, rekorURL string) *keylessSigner {
+	if fulcioURL == "" {
+		fulcioURL = DefaultFulcioURL
+	}
+	if rekorURL == "" {
+		rekorURL = DefaultRekorURL
+	}
+	return &keylessSigner{
+		fulcioURL: strings.TrimSuffix(fulcioURL, "/"),
+		rekorURL:  strings.TrimSuffix(rekorURL, "/"),
+		out:       os.Stdout,
+	}
+}
+
+// sign signs ref's digest and appends the signature, certificate and Rekor
+// bundle to the image's .sig tag, as `cosign sign` does
+func (k *keylessSigner) sign(ctx context.Context, ref name.Digest, options []remote.Option) error {
+	token, err := identityToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	signer, err := signature.LoadECDSASignerVerifier(privateKey, crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("failed to load ephemeral key: %w", err)
+	}
+
+	certPEM, chainPEM, err := k.requestCertificate(ctx, token, privateKey)
+	if err != nil {
+		return err
+	}
+
+	signedPayload, err := payload.Cosign{Image: ref}.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to create signature payload: %w", err)
+	}
+	sig, err := signer.SignMessage(bytes.NewReader(signedPayload))
+	if err != nil {
+		return fmt.Errorf("failed to sign %s: %w", ref, err)
+	}
+
+	bundle, err := k.uploadToRekor(ctx, signedPayload, sig, certPEM)
+	if err != nil {
+		return err
+	}
+
+	annotations := map[string]string{
+		certificateAnnotation: certPEM,
+		bundleAnnotation:      bundle,
+	}
+	if chainPEM != "" {
+		annotations[chainAnnotation] = chainPEM
+	}
+	return appendSignature(ref, signedPayload, sig, annotations, options)
+}
+
+// identityToken returns an OIDC token for Fulcio: SIGSTORE_ID_TOKEN when set,
+// otherwise one requested from GitHub Actions (the workflow needs
+// `permissions: id-token: write`)
+func identityToken(ctx context.Context) (string, error) {
+	if token := os.Getenv("SIGSTORE_ID_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	requestURL := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL")
+	requestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if requestURL == "" || requestToken == "" {
+		if isGitHubActions() {
+			return "", fmt.Errorf("keyless signing needs an OIDC token: add `permissions: id-token: write` to the workflow")
+		}
+		return "", fmt.Errorf("keyless signing needs an OIDC token: run in GitHub Actions, set SIGSTORE_ID_TOKEN, or sign with a key (--cosign-key)")
+	}
+
+	tokenURL, err := url.Parse(requestURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid ACTIONS_ID_TOKEN_REQUEST_URL: %w", err)
+	}
+	query := tokenURL.Query()
+	query.Set("audience", sigstoreAudience)
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create OIDC token request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+requestToken)
+
+	var response struct {
+		Value string `json:"value"`
+	}
+	if err := doJSON(req, &response); err != nil {
+		return "", fmt.Errorf("failed to get GitHub Actions OIDC token: %w", err)
+	}
+	if response.Value == "" {
+		return "", fmt.Errorf("failed to get GitHub Actions OIDC token: empty response")
+	}
+	return response.Value, nil
+}
+
+// tokenSubject returns the identity Fulcio certifies: a verified email, or the sub claim
+func tokenSubject(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("OIDC token is not a JWT")
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("failed to decode OIDC token claims: %w", err)
+	}
+	var claims struct {
+		Subject       string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return "", fmt.Errorf("failed to parse OIDC token claims: %w", err)
+	}
+	if claims.Email != "" && claims.EmailVerified {
+		return claims.Email, nil
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("OIDC token has no subject")
+	}
+	return claims.Subject, nil
+}
+
+// requestCertificate asks Fulcio to certify the ephemeral public key for the
+// token's identity and returns the leaf certificate and the rest of the chain as PEM
+func (k *keylessSigner) requestCertificate(ctx context.Context, token string, privateKey *ecdsa.PrivateKey) (string, string, error) {
+	subject, err := tokenSubject(token)
+	if err != nil {
+		return "", "", err
+	}
+	subjectHash := sha256.Sum256([]byte(subject))
+	proof, err := ecdsa.SignASN1(rand.Reader, privateKey, subjectHash[:])
+	if err != nil {
+		return "", "", fmt.Errorf("failed to sign proof of possession: %w", err)
+	}
+	publicKeyPEM, err := cryptoutils.MarshalPublicKeyToPEM(privateKey.Public())
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode ephemeral public key: %w", err)
+	}
+
+	request := map[string]any{
+		"credentials": map[string]string{"oidcIdentityToken": token},
+		"publicKeyRequest": map[string]any{
+			"publicKey":         map[string]string{"algorithm": "ECDSA", "content": string(publicKeyPEM)},
+			"proofOfPossession": base64.StdEncoding.EncodeToString(proof),
+		},
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode certificate request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.fulcioURL+"/api/v2/signingCert", bytes.NewReader(body))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create certificate request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	type chain struct {
+		Chain struct {
+			Certificates []string `json:"certificates"`
+		} `json:"chain"`
+	}
+	var response struct {
+		Embedded *chain `json:"signedCertificateEmbeddedSct"`
+		Detached *chain `json:"signedCertificateDetachedSct"`
+	}
+	if err := doJSON(req, &response); err != nil {
+		return "", "", fmt.Errorf("failed to get signing certificate from %s: %w", k.fulcioURL, err)
+	}
+
+	var certificates []string
+	switch {
+	case response.Embedded != nil:
+		certificates = response.Embedded.Chain.Certificates
+	case response.Detached != nil:
+		certificates = response.Detached.Chain.Certificates
+	}
+	if len(certificates) == 0 {
+		return "", "", fmt.Errorf("fulcio at %s returned no certificate", k.fulcioURL)
+	}
+	if err := checkCertifiedKey(certificates[0], privateKey); err != nil {
+		return "", "", err
+	}
+	return certificates[0], strings.Join(certificates[1:], ""), nil
+}
+
+// checkCertifiedKey makes sure Fulcio certified our key and not some other one
+func checkCertifiedKey(certPEM string, privateKey *ecdsa.PrivateKey) error {
+	certs, err := cryptoutils.UnmarshalCertificatesFromPEM([]byte(certPEM))
+	if err != nil || len(certs) == 0 {
+		return fmt.Errorf("fulcio returned an invalid certificate: %v", err)
+	}
+	if err := cryptoutils.EqualKeys(certs[0].PublicKey, privateKey.Public()); err != nil {
+		return fmt.Errorf("fulcio certificate is for a different key: %w", err)
+	}
+	return nil
+}
+
+// rekorEntry is one entry in Rekor's response to a new log entry
+type rekorEntry struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+	Verification   struct {
+		SignedEntryTimestamp string `json:"signedEntryTimestamp"`
+	} `json:"verification"`
+}
+
+// uploadToRekor records the signature in the transparency log and returns the
+// bundle cosign stores with the signature for offline verification
+func (k *keylessSigner) uploadToRekor(ctx context.Context, signedPayload, sig []byte, certPEM string) (string, error) {
+	payloadHash := sha256.Sum256(signedPayload)
+	entry := map[string]any{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]any{
+			"signature": map[string]any{
+				"content":   base64.StdEncoding.EncodeToString(sig),
+				"publicKey": map[string]string{"content": base64.StdEncoding.EncodeToString([]byte(certPEM))},
+			},
+			"data": map[string]any{
+				"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(payloadHash[:])},
+			},
+		},
+	}
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode transparency log entry: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.rekorURL+"/api/v1/log/entries", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create transparency log request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var response map[string]rekorEntry
+	if err := doJSON(req, &response); err != nil {
+		return "", fmt.Errorf("failed to upload to transparency log %s: %w", k.rekorURL, err)
+	}
+	for uuid, logged := range response {
+		fmt.Fprintf(k.out, "Recorded in transparency log %s (index %d, entry %s)\n", k.rekorURL, logged.LogIndex, uuid)
+		bundle, err := json.Marshal(map[string]any{
+			"SignedEntryTimestamp": logged.Verification.SignedEntryTimestamp,
+			"Payload": map[string]any{
+				"body":           logged.Body,
+				"integratedTime": logged.IntegratedTime,
+				"logIndex":       logged.LogIndex,
+				"logID":          logged.LogID,
+			},
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to encode transparency log bundle: %w", err)
+		}
+		return string(bundle), nil
+	}
+	return "", fmt.Errorf("transparency log %s returned no entry", k.rekorURL)
+}
+
+// doJSON sends req and decodes a JSON response, turning error statuses into errors
+func doJSON(req *http.Request, out any) error {
+	resp, err := keylessClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, out)
+}
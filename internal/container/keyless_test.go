@@ -0,0 +1,240 @@
+package container
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testJWT(claims map[string]any) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`))
+	body, _ := json.Marshal(claims)
+	return header + "." + base64.RawURLEncoding.EncodeToString(body) + ".sig"
+}
+
+// newFakeFulcio issues certificates from a throwaway CA for whatever key is
+// presented, after checking the proof of possession over the token subject
+func newFakeFulcio(t *testing.T, subject string) *httptest.Server {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-fulcio"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+	caPEM, err := cryptoutils.MarshalCertificateToPEM(caCert)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			PublicKeyRequest struct {
+				PublicKey struct {
+					Content string `json:"content"`
+				} `json:"publicKey"`
+				ProofOfPossession string `json:"proofOfPossession"`
+			} `json:"publicKeyRequest"`
+		}
+		if r.URL.Path != "/api/v2/signingCert" || json.NewDecoder(r.Body).Decode(&request) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		publicKey, err := cryptoutils.UnmarshalPEMToPublicKey([]byte(request.PublicKeyRequest.PublicKey.Content))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		proof, _ := base64.StdEncoding.DecodeString(request.PublicKeyRequest.ProofOfPossession)
+		subjectHash := sha256.Sum256([]byte(subject))
+		if !ecdsa.VerifyASN1(publicKey.(*ecdsa.PublicKey), subjectHash[:], proof) {
+			http.Error(w, "invalid proof of possession", http.StatusBadRequest)
+			return
+		}
+
+		leaf := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(10 * time.Minute),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+		}
+		leafDER, err := x509.CreateCertificate(rand.Reader, leaf, caCert, publicKey, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		leafCert, _ := x509.ParseCertificate(leafDER)
+		leafPEM, _ := cryptoutils.MarshalCertificateToPEM(leafCert)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"signedCertificateEmbeddedSct": map[string]any{
+				"chain": map[string]any{"certificates": []string{string(leafPEM), string(caPEM)}},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newFakeRekor accepts hashedrekord entries and counts them
+func newFakeRekor(t *testing.T, uploads *int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var entry struct {
+			Kind string `json:"kind"`
+		}
+		if r.URL.Path != "/api/v1/log/entries" || json.Unmarshal(body, &entry) != nil || entry.Kind != "hashedrekord" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		*uploads++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{
+			"24296fb24b8ad77a": map[string]any{
+				"body":           base64.StdEncoding.EncodeToString(body),
+				"integratedTime": 1700000000,
+				"logID":          "c0d23d6ad406973f",
+				"logIndex":       42,
+				"verification":   map[string]any{"signedEntryTimestamp": "c2V0"},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSignContainer_KeylessGitHubActions(t *testing.T) {
+	host, registries := newSigningRegistry(t)
+	subject := "https://github.com/andreweick/iago/.github/workflows/build.yml@refs/heads/main"
+	token := testJWT(map[string]any{"sub": "repo:andreweick/iago:ref:refs/heads/main", "email": subject, "email_verified": true})
+
+	oidc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer request-token" || r.URL.Query().Get("audience") != "sigstore" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"value": token})
+	}))
+	t.Cleanup(oidc.Close)
+	uploads := 0
+	fulcio := newFakeFulcio(t, subject)
+	rekor := newFakeRekor(t, &uploads)
+
+	t.Setenv("GITHUB_ACTIONS", "true")
+	t.Setenv("SIGSTORE_ID_TOKEN", "")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", oidc.URL+"/token?api-version=2.0")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", "request-token")
+
+	img, err := random.Image(64, 1)
+	require.NoError(t, err)
+	ref, err := name.ParseReference(host+"/app:latest", name.Insecure)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img))
+	digest, err := img.Digest()
+	require.NoError(t, err)
+
+	builder := NewBuilder(BuildOptions{Sign: true, Registries: registries, FulcioURL: fulcio.URL, RekorURL: rekor.URL})
+	require.NoError(t, builder.SignContainer(context.Background(), host+"/app:latest"))
+	assert.Equal(t, 1, uploads)
+
+	signatures, err := remote.Image(signatureTag(ref.Context(), digest))
+	require.NoError(t, err)
+	manifest, err := signatures.Manifest()
+	require.NoError(t, err)
+	require.Len(t, manifest.Layers, 1)
+	annotations := manifest.Layers[0].Annotations
+	assert.Contains(t, annotations[chainAnnotation], "BEGIN CERTIFICATE")
+	assert.Contains(t, annotations[bundleAnnotation], `"logIndex":42`)
+
+	// The signature verifies with the key in the Fulcio certificate
+	certs, err := cryptoutils.UnmarshalCertificatesFromPEM([]byte(annotations[certificateAnnotation]))
+	require.NoError(t, err)
+	require.Len(t, certs, 1)
+	verifier, err := signature.LoadVerifier(certs[0].PublicKey, crypto.SHA256)
+	require.NoError(t, err)
+	verified, err := VerifyImage(ref, verifier, nil)
+	require.NoError(t, err)
+	assert.Equal(t, digest, verified)
+}
+
+func TestIdentityToken(t *testing.T) {
+	t.Run("explicit token wins", func(t *testing.T) {
+		t.Setenv("SIGSTORE_ID_TOKEN", "explicit")
+		t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", "http://127.0.0.1:1")
+		token, err := identityToken(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "explicit", token)
+	})
+
+	t.Run("GitHub Actions without id-token permission", func(t *testing.T) {
+		t.Setenv("SIGSTORE_ID_TOKEN", "")
+		t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", "")
+		t.Setenv("GITHUB_ACTIONS", "true")
+		_, err := identityToken(context.Background())
+		assert.ErrorContains(t, err, "id-token: write")
+	})
+}
+
+func TestTokenSubject(t *testing.T) {
+	subject, err := tokenSubject(testJWT(map[string]any{"sub": "repo:a/b:ref:refs/heads/main"}))
+	require.NoError(t, err)
+	assert.Equal(t, "repo:a/b:ref:refs/heads/main", subject)
+
+	subject, err = tokenSubject(testJWT(map[string]any{"sub": "123", "email": "dev@example.com", "email_verified": true}))
+	require.NoError(t, err)
+	assert.Equal(t, "dev@example.com", subject)
+
+	subject, err = tokenSubject(testJWT(map[string]any{"sub": "123", "email": "dev@example.com"}))
+	require.NoError(t, err)
+	assert.Equal(t, "123", subject, "unverified email isn't used")
+
+	_, err = tokenSubject("not-a-jwt")
+	assert.Error(t, err)
+}
+
+func TestCheckCertifiedKey_RejectsOtherKey(t *testing.T) {
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherCert := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, otherCert, otherCert, otherKey.Public(), otherKey)
+	require.NoError(t, err)
+	parsed, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	certPEM, err := cryptoutils.MarshalCertificateToPEM(parsed)
+	require.NoError(t, err)
+
+	ourKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	assert.ErrorContains(t, checkCertifiedKey(string(certPEM), ourKey), "different key")
+}
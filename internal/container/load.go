@@ -0,0 +1,231 @@
+package container
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// Image sources for PushFrom, named as skopeo names its transports
+const (
+	SourceDockerDaemon      = "docker-daemon"      // An image in the docker daemon, e.g. docker-daemon:web:dev
+	SourceContainersStorage = "containers-storage" // An image in podman/buildah storage, e.g. containers-storage:localhost/web:dev
+	SourceDockerArchive     = "docker-archive"     // A docker save tarball
+	SourceOCIArchive        = "oci-archive"        // A tarball of an OCI layout, e.g. from podman save --format oci-archive
+	SourceOCI               = "oci"                // An OCI layout directory
+)
+
+// AnnotationBaseName is the OCI annotation podman and buildah set to the
+// image a build started FROM
+const AnnotationBaseName = "org.opencontainers.image.base.name"
+
+// ParseSource splits an image source into its transport and reference. A
+// path without a transport is an OCI layout directory, or a docker or OCI
+// archive.
+func ParseSource(source string) (transport, reference string, err error) {
+	transport, reference, ok := strings.Cut(source, ":")
+	switch {
+	case ok && reference != "" && (transport == SourceDockerDaemon || transport == SourceContainersStorage ||
+		transport == SourceDockerArchive || transport == SourceOCIArchive || transport == SourceOCI):
+		return transport, reference, nil
+	case source == "":
+		return "", "", fmt.Errorf("no image source given")
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", "", fmt.Errorf("unknown image source %q: use docker-daemon:, containers-storage:, docker-archive:, oci-archive: or oci:", source)
+	}
+	if info.IsDir() {
+		return SourceOCI, source, nil
+	}
+	oci, err := isOCIArchive(source)
+	if err != nil {
+		return "", "", err
+	}
+	if oci {
+		return SourceOCIArchive, source, nil
+	}
+	return SourceDockerArchive, source, nil
+}
+
+// loadImage reads the image at source, exporting it from the docker daemon
+// or container storage into the work directory first
+func (b *Builder) loadImage(ctx context.Context, source string) (artifact, error) {
+	transport, reference, err := ParseSource(source)
+	if err != nil {
+		return nil, err
+	}
+
+	switch transport {
+	case SourceDockerDaemon, SourceContainersStorage:
+		if err := b.ensureWorkDir(); err != nil {
+			return nil, err
+		}
+		tool, export := BackendDocker, []string{"save", "--output"}
+		if transport == SourceContainersStorage {
+			tool, export = BackendPodman, []string{"save", "--format", "docker-archive", "--output"}
+		}
+		if _, err := lookPath(tool); err != nil {
+			return nil, fmt.Errorf("%s is needed to read %s: images: %w", tool, transport, err)
+		}
+		archive := filepath.Join(b.workDir, "image.tar")
+		if err := runTool(ctx, b.out(), b.errOut(), nil, tool, append(export, archive, reference)...); err != nil {
+			return nil, err
+		}
+		return loadDockerArchive(archive)
+	case SourceDockerArchive:
+		return loadDockerArchive(reference)
+	case SourceOCIArchive:
+		if err := b.ensureWorkDir(); err != nil {
+			return nil, err
+		}
+		dir := filepath.Join(b.workDir, "oci")
+		if err := extractTar(reference, dir); err != nil {
+			return nil, err
+		}
+		return loadOCILayout(dir)
+	default:
+		return loadOCILayout(reference)
+	}
+}
+
+// loadDockerArchive reads the single image in a docker save tarball
+func loadDockerArchive(path string) (v1.Image, error) {
+	img, err := tarball.ImageFromPath(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image from %s: %w", path, err)
+	}
+	return img, nil
+}
+
+// loadOCILayout reads an OCI layout: its image when it holds one, otherwise
+// its index, which is pushed with every image it lists
+func loadOCILayout(dir string) (artifact, error) {
+	index, err := layout.ImageIndexFromPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCI layout %s: %w", dir, err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCI layout %s: %w", dir, err)
+	}
+	if len(manifest.Manifests) == 1 && manifest.Manifests[0].MediaType.IsImage() {
+		img, err := index.Image(manifest.Manifests[0].Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image in %s: %w", dir, err)
+		}
+		return img, nil
+	}
+	if len(manifest.Manifests) == 1 && manifest.Manifests[0].MediaType.IsIndex() {
+		child, err := index.ImageIndex(manifest.Manifests[0].Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image index in %s: %w", dir, err)
+		}
+		return child, nil
+	}
+	return index, nil
+}
+
+// isOCIArchive reports whether the tarball at path holds an OCI layout
+func isOCIArchive(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if filepath.Clean(header.Name) == "oci-layout" {
+			return true, nil
+		}
+	}
+}
+
+// extractTar unpacks the regular files and directories of a tarball into dir
+func extractTar(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if !filepath.IsLocal(header.Name) {
+			return fmt.Errorf("%s: entry %s is outside the archive", path, header.Name)
+		}
+		target := filepath.Join(dir, header.Name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", target, err)
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", filepath.Dir(target), err)
+			}
+			out, err := os.Create(target)
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", target, err)
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return fmt.Errorf("failed to extract %s: %w", header.Name, err)
+			}
+		}
+	}
+}
+
+// checkSourceBaseImage checks the base image an image loaded from source
+// records against the allowlist. An image that doesn't record one, as docker
+// builds don't, can't be shown to be allowed and is refused like one that isn't.
+func (b *Builder) checkSourceBaseImage(source string, img artifact) error {
+	raw, err := img.RawManifest()
+	if err != nil {
+		return fmt.Errorf("failed to read manifest of %s: %w", source, err)
+	}
+	var manifest struct {
+		Annotations map[string]string `json:"annotations"`
+	}
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return fmt.Errorf("failed to parse manifest of %s: %w", source, err)
+	}
+	base := manifest.Annotations[AnnotationBaseName]
+	b.info.BaseImage = base
+	if b.options.BaseImages.IsZero() {
+		return nil
+	}
+	if base == "" {
+		return fmt.Errorf("%s doesn't record its base image (%s), so [container_registry.build.base_images] can't allow it", source, AnnotationBaseName)
+	}
+	if !b.options.BaseImages.Allows(base) {
+		return fmt.Errorf("%s was built FROM %s, which [container_registry.build.base_images] doesn't allow", source, base)
+	}
+	return nil
+}
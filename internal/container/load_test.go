@@ -0,0 +1,145 @@
+package container
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeOCIArchive writes a random image as an OCI layout and tars it up,
+// returning the layout, the tarball and the image digest
+func writeOCIArchive(t *testing.T) (dir, archive, digest string) {
+	t.Helper()
+	img, err := random.Image(64, 1)
+	require.NoError(t, err)
+	hash, err := img.Digest()
+	require.NoError(t, err)
+
+	dir = filepath.Join(t.TempDir(), "layout")
+	path, err := layout.Write(dir, empty.Index)
+	require.NoError(t, err)
+	require.NoError(t, path.AppendImage(img))
+
+	archive = filepath.Join(t.TempDir(), "image.oci.tar")
+	f, err := os.Create(archive)
+	require.NoError(t, err)
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	require.NoError(t, tw.AddFS(os.DirFS(dir)))
+	require.NoError(t, tw.Close())
+	return dir, archive, hash.String()
+}
+
+func TestParseSource(t *testing.T) {
+	dockerArchive, _ := writeArchive(t)
+	dir, ociArchive, _ := writeOCIArchive(t)
+
+	for source, want := range map[string][2]string{
+		"containers-storage:localhost/web:dev": {SourceContainersStorage, "localhost/web:dev"},
+		"docker-daemon:web:dev":                {SourceDockerDaemon, "web:dev"},
+		"oci:" + dir:                           {SourceOCI, dir},
+		dir:                                    {SourceOCI, dir},
+		dockerArchive:                          {SourceDockerArchive, dockerArchive},
+		ociArchive:                             {SourceOCIArchive, ociArchive},
+	} {
+		transport, reference, err := ParseSource(source)
+		require.NoError(t, err, source)
+		assert.Equal(t, want, [2]string{transport, reference}, source)
+	}
+
+	_, _, err := ParseSource("web:dev")
+	assert.ErrorContains(t, err, "unknown image source")
+}
+
+func TestBuilder_PushFrom(t *testing.T) {
+	host, _ := newTestRegistry(t, 0)
+	dockerArchive, dockerDigest := writeArchive(t)
+	dir, ociArchive, ociDigest := writeOCIArchive(t)
+	fakeTools(t, dockerArchive, BackendPodman)
+
+	for _, tc := range []struct {
+		source, digest string
+	}{
+		{"containers-storage:localhost/web:dev", dockerDigest},
+		{"docker-archive:" + dockerArchive, dockerDigest},
+		{"oci:" + dir, ociDigest},
+		{ociArchive, ociDigest},
+	} {
+		var out strings.Builder
+		builder := NewBuilder(BuildOptions{
+			WorkloadName: "web",
+			Tag:          "dev",
+			RegistryURL:  host + "/homelab",
+			Registries:   RegistryTransports{host: {PlainHTTP: true}},
+			Output:       &out,
+		})
+		require.NoError(t, builder.PushFrom(context.Background(), tc.source), tc.source)
+		assert.Equal(t, tc.digest, builder.BuildInfo().ImageDigest, tc.source)
+
+		ref, err := name.ParseReference(host+"/homelab/web:dev", name.Insecure)
+		require.NoError(t, err)
+		desc, err := remote.Head(ref)
+		require.NoError(t, err)
+		assert.Equal(t, tc.digest, desc.Digest.String(), tc.source)
+	}
+}
+
+func TestBuilder_PushFrom_BaseImages(t *testing.T) {
+	host, _ := newTestRegistry(t, 0)
+	writeLayout := func(base string) string {
+		img, err := random.Image(64, 1)
+		require.NoError(t, err)
+		if base != "" {
+			img = mutate.Annotations(img, map[string]string{AnnotationBaseName: base}).(v1.Image)
+		}
+		dir := filepath.Join(t.TempDir(), "layout")
+		path, err := layout.Write(dir, empty.Index)
+		require.NoError(t, err)
+		require.NoError(t, path.AppendImage(img))
+		return dir
+	}
+	push := func(dir string, policy machine.BaseImagePolicy) (string, error) {
+		var out strings.Builder
+		builder := NewBuilder(BuildOptions{
+			WorkloadName: "web",
+			Tag:          "dev",
+			RegistryURL:  host + "/homelab",
+			Registries:   RegistryTransports{host: {PlainHTTP: true}},
+			BaseImages:   policy,
+			Output:       &out,
+		})
+		err := builder.PushFrom(context.Background(), "oci:"+dir)
+		return out.String(), err
+	}
+	enforce := machine.BaseImagePolicy{Allow: []string{"quay.io/fedora"}}
+
+	_, err := push(writeLayout("quay.io/fedora/fedora:41"), enforce)
+	assert.NoError(t, err)
+
+	_, err = push(writeLayout("docker.io/library/alpine:3"), enforce)
+	assert.ErrorContains(t, err, "built FROM docker.io/library/alpine:3")
+
+	_, err = push(writeLayout(""), enforce)
+	assert.ErrorContains(t, err, "doesn't record its base image")
+
+	out, err := push(writeLayout(""), machine.BaseImagePolicy{Allow: enforce.Allow, Mode: machine.BaseImageModeWarn})
+	assert.NoError(t, err)
+	assert.Contains(t, out, "doesn't record its base image")
+
+	_, err = push(writeLayout(""), machine.BaseImagePolicy{})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,148 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// cosignSuffixes are the tags cosign attaches to an image digest: signatures,
+// attestations and SBOMs
+var cosignSuffixes = []string{"sig", "att", "sbom"}
+
+// MirrorOptions configures copying images to a backup registry
+type MirrorOptions struct {
+	Registries RegistryTransports
+	SourceAuth *AuthConfig // nil uses docker/podman login credentials, or anonymous access
+	TargetAuth *AuthConfig
+	RateLimit  *RateLimiter
+	DryRun     bool // Report what would be copied without writing anything
+}
+
+// MirrorResult is one image copied to the backup registry
+type MirrorResult struct {
+	Source    string
+	Target    string
+	Digest    string
+	Artifacts []string // Cosign tags copied alongside, e.g. sha256-<hex>.sig
+	UpToDate  bool     // The target already had this digest
+}
+
+// MirrorTarget returns where image is copied under backup: the same repository
+// path and tag on the backup registry (and optional path prefix), e.g.
+// ghcr.io/me/web:latest -> backup.lan:5000/me/web:latest
+func MirrorTarget(image, backup string, registries RegistryTransports) (name.Tag, error) {
+	ref, err := registries.ParseReference(image)
+	if err != nil {
+		return name.Tag{}, fmt.Errorf("invalid image reference %s: %w", image, err)
+	}
+	tag, ok := ref.(name.Tag)
+	if !ok {
+		return name.Tag{}, fmt.Errorf("%s is pinned by digest; mirror copies tagged images", image)
+	}
+	target := strings.TrimSuffix(backup, "/") + "/" + ref.Context().RepositoryStr() + ":" + tag.TagStr()
+	parsed, err := registries.ParseReference(target)
+	if err != nil {
+		return name.Tag{}, fmt.Errorf("invalid mirror target %s: %w", target, err)
+	}
+	return parsed.(name.Tag), nil
+}
+
+// Mirror copies image to target byte for byte, so its digest (and every
+// signature made over it) stays valid, along with its cosign signatures,
+// attestations and SBOMs. Multi-platform indexes are copied whole.
+func Mirror(ctx context.Context, image string, target name.Tag, opts MirrorOptions) (MirrorResult, error) {
+	result := MirrorResult{Source: image, Target: target.String()}
+
+	source, err := opts.Registries.ParseReference(image)
+	if err != nil {
+		return result, fmt.Errorf("invalid image reference %s: %w", image, err)
+	}
+	sourceOptions, err := opts.remoteOptions(ctx, source.Context().Registry, opts.SourceAuth)
+	if err != nil {
+		return result, err
+	}
+	targetOptions, err := opts.remoteOptions(ctx, target.Context().Registry, opts.TargetAuth)
+	if err != nil {
+		return result, err
+	}
+
+	desc, err := remote.Get(source, sourceOptions...)
+	if err != nil {
+		return result, fmt.Errorf("failed to read %s: %w", image, err)
+	}
+	result.Digest = desc.Digest.String()
+
+	if existing, err := remote.Head(target, targetOptions...); err == nil && existing.Digest == desc.Digest {
+		result.UpToDate = true
+	} else if !opts.DryRun {
+		if err := copyDescriptor(desc, target, targetOptions); err != nil {
+			return result, fmt.Errorf("failed to copy %s to %s: %w", image, target, err)
+		}
+	}
+
+	for _, suffix := range cosignSuffixes {
+		tagName := fmt.Sprintf("%s-%s.%s", desc.Digest.Algorithm, desc.Digest.Hex, suffix)
+		artifact, err := remote.Get(source.Context().Tag(tagName), sourceOptions...)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to read %s of %s: %w", tagName, image, err)
+		}
+		result.Artifacts = append(result.Artifacts, tagName)
+		if opts.DryRun {
+			continue
+		}
+		// Signatures are appended to over time, so the tag is always re-copied
+		if err := copyDescriptor(artifact, target.Context().Tag(tagName), targetOptions); err != nil {
+			return result, fmt.Errorf("failed to copy %s of %s: %w", tagName, image, err)
+		}
+	}
+
+	if !opts.DryRun {
+		copied, err := remote.Head(target, targetOptions...)
+		if err != nil {
+			return result, fmt.Errorf("failed to check %s: %w", target, err)
+		}
+		if copied.Digest != desc.Digest {
+			return result, fmt.Errorf("digest of %s is %s, expected %s", target, copied.Digest, desc.Digest)
+		}
+	}
+	return result, nil
+}
+
+// copyDescriptor writes an image or index to target without re-encoding its manifest
+func copyDescriptor(desc *remote.Descriptor, target name.Tag, options []remote.Option) error {
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return err
+		}
+		return remote.WriteIndex(target, index, options...)
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return err
+	}
+	return remote.Write(target, img, options...)
+}
+
+// remoteOptions returns the transport and credentials for registry
+func (o MirrorOptions) remoteOptions(ctx context.Context, registry name.Registry, authCfg *AuthConfig) ([]remote.Option, error) {
+	transport, err := o.Registries.Transport(registry.RegistryStr())
+	if err != nil {
+		return nil, err
+	}
+	options := []remote.Option{remote.WithContext(ctx), remote.WithTransport(o.RateLimit.Transport(transport))}
+	if authenticator := pushAuthenticator(authCfg); authenticator != nil {
+		options = append(options, remote.WithAuth(authenticator))
+	} else {
+		options = append(options, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	}
+	return options, nil
+}
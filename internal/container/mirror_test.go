@@ -0,0 +1,94 @@
+package container
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorTarget(t *testing.T) {
+	target, err := MirrorTarget("ghcr.io/me/web:v2", "backup.lan:5000", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "backup.lan:5000/me/web:v2", target.String())
+
+	target, err = MirrorTarget("ghcr.io/me/web", "backup.lan/dr/", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "backup.lan/dr/me/web:latest", target.String())
+
+	_, err = MirrorTarget("ghcr.io/me/web@sha256:"+
+		"0000000000000000000000000000000000000000000000000000000000000000", "backup.lan", nil)
+	assert.ErrorContains(t, err, "pinned by digest")
+}
+
+func TestMirror(t *testing.T) {
+	sourceHost, sourceRegistries := newSigningRegistry(t)
+	backupHost, backupRegistries := newSigningRegistry(t)
+	registries := RegistryTransports{sourceHost: sourceRegistries[sourceHost], backupHost: backupRegistries[backupHost]}
+	keyPath, _ := writeCosignKeyPair(t, "")
+	t.Setenv("COSIGN_PASSWORD", "")
+
+	img, err := random.Image(256, 2)
+	require.NoError(t, err)
+	source := sourceHost + "/me/web:latest"
+	ref, err := registries.ParseReference(source)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img))
+	digest, err := img.Digest()
+	require.NoError(t, err)
+	signer, err := LoadSigningKey(keyPath)
+	require.NoError(t, err)
+	require.NoError(t, SignDigest(ref.Context().Digest(digest.String()), signer, nil))
+
+	index, err := random.Index(64, 1, 2)
+	require.NoError(t, err)
+	multiArch := sourceHost + "/me/db:latest"
+	indexRef, err := registries.ParseReference(multiArch)
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(indexRef, index))
+
+	ctx := context.Background()
+	target, err := MirrorTarget(source, backupHost, registries)
+	require.NoError(t, err)
+
+	result, err := Mirror(ctx, source, target, MirrorOptions{Registries: registries, DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sha256-" + digest.Hex + ".sig"}, result.Artifacts)
+	_, err = remote.Head(target)
+	assert.Error(t, err, "a dry run copies nothing")
+
+	result, err = Mirror(ctx, source, target, MirrorOptions{Registries: registries})
+	require.NoError(t, err)
+	assert.Equal(t, digest.String(), result.Digest)
+	assert.False(t, result.UpToDate)
+
+	copied, err := remote.Head(target)
+	require.NoError(t, err)
+	assert.Equal(t, digest, copied.Digest)
+	sigTag := "sha256-" + digest.Hex + ".sig"
+	sourceSig, err := remote.Head(ref.Context().Tag(sigTag))
+	require.NoError(t, err)
+	backupSig, err := remote.Head(target.Context().Tag(sigTag))
+	require.NoError(t, err)
+	assert.Equal(t, sourceSig.Digest, backupSig.Digest, "signatures are copied unchanged")
+
+	result, err = Mirror(ctx, source, target, MirrorOptions{Registries: registries})
+	require.NoError(t, err)
+	assert.True(t, result.UpToDate)
+
+	indexTarget, err := MirrorTarget(multiArch, backupHost, registries)
+	require.NoError(t, err)
+	_, err = Mirror(ctx, multiArch, indexTarget, MirrorOptions{Registries: registries})
+	require.NoError(t, err)
+	indexDigest, err := index.Digest()
+	require.NoError(t, err)
+	desc, err := remote.Head(indexTarget)
+	require.NoError(t, err)
+	assert.Equal(t, indexDigest, desc.Digest, "indexes are copied with every platform")
+
+	_, err = Mirror(ctx, sourceHost+"/me/missing:latest", target, MirrorOptions{Registries: registries})
+	assert.ErrorContains(t, err, "failed to read")
+}
@@ -0,0 +1,75 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// ParsePlatforms parses platforms such as "linux/arm64" or "linux/arm/v7".
+// A bare architecture ("amd64") means linux; values may be comma-separated
+// and duplicates are dropped.
+func ParsePlatforms(values []string) ([]v1.Platform, error) {
+	seen := make(map[string]bool)
+	var platforms []v1.Platform
+	for _, value := range values {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			if !strings.Contains(field, "/") {
+				field = "linux/" + field
+			}
+			platform, err := v1.ParsePlatform(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid platform %q: %w", field, err)
+			}
+			if platform.OS == "" || platform.Architecture == "" {
+				return nil, fmt.Errorf("invalid platform %q: expected os/arch[/variant], e.g. linux/arm64", field)
+			}
+			if seen[platform.String()] {
+				continue
+			}
+			seen[platform.String()] = true
+			platforms = append(platforms, *platform)
+		}
+	}
+	return platforms, nil
+}
+
+// BuildIndex builds the workload once per platform and combines the images
+// into an OCI image index (manifest list)
+func (b *Builder) BuildIndex(ctx context.Context) (v1.ImageIndex, error) {
+	platforms, err := ParsePlatforms(b.options.Platforms)
+	if err != nil {
+		return nil, err
+	}
+	if len(platforms) == 0 {
+		return nil, fmt.Errorf("no platforms to build")
+	}
+
+	index := mutate.IndexMediaType(empty.Index, types.OCIImageIndex)
+	for i := range platforms {
+		platform := platforms[i]
+		fmt.Fprintf(b.out(), "Building %s for %s\n", b.options.WorkloadName, platform.String())
+
+		b.platform = &platform
+		img, err := b.BuildContainer(ctx)
+		b.platform = nil
+		if err != nil {
+			return nil, fmt.Errorf("build for %s failed: %w", platform.String(), err)
+		}
+
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{Platform: &platform},
+		})
+	}
+	return index, nil
+}
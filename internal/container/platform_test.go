@@ -0,0 +1,127 @@
+package container
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	platforms, err := ParsePlatforms([]string{"amd64,linux/arm64", "linux/arm/v7", "linux/amd64"})
+	require.NoError(t, err)
+
+	var names []string
+	for _, platform := range platforms {
+		names = append(names, platform.String())
+	}
+	assert.Equal(t, []string{"linux/amd64", "linux/arm64", "linux/arm/v7"}, names)
+
+	platforms, err = ParsePlatforms(nil)
+	require.NoError(t, err)
+	assert.Empty(t, platforms)
+
+	_, err = ParsePlatforms([]string{"linux/"})
+	assert.ErrorContains(t, err, `invalid platform "linux/"`)
+}
+
+// pushMultiArchBase pushes an index with one random image per platform
+func pushMultiArchBase(t *testing.T, ref string, platforms ...string) {
+	t.Helper()
+	index := v1.ImageIndex(empty.Index)
+	for _, value := range platforms {
+		platform, err := v1.ParsePlatform(value)
+		require.NoError(t, err)
+
+		img, err := random.Image(64, 1)
+		require.NoError(t, err)
+		config, err := img.ConfigFile()
+		require.NoError(t, err)
+		config.OS, config.Architecture = platform.OS, platform.Architecture
+		img, err = mutate.ConfigFile(img, config)
+		require.NoError(t, err)
+
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: platform}})
+	}
+
+	parsed, err := name.ParseReference(ref, name.Insecure)
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(parsed, index))
+}
+
+func TestBuildAndPush_Platforms(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+	pushMultiArchBase(t, host+"/library/alpine:3", "linux/amd64", "linux/arm64")
+
+	contextPath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(contextPath, "Containerfile"), []byte("FROM "+host+"/library/alpine:3\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(contextPath, "app.conf"), []byte("key=value\n"), 0644))
+
+	builder := NewBuilder(BuildOptions{
+		WorkloadName: "app",
+		ContextPath:  contextPath,
+		Tag:          "latest",
+		RegistryURL:  host,
+		Registries:   RegistryTransports{host: {PlainHTTP: true}},
+		Backend:      BackendSimple,
+		Platforms:    []string{"amd64", "arm64"},
+	})
+	require.NoError(t, builder.BuildAndPush(context.Background()))
+
+	ref, err := name.ParseReference(host+"/app:latest", name.Insecure)
+	require.NoError(t, err)
+	index, err := remote.Index(ref)
+	require.NoError(t, err)
+	manifest, err := index.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, manifest.Manifests, 2)
+
+	indexDigest, err := index.Digest()
+	require.NoError(t, err)
+	assert.Equal(t, indexDigest.String(), builder.BuildInfo().ImageDigest)
+
+	for _, desc := range manifest.Manifests {
+		require.NotNil(t, desc.Platform)
+		img, err := index.Image(desc.Digest)
+		require.NoError(t, err)
+		config, err := img.ConfigFile()
+		require.NoError(t, err)
+		assert.Equal(t, desc.Platform.Architecture, config.Architecture, "each image is built from its platform's base")
+
+		layers, err := img.Layers()
+		require.NoError(t, err)
+		assert.Len(t, layers, 2, "base layer plus the context layer")
+	}
+}
+
+func TestBuildContainer_ToolPlatform(t *testing.T) {
+	archive, _ := writeArchive(t)
+	logPath := fakeTools(t, archive, BackendPodman)
+
+	contextPath := t.TempDir()
+	containerfile := filepath.Join(contextPath, "Containerfile")
+	require.NoError(t, os.WriteFile(containerfile, []byte("FROM scratch\n"), 0644))
+
+	builder := NewBuilder(BuildOptions{WorkloadName: "app", ContextPath: contextPath, Backend: BackendPodman, Platforms: []string{"arm64"}})
+	_, err := builder.build(context.Background())
+	require.NoError(t, err)
+	defer builder.cleanup()
+
+	calls, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(calls), "podman build --file "+containerfile+" --platform linux/arm64 --tag localhost/iago-build/app:latest-linux-arm64 "+filepath.Join(builder.workDir, "context-"))
+}
@@ -0,0 +1,128 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// DefaultPullRetries is how often a rate-limited pull is retried
+const DefaultPullRetries = 4
+
+// pullBackoff is the first delay after a 429; it doubles on each retry
+var pullBackoff = 2 * time.Second
+
+// pullBaseImage pulls a FROM image, trying the registry's configured mirrors
+// first and backing off when the registry rate-limits us
+func (b *Builder) pullBaseImage(ctx context.Context, ref name.Reference) (v1.Image, error) {
+	registry := ref.Context().RegistryStr()
+	upstream := registry
+	if registry == name.DefaultRegistry {
+		upstream = "Docker Hub"
+	}
+
+	for _, mirror := range b.mirrors(registry) {
+		mirrorRef, err := mirrorReference(ref, mirror, b.options.Registries)
+		if err == nil {
+			img, err := b.pullWithBackoff(ctx, mirrorRef, b.pullAuth(mirrorRef.Context().RegistryStr()))
+			if err == nil {
+				fmt.Fprintf(b.out(), "Pulled %s via mirror %s\n", ref, mirror)
+				return img, nil
+			}
+			err = fmt.Errorf("mirror pull of %s failed: %w", mirrorRef, err)
+		}
+		fmt.Fprintf(b.out(), "Warning: %v; falling back to %s\n", err, upstream)
+	}
+
+	return b.pullWithBackoff(ctx, ref, b.pullAuth(registry))
+}
+
+// mirrors returns the pull-through mirrors of registry in the order they're
+// tried: [container_registry.pull] mirror for Docker Hub, then the registry's
+// [container_registry.registries] mirrors
+func (b *Builder) mirrors(registry string) []string {
+	var mirrors []string
+	if mirror := b.options.Pull.Mirror; mirror != "" && registry == name.DefaultRegistry {
+		mirrors = append(mirrors, mirror)
+	}
+	for _, mirror := range b.options.Mirrors[registry] {
+		if !slices.Contains(mirrors, mirror) {
+			mirrors = append(mirrors, mirror)
+		}
+	}
+	return mirrors
+}
+
+// pullAuth returns the credentials for pulling from registry: its
+// [container_registry.registries] auth, or for Docker Hub the pull
+// credentials from the environment. nil pulls anonymously.
+func (b *Builder) pullAuth(registry string) authn.Authenticator {
+	cfg := b.options.RegistryAuth[registry]
+	if cfg == nil && registry == name.DefaultRegistry {
+		cfg = b.options.PullAuth
+	}
+	if cfg == nil {
+		return nil
+	}
+	if cfg.IdentityToken != "" {
+		return authn.FromConfig(authn.AuthConfig{Username: cfg.Username, IdentityToken: cfg.IdentityToken})
+	}
+	return &authn.Basic{Username: cfg.Username, Password: cfg.Password}
+}
+
+func (b *Builder) pullWithBackoff(ctx context.Context, ref name.Reference, auth authn.Authenticator) (v1.Image, error) {
+	options, err := b.remoteOptions(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if auth != nil {
+		options = append(options, remote.WithAuth(auth))
+	}
+	if b.platform != nil {
+		options = append(options, remote.WithPlatform(*b.platform))
+	}
+
+	retries := b.options.Pull.MaxRetries
+	if retries == 0 {
+		retries = DefaultPullRetries
+	}
+
+	delay := pullBackoff
+	for attempt := 0; ; attempt++ {
+		img, err := remote.Image(ref, options...)
+		if err == nil || !isRateLimited(err) || attempt >= retries {
+			return img, err
+		}
+
+		fmt.Fprintf(b.out(), "Rate limited pulling %s, retrying in %s (%d/%d)\n", ref, delay, attempt+1, retries)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// mirrorReference rewrites a Docker Hub reference to the same repository on mirror
+func mirrorReference(ref name.Reference, mirror string, registries RegistryTransports) (name.Reference, error) {
+	separator := ":"
+	if _, ok := ref.(name.Digest); ok {
+		separator = "@"
+	}
+	return registries.ParseReference(fmt.Sprintf("%s/%s%s%s", mirror, ref.Context().RepositoryStr(), separator, ref.Identifier()))
+}
+
+func isRateLimited(err error) bool {
+	var transportErr *transport.Error
+	return errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusTooManyRequests
+}
@@ -0,0 +1,123 @@
+package container
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRegistry serves an in-memory registry containing library/alpine:3 and
+// answers the first rateLimited manifest requests with 429
+func newTestRegistry(t *testing.T, rateLimited int32) (host string, manifestRequests *int32) {
+	t.Helper()
+
+	var count int32
+	reg := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/manifests/") && r.Method == http.MethodGet {
+			if atomic.AddInt32(&count, 1) <= rateLimited {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	host = strings.TrimPrefix(server.URL, "http://")
+	img, err := random.Image(64, 1)
+	require.NoError(t, err)
+	ref, err := name.ParseReference(host+"/library/alpine:3", name.Insecure)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img))
+
+	return host, &count
+}
+
+func TestPullBaseImage_RetriesRateLimit(t *testing.T) {
+	original := pullBackoff
+	pullBackoff = time.Millisecond
+	t.Cleanup(func() { pullBackoff = original })
+
+	host, requests := newTestRegistry(t, 2)
+	registries := RegistryTransports{host: {PlainHTTP: true}}
+	ref, err := registries.ParseReference(host + "/library/alpine:3")
+	require.NoError(t, err)
+
+	builder := NewBuilder(BuildOptions{Registries: registries})
+	_, err = builder.pullBaseImage(context.Background(), ref)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+
+	// Rewind the counter so the next dozen manifest requests are rate limited
+	atomic.StoreInt32(requests, -10)
+	builder = NewBuilder(BuildOptions{Registries: registries, Pull: machine.PullConfig{MaxRetries: 1}})
+	_, err = builder.pullBaseImage(context.Background(), ref)
+	assert.Error(t, err, "should give up after max_retries")
+}
+
+func TestPullBaseImage_Mirror(t *testing.T) {
+	host, requests := newTestRegistry(t, 0)
+
+	builder := NewBuilder(BuildOptions{
+		Registries: RegistryTransports{host: {PlainHTTP: true}},
+		Pull:       machine.PullConfig{Mirror: host},
+	})
+
+	ref, err := name.ParseReference("alpine:3")
+	require.NoError(t, err)
+
+	_, err = builder.pullBaseImage(context.Background(), ref)
+	require.NoError(t, err)
+	assert.Positive(t, atomic.LoadInt32(requests), "Docker Hub image should be pulled from the mirror")
+}
+
+func TestMirrorReference(t *testing.T) {
+	ref, err := name.ParseReference("nginx@sha256:" + strings.Repeat("a", 64))
+	require.NoError(t, err)
+
+	mirrored, err := mirrorReference(ref, "mirror.gcr.io", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "mirror.gcr.io/library/nginx@sha256:"+strings.Repeat("a", 64), mirrored.String())
+}
+
+func TestPullBaseImage_RegistryMirrors(t *testing.T) {
+	host, requests := newTestRegistry(t, 0)
+
+	var out strings.Builder
+	builder := NewBuilder(BuildOptions{
+		Registries: RegistryTransports{host: {PlainHTTP: true}, "127.0.0.1:1": {PlainHTTP: true}},
+		Mirrors:    map[string][]string{"quay.io": {"127.0.0.1:1", host}},
+		Output:     &out,
+	})
+
+	ref, err := name.ParseReference("quay.io/library/alpine:3")
+	require.NoError(t, err)
+
+	_, err = builder.pullBaseImage(context.Background(), ref)
+	require.NoError(t, err)
+	assert.Positive(t, atomic.LoadInt32(requests), "quay.io image should be pulled from the second mirror")
+	assert.Contains(t, out.String(), "falling back to quay.io")
+	assert.Contains(t, out.String(), "via mirror "+host)
+}
+
+func TestBuilder_Mirrors(t *testing.T) {
+	builder := NewBuilder(BuildOptions{
+		Pull:    machine.PullConfig{Mirror: "mirror.gcr.io"},
+		Mirrors: map[string][]string{name.DefaultRegistry: {"harbor.lab/hub", "mirror.gcr.io"}},
+	})
+	assert.Equal(t, []string{"mirror.gcr.io", "harbor.lab/hub"}, builder.mirrors(name.DefaultRegistry))
+	assert.Empty(t, builder.mirrors("ghcr.io"))
+}
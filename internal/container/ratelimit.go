@@ -0,0 +1,171 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that throttles registry transfers to a number
+// of bytes per second. One limiter is shared by every push and pull in the
+// process, so concurrent blob uploads and workloads split the budget.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second
+	burst  float64 // Bucket size: one second of transfer
+	tokens float64
+	last   time.Time
+}
+
+var (
+	sharedLimitersMu sync.Mutex
+	sharedLimiters   = make(map[int64]*RateLimiter)
+)
+
+// LimitRate returns the process-wide limiter for bytesPerSecond, or nil (no
+// limit) when it isn't positive
+func LimitRate(bytesPerSecond int64) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	sharedLimitersMu.Lock()
+	defer sharedLimitersMu.Unlock()
+	if limiter, ok := sharedLimiters[bytesPerSecond]; ok {
+		return limiter
+	}
+	limiter := newRateLimiter(bytesPerSecond)
+	sharedLimiters[bytesPerSecond] = limiter
+	return limiter
+}
+
+func newRateLimiter(bytesPerSecond int64) *RateLimiter {
+	rate := float64(bytesPerSecond)
+	return &RateLimiter{rate: rate, burst: rate, tokens: rate, last: time.Now()}
+}
+
+// ParseRate parses a transfer rate in bytes per second, with an optional
+// K, M or G suffix (powers of 1024) as in curl's --limit-rate: "500K", "2M"
+func ParseRate(value string) (int64, error) {
+	original := value
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate %q: expected bytes per second such as 500K or 2M", original)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// chunk is the most a single read waits for, so transfers stay smooth
+func (l *RateLimiter) chunk() int {
+	if l.burst < 32*1024 {
+		return max(int(l.burst), 1)
+	}
+	return 32 * 1024
+}
+
+// wait blocks until n bytes may be transferred
+func (l *RateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Transport wraps base so request and response bodies are throttled. A nil
+// limiter returns base unchanged.
+func (l *RateLimiter) Transport(base http.RoundTripper) http.RoundTripper {
+	if l == nil {
+		return base
+	}
+	return &limitedTransport{base: base, limiter: l}
+}
+
+type limitedTransport struct {
+	base    http.RoundTripper
+	limiter *RateLimiter
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if req.Body != nil && req.Body != http.NoBody {
+		limited := req.Clone(ctx)
+		limited.Body = &limitedBody{ReadCloser: req.Body, ctx: ctx, limiter: t.limiter}
+		if req.GetBody != nil {
+			limited.GetBody = func() (io.ReadCloser, error) {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				return &limitedBody{ReadCloser: body, ctx: ctx, limiter: t.limiter}, nil
+			}
+		}
+		req = limited
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Body != nil {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, ctx: ctx, limiter: t.limiter}
+	}
+	return resp, nil
+}
+
+// limitedBody reads at most one chunk at a time and waits for the bytes read
+type limitedBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *RateLimiter
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if chunk := b.limiter.chunk(); len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := b.limiter.wait(b.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
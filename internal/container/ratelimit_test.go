@@ -0,0 +1,82 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRate(t *testing.T) {
+	for value, want := range map[string]int64{
+		"":      0,
+		"1000":  1000,
+		"500K":  500 << 10,
+		"2m":    2 << 20,
+		"1.5M":  3 << 19,
+		"1G":    1 << 30,
+		" 64k ": 64 << 10,
+	} {
+		got, err := ParseRate(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, got, value)
+	}
+
+	for _, value := range []string{"fast", "2MB", "-1M", "K"} {
+		_, err := ParseRate(value)
+		assert.ErrorContains(t, err, "invalid rate", value)
+	}
+}
+
+func TestLimitRate_Shared(t *testing.T) {
+	assert.Nil(t, LimitRate(0))
+	assert.Same(t, LimitRate(1<<20), LimitRate(1<<20), "workloads share one budget")
+	assert.NotSame(t, LimitRate(1<<20), LimitRate(2<<20))
+
+	var limiter *RateLimiter
+	assert.Same(t, http.DefaultTransport, limiter.Transport(http.DefaultTransport))
+}
+
+func TestRateLimiter_Transport(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 48<<10)
+	var uploaded int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		uploaded = len(body)
+		w.Write(payload)
+	}))
+	t.Cleanup(server.Close)
+
+	// The first second's worth (32K) goes at once; the other 16K takes half a second
+	client := &http.Client{Transport: newRateLimiter(32 << 10).Transport(http.DefaultTransport)}
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Len(t, body, len(payload))
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+
+	// Uploads draw from the same bucket
+	client = &http.Client{Transport: newRateLimiter(32 << 10).Transport(http.DefaultTransport)}
+	start = time.Now()
+	resp, err = client.Post(server.URL, "application/octet-stream", bytes.NewReader(payload))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, len(payload), uploaded)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+
+	// Waiting stops when the transfer is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	limiter := newRateLimiter(1)
+	require.NoError(t, limiter.wait(ctx, 1))
+	cancel()
+	assert.ErrorIs(t, limiter.wait(ctx, 1), context.Canceled)
+}
@@ -0,0 +1,78 @@
+package container
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// SaveImage writes the image or image index at imageRef to a new OCI layout
+// in dir, annotated with the reference, and returns its digest
+func SaveImage(ctx context.Context, imageRef, dir string, registries RegistryTransports, authCfg *AuthConfig) (string, error) {
+	ref, err := registries.ParseReference(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("invalid image reference %s: %w", imageRef, err)
+	}
+	options, err := registries.RemoteOptions(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	if authenticator := pushAuthenticator(authCfg); authenticator != nil {
+		options = append(options, remote.WithAuth(authenticator))
+	}
+
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", imageRef, err)
+	}
+	path, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		return "", fmt.Errorf("failed to create OCI layout %s: %w", dir, err)
+	}
+	name := layout.WithAnnotations(map[string]string{"org.opencontainers.image.ref.name": imageRef})
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", imageRef, err)
+		}
+		if err := path.AppendIndex(index, name); err != nil {
+			return "", fmt.Errorf("failed to save %s: %w", imageRef, err)
+		}
+	} else {
+		img, err := desc.Image()
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", imageRef, err)
+		}
+		if err := path.AppendImage(img, name); err != nil {
+			return "", fmt.Errorf("failed to save %s: %w", imageRef, err)
+		}
+	}
+	return desc.Digest.String(), nil
+}
+
+// PushLayout pushes the image or image index in the OCI layout at dir, as
+// SaveImage writes it, to imageRef
+func PushLayout(ctx context.Context, dir, imageRef string, registries RegistryTransports, authCfg *AuthConfig) error {
+	img, err := loadOCILayout(dir)
+	if err != nil {
+		return err
+	}
+	ref, err := registries.ParseReference(imageRef)
+	if err != nil {
+		return fmt.Errorf("invalid image reference %s: %w", imageRef, err)
+	}
+	options, err := registries.RemoteOptions(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if authenticator := pushAuthenticator(authCfg); authenticator != nil {
+		options = append(options, remote.WithAuth(authenticator))
+	}
+	if err := remote.Push(ref, img, options...); err != nil {
+		return fmt.Errorf("failed to push image to %s: %w", imageRef, err)
+	}
+	return nil
+}
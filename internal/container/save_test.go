@@ -0,0 +1,33 @@
+package container
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveImage_PushLayout(t *testing.T) {
+	host, _ := newTestRegistry(t, 0)
+	registries := RegistryTransports{host: {PlainHTTP: true}}
+	dir := filepath.Join(t.TempDir(), "alpine")
+
+	digest, err := SaveImage(context.Background(), host+"/library/alpine:3", dir, registries, nil)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "oci-layout"))
+
+	// Restored to another repository, the image keeps its digest
+	require.NoError(t, PushLayout(context.Background(), dir, host+"/restored/alpine:3", registries, nil))
+	ref, err := name.ParseReference(host+"/restored/alpine:3", name.Insecure)
+	require.NoError(t, err)
+	desc, err := remote.Head(ref)
+	require.NoError(t, err)
+	assert.Equal(t, digest, desc.Digest.String())
+
+	_, err = SaveImage(context.Background(), host+"/library/missing:1", filepath.Join(t.TempDir(), "missing"), registries, nil)
+	assert.ErrorContains(t, err, "failed to fetch")
+}
@@ -0,0 +1,167 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/sbom"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// DefaultSBOMDir is where SBOMs of built images are written
+const DefaultSBOMDir = "output/sbom"
+
+// sbomDocument is the SBOM of one image a build produced
+type sbomDocument struct {
+	Path    string
+	Content []byte
+	Subject v1.Descriptor // The image it describes
+}
+
+// writeSBOMs scans img, or each image in an index, and writes an SBOM of
+// each to SBOMDir as <workload>.cdx.json, or <workload>-<os>-<arch>.cdx.json
+// for the images of an index
+func (b *Builder) writeSBOMs(img artifact) ([]sbomDocument, error) {
+	dir := b.options.SBOMDir
+	if dir == "" {
+		dir = DefaultSBOMDir
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	type target struct {
+		image v1.Image
+		desc  v1.Descriptor
+		name  string
+	}
+	var targets []target
+	switch img := img.(type) {
+	case v1.Image:
+		desc, err := describe(img)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target{image: img, desc: desc, name: b.options.WorkloadName})
+	case v1.ImageIndex:
+		manifest, err := img.IndexManifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image index: %w", err)
+		}
+		for _, desc := range manifest.Manifests {
+			if !desc.MediaType.IsImage() {
+				continue
+			}
+			child, err := img.Image(desc.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read image %s: %w", desc.Digest, err)
+			}
+			name := b.options.WorkloadName
+			if desc.Platform != nil {
+				name += "-" + strings.ReplaceAll(desc.Platform.String(), "/", "-")
+			}
+			targets = append(targets, target{image: child, desc: desc, name: name})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported image type %T", img)
+	}
+
+	registryURL := b.options.RegistryURL
+	if b.options.Local {
+		registryURL = "localhost:5000"
+	}
+	created := time.Now()
+	var docs []sbomDocument
+	for _, t := range targets {
+		inventory, err := sbom.Scan(t.image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", t.name, err)
+		}
+		for _, warning := range inventory.Warnings {
+			fmt.Fprintf(b.out(), "⚠️  SBOM of %s: %s\n", t.name, warning)
+		}
+		subject := sbom.Subject{Name: registryURL + "/" + b.options.WorkloadName, Digest: t.desc.Digest.String()}
+		content, err := inventory.Encode(b.options.SBOM, subject, created)
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, t.name+sbom.Extension(b.options.SBOM))
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write SBOM: %w", err)
+		}
+		fmt.Fprintf(b.out(), "Wrote SBOM %s (%d packages)\n", path, len(inventory.Packages))
+		docs = append(docs, sbomDocument{Path: path, Content: content, Subject: t.desc})
+	}
+	return docs, nil
+}
+
+// attachSBOMs pushes each SBOM as an OCI artifact whose subject is the image
+// it describes, so registries list it among the image's referrers (or under
+// the sha256-<hex> referrers tag where the referrers API isn't supported)
+func (b *Builder) attachSBOMs(ctx context.Context, docs []sbomDocument) error {
+	registryURL := b.options.RegistryURL
+	if b.options.Local {
+		registryURL = "localhost:5000"
+	}
+	artifactType := types.MediaType(sbom.MediaType(b.options.SBOM))
+
+	for _, doc := range docs {
+		imageRef := fmt.Sprintf("%s/%s@%s", registryURL, b.options.WorkloadName, doc.Subject.Digest)
+		ref, err := b.options.Registries.ParseReference(imageRef)
+		if err != nil {
+			return fmt.Errorf("invalid image reference %s: %w", imageRef, err)
+		}
+		options, err := b.pushOptions(ctx, ref)
+		if err != nil {
+			return err
+		}
+
+		referrer := mutate.ConfigMediaType(mutate.MediaType(empty.Image, types.OCIManifestSchema1), artifactType)
+		referrer, err = mutate.Append(referrer, mutate.Addendum{
+			Layer:       static.NewLayer(doc.Content, artifactType),
+			Annotations: map[string]string{"org.opencontainers.image.title": filepath.Base(doc.Path)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create SBOM artifact: %w", err)
+		}
+		subject := v1.Descriptor{MediaType: doc.Subject.MediaType, Size: doc.Subject.Size, Digest: doc.Subject.Digest}
+		referrer = mutate.Subject(referrer, subject).(v1.Image)
+		digest, err := referrer.Digest()
+		if err != nil {
+			return fmt.Errorf("failed to create SBOM artifact: %w", err)
+		}
+
+		target := ref.Context().Digest(digest.String())
+		if err := remote.Write(target, referrer, options...); err != nil {
+			return fmt.Errorf("failed to push SBOM to %s: %w", target, err)
+		}
+		fmt.Fprintf(b.out(), "Attached SBOM to %s\n", imageRef)
+	}
+	return nil
+}
+
+// describe returns the descriptor of img's manifest
+func describe(img v1.Image) (v1.Descriptor, error) {
+	mediaType, err := img.MediaType()
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to read image media type: %w", err)
+	}
+	manifest, err := img.RawManifest()
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to read image manifest: %w", err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to read image digest: %w", err)
+	}
+	return v1.Descriptor{MediaType: mediaType, Size: int64(len(manifest)), Digest: digest}, nil
+}
@@ -0,0 +1,93 @@
+package container
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andreweick/iago/internal/sbom"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilder_SBOM(t *testing.T) {
+	host, _ := newTestRegistry(t, 0)
+
+	layer, err := crane.Layer(map[string][]byte{
+		"etc/os-release":       []byte("ID=alpine\nVERSION_ID=3.20.0\n"),
+		"lib/apk/db/installed": []byte("P:musl\nV:1.2.5-r0\nA:x86_64\nL:MIT\n\nP:busybox\nV:1.36.1-r29\nA:x86_64\nL:GPL-2.0-only\n"),
+	})
+	require.NoError(t, err)
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	require.NoError(t, err)
+	archive := filepath.Join(t.TempDir(), "image.tar")
+	require.NoError(t, tarball.WriteToFile(archive, name.MustParseReference("web:dev"), img))
+	digest, err := img.Digest()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	var out strings.Builder
+	builder := NewBuilder(BuildOptions{
+		WorkloadName: "web",
+		Tag:          "dev",
+		RegistryURL:  host + "/homelab",
+		Registries:   RegistryTransports{host: {PlainHTTP: true}},
+		Output:       &out,
+		SBOM:         sbom.FormatCycloneDX,
+		SBOMDir:      dir,
+		AttachSBOM:   true,
+	})
+	require.NoError(t, builder.PushFrom(context.Background(), "docker-archive:"+archive))
+	assert.Contains(t, out.String(), "(2 packages)")
+
+	content, err := os.ReadFile(filepath.Join(dir, "web.cdx.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"purl": "pkg:apk/alpine/musl@1.2.5-r0?arch=x86_64&distro=alpine-3.20.0"`)
+	assert.Contains(t, string(content), `"version": "`+digest.String()+`"`)
+
+	// The SBOM is listed among the image's referrers
+	ref, err := name.ParseReference(host+"/homelab/web@"+digest.String(), name.Insecure)
+	require.NoError(t, err)
+	referrers, err := remote.Referrers(ref.(name.Digest))
+	require.NoError(t, err)
+	manifest, err := referrers.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, manifest.Manifests, 1)
+	assert.Equal(t, sbom.MediaTypeCycloneDX, string(manifest.Manifests[0].ArtifactType))
+
+	sbomImage, err := remote.Image(ref.Context().Digest(manifest.Manifests[0].Digest.String()))
+	require.NoError(t, err)
+	layers, err := sbomImage.Layers()
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+	mediaType, err := layers[0].MediaType()
+	require.NoError(t, err)
+	assert.Equal(t, sbom.MediaTypeCycloneDX, string(mediaType))
+}
+
+func TestBuilder_SBOMNoPush(t *testing.T) {
+	archive, _ := writeArchive(t)
+	dir := t.TempDir()
+	var out strings.Builder
+	builder := NewBuilder(BuildOptions{
+		WorkloadName: "web",
+		Tag:          "dev",
+		RegistryURL:  "registry.invalid",
+		NoPush:       true,
+		Output:       &out,
+		SBOM:         sbom.FormatSPDX,
+		SBOMDir:      dir,
+		AttachSBOM:   true,
+	})
+	require.NoError(t, builder.PushFrom(context.Background(), archive))
+	assert.FileExists(t, filepath.Join(dir, "web.spdx.json"))
+	assert.Contains(t, out.String(), "Skipping SBOM attachment")
+}
@@ -0,0 +1,227 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/payload"
+)
+
+// Cosign's simple signing layout: one layer per signature holding the signed
+// payload, with the base64 signature in the layer's annotation
+const (
+	SignatureMediaType  = "application/vnd.dev.cosignproject.cosign/simplesigning.v1+json"
+	signatureAnnotation = "dev.cosignproject.cosign/signature"
+)
+
+// ErrNoSignature is returned by VerifyImage when the image has no signatures at all
+var ErrNoSignature = errors.New("no signatures found")
+
+// LoadSigningKey loads a cosign private key from a file or from PEM content
+// (as passed in COSIGN_PRIVATE_KEY). Encrypted keys are decrypted with
+// COSIGN_PASSWORD, or a password read from the terminal when it is unset.
+func LoadSigningKey(key string) (signature.SignerVerifier, error) {
+	pemBytes := []byte(key)
+	if !strings.HasPrefix(key, "-----BEGIN") {
+		content, err := os.ReadFile(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cosign key: %w", err)
+		}
+		pemBytes = content
+	}
+
+	privateKey, err := cryptoutils.UnmarshalPEMToPrivateKey(pemBytes, cryptoutils.GetPasswordFromStdIn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cosign key (check COSIGN_PASSWORD): %w", err)
+	}
+	return signature.LoadSignerVerifier(privateKey, crypto.SHA256)
+}
+
+// LoadVerificationKey loads a cosign public key (cosign.pub)
+func LoadVerificationKey(path string) (signature.Verifier, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	publicKey, err := cryptoutils.UnmarshalPEMToPublicKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+	return signature.LoadVerifier(publicKey, crypto.SHA256)
+}
+
+// signatureTag is where cosign stores the signatures of digest: sha256-<hex>.sig
+// in the image's repository
+func signatureTag(repo name.Repository, digest v1.Hash) name.Tag {
+	return repo.Tag(fmt.Sprintf("%s-%s.sig", digest.Algorithm, digest.Hex))
+}
+
+// SignDigest signs ref's manifest digest and appends the signature to the
+// image's .sig tag, keeping signatures already there. The result verifies with
+// `cosign verify --key` (with --insecure-ignore-tlog, as nothing is uploaded to Rekor).
+func SignDigest(ref name.Digest, signer signature.Signer, options []remote.Option) error {
+	signedPayload, err := payload.Cosign{Image: ref}.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to create signature payload: %w", err)
+	}
+	sig, err := signer.SignMessage(bytes.NewReader(signedPayload))
+	if err != nil {
+		return fmt.Errorf("failed to sign %s: %w", ref, err)
+	}
+	return appendSignature(ref, signedPayload, sig, nil, options)
+}
+
+// appendSignature adds a signature layer, with any extra annotations such as a
+// keyless certificate, to the .sig tag of ref
+func appendSignature(ref name.Digest, signedPayload, sig []byte, annotations map[string]string, options []remote.Option) error {
+	digest, err := v1.NewHash(ref.DigestStr())
+	if err != nil {
+		return fmt.Errorf("invalid digest %s: %w", ref.DigestStr(), err)
+	}
+	tag := signatureTag(ref.Context(), digest)
+
+	signatures, err := remote.Image(tag, options...)
+	if isNotFound(err) {
+		signatures = mutate.ConfigMediaType(mutate.MediaType(empty.Image, types.OCIManifestSchema1), types.OCIConfigJSON)
+	} else if err != nil {
+		return fmt.Errorf("failed to read existing signatures %s: %w", tag, err)
+	}
+
+	layerAnnotations := map[string]string{signatureAnnotation: base64.StdEncoding.EncodeToString(sig)}
+	for key, value := range annotations {
+		layerAnnotations[key] = value
+	}
+	signatures, err = mutate.Append(signatures, mutate.Addendum{
+		Layer:       static.NewLayer(signedPayload, SignatureMediaType),
+		Annotations: layerAnnotations,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add signature: %w", err)
+	}
+	if err := remote.Write(tag, signatures, options...); err != nil {
+		return fmt.Errorf("failed to push signature to %s: %w", tag, err)
+	}
+	return nil
+}
+
+// VerifyImage checks that ref (a tag or digest) has at least one signature by
+// verifier over its current digest, and returns that digest
+func VerifyImage(ref name.Reference, verifier signature.Verifier, options []remote.Option) (v1.Hash, error) {
+	desc, err := remote.Head(ref, options...)
+	if err != nil {
+		return v1.Hash{}, fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	tag := signatureTag(ref.Context(), desc.Digest)
+
+	signatures, err := remote.Image(tag, options...)
+	if isNotFound(err) {
+		return v1.Hash{}, fmt.Errorf("%s@%s: %w", ref.Context(), desc.Digest, ErrNoSignature)
+	} else if err != nil {
+		return v1.Hash{}, fmt.Errorf("failed to fetch signatures %s: %w", tag, err)
+	}
+	manifest, err := signatures.Manifest()
+	if err != nil {
+		return v1.Hash{}, fmt.Errorf("failed to read signatures %s: %w", tag, err)
+	}
+
+	var errs []error
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != SignatureMediaType {
+			continue
+		}
+		if err := verifyLayer(signatures, layer, verifier, ref.Context(), desc.Digest); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return desc.Digest, nil
+	}
+	if len(errs) == 0 {
+		return v1.Hash{}, fmt.Errorf("%s@%s: %w", ref.Context(), desc.Digest, ErrNoSignature)
+	}
+	return v1.Hash{}, fmt.Errorf("no valid signature for %s@%s: %w", ref.Context(), desc.Digest, errors.Join(errs...))
+}
+
+// verifyLayer checks one signature layer against the key and the expected image
+func verifyLayer(signatures v1.Image, layer v1.Descriptor, verifier signature.Verifier, repo name.Repository, digest v1.Hash) error {
+	sig, err := base64.StdEncoding.DecodeString(layer.Annotations[signatureAnnotation])
+	if err != nil {
+		return fmt.Errorf("signature %s is not base64: %w", layer.Digest, err)
+	}
+	blob, err := signatures.LayerByDigest(layer.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to fetch signature payload %s: %w", layer.Digest, err)
+	}
+	reader, err := blob.Compressed()
+	if err != nil {
+		return fmt.Errorf("failed to fetch signature payload %s: %w", layer.Digest, err)
+	}
+	defer reader.Close()
+	signedPayload, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read signature payload %s: %w", layer.Digest, err)
+	}
+
+	if err := verifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader(signedPayload)); err != nil {
+		return fmt.Errorf("signature %s does not match the key: %w", layer.Digest, err)
+	}
+
+	var claim payload.SimpleContainerImage
+	if err := json.Unmarshal(signedPayload, &claim); err != nil {
+		return fmt.Errorf("signature payload %s is invalid: %w", layer.Digest, err)
+	}
+	if claim.Critical.Type != payload.CosignSignatureType {
+		return fmt.Errorf("signature %s has unexpected type %q", layer.Digest, claim.Critical.Type)
+	}
+	if claim.Critical.Image.DockerManifestDigest != digest.String() {
+		return fmt.Errorf("signature %s is for %s, not %s", layer.Digest, claim.Critical.Image.DockerManifestDigest, digest)
+	}
+	if identity := claim.Critical.Identity.DockerReference; identity != "" && identity != repo.Name() {
+		return fmt.Errorf("signature %s is for %s, not %s", layer.Digest, identity, repo.Name())
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var transportErr *transport.Error
+	return errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusNotFound
+}
+
+// VerifyImageRef resolves registry settings and credentials for imageRef and
+// verifies its signature with the public key at keyPath
+func VerifyImageRef(ctx context.Context, imageRef, keyPath string, registries RegistryTransports, authCfg *AuthConfig) (v1.Hash, error) {
+	verifier, err := LoadVerificationKey(keyPath)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	ref, err := registries.ParseReference(imageRef)
+	if err != nil {
+		return v1.Hash{}, fmt.Errorf("invalid image reference %s: %w", imageRef, err)
+	}
+	options, err := registries.RemoteOptions(ctx, ref)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	if authenticator := pushAuthenticator(authCfg); authenticator != nil {
+		options = append(options, remote.WithAuth(authenticator))
+	}
+	return VerifyImage(ref, verifier, options)
+}
@@ -0,0 +1,142 @@
+package container
+
+import (
+	"context"
+	"crypto/elliptic"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCosignKeyPair writes an encrypted cosign.key and cosign.pub the way
+// `cosign generate-key-pair` does
+func writeCosignKeyPair(t *testing.T, password string) (keyPath, pubPath string) {
+	t.Helper()
+	privPEM, pubPEM, err := cryptoutils.GeneratePEMEncodedECDSAKeyPair(elliptic.P256(), cryptoutils.StaticPasswordFunc([]byte(password)))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	keyPath = filepath.Join(dir, "cosign.key")
+	pubPath = filepath.Join(dir, "cosign.pub")
+	require.NoError(t, os.WriteFile(keyPath, privPEM, 0600))
+	require.NoError(t, os.WriteFile(pubPath, pubPEM, 0644))
+	return keyPath, pubPath
+}
+
+func newSigningRegistry(t *testing.T) (string, RegistryTransports) {
+	t.Helper()
+	server := httptest.NewServer(registry.New())
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+	return host, RegistryTransports{host: {PlainHTTP: true}}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	host, registries := newSigningRegistry(t)
+	keyPath, pubPath := writeCosignKeyPair(t, "hunter2")
+	_, otherPub := writeCosignKeyPair(t, "")
+	t.Setenv("COSIGN_PASSWORD", "hunter2")
+
+	img, err := random.Image(64, 1)
+	require.NoError(t, err)
+	ref, err := name.ParseReference(host+"/app:latest", name.Insecure)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img))
+	digest, err := img.Digest()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = VerifyImageRef(ctx, host+"/app:latest", pubPath, registries, nil)
+	assert.ErrorIs(t, err, ErrNoSignature)
+
+	builder := NewBuilder(BuildOptions{Sign: true, CosignKeyPath: keyPath, Registries: registries})
+	require.NoError(t, builder.SignContainer(ctx, host+"/app:latest"))
+
+	verified, err := VerifyImageRef(ctx, host+"/app:latest", pubPath, registries, nil)
+	require.NoError(t, err)
+	assert.Equal(t, digest, verified)
+
+	_, err = VerifyImageRef(ctx, host+"/app:latest", otherPub, registries, nil)
+	assert.ErrorContains(t, err, "does not match the key")
+
+	// A new image under the same tag isn't covered by the old signature
+	changed, err := random.Image(64, 1)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, changed))
+	_, err = VerifyImageRef(ctx, host+"/app:latest", pubPath, registries, nil)
+	assert.ErrorIs(t, err, ErrNoSignature)
+}
+
+func TestSignDigest_KeepsExistingSignatures(t *testing.T) {
+	host, registries := newSigningRegistry(t)
+	keyPath, pubPath := writeCosignKeyPair(t, "")
+	secondKey, _ := writeCosignKeyPair(t, "")
+	t.Setenv("COSIGN_PASSWORD", "")
+
+	img, err := random.Image(64, 1)
+	require.NoError(t, err)
+	digest, err := img.Digest()
+	require.NoError(t, err)
+	ref, err := name.NewDigest(host+"/app@"+digest.String(), name.Insecure)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img))
+
+	for _, key := range []string{keyPath, secondKey} {
+		signer, err := LoadSigningKey(key)
+		require.NoError(t, err)
+		require.NoError(t, SignDigest(ref, signer, nil))
+	}
+
+	signatures, err := remote.Image(signatureTag(ref.Context(), digest))
+	require.NoError(t, err)
+	layers, err := signatures.Layers()
+	require.NoError(t, err)
+	assert.Len(t, layers, 2)
+
+	_, err = VerifyImageRef(context.Background(), ref.String(), pubPath, registries, nil)
+	assert.NoError(t, err, "any matching signature is enough")
+}
+
+func TestLoadSigningKey_WrongPassword(t *testing.T) {
+	keyPath, _ := writeCosignKeyPair(t, "correct")
+	t.Setenv("COSIGN_PASSWORD", "wrong")
+
+	_, err := LoadSigningKey(keyPath)
+	assert.ErrorContains(t, err, "COSIGN_PASSWORD")
+}
+
+func TestBuildAndPush_Sign(t *testing.T) {
+	host, _ := newTestRegistry(t, 0)
+	registries := RegistryTransports{host: {PlainHTTP: true}}
+	keyPath, pubPath := writeCosignKeyPair(t, "")
+	t.Setenv("COSIGN_PASSWORD", "")
+
+	contextPath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(contextPath, "Containerfile"), []byte("FROM "+host+"/library/alpine:3\n"), 0644))
+
+	builder := NewBuilder(BuildOptions{
+		WorkloadName:  "app",
+		ContextPath:   contextPath,
+		Tag:           "latest",
+		RegistryURL:   host,
+		Sign:          true,
+		CosignKeyPath: keyPath,
+		Registries:    registries,
+		Backend:       BackendSimple,
+	})
+	require.NoError(t, builder.BuildAndPush(context.Background()))
+
+	verified, err := VerifyImageRef(context.Background(), host+"/app:latest", pubPath, registries, nil)
+	require.NoError(t, err)
+	assert.Equal(t, builder.BuildInfo().ImageDigest, verified.String())
+}
@@ -0,0 +1,140 @@
+package container
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// RegistryTransports applies per-registry TLS settings from defaults.toml to
+// every registry operation (pull, push, catalog, inspect)
+type RegistryTransports map[string]machine.RegistryTLSConfig
+
+// ParseReference parses an image reference, marking plain-HTTP registries insecure
+func (rt RegistryTransports) ParseReference(ref string) (name.Reference, error) {
+	parsed, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	if rt[parsed.Context().RegistryStr()].PlainHTTP {
+		return name.ParseReference(ref, name.Insecure)
+	}
+	return parsed, nil
+}
+
+// Transport returns the HTTP transport for a registry host
+func (rt RegistryTransports) Transport(registry string) (http.RoundTripper, error) {
+	cfg, ok := rt[registry]
+	if !ok || (cfg.CAFile == "" && cfg.CertFile == "" && !cfg.InsecureSkipVerify) {
+		return remote.DefaultTransport, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify, // #nosec G402 -- explicit opt-in for lab registries
+	}
+
+	if cfg.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle for %s: %w", registry, err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate for %s: %w", registry, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := remote.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// RemoteOptions returns remote options for talking to the registry of ref
+func (rt RegistryTransports) RemoteOptions(ctx context.Context, ref name.Reference) ([]remote.Option, error) {
+	transport, err := rt.Transport(ref.Context().RegistryStr())
+	if err != nil {
+		return nil, err
+	}
+	return []remote.Option{remote.WithContext(ctx), remote.WithTransport(transport)}, nil
+}
+
+// CraneOptions returns crane options for talking to registry
+func (rt RegistryTransports) CraneOptions(ctx context.Context, registry string) ([]crane.Option, error) {
+	transport, err := rt.Transport(registry)
+	if err != nil {
+		return nil, err
+	}
+	options := []crane.Option{crane.WithContext(ctx), crane.WithTransport(transport)}
+	if rt[registry].PlainHTTP {
+		options = append(options, crane.Insecure)
+	}
+	return options, nil
+}
+
+// Ping checks registry answers the registry API; an authentication challenge
+// counts, since reaching it needs no credentials
+func (rt RegistryTransports) Ping(ctx context.Context, registry string) error {
+	transport, err := rt.Transport(registry)
+	if err != nil {
+		return err
+	}
+	scheme := "https"
+	if rt[registry].PlainHTTP {
+		scheme = "http"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+registry+"/v2/", nil)
+	if err != nil {
+		return fmt.Errorf("invalid registry %s: %w", registry, err)
+	}
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		if tip := tlsTip(registry, err); tip != "" {
+			return fmt.Errorf("registry %s is not reachable: %w (%s)", registry, err, tip)
+		}
+		return fmt.Errorf("registry %s is not reachable: %w", registry, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("registry %s answered %s instead of the registry API", registry, resp.Status)
+	}
+	return nil
+}
+
+// tlsTip suggests the [container_registry.registries] setting for a registry
+// err shows has a certificate iago doesn't trust or doesn't speak TLS, or
+// returns ""
+func tlsTip(registry string, err error) string {
+	var unknownAuthority x509.UnknownAuthorityError
+	var recordHeader tls.RecordHeaderError
+	switch {
+	case errors.As(err, &unknownAuthority), strings.Contains(err.Error(), "x509: "):
+		return fmt.Sprintf("for a self-signed certificate set ca_file (or insecure_skip_verify = true) under [container_registry.registries.%q]", registry)
+	case errors.As(err, &recordHeader), strings.Contains(err.Error(), "server gave HTTP response to HTTPS client"):
+		return fmt.Sprintf("for a registry without TLS set plain_http = true under [container_registry.registries.%q]", registry)
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package container
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryTransports_ParseReference(t *testing.T) {
+	rt := RegistryTransports{"registry.lab:5000": {PlainHTTP: true}}
+
+	ref, err := rt.ParseReference("registry.lab:5000/app:latest")
+	require.NoError(t, err)
+	assert.Equal(t, "http", ref.Context().Scheme())
+
+	ref, err = rt.ParseReference("ghcr.io/andreweick/app:latest")
+	require.NoError(t, err)
+	assert.Equal(t, "https", ref.Context().Scheme())
+}
+
+func TestRegistryTransports_CustomCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/_catalog" {
+			_, _ = w.Write([]byte(`{"repositories":["app"]}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "https://")
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, caPEM, 0644))
+
+	ctx := context.Background()
+
+	// Without configuration the self-signed certificate is rejected
+	options, err := RegistryTransports{}.CraneOptions(ctx, registry)
+	require.NoError(t, err)
+	_, err = crane.Catalog(registry, options...)
+	assert.Error(t, err)
+
+	for name, cfg := range map[string]machine.RegistryTLSConfig{
+		"ca_file":              {CAFile: caFile},
+		"insecure_skip_verify": {InsecureSkipVerify: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			options, err := RegistryTransports{registry: cfg}.CraneOptions(ctx, registry)
+			require.NoError(t, err)
+
+			repos, err := crane.Catalog(registry, options...)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"app"}, repos)
+		})
+	}
+}
+
+func TestRegistryTransports_InvalidFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	emptyCA := filepath.Join(tempDir, "empty.pem")
+	require.NoError(t, os.WriteFile(emptyCA, []byte("not a certificate"), 0644))
+
+	_, err := RegistryTransports{"r": {CAFile: filepath.Join(tempDir, "missing.pem")}}.Transport("r")
+	assert.ErrorContains(t, err, "failed to read CA bundle")
+
+	_, err = RegistryTransports{"r": {CAFile: emptyCA}}.Transport("r")
+	assert.ErrorContains(t, err, "no certificates found")
+
+	_, err = RegistryTransports{"r": {CertFile: "missing.crt", KeyFile: "missing.key"}}.Transport("r")
+	assert.ErrorContains(t, err, "failed to load client certificate")
+}
+
+func TestRegistryTransports_Ping(t *testing.T) {
+	status := http.StatusUnauthorized
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/", r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "http://")
+	registries := RegistryTransports{registry: {PlainHTTP: true}}
+
+	assert.NoError(t, registries.Ping(context.Background(), registry), "an auth challenge means the registry is up")
+	status = http.StatusNotFound
+	assert.ErrorContains(t, registries.Ping(context.Background(), registry), "404 Not Found")
+	server.Close()
+	assert.ErrorContains(t, registries.Ping(context.Background(), registry), "is not reachable")
+}
+
+func TestTLSTip(t *testing.T) {
+	assert.Contains(t, tlsTip("registry.lab", errors.New("tls: failed to verify certificate: x509: certificate signed by unknown authority")), "ca_file")
+	assert.Contains(t, tlsTip("registry.lab", errors.New("http: server gave HTTP response to HTTPS client")), "plain_http = true")
+	assert.Empty(t, tlsTip("registry.lab", errors.New("connection refused")))
+}
@@ -0,0 +1,139 @@
+// Package containerfile reads the parts of Containerfiles iago checks and
+// records, without the container builder's dependencies
+package containerfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andreweick/iago/internal/machine"
+)
+
+// From is one FROM instruction
+type From struct {
+	Image      string
+	Stage      bool // Builds on an earlier stage or scratch rather than a registry image
+	Unresolved bool // Names an ARG without a default, so the image isn't known until build time
+}
+
+// Froms returns the FROM instructions of a Containerfile in order,
+// expanding ARGs declared before the first FROM from their defaults
+func Froms(containerfile string) []From {
+	stages := map[string]bool{"scratch": true}
+	args := make(map[string]string)
+	var froms []From
+	for _, line := range instructions(containerfile) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch {
+		case strings.EqualFold(fields[0], "ARG") && len(froms) == 0:
+			for _, arg := range fields[1:] {
+				name, value, _ := strings.Cut(arg, "=")
+				args[name] = strings.Trim(value, `"'`)
+			}
+		case strings.EqualFold(fields[0], "FROM"):
+			// Skip flags such as --platform=
+			from := From{}
+			var rest []string
+			for i, field := range fields[1:] {
+				if !strings.HasPrefix(field, "--") {
+					from.Image = os.Expand(field, func(name string) string {
+						name, fallback, hasFallback := strings.Cut(name, ":-")
+						if value := args[name]; value != "" {
+							return value
+						}
+						if !hasFallback {
+							from.Unresolved = true
+						}
+						return fallback
+					})
+					rest = fields[i+2:]
+					break
+				}
+			}
+			from.Stage = !from.Unresolved && stages[strings.ToLower(from.Image)]
+			froms = append(froms, from)
+			if len(rest) >= 2 && strings.EqualFold(rest[0], "AS") {
+				stages[strings.ToLower(rest[1])] = true
+			}
+		}
+	}
+	return froms
+}
+
+// instructions returns the lines of a Containerfile with backslash
+// continuations joined and comment lines dropped, as the builder reads them
+func instructions(containerfile string) []string {
+	var lines []string
+	var current strings.Builder
+	for _, line := range strings.Split(containerfile, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if continued, ok := strings.CutSuffix(trimmed, "\\"); ok {
+			current.WriteString(continued + " ")
+			continue
+		}
+		current.WriteString(trimmed)
+		lines = append(lines, current.String())
+		current.Reset()
+	}
+	if current.Len() > 0 {
+		lines = append(lines, current.String())
+	}
+	return lines
+}
+
+// BaseImages returns the registry images a Containerfile builds FROM, in
+// every stage, leaving out earlier stages and scratch
+func BaseImages(containerfile string) []string {
+	var images []string
+	for _, f := range Froms(containerfile) {
+		if !f.Stage && f.Image != "" {
+			images = append(images, f.Image)
+		}
+	}
+	return images
+}
+
+// Find returns the Containerfile in contextPath, or its Dockerfile
+// for backwards compatibility
+func Find(contextPath string) (string, error) {
+	for _, name := range []string{"Containerfile", "Dockerfile"} {
+		path := filepath.Join(contextPath, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("neither Containerfile nor Dockerfile found in %s", contextPath)
+}
+
+// CheckBaseImages returns an error for each base image in the build file that
+// policy doesn't allow, counting images it can't resolve as not allowed
+func CheckBaseImages(buildFilePath string, policy machine.BaseImagePolicy) error {
+	if policy.IsZero() {
+		return nil
+	}
+	content, err := os.ReadFile(buildFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", buildFilePath, err)
+	}
+	var errs []error
+	for _, from := range Froms(string(content)) {
+		switch {
+		case from.Stage:
+		case from.Unresolved || from.Image == "":
+			// An image only known at build time can't be shown to be allowed
+			errs = append(errs, fmt.Errorf("%s builds FROM an image set by a build argument, which [container_registry.build.base_images] can't check; give the ARG an allowed default", buildFilePath))
+		case !policy.Allows(from.Image):
+			errs = append(errs, fmt.Errorf("%s builds FROM %s, which [container_registry.build.base_images] doesn't allow", buildFilePath, from.Image))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,75 @@
+package containerfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBaseImages(t *testing.T) {
+	content := `ARG FEDORA=42
+FROM docker.io/library/golang:1.24 AS build
+RUN go build
+FROM build AS test
+FROM --platform=linux/amd64 quay.io/fedora/fedora-bootc:${FEDORA}
+COPY --from=build /out /usr/bin
+`
+	assert.Equal(t, []string{"docker.io/library/golang:1.24", "quay.io/fedora/fedora-bootc:42"}, BaseImages(content))
+	assert.Empty(t, BaseImages("FROM scratch\n"))
+
+	froms := Froms(content)
+	require.Len(t, froms, 3)
+	assert.True(t, froms[1].Stage)
+
+	continued := `# base image
+FROM \
+    --platform=linux/amd64 \
+    quay.io/fedora/fedora-bootc:42 \
+    AS base
+FROM base
+`
+	assert.Equal(t, []string{"quay.io/fedora/fedora-bootc:42"}, BaseImages(continued))
+
+	froms = Froms("ARG BASE\nFROM ${BASE}\nFROM ${TAG:-alpine}\n")
+	require.Len(t, froms, 2)
+	assert.True(t, froms[0].Unresolved)
+	assert.False(t, froms[0].Stage)
+	assert.Equal(t, From{Image: "alpine"}, froms[1])
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	_, err := Find(dir)
+	assert.ErrorContains(t, err, "neither Containerfile nor Dockerfile found")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM alpine\n"), 0644))
+	path, err := Find(dir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "Dockerfile"), path)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Containerfile"), []byte("FROM alpine\n"), 0644))
+	path, err = Find(dir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "Containerfile"), path, "Containerfile wins over Dockerfile")
+}
+
+func TestCheckBaseImages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Containerfile")
+	require.NoError(t, os.WriteFile(path, []byte("FROM golang:1.24 AS build\nFROM quay.io/fedora/fedora-bootc:42\n"), 0644))
+
+	assert.NoError(t, CheckBaseImages(path, machine.BaseImagePolicy{}))
+	assert.NoError(t, CheckBaseImages(path, machine.BaseImagePolicy{Allow: []string{"quay.io/fedora", "golang"}}))
+
+	err := CheckBaseImages(path, machine.BaseImagePolicy{Allow: []string{"quay.io/fedora"}})
+	assert.ErrorContains(t, err, "builds FROM golang:1.24, which [container_registry.build.base_images] doesn't allow")
+	assert.NotContains(t, err.Error(), "fedora-bootc")
+
+	require.NoError(t, os.WriteFile(path, []byte("ARG BASE\nFROM $BASE\n"), 0644))
+	err = CheckBaseImages(path, machine.BaseImagePolicy{Allow: []string{"quay.io/fedora"}})
+	assert.ErrorContains(t, err, "builds FROM an image set by a build argument")
+	assert.NoError(t, CheckBaseImages(path, machine.BaseImagePolicy{}))
+}
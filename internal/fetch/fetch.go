@@ -0,0 +1,175 @@
+package fetch
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultCacheDir is where fetched content is cached between runs
+const DefaultCacheDir = ".iago/cache/fetch"
+
+// DefaultCacheTTL is how long unpinned content is reused before refetching
+const DefaultCacheTTL = 24 * time.Hour
+
+// maxContentSize guards against accidentally inlining huge files into ignition
+const maxContentSize = 4 << 20
+
+// Fetcher retrieves remote content for templates from allow-listed hosts only
+type Fetcher struct {
+	AllowedHosts []string
+	// AllowAllHosts skips the allow-list, for URLs written explicitly in butane source: fields
+	AllowAllHosts bool
+	CacheDir      string
+	CacheTTL      time.Duration
+	Client        *http.Client
+	Out           io.Writer // Receives warnings; os.Stdout when nil
+}
+
+// NewFetcher creates a fetcher, applying defaults for empty settings
+func NewFetcher(allowedHosts []string, cacheDir string, cacheTTL time.Duration) *Fetcher {
+	if cacheDir == "" {
+		cacheDir = DefaultCacheDir
+	}
+	if cacheTTL == 0 {
+		cacheTTL = DefaultCacheTTL
+	}
+	f := &Fetcher{
+		AllowedHosts: allowedHosts,
+		CacheDir:     cacheDir,
+		CacheTTL:     cacheTTL,
+	}
+	f.Client = &http.Client{Timeout: 30 * time.Second, CheckRedirect: f.checkRedirect}
+	return f
+}
+
+// Fetch returns the content at rawURL. When checksum ("sha256:<hex>") is set the
+// content must match it; a cached copy that matches is used without refetching.
+func (f *Fetcher) Fetch(rawURL, checksum string) ([]byte, error) {
+	if err := f.checkAllowed(rawURL); err != nil {
+		return nil, err
+	}
+
+	cachePath := filepath.Join(f.CacheDir, fmt.Sprintf("%x", sha256.Sum256([]byte(rawURL))))
+	if content, fresh := f.readCache(cachePath); content != nil {
+		if checksum != "" && VerifyChecksum(content, checksum) == nil {
+			return content, nil
+		}
+		if checksum == "" && fresh {
+			return content, nil
+		}
+	}
+
+	content, err := f.download(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if checksum != "" {
+		if err := VerifyChecksum(content, checksum); err != nil {
+			return nil, fmt.Errorf("%s: %w", rawURL, err)
+		}
+	}
+
+	if err := os.MkdirAll(f.CacheDir, 0755); err == nil {
+		if err := os.WriteFile(cachePath, content, 0644); err != nil {
+			fmt.Fprintf(f.output(), "Warning: could not cache %s: %v\n", rawURL, err)
+		}
+	}
+
+	return content, nil
+}
+
+func (f *Fetcher) output() io.Writer {
+	if f.Out == nil {
+		return os.Stdout
+	}
+	return f.Out
+}
+
+// Checksum returns the "sha256:<hex>" checksum of content
+func Checksum(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+// VerifyChecksum checks content against a "sha256:<hex>" checksum
+func VerifyChecksum(content []byte, checksum string) error {
+	if !strings.HasPrefix(checksum, "sha256:") {
+		return fmt.Errorf("unsupported checksum '%s' (expected sha256:<hex>)", checksum)
+	}
+	if actual := Checksum(content); actual != strings.ToLower(checksum) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", checksum, actual)
+	}
+	return nil
+}
+
+func (f *Fetcher) checkAllowed(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL '%s': %w", rawURL, err)
+	}
+	if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return fmt.Errorf("URL '%s' must use http or https", rawURL)
+	}
+
+	if f.AllowAllHosts {
+		return nil
+	}
+
+	host := parsed.Hostname()
+	for _, allowed := range f.AllowedHosts {
+		if host == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("host '%s' is not in allowed_hosts (configure [templates.fetch] in defaults.toml)", host)
+}
+
+// checkRedirect applies the allow-list to every redirect, so an allowed host
+// can't hand the request on to one that isn't
+func (f *Fetcher) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+	return f.checkAllowed(req.URL.String())
+}
+
+func (f *Fetcher) readCache(cachePath string) (content []byte, fresh bool) {
+	info, err := os.Stat(cachePath)
+	if err != nil {
+		return nil, false
+	}
+	content, err = os.ReadFile(cachePath)
+	if err != nil {
+		return nil, false
+	}
+	return content, time.Since(info.ModTime()) < f.CacheTTL
+}
+
+func (f *Fetcher) download(rawURL string) ([]byte, error) {
+	resp, err := f.Client.Get(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: HTTP %d", rawURL, resp.StatusCode)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxContentSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", rawURL, err)
+	}
+	if len(content) > maxContentSize {
+		return nil, fmt.Errorf("%s exceeds the %d byte limit", rawURL, maxContentSize)
+	}
+
+	return content, nil
+}
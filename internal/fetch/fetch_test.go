@@ -0,0 +1,115 @@
+package fetch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T, body *string, hits *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*hits++
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, *body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetcher_AllowList(t *testing.T) {
+	fetcher := NewFetcher([]string{"example.com"}, t.TempDir(), time.Hour)
+
+	_, err := fetcher.Fetch("https://evil.example.org/sysctl.conf", "")
+	assert.ErrorContains(t, err, "host 'evil.example.org' is not in allowed_hosts")
+
+	_, err = fetcher.Fetch("file:///etc/passwd", "")
+	assert.ErrorContains(t, err, "must use http or https")
+}
+
+func TestFetcher_RedirectOutsideAllowList(t *testing.T) {
+	body, hits := "net.ipv4.ip_forward = 1\n", 0
+	target := newTestServer(t, &body, &hits)
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// localhost resolves to the same server but isn't in the allow-list
+		http.Redirect(w, r, strings.Replace(target.URL, "127.0.0.1", "localhost", 1)+"/sysctl.conf", http.StatusFound)
+	}))
+	t.Cleanup(redirect.Close)
+	fetcher := NewFetcher([]string{"127.0.0.1"}, t.TempDir(), time.Hour)
+
+	_, err := fetcher.Fetch(redirect.URL+"/sysctl.conf", "")
+	assert.ErrorContains(t, err, "host 'localhost' is not in allowed_hosts")
+	assert.Equal(t, 0, hits)
+}
+
+func TestFetcher_CachesContent(t *testing.T) {
+	body, hits := "net.ipv4.ip_forward = 1\n", 0
+	server := newTestServer(t, &body, &hits)
+	fetcher := NewFetcher([]string{"127.0.0.1"}, t.TempDir(), time.Hour)
+
+	content, err := fetcher.Fetch(server.URL+"/sysctl.conf", "")
+	require.NoError(t, err)
+	assert.Equal(t, body, string(content))
+
+	content, err = fetcher.Fetch(server.URL+"/sysctl.conf", "")
+	require.NoError(t, err)
+	assert.Equal(t, body, string(content))
+	assert.Equal(t, 1, hits, "second fetch should be served from cache")
+}
+
+func TestFetcher_ExpiredCacheRefetches(t *testing.T) {
+	body, hits := "v1", 0
+	server := newTestServer(t, &body, &hits)
+	fetcher := NewFetcher([]string{"127.0.0.1"}, t.TempDir(), time.Nanosecond)
+
+	_, err := fetcher.Fetch(server.URL+"/file", "")
+	require.NoError(t, err)
+	body = "v2"
+	content, err := fetcher.Fetch(server.URL+"/file", "")
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(content))
+	assert.Equal(t, 2, hits)
+}
+
+func TestFetcher_ChecksumPinning(t *testing.T) {
+	body, hits := "pinned content", 0
+	server := newTestServer(t, &body, &hits)
+	fetcher := NewFetcher([]string{"127.0.0.1"}, t.TempDir(), time.Nanosecond)
+
+	checksum := Checksum([]byte("pinned content"))
+	content, err := fetcher.Fetch(server.URL+"/file", checksum)
+	require.NoError(t, err)
+	assert.Equal(t, "pinned content", string(content))
+
+	// A matching cached copy is used even though the TTL expired
+	_, err = fetcher.Fetch(server.URL+"/file", checksum)
+	require.NoError(t, err)
+	assert.Equal(t, 1, hits)
+
+	body = "tampered"
+	_, err = fetcher.Fetch(server.URL+"/other", checksum)
+	assert.ErrorContains(t, err, "checksum mismatch")
+}
+
+func TestFetcher_HTTPError(t *testing.T) {
+	body, hits := "", 0
+	server := newTestServer(t, &body, &hits)
+	fetcher := NewFetcher([]string{"127.0.0.1"}, t.TempDir(), time.Hour)
+
+	_, err := fetcher.Fetch(server.URL+"/missing", "")
+	assert.ErrorContains(t, err, "HTTP 404")
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	assert.NoError(t, VerifyChecksum([]byte("abc"), Checksum([]byte("abc"))))
+	assert.ErrorContains(t, VerifyChecksum([]byte("abc"), "md5:900150983cd24fb0"), "unsupported checksum")
+	assert.ErrorContains(t, VerifyChecksum([]byte("abc"), Checksum([]byte("abd"))), "checksum mismatch")
+}
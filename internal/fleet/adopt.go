@@ -0,0 +1,253 @@
+package fleet
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// HostInfo is what adopt learns about a machine iago didn't provision
+type HostInfo struct {
+	Hostname         string
+	OS               string // PRETTY_NAME from /etc/os-release
+	Image            string // Booted bootc or rpm-ostree container image, if any
+	IPAddress        string // Source address of the default route
+	MACAddress       string
+	NetworkInterface string
+	Units            map[string][]byte // Unit files in /etc/systemd/system by name
+	EnabledUnits     []string
+	Quadlets         map[string][]byte // Files in /etc/containers/systemd by name
+	EnvFiles         []string          // Env files the units use, plus those under /etc/iago
+	Containers       []string          // Running containers as "name (image)"
+}
+
+// adoptScript reads the host's identity, image, network, units, quadlets and
+// containers. Unit and quadlet contents are printed base64 on the line after
+// their marker, like inspectScript. Env files are only listed: they often
+// hold secrets.
+const adoptScript = `echo "@hostname $(hostname -f 2>/dev/null || hostname)"
+. /etc/os-release 2>/dev/null; echo "@os $PRETTY_NAME"
+echo "@bootc"; sudo -n bootc status --format=json 2>/dev/null | base64 -w0; echo
+echo "@rpm-ostree"; rpm-ostree status --booted --json 2>/dev/null | base64 -w0; echo
+route=$(ip -o route get 1.1.1.1 2>/dev/null)
+dev=$(echo "$route" | sed -n 's/.* dev \([^ ]*\).*/\1/p')
+src=$(echo "$route" | sed -n 's/.* src \([^ ]*\).*/\1/p')
+mac=$(cat /sys/class/net/$dev/address 2>/dev/null)
+[ -n "$dev" ] && echo "@net $dev ${src:--} ${mac:--}"
+for f in /etc/systemd/system/*.service /etc/systemd/system/*.timer /etc/systemd/system/*.path; do
+  [ -f "$f" ] && [ ! -L "$f" ] || continue
+  u=$(basename "$f")
+  echo "@unit $u $(systemctl is-enabled "$u" 2>/dev/null)"; base64 -w0 "$f"; echo
+done
+for f in /etc/containers/systemd/*; do
+  [ -f "$f" ] || continue
+  echo "@quadlet $(basename "$f")"; { sudo -n cat "$f" 2>/dev/null || cat "$f"; } | base64 -w0; echo
+done
+sudo -n find /etc/iago -type f -name '*.env' 2>/dev/null | sed 's/^/@env /'
+sudo -n podman ps --format '{{.Names}} {{.Image}}' 2>/dev/null | sed 's/^/@container /'
+`
+
+// Inspect reads what adopt needs from host
+func Inspect(ctx context.Context, runner Runner, host string) (*HostInfo, error) {
+	output, err := runner.Run(ctx, host, adoptScript)
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseHostInfo(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state of %s: %w", host, err)
+	}
+	return info, nil
+}
+
+// parseHostInfo parses adoptScript's output
+func parseHostInfo(output string) (*HostInfo, error) {
+	info := &HostInfo{Units: make(map[string][]byte), Quadlets: make(map[string][]byte)}
+	envFiles := make(map[string]bool)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	// content reads the base64 line following a marker
+	content := func(marker string) ([]byte, error) {
+		if !scanner.Scan() {
+			return nil, fmt.Errorf("truncated output after %s", marker)
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(scanner.Text()))
+		if err != nil {
+			return nil, fmt.Errorf("invalid content for %s: %w", marker, err)
+		}
+		return data, nil
+	}
+
+	for scanner.Scan() {
+		marker, rest, _ := strings.Cut(scanner.Text(), " ")
+		fields := strings.Fields(rest)
+		switch marker {
+		case "@hostname":
+			info.Hostname = strings.TrimSpace(rest)
+		case "@os":
+			info.OS = strings.TrimSpace(rest)
+		case "@bootc", "@rpm-ostree":
+			data, err := content(marker)
+			if err != nil {
+				return nil, err
+			}
+			if info.Image == "" {
+				info.Image = bootedImage(marker, data)
+			}
+		case "@net":
+			// Unknown values are printed as -
+			if len(fields) == 3 {
+				info.NetworkInterface = fields[0]
+				info.IPAddress = strings.TrimPrefix(fields[1], "-")
+				info.MACAddress = strings.TrimPrefix(fields[2], "-")
+			}
+		case "@unit", "@quadlet":
+			if len(fields) == 0 {
+				continue
+			}
+			data, err := content(marker + " " + fields[0])
+			if err != nil {
+				return nil, err
+			}
+			if marker == "@quadlet" {
+				info.Quadlets[fields[0]] = data
+			} else {
+				info.Units[fields[0]] = data
+				if len(fields) > 1 && fields[1] == "enabled" {
+					info.EnabledUnits = append(info.EnabledUnits, fields[0])
+				}
+			}
+			for _, f := range environmentFiles(data) {
+				envFiles[f] = true
+			}
+		case "@env":
+			envFiles[strings.TrimSpace(rest)] = true
+		case "@container":
+			if len(fields) == 2 {
+				info.Containers = append(info.Containers, fmt.Sprintf("%s (%s)", fields[0], fields[1]))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for f := range envFiles {
+		info.EnvFiles = append(info.EnvFiles, f)
+	}
+	sort.Strings(info.EnvFiles)
+	sort.Strings(info.EnabledUnits)
+	return info, nil
+}
+
+// bootedImage picks the booted container image out of bootc or rpm-ostree's
+// JSON status
+func bootedImage(marker string, data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	if marker == "@bootc" {
+		var status struct {
+			Status struct {
+				Booted struct {
+					Image struct {
+						Image struct {
+							Image string `json:"image"`
+						} `json:"image"`
+					} `json:"image"`
+				} `json:"booted"`
+			} `json:"status"`
+		}
+		if json.Unmarshal(data, &status) != nil {
+			return ""
+		}
+		return status.Status.Booted.Image.Image.Image
+	}
+
+	var status struct {
+		Deployments []struct {
+			Booted                  bool   `json:"booted"`
+			ContainerImageReference string `json:"container-image-reference"`
+		} `json:"deployments"`
+	}
+	if json.Unmarshal(data, &status) != nil {
+		return ""
+	}
+	for _, d := range status.Deployments {
+		if d.Booted && d.ContainerImageReference != "" {
+			// e.g. ostree-unverified-registry:quay.io/fedora/fedora-coreos:stable
+			ref := d.ContainerImageReference
+			if i := strings.LastIndex(ref, ":docker://"); i >= 0 {
+				return ref[i+len(":docker://"):]
+			}
+			if _, image, ok := strings.Cut(ref, "registry:"); ok {
+				return image
+			}
+			return ref
+		}
+	}
+	return ""
+}
+
+// environmentFiles returns the EnvironmentFile= paths in a unit or quadlet
+func environmentFiles(unit []byte) []string {
+	var files []string
+	for _, line := range strings.Split(string(unit), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || strings.TrimSpace(key) != "EnvironmentFile" {
+			continue
+		}
+		for _, f := range strings.Fields(value) {
+			f = strings.TrimPrefix(f, "-")
+			if path.IsAbs(f) {
+				files = append(files, f)
+			}
+		}
+	}
+	return files
+}
+
+// Notes lists what adopt couldn't carry over and needs reconciling by hand
+// before the machine is managed by iago
+func (h *HostInfo) Notes(machineName, fqdn string) []string {
+	var notes []string
+	if h.Hostname != "" && h.Hostname != fqdn && h.Hostname != machineName {
+		notes = append(notes, fmt.Sprintf("The host calls itself %s, not %s; the generated config uses %s", h.Hostname, fqdn, fqdn))
+	}
+	if h.Image == "" {
+		notes = append(notes, "The booted image couldn't be read (bootc status needs passwordless sudo); the Containerfile starts from the default base image")
+	} else {
+		notes = append(notes, fmt.Sprintf("The host boots %s; containers/%s/Containerfile starts from it, so move its customisations into the Containerfile", h.Image, machineName))
+	}
+	if h.MACAddress == "" {
+		notes = append(notes, "The network interface and MAC address couldn't be read; set mac_address and network_interface in machine.toml")
+	}
+	for _, name := range sortedKeys(h.Units) {
+		notes = append(notes, fmt.Sprintf("Unit %s is copied to adopted/units/%s; add it to butane.yaml.tmpl or [[units]] in machine.toml, or drop it", name, name))
+	}
+	for _, name := range sortedKeys(h.Quadlets) {
+		notes = append(notes, fmt.Sprintf("Quadlet %s is copied to adopted/quadlets/%s; move it into the Containerfile or the butane template", name, name))
+	}
+	for _, f := range h.EnvFiles {
+		notes = append(notes, fmt.Sprintf("Env file %s was not copied (it may hold secrets); recreate it with iago's secrets or containers/%s/", f, machineName))
+	}
+	for _, c := range h.Containers {
+		notes = append(notes, fmt.Sprintf("Container %s is running; describe it in containers/%s/", c, machineName))
+	}
+	return notes
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,100 @@
+package fleet
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func TestParseHostInfo(t *testing.T) {
+	unit := "[Service]\nEnvironmentFile=-/etc/app/app.env\nEnvironmentFile=/etc/app/extra.env\nExecStart=/usr/bin/podman run app\n"
+	quadlet := "[Container]\nImage=ghcr.io/example/app\nEnvironmentFile=/etc/app/app.env\n"
+	output := strings.Join([]string{
+		"@hostname legacy.example.com",
+		"@os Fedora CoreOS 41.20250105.3.0",
+		"@bootc",
+		b64(`{"status":{"booted":{"image":{"image":{"image":"ghcr.io/example/legacy:latest","transport":"registry"}}}}}`),
+		"@rpm-ostree",
+		"",
+		"@net ens18 10.0.0.9 52:54:00:12:34:56",
+		"@unit app.service enabled",
+		b64(unit),
+		"@unit old.timer disabled",
+		b64("[Timer]\nOnCalendar=daily\n"),
+		"@quadlet app.container",
+		b64(quadlet),
+		"@env /etc/iago/containers/legacy.env",
+		"@container app ghcr.io/example/app:latest",
+	}, "\n")
+
+	info, err := parseHostInfo(output)
+	require.NoError(t, err)
+	assert.Equal(t, "legacy.example.com", info.Hostname)
+	assert.Equal(t, "Fedora CoreOS 41.20250105.3.0", info.OS)
+	assert.Equal(t, "ghcr.io/example/legacy:latest", info.Image)
+	assert.Equal(t, "ens18", info.NetworkInterface)
+	assert.Equal(t, "10.0.0.9", info.IPAddress)
+	assert.Equal(t, "52:54:00:12:34:56", info.MACAddress)
+	assert.Equal(t, unit, string(info.Units["app.service"]))
+	assert.Len(t, info.Units, 2)
+	assert.Equal(t, []string{"app.service"}, info.EnabledUnits)
+	assert.Equal(t, quadlet, string(info.Quadlets["app.container"]))
+	assert.Equal(t, []string{"/etc/app/app.env", "/etc/app/extra.env", "/etc/iago/containers/legacy.env"}, info.EnvFiles)
+	assert.Equal(t, []string{"app (ghcr.io/example/app:latest)"}, info.Containers)
+
+	_, err = parseHostInfo("@unit app.service enabled\n")
+	assert.ErrorContains(t, err, "truncated")
+}
+
+func TestParseHostInfo_Unknowns(t *testing.T) {
+	info, err := parseHostInfo("@hostname legacy\n@bootc\n\n@rpm-ostree\n" +
+		b64(`{"deployments":[{"booted":false,"container-image-reference":"ostree-unverified-registry:quay.io/old"},{"booted":true,"container-image-reference":"ostree-unverified-registry:quay.io/fedora/fedora-coreos:stable"}]}`) +
+		"\n@net ens3 - -\n")
+	require.NoError(t, err)
+	assert.Equal(t, "quay.io/fedora/fedora-coreos:stable", info.Image, "rpm-ostree is used when bootc status is unavailable")
+	assert.Equal(t, "ens3", info.NetworkInterface)
+	assert.Empty(t, info.IPAddress)
+	assert.Empty(t, info.MACAddress)
+
+	notes := strings.Join(info.Notes("legacy", "legacy.example.com"), "\n")
+	assert.NotContains(t, notes, "calls itself", "a short hostname matching the machine name is fine")
+	assert.Contains(t, notes, "set mac_address and network_interface")
+}
+
+func TestHostInfo_Notes(t *testing.T) {
+	info := &HostInfo{
+		Hostname:   "old-name",
+		MACAddress: "52:54:00:12:34:56",
+		Units:      map[string][]byte{"app.service": nil},
+		Quadlets:   map[string][]byte{"app.container": nil},
+		EnvFiles:   []string{"/etc/app/app.env"},
+		Containers: []string{"app (ghcr.io/example/app)"},
+	}
+	notes := info.Notes("legacy", "legacy.example.com")
+	require.Len(t, notes, 6)
+	assert.Contains(t, notes[0], "calls itself old-name")
+	assert.Contains(t, notes[1], "couldn't be read")
+	assert.Contains(t, notes[2], "adopted/units/app.service")
+	assert.Contains(t, notes[3], "adopted/quadlets/app.container")
+	assert.Contains(t, notes[4], "/etc/app/app.env was not copied")
+	assert.Contains(t, notes[5], "Container app (ghcr.io/example/app) is running")
+}
+
+func TestInspect(t *testing.T) {
+	runner := runnerFunc(func(ctx context.Context, host, command string) (string, error) {
+		assert.Equal(t, "legacy.example.com", host)
+		assert.Equal(t, adoptScript, command)
+		return "@hostname legacy.example.com\n", nil
+	})
+	info, err := Inspect(context.Background(), runner, "legacy.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy.example.com", info.Hostname)
+}
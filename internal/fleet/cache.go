@@ -0,0 +1,74 @@
+package fleet
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultCacheTTL is how long a machine's answer is reused, so running status
+// again straight away doesn't reconnect to every host
+const DefaultCacheTTL = time.Minute
+
+// ResultCache keeps successful command output on disk for a short time. A nil
+// cache caches nothing.
+type ResultCache struct {
+	Dir string
+	TTL time.Duration
+}
+
+type cacheEntry struct {
+	Output    string    `json:"output"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewResultCache returns a cache in the user's cache directory, e.g.
+// ~/.cache/iago/fleet
+func NewResultCache(ttl time.Duration) (*ResultCache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user cache directory: %w", err)
+	}
+	return &ResultCache{Dir: filepath.Join(dir, "iago", "fleet"), TTL: ttl}, nil
+}
+
+// Get returns the unexpired output cached for command on host
+func (c *ResultCache) Get(host, command string) (string, bool) {
+	if c == nil || c.TTL <= 0 {
+		return "", false
+	}
+	path := c.path(host, command)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || time.Now().After(entry.ExpiresAt) {
+		os.Remove(path)
+		return "", false
+	}
+	return entry.Output, true
+}
+
+// Put caches output for command on host. Failures to write are ignored: the
+// cache only saves work.
+func (c *ResultCache) Put(host, command, output string) {
+	if c == nil || c.TTL <= 0 {
+		return
+	}
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return
+	}
+	data, err := json.Marshal(cacheEntry{Output: output, ExpiresAt: time.Now().Add(c.TTL)})
+	if err != nil {
+		return
+	}
+	os.WriteFile(c.path(host, command), data, 0600)
+}
+
+func (c *ResultCache) path(host, command string) string {
+	return filepath.Join(c.Dir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(host+"\x00"+command))))
+}
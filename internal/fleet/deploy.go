@@ -0,0 +1,471 @@
+package fleet
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	ignitionConfig "github.com/coreos/ignition/v2/config"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/vincent-petithory/dataurl"
+)
+
+// deployablePrefixes are where deploy may write on a running machine: iago's
+// env files and scripts, and systemd units. Anything else in the ignition
+// (users, disks, /etc/hostname...) only changes by re-provisioning.
+var deployablePrefixes = []string{"/etc/iago/", "/usr/local/bin/", "/etc/systemd/system/", "/etc/containers/systemd/"}
+
+// Where ignitions keep credentials, as butane.SecretsDir and
+// butane.RegistryAuthFile (butane imports fleet through sysext), and where
+// machines keep the age identity that decrypts secrets
+const (
+	secretsDir       = "/etc/iago/secrets/"
+	ageDir           = "/etc/iago/age/"
+	registryAuthFile = "/etc/containers/auth.json"
+)
+
+// HoldsSecret reports whether the file at p holds credentials: generated
+// secrets, the age identity or registry auth. Diffs never show their content.
+func HoldsSecret(p string) bool {
+	return strings.HasPrefix(p, secretsDir) || strings.HasPrefix(p, ageDir) || p == registryAuthFile ||
+		(strings.HasPrefix(p, "/var/home/") && strings.HasSuffix(p, "/.config/containers/auth.json"))
+}
+
+// ChangeKind says what deploy would do to a path or unit
+type ChangeKind string
+
+const (
+	ChangeCreate  ChangeKind = "create"
+	ChangeUpdate  ChangeKind = "update"
+	ChangeMode    ChangeKind = "mode"
+	ChangeEnable  ChangeKind = "enable"
+	ChangeDisable ChangeKind = "disable"
+	ChangeRemove  ChangeKind = "remove"
+)
+
+// Change is one difference between the rendered config and the machine
+type Change struct {
+	Path string // File path, or unit name for enable/disable
+	Kind ChangeKind
+	Safe bool   // Applied by deploy; otherwise the machine must be re-provisioned
+	Diff string // Unified diff of text content
+
+	content []byte
+	mode    int
+}
+
+// Plan is what deploy would change on one machine
+type Plan struct {
+	Machine  string
+	Host     string
+	Changes  []Change
+	Exempted []Change // Drift in paths the machine expects to change at runtime
+}
+
+// desiredFile is a file the ignition writes
+type desiredFile struct {
+	path    string
+	content []byte
+	mode    int
+}
+
+// desiredState extracts the files (including unit files and drop-ins) and the
+// unit enablement an ignition config sets up
+func desiredState(ignitionJSON []byte) ([]desiredFile, map[string]bool, error) {
+	cfg, _, err := ignitionConfig.Parse(ignitionJSON)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse ignition: %w", err)
+	}
+
+	var files []desiredFile
+	for _, f := range cfg.Storage.Files {
+		if f.Contents.Source == nil || len(f.Append) > 0 {
+			continue
+		}
+		content, err := decodeSource(*f.Contents.Source, f.Contents.Compression)
+		if err != nil {
+			// Remote sources are fetched at provisioning time; deploy leaves them alone
+			continue
+		}
+		mode := 0644
+		if f.Mode != nil {
+			mode = *f.Mode
+		}
+		files = append(files, desiredFile{path: f.Path, content: content, mode: mode})
+	}
+
+	enabled := make(map[string]bool)
+	for _, u := range cfg.Systemd.Units {
+		unitPath := path.Join("/etc/systemd/system", u.Name)
+		if u.Contents != nil {
+			files = append(files, desiredFile{path: unitPath, content: []byte(*u.Contents), mode: 0644})
+		}
+		for _, d := range u.Dropins {
+			if d.Contents != nil {
+				files = append(files, desiredFile{path: path.Join(unitPath+".d", d.Name), content: []byte(*d.Contents), mode: 0644})
+			}
+		}
+		if u.Enabled != nil {
+			enabled[u.Name] = *u.Enabled
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, enabled, nil
+}
+
+// File is a file an ignition config writes with inline content
+type File struct {
+	Path    string
+	Content []byte
+	Mode    int
+}
+
+// InlineFiles returns the files, unit files and drop-ins an ignition config
+// writes with inline content, sorted by path
+func InlineFiles(ignitionJSON []byte) ([]File, error) {
+	desired, _, err := desiredState(ignitionJSON)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]File, len(desired))
+	for i, f := range desired {
+		files[i] = File{Path: f.path, Content: f.content, Mode: f.mode}
+	}
+	return files, nil
+}
+
+// decodeSource decodes an inline data: URL, gunzipping it when compressed
+func decodeSource(source string, compression *string) ([]byte, error) {
+	if !strings.HasPrefix(source, "data:") {
+		return nil, fmt.Errorf("not an inline source")
+	}
+	decoded, err := dataurl.DecodeString(source)
+	if err != nil {
+		return nil, err
+	}
+	if compression == nil || *compression == "" {
+		return decoded.Data, nil
+	}
+	if *compression != "gzip" {
+		return nil, fmt.Errorf("unsupported compression %q", *compression)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(decoded.Data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func deployable(p string) bool {
+	for _, prefix := range deployablePrefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteFile is a file as the machine has it
+type remoteFile struct {
+	exists  bool
+	mode    int
+	content []byte
+}
+
+// inspectScript prints each file's mode and base64 content, and each unit's
+// enablement, in a form readInspection parses. Paths and unit names are only
+// passed to printf as quoted arguments, so the shell expands nothing in them.
+func inspectScript(files []desiredFile, units []string) string {
+	var script strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&script, "if sudo -n test -f %[1]s; then printf '@file %%s %%s\\n' %[1]s \"$(sudo -n stat -c %%a %[1]s)\"; sudo -n base64 -w0 %[1]s; echo; else printf '@missing %%s\\n' %[1]s; fi\n", shellQuote(f.path))
+	}
+	for _, unit := range units {
+		fmt.Fprintf(&script, "printf '@unit %%s %%s\\n' %[1]s \"$(systemctl is-enabled %[1]s 2>/dev/null)\"\n", shellQuote(unit))
+	}
+	return script.String()
+}
+
+// readInspection parses inspectScript's output. A path or unit name may hold
+// spaces, so the mode or state is taken from the end of its line.
+func readInspection(output string) (map[string]remoteFile, map[string]string, error) {
+	files := make(map[string]remoteFile)
+	units := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		kind, rest, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || rest == "" {
+			continue
+		}
+		name, last := rest, ""
+		if i := strings.LastIndex(rest, " "); i >= 0 {
+			name, last = rest[:i], rest[i+1:]
+		}
+		switch kind {
+		case "@missing":
+			files[rest] = remoteFile{}
+		case "@unit":
+			units[name] = last
+		case "@file":
+			if last == "" || !scanner.Scan() {
+				return nil, nil, fmt.Errorf("truncated file listing for %s", rest)
+			}
+			mode, err := strconv.ParseInt(last, 8, 32)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid mode %q for %s", last, name)
+			}
+			content, err := base64.StdEncoding.DecodeString(strings.TrimSpace(scanner.Text()))
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid content for %s: %w", name, err)
+			}
+			files[name] = remoteFile{exists: true, mode: int(mode), content: content}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return files, units, nil
+}
+
+// PlanDeploy compares the machine's rendered ignition with what is on host
+func PlanDeploy(ctx context.Context, runner Runner, machineName, host string, ignitionJSON []byte) (*Plan, error) {
+	desired, enabled, err := desiredState(ignitionJSON)
+	if err != nil {
+		return nil, err
+	}
+	// Generated secrets change every render unless frozen and may be encrypted
+	// to the machine; deploy neither reads them back nor replaces them
+	var files []desiredFile
+	for _, f := range desired {
+		if !strings.HasPrefix(f.path, secretsDir) {
+			files = append(files, f)
+		}
+	}
+	units := make([]string, 0, len(enabled))
+	for unit := range enabled {
+		units = append(units, unit)
+	}
+	sort.Strings(units)
+
+	output, err := runner.Run(ctx, host, inspectScript(files, units))
+	if err != nil {
+		return nil, err
+	}
+	remoteFiles, remoteUnits, err := readInspection(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state of %s: %w", machineName, err)
+	}
+
+	plan := &Plan{Machine: machineName, Host: host}
+	for _, f := range files {
+		current, ok := remoteFiles[f.path]
+		if !ok {
+			return nil, fmt.Errorf("%s did not report %s", machineName, f.path)
+		}
+		change := Change{Path: f.path, Safe: deployable(f.path), content: f.content, mode: f.mode}
+		switch {
+		case !current.exists:
+			change.Kind = ChangeCreate
+			change.Diff = textDiff("host", f.path, nil, f.content)
+		case !bytes.Equal(current.content, f.content):
+			change.Kind = ChangeUpdate
+			change.Diff = textDiff("host", f.path, current.content, f.content)
+		case current.mode != f.mode:
+			change.Kind = ChangeMode
+			change.Diff = fmt.Sprintf("mode %04o -> %04o\n", current.mode, f.mode)
+		default:
+			continue
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	for _, unit := range units {
+		isEnabled := remoteUnits[unit] == "enabled"
+		switch {
+		case enabled[unit] && !isEnabled:
+			plan.Changes = append(plan.Changes, Change{Path: unit, Kind: ChangeEnable, Safe: true})
+		case !enabled[unit] && isEnabled:
+			plan.Changes = append(plan.Changes, Change{Path: unit, Kind: ChangeDisable, Safe: true})
+		}
+	}
+	return plan, nil
+}
+
+// Exempt moves the changes whose path or unit exempted reports true for out of
+// the plan, so deploy neither lists them as drift nor overwrites them
+func (p *Plan) Exempt(exempted func(path string) bool) {
+	changes := p.Changes[:0]
+	for _, c := range p.Changes {
+		if exempted(c.Path) {
+			p.Exempted = append(p.Exempted, c)
+		} else {
+			changes = append(changes, c)
+		}
+	}
+	p.Changes = changes
+}
+
+// textDiff returns a unified diff of name from the fromLabel version to the
+// rendered one, or a note for secret or binary content
+func textDiff(fromLabel, name string, from, to []byte) string {
+	if HoldsSecret(name) {
+		return fmt.Sprintf("secret content differs (%d -> %d bytes, not shown)\n", len(from), len(to))
+	}
+	if bytes.IndexByte(from, 0) >= 0 || bytes.IndexByte(to, 0) >= 0 {
+		return fmt.Sprintf("binary content differs (%d -> %d bytes)\n", len(from), len(to))
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(from)),
+		B:        difflib.SplitLines(string(to)),
+		FromFile: fromLabel + ":" + name,
+		ToFile:   "rendered:" + name,
+		Context:  3,
+	})
+	if err != nil {
+		return ""
+	}
+	return diff
+}
+
+// Safe returns the changes deploy applies
+func (p *Plan) Safe() []Change {
+	var changes []Change
+	for _, c := range p.Changes {
+		if c.Safe {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// Unsafe returns the changes that need the machine re-provisioned
+func (p *Plan) Unsafe() []Change {
+	var changes []Change
+	for _, c := range p.Changes {
+		if !c.Safe {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// RestartUnits returns the units whose files changed, plus the workload
+// service when its env files under /etc/iago changed. They are restarted with
+// try-restart, so a oneshot such as bootc-update.service that isn't running
+// is left for its timer.
+func (p *Plan) RestartUnits() []string {
+	seen := make(map[string]bool)
+	var units []string
+	add := func(unit string) {
+		if !seen[unit] {
+			seen[unit] = true
+			units = append(units, unit)
+		}
+	}
+	for _, c := range p.Safe() {
+		switch {
+		case c.Kind == ChangeEnable || c.Kind == ChangeDisable:
+		case strings.HasPrefix(c.Path, "/etc/systemd/system/"):
+			unit := strings.TrimPrefix(c.Path, "/etc/systemd/system/")
+			unit, _, _ = strings.Cut(unit, "/")
+			unit = strings.TrimSuffix(unit, ".d")
+			switch {
+			case unit == "bootc@.service":
+				add(fmt.Sprintf("bootc@%s.service", p.Machine))
+			case strings.Contains(unit, "@.") || strings.HasSuffix(unit, ".target"):
+				// Other templates have no instance we know of; targets aren't restarted
+			default:
+				add(unit)
+			}
+		case strings.HasPrefix(c.Path, "/etc/iago/containers/"):
+			add(fmt.Sprintf("bootc@%s.service", p.Machine))
+		}
+	}
+	return units
+}
+
+// applyScript writes every safe change in one pass, reloads systemd when a
+// unit changed and optionally restarts the affected units
+func (p *Plan) applyScript(restart bool) string {
+	var script strings.Builder
+	script.WriteString("set -e\n")
+	reload := false
+	for _, c := range p.Safe() {
+		switch c.Kind {
+		case ChangeEnable:
+			fmt.Fprintf(&script, "sudo -n systemctl enable %s\n", shellQuote(c.Path))
+		case ChangeDisable:
+			fmt.Fprintf(&script, "sudo -n systemctl disable %s\n", shellQuote(c.Path))
+		case ChangeMode:
+			fmt.Fprintf(&script, "sudo -n chmod %04o %s\n", c.mode, shellQuote(c.Path))
+		default:
+			fmt.Fprintf(&script, "base64 -d <<'IAGO_EOF' | sudo -n install -D -m %04o /dev/stdin %s\n", c.mode, shellQuote(c.Path))
+			encoded := base64.StdEncoding.EncodeToString(c.content)
+			for len(encoded) > 76 {
+				script.WriteString(encoded[:76] + "\n")
+				encoded = encoded[76:]
+			}
+			script.WriteString(encoded + "\nIAGO_EOF\n")
+		}
+		if strings.HasPrefix(c.Path, "/etc/systemd/system/") || strings.HasPrefix(c.Path, "/etc/containers/systemd/") {
+			reload = true
+		}
+	}
+	if reload {
+		script.WriteString("sudo -n systemctl daemon-reload\n")
+	}
+	if restart {
+		for _, unit := range p.RestartUnits() {
+			fmt.Fprintf(&script, "sudo -n systemctl try-restart %s\n", shellQuote(unit))
+		}
+	}
+	return script.String()
+}
+
+// Apply makes the plan's safe changes on the machine. It needs passwordless sudo.
+func (p *Plan) Apply(ctx context.Context, runner Runner, restart bool) error {
+	if len(p.Safe()) == 0 {
+		return nil
+	}
+	if _, err := runner.Run(ctx, p.Host, p.applyScript(restart)); err != nil {
+		return fmt.Errorf("failed to deploy to %s: %w", p.Machine, err)
+	}
+	return nil
+}
+
+// InstallFile writes content to path on the machine outside of a deploy plan,
+// e.g. a key that isn't part of its ignition. It needs passwordless sudo.
+func InstallFile(ctx context.Context, runner Runner, machineName, host, path string, content []byte, mode int) error {
+	plan := &Plan{Machine: machineName, Host: host, Changes: []Change{
+		{Path: path, Kind: ChangeCreate, Safe: true, content: content, mode: mode},
+	}}
+	return plan.Apply(ctx, runner, false)
+}
+
+// IgnitionFiles returns the contents of the storage files under dir in an
+// ignition config, by path
+func IgnitionFiles(ignitionJSON []byte, dir string) (map[string][]byte, error) {
+	files, _, err := desiredState(ignitionJSON)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]byte)
+	for _, f := range files {
+		if strings.HasPrefix(f.path, dir) {
+			result[f.path] = f.content
+		}
+	}
+	return result, nil
+}
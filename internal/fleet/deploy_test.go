@@ -0,0 +1,263 @@
+package fleet
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vincent-petithory/dataurl"
+)
+
+const testIgnition = `{
+  "ignition": {"version": "3.4.0"},
+  "storage": {"files": [
+    {"path": "/etc/hostname", "mode": 420, "contents": {"source": %q}},
+    {"path": "/etc/iago/containers/web.env", "mode": 384, "contents": {"source": %q}},
+    {"path": "/usr/local/bin/iago-check", "mode": 493, "contents": {"source": %q}},
+    {"path": "/etc/iago/remote.conf", "contents": {"source": "https://example.com/remote.conf"}}
+  ]},
+  "systemd": {"units": [
+    {"name": "bootc@.service", "contents": "[Service]\nExecStart=/usr/bin/podman run web\n", "dropins": [{"name": "10-limits.conf", "contents": "[Service]\nMemoryMax=1G\n"}]},
+    {"name": "bootc@web.service", "enabled": true},
+    {"name": "zincati.service", "enabled": false}
+  ]}
+}`
+
+func renderedIgnition() []byte {
+	data := func(s string) string { return dataurl.EncodeBytes([]byte(s)) }
+	return []byte(fmt.Sprintf(testIgnition, data("web\n"), data("PORT=8080\nMODE=prod\n"), data("#!/bin/sh\nexit 0\n")))
+}
+
+func TestDesiredState(t *testing.T) {
+	files, enabled, err := desiredState(renderedIgnition())
+	require.NoError(t, err)
+
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.path)
+	}
+	assert.Equal(t, []string{
+		"/etc/hostname",
+		"/etc/iago/containers/web.env",
+		"/etc/systemd/system/bootc@.service",
+		"/etc/systemd/system/bootc@.service.d/10-limits.conf",
+		"/usr/local/bin/iago-check",
+	}, paths, "remote sources are skipped")
+	assert.Equal(t, 0600, files[1].mode)
+	assert.Equal(t, "PORT=8080\nMODE=prod\n", string(files[1].content))
+	assert.Equal(t, map[string]bool{"bootc@web.service": true, "zincati.service": false}, enabled)
+}
+
+func TestReadInspection(t *testing.T) {
+	output := "@file /etc/hostname 644\n" + base64.StdEncoding.EncodeToString([]byte("web\n")) + "\n" +
+		"@missing /usr/local/bin/iago-check\n" +
+		"@file /etc/my app/env 600\n" + base64.StdEncoding.EncodeToString([]byte("A=1\n")) + "\n" +
+		"@unit zincati.service disabled\n" +
+		"@unit bootc@web.service \n"
+	files, units, err := readInspection(output)
+	require.NoError(t, err)
+
+	assert.Equal(t, remoteFile{exists: true, mode: 0644, content: []byte("web\n")}, files["/etc/hostname"])
+	assert.Equal(t, remoteFile{}, files["/usr/local/bin/iago-check"])
+	assert.Equal(t, remoteFile{exists: true, mode: 0600, content: []byte("A=1\n")}, files["/etc/my app/env"], "paths may hold spaces")
+	assert.Equal(t, map[string]string{"zincati.service": "disabled", "bootc@web.service": ""}, units)
+
+	_, _, err = readInspection("@file /etc/hostname 644\n")
+	assert.ErrorContains(t, err, "truncated")
+}
+
+// fakeHost answers inspectScript from files and units, and records other commands
+type fakeHost struct {
+	files    map[string]remoteFile
+	units    map[string]string
+	commands []string
+}
+
+func (h *fakeHost) Run(ctx context.Context, host, command string) (string, error) {
+	if !strings.Contains(command, "@missing") {
+		h.commands = append(h.commands, command)
+		return "", nil
+	}
+	var out strings.Builder
+	for _, line := range strings.Split(command, "\n") {
+		if i := strings.Index(line, `printf '@missing %s\n' `); i >= 0 {
+			p := shellUnquote(strings.TrimSuffix(line[i+len(`printf '@missing %s\n' `):], "; fi"))
+			f, ok := h.files[p]
+			if !ok || !f.exists {
+				fmt.Fprintf(&out, "@missing %s\n", p)
+				continue
+			}
+			fmt.Fprintf(&out, "@file %s %o\n%s\n", p, f.mode, base64.StdEncoding.EncodeToString(f.content))
+		}
+		if quoted, ok := strings.CutPrefix(line, `printf '@unit %s %s\n' `); ok {
+			unit := shellUnquote(quoted[:strings.Index(quoted, ` "$(`)])
+			fmt.Fprintf(&out, "@unit %s %s\n", unit, h.units[unit])
+		}
+	}
+	return out.String(), nil
+}
+
+// shellUnquote reverses shellQuote
+func shellUnquote(s string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(strings.TrimPrefix(s, "'"), "'"), `'\''`, "'")
+}
+
+func TestInspectScript_QuotesNames(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	dir := t.TempDir()
+	present := filepath.Join(dir, `my "app" $(touch pwned) it's.env`)
+	require.NoError(t, os.WriteFile(present, []byte("A=1\n"), 0640))
+	missing := filepath.Join(dir, "`touch pwned` missing")
+
+	// Run the script as the host would, without sudo
+	script := strings.ReplaceAll(inspectScript([]desiredFile{{path: present}, {path: missing}}, []string{"a $(touch pwned).service"}), "sudo -n ", "")
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	require.NoError(t, err)
+
+	files, units, err := readInspection(string(output))
+	require.NoError(t, err)
+	assert.Equal(t, remoteFile{exists: true, mode: 0640, content: []byte("A=1\n")}, files[present])
+	assert.Equal(t, remoteFile{}, files[missing])
+	assert.Contains(t, units, "a $(touch pwned).service")
+	assert.NoFileExists(t, filepath.Join(dir, "pwned"), "nothing in a name is run")
+}
+
+func TestPlanDeploy(t *testing.T) {
+	host := &fakeHost{
+		files: map[string]remoteFile{
+			"/etc/hostname":                      {exists: true, mode: 0644, content: []byte("old\n")},
+			"/etc/iago/containers/web.env":       {exists: true, mode: 0600, content: []byte("PORT=80\nMODE=prod\n")},
+			"/etc/systemd/system/bootc@.service": {exists: true, mode: 0644, content: []byte("[Service]\nExecStart=/usr/bin/podman run web\n")},
+			"/usr/local/bin/iago-check":          {exists: true, mode: 0644, content: []byte("#!/bin/sh\nexit 0\n")},
+		},
+		units: map[string]string{"bootc@web.service": "disabled", "zincati.service": "disabled"},
+	}
+
+	plan, err := PlanDeploy(context.Background(), host, "web", "10.0.0.5", renderedIgnition())
+	require.NoError(t, err)
+
+	kinds := make(map[string]ChangeKind)
+	for _, c := range plan.Changes {
+		kinds[c.Path] = c.Kind
+	}
+	assert.Equal(t, map[string]ChangeKind{
+		"/etc/hostname":                                       ChangeUpdate,
+		"/etc/iago/containers/web.env":                        ChangeUpdate,
+		"/etc/systemd/system/bootc@.service.d/10-limits.conf": ChangeCreate,
+		"/usr/local/bin/iago-check":                           ChangeMode,
+		"bootc@web.service":                                   ChangeEnable,
+	}, kinds)
+
+	unsafe := plan.Unsafe()
+	require.Len(t, unsafe, 1)
+	assert.Equal(t, "/etc/hostname", unsafe[0].Path)
+	assert.Len(t, plan.Safe(), 4)
+
+	for _, c := range plan.Changes {
+		if c.Path == "/etc/iago/containers/web.env" {
+			assert.Contains(t, c.Diff, "--- host:/etc/iago/containers/web.env")
+			assert.Contains(t, c.Diff, "-PORT=80\n")
+			assert.Contains(t, c.Diff, "+PORT=8080\n")
+		}
+		if c.Path == "/usr/local/bin/iago-check" {
+			assert.Equal(t, "mode 0644 -> 0755\n", c.Diff)
+		}
+	}
+
+	assert.Equal(t, []string{"bootc@web.service"}, plan.RestartUnits())
+}
+
+func TestPlanDeploy_Secrets(t *testing.T) {
+	data := func(s string) string { return dataurl.EncodeBytes([]byte(s)) }
+	ignition := []byte(fmt.Sprintf(`{
+  "ignition": {"version": "3.4.0"},
+  "storage": {"files": [
+    {"path": "/etc/iago/secrets/db-password", "mode": 384, "contents": {"source": %q}},
+    {"path": "/etc/containers/auth.json", "mode": 384, "contents": {"source": %q}}
+  ]}
+}`, data("hunter2\n"), data(`{"auths":{"ghcr.io":{"auth":"c2VjcmV0"}}}`)))
+	host := &fakeHost{files: map[string]remoteFile{
+		"/etc/iago/secrets/db-password": {exists: true, mode: 0600, content: []byte("old-password\n")},
+		"/etc/containers/auth.json":     {exists: true, mode: 0600, content: []byte(`{"auths":{}}`)},
+	}}
+
+	plan, err := PlanDeploy(context.Background(), host, "web", "10.0.0.5", ignition)
+	require.NoError(t, err)
+
+	require.Len(t, plan.Changes, 1, "generated secrets are left out of the plan")
+	change := plan.Changes[0]
+	assert.Equal(t, "/etc/containers/auth.json", change.Path)
+	assert.Equal(t, "secret content differs (12 -> 41 bytes, not shown)\n", change.Diff)
+	assert.NotContains(t, change.Diff, "c2VjcmV0")
+}
+
+func TestPlanExempt(t *testing.T) {
+	host := &fakeHost{
+		files: map[string]remoteFile{"/etc/hostname": {exists: true, mode: 0644, content: []byte("web\n")}},
+		units: map[string]string{"bootc@web.service": "disabled", "zincati.service": "disabled"},
+	}
+	plan, err := PlanDeploy(context.Background(), host, "web", "10.0.0.5", renderedIgnition())
+	require.NoError(t, err)
+
+	plan.Exempt(func(p string) bool { return strings.HasPrefix(p, "/etc/iago/") || p == "bootc@web.service" })
+	var exempted []string
+	for _, c := range plan.Exempted {
+		exempted = append(exempted, c.Path)
+	}
+	assert.ElementsMatch(t, []string{"/etc/iago/containers/web.env", "bootc@web.service"}, exempted)
+	for _, c := range plan.Changes {
+		assert.NotEqual(t, "/etc/iago/containers/web.env", c.Path)
+	}
+}
+
+func TestPlanApply(t *testing.T) {
+	host := &fakeHost{
+		files: map[string]remoteFile{"/etc/hostname": {exists: true, mode: 0644, content: []byte("web\n")}},
+		units: map[string]string{"bootc@web.service": "enabled", "zincati.service": "enabled"},
+	}
+	plan, err := PlanDeploy(context.Background(), host, "web", "10.0.0.5", renderedIgnition())
+	require.NoError(t, err)
+	require.Empty(t, plan.Unsafe())
+
+	require.NoError(t, plan.Apply(context.Background(), host, true))
+	require.Len(t, host.commands, 1)
+	script := host.commands[0]
+
+	assert.True(t, strings.HasPrefix(script, "set -e\n"))
+	assert.Contains(t, script, "| sudo -n install -D -m 0600 /dev/stdin '/etc/iago/containers/web.env'\n"+
+		base64.StdEncoding.EncodeToString([]byte("PORT=8080\nMODE=prod\n"))+"\nIAGO_EOF\n")
+	assert.Contains(t, script, "install -D -m 0755 /dev/stdin '/usr/local/bin/iago-check'")
+	assert.Contains(t, script, "sudo -n systemctl disable 'zincati.service'\n")
+	assert.NotContains(t, script, "/etc/hostname")
+	assert.Contains(t, script, "sudo -n systemctl daemon-reload\n")
+	assert.True(t, strings.HasSuffix(script, "sudo -n systemctl try-restart 'bootc@web.service'\n"))
+
+	host.commands = nil
+	require.NoError(t, plan.Apply(context.Background(), host, false))
+	assert.NotContains(t, host.commands[0], "try-restart")
+
+	empty := &Plan{Machine: "web", Host: "10.0.0.5"}
+	host.commands = nil
+	require.NoError(t, empty.Apply(context.Background(), host, true))
+	assert.Empty(t, host.commands, "nothing to apply doesn't connect")
+}
+
+func TestInstallFile(t *testing.T) {
+	host := &fakeHost{}
+	require.NoError(t, InstallFile(context.Background(), host, "web", "10.0.0.5", "/etc/iago/age/identity.key", []byte("AGE-SECRET-KEY-1\n"), 0600))
+	require.Len(t, host.commands, 1)
+	assert.Contains(t, host.commands[0], "| sudo -n install -D -m 0600 /dev/stdin '/etc/iago/age/identity.key'\n"+
+		base64.StdEncoding.EncodeToString([]byte("AGE-SECRET-KEY-1\n")))
+	assert.NotContains(t, host.commands[0], "daemon-reload")
+}
@@ -0,0 +1,99 @@
+package fleet
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultParallelism is how many machines are contacted at once
+const DefaultParallelism = 8
+
+// Runner runs a command on a host; Client does it over SSH
+type Runner interface {
+	Run(ctx context.Context, host, command string) (string, error)
+}
+
+// Target is a command to run on one machine
+type Target struct {
+	Machine string
+	Host    string
+	Command string
+}
+
+// Result is the outcome of a Target
+type Result struct {
+	Target
+	Output   string
+	Err      error
+	Duration time.Duration
+	Cached   bool // Served from the result cache instead of the machine
+}
+
+// Engine runs commands across the fleet with bounded parallelism. Each machine
+// has its own timeout (the Runner's), so one slow host doesn't hold up the
+// rest, and a failure on one machine never stops the others.
+type Engine struct {
+	Runner      Runner
+	Parallelism int          // Default DefaultParallelism
+	Cache       *ResultCache // Optional; successful results are reused until they expire
+
+	// OnResult, when set, is called as each machine finishes, in completion
+	// order, so callers can report progress before the slowest host answers
+	OnResult func(Result)
+}
+
+// Run runs every target and returns the results in target order. When ctx is
+// cancelled, targets that haven't finished report ctx's error and the results
+// gathered so far are still returned.
+func (e *Engine) Run(ctx context.Context, targets []Target) []Result {
+	parallelism := e.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+
+	results := make([]Result, len(targets))
+	slots := make(chan struct{}, parallelism)
+	var mu sync.Mutex // serializes OnResult
+	var wg sync.WaitGroup
+
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				results[i] = e.runOne(ctx, target)
+			case <-ctx.Done():
+				results[i] = Result{Target: target, Err: ctx.Err()}
+			}
+
+			if e.OnResult != nil {
+				mu.Lock()
+				e.OnResult(results[i])
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (e *Engine) runOne(ctx context.Context, target Target) Result {
+	if err := ctx.Err(); err != nil {
+		return Result{Target: target, Err: err}
+	}
+	if output, ok := e.Cache.Get(target.Host, target.Command); ok {
+		return Result{Target: target, Output: output, Cached: true}
+	}
+
+	started := time.Now()
+	output, err := e.Runner.Run(ctx, target.Host, target.Command)
+	result := Result{Target: target, Output: output, Err: err, Duration: time.Since(started)}
+	if err == nil {
+		e.Cache.Put(target.Host, target.Command, output)
+	}
+	return result
+}
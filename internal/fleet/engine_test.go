@@ -0,0 +1,138 @@
+package fleet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRunner answers after delay, failing for hosts in fail, and tracks how
+// many runs overlap
+type fakeRunner struct {
+	delay   time.Duration
+	fail    map[string]bool
+	calls   atomic.Int32
+	running atomic.Int32
+	peak    atomic.Int32
+}
+
+func (f *fakeRunner) Run(ctx context.Context, host, command string) (string, error) {
+	f.calls.Add(1)
+	now := f.running.Add(1)
+	defer f.running.Add(-1)
+	for {
+		peak := f.peak.Load()
+		if now <= peak || f.peak.CompareAndSwap(peak, now) {
+			break
+		}
+	}
+
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if f.fail[host] {
+		return "", fmt.Errorf("connection refused")
+	}
+	return host + ":" + command, nil
+}
+
+func targets(n int) []Target {
+	var out []Target
+	for i := 0; i < n; i++ {
+		host := fmt.Sprintf("host-%02d", i)
+		out = append(out, Target{Machine: host, Host: host, Command: "status"})
+	}
+	return out
+}
+
+func TestEngine_BoundedParallelism(t *testing.T) {
+	runner := &fakeRunner{delay: 20 * time.Millisecond, fail: map[string]bool{"host-03": true}}
+	var mu sync.Mutex
+	var reported []string
+	engine := &Engine{
+		Runner:      runner,
+		Parallelism: 3,
+		OnResult: func(r Result) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, r.Machine)
+		},
+	}
+
+	results := engine.Run(context.Background(), targets(10))
+	require.Len(t, results, 10)
+	for i, r := range results {
+		assert.Equal(t, fmt.Sprintf("host-%02d", i), r.Machine, "results keep target order")
+		if i == 3 {
+			assert.ErrorContains(t, r.Err, "connection refused")
+			continue
+		}
+		assert.NoError(t, r.Err)
+		assert.Equal(t, r.Host+":status", r.Output)
+	}
+	assert.LessOrEqual(t, runner.peak.Load(), int32(3))
+	assert.Len(t, reported, 10, "every result is reported as it arrives")
+}
+
+func TestEngine_CancelKeepsPartialResults(t *testing.T) {
+	runner := &fakeRunner{delay: time.Hour}
+	fast := &fakeRunner{}
+	engine := &Engine{Runner: runnerFunc(func(ctx context.Context, host, command string) (string, error) {
+		if host == "host-00" {
+			return fast.Run(ctx, host, command)
+		}
+		return runner.Run(ctx, host, command)
+	}), Parallelism: 2}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	results := engine.Run(ctx, targets(4))
+
+	assert.NoError(t, results[0].Err)
+	for _, r := range results[1:] {
+		assert.True(t, errors.Is(r.Err, context.DeadlineExceeded), "%s: %v", r.Machine, r.Err)
+	}
+}
+
+func TestEngine_Cache(t *testing.T) {
+	runner := &fakeRunner{fail: map[string]bool{"host-01": true}}
+	cache := &ResultCache{Dir: t.TempDir(), TTL: time.Minute}
+	engine := &Engine{Runner: runner, Cache: cache}
+
+	engine.Run(context.Background(), targets(2))
+	assert.Equal(t, int32(2), runner.calls.Load())
+
+	results := engine.Run(context.Background(), targets(2))
+	assert.True(t, results[0].Cached)
+	assert.Equal(t, "host-00:status", results[0].Output)
+	assert.False(t, results[1].Cached, "failures are retried, not cached")
+	assert.Equal(t, int32(3), runner.calls.Load())
+}
+
+func TestResultCache_Expiry(t *testing.T) {
+	cache := &ResultCache{Dir: t.TempDir(), TTL: time.Millisecond}
+	cache.Put("web", "status", "ok")
+	time.Sleep(5 * time.Millisecond)
+	_, ok := cache.Get("web", "status")
+	assert.False(t, ok)
+
+	var disabled *ResultCache
+	disabled.Put("web", "status", "ok")
+	_, ok = disabled.Get("web", "status")
+	assert.False(t, ok)
+}
+
+type runnerFunc func(ctx context.Context, host, command string) (string, error)
+
+func (f runnerFunc) Run(ctx context.Context, host, command string) (string, error) {
+	return f(ctx, host, command)
+}
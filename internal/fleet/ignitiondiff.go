@@ -0,0 +1,211 @@
+package fleet
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	ignitionConfig "github.com/coreos/ignition/v2/config"
+	"github.com/coreos/ignition/v2/config/v3_6_experimental/types"
+)
+
+// IgnitionDiff is how a newly rendered ignition differs from a previously
+// generated one
+type IgnitionDiff struct {
+	Files []Change // Storage files; Safe when iago deploy can apply the change
+	Units []Change // Unit files and drop-ins by path under /etc/systemd/system, and enablement by unit name
+	Other []string // Other sections that changed, e.g. passwd.users
+}
+
+// Empty reports whether the configs are equivalent
+func (d *IgnitionDiff) Empty() bool {
+	return len(d.Files) == 0 && len(d.Units) == 0 && len(d.Other) == 0
+}
+
+// ignitionFile is a storage file as an ignition config writes it. Remote and
+// appended contents are compared by their source.
+type ignitionFile struct {
+	exists  bool
+	content []byte
+	source  string
+	mode    int
+}
+
+// DiffIgnition compares two ignition configs of any spec version
+func DiffIgnition(previous, rendered []byte) (*IgnitionDiff, error) {
+	from, _, err := ignitionConfig.Parse(previous)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse previous ignition: %w", err)
+	}
+	to, _, err := ignitionConfig.Parse(rendered)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rendered ignition: %w", err)
+	}
+
+	fromFiles, toFiles := ignitionFiles(from.Storage.Files), ignitionFiles(to.Storage.Files)
+	diff := &IgnitionDiff{}
+	for _, p := range sortedPaths(fromFiles, toFiles) {
+		if change, ok := fileChange(p, fromFiles[p], toFiles[p]); ok {
+			change.Safe = change.Kind != ChangeRemove && deployable(p)
+			diff.Files = append(diff.Files, change)
+		}
+	}
+
+	fromUnits, fromEnabled := unitFiles(from.Systemd.Units)
+	toUnits, toEnabled := unitFiles(to.Systemd.Units)
+	for _, p := range sortedPaths(fromUnits, toUnits) {
+		if change, ok := fileChange(p, fromUnits[p], toUnits[p]); ok {
+			change.Safe = change.Kind != ChangeRemove
+			diff.Units = append(diff.Units, change)
+		}
+	}
+	var units []string
+	for _, enabled := range []map[string]bool{fromEnabled, toEnabled} {
+		for unit := range enabled {
+			if !slices.Contains(units, unit) {
+				units = append(units, unit)
+			}
+		}
+	}
+	sort.Strings(units)
+	for _, unit := range units {
+		switch {
+		case toEnabled[unit] && !fromEnabled[unit]:
+			diff.Units = append(diff.Units, Change{Path: unit, Kind: ChangeEnable, Safe: true})
+		case !toEnabled[unit] && fromEnabled[unit]:
+			diff.Units = append(diff.Units, Change{Path: unit, Kind: ChangeDisable, Safe: true})
+		}
+	}
+
+	sections := []struct {
+		name     string
+		from, to any
+	}{
+		{"ignition", from.Ignition, to.Ignition},
+		{"kernelArguments", from.KernelArguments, to.KernelArguments},
+		{"passwd.users", from.Passwd.Users, to.Passwd.Users},
+		{"passwd.groups", from.Passwd.Groups, to.Passwd.Groups},
+		{"storage.directories", from.Storage.Directories, to.Storage.Directories},
+		{"storage.links", from.Storage.Links, to.Storage.Links},
+		{"storage.disks", from.Storage.Disks, to.Storage.Disks},
+		{"storage.filesystems", from.Storage.Filesystems, to.Storage.Filesystems},
+		{"storage.luks", from.Storage.Luks, to.Storage.Luks},
+		{"storage.raid", from.Storage.Raid, to.Storage.Raid},
+	}
+	for _, section := range sections {
+		if !reflect.DeepEqual(section.from, section.to) {
+			diff.Other = append(diff.Other, section.name)
+		}
+	}
+	return diff, nil
+}
+
+// fileChange describes how a file went from "from" to "to"; zero values are
+// files the config doesn't have
+func fileChange(p string, from, to ignitionFile) (Change, bool) {
+	change := Change{Path: p, content: to.content, mode: to.mode}
+	switch {
+	case !from.exists:
+		change.Kind = ChangeCreate
+		change.Diff = textDiff("previous", p, nil, to.content)
+	case !to.exists:
+		change.Kind = ChangeRemove
+		change.Diff = textDiff("previous", p, from.content, nil)
+	case from.source != to.source:
+		change.Kind = ChangeUpdate
+		change.Diff = fmt.Sprintf("source %s -> %s\n", orNone(from.source), orNone(to.source))
+		if HoldsSecret(p) {
+			change.Diff = "secret source differs (not shown)\n"
+		}
+	case !bytes.Equal(from.content, to.content) && !encryptedSecret(p):
+		change.Kind = ChangeUpdate
+		change.Diff = textDiff("previous", p, from.content, to.content)
+	case from.mode != to.mode:
+		change.Kind = ChangeMode
+		change.Diff = fmt.Sprintf("mode %04o -> %04o\n", from.mode, to.mode)
+	default:
+		return Change{}, false
+	}
+	return change, true
+}
+
+// encryptedSecret reports whether p is an age-encrypted secret. age encryption
+// is randomized, so its content differs on every render even when frozen.
+func encryptedSecret(p string) bool {
+	return strings.HasPrefix(p, secretsDir) && strings.HasSuffix(p, ".age")
+}
+
+// ignitionFiles indexes storage files by path
+func ignitionFiles(files []types.File) map[string]ignitionFile {
+	result := make(map[string]ignitionFile, len(files))
+	for _, f := range files {
+		entry := ignitionFile{exists: true, mode: 0644}
+		if f.Mode != nil {
+			entry.mode = *f.Mode
+		}
+		if f.Contents.Source != nil {
+			content, err := decodeSource(*f.Contents.Source, f.Contents.Compression)
+			if err != nil {
+				entry.source = *f.Contents.Source
+			} else {
+				entry.content = content
+			}
+		}
+		for _, r := range f.Append {
+			if r.Source != nil {
+				entry.source += " + " + *r.Source
+			}
+		}
+		result[f.Path] = entry
+	}
+	return result
+}
+
+// unitFiles indexes unit contents and drop-ins by their path under
+// /etc/systemd/system, and returns which units are enabled
+func unitFiles(units []types.Unit) (map[string]ignitionFile, map[string]bool) {
+	files := make(map[string]ignitionFile)
+	enabled := make(map[string]bool)
+	for _, u := range units {
+		unitPath := path.Join("/etc/systemd/system", u.Name)
+		if u.Contents != nil {
+			files[unitPath] = ignitionFile{exists: true, content: []byte(*u.Contents), mode: 0644}
+		}
+		for _, d := range u.Dropins {
+			if d.Contents != nil {
+				files[path.Join(unitPath+".d", d.Name)] = ignitionFile{exists: true, content: []byte(*d.Contents), mode: 0644}
+			}
+		}
+		if u.Enabled != nil {
+			enabled[u.Name] = *u.Enabled
+		}
+	}
+	return files, enabled
+}
+
+// sortedPaths returns the paths in either map, sorted
+func sortedPaths(a, b map[string]ignitionFile) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var paths []string
+	for _, m := range []map[string]ignitionFile{a, b} {
+		for p := range m {
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func orNone(source string) string {
+	if source == "" {
+		return "(inline)"
+	}
+	return source
+}
@@ -0,0 +1,92 @@
+package fleet
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vincent-petithory/dataurl"
+)
+
+func TestDiffIgnition(t *testing.T) {
+	data := func(s string) string { return dataurl.EncodeBytes([]byte(s)) }
+	previous := []byte(fmt.Sprintf(`{
+  "ignition": {"version": "3.3.0"},
+  "passwd": {"users": [{"name": "core"}]},
+  "storage": {"files": [
+    {"path": "/etc/hostname", "mode": 420, "contents": {"source": %q}},
+    {"path": "/etc/iago/containers/web.env", "mode": 384, "contents": {"source": %q}},
+    {"path": "/etc/old.conf", "contents": {"source": %q}},
+    {"path": "/etc/iago/remote.conf", "contents": {"source": "https://example.com/v1.conf"}}
+  ]},
+  "systemd": {"units": [
+    {"name": "bootc@.service", "contents": "[Service]\nExecStart=/usr/bin/podman run web\n"},
+    {"name": "zincati.service", "enabled": true}
+  ]}
+}`, data("web\n"), data("PORT=80\n"), data("old\n")))
+
+	rendered := renderedIgnition()
+	diff, err := DiffIgnition(previous, rendered)
+	require.NoError(t, err)
+
+	kinds := func(changes []Change) map[string]ChangeKind {
+		result := make(map[string]ChangeKind)
+		for _, c := range changes {
+			result[c.Path] = c.Kind
+		}
+		return result
+	}
+	assert.Equal(t, map[string]ChangeKind{
+		"/etc/iago/containers/web.env": ChangeUpdate,
+		"/etc/iago/remote.conf":        ChangeUpdate,
+		"/etc/old.conf":                ChangeRemove,
+		"/usr/local/bin/iago-check":    ChangeCreate,
+	}, kinds(diff.Files), "unchanged files are left out")
+	assert.Equal(t, map[string]ChangeKind{
+		"/etc/systemd/system/bootc@.service.d/10-limits.conf": ChangeCreate,
+		"bootc@web.service": ChangeEnable,
+		"zincati.service":   ChangeDisable,
+	}, kinds(diff.Units))
+	assert.Equal(t, []string{"passwd.users"}, diff.Other)
+
+	for _, c := range diff.Files {
+		switch c.Path {
+		case "/etc/iago/containers/web.env":
+			assert.Contains(t, c.Diff, "--- previous:/etc/iago/containers/web.env")
+			assert.Contains(t, c.Diff, "+PORT=8080\n")
+			assert.True(t, c.Safe)
+		case "/etc/iago/remote.conf":
+			assert.Equal(t, "source https://example.com/v1.conf -> https://example.com/remote.conf\n", c.Diff)
+		case "/etc/old.conf":
+			assert.False(t, c.Safe, "deploy doesn't remove files")
+		}
+	}
+
+	same, err := DiffIgnition(rendered, rendered)
+	require.NoError(t, err)
+	assert.True(t, same.Empty())
+}
+
+func TestDiffIgnition_Secrets(t *testing.T) {
+	data := func(s string) string { return dataurl.EncodeBytes([]byte(s)) }
+	ignition := func(password, encrypted string) []byte {
+		return []byte(fmt.Sprintf(`{
+  "ignition": {"version": "3.4.0"},
+  "storage": {"files": [
+    {"path": "/etc/iago/secrets/db-password", "mode": 384, "contents": {"source": %q}},
+    {"path": "/etc/iago/secrets/api-token.age", "mode": 384, "contents": {"source": %q}}
+  ]}
+}`, data(password), data(encrypted)))
+	}
+
+	diff, err := DiffIgnition(ignition("old-password\n", "age-1"), ignition("hunter2\n", "age-2"))
+	require.NoError(t, err)
+	require.Len(t, diff.Files, 1, "re-encrypted secrets aren't changes")
+	assert.Equal(t, "/etc/iago/secrets/db-password", diff.Files[0].Path)
+	assert.Equal(t, "secret content differs (13 -> 8 bytes, not shown)\n", diff.Files[0].Diff)
+
+	same, err := DiffIgnition(ignition("hunter2\n", "age-1"), ignition("hunter2\n", "age-2"))
+	require.NoError(t, err)
+	assert.True(t, same.Empty())
+}
@@ -0,0 +1,220 @@
+// Package fleet runs commands on deployed machines over SSH
+package fleet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/github"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// DefaultTimeout bounds connecting to and running a command on one machine
+const DefaultTimeout = 15 * time.Second
+
+// defaultIdentityFiles are tried, when unencrypted, after the ssh-agent's keys
+var defaultIdentityFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// ClientOptions configures how machines are reached
+type ClientOptions struct {
+	User           string        // Login user, normally [user] username from defaults
+	GitHubUsername string        // Offer only keys listed at github.com/<user>.keys when any match
+	Port           int           // Default 22
+	Timeout        time.Duration // Per machine; default DefaultTimeout
+	KnownHostsFile string        // Default ~/.ssh/known_hosts
+	IdentityFiles  []string      // Default ~/.ssh/id_ed25519, id_ecdsa and id_rsa
+
+	// InsecureIgnoreHostKey skips host key checking, e.g. right after a VM is reprovisioned
+	InsecureIgnoreHostKey bool
+}
+
+// Client runs commands on machines over SSH
+type Client struct {
+	user     string
+	port     string
+	timeout  time.Duration
+	hostKeys ssh.HostKeyCallback
+	signers  []ssh.Signer
+}
+
+// NewClient loads SSH keys from the ssh-agent (SSH_AUTH_SOCK) and identity
+// files, and host keys from known_hosts
+func NewClient(options ClientOptions) (*Client, error) {
+	if options.User == "" {
+		return nil, fmt.Errorf("no SSH user: set [user] username in config/defaults.toml")
+	}
+	home, _ := os.UserHomeDir()
+
+	client := &Client{
+		user:    options.User,
+		port:    "22",
+		timeout: options.Timeout,
+	}
+	if options.Port != 0 {
+		client.port = fmt.Sprint(options.Port)
+	}
+	if client.timeout == 0 {
+		client.timeout = DefaultTimeout
+	}
+
+	if options.InsecureIgnoreHostKey {
+		client.hostKeys = ssh.InsecureIgnoreHostKey()
+	} else {
+		knownHostsFile := options.KnownHostsFile
+		if knownHostsFile == "" {
+			knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		callback, err := knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load known hosts (connect with ssh once, or pass --insecure-ignore-host-key): %w", err)
+		}
+		client.hostKeys = callback
+	}
+
+	signers := agentSigners()
+	identityFiles := options.IdentityFiles
+	if identityFiles == nil {
+		for _, name := range defaultIdentityFiles {
+			identityFiles = append(identityFiles, filepath.Join(home, ".ssh", name))
+		}
+	}
+	signers = append(signers, fileSigners(identityFiles)...)
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no SSH keys: start ssh-agent and add a key with ssh-add")
+	}
+
+	if options.GitHubUsername != "" {
+		if keys, err := github.FetchSSHKeys(options.GitHubUsername); err == nil {
+			signers = preferAuthorized(signers, keys)
+		}
+	}
+	client.signers = signers
+	return client, nil
+}
+
+// agentSigners returns the keys held by the running ssh-agent, if any
+func agentSigners() []ssh.Signer {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil
+	}
+	// The connection stays open: agent signers sign through it
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil
+	}
+	return signers
+}
+
+// fileSigners loads the unencrypted private keys among paths; passphrase
+// protected keys are expected to be in the agent
+func fileSigners(paths []string) []ssh.Signer {
+	var signers []ssh.Signer
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(content)
+		if err != nil {
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	return signers
+}
+
+// preferAuthorized narrows signers to those whose public key is in
+// authorizedKeys, the keys iago installs on machines, so a full agent doesn't
+// exhaust the server's authentication attempts. All signers are kept when
+// none match.
+func preferAuthorized(signers []ssh.Signer, authorizedKeys []string) []ssh.Signer {
+	authorized := make(map[string]bool)
+	for _, line := range authorizedKeys {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			continue
+		}
+		authorized[string(key.Marshal())] = true
+	}
+
+	var matching []ssh.Signer
+	for _, signer := range signers {
+		if authorized[string(signer.PublicKey().Marshal())] {
+			matching = append(matching, signer)
+		}
+	}
+	if len(matching) == 0 {
+		return signers
+	}
+	return matching
+}
+
+// Run runs command on host and returns its standard output. A command that
+// exits non-zero returns an error including its standard error.
+func (c *Client) Run(ctx context.Context, host, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(host, c.port)
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	// Closing the connection unblocks the handshake and session when ctx ends
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            c.user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(c.signers...)},
+		HostKeyCallback: c.hostKeys,
+		Timeout:         c.timeout,
+	})
+	if err != nil {
+		conn.Close()
+		return "", c.contextErr(ctx, host, fmt.Errorf("ssh to %s@%s failed: %w", c.user, host, err))
+	}
+	client := ssh.NewClient(sshConn, channels, requests)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", c.contextErr(ctx, host, fmt.Errorf("failed to open session on %s: %w", host, err))
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := session.Run(command); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return stdout.String(), c.contextErr(ctx, host, fmt.Errorf("command failed on %s: %w", host, err))
+	}
+	return stdout.String(), nil
+}
+
+// contextErr reports a timeout instead of the connection error it caused
+func (c *Client) contextErr(ctx context.Context, host string, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s did not respond within %s: %w", host, c.timeout, context.DeadlineExceeded)
+	}
+	return err
+}
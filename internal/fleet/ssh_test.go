@@ -0,0 +1,201 @@
+package fleet
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testServer is an SSH server answering exec requests with handler
+type testServer struct {
+	port       int
+	knownHosts string
+	identity   string
+}
+
+func newTestSigner(t *testing.T) (ssh.Signer, []byte) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+	return signer, pem.EncodeToMemory(block)
+}
+
+func newTestServer(t *testing.T, handler func(command string) (stdout, stderr string, exit uint32)) *testServer {
+	t.Helper()
+	hostKey, _ := newTestSigner(t)
+	userKey, userPEM := newTestSigner(t)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "core" && string(key.Marshal()) == string(userKey.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, assert.AnError
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config, handler)
+		}
+	}()
+
+	dir := t.TempDir()
+	port := listener.Addr().(*net.TCPAddr).Port
+	server := &testServer{
+		port:       port,
+		knownHosts: filepath.Join(dir, "known_hosts"),
+		identity:   filepath.Join(dir, "id_ed25519"),
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize("127.0.0.1:" + strconv.Itoa(port))}, hostKey.PublicKey())
+	require.NoError(t, os.WriteFile(server.knownHosts, []byte(line+"\n"), 0600))
+	require.NoError(t, os.WriteFile(server.identity, userPEM, 0600))
+	return server
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig, handler func(string) (string, string, uint32)) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range channelRequests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				length := binary.BigEndian.Uint32(req.Payload)
+				stdout, stderr, exit := handler(string(req.Payload[4 : 4+length]))
+				req.Reply(true, nil)
+				channel.Write([]byte(stdout))
+				channel.Stderr().Write([]byte(stderr))
+				channel.SendRequest("exit-status", false, binary.BigEndian.AppendUint32(nil, exit))
+				return
+			}
+		}()
+	}
+}
+
+func (s *testServer) client(t *testing.T) *Client {
+	t.Helper()
+	t.Setenv("SSH_AUTH_SOCK", "")
+	client, err := NewClient(ClientOptions{
+		User:           "core",
+		Port:           s.port,
+		KnownHostsFile: s.knownHosts,
+		IdentityFiles:  []string{s.identity},
+		Timeout:        5 * time.Second,
+	})
+	require.NoError(t, err)
+	return client
+}
+
+func TestClientRun(t *testing.T) {
+	server := newTestServer(t, func(command string) (string, string, uint32) {
+		if command == "false" {
+			return "", "permission denied", 1
+		}
+		return "ran " + command, "", 0
+	})
+	client := server.client(t)
+
+	out, err := client.Run(context.Background(), "127.0.0.1", "uptime")
+	require.NoError(t, err)
+	assert.Equal(t, "ran uptime", out)
+
+	_, err = client.Run(context.Background(), "127.0.0.1", "false")
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestClientRun_UnknownHostKey(t *testing.T) {
+	server := newTestServer(t, func(string) (string, string, uint32) { return "", "", 0 })
+	other := newTestServer(t, func(string) (string, string, uint32) { return "", "", 0 })
+	server.knownHosts = other.knownHosts
+
+	_, err := server.client(t).Run(context.Background(), "127.0.0.1", "uptime")
+	assert.ErrorContains(t, err, "knownhosts")
+}
+
+func TestClientRun_Timeout(t *testing.T) {
+	// Accepts connections but never speaks SSH
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				<-done
+				conn.Close()
+			}()
+		}
+	}()
+
+	server := newTestServer(t, nil)
+	server.port = listener.Addr().(*net.TCPAddr).Port
+	client := server.client(t)
+	client.timeout = 200 * time.Millisecond
+
+	_, err = client.Run(context.Background(), "127.0.0.1", "uptime")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestNewClient_Errors(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	_, err := NewClient(ClientOptions{})
+	assert.ErrorContains(t, err, "no SSH user")
+
+	_, err = NewClient(ClientOptions{User: "core", InsecureIgnoreHostKey: true, IdentityFiles: []string{}})
+	assert.ErrorContains(t, err, "no SSH keys")
+
+	_, err = NewClient(ClientOptions{User: "core", KnownHostsFile: filepath.Join(t.TempDir(), "missing")})
+	assert.ErrorContains(t, err, "--insecure-ignore-host-key")
+}
+
+func TestPreferAuthorized(t *testing.T) {
+	first, _ := newTestSigner(t)
+	second, _ := newTestSigner(t)
+	signers := []ssh.Signer{first, second}
+
+	authorized := []string{string(ssh.MarshalAuthorizedKey(second.PublicKey()))}
+	assert.Equal(t, []ssh.Signer{second}, preferAuthorized(signers, authorized))
+	assert.Equal(t, signers, preferAuthorized(signers, []string{"ssh-ed25519 bogus"}), "no match keeps every key")
+}
@@ -0,0 +1,126 @@
+package fleet
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/machine"
+)
+
+// Status is what a machine reports about its workload and OS updates
+type Status struct {
+	Machine     string
+	Host        string
+	Container   string // systemd state of the workload's service, e.g. active or failed
+	ImageDigest string // Digest of the image the workload container is running
+	LastUpdate  string // When the update service last ran
+	Zincati     string // systemd state of zincati.service (OS updates)
+	Err         error  // Set when the machine couldn't be reached
+	Cached      bool   // Reported from the result cache
+}
+
+// LastUpdateTime parses LastUpdate, which systemd prints like
+// "Mon 2026-10-12 03:00:01 UTC"; ok is false when it's empty or unparsable
+func (s Status) LastUpdateTime() (time.Time, bool) {
+	if s.LastUpdate == "" || s.LastUpdate == "n/a" {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation("Mon 2006-01-02 15:04:05 MST", s.LastUpdate, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Host returns the address used to reach a machine: its IP address when set, else its FQDN
+func Host(m machine.Config) string {
+	if m.IPAddress != "" {
+		return m.IPAddress
+	}
+	return m.FQDN
+}
+
+// statusScript prints one key=value line per field. It only reads state, and
+// uses sudo -n so a missing sudo rule gives an empty digest rather than a
+// password prompt.
+func statusScript(m machine.Config) string {
+	service := fmt.Sprintf("bootc@%s.service", m.Name)
+	systemctl := "systemctl"
+	podman := "sudo -n podman"
+	if m.Rootless {
+		user := m.RootlessUserName()
+		service = m.Name + ".service"
+		systemctl = fmt.Sprintf("systemctl --user -M %s@", shellQuote(user))
+		podman = fmt.Sprintf(`sudo -n runuser -u %[1]s -- env XDG_RUNTIME_DIR=/run/user/$(id -u %[1]s) podman`, shellQuote(user))
+	}
+	updateService := "bootc-update.service"
+	if m.UpdateMechanism == machine.UpdateMechanismPodman {
+		updateService = "podman-auto-update.service"
+	}
+
+	lines := []string{
+		fmt.Sprintf(`echo "container=$(%s is-active %s 2>/dev/null)"`, systemctl, shellQuote(service)),
+		fmt.Sprintf(`echo "digest=$(%s inspect --format '{{.ImageDigest}}' %s 2>/dev/null)"`, podman, shellQuote(m.Name)),
+		fmt.Sprintf(`echo "last_update=$(systemctl show -p ExecMainExitTimestamp --value %s 2>/dev/null)"`, updateService),
+		`echo "zincati=$(systemctl is-active zincati.service 2>/dev/null)"`,
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseStatus fills status from statusScript's output
+func parseStatus(output string, status *Status) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "container":
+			status.Container = value
+		case "digest":
+			status.ImageDigest = value
+		case "last_update":
+			status.LastUpdate = value
+		case "zincati":
+			status.Zincati = value
+		}
+	}
+}
+
+// FleetStatus reads the status of every machine through engine. Machines that
+// couldn't be reached have Status.Err set; the rest are reported regardless.
+func FleetStatus(ctx context.Context, engine *Engine, machines []machine.Config) []Status {
+	statuses := make([]Status, len(machines))
+	var targets []Target
+	var indexes []int // statuses index of each target
+	for i, m := range machines {
+		statuses[i] = Status{Machine: m.Name, Host: Host(m)}
+		if statuses[i].Host == "" {
+			statuses[i].Err = fmt.Errorf("no fqdn or ip_address")
+			continue
+		}
+		targets = append(targets, Target{Machine: m.Name, Host: statuses[i].Host, Command: statusScript(m)})
+		indexes = append(indexes, i)
+	}
+
+	for j, result := range engine.Run(ctx, targets) {
+		status := &statuses[indexes[j]]
+		status.Cached = result.Cached
+		if result.Err != nil {
+			status.Err = result.Err
+			continue
+		}
+		parseStatus(result.Output, status)
+	}
+	return statuses
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,65 @@
+package fleet
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusScript(t *testing.T) {
+	script := statusScript(machine.Config{Name: "web"})
+	assert.Contains(t, script, "systemctl is-active 'bootc@web.service'")
+	assert.Contains(t, script, "sudo -n podman inspect --format '{{.ImageDigest}}' 'web'")
+	assert.Contains(t, script, "ExecMainExitTimestamp --value bootc-update.service")
+
+	rootless := statusScript(machine.Config{Name: "web", Rootless: true, RootlessUser: "svc", UpdateMechanism: machine.UpdateMechanismPodman})
+	assert.Contains(t, rootless, "systemctl --user -M 'svc'@ is-active 'web.service'")
+	assert.Contains(t, rootless, "runuser -u 'svc' --")
+	assert.Contains(t, rootless, "podman-auto-update.service")
+}
+
+func TestParseStatus(t *testing.T) {
+	var status Status
+	parseStatus("container=active\ndigest=sha256:abc\nlast_update=Mon 2025-01-06 04:00:12 UTC\nzincati=inactive\nnoise\n", &status)
+	assert.Equal(t, Status{
+		Container:   "active",
+		ImageDigest: "sha256:abc",
+		LastUpdate:  "Mon 2025-01-06 04:00:12 UTC",
+		Zincati:     "inactive",
+	}, status)
+
+	updated, ok := status.LastUpdateTime()
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2025, 1, 6, 4, 0, 12, 0, time.UTC), updated.UTC())
+	_, ok = Status{LastUpdate: "n/a"}.LastUpdateTime()
+	assert.False(t, ok)
+}
+
+func TestFleetStatus(t *testing.T) {
+	server := newTestServer(t, func(command string) (string, string, uint32) {
+		if !strings.Contains(command, "bootc@web.service") {
+			return "", "unexpected command", 1
+		}
+		return "container=active\ndigest=sha256:abc\nlast_update=\nzincati=active\n", "", 0
+	})
+	client := server.client(t)
+
+	statuses := FleetStatus(context.Background(), &Engine{Runner: client}, []machine.Config{
+		{Name: "web", IPAddress: "127.0.0.1"},
+		{Name: "nowhere"},
+	})
+	require.Len(t, statuses, 2)
+
+	assert.NoError(t, statuses[0].Err)
+	assert.Equal(t, "127.0.0.1", statuses[0].Host)
+	assert.Equal(t, "active", statuses[0].Container)
+	assert.Equal(t, "sha256:abc", statuses[0].ImageDigest)
+	assert.Equal(t, "active", statuses[0].Zincati)
+
+	assert.ErrorContains(t, statuses[1].Err, "no fqdn or ip_address")
+}
@@ -0,0 +1,179 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportMarker identifies iago's PR comment so later runs update it instead
+// of adding another
+const reportMarker = "<!-- iago ci report -->"
+
+// PullRequest identifies the pull request a CI run reports to
+type PullRequest struct {
+	Repo    string // owner/name
+	Number  int
+	HeadSHA string // Commit check runs are attached to
+}
+
+// PullRequestFromEnv reads the pull request a GitHub Actions run was
+// triggered by from GITHUB_REPOSITORY, the event payload at GITHUB_EVENT_PATH
+// and GITHUB_REF
+func PullRequestFromEnv() (PullRequest, error) {
+	pr := PullRequest{Repo: os.Getenv("GITHUB_REPOSITORY"), HeadSHA: os.Getenv("GITHUB_SHA")}
+	if path := os.Getenv("GITHUB_EVENT_PATH"); path != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			var event struct {
+				PullRequest struct {
+					Number int `json:"number"`
+					Head   struct {
+						SHA string `json:"sha"`
+					} `json:"head"`
+				} `json:"pull_request"`
+			}
+			if json.Unmarshal(data, &event) == nil && event.PullRequest.Number != 0 {
+				pr.Number = event.PullRequest.Number
+				pr.HeadSHA = event.PullRequest.Head.SHA
+			}
+		}
+	}
+	if pr.Number == 0 {
+		// refs/pull/<number>/merge
+		if rest, ok := strings.CutPrefix(os.Getenv("GITHUB_REF"), "refs/pull/"); ok {
+			number, _, _ := strings.Cut(rest, "/")
+			pr.Number, _ = strconv.Atoi(number)
+		}
+	}
+
+	if pr.Repo == "" {
+		return pr, fmt.Errorf("GITHUB_REPOSITORY is not set; pass --repo")
+	}
+	if pr.Number == 0 {
+		return pr, fmt.Errorf("not running for a pull request; pass --pr")
+	}
+	return pr, nil
+}
+
+// CommentOnPullRequest posts body as a comment on pr, replacing the comment
+// an earlier run posted, and returns the comment's URL
+func CommentOnPullRequest(ctx context.Context, token string, pr PullRequest, body string) (string, error) {
+	body = reportMarker + "\n" + body
+
+	var comments []struct {
+		ID   int64  `json:"id"`
+		Body string `json:"body"`
+	}
+	if err := apiRequest(ctx, token, http.MethodGet, fmt.Sprintf("/repos/%s/issues/%d/comments?per_page=100", pr.Repo, pr.Number), nil, &comments); err != nil {
+		return "", fmt.Errorf("failed to list comments on %s#%d: %w", pr.Repo, pr.Number, err)
+	}
+
+	method, path := http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/comments", pr.Repo, pr.Number)
+	for _, c := range comments {
+		if strings.HasPrefix(c.Body, reportMarker) {
+			method, path = http.MethodPatch, fmt.Sprintf("/repos/%s/issues/comments/%d", pr.Repo, c.ID)
+			break
+		}
+	}
+
+	var comment struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if err := apiRequest(ctx, token, method, path, map[string]string{"body": body}, &comment); err != nil {
+		return "", fmt.Errorf("failed to comment on %s#%d: %w", pr.Repo, pr.Number, err)
+	}
+	return comment.HTMLURL, nil
+}
+
+// CheckRun is a completed check shown on a pull request's commit
+type CheckRun struct {
+	Name    string
+	Success bool
+	Title   string
+	Summary string // Markdown
+}
+
+// CreateCheckRun attaches check to pr's head commit and returns its URL. The
+// token needs checks: write, which the Actions GITHUB_TOKEN can be granted.
+func CreateCheckRun(ctx context.Context, token string, pr PullRequest, check CheckRun) (string, error) {
+	if pr.HeadSHA == "" {
+		return "", fmt.Errorf("head commit of %s#%d is unknown", pr.Repo, pr.Number)
+	}
+	conclusion := "failure"
+	if check.Success {
+		conclusion = "success"
+	}
+	request := map[string]any{
+		"name":       check.Name,
+		"head_sha":   pr.HeadSHA,
+		"status":     "completed",
+		"conclusion": conclusion,
+		"output": map[string]string{
+			"title":   check.Title,
+			"summary": check.Summary,
+		},
+	}
+	var run struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if err := apiRequest(ctx, token, http.MethodPost, fmt.Sprintf("/repos/%s/check-runs", pr.Repo), request, &run); err != nil {
+		return "", fmt.Errorf("failed to create check run on %s: %w", pr.Repo, err)
+	}
+	return run.HTMLURL, nil
+}
+
+// apiRequest sends a JSON request to the GitHub API and decodes the response
+// into result
+func apiRequest(ctx context.Context, token, method, path string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, APIURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return ErrTokenRejected
+	}
+	if resp.StatusCode/100 != 2 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Message != "" {
+			return fmt.Errorf("HTTP %d: %s", resp.StatusCode, apiErr.Message)
+		}
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,116 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPullRequestFromEnv(t *testing.T) {
+	t.Setenv("GITHUB_REPOSITORY", "octo/fleet")
+	t.Setenv("GITHUB_SHA", "merge-sha")
+	t.Setenv("GITHUB_REF", "refs/pull/7/merge")
+	t.Setenv("GITHUB_EVENT_PATH", "")
+
+	pr, err := PullRequestFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, PullRequest{Repo: "octo/fleet", Number: 7, HeadSHA: "merge-sha"}, pr)
+
+	event := filepath.Join(t.TempDir(), "event.json")
+	require.NoError(t, os.WriteFile(event, []byte(`{"pull_request":{"number":42,"head":{"sha":"head-sha"}}}`), 0644))
+	t.Setenv("GITHUB_EVENT_PATH", event)
+	pr, err = PullRequestFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, PullRequest{Repo: "octo/fleet", Number: 42, HeadSHA: "head-sha"}, pr, "the event payload names the PR's head commit")
+
+	t.Setenv("GITHUB_EVENT_PATH", "")
+	t.Setenv("GITHUB_REF", "refs/heads/main")
+	_, err = PullRequestFromEnv()
+	assert.ErrorContains(t, err, "not running for a pull request")
+}
+
+func TestCommentOnPullRequest(t *testing.T) {
+	var comments []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		var request map[string]string
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/octo/fleet/issues/7/comments":
+			json.NewEncoder(w).Encode(append([]map[string]any{{"id": 1, "body": "LGTM"}}, comments...))
+		case r.Method == http.MethodPost && r.URL.Path == "/repos/octo/fleet/issues/7/comments":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+			comments = append(comments, map[string]any{"id": 2, "body": request["body"]})
+			fmt.Fprint(w, `{"html_url":"https://github.com/octo/fleet/pull/7#issuecomment-2"}`)
+		case r.Method == http.MethodPatch && r.URL.Path == "/repos/octo/fleet/issues/comments/2":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+			comments[0]["body"] = request["body"]
+			fmt.Fprint(w, `{"html_url":"https://github.com/octo/fleet/pull/7#issuecomment-2"}`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	original := APIURL
+	APIURL = server.URL
+	t.Cleanup(func() { APIURL = original })
+
+	ctx := context.Background()
+	pr := PullRequest{Repo: "octo/fleet", Number: 7}
+	url, err := CommentOnPullRequest(ctx, "token", pr, "first run")
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/octo/fleet/pull/7#issuecomment-2", url)
+	require.Len(t, comments, 1)
+
+	_, err = CommentOnPullRequest(ctx, "token", pr, "second run")
+	require.NoError(t, err)
+	require.Len(t, comments, 1, "a second run updates the comment")
+	assert.True(t, strings.HasSuffix(comments[0]["body"].(string), "second run"))
+}
+
+func TestCreateCheckRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "/repos/octo/fleet/check-runs", r.URL.Path)
+		var request struct {
+			HeadSHA    string            `json:"head_sha"`
+			Conclusion string            `json:"conclusion"`
+			Output     map[string]string `json:"output"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, "abc123", request.HeadSHA)
+		assert.Equal(t, "failure", request.Conclusion)
+		assert.Equal(t, "1 machine failed", request.Output["title"])
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"html_url":"https://github.com/octo/fleet/runs/1"}`)
+	}))
+	defer server.Close()
+	original := APIURL
+	APIURL = server.URL
+	t.Cleanup(func() { APIURL = original })
+
+	ctx := context.Background()
+	pr := PullRequest{Repo: "octo/fleet", Number: 7, HeadSHA: "abc123"}
+	check := CheckRun{Name: "iago", Title: "1 machine failed", Summary: "details"}
+	url, err := CreateCheckRun(ctx, "token", pr, check)
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/octo/fleet/runs/1", url)
+
+	_, err = CreateCheckRun(ctx, "revoked", pr, check)
+	assert.ErrorIs(t, err, ErrTokenRejected)
+
+	_, err = CreateCheckRun(ctx, "token", PullRequest{Repo: "octo/fleet", Number: 7}, check)
+	assert.ErrorContains(t, err, "head commit")
+}
@@ -0,0 +1,80 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIURL is the GitHub REST API base URL
+var APIURL = "https://api.github.com"
+
+// ErrTokenRejected means GitHub didn't accept the token at all
+var ErrTokenRejected = errors.New("GitHub rejected the token: it is invalid, revoked or expired")
+
+// expirationLayout is the format of the github-authentication-token-expiration header
+const expirationLayout = "2006-01-02 15:04:05 MST"
+
+// TokenInfo describes a GitHub token as reported by the API
+type TokenInfo struct {
+	Classic   bool      // Classic personal access tokens report OAuth scopes; fine-grained tokens don't
+	Scopes    []string  // OAuth scopes of a classic token
+	ExpiresAt time.Time // Zero when the token doesn't expire
+}
+
+// HasScope reports whether a classic token was granted scope
+func (t TokenInfo) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// InspectToken asks the GitHub API which scopes and expiry a token has
+func InspectToken(ctx context.Context, token string) (TokenInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, APIURL+"/user", nil)
+	if err != nil {
+		return TokenInfo{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return TokenInfo{}, fmt.Errorf("failed to inspect GitHub token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return TokenInfo{}, ErrTokenRejected
+	}
+	if resp.StatusCode != http.StatusOK {
+		return TokenInfo{}, fmt.Errorf("failed to inspect GitHub token: HTTP %d", resp.StatusCode)
+	}
+
+	var info TokenInfo
+	if header, ok := resp.Header[http.CanonicalHeaderKey("X-OAuth-Scopes")]; ok {
+		info.Classic = true
+		for _, scope := range strings.Split(strings.Join(header, ","), ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				info.Scopes = append(info.Scopes, scope)
+			}
+		}
+	}
+	if expiration := resp.Header.Get("GitHub-Authentication-Token-Expiration"); expiration != "" {
+		expiresAt, err := time.Parse(expirationLayout, expiration)
+		if err != nil {
+			return TokenInfo{}, fmt.Errorf("failed to parse token expiration %q: %w", expiration, err)
+		}
+		info.ExpiresAt = expiresAt
+	}
+	return info, nil
+}
@@ -0,0 +1,54 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/user", r.URL.Path)
+		switch r.Header.Get("Authorization") {
+		case "Bearer classic":
+			w.Header().Set("X-OAuth-Scopes", "repo, write:packages")
+			w.Header().Set("GitHub-Authentication-Token-Expiration", "2026-01-02 03:04:05 UTC")
+		case "Bearer noscopes":
+			w.Header().Set("X-OAuth-Scopes", "")
+		case "Bearer fine-grained":
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"login":"octocat"}`))
+	}))
+	defer server.Close()
+	original := APIURL
+	APIURL = server.URL
+	t.Cleanup(func() { APIURL = original })
+
+	ctx := context.Background()
+	info, err := InspectToken(ctx, "classic")
+	require.NoError(t, err)
+	assert.True(t, info.Classic)
+	assert.True(t, info.HasScope("write:packages"))
+	assert.Equal(t, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), info.ExpiresAt.UTC())
+
+	info, err = InspectToken(ctx, "noscopes")
+	require.NoError(t, err)
+	assert.True(t, info.Classic)
+	assert.Empty(t, info.Scopes)
+
+	info, err = InspectToken(ctx, "fine-grained")
+	require.NoError(t, err)
+	assert.False(t, info.Classic)
+	assert.True(t, info.ExpiresAt.IsZero())
+
+	_, err = InspectToken(ctx, "revoked")
+	assert.ErrorIs(t, err, ErrTokenRejected)
+}
@@ -0,0 +1,178 @@
+package hardening
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/andreweick/iago/internal/machine"
+)
+
+//go:embed controls/*.yaml
+var catalog embed.FS
+
+const (
+	titlePrefix    = "# title:"
+	categoryPrefix = "# category:"
+)
+
+// Control is a single hardening measure, a butane fragment merged into the rendered config
+type Control struct {
+	ID       string
+	Title    string
+	Category string // kernel, sshd, auditd, sandbox or crypto
+	Content  string // butane YAML, rendered as a Go template with the machine's data
+}
+
+// Preset is a named set of controls machines opt into together
+type Preset struct {
+	Name        string
+	Description string
+	Controls    []string
+}
+
+var presets = []Preset{
+	{
+		Name:        "baseline",
+		Description: "Low-risk defaults suitable for every machine",
+		Controls:    []string{"sshd-hardening", "sysctl-hardening", "core-dumps-disabled"},
+	},
+	{
+		Name:        "cis-level1",
+		Description: "CIS-style level 1: baseline plus kernel arguments, auditd rules and unit sandboxing",
+		Controls: []string{
+			"sshd-hardening", "sysctl-hardening", "core-dumps-disabled",
+			"kernel-args-hardening", "auditd-rules", "unit-sandboxing",
+		},
+	},
+	{
+		Name:        "fips",
+		Description: "FIPS 140 mode; combine with another preset for the remaining controls",
+		Controls:    []string{"fips-mode"},
+	},
+}
+
+// Presets returns all presets, in the order they are documented
+func Presets() []Preset {
+	return append([]Preset(nil), presets...)
+}
+
+// GetPreset returns a preset by name
+func GetPreset(name string) (Preset, error) {
+	for _, p := range presets {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	names := make([]string, len(presets))
+	for i, p := range presets {
+		names[i] = p.Name
+	}
+	return Preset{}, fmt.Errorf("unknown hardening preset '%s' (available: %s)", name, strings.Join(names, ", "))
+}
+
+// Controls returns all controls in the embedded catalog, sorted by ID
+func Controls() ([]Control, error) {
+	entries, err := catalog.ReadDir("controls")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hardening controls: %w", err)
+	}
+
+	var result []Control
+	for _, entry := range entries {
+		control, err := GetControl(strings.TrimSuffix(entry.Name(), ".yaml"))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, control)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// GetControl returns a control by ID
+func GetControl(id string) (Control, error) {
+	content, err := catalog.ReadFile(path.Join("controls", id+".yaml"))
+	if err != nil {
+		return Control{}, fmt.Errorf("unknown hardening control '%s' (see 'iago hardening list')", id)
+	}
+
+	control := Control{ID: id, Content: string(content)}
+	for _, line := range strings.Split(control.Content, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		if strings.HasPrefix(line, titlePrefix) {
+			control.Title = strings.TrimSpace(strings.TrimPrefix(line, titlePrefix))
+		}
+		if strings.HasPrefix(line, categoryPrefix) {
+			control.Category = strings.TrimSpace(strings.TrimPrefix(line, categoryPrefix))
+		}
+	}
+
+	return control, nil
+}
+
+// Plan is the set of controls a machine opts into through its presets
+type Plan struct {
+	Presets  []string
+	Controls []Control // applied, in preset order without duplicates
+	Excluded []Control // in a selected preset but opted out of
+}
+
+// Settings returns the machine's hardening settings: its own presets replace
+// the defaults' presets, and exclusions from both apply
+func Settings(m machine.Config, defaults machine.Defaults) machine.HardeningConfig {
+	settings := machine.HardeningConfig{
+		Presets: defaults.Hardening.Presets,
+		Exclude: append(append([]string(nil), defaults.Hardening.Exclude...), m.Hardening.Exclude...),
+	}
+	if len(m.Hardening.Presets) > 0 {
+		settings.Presets = m.Hardening.Presets
+	}
+	return settings
+}
+
+// PlanFor resolves settings into the controls to apply
+func PlanFor(settings machine.HardeningConfig) (Plan, error) {
+	plan := Plan{Presets: settings.Presets}
+
+	excluded := make(map[string]bool)
+	for _, id := range settings.Exclude {
+		if _, err := GetControl(id); err != nil {
+			return Plan{}, err
+		}
+		excluded[id] = true
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range settings.Presets {
+		preset, err := GetPreset(name)
+		if err != nil {
+			return Plan{}, err
+		}
+		for _, id := range preset.Controls {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			control, err := GetControl(id)
+			if err != nil {
+				return Plan{}, err
+			}
+			if excluded[id] {
+				plan.Excluded = append(plan.Excluded, control)
+			} else {
+				plan.Controls = append(plan.Controls, control)
+			}
+		}
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,95 @@
+package hardening
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestControls(t *testing.T) {
+	all, err := Controls()
+	require.NoError(t, err)
+	require.NotEmpty(t, all)
+
+	for _, control := range all {
+		assert.NotEmpty(t, control.Title, "control %s should have a title", control.ID)
+		assert.NotEmpty(t, control.Category, "control %s should have a category", control.ID)
+	}
+}
+
+func TestPresetsReferenceKnownControls(t *testing.T) {
+	for _, preset := range Presets() {
+		for _, id := range preset.Controls {
+			_, err := GetControl(id)
+			assert.NoError(t, err, "preset %s", preset.Name)
+		}
+	}
+}
+
+func TestControlsTranslateToIgnition(t *testing.T) {
+	all, err := Controls()
+	require.NoError(t, err)
+
+	data := map[string]interface{}{
+		"User":  map[string]string{"Username": "core"},
+		"Admin": map[string]string{"Username": "admin"},
+	}
+	for _, control := range all {
+		tmpl, err := template.New(control.ID).Parse(control.Content)
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		require.NoError(t, tmpl.Execute(&buf, data))
+
+		butane := "variant: fcos\nversion: 1.5.0\n" + buf.String()
+		_, report, err := config.TranslateBytes([]byte(butane), common.TranslateBytesOptions{})
+		assert.NoError(t, err, "control %s should be valid butane: %s", control.ID, report.String())
+	}
+}
+
+func TestPlanFor(t *testing.T) {
+	plan, err := PlanFor(machine.HardeningConfig{
+		Presets: []string{"baseline", "cis-level1", "fips"},
+		Exclude: []string{"unit-sandboxing"},
+	})
+	require.NoError(t, err)
+
+	var ids []string
+	for _, control := range plan.Controls {
+		ids = append(ids, control.ID)
+	}
+	assert.Equal(t, []string{
+		"sshd-hardening", "sysctl-hardening", "core-dumps-disabled",
+		"kernel-args-hardening", "auditd-rules", "fips-mode",
+	}, ids, "controls shared by presets should appear once")
+	require.Len(t, plan.Excluded, 1)
+	assert.Equal(t, "unit-sandboxing", plan.Excluded[0].ID)
+
+	_, err = PlanFor(machine.HardeningConfig{Presets: []string{"nope"}})
+	assert.ErrorContains(t, err, "unknown hardening preset 'nope'")
+
+	_, err = PlanFor(machine.HardeningConfig{Exclude: []string{"nope"}})
+	assert.ErrorContains(t, err, "unknown hardening control 'nope'")
+}
+
+func TestSettings(t *testing.T) {
+	defaults := machine.Defaults{Hardening: machine.HardeningConfig{
+		Presets: []string{"baseline"},
+		Exclude: []string{"core-dumps-disabled"},
+	}}
+
+	inherited := Settings(machine.Config{Name: "web"}, defaults)
+	assert.Equal(t, []string{"baseline"}, inherited.Presets)
+
+	overridden := Settings(machine.Config{
+		Name:      "db",
+		Hardening: machine.HardeningConfig{Presets: []string{"cis-level1"}, Exclude: []string{"auditd-rules"}},
+	}, defaults)
+	assert.Equal(t, []string{"cis-level1"}, overridden.Presets)
+	assert.Equal(t, []string{"core-dumps-disabled", "auditd-rules"}, overridden.Exclude)
+}
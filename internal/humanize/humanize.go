@@ -0,0 +1,138 @@
+// Package humanize formats durations, byte sizes and times the way iago
+// prints them to people: short, rounded to what is worth reading, and with
+// the decimal separator of the user's locale. Machine-readable output
+// (--output json|yaml, state files) keeps raw values.
+package humanize
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// commaLanguages write decimals with a comma, e.g. 1,5 MiB
+var commaLanguages = map[string]bool{
+	"bg": true, "ca": true, "cs": true, "da": true, "de": true, "el": true, "es": true, "et": true,
+	"fi": true, "fr": true, "hr": true, "hu": true, "id": true, "it": true, "lt": true, "lv": true,
+	"nb": true, "nl": true, "nn": true, "pl": true, "pt": true, "ro": true, "ru": true, "sk": true,
+	"sl": true, "sr": true, "sv": true, "tr": true, "uk": true, "vi": true,
+}
+
+// decimalSeparator returns the decimal separator of the locale in LC_ALL,
+// LC_NUMERIC or LANG, in that order, defaulting to "."
+func decimalSeparator() string {
+	for _, name := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		locale := os.Getenv(name)
+		if locale == "" {
+			continue
+		}
+		language, _, _ := strings.Cut(locale, "_")
+		language, _, _ = strings.Cut(language, ".")
+		if commaLanguages[strings.ToLower(language)] {
+			return ","
+		}
+		return "."
+	}
+	return "."
+}
+
+// decimal formats v with one decimal place, dropping it for whole numbers
+func decimal(v float64) string {
+	s := fmt.Sprintf("%.1f", v)
+	s = strings.TrimSuffix(s, ".0")
+	return strings.Replace(s, ".", decimalSeparator(), 1)
+}
+
+// Bytes formats a size in powers of 1024, e.g. "512 B", "1.5 KiB" or "23 MiB"
+func Bytes(n int64) string {
+	if n < 0 {
+		return "-" + Bytes(-n)
+	}
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	for _, unit := range []string{"KiB", "MiB", "GiB", "TiB"} {
+		value /= 1024
+		if value < 1024 || unit == "TiB" {
+			if value >= 100 {
+				return fmt.Sprintf("%.0f %s", value, unit)
+			}
+			return decimal(value) + " " + unit
+		}
+	}
+	return "" // never reached
+}
+
+// Duration formats d with its two largest units, e.g. "850ms", "12s",
+// "3m 5s", "2h 14m" or "3d 4h"
+func Duration(d time.Duration) string {
+	if d < 0 {
+		return "-" + Duration(-d)
+	}
+	switch {
+	case d < time.Second:
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	case d < time.Minute:
+		return decimal(d.Round(100*time.Millisecond).Seconds()) + "s"
+	}
+
+	d = d.Round(time.Second)
+	units := []struct {
+		size time.Duration
+		name string
+	}{{24 * time.Hour, "d"}, {time.Hour, "h"}, {time.Minute, "m"}, {time.Second, "s"}}
+	for i, unit := range units[:len(units)-1] {
+		if d < unit.size {
+			continue
+		}
+		next := units[i+1]
+		whole, rest := d/unit.size, (d%unit.size)/next.size
+		if rest == 0 {
+			return fmt.Sprintf("%d%s", whole, unit.name)
+		}
+		return fmt.Sprintf("%d%s %d%s", whole, unit.name, rest, next.name)
+	}
+	return "" // never reached
+}
+
+// Ago formats t relative to now, e.g. "just now", "5m ago", "3h ago" or
+// "in 2d"; past a month it gives the date instead
+func Ago(t, now time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	d := now.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+	if d < 10*time.Second {
+		return "just now"
+	}
+	if d >= 30*24*time.Hour {
+		return t.Local().Format("2006-01-02")
+	}
+
+	var rough string
+	switch {
+	case d < time.Minute:
+		rough = fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		rough = fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		rough = fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		rough = fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+	if future {
+		return "in " + rough
+	}
+	return rough + " ago"
+}
+
+// Since formats t relative to the current time
+func Since(t time.Time) string {
+	return Ago(t, time.Now())
+}
@@ -0,0 +1,61 @@
+package humanize
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBytes(t *testing.T) {
+	t.Setenv("LC_ALL", "")
+	t.Setenv("LC_NUMERIC", "")
+	t.Setenv("LANG", "en_US.UTF-8")
+	for n, want := range map[int64]string{
+		0:                 "0 B",
+		512:               "512 B",
+		1024:              "1 KiB",
+		1536:              "1.5 KiB",
+		23 * 1024 * 1024:  "23 MiB",
+		150 * 1024 * 1024: "150 MiB",
+		1288490189:        "1.2 GiB",
+		-2048:             "-2 KiB",
+		3 << 40:           "3 TiB",
+		5000 * (1 << 40):  "5000 TiB",
+	} {
+		assert.Equal(t, want, Bytes(n), "%d bytes", n)
+	}
+
+	t.Setenv("LANG", "de_DE.UTF-8")
+	assert.Equal(t, "1,5 KiB", Bytes(1536))
+	t.Setenv("LC_NUMERIC", "C")
+	assert.Equal(t, "1.5 KiB", Bytes(1536), "LC_NUMERIC overrides LANG")
+}
+
+func TestDuration(t *testing.T) {
+	t.Setenv("LC_ALL", "C")
+	for d, want := range map[time.Duration]string{
+		850 * time.Millisecond:             "850ms",
+		12 * time.Second:                   "12s",
+		12340 * time.Millisecond:           "12.3s",
+		time.Minute:                        "1m",
+		3*time.Minute + 5*time.Second:      "3m 5s",
+		2*time.Hour + 14*time.Minute + 9e9: "2h 14m",
+		76 * time.Hour:                     "3d 4h",
+		-90 * time.Second:                  "-1m 30s",
+	} {
+		assert.Equal(t, want, Duration(d), "%s", d)
+	}
+}
+
+func TestAgo(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "just now", Ago(now.Add(-3*time.Second), now))
+	assert.Equal(t, "42s ago", Ago(now.Add(-42*time.Second), now))
+	assert.Equal(t, "5m ago", Ago(now.Add(-5*time.Minute-30*time.Second), now))
+	assert.Equal(t, "3h ago", Ago(now.Add(-3*time.Hour), now))
+	assert.Equal(t, "2d ago", Ago(now.Add(-50*time.Hour), now))
+	assert.Equal(t, "in 5m", Ago(now.Add(5*time.Minute), now))
+	assert.Equal(t, "never", Ago(time.Time{}, now))
+	assert.Equal(t, time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC).Local().Format("2006-01-02"), Ago(time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC), now))
+}
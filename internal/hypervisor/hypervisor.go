@@ -0,0 +1,101 @@
+package hypervisor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/andreweick/iago/internal/machine"
+)
+
+// ConsoleType selects which console a backend should open
+type ConsoleType string
+
+const (
+	ConsoleSerial ConsoleType = "serial"
+	ConsoleVNC    ConsoleType = "vnc"
+)
+
+// Command is a single external command run on behalf of a backend
+type Command struct {
+	Args        []string
+	IgnoreError bool // e.g. stopping a VM that is already stopped
+}
+
+// Backend is implemented by each supported hypervisor
+type Backend interface {
+	Name() string
+	// ConsoleCommand returns the command line that opens a console for the machine
+	ConsoleCommand(m machine.Config, consoleType ConsoleType) ([]string, error)
+	// DeployCommands returns the commands that upload an ignition file and (re)start the VM
+	DeployCommands(m machine.Config, ignitionFile string) ([]Command, error)
+	// StatusCommand returns the command line that reports the VM power state
+	StatusCommand(m machine.Config) ([]string, error)
+}
+
+// NewBackend returns the backend configured in defaults.toml
+func NewBackend(cfg machine.HypervisorConfig) (Backend, error) {
+	switch cfg.Backend {
+	case "proxmox":
+		if cfg.Proxmox.Host == "" {
+			return nil, fmt.Errorf("hypervisor.proxmox.host must be set in defaults.toml")
+		}
+		backend := &ProxmoxBackend{config: cfg.Proxmox}
+		if _, _, err := backend.ignitionOption(machine.Config{}); err != nil {
+			return nil, err
+		}
+		return backend, nil
+	case "libvirt":
+		return &LibvirtBackend{config: cfg.Libvirt}, nil
+	case "":
+		return nil, fmt.Errorf("no hypervisor backend configured: set [hypervisor] backend = \"proxmox\" or \"libvirt\" in defaults.toml")
+	default:
+		return nil, fmt.Errorf("unknown hypervisor backend '%s' (supported: proxmox, libvirt)", cfg.Backend)
+	}
+}
+
+// OpenConsole runs the backend console command attached to the current terminal
+func OpenConsole(ctx context.Context, backend Backend, m machine.Config, consoleType ConsoleType) error {
+	args, err := backend.ConsoleCommand(m, consoleType)
+	if err != nil {
+		return err
+	}
+
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return fmt.Errorf("%s not found in PATH (required by the %s backend)", args[0], backend.Name())
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("console session failed: %w", err)
+	}
+	return nil
+}
+
+// RunCommands runs backend commands in order, streaming their output
+func RunCommands(ctx context.Context, commands []Command, stdout, stderr io.Writer) error {
+	for _, c := range commands {
+		cmd := exec.CommandContext(ctx, c.Args[0], c.Args[1:]...)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		if err := cmd.Run(); err != nil && !c.IgnoreError {
+			return fmt.Errorf("'%s' failed: %w", strings.Join(c.Args, " "), err)
+		}
+	}
+	return nil
+}
+
+// openCommand returns the platform command for opening a URL
+func openCommand() string {
+	if runtime.GOOS == "darwin" {
+		return "open"
+	}
+	return "xdg-open"
+}
@@ -0,0 +1,125 @@
+package hypervisor
+
+import (
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBackend(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      machine.HypervisorConfig
+		expected    string
+		expectError string
+	}{
+		{
+			name:     "proxmox",
+			config:   machine.HypervisorConfig{Backend: "proxmox", Proxmox: machine.ProxmoxConfig{Host: "pve.example.com"}},
+			expected: "proxmox",
+		},
+		{
+			name:     "libvirt",
+			config:   machine.HypervisorConfig{Backend: "libvirt"},
+			expected: "libvirt",
+		},
+		{
+			name:        "proxmox without host",
+			config:      machine.HypervisorConfig{Backend: "proxmox"},
+			expectError: "hypervisor.proxmox.host",
+		},
+		{
+			name:        "not configured",
+			config:      machine.HypervisorConfig{},
+			expectError: "no hypervisor backend configured",
+		},
+		{
+			name:        "unknown backend",
+			config:      machine.HypervisorConfig{Backend: "vmware"},
+			expectError: "unknown hypervisor backend 'vmware'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, err := NewBackend(tt.config)
+			if tt.expectError != "" {
+				assert.ErrorContains(t, err, tt.expectError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, backend.Name())
+		})
+	}
+}
+
+func TestProxmoxConsoleCommand(t *testing.T) {
+	backend := &ProxmoxBackend{config: machine.ProxmoxConfig{Host: "pve.example.com", Node: "node1"}}
+	m := machine.Config{Name: "it-tools", VMID: 104}
+
+	args, err := backend.ConsoleCommand(m, ConsoleSerial)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ssh", "-t", "root@pve.example.com", "qm", "terminal", "104"}, args)
+
+	args, err = backend.ConsoleCommand(m, ConsoleVNC)
+	require.NoError(t, err)
+	assert.Len(t, args, 2)
+	assert.Equal(t, "https://pve.example.com:8006/?console=kvm&novnc=1&vmid=104&vmname=it-tools&node=node1", args[1])
+
+	_, err = backend.ConsoleCommand(machine.Config{Name: "no-id"}, ConsoleSerial)
+	assert.ErrorContains(t, err, "no vm_id set")
+}
+
+func TestLibvirtConsoleCommand(t *testing.T) {
+	m := machine.Config{Name: "it-tools"}
+
+	backend := &LibvirtBackend{}
+	args, err := backend.ConsoleCommand(m, ConsoleSerial)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"virsh", "-c", DefaultLibvirtURI, "console", "it-tools"}, args)
+
+	backend = &LibvirtBackend{config: machine.LibvirtConfig{URI: "qemu+ssh://kvm/system"}}
+	args, err = backend.ConsoleCommand(m, ConsoleVNC)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"virt-viewer", "-c", "qemu+ssh://kvm/system", "it-tools"}, args)
+
+	_, err = backend.ConsoleCommand(m, ConsoleType("spice"))
+	assert.ErrorContains(t, err, "unsupported console type")
+}
+
+func TestProxmoxDeployCommands(t *testing.T) {
+	backend := &ProxmoxBackend{config: machine.ProxmoxConfig{Host: "pve.example.com"}}
+
+	commands, err := backend.DeployCommands(machine.Config{Name: "it-tools", VMID: 104}, "output/ignition/it-tools.ign")
+	require.NoError(t, err)
+	require.Len(t, commands, 4)
+	assert.Equal(t, []string{"scp", "output/ignition/it-tools.ign", "root@pve.example.com:/var/lib/vz/snippets/it-tools.ign"}, commands[0].Args)
+	assert.Equal(t, []string{"ssh", "root@pve.example.com", "qm", "set", "104", "--cicustom", "'user=local:snippets/it-tools.ign'"}, commands[1].Args,
+		"ignition goes where provision proxmox put it: the cloud-init user data")
+	assert.True(t, commands[2].IgnoreError, "stopping an already stopped VM should not fail the deploy")
+	assert.Equal(t, []string{"ssh", "root@pve.example.com", "qm", "start", "104"}, commands[3].Args)
+
+	backend.config.IgnitionDelivery = ProxmoxDeliveryFwCfg
+	commands, err = backend.DeployCommands(machine.Config{Name: "it-tools", VMID: 104}, "output/ignition/it-tools.ign")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ssh", "root@pve.example.com", "qm", "set", "104", "--args", "'-fw_cfg name=opt/com.coreos/config,file=/var/lib/vz/snippets/it-tools.ign'"}, commands[1].Args)
+
+	_, err = NewBackend(machine.HypervisorConfig{Backend: "proxmox", Proxmox: machine.ProxmoxConfig{Host: "pve", IgnitionDelivery: "pxe"}})
+	assert.ErrorContains(t, err, "unknown hypervisor.proxmox.ignition_delivery")
+
+	status, err := backend.StatusCommand(machine.Config{Name: "it-tools", VMID: 104})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ssh", "root@pve.example.com", "qm", "status", "104"}, status)
+}
+
+func TestLibvirtDeployCommands(t *testing.T) {
+	backend := &LibvirtBackend{config: machine.LibvirtConfig{IgnitionDir: "/srv/ignition"}}
+
+	commands, err := backend.DeployCommands(machine.Config{Name: "it-tools"}, "out/it-tools.ign")
+	require.NoError(t, err)
+	require.Len(t, commands, 3)
+	assert.Equal(t, []string{"install", "-m", "0644", "out/it-tools.ign", "/srv/ignition/it-tools.ign"}, commands[0].Args)
+	assert.Equal(t, []string{"virsh", "-c", DefaultLibvirtURI, "start", "it-tools"}, commands[2].Args)
+}
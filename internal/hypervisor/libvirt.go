@@ -0,0 +1,66 @@
+package hypervisor
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/andreweick/iago/internal/machine"
+)
+
+// DefaultLibvirtURI is used when no URI is configured
+const DefaultLibvirtURI = "qemu:///system"
+
+// DefaultLibvirtIgnitionDir is where ignition files referenced by domain fw_cfg entries live
+const DefaultLibvirtIgnitionDir = "/var/lib/libvirt/images/ignition"
+
+// LibvirtBackend reaches domains through virsh/virt-viewer; the domain name is the machine name
+type LibvirtBackend struct {
+	config machine.LibvirtConfig
+}
+
+func (l *LibvirtBackend) Name() string {
+	return "libvirt"
+}
+
+// ConsoleCommand opens `virsh console` for serial consoles or virt-viewer for graphical consoles
+func (l *LibvirtBackend) ConsoleCommand(m machine.Config, consoleType ConsoleType) ([]string, error) {
+	switch consoleType {
+	case ConsoleSerial:
+		return []string{"virsh", "-c", l.uri(), "console", m.Name}, nil
+	case ConsoleVNC:
+		return []string{"virt-viewer", "-c", l.uri(), m.Name}, nil
+	default:
+		return nil, fmt.Errorf("unsupported console type '%s'", consoleType)
+	}
+}
+
+// DeployCommands installs the ignition file at the path the domain's fw_cfg
+// entry references and power-cycles the domain
+func (l *LibvirtBackend) DeployCommands(m machine.Config, ignitionFile string) ([]Command, error) {
+	target := filepath.Join(l.ignitionDir(), m.Name+".ign")
+
+	return []Command{
+		{Args: []string{"install", "-m", "0644", ignitionFile, target}},
+		{Args: []string{"virsh", "-c", l.uri(), "destroy", m.Name}, IgnoreError: true},
+		{Args: []string{"virsh", "-c", l.uri(), "start", m.Name}},
+	}, nil
+}
+
+// StatusCommand reports the domain state with `virsh domstate`
+func (l *LibvirtBackend) StatusCommand(m machine.Config) ([]string, error) {
+	return []string{"virsh", "-c", l.uri(), "domstate", m.Name}, nil
+}
+
+func (l *LibvirtBackend) ignitionDir() string {
+	if l.config.IgnitionDir == "" {
+		return DefaultLibvirtIgnitionDir
+	}
+	return l.config.IgnitionDir
+}
+
+func (l *LibvirtBackend) uri() string {
+	if l.config.URI == "" {
+		return DefaultLibvirtURI
+	}
+	return l.config.URI
+}
@@ -0,0 +1,130 @@
+package hypervisor
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/andreweick/iago/internal/machine"
+)
+
+// DefaultProxmoxSnippetsDir is the snippets directory of the default "local" storage
+const DefaultProxmoxSnippetsDir = "/var/lib/vz/snippets"
+
+// How Proxmox VMs are given their ignition snippet, set with
+// [hypervisor.proxmox] ignition_delivery
+const (
+	// ProxmoxDeliveryCloudInit passes it as the cloud-init drive's user data,
+	// which Fedora CoreOS reads as Ignition on Proxmox VE
+	ProxmoxDeliveryCloudInit = "cloudinit"
+	// ProxmoxDeliveryFwCfg passes it through QEMU fw_cfg; only root@pam may set it
+	ProxmoxDeliveryFwCfg = "fw_cfg"
+)
+
+// ProxmoxBackend reaches VMs through the Proxmox VE host
+type ProxmoxBackend struct {
+	config machine.ProxmoxConfig
+}
+
+func (p *ProxmoxBackend) Name() string {
+	return "proxmox"
+}
+
+// ConsoleCommand opens `qm terminal` over SSH for serial consoles, or the
+// noVNC page in the browser for graphical consoles
+func (p *ProxmoxBackend) ConsoleCommand(m machine.Config, consoleType ConsoleType) ([]string, error) {
+	if m.VMID == 0 {
+		return nil, fmt.Errorf("machine '%s' has no vm_id set in machine.toml (required for proxmox)", m.Name)
+	}
+
+	switch consoleType {
+	case ConsoleSerial:
+		return []string{"ssh", "-t", p.sshTarget(), "qm", "terminal", fmt.Sprint(m.VMID)}, nil
+	case ConsoleVNC:
+		return []string{openCommand(), p.vncURL(m)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported console type '%s'", consoleType)
+	}
+}
+
+// DeployCommands copies the ignition file into the snippets directory, hands
+// it to the VM as ignition_delivery says and restarts the VM so ignition runs
+// on the next boot
+func (p *ProxmoxBackend) DeployCommands(m machine.Config, ignitionFile string) ([]Command, error) {
+	if m.VMID == 0 {
+		return nil, fmt.Errorf("machine '%s' has no vm_id set in machine.toml (required for proxmox)", m.Name)
+	}
+	option, value, err := p.ignitionOption(m)
+	if err != nil {
+		return nil, err
+	}
+
+	vmid := fmt.Sprint(m.VMID)
+	return []Command{
+		p.uploadCommand(m, ignitionFile),
+		{Args: []string{"ssh", p.sshTarget(), "qm", "set", vmid, "--" + option, fmt.Sprintf("'%s'", value)}},
+		{Args: []string{"ssh", p.sshTarget(), "qm", "stop", vmid}, IgnoreError: true},
+		{Args: []string{"ssh", p.sshTarget(), "qm", "start", vmid}},
+	}, nil
+}
+
+// SnippetUploadCommand copies an ignition file into the Proxmox host's
+// snippets directory as <name>.ign, where iago provision proxmox expects it
+func SnippetUploadCommand(cfg machine.ProxmoxConfig, m machine.Config, ignitionFile string) Command {
+	return (&ProxmoxBackend{config: cfg}).uploadCommand(m, ignitionFile)
+}
+
+// SnippetRemoveCommand deletes a machine's ignition snippet from the Proxmox host
+func SnippetRemoveCommand(cfg machine.ProxmoxConfig, m machine.Config) Command {
+	p := &ProxmoxBackend{config: cfg}
+	return Command{Args: []string{"ssh", p.sshTarget(), "rm", "-f", path.Join(p.snippetsDir(), m.Name+".ign")}}
+}
+
+// ignitionOption returns the VM option, and its value, that hands m's
+// ignition snippet to the VM
+func (p *ProxmoxBackend) ignitionOption(m machine.Config) (string, string, error) {
+	switch p.config.IgnitionDelivery {
+	case "", ProxmoxDeliveryCloudInit:
+		return "cicustom", fmt.Sprintf("user=%s:snippets/%s.ign", valueOr(p.config.SnippetsStorage, "local"), m.Name), nil
+	case ProxmoxDeliveryFwCfg:
+		return "args", fmt.Sprintf("-fw_cfg name=opt/com.coreos/config,file=%s", path.Join(p.snippetsDir(), m.Name+".ign")), nil
+	default:
+		return "", "", fmt.Errorf("unknown hypervisor.proxmox.ignition_delivery '%s' (supported: %s, %s)", p.config.IgnitionDelivery, ProxmoxDeliveryCloudInit, ProxmoxDeliveryFwCfg)
+	}
+}
+
+func (p *ProxmoxBackend) uploadCommand(m machine.Config, ignitionFile string) Command {
+	remotePath := path.Join(p.snippetsDir(), m.Name+".ign")
+	return Command{Args: []string{"scp", ignitionFile, fmt.Sprintf("%s:%s", p.sshTarget(), remotePath)}}
+}
+
+// StatusCommand reports the VM state with `qm status`
+func (p *ProxmoxBackend) StatusCommand(m machine.Config) ([]string, error) {
+	if m.VMID == 0 {
+		return nil, fmt.Errorf("machine '%s' has no vm_id set in machine.toml (required for proxmox)", m.Name)
+	}
+	return []string{"ssh", p.sshTarget(), "qm", "status", fmt.Sprint(m.VMID)}, nil
+}
+
+func (p *ProxmoxBackend) snippetsDir() string {
+	if p.config.SnippetsDir == "" {
+		return DefaultProxmoxSnippetsDir
+	}
+	return p.config.SnippetsDir
+}
+
+func (p *ProxmoxBackend) sshTarget() string {
+	user := p.config.SSHUser
+	if user == "" {
+		user = "root"
+	}
+	return fmt.Sprintf("%s@%s", user, p.config.Host)
+}
+
+func (p *ProxmoxBackend) vncURL(m machine.Config) string {
+	node := p.config.Node
+	if node == "" {
+		node = "pve"
+	}
+	return fmt.Sprintf("https://%s:8006/?console=kvm&novnc=1&vmid=%d&vmname=%s&node=%s",
+		p.config.Host, m.VMID, m.Name, node)
+}
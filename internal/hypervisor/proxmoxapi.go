@@ -0,0 +1,270 @@
+package hypervisor
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/machine"
+)
+
+// ProxmoxClient talks to the Proxmox VE API of one node with an API token
+type ProxmoxClient struct {
+	config       machine.ProxmoxConfig
+	baseURL      string
+	token        string
+	http         *http.Client
+	pollInterval time.Duration
+}
+
+// NewProxmoxClient returns a client for [hypervisor.proxmox], authenticating
+// as token_id with secret
+func NewProxmoxClient(cfg machine.ProxmoxConfig, secret string) (*ProxmoxClient, error) {
+	switch {
+	case cfg.Host == "":
+		return nil, fmt.Errorf("hypervisor.proxmox.host must be set in defaults.toml")
+	case cfg.TokenID == "":
+		return nil, fmt.Errorf("hypervisor.proxmox.token_id must be set in defaults.toml (e.g. \"iago@pve!provision\")")
+	case secret == "":
+		return nil, fmt.Errorf("no Proxmox API token secret")
+	}
+	if cfg.Node == "" {
+		cfg.Node = "pve"
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // #nosec G402 -- explicit opt-in for self-signed lab hosts
+	}
+	baseURL := cfg.Host
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "https://" + baseURL + ":8006"
+	}
+	return &ProxmoxClient{
+		config:       cfg,
+		baseURL:      strings.TrimRight(baseURL, "/") + "/api2/json",
+		token:        fmt.Sprintf("PVEAPIToken=%s=%s", cfg.TokenID, secret),
+		http:         &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		pollInterval: 2 * time.Second,
+	}, nil
+}
+
+// NextID asks the cluster for an unused VM ID
+func (c *ProxmoxClient) NextID(ctx context.Context) (int, error) {
+	var id json.Number
+	if err := c.do(ctx, http.MethodGet, "/cluster/nextid", nil, &id); err != nil {
+		return 0, fmt.Errorf("failed to allocate a VM ID: %w", err)
+	}
+	n, err := strconv.Atoi(id.String())
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate a VM ID: unexpected answer %q", id)
+	}
+	return n, nil
+}
+
+// VMExists reports whether the node has a VM with vmid
+func (c *ProxmoxClient) VMExists(ctx context.Context, vmid int) (bool, error) {
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/%d/status/current", c.qemuPath(), vmid), nil, nil)
+	var apiErr *proxmoxError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &apiErr) && strings.Contains(apiErr.message, "does not exist"):
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to look up VM %d: %w", vmid, err)
+	}
+}
+
+// ProvisionVM creates VM vmid for m by cloning the configured template, gives
+// it m's MAC address and the ignition snippet uploaded as <name>.ign, the way
+// ignition_delivery says, and starts it when start is set
+func (c *ProxmoxClient) ProvisionVM(ctx context.Context, m machine.Config, vmid int, start bool, progress io.Writer) error {
+	if c.config.Template == 0 {
+		return fmt.Errorf("hypervisor.proxmox.template must name the VM ID of a Fedora CoreOS template")
+	}
+	if m.MACAddress == "" {
+		return fmt.Errorf("machine '%s' has no mac_address (generate one with iago init --generate-mac)", m.Name)
+	}
+	option, value, err := (&ProxmoxBackend{config: c.config}).ignitionOption(m)
+	if err != nil {
+		return err
+	}
+	exists, err := c.VMExists(ctx, vmid)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("VM %d already exists on %s; use iago up to re-deploy it", vmid, c.config.Node)
+	}
+
+	fmt.Fprintf(progress, "Cloning template %d to VM %d (%s)...\n", c.config.Template, vmid, m.Name)
+	var upid string
+	clone := url.Values{"newid": {strconv.Itoa(vmid)}, "name": {m.Name}, "full": {"1"}}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("%s/%d/clone", c.qemuPath(), c.config.Template), clone, &upid); err != nil {
+		return fmt.Errorf("failed to clone template %d: %w", c.config.Template, err)
+	}
+	if err := c.waitTask(ctx, upid); err != nil {
+		return fmt.Errorf("failed to clone template %d: %w", c.config.Template, err)
+	}
+
+	fmt.Fprintf(progress, "Configuring VM %d...\n", vmid)
+	settings := url.Values{
+		"net0":        {fmt.Sprintf("virtio=%s,bridge=%s", strings.ToUpper(m.MACAddress), valueOr(c.config.Bridge, "vmbr0"))},
+		option:        {value},
+		"description": {fmt.Sprintf("Managed by iago (machine %s)", m.Name)},
+	}
+	if option == "cicustom" {
+		settings.Set("ide2", valueOr(c.config.CloudInitStorage, "local-lvm")+":cloudinit")
+	}
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("%s/%d/config", c.qemuPath(), vmid), settings, nil); err != nil {
+		return fmt.Errorf("failed to configure VM %d: %w", vmid, err)
+	}
+
+	if !start {
+		return nil
+	}
+	fmt.Fprintf(progress, "Starting VM %d...\n", vmid)
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("%s/%d/status/start", c.qemuPath(), vmid), nil, &upid); err != nil {
+		return fmt.Errorf("failed to start VM %d: %w", vmid, err)
+	}
+	if err := c.waitTask(ctx, upid); err != nil {
+		return fmt.Errorf("failed to start VM %d: %w", vmid, err)
+	}
+	return nil
+}
+
+// DestroyVM stops VM vmid if it is running and deletes it with its disks
+func (c *ProxmoxClient) DestroyVM(ctx context.Context, vmid int, progress io.Writer) error {
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/%d/status/current", c.qemuPath(), vmid), nil, &status); err != nil {
+		return fmt.Errorf("failed to look up VM %d: %w", vmid, err)
+	}
+
+	var upid string
+	if status.Status == "running" {
+		fmt.Fprintf(progress, "Stopping VM %d...\n", vmid)
+		if err := c.do(ctx, http.MethodPost, fmt.Sprintf("%s/%d/status/stop", c.qemuPath(), vmid), nil, &upid); err != nil {
+			return fmt.Errorf("failed to stop VM %d: %w", vmid, err)
+		}
+		if err := c.waitTask(ctx, upid); err != nil {
+			return fmt.Errorf("failed to stop VM %d: %w", vmid, err)
+		}
+	}
+
+	fmt.Fprintf(progress, "Deleting VM %d...\n", vmid)
+	query := url.Values{"purge": {"1"}, "destroy-unreferenced-disks": {"1"}}
+	if err := c.do(ctx, http.MethodDelete, fmt.Sprintf("%s/%d?%s", c.qemuPath(), vmid, query.Encode()), nil, &upid); err != nil {
+		return fmt.Errorf("failed to delete VM %d: %w", vmid, err)
+	}
+	if err := c.waitTask(ctx, upid); err != nil {
+		return fmt.Errorf("failed to delete VM %d: %w", vmid, err)
+	}
+	return nil
+}
+
+// waitTask polls a task until it finishes and returns its failure, if any
+func (c *ProxmoxClient) waitTask(ctx context.Context, upid string) error {
+	for {
+		var status struct {
+			Status     string `json:"status"`
+			ExitStatus string `json:"exitstatus"`
+		}
+		if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/nodes/%s/tasks/%s/status", c.config.Node, url.PathEscape(upid)), nil, &status); err != nil {
+			return err
+		}
+		if status.Status == "stopped" {
+			if status.ExitStatus != "OK" {
+				return fmt.Errorf("task %s: %s", upid, status.ExitStatus)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// proxmoxError is a request the API refused
+type proxmoxError struct {
+	status  string
+	message string
+}
+
+func (e *proxmoxError) Error() string {
+	return fmt.Sprintf("%s: %s", e.status, e.message)
+}
+
+// do sends a form-encoded request and decodes the "data" member of the answer into out
+func (c *ProxmoxClient) do(ctx context.Context, method, path string, form url.Values, out any) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create Proxmox request: %w", err)
+	}
+	req.Header.Set("Authorization", c.token)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var answer struct {
+		Data    json.RawMessage   `json:"data"`
+		Message string            `json:"message"`
+		Errors  map[string]string `json:"errors"`
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read Proxmox response: %w", err)
+	}
+	_ = json.Unmarshal(content, &answer)
+	if resp.StatusCode != http.StatusOK {
+		// Proxmox puts the reason in the status line and "message", and parameter errors in "errors"
+		message := strings.TrimSpace(answer.Message)
+		if message == "" {
+			message = strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)))
+		}
+		for param, problem := range answer.Errors {
+			message += fmt.Sprintf("; %s: %s", param, strings.TrimSpace(problem))
+		}
+		return &proxmoxError{status: strconv.Itoa(resp.StatusCode), message: message}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(answer.Data, out); err != nil {
+		return fmt.Errorf("failed to decode Proxmox response: %w", err)
+	}
+	return nil
+}
+
+func (c *ProxmoxClient) qemuPath() string {
+	return fmt.Sprintf("/nodes/%s/qemu", c.config.Node)
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
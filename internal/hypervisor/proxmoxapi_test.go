@@ -0,0 +1,127 @@
+package hypervisor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxmoxClient_ProvisionVM(t *testing.T) {
+	var calls []string
+	var settings url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "PVEAPIToken=iago@pve!provision=s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		require.NoError(t, r.ParseForm())
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api2/json/cluster/nextid":
+			fmt.Fprint(w, `{"data":"123"}`)
+		case "GET /api2/json/nodes/pve1/qemu/123/status/current":
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"data":null,"message":"Configuration file 'nodes/pve1/qemu-server/123.conf' does not exist\n"}`)
+		case "POST /api2/json/nodes/pve1/qemu/9000/clone":
+			assert.Equal(t, "123", r.PostForm.Get("newid"))
+			assert.Equal(t, "web", r.PostForm.Get("name"))
+			fmt.Fprint(w, `{"data":"UPID:pve1:clone"}`)
+		case "PUT /api2/json/nodes/pve1/qemu/123/config":
+			settings = r.PostForm
+			fmt.Fprint(w, `{"data":null}`)
+		case "POST /api2/json/nodes/pve1/qemu/123/status/start":
+			fmt.Fprint(w, `{"data":"UPID:pve1:start"}`)
+		case "GET /api2/json/nodes/pve1/tasks/UPID:pve1:clone/status", "GET /api2/json/nodes/pve1/tasks/UPID:pve1:start/status":
+			fmt.Fprint(w, `{"data":{"status":"stopped","exitstatus":"OK"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := machine.ProxmoxConfig{Host: server.URL, Node: "pve1", TokenID: "iago@pve!provision", Template: 9000}
+	client, err := NewProxmoxClient(cfg, "s3cret")
+	require.NoError(t, err)
+	client.http = server.Client()
+
+	vmid, err := client.NextID(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 123, vmid)
+
+	exists, err := client.VMExists(context.Background(), vmid)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	m := machine.Config{Name: "web", MACAddress: "52:54:00:ab:cd:ef"}
+	require.NoError(t, client.ProvisionVM(context.Background(), m, vmid, true, io.Discard))
+
+	assert.Equal(t, "virtio=52:54:00:AB:CD:EF,bridge=vmbr0", settings.Get("net0"))
+	assert.Equal(t, "local-lvm:cloudinit", settings.Get("ide2"))
+	assert.Equal(t, "user=local:snippets/web.ign", settings.Get("cicustom"))
+	assert.Empty(t, settings.Get("args"))
+	assert.Contains(t, calls, "POST /api2/json/nodes/pve1/qemu/123/status/start")
+
+	client.config.IgnitionDelivery = ProxmoxDeliveryFwCfg
+	require.NoError(t, client.ProvisionVM(context.Background(), m, vmid, false, io.Discard))
+	assert.Equal(t, "-fw_cfg name=opt/com.coreos/config,file=/var/lib/vz/snippets/web.ign", settings.Get("args"))
+	assert.Empty(t, settings.Get("ide2"))
+	assert.Empty(t, settings.Get("cicustom"))
+	client.config.IgnitionDelivery = ""
+
+	err = client.ProvisionVM(context.Background(), machine.Config{Name: "web"}, vmid, true, io.Discard)
+	assert.ErrorContains(t, err, "has no mac_address")
+}
+
+func TestProxmoxClient_DestroyVM(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api2/json/nodes/pve1/qemu/123/status/current":
+			fmt.Fprint(w, `{"data":{"status":"running"}}`)
+		case "POST /api2/json/nodes/pve1/qemu/123/status/stop":
+			fmt.Fprint(w, `{"data":"UPID:pve1:stop"}`)
+		case "DELETE /api2/json/nodes/pve1/qemu/123":
+			assert.Equal(t, "1", r.URL.Query().Get("purge"))
+			fmt.Fprint(w, `{"data":"UPID:pve1:destroy"}`)
+		case "GET /api2/json/nodes/pve1/tasks/UPID:pve1:stop/status", "GET /api2/json/nodes/pve1/tasks/UPID:pve1:destroy/status":
+			fmt.Fprint(w, `{"data":{"status":"stopped","exitstatus":"OK"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewProxmoxClient(machine.ProxmoxConfig{Host: server.URL, Node: "pve1", TokenID: "iago@pve!provision"}, "s3cret")
+	require.NoError(t, err)
+	client.http = server.Client()
+
+	require.NoError(t, client.DestroyVM(context.Background(), 123, io.Discard))
+	assert.Equal(t, []string{
+		"GET /api2/json/nodes/pve1/qemu/123/status/current",
+		"POST /api2/json/nodes/pve1/qemu/123/status/stop",
+		"GET /api2/json/nodes/pve1/tasks/UPID:pve1:stop/status",
+		"DELETE /api2/json/nodes/pve1/qemu/123",
+		"GET /api2/json/nodes/pve1/tasks/UPID:pve1:destroy/status",
+	}, calls)
+
+	assert.ErrorContains(t, client.DestroyVM(context.Background(), 456, io.Discard), "failed to look up VM 456")
+}
+
+func TestNewProxmoxClient(t *testing.T) {
+	_, err := NewProxmoxClient(machine.ProxmoxConfig{Host: "pve"}, "s3cret")
+	assert.ErrorContains(t, err, "token_id")
+
+	client, err := NewProxmoxClient(machine.ProxmoxConfig{Host: "pve.lab", TokenID: "iago@pve!provision"}, "s3cret")
+	require.NoError(t, err)
+	assert.Equal(t, "https://pve.lab:8006/api2/json", client.baseURL)
+	assert.Equal(t, "pve", client.config.Node)
+}
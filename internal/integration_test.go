@@ -265,7 +265,8 @@ container_tag = "latest"`, machineName, machineName, machineName)
 			return nil
 		}
 
-		fileName := info.Name()
+		// Archived generations are gzip-compressed copies of the same files
+		fileName := strings.TrimSuffix(info.Name(), ".gz")
 
 		// Check for .yml files (should not exist)
 		if strings.HasSuffix(fileName, ".yml") {
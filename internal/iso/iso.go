@@ -0,0 +1,126 @@
+package iso
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DefaultCacheDir is where downloaded live ISOs are kept between runs
+const DefaultCacheDir = ".iago/cache/iso"
+
+// DefaultImage provides coreos-installer on hosts that don't have it
+const DefaultImage = "quay.io/coreos/coreos-installer:release"
+
+var lookPath = exec.LookPath
+
+// Installer runs coreos-installer, either installed locally or from its
+// container image with podman or docker
+type Installer struct {
+	command []string
+	mounts  bool // Paths must be bind-mounted into the container
+	stderr  io.Writer
+}
+
+// NewInstaller finds coreos-installer on PATH, falling back to running
+// DefaultImage with podman or docker
+func NewInstaller(stderr io.Writer) (*Installer, error) {
+	if path, err := lookPath("coreos-installer"); err == nil {
+		return &Installer{command: []string{path}, stderr: stderr}, nil
+	}
+	for _, tool := range []string{"podman", "docker"} {
+		if path, err := lookPath(tool); err == nil {
+			return &Installer{command: []string{path, "run", "--rm", "--pull=missing"}, mounts: true, stderr: stderr}, nil
+		}
+	}
+	return nil, fmt.Errorf("coreos-installer not found: install it, or podman or docker to run %s", DefaultImage)
+}
+
+// Download fetches the newest live ISO of a Fedora CoreOS stream into
+// cacheDir, verifying its signature, and returns its path. An ISO that is
+// already there isn't downloaded again.
+func (i *Installer) Download(ctx context.Context, stream, arch, cacheDir string) (string, error) {
+	if stream == "" {
+		stream = "stable"
+	}
+	if arch == "" {
+		arch = "x86_64"
+	}
+	if cacheDir == "" {
+		cacheDir = DefaultCacheDir
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create ISO cache: %w", err)
+	}
+
+	var stdout bytes.Buffer
+	if err := i.run(ctx, &stdout, []string{cacheDir}, "download", "--stream", stream, "--architecture", arch, "--format", "iso", "--directory", cacheDir); err != nil {
+		return "", fmt.Errorf("failed to download the Fedora CoreOS %s ISO: %w", stream, err)
+	}
+	// coreos-installer prints the downloaded file's path last
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	path := strings.TrimSpace(lines[len(lines)-1])
+	if path == "" {
+		return "", fmt.Errorf("coreos-installer download didn't report a file")
+	}
+	return path, nil
+}
+
+// Embed writes a copy of baseISO to output with ignitionFile embedded. The
+// live system applies the ignition itself, or with destDevice set installs
+// Fedora CoreOS to that disk with it and reboots.
+func (i *Installer) Embed(ctx context.Context, baseISO, ignitionFile, output, destDevice string) error {
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	var args []string
+	if destDevice == "" {
+		args = []string{"iso", "ignition", "embed", "--force", "--ignition-file", ignitionFile, "--output", output, baseISO}
+	} else {
+		args = []string{"iso", "customize", "--force", "--dest-device", destDevice, "--dest-ignition", ignitionFile, "--output", output, baseISO}
+	}
+	dirs := []string{filepath.Dir(baseISO), filepath.Dir(ignitionFile), filepath.Dir(output)}
+	if err := i.run(ctx, io.Discard, dirs, args...); err != nil {
+		return fmt.Errorf("failed to embed ignition: %w", err)
+	}
+	return nil
+}
+
+// run runs coreos-installer with args; in the container the directories in
+// dirs are mounted at the same absolute paths and relative paths still work
+func (i *Installer) run(ctx context.Context, stdout io.Writer, dirs []string, args ...string) error {
+	command := slices.Clone(i.command)
+	if i.mounts {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		var mounted []string
+		for _, dir := range append([]string{wd}, dirs...) {
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				return err
+			}
+			if !slices.Contains(mounted, abs) {
+				mounted = append(mounted, abs)
+				command = append(command, "-v", abs+":"+abs+":z")
+			}
+		}
+		command = append(command, "-w", wd, DefaultImage)
+	}
+	command = append(command, args...)
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = i.stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("coreos-installer %s failed: %w", args[0], err)
+	}
+	return nil
+}
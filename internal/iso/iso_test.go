@@ -0,0 +1,86 @@
+package iso
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTool installs a stand-in for tool that logs its arguments and prints
+// a downloaded file's path, and makes it the only tool lookPath finds
+func fakeTool(t *testing.T, tool string) string {
+	t.Helper()
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "calls.log")
+	script := "#!/bin/sh\necho \"$@\" >> " + logPath + "\necho 'Downloading...'\necho cache/fedora-coreos-live.x86_64.iso\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, tool), []byte(script), 0755))
+
+	original := lookPath
+	lookPath = func(file string) (string, error) {
+		if file == tool {
+			return filepath.Join(dir, tool), nil
+		}
+		return "", exec.ErrNotFound
+	}
+	t.Cleanup(func() { lookPath = original })
+	return logPath
+}
+
+func TestInstaller_Local(t *testing.T) {
+	logPath := fakeTool(t, "coreos-installer")
+	t.Chdir(t.TempDir())
+
+	installer, err := NewInstaller(io.Discard)
+	require.NoError(t, err)
+
+	base, err := installer.Download(context.Background(), "testing", "", "cache")
+	require.NoError(t, err)
+	assert.Equal(t, "cache/fedora-coreos-live.x86_64.iso", base)
+
+	require.NoError(t, installer.Embed(context.Background(), base, "output/ignition/nas.ign", "output/iso/nas.iso", ""))
+	require.NoError(t, installer.Embed(context.Background(), base, "output/ignition/nas.ign", "output/iso/nas.iso", "/dev/sda"))
+	assert.DirExists(t, "output/iso")
+
+	calls, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"download --stream testing --architecture x86_64 --format iso --directory cache",
+		"iso ignition embed --force --ignition-file output/ignition/nas.ign --output output/iso/nas.iso cache/fedora-coreos-live.x86_64.iso",
+		"iso customize --force --dest-device /dev/sda --dest-ignition output/ignition/nas.ign --output output/iso/nas.iso cache/fedora-coreos-live.x86_64.iso",
+	}, strings.Split(strings.TrimSpace(string(calls)), "\n"))
+}
+
+func TestInstaller_Container(t *testing.T) {
+	logPath := fakeTool(t, "podman")
+	dir := t.TempDir()
+	t.Chdir(dir)
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	installer, err := NewInstaller(io.Discard)
+	require.NoError(t, err)
+	_, err = installer.Download(context.Background(), "", "aarch64", "")
+	require.NoError(t, err)
+
+	calls, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	cache := filepath.Join(wd, DefaultCacheDir)
+	assert.Equal(t, "run --rm --pull=missing -v "+wd+":"+wd+":z -v "+cache+":"+cache+":z -w "+wd+" "+DefaultImage+
+		" download --stream stable --architecture aarch64 --format iso --directory "+DefaultCacheDir+"\n", string(calls))
+}
+
+func TestNewInstaller_Missing(t *testing.T) {
+	original := lookPath
+	lookPath = func(string) (string, error) { return "", exec.ErrNotFound }
+	t.Cleanup(func() { lookPath = original })
+
+	_, err := NewInstaller(io.Discard)
+	assert.ErrorContains(t, err, "coreos-installer not found")
+}
@@ -0,0 +1,123 @@
+package lock
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+)
+
+// DefaultPath is the lock file at the repository root; it is meant to be committed
+const DefaultPath = "iago.lock"
+
+// Lock pins external inputs so generation is reproducible
+type Lock struct {
+	Resources []Resource `toml:"resources,omitempty"`
+	Machines  []Frozen   `toml:"machines,omitempty"`
+}
+
+// Resource pins the checksum of a remote file referenced by a template
+type Resource struct {
+	URL      string `toml:"url"`
+	Checksum string `toml:"checksum"`
+}
+
+// Frozen records the public external inputs of a machine's ignition as of
+// iago freeze; generated secrets are kept in workstation state instead
+type Frozen struct {
+	Name         string     `toml:"name"`
+	SSHKeys      []string   `toml:"ssh_keys,omitempty"`       // Keys fetched from GitHub
+	AdminSSHKeys []string   `toml:"admin_ssh_keys,omitempty"` // Keys of [admin] github_username
+	Fetches      []Resource `toml:"fetches,omitempty"`        // fetchURL content checksums
+	Image        string     `toml:"image,omitempty"`          // container_image:container_tag
+	ImageDigest  string     `toml:"image_digest,omitempty"`   // What Image resolved to
+}
+
+// Load reads the lock file, returning an empty lock if it doesn't exist
+func Load(path string) (*Lock, error) {
+	l := &Lock{}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return l, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if err := toml.Unmarshal(content, l); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return l, nil
+}
+
+// Save writes the lock file with entries in a stable order
+func (l *Lock) Save(path string) error {
+	sort.Slice(l.Resources, func(i, j int) bool {
+		return l.Resources[i].URL < l.Resources[j].URL
+	})
+	sort.Slice(l.Machines, func(i, j int) bool {
+		return l.Machines[i].Name < l.Machines[j].Name
+	})
+	for _, m := range l.Machines {
+		sort.Slice(m.Fetches, func(i, j int) bool {
+			return m.Fetches[i].URL < m.Fetches[j].URL
+		})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by iago. Pins external inputs; commit this file.\n\n")
+	if err := toml.NewEncoder(&buf).Encode(l); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// ResourceChecksum returns the pinned checksum for url, if any
+func (l *Lock) ResourceChecksum(url string) (string, bool) {
+	for _, r := range l.Resources {
+		if r.URL == url {
+			return r.Checksum, true
+		}
+	}
+	return "", false
+}
+
+// SetResourceChecksum pins url to checksum, replacing any existing pin
+func (l *Lock) SetResourceChecksum(url, checksum string) {
+	for i, r := range l.Resources {
+		if r.URL == url {
+			l.Resources[i].Checksum = checksum
+			return
+		}
+	}
+	l.Resources = append(l.Resources, Resource{URL: url, Checksum: checksum})
+}
+
+// Frozen returns the inputs iago freeze recorded for machine, if any
+func (l *Lock) Frozen(machine string) (Frozen, bool) {
+	for _, m := range l.Machines {
+		if m.Name == machine {
+			return m, true
+		}
+	}
+	return Frozen{}, false
+}
+
+// SetFrozen records a machine's frozen inputs, replacing any earlier freeze
+func (l *Lock) SetFrozen(frozen Frozen) {
+	for i, m := range l.Machines {
+		if m.Name == frozen.Name {
+			l.Machines[i] = frozen
+			return
+		}
+	}
+	l.Machines = append(l.Machines, frozen)
+}
@@ -0,0 +1,81 @@
+package lock
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad_MissingFile(t *testing.T) {
+	l, err := Load(filepath.Join(t.TempDir(), "iago.lock"))
+
+	require.NoError(t, err)
+	assert.Empty(t, l.Resources)
+}
+
+func TestResourceChecksums(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iago.lock")
+
+	l := &Lock{}
+	l.SetResourceChecksum("https://b.example.com/file", "sha256:bbb")
+	l.SetResourceChecksum("https://a.example.com/file", "sha256:aaa")
+	l.SetResourceChecksum("https://b.example.com/file", "sha256:ccc")
+	require.NoError(t, l.Save(path))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "# Generated by iago")
+
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, loaded.Resources, 2)
+	assert.Equal(t, "https://a.example.com/file", loaded.Resources[0].URL, "resources should be sorted by URL")
+
+	checksum, ok := loaded.ResourceChecksum("https://b.example.com/file")
+	assert.True(t, ok)
+	assert.Equal(t, "sha256:ccc", checksum)
+
+	_, ok = loaded.ResourceChecksum("https://missing.example.com/")
+	assert.False(t, ok)
+}
+
+func TestFrozen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iago.lock")
+
+	l := &Lock{}
+	l.SetFrozen(Frozen{Name: "web", SSHKeys: []string{"ssh-ed25519 AAAA old"}})
+	l.SetFrozen(Frozen{Name: "db", ImageDigest: "sha256:db"})
+	l.SetFrozen(Frozen{
+		Name:    "web",
+		SSHKeys: []string{"ssh-ed25519 AAAA new"},
+		Fetches: []Resource{
+			{URL: "https://b.example.com/motd", Checksum: "sha256:bbb"},
+			{URL: "https://a.example.com/motd", Checksum: "sha256:aaa"},
+		},
+	})
+	require.NoError(t, l.Save(path))
+
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, loaded.Machines, 2)
+	assert.Equal(t, "db", loaded.Machines[0].Name, "machines should be sorted by name")
+
+	web, ok := loaded.Frozen("web")
+	require.True(t, ok)
+	assert.Equal(t, []string{"ssh-ed25519 AAAA new"}, web.SSHKeys, "freezing again replaces the earlier freeze")
+	assert.Equal(t, "https://a.example.com/motd", web.Fetches[0].URL, "fetches should be sorted by URL")
+
+	_, ok = loaded.Frozen("missing")
+	assert.False(t, ok)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iago.lock")
+	require.NoError(t, os.WriteFile(path, []byte("resources = ["), 0644))
+
+	_, err := Load(path)
+	assert.ErrorContains(t, err, "failed to parse")
+}
@@ -0,0 +1,99 @@
+package machine
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Sudo policies for an account's sudo option
+const (
+	SudoNoPassword = "nopasswd" // Sudo without a password
+	SudoPassword   = "password" // Sudo after entering the account's password
+	SudoNone       = "none"     // No sudo at all
+)
+
+// Account is the hardening options shared by [user] and [admin]
+type Account struct {
+	Section  string // "user" or "admin", for error messages
+	Username string
+	Groups   []string
+	SSHOnly  bool
+	Sudo     string
+	Expires  string
+	Shell    string
+}
+
+// Account returns the account options of the primary user
+func (u UserConfig) Account() Account {
+	return Account{Section: "user", Username: u.Username, Groups: u.Groups, SSHOnly: u.SSHOnly, Sudo: u.Sudo, Expires: u.Expires, Shell: u.Shell}
+}
+
+// Account returns the account options of the admin user
+func (a AdminConfig) Account() Account {
+	return Account{Section: "admin", Username: a.Username, Groups: a.Groups, SSHOnly: a.SSHOnly, Sudo: a.Sudo, Expires: a.Expires, Shell: a.Shell}
+}
+
+// Customized reports whether any hardening option is set
+func (a Account) Customized() bool {
+	return a.SSHOnly || a.Sudo != "" || a.Expires != "" || a.Shell != ""
+}
+
+// SudoRule is the sudoers line for the account's sudo option, or "" when
+// sudo is left to group membership or disabled
+func (a Account) SudoRule() string {
+	switch a.Sudo {
+	case SudoNoPassword:
+		return a.Username + " ALL=(ALL) NOPASSWD: ALL"
+	case SudoPassword:
+		return a.Username + " ALL=(ALL) ALL"
+	}
+	return ""
+}
+
+// ValidateAccounts checks the account options in defaults. On Fedora CoreOS
+// the sudo group grants passwordless sudo and wheel grants sudo with a
+// password, so group membership has to agree with the sudo option.
+func ValidateAccounts(defaults Defaults) []error {
+	var errs []error
+	for _, account := range []Account{defaults.User.Account(), defaults.Admin.Account()} {
+		errs = append(errs, account.validate()...)
+	}
+	if defaults.User.SSHOnly && defaults.User.GitHubUsername == "" {
+		errs = append(errs, fmt.Errorf("user.ssh_only locks the password, but without user.github_username there are no SSH keys to log in with"))
+	}
+	if defaults.Admin.SSHOnly && defaults.Admin.GitHubUsername == "" && len(defaults.Admin.SSHKeys) == 0 {
+		errs = append(errs, fmt.Errorf("admin.ssh_only locks the password, but without admin.github_username or admin.ssh_keys there are no SSH keys to log in with"))
+	}
+	return errs
+}
+
+func (a Account) validate() []error {
+	var errs []error
+	switch a.Sudo {
+	case "", SudoNoPassword:
+	case SudoPassword:
+		if slices.Contains(a.Groups, "sudo") {
+			errs = append(errs, fmt.Errorf("%s.sudo = %q has no effect while %s is in the sudo group, which grants passwordless sudo", a.Section, a.Sudo, a.Username))
+		}
+	case SudoNone:
+		for _, group := range []string{"sudo", "wheel"} {
+			if slices.Contains(a.Groups, group) {
+				errs = append(errs, fmt.Errorf("%s.sudo = %q has no effect while %s is in the %s group", a.Section, a.Sudo, a.Username, group))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%s.sudo must be %s, %s or %s, not %q", a.Section, SudoNoPassword, SudoPassword, SudoNone, a.Sudo))
+	}
+
+	if a.Expires != "" {
+		if _, err := time.Parse("2006-01-02", a.Expires); err != nil {
+			errs = append(errs, fmt.Errorf("%s.expires must be a date like 2027-01-31, not %q", a.Section, a.Expires))
+		}
+	}
+	if a.Shell != "" && !filepath.IsAbs(a.Shell) {
+		errs = append(errs, fmt.Errorf("%s.shell must be an absolute path, not %q", a.Section, a.Shell))
+	}
+	return errs
+}
@@ -0,0 +1,45 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountSudoRule(t *testing.T) {
+	assert.Equal(t, "core ALL=(ALL) NOPASSWD: ALL", Account{Username: "core", Sudo: SudoNoPassword}.SudoRule())
+	assert.Equal(t, "core ALL=(ALL) ALL", Account{Username: "core", Sudo: SudoPassword}.SudoRule())
+	assert.Empty(t, Account{Username: "core", Sudo: SudoNone}.SudoRule())
+	assert.Empty(t, Account{Username: "core"}.SudoRule())
+}
+
+func TestValidateAccounts(t *testing.T) {
+	valid := Defaults{
+		User:  UserConfig{Username: "core", GitHubUsername: "octocat", Groups: []string{"wheel"}, SSHOnly: true, Sudo: SudoPassword, Shell: "/bin/bash"},
+		Admin: AdminConfig{Username: "admin", Groups: []string{"sudo"}, Sudo: SudoNoPassword, Expires: "2027-01-31"},
+	}
+	assert.Empty(t, ValidateAccounts(valid))
+	assert.Empty(t, ValidateAccounts(Defaults{}))
+
+	invalid := Defaults{
+		User:  UserConfig{Username: "core", Groups: []string{"sudo", "wheel"}, SSHOnly: true, Sudo: SudoPassword, Shell: "bash"},
+		Admin: AdminConfig{Username: "admin", Groups: []string{"wheel"}, Sudo: SudoNone, Expires: "next year"},
+	}
+	errs := ValidateAccounts(invalid)
+	require.Len(t, errs, 5)
+	assert.Contains(t, errs[0].Error(), `user.sudo = "password" has no effect while core is in the sudo group`)
+	assert.Contains(t, errs[1].Error(), "user.shell must be an absolute path")
+	assert.Contains(t, errs[2].Error(), `admin.sudo = "none" has no effect while admin is in the wheel group`)
+	assert.Contains(t, errs[3].Error(), "admin.expires must be a date")
+	assert.Contains(t, errs[4].Error(), "no SSH keys to log in with")
+
+	errs = ValidateAccounts(Defaults{Admin: AdminConfig{Sudo: "always"}})
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), `admin.sudo must be nopasswd, password or none, not "always"`)
+
+	errs = ValidateAccounts(Defaults{Admin: AdminConfig{Username: "admin", SSHOnly: true}})
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "without admin.github_username or admin.ssh_keys")
+	assert.Empty(t, ValidateAccounts(Defaults{Admin: AdminConfig{Username: "admin", SSHOnly: true, SSHKeys: []string{"ssh-ed25519 AAAA"}}}))
+}
@@ -0,0 +1,97 @@
+package machine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Base image policy modes
+const (
+	BaseImageModeEnforce = "enforce" // Refuse to build workloads using other base images
+	BaseImageModeWarn    = "warn"    // Build them, but say so
+)
+
+// BaseImagePolicy is the allowlist of base images workloads may build FROM
+type BaseImagePolicy struct {
+	Allow []string `toml:"allow,omitempty"`                               // Registries, namespaces or repositories, e.g. "quay.io/fedora" or "ghcr.io/me/*"
+	Mode  string   `toml:"mode,omitempty" jsonschema:"enum=enforce|warn"` // default enforce
+}
+
+// IsZero reports whether no allowlist is configured, allowing any base image
+func (p BaseImagePolicy) IsZero() bool {
+	return len(p.Allow) == 0
+}
+
+// Warn reports whether images outside the allowlist are only warned about
+func (p BaseImagePolicy) Warn() bool {
+	return p.Mode == BaseImageModeWarn
+}
+
+// Allows reports whether image may be used as a base image. An entry allows
+// the repository it names and everything below it, so "quay.io" allows any
+// image on quay.io and "quay.io/fedora" any image in that namespace. Docker
+// Hub short names are expanded first, so "nginx" is docker.io/library/nginx,
+// while "docker.io/bitnami" also allows the bitnami namespace.
+func (p BaseImagePolicy) Allows(image string) bool {
+	if p.IsZero() {
+		return true
+	}
+	repository := NormalizeImage(image)
+	for _, entry := range p.Allow {
+		entry = strings.TrimSuffix(strings.TrimSuffix(entry, "*"), "/")
+		candidates := []string{normalizeRepository(entry)}
+		// "docker.io/bitnami" names a namespace as well as the library image
+		if namespace, ok := strings.CutPrefix(entry, "docker.io/"); ok && !strings.Contains(namespace, "/") {
+			candidates = append(candidates, entry)
+		}
+		for _, allowed := range candidates {
+			if repository == allowed || strings.HasPrefix(repository, allowed+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NormalizeImage returns an image reference's fully qualified repository,
+// without its tag or digest, expanding Docker Hub short names
+func NormalizeImage(image string) string {
+	repository, _, _ := strings.Cut(image, "@")
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+	return normalizeRepository(repository)
+}
+
+// normalizeRepository qualifies a repository, or a bare registry host, with
+// Docker Hub's registry and library namespace where they are implied
+func normalizeRepository(repository string) string {
+	registry, rest, ok := strings.Cut(repository, "/")
+	isHost := strings.ContainsAny(registry, ".:") || registry == "localhost"
+	switch {
+	case !ok && isHost:
+		return repository
+	case !isHost:
+		registry, rest = "docker.io", repository
+	}
+	if registry == "docker.io" && rest != "" && !strings.Contains(rest, "/") {
+		rest = "library/" + rest
+	}
+	if rest == "" {
+		return registry
+	}
+	return registry + "/" + rest
+}
+
+// ValidateBaseImagePolicy checks [container_registry.build.base_images]
+func ValidateBaseImagePolicy(p BaseImagePolicy) error {
+	if p.Mode != "" && p.Mode != BaseImageModeEnforce && p.Mode != BaseImageModeWarn {
+		return fmt.Errorf("invalid [container_registry.build.base_images] mode %q (expected enforce or warn)", p.Mode)
+	}
+	for _, entry := range p.Allow {
+		if strings.TrimSuffix(strings.TrimSuffix(entry, "*"), "/") == "" {
+			return fmt.Errorf("invalid [container_registry.build.base_images] allow entry %q", entry)
+		}
+	}
+	return nil
+}
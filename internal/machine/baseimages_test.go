@@ -0,0 +1,53 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeImage(t *testing.T) {
+	for image, want := range map[string]string{
+		"nginx":                                 "docker.io/library/nginx",
+		"nginx:1.27":                            "docker.io/library/nginx",
+		"bitnami/redis":                         "docker.io/bitnami/redis",
+		"docker.io/nginx@sha256:abc":            "docker.io/library/nginx",
+		"quay.io/fedora/fedora-bootc:42":        "quay.io/fedora/fedora-bootc",
+		"registry.lab:5000/base:latest":         "registry.lab:5000/base",
+		"localhost/base":                        "localhost/base",
+		"ghcr.io/me/app:v1@sha256:0123456789ab": "ghcr.io/me/app",
+	} {
+		assert.Equal(t, want, NormalizeImage(image), image)
+	}
+}
+
+func TestBaseImagePolicy_Allows(t *testing.T) {
+	assert.True(t, BaseImagePolicy{}.Allows("nginx"), "no allowlist allows everything")
+
+	policy := BaseImagePolicy{Allow: []string{"quay.io/fedora", "ghcr.io/me/*", "registry.lab:5000", "nginx"}}
+	for image, want := range map[string]bool{
+		"quay.io/fedora/fedora-bootc:42":       true,
+		"quay.io/fedora-evil/fedora-bootc":     false,
+		"quay.io/centos-bootc/centos-bootc":    false,
+		"ghcr.io/me/base:1":                    true,
+		"ghcr.io/someone/base":                 false,
+		"registry.lab:5000/team/base:latest":   true,
+		"nginx:1.27":                           true,
+		"docker.io/library/nginx":              true,
+		"docker.io/library/nginx-unprivileged": false,
+		"busybox":                              false,
+	} {
+		assert.Equal(t, want, policy.Allows(image), image)
+	}
+
+	assert.True(t, BaseImagePolicy{Allow: []string{"docker.io"}}.Allows("busybox"))
+	assert.True(t, BaseImagePolicy{Allow: []string{"docker.io/bitnami"}}.Allows("bitnami/redis:7"))
+	assert.False(t, BaseImagePolicy{Allow: []string{"bitnami"}}.Allows("bitnami/redis:7"), "a short name is a library image, not a namespace")
+}
+
+func TestValidateBaseImagePolicy(t *testing.T) {
+	assert.NoError(t, ValidateBaseImagePolicy(BaseImagePolicy{}))
+	assert.NoError(t, ValidateBaseImagePolicy(BaseImagePolicy{Allow: []string{"quay.io"}, Mode: BaseImageModeWarn}))
+	assert.ErrorContains(t, ValidateBaseImagePolicy(BaseImagePolicy{Mode: "audit"}), `mode "audit"`)
+	assert.ErrorContains(t, ValidateBaseImagePolicy(BaseImagePolicy{Allow: []string{"*"}}), `allow entry "*"`)
+}
@@ -0,0 +1,274 @@
+package machine
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Calendar is a parsed systemd OnCalendar expression (systemd.time(7))
+type Calendar struct {
+	Weekdays []time.Weekday // Days the expression fires on; empty means every day
+	Date     string         // Date part, normalized, e.g. *-*-*
+	Time     string         // Time part, normalized, e.g. 02:00:00
+	Location string         // Trailing timezone, if any
+}
+
+// calendarShorthands are the named expressions systemd accepts, expanded
+var calendarShorthands = map[string]string{
+	"minutely":     "*-*-* *:*:00",
+	"hourly":       "*-*-* *:00:00",
+	"daily":        "*-*-* 00:00:00",
+	"monthly":      "*-*-01 00:00:00",
+	"weekly":       "Mon *-*-* 00:00:00",
+	"yearly":       "*-01-01 00:00:00",
+	"annually":     "*-01-01 00:00:00",
+	"quarterly":    "*-01,04,07,10-01 00:00:00",
+	"semiannually": "*-01,07-01 00:00:00",
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+	"sun": time.Sunday, "sunday": time.Sunday,
+}
+
+// timezoneName matches the trailing timezone systemd accepts: UTC or a tz database name
+var timezoneName = regexp.MustCompile(`^(UTC|[A-Z][A-Za-z_+-]*(/[A-Za-z0-9_+-]+)+)$`)
+
+// ParseCalendar parses and validates a systemd OnCalendar expression, such as
+// "Sat,Sun *-*-* 02:00" or "daily"
+func ParseCalendar(expr string) (Calendar, error) {
+	fields := strings.Fields(expr)
+	if len(fields) == 0 {
+		return Calendar{}, fmt.Errorf("empty calendar expression")
+	}
+	invalid := func(format string, args ...any) (Calendar, error) {
+		return Calendar{}, fmt.Errorf("invalid calendar expression %q: %s", expr, fmt.Sprintf(format, args...))
+	}
+
+	var cal Calendar
+	if n := len(fields); n > 1 && timezoneName.MatchString(fields[n-1]) {
+		cal.Location = fields[n-1]
+		fields = fields[:n-1]
+	}
+	if len(fields) == 1 {
+		if expanded, ok := calendarShorthands[strings.ToLower(fields[0])]; ok {
+			fields = strings.Fields(expanded)
+		}
+	}
+
+	if first := fields[0]; unicode.IsLetter(rune(first[0])) && !strings.Contains(first, ":") {
+		days, err := parseWeekdays(first)
+		if err != nil {
+			return invalid("%v", err)
+		}
+		cal.Weekdays = days
+		fields = fields[1:]
+	}
+	if len(fields) > 0 && strings.Contains(fields[0], "-") && !strings.Contains(fields[0], ":") {
+		date, err := parseCalendarDate(fields[0])
+		if err != nil {
+			return invalid("%v", err)
+		}
+		cal.Date = date
+		fields = fields[1:]
+	}
+	if len(fields) > 0 && strings.Contains(fields[0], ":") {
+		t, err := parseCalendarTime(fields[0])
+		if err != nil {
+			return invalid("%v", err)
+		}
+		cal.Time = t
+		fields = fields[1:]
+	}
+	if len(fields) > 0 {
+		return invalid("unexpected %q", fields[0])
+	}
+	if cal.Weekdays == nil && cal.Date == "" && cal.Time == "" {
+		return invalid("expected a weekday, date or time")
+	}
+	if cal.Date == "" {
+		cal.Date = "*-*-*"
+	}
+	if cal.Time == "" {
+		cal.Time = "00:00:00"
+	}
+	return cal, nil
+}
+
+// String formats the expression the way systemd-analyze calendar normalizes it
+func (c Calendar) String() string {
+	var parts []string
+	if len(c.Weekdays) > 0 {
+		var names []string
+		for _, d := range c.Weekdays {
+			names = append(names, d.String()[:3])
+		}
+		parts = append(parts, strings.Join(names, ","))
+	}
+	parts = append(parts, c.Date, c.Time)
+	if c.Location != "" {
+		parts = append(parts, c.Location)
+	}
+	return strings.Join(parts, " ")
+}
+
+// StartTime returns the single HH:MM the expression fires at each day, or
+// false when it fires at several times or on particular dates
+func (c Calendar) StartTime() (string, bool) {
+	if c.Date != "*-*-*" {
+		return "", false
+	}
+	parts := strings.Split(c.Time, ":")
+	for _, p := range parts {
+		if _, err := strconv.Atoi(p); err != nil {
+			return "", false
+		}
+	}
+	if len(parts) == 3 && parts[2] != "00" {
+		return "", false
+	}
+	return parts[0] + ":" + parts[1], true
+}
+
+// parseWeekdays parses "Mon", "Sat,Sun" or "Mon..Fri" into days in week order
+func parseWeekdays(spec string) ([]time.Weekday, error) {
+	selected := make(map[time.Weekday]bool)
+	for _, item := range strings.Split(spec, ",") {
+		from, to, isRange := strings.Cut(item, "..")
+		if !isRange {
+			from, to, isRange = strings.Cut(item, "-")
+		}
+		start, ok := weekdayNames[strings.ToLower(from)]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q", from)
+		}
+		end := start
+		if isRange {
+			if end, ok = weekdayNames[strings.ToLower(to)]; !ok {
+				return nil, fmt.Errorf("unknown weekday %q", to)
+			}
+		}
+		// Ranges run Monday to Sunday, as in systemd
+		if mondayIndex(end) < mondayIndex(start) {
+			return nil, fmt.Errorf("weekday range %q runs backwards", item)
+		}
+		for d := mondayIndex(start); d <= mondayIndex(end); d++ {
+			selected[time.Weekday((d+1)%7)] = true
+		}
+	}
+
+	var days []time.Weekday
+	for i := 0; i < 7; i++ {
+		if d := time.Weekday((i + 1) % 7); selected[d] {
+			days = append(days, d)
+		}
+	}
+	return days, nil
+}
+
+func mondayIndex(d time.Weekday) int {
+	return (int(d) + 6) % 7
+}
+
+// parseCalendarDate validates [YEAR-]MONTH-DAY, where DAY may be ~N for the
+// Nth last day of the month
+func parseCalendarDate(spec string) (string, error) {
+	separator := "-"
+	if strings.Contains(spec, "~") {
+		separator = "~"
+	}
+	idx := strings.LastIndex(spec, separator)
+	if idx <= 0 {
+		return "", fmt.Errorf("invalid date %q", spec)
+	}
+	head, day := spec[:idx], spec[idx+1:]
+
+	year, month := "*", head
+	if y, m, hasYear := strings.Cut(head, "-"); hasYear {
+		year, month = y, m
+	}
+	if strings.Contains(month, "-") {
+		return "", fmt.Errorf("invalid date %q", spec)
+	}
+	for _, c := range []struct {
+		name     string
+		value    string
+		min, max int
+	}{
+		{"year", year, 1970, 2199},
+		{"month", month, 1, 12},
+		{"day", day, 1, 31},
+	} {
+		if err := checkCalendarComponent(c.value, c.min, c.max); err != nil {
+			return "", fmt.Errorf("invalid %s in %q: %v", c.name, spec, err)
+		}
+	}
+	return year + "-" + month + separator + day, nil
+}
+
+// parseCalendarTime validates HOUR:MINUTE[:SECOND], adding :00 seconds
+func parseCalendarTime(spec string) (string, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return "", fmt.Errorf("invalid time %q (expected HH:MM or HH:MM:SS)", spec)
+	}
+	if len(parts) == 2 {
+		parts = append(parts, "00")
+	}
+	limits := []struct {
+		name string
+		max  int
+	}{{"hour", 23}, {"minute", 59}, {"second", 59}}
+	for i, part := range parts {
+		value := part
+		if i == 2 {
+			value, _, _ = strings.Cut(part, ".")
+		}
+		if err := checkCalendarComponent(value, 0, limits[i].max); err != nil {
+			return "", fmt.Errorf("invalid %s in %q: %v", limits[i].name, spec, err)
+		}
+	}
+	return strings.Join(parts, ":"), nil
+}
+
+// checkCalendarComponent validates a comma list of *, N, N..M and either
+// followed by /REPEAT
+func checkCalendarComponent(spec string, min, max int) error {
+	if spec == "" {
+		return fmt.Errorf("empty value")
+	}
+	for _, item := range strings.Split(spec, ",") {
+		value, repeat, hasRepeat := strings.Cut(item, "/")
+		if hasRepeat {
+			if n, err := strconv.Atoi(repeat); err != nil || n <= 0 {
+				return fmt.Errorf("invalid repetition %q", repeat)
+			}
+		}
+		if value == "*" {
+			continue
+		}
+		bounds := []string{value}
+		if from, to, isRange := strings.Cut(value, ".."); isRange {
+			bounds = []string{from, to}
+		}
+		for _, bound := range bounds {
+			n, err := strconv.Atoi(bound)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", bound)
+			}
+			if n < min || n > max {
+				return fmt.Errorf("%d is outside %d-%d", n, min, max)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package machine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCalendar(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"*-*-* 02:00:00", "*-*-* 02:00:00"},
+		{"02:30", "*-*-* 02:30:00"},
+		{"Sat,Sun 03:00", "Sat,Sun *-*-* 03:00:00"},
+		{"Mon..Fri *-*-* 22:15", "Mon,Tue,Wed,Thu,Fri *-*-* 22:15:00"},
+		{"sun", "Sun *-*-* 00:00:00"},
+		{"daily", "*-*-* 00:00:00"},
+		{"weekly", "Mon *-*-* 00:00:00"},
+		{"*-*-01 04:00", "*-*-01 04:00:00"},
+		{"2026-01,07-15 04:00", "2026-01,07-15 04:00:00"},
+		{"*-02~03", "*-02~03 00:00:00"},
+		{"*-*-* 00/2:00", "*-*-* 00/2:00:00"},
+		{"*-*-* 01..04:30", "*-*-* 01..04:30:00"},
+		{"Sat 02:00 Europe/Berlin", "Sat *-*-* 02:00:00 Europe/Berlin"},
+		{"*-*-* 02:00 UTC", "*-*-* 02:00:00 UTC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			cal, err := ParseCalendar(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, cal.String())
+		})
+	}
+
+	for _, expr := range []string{
+		"",
+		"02:00:00:00",
+		"25:00",
+		"*-*-* 02:61",
+		"*-13-01",
+		"Funday 02:00",
+		"Fri..Mon 02:00",
+		"*-*-* 02:00 tomorrow",
+		"*-*-* */0:00",
+		"at 2am",
+	} {
+		_, err := ParseCalendar(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestCalendarStartTime(t *testing.T) {
+	cal, err := ParseCalendar("Sat,Sun 02:30")
+	require.NoError(t, err)
+	start, ok := cal.StartTime()
+	assert.True(t, ok)
+	assert.Equal(t, "02:30", start)
+	assert.Equal(t, []time.Weekday{time.Saturday, time.Sunday}, cal.Weekdays)
+
+	for _, expr := range []string{"*-*-01 02:00", "*-*-* 00/2:00", "*-*-* 02:00:30"} {
+		cal, err := ParseCalendar(expr)
+		require.NoError(t, err)
+		_, ok := cal.StartTime()
+		assert.False(t, ok, expr)
+	}
+}
+
+func TestResolveMaintenance(t *testing.T) {
+	defaults := Defaults{Maintenance: MaintenanceConfig{Window: "Sun 02:00", RandomizedDelay: "30m"}}
+	m := Config{Maintenance: MaintenanceConfig{Window: "Sat 04:00", FixedDelay: true}}
+
+	resolved := ResolveMaintenance(m, defaults)
+	assert.Equal(t, MaintenanceConfig{Window: "Sat 04:00", RandomizedDelay: "30m", FixedDelay: true}, resolved)
+	assert.Equal(t, defaults.Maintenance, ResolveMaintenance(Config{}, defaults))
+
+	d, err := MaintenanceConfig{RandomizedDelay: "900"}.Delay()
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, d)
+
+	assert.NoError(t, ValidateMaintenance(resolved))
+	assert.ErrorContains(t, ValidateMaintenance(MaintenanceConfig{Window: "2am"}), "invalid [maintenance] window")
+	assert.ErrorContains(t, ValidateMaintenance(MaintenanceConfig{RandomizedDelay: "soon"}), "randomized_delay")
+	assert.ErrorContains(t, ValidateMaintenance(MaintenanceConfig{RandomizedDelay: "25h"}), "longer than a day")
+}
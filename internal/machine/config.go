@@ -1,12 +1,163 @@
 package machine
 
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+)
+
 type Config struct {
-	Name             string `toml:"name"`
-	MACAddress       string `toml:"mac_address,omitempty"`
-	NetworkInterface string `toml:"network_interface,omitempty"`
-	FQDN             string `toml:"fqdn"`
-	ContainerImage   string `toml:"container_image,omitempty"`
-	ContainerTag     string `toml:"container_tag,omitempty"`
+	Name             string            `toml:"name" jsonschema:"required"`
+	MACAddress       string            `toml:"mac_address,omitempty"`
+	NetworkInterface string            `toml:"network_interface,omitempty"`
+	FQDN             string            `toml:"fqdn,omitempty"` // Defaults to <name>.<domain> from the site or defaults
+	IPAddress        string            `toml:"ip_address,omitempty"`
+	PrefixLength     int               `toml:"prefix_length,omitempty"` // Makes ip_address static instead of DHCP; see StaticNetwork
+	Gateway          string            `toml:"gateway,omitempty"`
+	DNSServers       []string          `toml:"dns_servers,omitempty"` // Static machines only; defaults to [network] dns_servers
+	Tags             []string          `toml:"tags,omitempty"`
+	Group            string            `toml:"group,omitempty"` // e.g. vps or homelab, for --group filtering
+	Site             string            `toml:"site,omitempty"`  // Site overlay from config/sites/<site>.toml (domain, registry, DNS, hypervisor)
+	ContainerImage   string            `toml:"container_image,omitempty"`
+	ContainerTag     string            `toml:"container_tag,omitempty"`
+	VMID             int               `toml:"vm_id,omitempty"`         // Proxmox VM ID (libvirt uses the machine name)
+	Snippets         []string          `toml:"snippets,omitempty"`      // Catalog snippets merged into the rendered butane
+	Owners           []string          `toml:"owners,omitempty"`        // Teams or people responsible for the machine
+	Protected        bool              `toml:"protected,omitempty"`     // Require explicit confirmation for rm and up
+	Adopted          bool              `toml:"adopted,omitempty"`       // Provisioned outside iago and adopted; see machines/<name>/ADOPTED.md
+	Hardening        HardeningConfig   `toml:"hardening,omitempty"`     // Presets replace the defaults' presets
+	Maintenance      MaintenanceConfig `toml:"maintenance,omitempty"`   // Settings replace the defaults' one by one
+	Rootless         bool              `toml:"rootless,omitempty"`      // Run the container as a user-level quadlet instead of a root service
+	RootlessUser     string            `toml:"rootless_user,omitempty"` // User that owns the rootless container (default: machine name)
+	RootlessSubIDs   SubIDConfig       `toml:"rootless_subids,omitempty"`
+	RootlessStorage  StorageConfig     `toml:"rootless_storage,omitempty"`
+	UpdateMechanism  string            `toml:"update_mechanism,omitempty" jsonschema:"enum=bootc-update|podman-auto-update"`     // How the container is kept up to date (default: bootc-update)
+	Units            []UnitConfig      `toml:"units,omitempty"`                                                                  // Systemd units appended to the rendered butane
+	IgnitionVersion  string            `toml:"ignition_version,omitempty" jsonschema:"enum=3.0.0|3.1.0|3.2.0|3.3.0|3.4.0|3.5.0"` // Ignition spec for an OS older than the template targets, e.g. 3.3.0
+	DriftExempt      []string          `toml:"drift_exempt,omitempty"`                                                           // Paths or units expected to change at runtime, which deploy leaves alone
+	Vars             map[string]any    `toml:"vars,omitempty"`                                                                   // Free-form values for the machine's templates, as .Vars
+
+	// Dir holds machine.toml and the templates; machines/<name> when empty
+	Dir string `toml:"-"`
+	// Include is the [[include]] the machine comes from; "" for the repository's own
+	Include string `toml:"-"`
+}
+
+// Directory returns the directory holding the machine's machine.toml and templates
+func (m Config) Directory() string {
+	if m.Dir != "" {
+		return m.Dir
+	}
+	return filepath.Join(MachinesDir, m.Name)
+}
+
+// UnitConfig is a systemd unit declared in machine.toml. Without contents it
+// enables or adds drop-ins to a unit the OS or the template already provides.
+type UnitConfig struct {
+	Name         string         `toml:"name" jsonschema:"required"`
+	Enabled      *bool          `toml:"enabled,omitempty"`       // Unset leaves it to the template; false disables the unit
+	Contents     string         `toml:"contents,omitempty"`      // Inline unit file
+	ContentsFile string         `toml:"contents_file,omitempty"` // Unit file relative to machines/<name>/
+	Dropins      []DropinConfig `toml:"dropins,omitempty"`
+}
+
+// DropinConfig is a drop-in for a unit declared in machine.toml
+type DropinConfig struct {
+	Name         string `toml:"name" jsonschema:"required"` // e.g. 10-limits.conf
+	Contents     string `toml:"contents,omitempty"`
+	ContentsFile string `toml:"contents_file,omitempty"` // Relative to machines/<name>/
+}
+
+const (
+	// UpdateMechanismBootc updates containers with iago's bootc-update.sh timer
+	UpdateMechanismBootc = "bootc-update"
+	// UpdateMechanismPodman labels containers for podman-auto-update.timer
+	UpdateMechanismPodman = "podman-auto-update"
+)
+
+// DefaultSubIDStart sits above the ranges useradd hands out (SUB_UID_MIN 524288
+// to SUB_UID_MAX 600100000 in login.defs), so the explicit range doesn't overlap
+// one allocated to another user
+const DefaultSubIDStart = 1000000000
+
+// DefaultSubIDCount is the size of the subordinate ID range most images need
+const DefaultSubIDCount = 65536
+
+// SubIDConfig is the subordinate UID/GID range for a rootless user
+type SubIDConfig struct {
+	Start int `toml:"start,omitempty"` // default 1000000000
+	Count int `toml:"count,omitempty"` // default 65536
+}
+
+// StorageConfig tunes containers/storage for a rootless user
+type StorageConfig struct {
+	Driver    string `toml:"driver,omitempty"`    // default "overlay"
+	GraphRoot string `toml:"graphroot,omitempty"` // default ~/.local/share/containers/storage
+}
+
+// StaticNetwork reports whether the machine's address is configured statically
+// rather than by DHCP
+func (c Config) StaticNetwork() bool {
+	return c.PrefixLength > 0 || c.Gateway != ""
+}
+
+// HasTag reports whether the machine carries tag
+func (c Config) HasTag(tag string) bool {
+	return slices.Contains(c.Tags, tag)
+}
+
+// ImageReference returns container_image:container_tag, tagged latest when no
+// tag is set, or "" when the machine has no image
+func (c Config) ImageReference() string {
+	if c.ContainerImage == "" {
+		return ""
+	}
+	tag := c.ContainerTag
+	if tag == "" {
+		tag = "latest"
+	}
+	return c.ContainerImage + ":" + tag
+}
+
+// RootlessUserName returns the user a rootless container runs as
+func (c Config) RootlessUserName() string {
+	if c.RootlessUser != "" {
+		return c.RootlessUser
+	}
+	return c.Name
+}
+
+// RootlessSubIDRange returns the /etc/subuid and /etc/subgid entry for the rootless user
+func (c Config) RootlessSubIDRange() string {
+	start, count := c.RootlessSubIDs.Start, c.RootlessSubIDs.Count
+	if start == 0 {
+		start = DefaultSubIDStart
+	}
+	if count == 0 {
+		count = DefaultSubIDCount
+	}
+	return fmt.Sprintf("%s:%d:%d", c.RootlessUserName(), start, count)
+}
+
+// RootlessStorageDriver returns the containers/storage driver for the rootless user
+func (c Config) RootlessStorageDriver() string {
+	if c.RootlessStorage.Driver != "" {
+		return c.RootlessStorage.Driver
+	}
+	return "overlay"
+}
+
+// RootlessGraphRoot returns where the rootless user's images and containers are stored
+func (c Config) RootlessGraphRoot() string {
+	if c.RootlessStorage.GraphRoot != "" {
+		return c.RootlessStorage.GraphRoot
+	}
+	return fmt.Sprintf("/var/home/%s/.local/share/containers/storage", c.RootlessUserName())
+}
+
+// UsesPodmanAutoUpdate reports whether podman-auto-update keeps the container up to date
+func (c Config) UsesPodmanAutoUpdate() bool {
+	return c.UpdateMechanism == UpdateMechanismPodman
 }
 
 type MachineList struct {
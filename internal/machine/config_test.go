@@ -28,3 +28,63 @@ func TestGeneratePasswordHash(t *testing.T) {
 	assert.NotEqual(t, password, hash, "Hash should be different from password")
 	assert.True(t, len(hash) > 20, "Hash should be sufficiently long")
 }
+
+func TestRootlessSettings(t *testing.T) {
+	m := Config{Name: "app", Rootless: true}
+	assert.Equal(t, "app", m.RootlessUserName())
+	assert.Equal(t, "app:1000000000:65536", m.RootlessSubIDRange())
+	assert.Equal(t, "overlay", m.RootlessStorageDriver())
+	assert.Equal(t, "/var/home/app/.local/share/containers/storage", m.RootlessGraphRoot())
+
+	m.RootlessUser = "svc"
+	m.RootlessSubIDs = SubIDConfig{Start: 200000, Count: 131072}
+	m.RootlessStorage = StorageConfig{Driver: "vfs", GraphRoot: "/var/lib/app/storage"}
+	assert.Equal(t, "svc:200000:131072", m.RootlessSubIDRange())
+	assert.Equal(t, "vfs", m.RootlessStorageDriver())
+	assert.Equal(t, "/var/lib/app/storage", m.RootlessGraphRoot())
+}
+
+func TestContainerRegistryConfig_Registries(t *testing.T) {
+	config := ContainerRegistryConfig{
+		TLS: map[string]RegistryTLSConfig{
+			"registry.lab:5000": {CAFile: "old.pem"},
+			"ghcr.io":           {CAFile: "ghcr.pem"},
+		},
+		Registries: map[string]RegistryConfig{
+			"registry.lab:5000": {PlainHTTP: true, Auth: HostAuthConfig{Username: "robot", TokenEnv: "LAB_TOKEN"}},
+			"docker.io":         {Mirrors: []string{"harbor.lab/hub"}},
+		},
+	}
+
+	tls := config.TLSConfigs()
+	assert.Equal(t, RegistryTLSConfig{PlainHTTP: true}, tls["registry.lab:5000"], "registries replaces tls")
+	assert.Equal(t, "ghcr.pem", tls["ghcr.io"].CAFile)
+	assert.Equal(t, map[string][]string{DockerHub: {"harbor.lab/hub"}}, config.Mirrors())
+
+	auth, ok := config.RegistryAuth("registry.lab:5000/team")
+	assert.True(t, ok)
+	assert.Equal(t, "robot", auth.Username)
+	_, ok = config.RegistryAuth("docker.io")
+	assert.False(t, ok)
+}
+
+func TestDefaults_RegistryTLSConfigs(t *testing.T) {
+	chdirTemp(t)
+	defaults := Defaults{ContainerRegistry: ContainerRegistryConfig{
+		URL: "registry.lab/homelab",
+		Registries: map[string]RegistryConfig{
+			"ghcr.io":     {},
+			"harbor.lab":  {CAFile: "harbor.pem"},
+			"plain.lab:5": {PlainHTTP: true},
+		},
+	}}
+	assert.NotContains(t, defaults.RegistryTLSConfigs(), "localhost:5000", "no CA, nothing added")
+
+	writeFiles(t, map[string]string{DefaultCACertificate: "certificate"})
+	configs := defaults.RegistryTLSConfigs()
+	assert.Equal(t, DefaultCACertificate, configs["localhost:5000"].CAFile)
+	assert.Equal(t, DefaultCACertificate, configs["registry.lab"].CAFile)
+	assert.Equal(t, DefaultCACertificate, configs["ghcr.io"].CAFile)
+	assert.Equal(t, "harbor.pem", configs["harbor.lab"].CAFile, "a registry's own ca_file wins")
+	assert.Empty(t, configs["plain.lab:5"].CAFile)
+}
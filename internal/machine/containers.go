@@ -0,0 +1,106 @@
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ContainersConfig is the fleet's host container policy, written to
+// /etc/containers/registries.conf.d and containers.conf.d on every machine
+type ContainersConfig struct {
+	ShortNameMode    string           `toml:"short_name_mode,omitempty" jsonschema:"enum=enforcing|permissive|disabled"`
+	SearchRegistries []string         `toml:"search_registries,omitempty"` // Registries short names like "nginx" are looked up in, in order
+	Blocked          []string         `toml:"blocked,omitempty"`           // Registry prefixes pulls are refused from, e.g. "docker.io/library"
+	Registries       []RegistryPolicy `toml:"registries,omitempty"`        // Per-prefix mirrors and rewrites
+	LogDriver        string           `toml:"log_driver,omitempty" jsonschema:"enum=journald|k8s-file|json-file|passthrough|none"`
+	LogSizeMax       string           `toml:"log_size_max,omitempty"` // Container log cap for k8s-file, e.g. "10M"
+	PidsLimit        int64            `toml:"pids_limit,omitempty"`   // Per-container process limit (0 keeps the OS default, -1 is unlimited)
+	Ulimits          []string         `toml:"ulimits,omitempty"`      // Default ulimits, e.g. "nofile=65535:65535"
+}
+
+// RegistryPolicy is one [[registry]] of registries.conf(5)
+type RegistryPolicy struct {
+	Prefix             string   `toml:"prefix"`                          // Image names it applies to, e.g. "docker.io" or "*.lab"
+	Location           string   `toml:"location,omitempty"`              // Pull from here instead of prefix
+	Mirrors            []string `toml:"mirrors,omitempty"`               // Tried in order before location
+	MirrorByDigestOnly bool     `toml:"mirror_by_digest_only,omitempty"` // Only use mirrors for pulls by digest
+	Insecure           bool     `toml:"insecure,omitempty"`              // Allow HTTP and unverified TLS
+}
+
+// IsZero reports whether no container policy is configured, leaving the OS defaults alone
+func (c ContainersConfig) IsZero() bool {
+	return c.ShortNameMode == "" && len(c.SearchRegistries) == 0 && len(c.Blocked) == 0 && len(c.Registries) == 0 &&
+		c.LogDriver == "" && c.LogSizeMax == "" && c.PidsLimit == 0 && len(c.Ulimits) == 0
+}
+
+// LogSizeBytes parses LogSizeMax: bytes with an optional K, M or G suffix
+// (powers of 1024), -1 for unlimited, or 0 when unset
+func (c ContainersConfig) LogSizeBytes() (int64, error) {
+	value := strings.TrimSpace(c.LogSizeMax)
+	if value == "" {
+		return 0, nil
+	}
+	if value == "-1" {
+		return -1, nil
+	}
+	multiplier := int64(1)
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid [containers] log_size_max %q (expected a size such as \"10M\" or -1)", c.LogSizeMax)
+	}
+	return n * multiplier, nil
+}
+
+// ValidateContainers checks [containers] settings podman would reject
+func ValidateContainers(c ContainersConfig) error {
+	var errs []error
+	if c.ShortNameMode != "" && !slices.Contains([]string{"enforcing", "permissive", "disabled"}, c.ShortNameMode) {
+		errs = append(errs, fmt.Errorf("invalid [containers] short_name_mode %q (expected enforcing, permissive or disabled)", c.ShortNameMode))
+	}
+	if c.LogDriver != "" && !slices.Contains([]string{"journald", "k8s-file", "json-file", "passthrough", "none"}, c.LogDriver) {
+		errs = append(errs, fmt.Errorf("invalid [containers] log_driver %q", c.LogDriver))
+	}
+	if _, err := c.LogSizeBytes(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.PidsLimit < -1 {
+		errs = append(errs, fmt.Errorf("invalid [containers] pids_limit %d", c.PidsLimit))
+	}
+	for _, ulimit := range c.Ulimits {
+		name, limits, ok := strings.Cut(ulimit, "=")
+		if !ok || name == "" || limits == "" {
+			errs = append(errs, fmt.Errorf("invalid [containers] ulimit %q (expected name=soft[:hard])", ulimit))
+		}
+	}
+
+	prefixes := make(map[string]bool)
+	for _, r := range c.Registries {
+		switch {
+		case r.Prefix == "":
+			errs = append(errs, fmt.Errorf("[[containers.registries]] entry has no prefix"))
+		case prefixes[r.Prefix]:
+			errs = append(errs, fmt.Errorf("[[containers.registries]] prefix %q appears twice", r.Prefix))
+		}
+		prefixes[r.Prefix] = true
+	}
+	for _, prefix := range c.Blocked {
+		if prefixes[prefix] {
+			errs = append(errs, fmt.Errorf("[containers] blocked prefix %q also has a [[containers.registries]] entry", prefix))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,38 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateContainers(t *testing.T) {
+	assert.NoError(t, ValidateContainers(ContainersConfig{}))
+	assert.NoError(t, ValidateContainers(ContainersConfig{
+		ShortNameMode: "permissive",
+		LogDriver:     "journald",
+		LogSizeMax:    "-1",
+		PidsLimit:     -1,
+		Ulimits:       []string{"nofile=1024:4096"},
+		Registries:    []RegistryPolicy{{Prefix: "docker.io", Mirrors: []string{"mirror.gcr.io"}}},
+	}))
+
+	err := ValidateContainers(ContainersConfig{
+		ShortNameMode: "strict",
+		LogDriver:     "syslog",
+		LogSizeMax:    "lots",
+		PidsLimit:     -5,
+		Ulimits:       []string{"nofile"},
+		Blocked:       []string{"quay.io"},
+		Registries:    []RegistryPolicy{{Prefix: "quay.io"}, {Prefix: "quay.io"}, {}},
+	})
+	require.Error(t, err)
+	for _, want := range []string{"short_name_mode", "log_driver", "log_size_max", "pids_limit", "ulimit", "appears twice", "has no prefix", `blocked prefix "quay.io"`} {
+		assert.Contains(t, err.Error(), want)
+	}
+
+	size, err := ContainersConfig{LogSizeMax: "512K"}.LogSizeBytes()
+	require.NoError(t, err)
+	assert.Equal(t, int64(512*1024), size)
+}
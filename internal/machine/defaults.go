@@ -1,12 +1,34 @@
 package machine
 
+import (
+	"os"
+	"strings"
+)
+
 type Defaults struct {
+	Domain            string                  `toml:"domain,omitempty"` // Machines without an fqdn get <name>.<domain>; usually set per site
 	User              UserConfig              `toml:"user"`
 	Admin             AdminConfig             `toml:"admin"`
 	Network           NetworkConfig           `toml:"network"`
 	Updates           UpdateConfig            `toml:"updates"`
 	Bootc             BootcConfig             `toml:"bootc"`
 	ContainerRegistry ContainerRegistryConfig `toml:"container_registry"`
+	Containers        ContainersConfig        `toml:"containers,omitempty"`
+	Hypervisor        HypervisorConfig        `toml:"hypervisor"`
+	Templates         TemplatesConfig         `toml:"templates"`
+	Env               EnvConfig               `toml:"env"`
+	Hardening         HardeningConfig         `toml:"hardening,omitempty"`
+	Maintenance       MaintenanceConfig       `toml:"maintenance,omitempty"`
+	Signing           SigningConfig           `toml:"signing,omitempty"`
+	Secrets           SecretsConfig           `toml:"secrets,omitempty"`
+	Output            OutputConfig            `toml:"output,omitempty"`
+	OnePassword       OnePasswordConfig       `toml:"onepassword,omitempty"`
+	PXE               PXEConfig               `toml:"pxe,omitempty"`
+	Sysext            SysextConfig            `toml:"sysext,omitempty"`
+	CA                CAConfig                `toml:"ca,omitempty"`
+	Include           []IncludeConfig         `toml:"include,omitempty"` // Repositories whose machines and containers are overlaid read-only; defaults.toml only
+
+	root string // The repository the defaults were loaded from; "" is the working directory
 }
 
 type UserConfig struct {
@@ -14,12 +36,22 @@ type UserConfig struct {
 	GitHubUsername string   `toml:"github_username"`
 	Groups         []string `toml:"groups"`
 	PasswordHash   string   `toml:"password_hash"`
+	SSHOnly        bool     `toml:"ssh_only,omitempty"`                                      // Lock the password; log in with SSH keys only
+	Sudo           string   `toml:"sudo,omitempty" jsonschema:"enum=nopasswd|password|none"` // Per-account sudoers rule; unset leaves sudo to group membership
+	Expires        string   `toml:"expires,omitempty"`                                       // Account expiry date (YYYY-MM-DD)
+	Shell          string   `toml:"shell,omitempty"`                                         // Login shell, e.g. /bin/bash
 }
 
 type AdminConfig struct {
-	Username     string   `toml:"username"`
-	Groups       []string `toml:"groups"`
-	PasswordHash string   `toml:"password_hash"`
+	Username       string   `toml:"username"`
+	Groups         []string `toml:"groups"`
+	PasswordHash   string   `toml:"password_hash"`
+	GitHubUsername string   `toml:"github_username,omitempty"`                               // Break-glass keys from github.com/<name>.keys, as .AdminSSHKeys
+	SSHKeys        []string `toml:"ssh_keys,omitempty"`                                      // Public keys added to .AdminSSHKeys as they are
+	SSHOnly        bool     `toml:"ssh_only,omitempty"`                                      // Lock the password; log in with SSH keys only
+	Sudo           string   `toml:"sudo,omitempty" jsonschema:"enum=nopasswd|password|none"` // Per-account sudoers rule; unset leaves sudo to group membership
+	Expires        string   `toml:"expires,omitempty"`                                       // Account expiry date (YYYY-MM-DD)
+	Shell          string   `toml:"shell,omitempty"`                                         // Login shell, e.g. /bin/bash
 }
 
 type NetworkConfig struct {
@@ -29,10 +61,10 @@ type NetworkConfig struct {
 }
 
 type UpdateConfig struct {
-	Strategy   string `toml:"strategy"`
+	Strategy   string `toml:"strategy" jsonschema:"enum=immediate|periodic|fleet_lock"`
 	Period     string `toml:"period"`
 	RebootTime string `toml:"reboot_time"`
-	Stream     string `toml:"stream"`
+	Stream     string `toml:"stream" jsonschema:"enum=stable|testing|next"`
 }
 
 type BootcConfig struct {
@@ -42,4 +74,315 @@ type BootcConfig struct {
 
 type ContainerRegistryConfig struct {
 	URL string `toml:"url"`
+	// TLS holds per-registry transport settings keyed by registry host (e.g. "registry.lab:5000")
+	TLS         map[string]RegistryTLSConfig `toml:"tls,omitempty"`
+	Pull        PullConfig                   `toml:"pull,omitempty"`
+	Performance PerformanceConfig            `toml:"performance,omitempty"`
+	Build       BuildConfig                  `toml:"build,omitempty"`
+	// HostAuth holds the pull credentials written onto machines, keyed by registry host (e.g. "ghcr.io")
+	HostAuth map[string]HostAuthConfig `toml:"host_auth,omitempty"`
+	// Registries holds how the workstation reaches other registries, keyed by host (e.g. "registry.lab:5000")
+	Registries map[string]RegistryConfig `toml:"registries,omitempty"`
+}
+
+// RegistryConfig is how iago reaches one registry from the workstation when
+// pulling FROM images, pushing and inspecting images
+type RegistryConfig struct {
+	Mirrors            []string       `toml:"mirrors,omitempty"`              // Pull-through mirrors FROM images are tried from first, in order, e.g. "harbor.lab/proxy-quay"
+	CAFile             string         `toml:"ca_file,omitempty"`              // PEM bundle trusted in addition to system roots, e.g. a homelab CA
+	CertFile           string         `toml:"cert_file,omitempty"`            // Client certificate for mutual TLS
+	KeyFile            string         `toml:"key_file,omitempty"`             // Key of cert_file
+	InsecureSkipVerify bool           `toml:"insecure_skip_verify,omitempty"` // Accept a self-signed certificate without checking it
+	PlainHTTP          bool           `toml:"plain_http,omitempty"`           // Registries without TLS
+	Auth               HostAuthConfig `toml:"auth,omitempty"`                 // Credentials, used before docker/podman login
+}
+
+// DockerHub is the registry host image references without one resolve to
+const DockerHub = "index.docker.io"
+
+// RegistryHost returns the host references to registry resolve to, which
+// is only different for Docker Hub
+func RegistryHost(registry string) string {
+	if registry == "docker.io" {
+		return DockerHub
+	}
+	return registry
+}
+
+// TLSConfigs returns the transport settings of each registry host, from
+// [container_registry.registries] and the older [container_registry.tls]
+func (c ContainerRegistryConfig) TLSConfigs() map[string]RegistryTLSConfig {
+	configs := make(map[string]RegistryTLSConfig, len(c.TLS)+len(c.Registries))
+	for host, tls := range c.TLS {
+		configs[RegistryHost(host)] = tls
+	}
+	for host, registry := range c.Registries {
+		configs[RegistryHost(host)] = RegistryTLSConfig{
+			CAFile:             registry.CAFile,
+			CertFile:           registry.CertFile,
+			KeyFile:            registry.KeyFile,
+			InsecureSkipVerify: registry.InsecureSkipVerify,
+			PlainHTTP:          registry.PlainHTTP,
+		}
+	}
+	return configs
+}
+
+// Mirrors returns the pull-through mirrors of each registry host that has any
+func (c ContainerRegistryConfig) Mirrors() map[string][]string {
+	mirrors := make(map[string][]string)
+	for host, registry := range c.Registries {
+		if len(registry.Mirrors) > 0 {
+			mirrors[RegistryHost(host)] = registry.Mirrors
+		}
+	}
+	return mirrors
+}
+
+// RegistryAuth returns the credentials configured for the registry of
+// registryURL, e.g. "registry.lab:5000/team"
+func (c ContainerRegistryConfig) RegistryAuth(registryURL string) (HostAuthConfig, bool) {
+	host, _, _ := strings.Cut(registryURL, "/")
+	for configured, registry := range c.Registries {
+		if RegistryHost(configured) == RegistryHost(host) && registry.Auth != (HostAuthConfig{}) {
+			return registry.Auth, true
+		}
+	}
+	return HostAuthConfig{}, false
+}
+
+// HostAuthConfig is where a registry's token comes from: for host_auth the
+// pull token written onto machines, for registries the workstation's
+// credentials. Set either Ref or TokenEnv.
+type HostAuthConfig struct {
+	Username string `toml:"username"`            // Registry user the token belongs to
+	Ref      string `toml:"ref,omitempty"`       // [secrets] provider reference of a read-only pull token
+	TokenEnv string `toml:"token_env,omitempty"` // Environment variable holding the token instead
+}
+
+// BuildConfig selects the tool that builds Containerfiles, the platforms to
+// build for and how the pushed layers are compressed
+type BuildConfig struct {
+	Backend     string                 `toml:"backend,omitempty" jsonschema:"enum=auto|podman|buildah|docker|simple"` // default auto: podman, buildah, then docker
+	Platforms   []string               `toml:"platforms,omitempty"`                                                   // e.g. ["linux/amd64", "linux/arm64"]; empty builds for the host
+	Compression string                 `toml:"compression,omitempty" jsonschema:"enum=gzip|zstd"`                     // default gzip
+	Estargz     bool                   `toml:"estargz,omitempty"`                                                     // eStargz layers for lazy pulling
+	Workloads   map[string]LayerConfig `toml:"workloads,omitempty"`                                                   // Per-workload compression, by workload name
+	BaseImages  BaseImagePolicy        `toml:"base_images,omitempty"`                                                 // Registries and images Containerfiles may build FROM
+}
+
+// LayerConfig is how a workload's layers are compressed
+type LayerConfig struct {
+	Compression string `toml:"compression,omitempty" jsonschema:"enum=gzip|zstd"`
+	Estargz     bool   `toml:"estargz,omitempty"`
+}
+
+// Layers returns the layer compression for workload: its [container_registry.build.workloads.<name>]
+// entry when there is one, otherwise the build defaults
+func (c BuildConfig) Layers(workload string) LayerConfig {
+	if layers, ok := c.Workloads[workload]; ok {
+		return layers
+	}
+	return LayerConfig{Compression: c.Compression, Estargz: c.Estargz}
+}
+
+// PerformanceConfig tunes image pushes for large bootc images
+type PerformanceConfig struct {
+	PushConcurrency int    `toml:"push_concurrency,omitempty"` // Concurrent blob uploads (default 4)
+	SkipExisting    bool   `toml:"skip_existing,omitempty"`    // Skip the push when the tag already points at the built digest
+	LimitRate       string `toml:"limit_rate,omitempty"`       // Bandwidth cap for pulls and pushes, e.g. "2M" (bytes per second)
+}
+
+// PullConfig controls how base images named in FROM are pulled
+type PullConfig struct {
+	Mirror     string `toml:"mirror,omitempty"`      // Docker Hub pull-through mirror, e.g. "mirror.gcr.io"
+	MaxRetries int    `toml:"max_retries,omitempty"` // Retries after HTTP 429 (default 4)
+}
+
+// RegistryTLSConfig customizes how iago connects to a single registry
+type RegistryTLSConfig struct {
+	CAFile             string `toml:"ca_file,omitempty"`   // PEM bundle trusted in addition to system roots
+	CertFile           string `toml:"cert_file,omitempty"` // Client certificate for mutual TLS
+	KeyFile            string `toml:"key_file,omitempty"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify,omitempty"`
+	PlainHTTP          bool   `toml:"plain_http,omitempty"` // Lab registries without TLS
+}
+
+// HypervisorConfig selects the backend used to reach machine consoles and VMs
+type HypervisorConfig struct {
+	Backend string        `toml:"backend" jsonschema:"enum=proxmox|libvirt"` // "proxmox" or "libvirt"
+	Proxmox ProxmoxConfig `toml:"proxmox"`
+	Libvirt LibvirtConfig `toml:"libvirt"`
+}
+
+type ProxmoxConfig struct {
+	Host        string `toml:"host"`
+	Node        string `toml:"node"`
+	SSHUser     string `toml:"ssh_user"`
+	SnippetsDir string `toml:"snippets_dir"`
+
+	// API settings used by iago provision proxmox; the token secret comes from the "proxmox" secret reference
+	TokenID            string `toml:"token_id,omitempty"`                                             // e.g. "iago@pve!provision"
+	Template           int    `toml:"template,omitempty"`                                             // VM ID of the Fedora CoreOS template to clone
+	Bridge             string `toml:"bridge,omitempty"`                                               // default "vmbr0"
+	SnippetsStorage    string `toml:"snippets_storage,omitempty"`                                     // Storage holding snippets_dir (default "local")
+	CloudInitStorage   string `toml:"cloudinit_storage,omitempty"`                                    // Storage for the cloud-init drive (default "local-lvm")
+	IgnitionDelivery   string `toml:"ignition_delivery,omitempty" jsonschema:"enum=cloudinit|fw_cfg"` // How VMs get ignition: "cloudinit" (default) or "fw_cfg"
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify,omitempty"`                                 // Accept the host's self-signed certificate
+}
+
+type LibvirtConfig struct {
+	URI         string `toml:"uri"`
+	IgnitionDir string `toml:"ignition_dir"`
+}
+
+// HardeningConfig selects hardening presets; set in defaults, roles or a machine's [hardening] table
+type HardeningConfig struct {
+	Presets []string `toml:"presets,omitempty"` // e.g. ["cis-level1", "fips"]
+	Exclude []string `toml:"exclude,omitempty"` // Control IDs to opt out of
+}
+
+// SigningConfig points at the minisign keys used for generated ignition files
+type SigningConfig struct {
+	IgnitionKey        string `toml:"ignition_key,omitempty"`         // Secret key; when set, every generated .ign gets a .minisig
+	IgnitionPublicKey  string `toml:"ignition_public_key,omitempty"`  // Public key used by verify-ignition
+	ContainerPublicKey string `toml:"container_public_key,omitempty"` // Cosign public key used by iago verify
+	FulcioURL          string `toml:"fulcio_url,omitempty"`           // Keyless signing CA (default https://fulcio.sigstore.dev)
+	RekorURL           string `toml:"rekor_url,omitempty"`            // Keyless signing transparency log (default https://rekor.sigstore.dev)
+}
+
+// SecretsConfig encrypts generated secrets with age so they aren't in
+// plaintext in the ignition and debug butane files
+type SecretsConfig struct {
+	Encryption  string   `toml:"encryption,omitempty" jsonschema:"enum=none|age"` // "age" encrypts files under /etc/iago/secrets/ to machines/<name>/recipients.txt
+	Recipients  []string `toml:"recipients,omitempty"`                            // Operator age recipients that can also decrypt every machine's secrets
+	IdentityDir string   `toml:"identity_dir,omitempty"`                          // Where iago secrets keygen keeps machine identities (default ~/.config/iago/age)
+	AgeImage    string   `toml:"age_image,omitempty"`                             // Image with age in its PATH for hosts without it, pinned by digest
+
+	// Provider resolves registry tokens, host_auth refs and the secret template function
+	Provider string            `toml:"provider,omitempty" jsonschema:"enum=onepassword|vault|sops|env"` // default onepassword
+	Refs     map[string]string `toml:"refs,omitempty"`                                                  // Purpose -> reference in the provider's syntax
+	Vault    VaultConfig       `toml:"vault,omitempty"`
+	SOPS     SOPSConfig        `toml:"sops,omitempty"`
+}
+
+// VaultConfig locates the HashiCorp Vault KV v2 engine secrets are read from
+type VaultConfig struct {
+	Address   string `toml:"address,omitempty"`   // default $VAULT_ADDR
+	Mount     string `toml:"mount,omitempty"`     // KV v2 mount (default "secret")
+	Namespace string `toml:"namespace,omitempty"` // Vault Enterprise namespace
+}
+
+// SOPSConfig names the SOPS-encrypted file references are looked up in
+type SOPSConfig struct {
+	File string `toml:"file,omitempty"` // e.g. "config/secrets.sops.yaml"
+}
+
+// OutputConfig controls how many past generations of each machine's output are archived
+type OutputConfig struct {
+	ArchiveDir string `toml:"archive_dir,omitempty"` // default output/archive
+	Keep       int    `toml:"keep,omitempty"`        // Generations ignite keeps per machine (unset: ignite never prunes, iago gc output keeps 10; -1 disables archiving)
+}
+
+// PXEConfig describes how iago pxe netboots bare metal into Fedora CoreOS
+type PXEConfig struct {
+	BaseURL      string `toml:"base_url,omitempty"`      // Where machines fetch <name>.ign, e.g. "http://10.0.0.5:8080"
+	Version      string `toml:"version,omitempty"`       // Fedora CoreOS release of the live PXE artifacts, e.g. "40.20240416.3.1"
+	Arch         string `toml:"arch,omitempty"`          // default x86_64
+	ArtifactsURL string `toml:"artifacts_url,omitempty"` // Local mirror of the artifacts (default the Fedora CoreOS builds site for updates.stream)
+
+	// Artifact file names or URLs, for mirrors that don't keep the release naming
+	Kernel    string `toml:"kernel,omitempty"`
+	Initramfs string `toml:"initramfs,omitempty"`
+	Rootfs    string `toml:"rootfs,omitempty"`
+
+	InstallDevice string   `toml:"install_device,omitempty"` // Install to this disk with coreos-installer; unset runs the live system from RAM
+	KernelArgs    []string `toml:"kernel_args,omitempty"`    // Extra kernel arguments, e.g. "console=ttyS0,115200n8"
+}
+
+// OnePasswordConfig names the 1Password secrets iago resolves, keyed by purpose
+type OnePasswordConfig struct {
+	Vault string            `toml:"vault,omitempty"` // Vault for references written as "item/field"
+	Refs  map[string]string `toml:"refs,omitempty"`  // Purpose ("registry", "proxmox", "cloudflare") -> secret reference
+}
+
+// TemplatesConfig controls optional template functions
+type TemplatesConfig struct {
+	Fetch FetchConfig `toml:"fetch"`
+}
+
+// FetchConfig guards the fetchURL template function
+type FetchConfig struct {
+	AllowedHosts []string `toml:"allowed_hosts"`
+	CacheDir     string   `toml:"cache_dir"`
+	CacheTTL     string   `toml:"cache_ttl"` // Go duration, e.g. "24h"
+}
+
+// SysextConfig delivers machine configuration as signed systemd-sysext and
+// systemd-confext images that hosts pull and merge (see iago sysext build)
+type SysextConfig struct {
+	URL         string `toml:"url,omitempty"`         // Where hosts fetch <machine>/SHA256SUMS and its images; enables the pull timer
+	Interval    string `toml:"interval,omitempty"`    // How often hosts check for new images (default 15min)
+	SigningKey  string `toml:"signing_key,omitempty"` // PEM private key that signs the images' dm-verity root hashes
+	Certificate string `toml:"certificate,omitempty"` // PEM certificate of signing_key; installed in /etc/verity.d, hosts only merge images and SHA256SUMS it verifies
+}
+
+// CAConfig is the homelab certificate authority 'iago ca' manages. Every
+// machine trusts its certificate, as do registry pulls and pushes.
+type CAConfig struct {
+	Certificate string `toml:"certificate,omitempty"` // PEM certificate, committed with the repository (default config/ca/ca.crt)
+	Key         string `toml:"key,omitempty"`         // PEM private key, kept off the repository (default .iago/ca/ca.key)
+}
+
+// Default locations of the CA's certificate and key
+const (
+	DefaultCACertificate = "config/ca/ca.crt"
+	DefaultCAKey         = ".iago/ca/ca.key"
+)
+
+// CertificatePath returns the CA certificate, or "" when there is no CA
+func (c CAConfig) CertificatePath() string {
+	if c.Certificate != "" {
+		return c.Certificate
+	}
+	if _, err := os.Stat(DefaultCACertificate); err == nil {
+		return DefaultCACertificate
+	}
+	return ""
+}
+
+// KeyPath returns the CA key
+func (c CAConfig) KeyPath() string {
+	if c.Key != "" {
+		return c.Key
+	}
+	return DefaultCAKey
+}
+
+// RegistryTLSConfigs returns the transport settings of each registry host,
+// trusting the CA for the local registry, the registry images are pushed to
+// and every configured registry without a ca_file of its own
+func (d Defaults) RegistryTLSConfigs() map[string]RegistryTLSConfig {
+	configs := d.ContainerRegistry.TLSConfigs()
+	caFile := d.CA.CertificatePath()
+	if caFile == "" {
+		return configs
+	}
+	hosts := []string{"localhost:5000"}
+	if d.ContainerRegistry.URL != "" {
+		host, _, _ := strings.Cut(d.ContainerRegistry.URL, "/")
+		hosts = append(hosts, RegistryHost(host))
+	}
+	for _, host := range hosts {
+		if _, ok := configs[host]; !ok {
+			configs[host] = RegistryTLSConfig{}
+		}
+	}
+	for host, tls := range configs {
+		if tls.CAFile == "" && !tls.PlainHTTP && !tls.InsecureSkipVerify {
+			tls.CAFile = caFile
+			configs[host] = tls
+		}
+	}
+	return configs
 }
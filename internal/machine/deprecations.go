@@ -0,0 +1,176 @@
+package machine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/andreweick/iago/internal/tomledit"
+)
+
+// LegacyMachinesFile is the single-file machine list replaced by machines/<name>/machine.toml
+const LegacyMachinesFile = "config/machines.toml"
+
+// legacyTemplateNames are butane template names replaced by butane.yaml.tmpl
+var legacyTemplateNames = []string{"butane.yaml", "butane.yml", "butane.yml.tmpl"}
+
+// Deprecation is an outdated config layout, with the fix `iago migrate` applies
+type Deprecation struct {
+	Path    string
+	Message string
+	Fix     string // Empty when the layout must be fixed by hand
+
+	apply func() error
+}
+
+var warnOnce sync.Once
+
+// WarnDeprecations prints deprecated layouts to stderr, once per process
+func WarnDeprecations() {
+	warnOnce.Do(func() {
+		deprecations, err := CheckDeprecations()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not check for deprecated config: %v\n", err)
+			return
+		}
+		for _, d := range deprecations {
+			hint := "run 'iago migrate'"
+			if d.apply == nil {
+				hint = "fix by hand"
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s (%s)\n", d.Path, d.Message, hint)
+		}
+	})
+}
+
+// CheckDeprecations finds deprecated layouts in the current directory
+func CheckDeprecations() ([]Deprecation, error) {
+	var deprecations []Deprecation
+
+	legacy, err := legacyMachinesDeprecation()
+	if err != nil {
+		return nil, err
+	}
+	if legacy != nil {
+		deprecations = append(deprecations, *legacy)
+	}
+
+	machineDirs, err := os.ReadDir("machines")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read machines directory: %w", err)
+	}
+	for _, dir := range machineDirs {
+		if dir.IsDir() {
+			deprecations = append(deprecations, legacyTemplateDeprecations(filepath.Join("machines", dir.Name()))...)
+		}
+	}
+
+	return deprecations, nil
+}
+
+// Migrate applies the fix of every deprecation that has one and returns the
+// deprecations it handled; nothing is changed when dryRun is set
+func Migrate(dryRun bool) ([]Deprecation, error) {
+	deprecations, err := CheckDeprecations()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Deprecation
+	for _, d := range deprecations {
+		if d.apply == nil {
+			continue
+		}
+		if !dryRun {
+			if err := d.apply(); err != nil {
+				return applied, fmt.Errorf("failed to migrate %s: %w", d.Path, err)
+			}
+		}
+		applied = append(applied, d)
+	}
+	return applied, nil
+}
+
+// ManualOnly reports whether a deprecation has no automatic fix
+func (d Deprecation) ManualOnly() bool {
+	return d.apply == nil
+}
+
+func legacyMachinesDeprecation() (*Deprecation, error) {
+	content, err := os.ReadFile(LegacyMachinesFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", LegacyMachinesFile, err)
+	}
+
+	doc, err := tomledit.Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", LegacyMachinesFile, err)
+	}
+	entries, err := doc.Entries("machines")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", LegacyMachinesFile, err)
+	}
+
+	return &Deprecation{
+		Path:    LegacyMachinesFile,
+		Message: fmt.Sprintf("single-file machine list is no longer read; its %d machine(s) are ignored", len(entries)),
+		Fix:     "move each machine to machines/<name>/machine.toml and rename the file to machines.toml.bak",
+		apply: func() error {
+			// Each entry is copied as written, comments included
+			for _, entry := range entries {
+				var m Config
+				if err := entry.Decode(&m); err != nil {
+					return err
+				}
+				path := filepath.Join("machines", m.Name, "machine.toml")
+				if _, err := os.Stat(path); err == nil {
+					continue // machines/ already wins; keep it
+				}
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					return err
+				}
+				if err := entry.Save(path); err != nil {
+					return err
+				}
+			}
+			return os.Rename(LegacyMachinesFile, LegacyMachinesFile+".bak")
+		},
+	}, nil
+}
+
+func legacyTemplateDeprecations(machineDir string) []Deprecation {
+	current := filepath.Join(machineDir, "butane.yaml.tmpl")
+	_, err := os.Stat(current)
+	hasCurrent := err == nil
+
+	var deprecations []Deprecation
+	for _, name := range legacyTemplateNames {
+		legacy := filepath.Join(machineDir, name)
+		if _, err := os.Stat(legacy); err != nil {
+			continue
+		}
+
+		if hasCurrent {
+			deprecations = append(deprecations, Deprecation{
+				Path:    legacy,
+				Message: "ignored because butane.yaml.tmpl exists; remove it once any changes are merged",
+			})
+			continue
+		}
+
+		hasCurrent = true
+		deprecations = append(deprecations, Deprecation{
+			Path:    legacy,
+			Message: "butane templates must be named butane.yaml.tmpl; this file is ignored",
+			Fix:     "rename to butane.yaml.tmpl",
+			apply: func() error {
+				return os.Rename(legacy, current)
+			},
+		})
+	}
+	return deprecations
+}
@@ -0,0 +1,132 @@
+package machine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	tempDir := t.TempDir()
+	originalDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		os.Chdir(originalDir)
+	})
+	return tempDir
+}
+
+func writeFiles(t *testing.T, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestCheckDeprecations_CurrentLayout(t *testing.T) {
+	chdirTemp(t)
+	writeFiles(t, map[string]string{
+		"machines/web/machine.toml":     "name = \"web\"\n",
+		"machines/web/butane.yaml.tmpl": "variant: fcos\n",
+	})
+
+	deprecations, err := CheckDeprecations()
+	require.NoError(t, err)
+	assert.Empty(t, deprecations)
+}
+
+func TestMigrate_LegacyMachinesFile(t *testing.T) {
+	chdirTemp(t)
+	writeFiles(t, map[string]string{
+		LegacyMachinesFile: `[[machines]]
+name = "old"
+fqdn = "old.example.com"
+
+[[machines]]
+name = "web"
+fqdn = "stale.example.com"
+`,
+		"machines/web/machine.toml": "name = \"web\"\nfqdn = \"web.example.com\"\n",
+	})
+
+	deprecations, err := CheckDeprecations()
+	require.NoError(t, err)
+	require.Len(t, deprecations, 1)
+	assert.Contains(t, deprecations[0].Message, "2 machine(s) are ignored")
+
+	migrated, err := Migrate(true)
+	require.NoError(t, err)
+	assert.Len(t, migrated, 1)
+	assert.FileExists(t, LegacyMachinesFile, "dry run must not change files")
+
+	_, err = Migrate(false)
+	require.NoError(t, err)
+	assert.NoFileExists(t, LegacyMachinesFile)
+	assert.FileExists(t, LegacyMachinesFile+".bak")
+
+	loader := NewConfigLoader()
+	require.NoError(t, loader.LoadMachines())
+	old, err := loader.GetMachine("old")
+	require.NoError(t, err)
+	assert.Equal(t, "old.example.com", old.FQDN)
+	web, err := loader.GetMachine("web")
+	require.NoError(t, err)
+	assert.Equal(t, "web.example.com", web.FQDN, "existing machine directories are kept")
+}
+
+func TestMigrate_LegacyMachinesFileKeepsComments(t *testing.T) {
+	chdirTemp(t)
+	writeFiles(t, map[string]string{
+		LegacyMachinesFile: `# Database server
+[[machines]]
+name = "db"
+fqdn = "db.example.com" # internal only
+
+[machines.network]
+ip = "10.0.0.7"
+`,
+	})
+
+	_, err := Migrate(false)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile("machines/db/machine.toml")
+	require.NoError(t, err)
+	assert.Equal(t, `# Database server
+name = "db"
+fqdn = "db.example.com" # internal only
+
+[network]
+ip = "10.0.0.7"
+`, string(content))
+}
+
+func TestMigrate_LegacyTemplateNames(t *testing.T) {
+	chdirTemp(t)
+	writeFiles(t, map[string]string{
+		"machines/a/butane.yml":       "variant: fcos\n",
+		"machines/b/butane.yaml.tmpl": "variant: fcos\n",
+		"machines/b/butane.yaml":      "variant: fcos\n",
+	})
+
+	deprecations, err := CheckDeprecations()
+	require.NoError(t, err)
+	require.Len(t, deprecations, 2)
+
+	migrated, err := Migrate(false)
+	require.NoError(t, err)
+	require.Len(t, migrated, 1)
+	assert.FileExists(t, "machines/a/butane.yaml.tmpl")
+
+	remaining, err := CheckDeprecations()
+	require.NoError(t, err)
+	require.Len(t, remaining, 1)
+	assert.True(t, remaining[0].ManualOnly())
+	assert.Equal(t, filepath.Join("machines", "b", "butane.yaml"), remaining[0].Path)
+}
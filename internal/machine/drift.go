@@ -0,0 +1,36 @@
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DriftExempted reports whether drift_exempt covers p: a file path matched by
+// a glob such as "/etc/pki/tls/*.pem", a file below a pattern ending in "/",
+// or a unit name such as "certbot.timer"
+func (c Config) DriftExempted(p string) bool {
+	for _, pattern := range c.DriftExempt {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(p, pattern) {
+			return true
+		}
+		if matched, _ := path.Match(pattern, p); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateDriftExempt checks every drift_exempt entry is a valid pattern
+func ValidateDriftExempt(c Config) error {
+	var errs []error
+	for _, pattern := range c.DriftExempt {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid drift_exempt pattern %q: %w", pattern, err))
+		} else if strings.Contains(pattern, "/") && !path.IsAbs(pattern) {
+			errs = append(errs, fmt.Errorf("drift_exempt path %q must be absolute", pattern))
+		}
+	}
+	return errors.Join(errs...)
+}
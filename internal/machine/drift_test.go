@@ -0,0 +1,26 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDriftExempted(t *testing.T) {
+	c := Config{DriftExempt: []string{"/etc/pki/tls/*.pem", "/var/lib/app/", "certbot.timer"}}
+
+	assert.True(t, c.DriftExempted("/etc/pki/tls/web.pem"))
+	assert.False(t, c.DriftExempted("/etc/pki/tls/private/web.key"), "globs don't cross directories")
+	assert.True(t, c.DriftExempted("/var/lib/app/state/cache.db"))
+	assert.True(t, c.DriftExempted("certbot.timer"))
+	assert.False(t, c.DriftExempted("/etc/hostname"))
+	assert.False(t, Config{}.DriftExempted("/etc/hostname"))
+}
+
+func TestValidateDriftExempt(t *testing.T) {
+	assert.NoError(t, ValidateDriftExempt(Config{DriftExempt: []string{"/etc/pki/*.pem", "/var/lib/app/", "certbot.timer"}}))
+
+	err := ValidateDriftExempt(Config{DriftExempt: []string{"etc/hosts", "/etc/[ab"}})
+	assert.ErrorContains(t, err, `"etc/hosts" must be absolute`)
+	assert.ErrorContains(t, err, `invalid drift_exempt pattern "/etc/[ab"`)
+}
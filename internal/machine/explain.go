@@ -0,0 +1,93 @@
+package machine
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Setting is a resolved configuration value and the file that set it
+type Setting struct {
+	Key    string // Dotted key; machine.toml keys are prefixed with "machine."
+	Value  interface{}
+	Source string
+}
+
+// Explain returns every value that applies to a machine, in key order, with
+// the layer it came from: defaults.toml, a defaults.d overlay, a site overlay,
+// a role overlay or machine.toml. Later layers win, so each key reports the last file setting it.
+func (cl *ConfigLoader) Explain(m Config) ([]Setting, error) {
+	settings := make(map[string]Setting)
+
+	layers, err := cl.layersFor(m)
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range layers {
+		if err := collectSettings(settings, "", layer.content, layer.path); err != nil {
+			return nil, err
+		}
+	}
+
+	if path, ok := cl.machinePaths[m.Name]; ok {
+		content, err := os.ReadFile(cl.path(path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if content, err = Interpolate(content, cl.envAllow); err != nil {
+			return nil, fmt.Errorf("failed to interpolate %s: %w", path, err)
+		}
+		if err := collectSettings(settings, "machine.", content, path); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]Setting, 0, len(settings))
+	for _, setting := range settings {
+		result = append(result, setting)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result, nil
+}
+
+// FormatValue renders a setting value in TOML-like syntax
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = FormatValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case []map[string]interface{}:
+		return fmt.Sprintf("[%d table(s)]", len(v))
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func collectSettings(settings map[string]Setting, prefix string, content []byte, source string) error {
+	var doc map[string]interface{}
+	if err := toml.Unmarshal(content, &doc); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", source, err)
+	}
+	flattenSettings(settings, prefix, doc, source)
+	return nil
+}
+
+func flattenSettings(settings map[string]Setting, prefix string, table map[string]interface{}, source string) {
+	for key, value := range table {
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenSettings(settings, prefix+key+".", nested, source)
+			continue
+		}
+		settings[prefix+key] = Setting{Key: prefix + key, Value: value, Source: source}
+	}
+}
@@ -0,0 +1,270 @@
+package machine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IncludeCacheDir holds the checkouts of git includes, one directory per include
+const IncludeCacheDir = ".iago/include"
+
+// IncludeConfig is another iago repository whose machines and containers are
+// overlaid, read-only, on this one. Its machines use this repository's
+// defaults, roles and sites.
+type IncludeConfig struct {
+	Name string `toml:"name" jsonschema:"required"`
+	Path string `toml:"path,omitempty"` // A checkout on disk, relative to the repository
+	Git  string `toml:"git,omitempty"`  // Repository URL, checked out in .iago/include/<name> by 'iago include sync'
+	Ref  string `toml:"ref,omitempty"`  // Branch, tag or commit of git (default the remote's default branch)
+}
+
+// Root returns the directory the include is read from
+func (i IncludeConfig) Root() string {
+	if i.Path != "" {
+		return i.Path
+	}
+	return filepath.Join(IncludeCacheDir, i.Name)
+}
+
+// ValidateIncludes checks every include has a unique name and exactly one of path or git
+func ValidateIncludes(includes []IncludeConfig) error {
+	seen := make(map[string]bool)
+	for _, include := range includes {
+		if include.Name == "" || include.Name != filepath.Base(include.Name) || strings.HasPrefix(include.Name, ".") {
+			return fmt.Errorf("invalid [[include]] name %q", include.Name)
+		}
+		if seen[include.Name] {
+			return fmt.Errorf("[[include]] %s is listed twice", include.Name)
+		}
+		seen[include.Name] = true
+		if (include.Path == "") == (include.Git == "") {
+			return fmt.Errorf("[[include]] %s needs exactly one of path or git", include.Name)
+		}
+		if include.Ref != "" && include.Git == "" {
+			return fmt.Errorf("[[include]] %s: ref only applies to git includes", include.Name)
+		}
+		if err := checkIncludeRef(include); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// includeRoot returns an include's directory below root, checking it exists
+func includeRoot(root string, include IncludeConfig) (string, error) {
+	dir := include.Root()
+	if root != "" {
+		dir = filepath.Join(root, dir)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		if include.Git != "" {
+			return "", fmt.Errorf("include %s isn't checked out in %s; run 'iago include sync'", include.Name, include.Root())
+		}
+		return "", fmt.Errorf("include %s: %s is not a directory", include.Name, include.Path)
+	}
+	return dir, nil
+}
+
+// containerDirs maps workload names to the directories they're built from,
+// in the repository at root and then its includes; the repository's own
+// containers shadow included ones of the same name. Paths are relative to root.
+func containerDirs(root string, includes []IncludeConfig) (map[string]string, error) {
+	dirs := make(map[string]string)
+	sources := []string{""}
+	for _, include := range includes {
+		if _, err := includeRoot(root, include); err != nil {
+			return nil, err
+		}
+		sources = append(sources, include.Root())
+	}
+	for _, source := range sources {
+		base := filepath.Join(source, ContainersDir)
+		entries, err := os.ReadDir(filepath.Join(root, base))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read %s: %w", base, err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || entry.Name() == "_shared" || dirs[entry.Name()] != "" {
+				continue
+			}
+			dirs[entry.Name()] = filepath.Join(base, entry.Name())
+		}
+	}
+	return dirs, nil
+}
+
+// ContainerDirs returns the container directory of every workload in the
+// repository and its includes, by workload name, below the repository the
+// defaults were loaded from
+func (d Defaults) ContainerDirs() (map[string]string, error) {
+	dirs, err := containerDirs(d.root, d.Include)
+	if err != nil {
+		return nil, err
+	}
+	for name, dir := range dirs {
+		dirs[name] = d.path(dir)
+	}
+	return dirs, nil
+}
+
+// ContainerNames returns the workloads of the repository and its includes, sorted
+func (d Defaults) ContainerNames() ([]string, error) {
+	dirs, err := d.ContainerDirs()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(dirs))
+	for name := range dirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ContainerDir returns the directory a workload is built from: containers/<name>
+// in the repository, or in the first include that has it
+func (d Defaults) ContainerDir(workload string) string {
+	if dirs, err := d.ContainerDirs(); err == nil && dirs[workload] != "" {
+		return dirs[workload]
+	}
+	return d.path(filepath.Join(ContainersDir, workload))
+}
+
+// path returns a repository path below the repository the defaults were
+// loaded from; absolute paths are returned as they are
+func (d Defaults) path(rel string) string {
+	if d.root == "" || filepath.IsAbs(rel) {
+		return rel
+	}
+	return filepath.Join(d.root, rel)
+}
+
+// loadIncludedMachines reads the machines of each include that the
+// repository doesn't define itself
+func (cl *ConfigLoader) loadIncludedMachines(machines []Config) ([]Config, error) {
+	defined := make(map[string]string)
+	for _, m := range machines {
+		defined[m.Name] = cl.machinePaths[m.Name]
+	}
+	for _, include := range cl.defaults.Include {
+		root, err := includeRoot(cl.root, include)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(filepath.Join(root, MachinesDir))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read machines of include %s: %w", include.Name, err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			dir := filepath.Join(include.Root(), MachinesDir, entry.Name())
+			machinePath := filepath.Join(dir, "machine.toml")
+			if _, err := os.Stat(cl.path(machinePath)); err != nil {
+				continue
+			}
+			m, err := cl.readMachine(machinePath)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := defined[m.Name]; ok {
+				// The repository's own machine of the same name shadows it
+				continue
+			}
+			m.Dir = dir
+			m.Include = include.Name
+			machines = append(machines, m)
+			defined[m.Name] = machinePath
+			cl.machinePaths[m.Name] = machinePath
+		}
+	}
+	return machines, nil
+}
+
+// checkNotIncluded refuses changes to a machine that comes from an include
+func (cl *ConfigLoader) checkNotIncluded(name string) error {
+	if m, err := cl.GetMachine(name); err == nil && m.Include != "" {
+		return fmt.Errorf("machine '%s' comes from include %s, which is read-only; change it in that repository or 'iago clone' it here", name, m.Include)
+	}
+	return nil
+}
+
+// SyncInclude clones a git include into IncludeCacheDir of the repository at
+// root, or fetches it, and checks out its ref. It returns the commit checked
+// out. Path includes are left alone.
+func SyncInclude(ctx context.Context, root string, include IncludeConfig) (string, error) {
+	if include.Git == "" {
+		return "", nil
+	}
+	if err := checkIncludeRef(include); err != nil {
+		return "", err
+	}
+	dir := include.Root()
+	if root != "" {
+		dir = filepath.Join(root, dir)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return "", fmt.Errorf("failed to create %s: %w", IncludeCacheDir, err)
+		}
+		if _, err := includeGit(ctx, "", "clone", "--quiet", "--", include.Git, dir); err != nil {
+			return "", err
+		}
+	} else if _, err := includeGit(ctx, dir, "fetch", "--quiet", "--tags", "origin"); err != nil {
+		return "", err
+	}
+
+	// A branch is checked out at its tip on the remote, not the local copy
+	ref := "origin/HEAD"
+	if include.Ref != "" {
+		ref = include.Ref
+		if _, err := includeGit(ctx, dir, "rev-parse", "--verify", "--quiet", "origin/"+ref); err == nil {
+			ref = "origin/" + ref
+		}
+	}
+	if _, err := includeGit(ctx, dir, "checkout", "--quiet", "--detach", ref, "--"); err != nil {
+		return "", err
+	}
+	commit, err := includeGit(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+// checkIncludeRef refuses a git URL or ref that git would read as an option
+func checkIncludeRef(include IncludeConfig) error {
+	if strings.HasPrefix(include.Git, "-") {
+		return fmt.Errorf("[[include]] %s: git %q starts with '-'", include.Name, include.Git)
+	}
+	if strings.HasPrefix(include.Ref, "-") {
+		return fmt.Errorf("[[include]] %s: ref %q starts with '-'", include.Name, include.Ref)
+	}
+	return nil
+}
+
+// includeGit runs git in dir and returns its trimmed output
+func includeGit(ctx context.Context, dir string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,138 @@
+package machine
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateIncludes(t *testing.T) {
+	assert.NoError(t, ValidateIncludes([]IncludeConfig{
+		{Name: "base", Path: "../base"},
+		{Name: "team", Git: "https://example.com/team.git", Ref: "v1"},
+	}))
+	assert.ErrorContains(t, ValidateIncludes([]IncludeConfig{{Name: "../x", Path: "x"}}), "invalid [[include]] name")
+	assert.ErrorContains(t, ValidateIncludes([]IncludeConfig{{Name: "a", Path: "a"}, {Name: "a", Path: "b"}}), "listed twice")
+	assert.ErrorContains(t, ValidateIncludes([]IncludeConfig{{Name: "a"}}), "exactly one of path or git")
+	assert.ErrorContains(t, ValidateIncludes([]IncludeConfig{{Name: "a", Path: "a", Git: "g"}}), "exactly one of path or git")
+	assert.ErrorContains(t, ValidateIncludes([]IncludeConfig{{Name: "a", Path: "a", Ref: "main"}}), "ref only applies")
+	assert.ErrorContains(t, ValidateIncludes([]IncludeConfig{{Name: "a", Git: "--upload-pack=x"}}), "starts with '-'")
+	assert.ErrorContains(t, ValidateIncludes([]IncludeConfig{{Name: "a", Git: "g", Ref: "-b"}}), "starts with '-'")
+}
+
+func TestConfigLoader_Include(t *testing.T) {
+	chdirTemp(t)
+	writeFiles(t, map[string]string{
+		"config/defaults.toml": `domain = "example.com"
+
+[[include]]
+name = "base"
+path = "base"
+`,
+		"machines/web/machine.toml":          "name = \"web\"\nfqdn = \"web.local\"\n",
+		"containers/web/Containerfile":       "FROM scratch\n",
+		"base/machines/web/machine.toml":     "name = \"web\"\nfqdn = \"web.base\"\n",
+		"base/machines/dns/machine.toml":     "name = \"dns\"\nfqdn = \"dns.example.com\"\n",
+		"base/machines/dns/butane.yaml.tmpl": "# dns server\nhostname: dns\n",
+		"base/containers/web/Containerfile":  "FROM scratch\n",
+		"base/containers/dns/Containerfile":  "FROM scratch\n",
+	})
+
+	loader := NewConfigLoader()
+	require.NoError(t, loader.LoadAll())
+
+	web, err := loader.GetMachine("web")
+	require.NoError(t, err)
+	assert.Equal(t, "web.local", web.FQDN, "the repository's machine shadows the included one")
+	assert.Empty(t, web.Include)
+
+	dns, err := loader.GetMachine("dns")
+	require.NoError(t, err)
+	assert.Equal(t, "base", dns.Include)
+	assert.Equal(t, filepath.Join("base", "machines", "dns"), dns.Directory())
+
+	dirs, err := loader.GetDefaults().ContainerDirs()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"web": filepath.Join("containers", "web"),
+		"dns": filepath.Join("base", "containers", "dns"),
+	}, dirs)
+
+	assert.ErrorContains(t, loader.RemoveMachine("dns"), "comes from include base")
+	assert.ErrorContains(t, loader.RenameMachine("dns", "ns"), "comes from include base")
+
+	edits, err := loader.PlanReplace(loader.GetMachines(), Replacement{Old: "dns", New: "ns"})
+	require.NoError(t, err)
+	assert.Empty(t, edits, "included machines aren't edited")
+
+	require.NoError(t, loader.CloneMachine("dns", "dns2"))
+	template, err := os.ReadFile("machines/dns2/butane.yaml.tmpl")
+	require.NoError(t, err)
+	assert.Equal(t, "# dns2 server\nhostname: dns2\n", string(template))
+	assert.FileExists(t, "base/machines/dns/machine.toml", "the include is left alone")
+}
+
+func TestConfigLoader_IncludeNotSynced(t *testing.T) {
+	chdirTemp(t)
+	writeFiles(t, map[string]string{
+		"config/defaults.toml": "[[include]]\nname = \"team\"\ngit = \"https://example.com/team.git\"\n",
+	})
+
+	err := NewConfigLoader().LoadAll()
+	assert.ErrorContains(t, err, "run 'iago include sync'")
+}
+
+func TestDefaults_ContainerDirsAtRoot(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"config", filepath.Join("containers", "web"), filepath.Join("base", "containers", "dns")} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(root, "config", "defaults.toml"), []byte("[[include]]\nname = \"base\"\npath = \"base\"\n"), 0644))
+
+	loader := NewConfigLoaderAt(root)
+	require.NoError(t, loader.LoadDefaults())
+	dirs, err := loader.GetDefaults().ContainerDirs()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"web": filepath.Join(root, "containers", "web"),
+		"dns": filepath.Join(root, "base", "containers", "dns"),
+	}, dirs)
+	assert.Equal(t, filepath.Join(root, "containers", "api"), loader.GetDefaults().ContainerDir("api"))
+}
+
+func TestSyncInclude(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	root := chdirTemp(t)
+	upstream := filepath.Join(root, "upstream")
+	writeFiles(t, map[string]string{
+		"upstream/machines/dns/machine.toml": "name = \"dns\"\n",
+	})
+	for _, args := range [][]string{
+		{"init", "--quiet", "--initial-branch=main"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "initial"},
+	} {
+		_, err := includeGit(context.Background(), upstream, args...)
+		require.NoError(t, err)
+	}
+
+	include := IncludeConfig{Name: "team", Git: upstream, Ref: "main"}
+	commit, err := SyncInclude(context.Background(), root, include)
+	require.NoError(t, err)
+	assert.Len(t, commit, 40)
+	assert.FileExists(t, filepath.Join(root, IncludeCacheDir, "team", "machines", "dns", "machine.toml"))
+
+	again, err := SyncInclude(context.Background(), root, include)
+	require.NoError(t, err)
+	assert.Equal(t, commit, again)
+
+	_, err = SyncInclude(context.Background(), root, IncludeConfig{Name: "team", Git: upstream, Ref: "--upload-pack=touch x"})
+	assert.ErrorContains(t, err, "starts with '-'")
+}
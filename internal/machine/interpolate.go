@@ -0,0 +1,115 @@
+package machine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// EnvPrefix marks environment variables that may always be interpolated
+const EnvPrefix = "IAGO_"
+
+// EnvConfig controls ${VAR} interpolation in defaults.toml and machine.toml
+type EnvConfig struct {
+	// Allow lists variables besides IAGO_* that configs may reference
+	Allow []string `toml:"allow"`
+}
+
+// envPattern matches ${VAR}, ${VAR:-default} and the $${ escape
+var envPattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// Interpolate replaces ${VAR} and ${VAR:-default} in the string values of a
+// TOML document with environment variables. Only IAGO_* and allowed variables
+// may be referenced; $${ produces a literal ${.
+func Interpolate(content []byte, allowed []string) ([]byte, error) {
+	if !bytes.Contains(content, []byte("${")) {
+		return content, nil
+	}
+
+	var doc map[string]interface{}
+	if err := toml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+
+	allow := make(map[string]bool, len(allowed))
+	for _, name := range allowed {
+		allow[name] = true
+	}
+
+	interpolated, err := interpolateValue(doc, allow)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(interpolated); err != nil {
+		return nil, fmt.Errorf("failed to encode interpolated config: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func interpolateValue(value interface{}, allow map[string]bool) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return interpolateString(v, allow)
+	case map[string]interface{}:
+		for key, item := range v {
+			result, err := interpolateValue(item, allow)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = result
+		}
+	case []map[string]interface{}:
+		for i, item := range v {
+			result, err := interpolateValue(item, allow)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = result.(map[string]interface{})
+		}
+	case []interface{}:
+		for i, item := range v {
+			result, err := interpolateValue(item, allow)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = result
+		}
+	}
+	return value, nil
+}
+
+func interpolateString(s string, allow map[string]bool) (string, error) {
+	var firstErr error
+	result := envPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+
+		groups := envPattern.FindStringSubmatch(match)
+		name, fallback := groups[1], groups[2]
+		if !strings.HasPrefix(name, EnvPrefix) && !allow[name] {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("environment variable %s is not allowed (use the %s prefix or add it to [env] allow in defaults.toml)", name, EnvPrefix)
+			}
+			return match
+		}
+
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			return value
+		}
+		if strings.Contains(match, ":-") {
+			return fallback
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("environment variable %s is not set and has no default (use ${%s:-default})", name, name)
+		}
+		return match
+	})
+	return result, firstErr
+}
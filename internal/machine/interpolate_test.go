@@ -0,0 +1,92 @@
+package machine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("IAGO_DOMAIN", "example.com")
+	t.Setenv("CI_REGISTRY", "registry.ci.example.com")
+	t.Setenv("IAGO_EMPTY", "")
+
+	content := []byte(`name = "web"
+fqdn = "web.${IAGO_DOMAIN}"
+container_image = "${CI_REGISTRY}/web"
+container_tag = "${IAGO_TAG:-latest}"
+network_interface = "${IAGO_EMPTY:-ens18}"
+snippets = ["$${NOT_INTERPOLATED}"]
+`)
+
+	result, err := Interpolate(content, []string{"CI_REGISTRY"})
+	require.NoError(t, err)
+
+	var config Config
+	require.NoError(t, toml.Unmarshal(result, &config))
+	assert.Equal(t, "web.example.com", config.FQDN)
+	assert.Equal(t, "registry.ci.example.com/web", config.ContainerImage)
+	assert.Equal(t, "latest", config.ContainerTag)
+	assert.Equal(t, "ens18", config.NetworkInterface)
+	assert.Equal(t, []string{"${NOT_INTERPOLATED}"}, config.Snippets)
+}
+
+func TestInterpolate_Errors(t *testing.T) {
+	t.Setenv("HOME_SECRET", "x")
+
+	_, err := Interpolate([]byte(`fqdn = "${HOME_SECRET}"`), nil)
+	assert.ErrorContains(t, err, "HOME_SECRET is not allowed")
+
+	_, err = Interpolate([]byte(`fqdn = "${IAGO_UNSET_FOR_TEST}"`), nil)
+	assert.ErrorContains(t, err, "IAGO_UNSET_FOR_TEST is not set")
+}
+
+func TestInterpolate_LeavesPlainContentUntouched(t *testing.T) {
+	content := []byte("# comment\npassword_hash = \"$6$salt$hash\"\n")
+
+	result, err := Interpolate(content, nil)
+	require.NoError(t, err)
+	assert.Equal(t, content, result)
+}
+
+func TestConfigLoader_InterpolatesConfigs(t *testing.T) {
+	tempDir := t.TempDir()
+	originalDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		os.Chdir(originalDir)
+	})
+
+	t.Setenv("CI_REGISTRY", "registry.ci.example.com")
+	t.Setenv("IAGO_DOMAIN", "ci.example.com")
+
+	files := map[string]string{
+		"config/defaults.toml": `[env]
+allow = ["CI_REGISTRY"]
+
+[container_registry]
+url = "${CI_REGISTRY}/iago"
+`,
+		"machines/web/machine.toml": `name = "web"
+fqdn = "web.${IAGO_DOMAIN}"
+`,
+	}
+	for path, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	loader := NewConfigLoader()
+	require.NoError(t, loader.LoadDefaults())
+	require.NoError(t, loader.LoadMachines())
+
+	assert.Equal(t, "registry.ci.example.com/iago", loader.GetDefaults().ContainerRegistry.URL)
+	web, err := loader.GetMachine("web")
+	require.NoError(t, err)
+	assert.Equal(t, "web.ci.example.com", web.FQDN)
+}
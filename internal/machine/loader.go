@@ -4,8 +4,23 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/BurntSushi/toml"
+	"github.com/andreweick/iago/internal/agecrypt"
+	"github.com/andreweick/iago/internal/tomledit"
+)
+
+const (
+	// DefaultsDir holds overlays merged in lexical order over defaults.toml
+	DefaultsDir = "config/defaults.d"
+	// RolesDir holds per-tag overlays, e.g. config/roles/edge.toml for machines tagged "edge"
+	RolesDir = "config/roles"
+	// SitesDir holds per-site overlays, e.g. config/sites/hetzner.toml for machines with site = "hetzner"
+	SitesDir = "config/sites"
 )
 
 // ErrMachineNotFound is returned when a machine cannot be found
@@ -15,6 +30,25 @@ type ConfigLoader struct {
 	defaults  Defaults
 	machines  MachineList
 	workloads WorkloadList
+
+	// defaultLayers are defaults.toml followed by defaults.d overlays and the
+	// environment's, kept so role overlays can be applied per machine on top of them
+	defaultLayers []defaultsLayer
+	roles         map[string]defaultsLayer
+	sites         map[string]defaultsLayer
+	envAllow      []string
+	machinePaths  map[string]string
+
+	// environment selects the config/defaults.<environment>.toml overlay
+	environment string
+
+	// root is the repository directory; "" is the working directory
+	root string
+}
+
+type defaultsLayer struct {
+	path    string
+	content []byte
 }
 
 type WorkloadList struct {
@@ -32,6 +66,96 @@ func NewConfigLoader() *ConfigLoader {
 	return &ConfigLoader{}
 }
 
+// NewConfigLoaderAt returns a loader that reads the repository at root rather
+// than the working directory. Paths in messages stay relative to root.
+func NewConfigLoaderAt(root string) *ConfigLoader {
+	return &ConfigLoader{root: root}
+}
+
+// Root returns the repository directory the loader reads; "" is the working
+// directory
+func (cl *ConfigLoader) Root() string {
+	return cl.root
+}
+
+// SetEnvironment selects an environment such as "staging" whose
+// config/defaults.<name>.toml is merged over the defaults; "" selects none
+func (cl *ConfigLoader) SetEnvironment(name string) {
+	cl.environment = name
+}
+
+// Environment returns the selected environment, or "" for none
+func (cl *ConfigLoader) Environment() string {
+	return cl.environment
+}
+
+// EnvironmentPath returns the overlay file of an environment
+func EnvironmentPath(name string) string {
+	return fmt.Sprintf("config/defaults.%s.toml", name)
+}
+
+// Environments returns the environments with an overlay in config/, sorted
+func (cl *ConfigLoader) Environments() []string {
+	paths, _ := filepath.Glob(cl.path("config/defaults.*.toml"))
+	var names []string
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "defaults."), ".toml")
+		if validEnvironment(name) == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// environmentName is what an environment may be called, keeping its overlay in config/
+var environmentName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// validEnvironment checks an environment name can't select another defaults file
+func validEnvironment(name string) error {
+	if name == "secret" || !environmentName.MatchString(name) {
+		return fmt.Errorf("invalid environment name '%s'", name)
+	}
+	return nil
+}
+
+// readEnvironmentDefaults reads the selected environment's overlay
+func (cl *ConfigLoader) readEnvironmentDefaults() (defaultsLayer, error) {
+	if err := validEnvironment(cl.environment); err != nil {
+		return defaultsLayer{}, err
+	}
+	path := EnvironmentPath(cl.environment)
+	content, err := readConfigFile(cl.path(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			available := strings.Join(cl.Environments(), ", ")
+			if available == "" {
+				available = "none"
+			}
+			return defaultsLayer{}, fmt.Errorf("unknown environment '%s' (no %s; available: %s)", cl.environment, path, available)
+		}
+		return defaultsLayer{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if content, err = Interpolate(content, cl.envAllow); err != nil {
+		return defaultsLayer{}, fmt.Errorf("failed to interpolate %s: %w", path, err)
+	}
+	return defaultsLayer{path: path, content: content}, nil
+}
+
+// path returns a repository path below the loader's root; absolute paths,
+// such as an include's path, are returned as they are
+func (cl *ConfigLoader) path(rel string) string {
+	if cl.root == "" || filepath.IsAbs(rel) {
+		return rel
+	}
+	return filepath.Join(cl.root, rel)
+}
+
+// MachineDir returns the machine's directory below the loader's root
+func (cl *ConfigLoader) MachineDir(m Config) string {
+	return cl.path(m.Directory())
+}
+
 func (cl *ConfigLoader) LoadAll() error {
 	if err := cl.LoadDefaults(); err != nil {
 		return fmt.Errorf("failed to load defaults: %w", err)
@@ -49,25 +173,258 @@ func (cl *ConfigLoader) LoadAll() error {
 }
 
 func (cl *ConfigLoader) LoadDefaults() error {
-	content, err := os.ReadFile("config/defaults.toml")
+	content, err := readConfigFile(cl.path("config/defaults.toml"))
 	if err != nil {
 		return fmt.Errorf("failed to read defaults.toml: %w", err)
 	}
 
-	if err := toml.Unmarshal(content, &cl.defaults); err != nil {
+	// The interpolation allowlist itself comes from defaults.toml, uninterpolated
+	var env struct {
+		Env EnvConfig `toml:"env"`
+	}
+	if err := toml.Unmarshal(content, &env); err != nil {
 		return fmt.Errorf("failed to parse defaults.toml: %w", err)
 	}
+	cl.envAllow = env.Env.Allow
+
+	if content, err = Interpolate(content, cl.envAllow); err != nil {
+		return fmt.Errorf("failed to interpolate defaults.toml: %w", err)
+	}
+	layers := []defaultsLayer{{path: "config/defaults.toml", content: content}}
+
+	secret, err := cl.readSecretDefaults()
+	if err != nil {
+		return err
+	}
+	if secret != nil {
+		layers = append(layers, *secret)
+	}
+
+	overlays, err := cl.readTOMLDir(DefaultsDir)
+	if err != nil {
+		return err
+	}
+	layers = append(layers, overlays...)
+
+	if cl.environment != "" {
+		layer, err := cl.readEnvironmentDefaults()
+		if err != nil {
+			return err
+		}
+		layers = append(layers, layer)
+	}
+
+	roleLayers, err := cl.readTOMLDir(RolesDir)
+	if err != nil {
+		return err
+	}
+	roles := make(map[string]defaultsLayer, len(roleLayers))
+	for _, layer := range roleLayers {
+		roles[strings.TrimSuffix(filepath.Base(layer.path), ".toml")] = layer
+	}
+
+	siteLayers, err := cl.readTOMLDir(SitesDir)
+	if err != nil {
+		return err
+	}
+	sites := make(map[string]defaultsLayer, len(siteLayers))
+	for _, layer := range siteLayers {
+		sites[strings.TrimSuffix(filepath.Base(layer.path), ".toml")] = layer
+	}
+
+	defaults, err := decodeLayers(layers)
+	if err != nil {
+		return err
+	}
+	if err := ValidateIncludes(defaults.Include); err != nil {
+		return err
+	}
+	// Parse site and role overlays up front so mistakes surface on every command
+	for _, layer := range append(siteLayers, roleLayers...) {
+		if _, err := decodeLayers(append(layers[:len(layers):len(layers)], layer)); err != nil {
+			return err
+		}
+	}
 
+	defaults.root = cl.root
+	cl.defaults = defaults
+	cl.defaultLayers = layers
+	cl.roles = roles
+	cl.sites = sites
 	return nil
 }
 
+// DefaultsFor returns the defaults for a machine: defaults.toml, then
+// defaults.d overlays, then the environment's overlay, then its site overlay,
+// then the role overlay of each of its tags in order
+func (cl *ConfigLoader) DefaultsFor(m Config) (Defaults, error) {
+	layers, err := cl.layersFor(m)
+	if err != nil {
+		return Defaults{}, err
+	}
+	if len(layers) == len(cl.defaultLayers) {
+		return cl.defaults, nil
+	}
+	return decodeLayers(layers)
+}
+
+// layersFor returns the defaults layers that apply to a machine, in merge order
+func (cl *ConfigLoader) layersFor(m Config) ([]defaultsLayer, error) {
+	layers := cl.defaultLayers
+	if m.Site != "" && cl.defaultLayers != nil {
+		site, ok := cl.sites[m.Site]
+		if !ok {
+			return nil, fmt.Errorf("machine '%s': unknown site '%s' (no %s/%s.toml)", m.Name, m.Site, SitesDir, m.Site)
+		}
+		layers = append(layers[:len(layers):len(layers)], site)
+	}
+	for _, tag := range m.Tags {
+		if role, ok := cl.roles[tag]; ok {
+			layers = append(layers[:len(layers):len(layers)], role)
+		}
+	}
+	return layers, nil
+}
+
+// Sites returns the names of the site overlays in config/sites, sorted
+func (cl *ConfigLoader) Sites() []string {
+	names := make([]string, 0, len(cl.sites))
+	for name := range cl.sites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MachinesInSite returns the machines with the given site
+func (cl *ConfigLoader) MachinesInSite(site string) []Config {
+	return cl.SelectMachines(Selector{Site: site})
+}
+
+// Selector picks machines by site, group and tags. Empty fields match every machine.
+type Selector struct {
+	Site  string
+	Group string
+	Tags  []string // Machines must carry all of them
+}
+
+// IsZero reports whether the selector matches every machine
+func (s Selector) IsZero() bool {
+	return s.Site == "" && s.Group == "" && len(s.Tags) == 0
+}
+
+// Matches reports whether m is selected
+func (s Selector) Matches(m Config) bool {
+	if s.Site != "" && m.Site != s.Site {
+		return false
+	}
+	if s.Group != "" && m.Group != s.Group {
+		return false
+	}
+	for _, tag := range s.Tags {
+		if !m.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// String describes the selector for messages, e.g. "--site hetzner --tag dns"
+func (s Selector) String() string {
+	var parts []string
+	if s.Site != "" {
+		parts = append(parts, "--site "+s.Site)
+	}
+	if s.Group != "" {
+		parts = append(parts, "--group "+s.Group)
+	}
+	for _, tag := range s.Tags {
+		parts = append(parts, "--tag "+tag)
+	}
+	return strings.Join(parts, " ")
+}
+
+// SelectMachines returns the machines s matches
+func (cl *ConfigLoader) SelectMachines(s Selector) []Config {
+	var machines []Config
+	for _, m := range cl.machines.Machines {
+		if s.Matches(m) {
+			machines = append(machines, m)
+		}
+	}
+	return machines
+}
+
+// decodeLayers decodes each layer over the previous ones; keys present in a
+// later layer replace earlier values, tables are merged key by key
+func decodeLayers(layers []defaultsLayer) (Defaults, error) {
+	var defaults Defaults
+	for _, layer := range layers {
+		if err := decodeStrict(layer.path, layer.content, &defaults); err != nil {
+			return Defaults{}, err
+		}
+	}
+	return defaults, nil
+}
+
+// readSecretDefaults reads defaults.secret.toml, or returns nil when there is none
+func (cl *ConfigLoader) readSecretDefaults() (*defaultsLayer, error) {
+	raw, err := os.ReadFile(cl.path(DefaultsSecretPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", DefaultsSecretPath, err)
+	}
+
+	content := raw
+	if IsSOPSEncrypted(raw) {
+		if content, err = decryptSOPS(cl.path(DefaultsSecretPath)); err != nil {
+			return nil, err
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: %s is not encrypted; run 'sops --encrypt --in-place %s' before committing it\n", DefaultsSecretPath, DefaultsSecretPath)
+	}
+
+	if content, err = Interpolate(content, cl.envAllow); err != nil {
+		return nil, fmt.Errorf("failed to interpolate %s: %w", DefaultsSecretPath, err)
+	}
+	return &defaultsLayer{path: DefaultsSecretPath, content: content}, nil
+}
+
+// readTOMLDir reads, decrypts and interpolates the *.toml files in dir in
+// lexical order; a missing dir is empty
+func (cl *ConfigLoader) readTOMLDir(dir string) ([]defaultsLayer, error) {
+	paths, err := filepath.Glob(filepath.Join(cl.path(dir), "*.toml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	sort.Strings(paths)
+
+	layers := make([]defaultsLayer, 0, len(paths))
+	for _, path := range paths {
+		content, err := readConfigFile(path)
+		path = filepath.Join(dir, filepath.Base(path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if content, err = Interpolate(content, cl.envAllow); err != nil {
+			return nil, fmt.Errorf("failed to interpolate %s: %w", path, err)
+		}
+		layers = append(layers, defaultsLayer{path: path, content: content})
+	}
+	return layers, nil
+}
+
 func (cl *ConfigLoader) LoadMachines() error {
+	WarnDeprecations()
+
 	// Load from machines directory structure
 	return cl.loadMachinesFromMachineDirs()
 }
 
 func (cl *ConfigLoader) loadMachinesFromMachineDirs() error {
-	machineDirs, err := os.ReadDir("machines")
+	machineDirs, err := os.ReadDir(cl.path("machines"))
 	if err != nil {
 		if os.IsNotExist(err) {
 			cl.machines.Machines = []Config{}
@@ -77,6 +434,7 @@ func (cl *ConfigLoader) loadMachinesFromMachineDirs() error {
 	}
 
 	var machines []Config
+	cl.machinePaths = make(map[string]string)
 	for _, dir := range machineDirs {
 		if !dir.IsDir() {
 			continue
@@ -84,50 +442,81 @@ func (cl *ConfigLoader) loadMachinesFromMachineDirs() error {
 
 		// Read machine.toml from machines directory
 		machinePath := fmt.Sprintf("machines/%s/machine.toml", dir.Name())
-		content, err := os.ReadFile(machinePath)
-		if err != nil {
+		if _, err := os.Stat(cl.path(machinePath)); err != nil {
 			continue // Skip directories without machine.toml
 		}
-
-		var machine Config
-		if err := toml.Unmarshal(content, &machine); err != nil {
-			return fmt.Errorf("failed to parse %s: %w", machinePath, err)
+		machine, err := cl.readMachine(machinePath)
+		if err != nil {
+			return err
 		}
 		machines = append(machines, machine)
+		cl.machinePaths[machine.Name] = machinePath
 	}
 
+	machines, err = cl.loadIncludedMachines(machines)
+	if err != nil {
+		return err
+	}
 	cl.machines.Machines = machines
 	return nil
 }
 
+// readMachine reads and interpolates a machine.toml, filling in the FQDN
+// from the machine's site or defaults when it has none
+func (cl *ConfigLoader) readMachine(machinePath string) (Config, error) {
+	content, err := os.ReadFile(cl.path(machinePath))
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read %s: %w", machinePath, err)
+	}
+	if content, err = Interpolate(content, cl.envAllow); err != nil {
+		return Config{}, fmt.Errorf("failed to interpolate %s: %w", machinePath, err)
+	}
+
+	var machine Config
+	if err := decodeStrict(machinePath, content, &machine); err != nil {
+		return Config{}, err
+	}
+	if _, err := cl.layersFor(machine); err != nil {
+		return Config{}, err
+	}
+	if machine.FQDN == "" {
+		// Machines without an fqdn inherit <name>.<domain> from their site or defaults
+		defaults, err := cl.DefaultsFor(machine)
+		if err != nil {
+			return Config{}, err
+		}
+		if defaults.Domain != "" {
+			machine.FQDN = machine.Name + "." + defaults.Domain
+		}
+	}
+	return machine, nil
+}
+
 func (cl *ConfigLoader) LoadWorkloads() error {
 	// Load from containers directory structure
 	return cl.loadWorkloadsFromContainerDirs()
 }
 
 func (cl *ConfigLoader) loadWorkloadsFromContainerDirs() error {
-	containerDirs, err := os.ReadDir("containers")
+	dirs, err := containerDirs(cl.root, cl.defaults.Include)
 	if err != nil {
-		if os.IsNotExist(err) {
-			cl.workloads.Workloads = []WorkloadDefinition{}
-			return nil
-		}
-		return fmt.Errorf("failed to read containers directory: %w", err)
+		return err
 	}
+	names := make([]string, 0, len(dirs))
+	for name := range dirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	var workloads []WorkloadDefinition
-	for _, dir := range containerDirs {
-		if !dir.IsDir() || dir.Name() == "_shared" {
-			continue
-		}
-
+	workloads := []WorkloadDefinition{}
+	for _, name := range names {
 		// For now, create workload definitions based on container directories
 		// In the future, we might want to read metadata from the container
 		workload := WorkloadDefinition{
-			Name:           dir.Name(),
-			ContainerImage: fmt.Sprintf("%s/%s", cl.defaults.ContainerRegistry.URL, dir.Name()),
+			Name:           name,
+			ContainerImage: fmt.Sprintf("%s/%s", cl.defaults.ContainerRegistry.URL, name),
 			ContainerTag:   "latest",
-			BootcSource:    fmt.Sprintf("containers/%s/", dir.Name()),
+			BootcSource:    filepath.ToSlash(dirs[name]) + "/",
 		}
 		workloads = append(workloads, workload)
 	}
@@ -148,6 +537,20 @@ func (cl *ConfigLoader) GetWorkloads() []WorkloadDefinition {
 	return cl.workloads.Workloads
 }
 
+// WorkloadConsumers returns the machines, sorted by name, whose container_image
+// is the image built from containers/<workload>
+func (cl *ConfigLoader) WorkloadConsumers(workload string) []string {
+	image := fmt.Sprintf("%s/%s", cl.defaults.ContainerRegistry.URL, workload)
+	var names []string
+	for _, m := range cl.machines.Machines {
+		if m.ContainerImage == image {
+			names = append(names, m.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (cl *ConfigLoader) GetMachine(name string) (Config, error) {
 	for _, machine := range cl.machines.Machines {
 		if machine.Name == name {
@@ -157,52 +560,231 @@ func (cl *ConfigLoader) GetMachine(name string) (Config, error) {
 	return Config{}, fmt.Errorf("machine '%s': %w", name, ErrMachineNotFound)
 }
 
+// RemoveMachine deletes a machine's directory. A leftover entry for it in the
+// legacy single-file machine list is removed too, keeping the rest of that
+// file as written, so `iago migrate` can't bring the machine back.
 func (cl *ConfigLoader) RemoveMachine(name string) error {
-	// Remove from new machine directory structure
-	machineDir := fmt.Sprintf("machines/%s", name)
-	if _, err := os.Stat(machineDir); os.IsNotExist(err) {
-		return fmt.Errorf("machine '%s': %w", name, ErrMachineNotFound)
+	if err := cl.checkNotIncluded(name); err != nil {
+		return err
 	}
+	machineDir := cl.path(filepath.Join(MachinesDir, name))
+	_, err := os.Stat(machineDir)
+	found := err == nil
 
-	// Remove the entire machine directory
-	if err := os.RemoveAll(machineDir); err != nil {
-		return fmt.Errorf("failed to remove machine directory: %w", err)
+	if found {
+		if err := os.RemoveAll(machineDir); err != nil {
+			return fmt.Errorf("failed to remove machine directory: %w", err)
+		}
+	}
+
+	removed, err := removeLegacyMachine(cl.path(LegacyMachinesFile), name)
+	if err != nil {
+		return err
+	}
+	if !found && !removed {
+		return fmt.Errorf("machine '%s': %w", name, ErrMachineNotFound)
 	}
 
 	// Reload machines list
 	return cl.LoadMachines()
 }
 
-func (cl *ConfigLoader) removeMachineFromOldStructure(name string) error {
-	// Find and remove the machine
-	newMachines := make([]Config, 0, len(cl.machines.Machines))
-	found := false
+// RenameMachine moves machines/<oldName> to machines/<newName> and rewrites its
+// machine.toml: the name, an fqdn whose first label is the old name, and a
+// container_image whose last path element is the old name
+func (cl *ConfigLoader) RenameMachine(oldName, newName string) error {
+	if err := cl.checkNotIncluded(oldName); err != nil {
+		return err
+	}
+	oldDir, newDir, err := cl.machineDirs(filepath.Join(MachinesDir, oldName), oldName, newName)
+	if err != nil {
+		return err
+	}
 
-	for _, machine := range cl.machines.Machines {
-		if machine.Name != name {
-			newMachines = append(newMachines, machine)
-		} else {
-			found = true
+	// Move first and rewrite the moved file, so a failure leaves the machine
+	// as it was rather than half renamed
+	if err := os.Rename(oldDir, newDir); err != nil {
+		return fmt.Errorf("failed to move machine directory: %w", err)
+	}
+	if err := renameConfig(filepath.Join(newDir, "machine.toml"), oldName, newName); err != nil {
+		if undo := os.Rename(newDir, oldDir); undo != nil {
+			return fmt.Errorf("%w (and failed to move %s back: %v)", err, newDir, undo)
 		}
+		return err
 	}
 
-	if !found {
-		return fmt.Errorf("machine '%s': %w", name, ErrMachineNotFound)
+	// Reload machines list
+	return cl.LoadMachines()
+}
+
+// renameConfig rewrites the name fields of the machine.toml at machinePath
+func renameConfig(machinePath, oldName, newName string) error {
+	doc, err := tomledit.Load(machinePath)
+	if err != nil {
+		return err
+	}
+	if err := renameFields(doc, oldName, newName); err != nil {
+		return err
+	}
+	return doc.Save(machinePath)
+}
+
+// CloneMachine copies machines/<source> to machines/<newName>, rewriting the
+// name fields of machine.toml as RenameMachine does, giving the copy a new MAC
+// address if the source has one, and replacing the source's name wherever it
+// appears as a whole word in butane.yaml.tmpl. The source's recipients.txt is
+// left out, so the copy needs keys of its own. Cloning a machine that comes
+// from an include copies it into the repository.
+func (cl *ConfigLoader) CloneMachine(source, newName string) error {
+	sourceDir := filepath.Join(MachinesDir, source)
+	if m, err := cl.GetMachine(source); err == nil && m.Include != "" {
+		sourceDir = m.Directory()
+	}
+	sourceDir, newDir, err := cl.machineDirs(sourceDir, source, newName)
+	if err != nil {
+		return err
+	}
+
+	if err := copyMachineDir(newDir, sourceDir); err != nil {
+		os.RemoveAll(newDir)
+		return err
+	}
+	if err := cloneConfig(newDir, source, newName); err != nil {
+		os.RemoveAll(newDir)
+		return err
 	}
 
-	cl.machines.Machines = newMachines
+	// Reload machines list
+	return cl.LoadMachines()
+}
+
+// copyMachineDir copies a machine directory to dst without its age
+// recipients: secrets encrypted for the copy must not open with the source's key
+func copyMachineDir(dst, src string) error {
+	if err := os.CopyFS(dst, os.DirFS(src)); err != nil {
+		return fmt.Errorf("failed to copy machine directory: %w", err)
+	}
+	if err := os.Remove(filepath.Join(dst, agecrypt.RecipientsFile)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove the copied recipients: %w", err)
+	}
+	return nil
+}
 
-	// Write back to file
-	file, err := os.Create("config/machines.toml")
+// cloneConfig rewrites the copied machine.toml and butane template in dir
+func cloneConfig(dir, source, newName string) error {
+	machinePath := filepath.Join(dir, "machine.toml")
+	doc, err := tomledit.Load(machinePath)
 	if err != nil {
-		return fmt.Errorf("failed to open machines.toml for writing: %w", err)
+		return err
+	}
+	if err := renameFields(doc, source, newName); err != nil {
+		return err
+	}
+	if _, ok := doc.Get("mac_address"); ok {
+		mac, err := GenerateMAC(DefaultMACPrefix)
+		if err != nil {
+			return fmt.Errorf("failed to generate MAC address: %w", err)
+		}
+		if err := doc.Set("mac_address", mac); err != nil {
+			return err
+		}
 	}
-	defer file.Close()
+	if err := doc.Save(machinePath); err != nil {
+		return err
+	}
+
+	templatePath := filepath.Join(dir, "butane.yaml.tmpl")
+	content, err := os.ReadFile(templatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read %s: %w", templatePath, err)
+	}
+	replaced := replaceName(string(content), source, newName)
+	if err := os.WriteFile(templatePath, []byte(replaced), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", templatePath, err)
+	}
+	return nil
+}
+
+// nameToken matches the words a machine name can be made of
+var nameToken = regexp.MustCompile(`[A-Za-z0-9_-]+`)
 
-	encoder := toml.NewEncoder(file)
-	if err := encoder.Encode(cl.machines); err != nil {
-		return fmt.Errorf("failed to write machines.toml: %w", err)
+// replaceName replaces oldName with newName where it appears as a whole word,
+// so renaming "web" leaves "web-cache" and "webhook" alone
+func replaceName(content, oldName, newName string) string {
+	return nameToken.ReplaceAllStringFunc(content, func(word string) string {
+		if word == oldName {
+			return newName
+		}
+		return word
+	})
+}
+
+// machineDirs checks that machine name exists in the repository directory dir
+// and newName is free, and returns the paths of dir and the new machine's
+// directory below the loader's root
+func (cl *ConfigLoader) machineDirs(dir, name, newName string) (string, string, error) {
+	if newName == "" || newName != filepath.Base(newName) || strings.Contains(newName, "..") || strings.HasPrefix(newName, ".") {
+		return "", "", fmt.Errorf("invalid machine name %q", newName)
+	}
+	dir, newDir := cl.path(dir), cl.path(filepath.Join(MachinesDir, newName))
+	if _, err := os.Stat(filepath.Join(dir, "machine.toml")); err != nil {
+		return "", "", fmt.Errorf("machine '%s': %w", name, ErrMachineNotFound)
 	}
+	if _, err := os.Stat(newDir); err == nil {
+		return "", "", fmt.Errorf("machine '%s' already exists", newName)
+	}
+	return dir, newDir, nil
+}
 
+// renameFields points name, fqdn and container_image at newName
+func renameFields(doc *tomledit.Document, oldName, newName string) error {
+	if err := doc.Set("name", newName); err != nil {
+		return err
+	}
+	if fqdn, ok := doc.Get("fqdn"); ok {
+		if rest, found := strings.CutPrefix(fmt.Sprint(fqdn), oldName+"."); found {
+			if err := doc.Set("fqdn", newName+"."+rest); err != nil {
+				return err
+			}
+		}
+	}
+	if image, ok := doc.Get("container_image"); ok {
+		if repo, found := strings.CutSuffix(fmt.Sprint(image), "/"+oldName); found {
+			if err := doc.Set("container_image", repo+"/"+newName); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
+
+// removeLegacyMachine drops name's [[machines]] entry from the legacy machine
+// list at path
+func removeLegacyMachine(path, name string) (bool, error) {
+	doc, err := tomledit.Load(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	entries, err := doc.Entries("machines")
+	if err != nil {
+		return false, fmt.Errorf("failed to parse %s: %w", LegacyMachinesFile, err)
+	}
+	for i, entry := range entries {
+		var m Config
+		if err := entry.Decode(&m); err != nil || m.Name != name {
+			continue
+		}
+		if err := doc.RemoveEntry("machines", i); err != nil {
+			return false, fmt.Errorf("failed to remove %s from %s: %w", name, LegacyMachinesFile, err)
+		}
+		if err := doc.Save(path); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
@@ -234,3 +234,403 @@ container_tag = "latest"
 		})
 	}
 }
+
+func TestConfigLoader_RemoveMachine_LegacyEntry(t *testing.T) {
+	chdirTemp(t)
+	writeFiles(t, map[string]string{
+		LegacyMachinesFile: `# Old machine list
+
+[[machines]]
+name = "web" # front end
+
+# Retired soon
+[[machines]]
+name = "old"
+
+[machines.network]
+ip = "10.0.0.9"
+`,
+		"machines/web/machine.toml": "name = \"web\"\n",
+	})
+
+	loader := NewConfigLoader()
+	require.NoError(t, loader.RemoveMachine("old"), "a machine only in the legacy file can be removed")
+	require.NoError(t, loader.RemoveMachine("web"))
+
+	content, err := os.ReadFile(LegacyMachinesFile)
+	require.NoError(t, err)
+	assert.Equal(t, "# Old machine list\n", string(content))
+	assert.NoDirExists(t, "machines/web")
+
+	assert.ErrorIs(t, loader.RemoveMachine("web"), ErrMachineNotFound)
+}
+
+func TestConfigLoader_RenameMachine(t *testing.T) {
+	chdirTemp(t)
+	writeFiles(t, map[string]string{
+		"machines/web/machine.toml": `name = "web" # front end
+fqdn = "web.lab.example.com"
+container_image = "ghcr.io/example/web"
+tags = ["web"]
+`,
+		"machines/web/butane.yaml.tmpl": "variant: fcos\n",
+		"machines/db/machine.toml":      "name = \"db\"\n",
+	})
+
+	loader := NewConfigLoader()
+	require.NoError(t, loader.RenameMachine("web", "www"))
+
+	content, err := os.ReadFile("machines/www/machine.toml")
+	require.NoError(t, err)
+	assert.Equal(t, `name = "www" # front end
+fqdn = "www.lab.example.com"
+container_image = "ghcr.io/example/www"
+tags = ["web"]
+`, string(content))
+	assert.FileExists(t, "machines/www/butane.yaml.tmpl")
+	assert.NoDirExists(t, "machines/web")
+	_, err = loader.GetMachine("www")
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, loader.RenameMachine("web", "app"), ErrMachineNotFound)
+	assert.ErrorContains(t, loader.RenameMachine("www", "db"), "already exists")
+	assert.ErrorContains(t, loader.RenameMachine("www", "../db"), "invalid machine name")
+
+	writeFiles(t, map[string]string{"machines/bad/machine.toml": "name = \n"})
+	assert.Error(t, loader.RenameMachine("bad", "good"))
+	assert.FileExists(t, "machines/bad/machine.toml", "a failed rename leaves the machine where it was")
+	assert.NoDirExists(t, "machines/good")
+}
+
+func TestConfigLoader_MachineChangesAtRoot(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "machines", "web"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "machines", "web", "machine.toml"), []byte("name = \"web\"\n"), 0644))
+
+	loader := NewConfigLoaderAt(root)
+	require.NoError(t, loader.RenameMachine("web", "www"))
+	assert.FileExists(t, filepath.Join(root, "machines", "www", "machine.toml"))
+	require.NoError(t, loader.CloneMachine("www", "api"))
+	assert.FileExists(t, filepath.Join(root, "machines", "api", "machine.toml"))
+	require.NoError(t, loader.RemoveMachine("www"))
+	assert.NoDirExists(t, filepath.Join(root, "machines", "www"))
+	assert.NoDirExists(t, "machines", "nothing is written below the working directory")
+}
+
+func TestConfigLoader_CloneMachine(t *testing.T) {
+	chdirTemp(t)
+	writeFiles(t, map[string]string{
+		"machines/web/machine.toml": `name = "web"
+fqdn = "web.lab.example.com"
+mac_address = "02:05:56:00:00:01"
+`,
+		"machines/web/butane.yaml.tmpl":  "# web server\nhostname: web\ncache: web-cache\n",
+		"machines/web/units/web.service": "[Service]\n",
+		"machines/web/recipients.txt":    "age1web\n",
+	})
+
+	loader := NewConfigLoader()
+	require.NoError(t, loader.CloneMachine("web", "api"))
+
+	m, err := loader.GetMachine("api")
+	require.NoError(t, err)
+	assert.Equal(t, "api.lab.example.com", m.FQDN)
+	assert.True(t, ValidateMAC(m.MACAddress))
+	assert.NotEqual(t, "02:05:56:00:00:01", m.MACAddress)
+
+	template, err := os.ReadFile("machines/api/butane.yaml.tmpl")
+	require.NoError(t, err)
+	assert.Equal(t, "# api server\nhostname: api\ncache: web-cache\n", string(template))
+	assert.FileExists(t, "machines/api/units/web.service")
+	assert.NoFileExists(t, "machines/api/recipients.txt", "the clone needs keys of its own")
+	assert.FileExists(t, "machines/web/recipients.txt")
+	_, err = loader.GetMachine("web")
+	assert.NoError(t, err, "the source is kept")
+
+	assert.ErrorContains(t, loader.CloneMachine("web", "api"), "already exists")
+	assert.ErrorIs(t, loader.CloneMachine("db", "db2"), ErrMachineNotFound)
+}
+
+func TestConfigLoader_WorkloadConsumers(t *testing.T) {
+	chdirTemp(t)
+	writeFiles(t, map[string]string{
+		"config/defaults.toml":        "[container_registry]\nurl = \"ghcr.io/me\"\n",
+		"machines/web-2/machine.toml": "name = \"web-2\"\ncontainer_image = \"ghcr.io/me/web\"\n",
+		"machines/web-1/machine.toml": "name = \"web-1\"\ncontainer_image = \"ghcr.io/me/web\"\n",
+		"machines/db/machine.toml":    "name = \"db\"\ncontainer_image = \"ghcr.io/me/db\"\n",
+	})
+
+	loader := NewConfigLoader()
+	require.NoError(t, loader.LoadAll())
+	assert.Equal(t, []string{"web-1", "web-2"}, loader.WorkloadConsumers("web"))
+	assert.Empty(t, loader.WorkloadConsumers("cache"))
+}
+
+func TestConfigLoader_DefaultsLayering(t *testing.T) {
+	tempDir := t.TempDir()
+	originalDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		os.Chdir(originalDir)
+	})
+
+	files := map[string]string{
+		"config/defaults.toml": `[user]
+username = "core"
+groups = ["wheel"]
+
+[network]
+timezone = "UTC"
+dns_servers = ["1.1.1.1"]
+`,
+		"config/defaults.d/20-site.toml": `[network]
+timezone = "America/New_York"
+`,
+		"config/defaults.d/10-base.toml": `[network]
+timezone = "Europe/London"
+dns_servers = ["9.9.9.9"]
+`,
+		"config/roles/edge.toml": `[network]
+dns_servers = ["10.0.0.53"]
+`,
+		"config/roles/dmz.toml": `[user]
+groups = []
+`,
+	}
+	for path, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	loader := NewConfigLoader()
+	require.NoError(t, loader.LoadDefaults())
+
+	defaults := loader.GetDefaults()
+	assert.Equal(t, "core", defaults.User.Username)
+	assert.Equal(t, "America/New_York", defaults.Network.Timezone, "defaults.d overlays apply in lexical order")
+	assert.Equal(t, []string{"9.9.9.9"}, defaults.Network.DNSServers)
+
+	edge, err := loader.DefaultsFor(Config{Name: "proxy", Tags: []string{"edge", "dmz", "unknown"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.53"}, edge.Network.DNSServers)
+	assert.Equal(t, "America/New_York", edge.Network.Timezone, "role overlays keep unset keys")
+	assert.Empty(t, edge.User.Groups)
+	assert.Equal(t, []string{"wheel"}, loader.GetDefaults().User.Groups, "role overlays must not leak into global defaults")
+
+	plain, err := loader.DefaultsFor(Config{Name: "db"})
+	require.NoError(t, err)
+	assert.Equal(t, defaults, plain)
+
+	require.NoError(t, os.WriteFile("config/roles/broken.toml", []byte("[network"), 0644))
+	assert.ErrorContains(t, NewConfigLoader().LoadDefaults(), "broken.toml")
+}
+
+func TestConfigLoader_Explain(t *testing.T) {
+	chdirTemp(t)
+	writeFiles(t, map[string]string{
+		"config/defaults.toml": `[user]
+username = "core"
+groups = ["wheel"]
+
+[network]
+timezone = "UTC"
+`,
+		"config/defaults.d/10-site.toml": "[network]\ntimezone = \"America/New_York\"\n",
+		"config/roles/edge.toml":         "[user]\ngroups = [\"edge\"]\n",
+		"machines/proxy/machine.toml":    "name = \"proxy\"\nfqdn = \"proxy.example.com\"\ntags = [\"edge\"]\n",
+	})
+
+	loader := NewConfigLoader()
+	require.NoError(t, loader.LoadDefaults())
+	require.NoError(t, loader.LoadMachines())
+	m, err := loader.GetMachine("proxy")
+	require.NoError(t, err)
+
+	settings, err := loader.Explain(m)
+	require.NoError(t, err)
+
+	sources := make(map[string]string)
+	values := make(map[string]string)
+	for _, s := range settings {
+		sources[s.Key] = s.Source
+		values[s.Key] = FormatValue(s.Value)
+	}
+
+	assert.Equal(t, "config/defaults.toml", sources["user.username"])
+	assert.Equal(t, filepath.Join("config/defaults.d", "10-site.toml"), sources["network.timezone"])
+	assert.Equal(t, filepath.Join("config/roles", "edge.toml"), sources["user.groups"])
+	assert.Equal(t, `["edge"]`, values["user.groups"])
+	assert.Equal(t, "machines/proxy/machine.toml", sources["machine.fqdn"])
+	assert.Equal(t, `"proxy.example.com"`, values["machine.fqdn"])
+	assert.Equal(t, "machine.fqdn", settings[0].Key, "settings are sorted by key")
+}
+
+func TestNewConfigLoaderAt(t *testing.T) {
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"config/defaults.toml":           "domain = \"example.com\"\n",
+		"config/defaults.d/10-site.toml": "[network]\ntimezone = \"UTC\"\n",
+		"machines/web/machine.toml":      "name = \"web\"\n",
+		"containers/web/Containerfile":   "FROM scratch\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, filepath.Dir(path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, path), []byte(content), 0644))
+	}
+
+	loader := NewConfigLoaderAt(root)
+	require.NoError(t, loader.LoadAll())
+	m, err := loader.GetMachine("web")
+	require.NoError(t, err)
+	assert.Equal(t, "web.example.com", m.FQDN)
+	assert.Equal(t, "UTC", loader.GetDefaults().Network.Timezone)
+	assert.Len(t, loader.GetWorkloads(), 1)
+
+	settings, err := loader.Explain(m)
+	require.NoError(t, err)
+	sources := make(map[string]string)
+	for _, s := range settings {
+		sources[s.Key] = s.Source
+	}
+	assert.Equal(t, filepath.Join("config/defaults.d", "10-site.toml"), sources["network.timezone"], "sources stay relative to the root")
+	assert.Equal(t, "machines/web/machine.toml", sources["machine.name"])
+}
+
+func TestConfigLoader_Environment(t *testing.T) {
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"config/defaults.toml": `[container_registry]
+url = "ghcr.io/me"
+
+[network]
+timezone = "UTC"
+dns_servers = ["1.1.1.1"]
+`,
+		"config/defaults.d/10-dns.toml": "[network]\ndns_servers = [\"9.9.9.9\"]\n",
+		"config/defaults.staging.toml":  "[container_registry]\nurl = \"registry.staging.lab\"\n",
+		"config/defaults.secret.toml":   "",
+		"config/sites/lab.toml":         "[network]\ntimezone = \"Europe/Berlin\"\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, filepath.Dir(path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, path), []byte(content), 0644))
+	}
+
+	loader := NewConfigLoaderAt(root)
+	require.NoError(t, loader.LoadDefaults())
+	assert.Equal(t, "ghcr.io/me", loader.GetDefaults().ContainerRegistry.URL, "no environment leaves the defaults alone")
+	assert.Equal(t, []string{"staging"}, loader.Environments(), "defaults.secret.toml isn't an environment")
+
+	loader.SetEnvironment("staging")
+	require.NoError(t, loader.LoadDefaults())
+	defaults := loader.GetDefaults()
+	assert.Equal(t, "registry.staging.lab", defaults.ContainerRegistry.URL)
+	assert.Equal(t, []string{"9.9.9.9"}, defaults.Network.DNSServers, "keys the environment doesn't set are kept")
+	site, err := loader.DefaultsFor(Config{Name: "web", Site: "lab"})
+	require.NoError(t, err)
+	assert.Equal(t, "registry.staging.lab", site.ContainerRegistry.URL)
+	assert.Equal(t, "Europe/Berlin", site.Network.Timezone, "site overlays apply over the environment")
+
+	loader.SetEnvironment("prod")
+	assert.ErrorContains(t, loader.LoadDefaults(), "unknown environment 'prod' (no config/defaults.prod.toml; available: staging)")
+	loader.SetEnvironment("secret")
+	assert.ErrorContains(t, loader.LoadDefaults(), "invalid environment name 'secret'")
+	loader.SetEnvironment("../defaults")
+	assert.ErrorContains(t, loader.LoadDefaults(), "invalid environment name")
+}
+
+func TestConfigLoader_Sites(t *testing.T) {
+	chdirTemp(t)
+	writeFiles(t, map[string]string{
+		"config/defaults.toml": `domain = "home.example.com"
+
+[network]
+dns_servers = ["1.1.1.1"]
+
+[hypervisor]
+backend = "proxmox"
+`,
+		"config/sites/hetzner.toml": `domain = "hetzner.example.com"
+
+[network]
+dns_servers = ["185.12.64.1"]
+
+[container_registry]
+url = "registry.hetzner.example.com"
+
+[hypervisor]
+backend = "libvirt"
+`,
+		"config/roles/edge.toml":      "[network]\ndns_servers = [\"10.0.0.53\"]\n",
+		"machines/web/machine.toml":   "name = \"web\"\nsite = \"hetzner\"\n",
+		"machines/proxy/machine.toml": "name = \"proxy\"\nsite = \"hetzner\"\nfqdn = \"proxy.example.org\"\ntags = [\"edge\"]\n",
+		"machines/nas/machine.toml":   "name = \"nas\"\n",
+	})
+
+	loader := NewConfigLoader()
+	require.NoError(t, loader.LoadDefaults())
+	require.NoError(t, loader.LoadMachines())
+	assert.Equal(t, []string{"hetzner"}, loader.Sites())
+
+	web, err := loader.GetMachine("web")
+	require.NoError(t, err)
+	assert.Equal(t, "web.hetzner.example.com", web.FQDN, "fqdn should come from the site domain")
+	nas, err := loader.GetMachine("nas")
+	require.NoError(t, err)
+	assert.Equal(t, "nas.home.example.com", nas.FQDN, "fqdn should fall back to the defaults domain")
+
+	defaults, err := loader.DefaultsFor(web)
+	require.NoError(t, err)
+	assert.Equal(t, "registry.hetzner.example.com", defaults.ContainerRegistry.URL)
+	assert.Equal(t, "libvirt", defaults.Hypervisor.Backend)
+	assert.Equal(t, []string{"185.12.64.1"}, defaults.Network.DNSServers)
+
+	proxy, err := loader.GetMachine("proxy")
+	require.NoError(t, err)
+	assert.Equal(t, "proxy.example.org", proxy.FQDN, "an explicit fqdn wins over the site domain")
+	defaults, err = loader.DefaultsFor(proxy)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.53"}, defaults.Network.DNSServers, "roles apply over sites")
+
+	var names []string
+	for _, m := range loader.MachinesInSite("hetzner") {
+		names = append(names, m.Name)
+	}
+	assert.ElementsMatch(t, []string{"web", "proxy"}, names)
+
+	writeFiles(t, map[string]string{"machines/db/machine.toml": "name = \"db\"\nsite = \"office\"\n"})
+	err = loader.LoadMachines()
+	assert.ErrorContains(t, err, "unknown site 'office'")
+}
+
+func TestConfigLoader_SelectMachines(t *testing.T) {
+	chdirTemp(t)
+	writeFiles(t, map[string]string{
+		"config/defaults.toml":        `domain = "example.com"`,
+		"config/sites/hetzner.toml":   `domain = "hetzner.example.com"`,
+		"machines/dns1/machine.toml":  "name = \"dns1\"\ngroup = \"homelab\"\ntags = [\"dns\", \"critical\"]\n",
+		"machines/dns2/machine.toml":  "name = \"dns2\"\ngroup = \"vps\"\nsite = \"hetzner\"\ntags = [\"dns\"]\n",
+		"machines/proxy/machine.toml": "name = \"proxy\"\ngroup = \"vps\"\nsite = \"hetzner\"\n",
+		"machines/nas/machine.toml":   "name = \"nas\"\n",
+	})
+
+	loader := NewConfigLoader()
+	require.NoError(t, loader.LoadDefaults())
+	require.NoError(t, loader.LoadMachines())
+
+	selected := func(s Selector) []string {
+		var names []string
+		for _, m := range loader.SelectMachines(s) {
+			names = append(names, m.Name)
+		}
+		return names
+	}
+	assert.ElementsMatch(t, []string{"dns1", "dns2", "proxy", "nas"}, selected(Selector{}))
+	assert.ElementsMatch(t, []string{"dns1", "dns2"}, selected(Selector{Tags: []string{"dns"}}))
+	assert.ElementsMatch(t, []string{"dns1"}, selected(Selector{Tags: []string{"dns", "critical"}}), "every tag must match")
+	assert.ElementsMatch(t, []string{"dns2", "proxy"}, selected(Selector{Group: "vps"}))
+	assert.ElementsMatch(t, []string{"dns2"}, selected(Selector{Group: "vps", Tags: []string{"dns"}}))
+	assert.Empty(t, selected(Selector{Site: "hetzner", Group: "homelab"}))
+
+	assert.True(t, Selector{}.IsZero())
+	assert.Equal(t, "--site hetzner --group vps --tag dns", Selector{Site: "hetzner", Group: "vps", Tags: []string{"dns"}}.String())
+}
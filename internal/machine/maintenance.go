@@ -0,0 +1,69 @@
+package machine
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// MaintenanceConfig schedules update timers and reboots; set in defaults,
+// sites, roles or a machine's [maintenance] table
+type MaintenanceConfig struct {
+	Window          string `toml:"window,omitempty"`           // systemd OnCalendar expression, e.g. "Sat,Sun *-*-* 02:00" (default: daily at [bootc] update_time)
+	RandomizedDelay string `toml:"randomized_delay,omitempty"` // Spread machines' start times over this long, e.g. "45m"
+	FixedDelay      bool   `toml:"fixed_delay,omitempty"`      // Give each machine the same offset every day instead of a new one (FixedRandomDelay)
+}
+
+// IsZero reports whether nothing is configured, so timers keep [bootc] update_time alone
+func (c MaintenanceConfig) IsZero() bool {
+	return c == MaintenanceConfig{}
+}
+
+// ResolveMaintenance returns m's maintenance settings: its own, falling back
+// to the defaults' one by one
+func ResolveMaintenance(m Config, defaults Defaults) MaintenanceConfig {
+	resolved := defaults.Maintenance
+	if m.Maintenance.Window != "" {
+		resolved.Window = m.Maintenance.Window
+	}
+	if m.Maintenance.RandomizedDelay != "" {
+		resolved.RandomizedDelay = m.Maintenance.RandomizedDelay
+	}
+	if m.Maintenance.FixedDelay {
+		resolved.FixedDelay = true
+	}
+	return resolved
+}
+
+// Delay parses RandomizedDelay: a Go duration such as "45m" or "1h30m", or seconds
+func (c MaintenanceConfig) Delay() (time.Duration, error) {
+	if c.RandomizedDelay == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.Atoi(c.RandomizedDelay); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(c.RandomizedDelay)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid [maintenance] randomized_delay %q (expected a duration such as \"45m\")", c.RandomizedDelay)
+	}
+	return d, nil
+}
+
+// ValidateMaintenance checks the window is an OnCalendar expression systemd
+// accepts and the delay a duration that fits in a day
+func ValidateMaintenance(c MaintenanceConfig) error {
+	if c.Window != "" {
+		if _, err := ParseCalendar(c.Window); err != nil {
+			return fmt.Errorf("invalid [maintenance] window: %w", err)
+		}
+	}
+	d, err := c.Delay()
+	if err != nil {
+		return err
+	}
+	if d > 24*time.Hour {
+		return fmt.Errorf("[maintenance] randomized_delay %s is longer than a day", c.RandomizedDelay)
+	}
+	return nil
+}
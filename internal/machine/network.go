@@ -3,6 +3,7 @@ package machine
 import (
 	"crypto/rand"
 	"fmt"
+	"net/netip"
 	"strings"
 )
 
@@ -45,3 +46,41 @@ func GetMACOrGenerate(existingMAC, prefix string) (string, error) {
 
 	return GenerateMAC(prefix)
 }
+
+// ValidateStaticNetwork checks a machine's static address settings: an
+// ip_address with a prefix length that fits it, and a gateway and DNS servers
+// that are addresses
+func ValidateStaticNetwork(c Config) error {
+	if !c.StaticNetwork() {
+		if len(c.DNSServers) > 0 {
+			return fmt.Errorf("dns_servers only applies to a static address; set prefix_length and gateway too")
+		}
+		return nil
+	}
+
+	if c.IPAddress == "" {
+		return fmt.Errorf("a static address needs ip_address")
+	}
+	addr, err := netip.ParseAddr(c.IPAddress)
+	if err != nil {
+		return fmt.Errorf("invalid ip_address %q", c.IPAddress)
+	}
+	if c.PrefixLength < 1 || c.PrefixLength > addr.BitLen() {
+		return fmt.Errorf("a static address needs prefix_length between 1 and %d for %s", addr.BitLen(), c.IPAddress)
+	}
+	if c.Gateway != "" {
+		gateway, err := netip.ParseAddr(c.Gateway)
+		if err != nil {
+			return fmt.Errorf("invalid gateway %q", c.Gateway)
+		}
+		if gateway.Is4() != addr.Is4() {
+			return fmt.Errorf("gateway %s and ip_address %s are different IP versions", c.Gateway, c.IPAddress)
+		}
+	}
+	for _, server := range c.DNSServers {
+		if _, err := netip.ParseAddr(server); err != nil {
+			return fmt.Errorf("invalid DNS server %q", server)
+		}
+	}
+	return nil
+}
@@ -66,3 +66,32 @@ func TestGetMACOrGenerate(t *testing.T) {
 	assert.True(t, strings.HasPrefix(result, prefix))
 	assert.True(t, ValidateMAC(result))
 }
+
+func TestValidateStaticNetwork(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config Config
+		err    string
+	}{
+		{"dhcp", Config{IPAddress: "10.0.0.5"}, ""},
+		{"static ipv4", Config{IPAddress: "10.0.0.5", PrefixLength: 24, Gateway: "10.0.0.1", DNSServers: []string{"10.0.0.53"}}, ""},
+		{"static ipv6 without gateway", Config{IPAddress: "2001:db8::5", PrefixLength: 64}, ""},
+		{"dns without static address", Config{DNSServers: []string{"1.1.1.1"}}, "dns_servers only applies to a static address"},
+		{"gateway without address", Config{Gateway: "10.0.0.1"}, "needs ip_address"},
+		{"bad address", Config{IPAddress: "10.0.0", PrefixLength: 24}, `invalid ip_address "10.0.0"`},
+		{"missing prefix", Config{IPAddress: "10.0.0.5", Gateway: "10.0.0.1"}, "prefix_length between 1 and 32"},
+		{"prefix too long", Config{IPAddress: "2001:db8::5", PrefixLength: 129}, "prefix_length between 1 and 128"},
+		{"bad gateway", Config{IPAddress: "10.0.0.5", PrefixLength: 24, Gateway: "router"}, `invalid gateway "router"`},
+		{"mixed versions", Config{IPAddress: "10.0.0.5", PrefixLength: 24, Gateway: "fe80::1"}, "different IP versions"},
+		{"bad dns", Config{IPAddress: "10.0.0.5", PrefixLength: 24, DNSServers: []string{"dns.example.com"}}, `invalid DNS server "dns.example.com"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateStaticNetwork(tc.config)
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package machine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// passwordHashHint tells people how to produce an acceptable hash
+const passwordHashHint = "generate one with 'mkpasswd --method=yescrypt' or 'openssl passwd -6'"
+
+// placeholderHashes are example values from docs and tests that must never reach a machine
+var placeholderHashes = map[string]bool{
+	"$6$test$hash":  true,
+	"$6$admin$hash": true,
+	"$6$salt$hash":  true,
+}
+
+// Hash formats shadow accepts that are still considered strong
+var (
+	yescryptPattern    = regexp.MustCompile(`^\$y\$[./A-Za-z0-9]+\$[./A-Za-z0-9]{1,86}\$[./A-Za-z0-9]{43}$`)
+	sha512cryptPattern = regexp.MustCompile(`^\$6\$(rounds=[0-9]+\$)?[./A-Za-z0-9]{1,16}\$[./A-Za-z0-9]{86}$`)
+	bcryptPattern      = regexp.MustCompile(`^\$2[aby]\$[0-9]{2}\$[./A-Za-z0-9]{53}$`)
+)
+
+// weakHashPrefixes are crypt algorithms shadow accepts but that are too weak to use
+var weakHashPrefixes = map[string]string{
+	"$1$": "MD5-crypt",
+	"$5$": "SHA-256-crypt",
+	"$3$": "NT-hash",
+}
+
+// CheckPasswordHash reports why hash can't be used as a shadow password hash.
+// Yescrypt, SHA-512-crypt and bcrypt hashes are accepted; an empty hash (no
+// password login) and locked accounts ("!" or "*") are allowed.
+func CheckPasswordHash(hash string) error {
+	switch {
+	case hash == "" || hash == "!" || hash == "*":
+		return nil
+	case placeholderHashes[hash]:
+		return fmt.Errorf("is the placeholder %q, not a real hash; %s", hash, passwordHashHint)
+	case !strings.HasPrefix(hash, "$"):
+		return fmt.Errorf("looks like a plaintext password or DES hash, not a crypt hash; %s", passwordHashHint)
+	}
+
+	for prefix, algorithm := range weakHashPrefixes {
+		if strings.HasPrefix(hash, prefix) {
+			return fmt.Errorf("uses %s, which is too weak; %s", algorithm, passwordHashHint)
+		}
+	}
+
+	var pattern *regexp.Regexp
+	var algorithm string
+	switch {
+	case strings.HasPrefix(hash, "$y$"):
+		pattern, algorithm = yescryptPattern, "yescrypt"
+	case strings.HasPrefix(hash, "$6$"):
+		pattern, algorithm = sha512cryptPattern, "SHA-512-crypt"
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		pattern, algorithm = bcryptPattern, "bcrypt"
+	default:
+		return fmt.Errorf("uses unsupported algorithm $%s$; use yescrypt ($y$), SHA-512-crypt ($6$) or bcrypt ($2b$); %s",
+			strings.Split(hash, "$")[1], passwordHashHint)
+	}
+	if !pattern.MatchString(hash) {
+		return fmt.Errorf("is not a well-formed %s hash (truncated or hand-edited?); %s", algorithm, passwordHashHint)
+	}
+	return nil
+}
+
+// ValidatePasswordHashes checks every password_hash in defaults; accounts
+// with ssh_only set are skipped since their password is locked
+func ValidatePasswordHashes(defaults Defaults) []error {
+	var errs []error
+	if err := CheckPasswordHash(defaults.User.PasswordHash); err != nil && !defaults.User.SSHOnly {
+		errs = append(errs, fmt.Errorf("user.password_hash %w", err))
+	}
+	if err := CheckPasswordHash(defaults.Admin.PasswordHash); err != nil && !defaults.Admin.SSHOnly {
+		errs = append(errs, fmt.Errorf("admin.password_hash %w", err))
+	}
+	return errs
+}
@@ -0,0 +1,60 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPasswordHash(t *testing.T) {
+	bcryptHash, err := GeneratePasswordHash("correct horse battery staple")
+	require.NoError(t, err)
+
+	valid := []string{
+		"",
+		"!",
+		"*",
+		"$6$VcugxwlWVNK8G/GF$JOrUqw178WeymEugDqPIfQGpedFog2HNJEYuYfpfo.HvjBvOlaX3/OajZwQfgRPcCI12laZ0RkfR9.uDZKd4f/",
+		"$6$rounds=10000$saltsalt$7d0RLfyHv6TRbWDd.b7Mwl1P8jSaYs5WbeDRwF.qgcbbOwOO2pWN0JgBL1EjCt0Gw9j0DfjOhL3U9B6wtY6W4.",
+		"$y$j9T$F5Jx5fExrKuPp53xLKQ..1$X3DX6M94c7o.9agCG9G317fhZg9SqC.5i5rd.RhAtQ7",
+		bcryptHash,
+	}
+	for _, hash := range valid {
+		assert.NoError(t, CheckPasswordHash(hash), hash)
+	}
+
+	invalid := map[string]string{
+		"$6$test$hash":                   "placeholder",
+		"hunter2":                        "plaintext",
+		"abJnggxhB/yWI":                  "DES hash",
+		"$1$salt$qJH7.N4xYta3aEG/dfqo/0": "MD5-crypt",
+		"$5$salt$Gcm6FsVtF/Qa77ZKD.iwsJlCVPY0XSMgLJL0Hnww/c1": "SHA-256-crypt",
+		"$7$CU..../....salt$hash":                             "unsupported algorithm $7$",
+		"$6$VcugxwlWVNK8G/GF$JOrUqw178Wey":                    "not a well-formed SHA-512-crypt hash",
+		"$y$j9T$salt$short":                                   "not a well-formed yescrypt hash",
+	}
+	for hash, reason := range invalid {
+		err := CheckPasswordHash(hash)
+		if assert.Error(t, err, hash) {
+			assert.Contains(t, err.Error(), reason, hash)
+			assert.Contains(t, err.Error(), "mkpasswd", "errors tell people how to fix it")
+		}
+	}
+}
+
+func TestValidatePasswordHashes(t *testing.T) {
+	defaults := Defaults{
+		User:  UserConfig{PasswordHash: "$6$test$hash"},
+		Admin: AdminConfig{PasswordHash: "plaintext"},
+	}
+	errs := ValidatePasswordHashes(defaults)
+	require.Len(t, errs, 2)
+	assert.Contains(t, errs[0].Error(), "user.password_hash is the placeholder")
+	assert.Contains(t, errs[1].Error(), "admin.password_hash looks like a plaintext password")
+
+	assert.Empty(t, ValidatePasswordHashes(Defaults{}))
+
+	defaults.User.SSHOnly = true
+	assert.Len(t, ValidatePasswordHashes(defaults), 1, "ssh_only accounts have no usable password to check")
+}
@@ -0,0 +1,189 @@
+package machine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/andreweick/iago/internal/tomledit"
+)
+
+// PreviewsDir holds pull request previews: output/preview/pr-<n>/<name>/ is
+// a preview's machine directory and output/preview/pr-<n>/<name>.ign its ignition
+const PreviewsDir = "output/preview"
+
+// PreviewName returns the name of a machine's preview for pull request pr, e.g. web-pr42
+func PreviewName(name string, pr int) string {
+	return fmt.Sprintf("%s-pr%d", name, pr)
+}
+
+// PreviewDir returns the directory of pull request pr's previews
+func PreviewDir(pr int) string {
+	return filepath.Join(PreviewsDir, fmt.Sprintf("pr-%d", pr))
+}
+
+// CreatePreview writes a preview of machine source for pull request pr from
+// the templates checked out now. It is a copy of the machine's directory with
+// its name, FQDN and container image renamed as clone does, a MAC address of
+// its own, no static address and no age recipients, so it can boot next to
+// the original without holding its keys.
+// Creating a preview again keeps its MAC address and VM ID, so the same VM
+// picks up the new templates. The preview is added to the loaded machines.
+func (cl *ConfigLoader) CreatePreview(source string, pr int) (Config, error) {
+	if pr <= 0 {
+		return Config{}, fmt.Errorf("invalid pull request number %d", pr)
+	}
+	sourceDir := filepath.Join(MachinesDir, source)
+	if _, err := os.Stat(cl.path(filepath.Join(sourceDir, "machine.toml"))); err != nil {
+		return Config{}, fmt.Errorf("machine '%s': %w", source, ErrMachineNotFound)
+	}
+	name := PreviewName(source, pr)
+	dir := filepath.Join(PreviewDir(pr), name)
+	machinePath := filepath.Join(dir, "machine.toml")
+
+	// What the VM already has survives regenerating the preview
+	kept := make(map[string]any)
+	if previous, err := tomledit.Load(cl.path(machinePath)); err == nil {
+		for _, key := range []string{"mac_address", "vm_id"} {
+			if value, ok := previous.Get(key); ok {
+				kept[key] = value
+			}
+		}
+	}
+
+	if err := os.RemoveAll(cl.path(dir)); err != nil {
+		return Config{}, fmt.Errorf("failed to remove the previous preview: %w", err)
+	}
+	if err := os.MkdirAll(cl.path(filepath.Dir(dir)), 0755); err != nil {
+		return Config{}, fmt.Errorf("failed to create %s: %w", PreviewDir(pr), err)
+	}
+	if err := copyMachineDir(cl.path(dir), cl.path(sourceDir)); err != nil {
+		os.RemoveAll(cl.path(dir))
+		return Config{}, err
+	}
+	if err := cloneConfig(cl.path(dir), source, name); err != nil {
+		os.RemoveAll(cl.path(dir))
+		return Config{}, err
+	}
+	if err := previewConfig(cl.path(machinePath), kept); err != nil {
+		os.RemoveAll(cl.path(dir))
+		return Config{}, err
+	}
+
+	m, err := cl.readMachine(machinePath)
+	if err != nil {
+		return Config{}, err
+	}
+	m.Dir = dir
+	cl.machines.Machines = append(cl.machines.Machines, m)
+	return m, nil
+}
+
+// previewConfig detaches a cloned machine.toml from the machine it copies:
+// it drops the static address, VM ID and protection, and restores kept values
+func previewConfig(machinePath string, kept map[string]any) error {
+	doc, err := tomledit.Load(machinePath)
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{"ip_address", "prefix_length", "gateway", "dns_servers", "vm_id", "protected"} {
+		if _, err := doc.Delete(key); err != nil {
+			return err
+		}
+	}
+	if _, ok := kept["mac_address"]; !ok {
+		if _, ok := doc.Get("mac_address"); !ok {
+			mac, err := GenerateMAC(DefaultMACPrefix)
+			if err != nil {
+				return fmt.Errorf("failed to generate MAC address: %w", err)
+			}
+			kept["mac_address"] = mac
+		}
+	}
+	keys := make([]string, 0, len(kept))
+	for key := range kept {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := doc.Set(key, kept[key]); err != nil {
+			return err
+		}
+	}
+	return doc.Save(machinePath)
+}
+
+// Previews returns the previews of pull request pr, or of every pull request
+// when pr is 0, sorted by directory
+func (cl *ConfigLoader) Previews(pr int) ([]Config, error) {
+	pattern := filepath.Join(PreviewsDir, "pr-*", "*", "machine.toml")
+	if pr != 0 {
+		pattern = filepath.Join(PreviewDir(pr), "*", "machine.toml")
+	}
+	paths, err := filepath.Glob(cl.path(pattern))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list previews: %w", err)
+	}
+	sort.Strings(paths)
+
+	previews := make([]Config, 0, len(paths))
+	for _, path := range paths {
+		if cl.root != "" {
+			if path, err = filepath.Rel(cl.root, path); err != nil {
+				return nil, err
+			}
+		}
+		m, err := cl.readMachine(path)
+		if err != nil {
+			return nil, err
+		}
+		m.Dir = filepath.Dir(path)
+		previews = append(previews, m)
+	}
+	return previews, nil
+}
+
+// SetPreviewVMID records the VM a preview was deployed to in its machine.toml
+func (cl *ConfigLoader) SetPreviewVMID(m Config, vmid int) error {
+	path := cl.path(filepath.Join(m.Directory(), "machine.toml"))
+	doc, err := tomledit.Load(path)
+	if err != nil {
+		return err
+	}
+	if err := doc.Set("vm_id", vmid); err != nil {
+		return err
+	}
+	return doc.Save(path)
+}
+
+// RemovePreview deletes a preview's directory and generated files, and the
+// pull request's directory once it has no previews left
+func (cl *ConfigLoader) RemovePreview(m Config) error {
+	if m.Dir == "" || filepath.Dir(filepath.Dir(m.Dir)) != PreviewsDir {
+		return fmt.Errorf("machine '%s' is not a preview", m.Name)
+	}
+	prDir := filepath.Dir(m.Dir)
+	generated, err := filepath.Glob(filepath.Join(cl.path(prDir), m.Name+".ign*"))
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", prDir, err)
+	}
+	paths := append([]string{cl.path(m.Dir), filepath.Join(cl.path(prDir), m.Name+"-final-butane.yaml")}, generated...)
+	for _, path := range paths {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+
+	// The checksums left behind only list what was removed
+	entries, err := os.ReadDir(cl.path(prDir))
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", prDir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			return nil
+		}
+	}
+	return os.RemoveAll(cl.path(prDir))
+}
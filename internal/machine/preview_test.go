@@ -0,0 +1,78 @@
+package machine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigLoader_Previews(t *testing.T) {
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"config/defaults.toml": "domain = \"example.com\"\n",
+		"machines/web/machine.toml": `name = "web"
+fqdn = "web.example.com"
+mac_address = "02:00:00:00:00:01"
+ip_address = "10.0.0.5"
+prefix_length = 24
+gateway = "10.0.0.1"
+vm_id = 110
+protected = true
+`,
+		"machines/web/butane.yaml.tmpl": "# web\n",
+		"machines/web/recipients.txt":   "age1web\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, filepath.Dir(path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, path), []byte(content), 0644))
+	}
+	loader := NewConfigLoaderAt(root)
+	require.NoError(t, loader.LoadAll())
+
+	m, err := loader.CreatePreview("web", 42)
+	require.NoError(t, err)
+	assert.Equal(t, "web-pr42", m.Name)
+	assert.Equal(t, "web-pr42.example.com", m.FQDN)
+	assert.Equal(t, filepath.Join("output/preview/pr-42", "web-pr42"), m.Directory())
+	assert.NotEqual(t, "02:00:00:00:00:01", m.MACAddress)
+	assert.Empty(t, m.IPAddress, "previews use DHCP")
+	assert.Zero(t, m.VMID)
+	assert.False(t, m.Protected)
+	template, err := os.ReadFile(filepath.Join(root, m.Directory(), "butane.yaml.tmpl"))
+	require.NoError(t, err)
+	assert.Equal(t, "# web-pr42\n", string(template))
+	assert.NoFileExists(t, filepath.Join(root, m.Directory(), "recipients.txt"))
+	loaded, err := loader.GetMachine("web-pr42")
+	require.NoError(t, err, "the preview is added to the loaded machines")
+	assert.Equal(t, m.Directory(), loaded.Directory())
+
+	require.NoError(t, loader.SetPreviewVMID(m, 9042))
+	again, err := loader.CreatePreview("web", 42)
+	require.NoError(t, err)
+	assert.Equal(t, m.MACAddress, again.MACAddress, "regenerating keeps the MAC address")
+	assert.Equal(t, 9042, again.VMID, "regenerating keeps the VM")
+
+	_, err = loader.CreatePreview("web", 7)
+	require.NoError(t, err)
+	previews, err := loader.Previews(0)
+	require.NoError(t, err)
+	require.Len(t, previews, 2)
+	assert.Equal(t, "web-pr42", previews[0].Name)
+	assert.Equal(t, "web-pr7", previews[1].Name)
+	previews, err = loader.Previews(42)
+	require.NoError(t, err)
+	require.Len(t, previews, 1)
+
+	_, err = loader.CreatePreview("db", 42)
+	assert.ErrorIs(t, err, ErrMachineNotFound)
+	assert.ErrorContains(t, loader.RemovePreview(Config{Name: "web"}), "is not a preview")
+
+	ignition := filepath.Join(root, "output/preview/pr-42", "web-pr42.ign")
+	require.NoError(t, os.WriteFile(ignition, []byte("{}"), 0644))
+	require.NoError(t, loader.RemovePreview(previews[0]))
+	assert.NoFileExists(t, ignition)
+	assert.NoDirExists(t, filepath.Join(root, "output/preview/pr-42"), "an empty pull request directory is removed")
+	assert.DirExists(t, filepath.Join(root, "output/preview/pr-7"))
+}
@@ -0,0 +1,34 @@
+package machine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Approval is how a caller vouches for a destructive action on a protected machine
+type Approval struct {
+	User       string // who is running the command
+	ApprovedBy string // a second person who signed off on the action
+	Override   bool   // --i-know-what-im-doing
+}
+
+// CheckProtected returns an error if action would touch a protected machine
+// without an override or an approval from someone other than the caller
+func (c Config) CheckProtected(action string, approval Approval) error {
+	if !c.Protected || approval.Override {
+		return nil
+	}
+
+	if approval.ApprovedBy != "" {
+		if strings.EqualFold(approval.ApprovedBy, approval.User) {
+			return fmt.Errorf("machine '%s' is protected and must be approved by someone other than %s", c.Name, approval.User)
+		}
+		return nil
+	}
+
+	owners := ""
+	if len(c.Owners) > 0 {
+		owners = fmt.Sprintf(" (owners: %s)", strings.Join(c.Owners, ", "))
+	}
+	return fmt.Errorf("machine '%s' is protected%s; rerun '%s' with --approved-by <name> or --i-know-what-im-doing", c.Name, owners, action)
+}
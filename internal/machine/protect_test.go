@@ -0,0 +1,24 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckProtected(t *testing.T) {
+	unprotected := Config{Name: "dev"}
+	assert.NoError(t, unprotected.CheckProtected("rm", Approval{User: "alice"}))
+
+	protected := Config{Name: "nas", Protected: true, Owners: []string{"storage-team"}}
+
+	err := protected.CheckProtected("rm", Approval{User: "alice"})
+	assert.ErrorContains(t, err, "owners: storage-team")
+	assert.ErrorContains(t, err, "--i-know-what-im-doing")
+
+	assert.NoError(t, protected.CheckProtected("rm", Approval{User: "alice", Override: true}))
+	assert.NoError(t, protected.CheckProtected("rm", Approval{User: "alice", ApprovedBy: "bob"}))
+
+	err = protected.CheckProtected("up", Approval{User: "alice", ApprovedBy: "Alice"})
+	assert.ErrorContains(t, err, "someone other than alice")
+}
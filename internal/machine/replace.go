@@ -0,0 +1,138 @@
+package machine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// Replacement rewrites text in machine files: every occurrence of Old, or with
+// Regex every match of it, where New may refer to groups as $1 or ${name}
+type Replacement struct {
+	Old   string
+	New   string
+	Regex bool
+}
+
+// ParseReplacement parses an "old=new" replacement; old may not be empty
+func ParseReplacement(spec string, regex bool) (Replacement, error) {
+	old, replacement, ok := strings.Cut(spec, "=")
+	if !ok || old == "" {
+		return Replacement{}, fmt.Errorf("invalid replacement %q (expected old=new)", spec)
+	}
+	if regex {
+		if _, err := regexp.Compile(old); err != nil {
+			return Replacement{}, fmt.Errorf("invalid regular expression %q: %w", old, err)
+		}
+	}
+	return Replacement{Old: old, New: replacement, Regex: regex}, nil
+}
+
+// apply returns content with r applied and the number of replacements made
+func (r Replacement) apply(content []byte) ([]byte, int) {
+	if !r.Regex {
+		return bytes.ReplaceAll(content, []byte(r.Old), []byte(r.New)), bytes.Count(content, []byte(r.Old))
+	}
+	re := regexp.MustCompile(r.Old)
+	return re.ReplaceAll(content, []byte(r.New)), len(re.FindAllIndex(content, -1))
+}
+
+// Edit is a change a Replacement makes to one machine file
+type Edit struct {
+	Machine string
+	Path    string // Relative to the repository
+	Count   int    // Replacements made
+	Before  []byte
+	After   []byte
+
+	file string // Path on disk
+}
+
+// Diff returns a unified diff of the edit
+func (e Edit) Diff() string {
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(e.Before)),
+		B:        difflib.SplitLines(string(e.After)),
+		FromFile: "a/" + filepath.ToSlash(e.Path),
+		ToFile:   "b/" + filepath.ToSlash(e.Path),
+		Context:  3,
+	})
+	if err != nil {
+		return ""
+	}
+	return diff
+}
+
+// Apply writes the edit, refusing when the file changed since it was planned
+func (e Edit) Apply() error {
+	current, err := os.ReadFile(e.file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", e.Path, err)
+	}
+	if !bytes.Equal(current, e.Before) {
+		return fmt.Errorf("%s changed since the edit was planned; run the replacement again", e.Path)
+	}
+	info, err := os.Stat(e.file)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", e.Path, err)
+	}
+	if err := os.WriteFile(e.file, e.After, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", e.Path, err)
+	}
+	return nil
+}
+
+// PlanReplace returns the edits r makes to the butane templates and
+// machine.toml files of machines, without writing anything. Machines from
+// includes are read-only and left out. An edit that would leave a machine.toml
+// unreadable, or rename the machine, is an error.
+func (cl *ConfigLoader) PlanReplace(machines []Config, r Replacement) ([]Edit, error) {
+	var edits []Edit
+	for _, m := range machines {
+		if m.Include != "" {
+			continue
+		}
+		for _, name := range []string{"butane.yaml.tmpl", "machine.toml"} {
+			path := filepath.Join(m.Directory(), name)
+			content, err := os.ReadFile(cl.path(path))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			after, count := r.apply(content)
+			if count == 0 || bytes.Equal(content, after) {
+				continue
+			}
+			if name == "machine.toml" {
+				if err := cl.checkMachineEdit(m, path, after); err != nil {
+					return nil, err
+				}
+			}
+			edits = append(edits, Edit{Machine: m.Name, Path: path, Count: count, Before: content, After: after, file: cl.path(path)})
+		}
+	}
+	return edits, nil
+}
+
+// checkMachineEdit checks an edited machine.toml still loads as the same machine
+func (cl *ConfigLoader) checkMachineEdit(m Config, path string, content []byte) error {
+	interpolated, err := Interpolate(content, cl.envAllow)
+	if err != nil {
+		return fmt.Errorf("the replacement breaks %s: %w", path, err)
+	}
+	var edited Config
+	if err := decodeStrict(path, interpolated, &edited); err != nil {
+		return fmt.Errorf("the replacement breaks %s: %w", path, err)
+	}
+	if edited.Name != m.Name {
+		return fmt.Errorf("the replacement renames %s to %s in %s; use 'iago rename' instead", m.Name, edited.Name, path)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package machine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReplacement(t *testing.T) {
+	r, err := ParseReplacement("old.example.com=ghcr.io/me", false)
+	require.NoError(t, err)
+	assert.Equal(t, Replacement{Old: "old.example.com", New: "ghcr.io/me"}, r)
+
+	r, err = ParseReplacement("a=b=c", false)
+	require.NoError(t, err)
+	assert.Equal(t, "b=c", r.New, "only the first = separates old from new")
+
+	_, err = ParseReplacement("=new", false)
+	assert.ErrorContains(t, err, "expected old=new")
+	_, err = ParseReplacement("(=x", true)
+	assert.ErrorContains(t, err, "invalid regular expression")
+}
+
+func TestConfigLoader_PlanReplace(t *testing.T) {
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"config/defaults.toml":          "domain = \"example.com\"\n",
+		"machines/web/machine.toml":     "name = \"web\"\ncontainer_image = \"old.example.com/web\"\n",
+		"machines/web/butane.yaml.tmpl": "image: old.example.com/web:{{ .Machine.ContainerTag }}\nmirror: old.example.com/cache\n",
+		"machines/db/machine.toml":      "name = \"db\"\ncontainer_image = \"ghcr.io/me/db\"\n",
+		"machines/db/butane.yaml.tmpl":  "variant: fcos\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, filepath.Dir(path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, path), []byte(content), 0644))
+	}
+	loader := NewConfigLoaderAt(root)
+	require.NoError(t, loader.LoadAll())
+
+	edits, err := loader.PlanReplace(loader.GetMachines(), Replacement{Old: "old.example.com", New: "ghcr.io/me"})
+	require.NoError(t, err)
+	require.Len(t, edits, 2, "db has no matches")
+	assert.Equal(t, filepath.Join("machines", "web", "butane.yaml.tmpl"), edits[0].Path)
+	assert.Equal(t, 2, edits[0].Count)
+	assert.Contains(t, edits[0].Diff(), "--- a/machines/web/butane.yaml.tmpl\n+++ b/machines/web/butane.yaml.tmpl\n")
+	assert.Contains(t, edits[0].Diff(), "+image: ghcr.io/me/web:{{ .Machine.ContainerTag }}\n")
+	assert.Equal(t, "machine.toml", filepath.Base(edits[1].Path))
+
+	content, err := os.ReadFile(filepath.Join(root, "machines/web/machine.toml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "old.example.com", "planning writes nothing")
+	require.NoError(t, edits[1].Apply())
+	content, err = os.ReadFile(filepath.Join(root, "machines/web/machine.toml"))
+	require.NoError(t, err)
+	assert.Equal(t, "name = \"web\"\ncontainer_image = \"ghcr.io/me/web\"\n", string(content))
+	assert.ErrorContains(t, edits[1].Apply(), "changed since the edit was planned")
+
+	edits, err = loader.PlanReplace(loader.GetMachines(), Replacement{Old: `mirror: ([a-z.]+)/cache`, New: "mirror: $1/mirror", Regex: true})
+	require.NoError(t, err)
+	require.Len(t, edits, 1)
+	assert.Contains(t, string(edits[0].After), "mirror: old.example.com/mirror")
+
+	_, err = loader.PlanReplace(loader.GetMachines(), Replacement{Old: "container_image", New: "container_imag"})
+	assert.ErrorContains(t, err, "unknown key 'container_imag'")
+	_, err = loader.PlanReplace(loader.GetMachines(), Replacement{Old: `name = "db"`, New: `name = "database"`})
+	assert.ErrorContains(t, err, "use 'iago rename' instead")
+}
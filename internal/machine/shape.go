@@ -0,0 +1,59 @@
+package machine
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const (
+	// MachinesDir holds a directory per machine with its machine.toml and butane template
+	MachinesDir = "machines"
+	// ContainersDir holds a bootc build context per workload
+	ContainersDir = "containers"
+	// ScriptsDir holds files butane templates embed with local:
+	ScriptsDir = "config/scripts"
+)
+
+// RepoShape records which optional top-level directories a repository has.
+// A machine-only repository deploys images built elsewhere, and a
+// container-only one builds images without provisioning machines.
+type RepoShape struct {
+	Machines   bool
+	Containers bool
+	Scripts    bool
+}
+
+// DetectRepoShape looks for the optional directories in the working directory
+func DetectRepoShape() RepoShape {
+	return DetectRepoShapeAt("")
+}
+
+// DetectRepoShapeAt looks for the optional directories in the repository at
+// root; "" is the working directory
+func DetectRepoShapeAt(root string) RepoShape {
+	return RepoShape{
+		Machines:   isDir(filepath.Join(root, MachinesDir)),
+		Containers: isDir(filepath.Join(root, ContainersDir)),
+		Scripts:    isDir(filepath.Join(root, ScriptsDir)),
+	}
+}
+
+// Notices explains what each missing directory means for iago's commands
+func (s RepoShape) Notices() []string {
+	var notices []string
+	if !s.Machines {
+		notices = append(notices, "no machines/ directory: there are no machines to render or check (create one with 'iago init --machine-only')")
+	}
+	if !s.Containers {
+		notices = append(notices, "no containers/ directory: machine images are assumed to be built elsewhere")
+	}
+	if !s.Scripts {
+		notices = append(notices, "no config/scripts/ directory: butane templates can't embed files with local:")
+	}
+	return notices
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,23 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectRepoShape(t *testing.T) {
+	chdirTemp(t)
+	assert.Equal(t, RepoShape{}, DetectRepoShape())
+	assert.Len(t, RepoShape{}.Notices(), 3)
+
+	writeFiles(t, map[string]string{
+		"machines/web/machine.toml": "name = \"web\"\n",
+		"containers":                "not a directory",
+	})
+	shape := DetectRepoShape()
+	assert.Equal(t, RepoShape{Machines: true}, shape)
+	notices := shape.Notices()
+	assert.Len(t, notices, 2)
+	assert.Contains(t, notices[0], "built elsewhere")
+}
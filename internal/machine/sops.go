@@ -0,0 +1,66 @@
+package machine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// DefaultsSecretPath is an optional overlay applied right after defaults.toml,
+// meant to hold password hashes and tokens and be committed SOPS-encrypted
+const DefaultsSecretPath = "config/defaults.secret.toml"
+
+// SOPSCommand is the sops binary used to decrypt encrypted config files. Keys
+// come from sops' usual sources (SOPS_AGE_KEY_FILE, cloud KMS credentials, ...).
+var SOPSCommand = "sops"
+
+// IsSOPSEncrypted reports whether content is a SOPS-encrypted file. SOPS has
+// no TOML format, so encrypted TOML uses its binary format: a JSON document
+// with the ciphertext under "data" and key metadata under "sops".
+func IsSOPSEncrypted(content []byte) bool {
+	if !bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		return false
+	}
+	var doc struct {
+		Data string         `json:"data"`
+		Sops map[string]any `json:"sops"`
+	}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return false
+	}
+	_, hasMAC := doc.Sops["mac"]
+	return doc.Data != "" && hasMAC
+}
+
+// readConfigFile reads a config file, decrypting it with sops when it is encrypted
+func readConfigFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !IsSOPSEncrypted(content) {
+		return content, nil
+	}
+	return decryptSOPS(path)
+}
+
+// decryptSOPS returns the plaintext of the SOPS-encrypted file at path
+func decryptSOPS(path string) ([]byte, error) {
+	cmd := exec.Command(SOPSCommand, "--decrypt", "--input-type", "binary", "--output-type", "binary", path)
+	out, err := cmd.Output()
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, fmt.Errorf("%s is SOPS-encrypted but %s is not installed", path, SOPSCommand)
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("failed to decrypt %s: %s", path, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("failed to decrypt %s: %w", path, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,89 @@
+package machine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encryptedStub is a SOPS binary-format document; fakeSOPS "decrypts" it by
+// printing the file's .plain sibling
+const encryptedStub = `{
+	"data": "ENC[AES256_GCM,data:abc,iv:def,tag:ghi,type:str]",
+	"sops": {"age": [{"recipient": "age1xyz"}], "mac": "ENC[AES256_GCM,data:mac]", "version": "3.9.0"}
+}
+`
+
+func fakeSOPS(t *testing.T) {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "sops")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nfor last; do :; done\ncat \"$last.plain\"\n"), 0755))
+	original := SOPSCommand
+	SOPSCommand = script
+	t.Cleanup(func() { SOPSCommand = original })
+}
+
+func TestIsSOPSEncrypted(t *testing.T) {
+	assert.True(t, IsSOPSEncrypted([]byte(encryptedStub)))
+	assert.False(t, IsSOPSEncrypted([]byte("[user]\nusername = \"core\"\n")))
+	assert.False(t, IsSOPSEncrypted([]byte(`{"data": "plain", "sops": {}}`)), "no MAC, not SOPS")
+	assert.False(t, IsSOPSEncrypted([]byte(`{"sops": {"mac": "x"}}`)), "no ciphertext")
+}
+
+func TestLoadDefaults_SOPS(t *testing.T) {
+	chdirTemp(t)
+	fakeSOPS(t)
+	writeFiles(t, map[string]string{
+		"config/defaults.toml":            encryptedStub,
+		"config/defaults.toml.plain":      "[user]\nusername = \"core\"\npassword_hash = \"from-defaults\"\n",
+		DefaultsSecretPath:                encryptedStub,
+		DefaultsSecretPath + ".plain":     "[admin]\npassword_hash = \"from-secret\"\n",
+		"config/defaults.d/10-site.toml":  "[network]\ntimezone = \"UTC\"\n",
+		"config/sites/hetzner.toml":       encryptedStub,
+		"config/sites/hetzner.toml.plain": "domain = \"hetzner.example.com\"\n",
+	})
+
+	loader := NewConfigLoader()
+	require.NoError(t, loader.LoadDefaults())
+	defaults := loader.GetDefaults()
+	assert.Equal(t, "core", defaults.User.Username)
+	assert.Equal(t, "from-defaults", defaults.User.PasswordHash)
+	assert.Equal(t, "from-secret", defaults.Admin.PasswordHash)
+	assert.Equal(t, "UTC", defaults.Network.Timezone)
+
+	site, err := loader.DefaultsFor(Config{Name: "web", Site: "hetzner"})
+	require.NoError(t, err)
+	assert.Equal(t, "hetzner.example.com", site.Domain)
+}
+
+func TestLoadDefaults_SecretOverlayOrder(t *testing.T) {
+	chdirTemp(t)
+	writeFiles(t, map[string]string{
+		"config/defaults.toml":           "[admin]\npassword_hash = \"base\"\n[network]\ntimezone = \"UTC\"\n",
+		DefaultsSecretPath:               "[admin]\npassword_hash = \"secret\"\n[network]\ntimezone = \"Europe/Berlin\"\n",
+		"config/defaults.d/10-site.toml": "[network]\ntimezone = \"America/New_York\"\n",
+	})
+
+	loader := NewConfigLoader()
+	require.NoError(t, loader.LoadDefaults(), "a plaintext secret overlay only warns")
+	defaults := loader.GetDefaults()
+	assert.Equal(t, "secret", defaults.Admin.PasswordHash)
+	assert.Equal(t, "America/New_York", defaults.Network.Timezone, "defaults.d overlays apply after the secret overlay")
+}
+
+func TestLoadDefaults_SOPSMissing(t *testing.T) {
+	chdirTemp(t)
+	original := SOPSCommand
+	SOPSCommand = "iago-no-such-sops"
+	t.Cleanup(func() { SOPSCommand = original })
+	writeFiles(t, map[string]string{
+		"config/defaults.toml": "[user]\nusername = \"core\"\n",
+		DefaultsSecretPath:     encryptedStub,
+	})
+
+	err := NewConfigLoader().LoadDefaults()
+	assert.ErrorContains(t, err, DefaultsSecretPath+" is SOPS-encrypted but iago-no-such-sops is not installed")
+}
@@ -0,0 +1,163 @@
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/andreweick/iago/internal/tomledit"
+)
+
+// decodeStrict decodes content, read from path, into v. Syntax errors and
+// keys v has no field for are reported as path:line, with the closest known
+// key as a suggestion, so a typo fails loading instead of being ignored.
+func decodeStrict(path string, content []byte, v any) error {
+	md, err := toml.Decode(string(content), v)
+	if err != nil {
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			return fmt.Errorf("failed to parse %s:%d: %s", path, parseErr.Position.Line, parseErr.Message)
+		}
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	undecoded := md.Undecoded()
+	if len(undecoded) == 0 {
+		return nil
+	}
+	doc, err := tomledit.Parse(content)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	reported := make(map[string]bool)
+	var errs []error
+	for _, key := range undecoded {
+		// Report an unknown table once, not every key in it
+		if parentReported(key, reported) {
+			continue
+		}
+		table := tableType(reflect.TypeOf(v), key[:len(key)-1])
+		if table != nil && table.Kind() != reflect.Struct {
+			continue // free-form, like [vars]
+		}
+		reported[key.String()] = true
+
+		message := fmt.Sprintf("%s:%d: unknown key '%s'", path, doc.Line(key.String()), key)
+		if table != nil {
+			if suggestion := closestKey(knownKeys(table), key[len(key)-1]); suggestion != "" {
+				message += fmt.Sprintf(" (did you mean '%s'?)", append(key[:len(key)-1:len(key)-1], suggestion))
+			}
+		}
+		errs = append(errs, errors.New(message))
+	}
+	return errors.Join(errs...)
+}
+
+// parentReported reports whether a table containing key was already reported
+func parentReported(key toml.Key, reported map[string]bool) bool {
+	for i := 1; i < len(key); i++ {
+		if reported[key[:i].String()] {
+			return true
+		}
+	}
+	return false
+}
+
+// tableType returns the type decoding the table at parent: a struct, or an
+// interface that takes any key below it. It returns nil when t has no field
+// for parent.
+func tableType(t reflect.Type, parent []string) reflect.Type {
+	for _, name := range parent {
+		switch t = elem(t); t.Kind() {
+		case reflect.Map:
+			t = t.Elem() // keyed entries, such as [container_registry.host_auth."ghcr.io"]
+		case reflect.Struct:
+			field, ok := fieldByKey(t, name)
+			if !ok {
+				return nil
+			}
+			t = field.Type
+		default:
+			return t
+		}
+	}
+	return elem(t)
+}
+
+// elem returns what pointers, slices and arrays of t hold
+func elem(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	return t
+}
+
+// knownKeys returns the TOML keys struct t accepts
+func knownKeys(t reflect.Type) []string {
+	var keys []string
+	for i := range t.NumField() {
+		if key := tomlKey(t.Field(i)); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// fieldByKey finds the field of struct t that decodes key
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := range t.NumField() {
+		if field := t.Field(i); tomlKey(field) == key {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// tomlKey returns the key a struct field decodes, or "" for skipped fields
+func tomlKey(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return field.Name
+	}
+	return name
+}
+
+// closestKey returns the candidate within two edits of key, or "" when none is
+func closestKey(candidates []string, key string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if d := editDistance(strings.ToLower(key), candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,44 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigLoader_UnknownKeys(t *testing.T) {
+	chdirTemp(t)
+	writeFiles(t, map[string]string{
+		"config/defaults.toml": `domain = "example.com"
+
+[network]
+timezone = "UTC"
+dns_server = ["1.1.1.1"]
+
+[container_registry.host_auth."ghcr.io"]
+usernme = "robot"
+
+[monitoring]
+enabled = true
+`,
+		"machines/web/machine.toml": "name = \"web\"\ncontainer_imag = \"ghcr.io/me/web\"\n\n[vars]\nport = 8080\n\n[vars.storage]\npath = \"/srv\"\n",
+	})
+
+	loader := NewConfigLoader()
+	err := loader.LoadDefaults()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "config/defaults.toml:5: unknown key 'network.dns_server' (did you mean 'network.dns_servers'?)")
+	assert.Contains(t, err.Error(), `config/defaults.toml:8: unknown key 'container_registry.host_auth."ghcr.io".usernme' (did you mean 'container_registry.host_auth."ghcr.io".username'?)`)
+	assert.Contains(t, err.Error(), "config/defaults.toml:10: unknown key 'monitoring'")
+	assert.NotContains(t, err.Error(), "monitoring.enabled", "an unknown table is reported once")
+
+	writeFiles(t, map[string]string{"config/defaults.toml": "domain = \"example.com\"\n"})
+	require.NoError(t, loader.LoadDefaults())
+	err = loader.LoadMachines()
+	require.Error(t, err)
+	assert.Equal(t, "machines/web/machine.toml:2: unknown key 'container_imag' (did you mean 'container_image'?)", err.Error(), "[vars] takes any key")
+
+	writeFiles(t, map[string]string{"machines/web/machine.toml": "name = \"web\"\ntags = [\"a\"\n"})
+	assert.ErrorContains(t, loader.LoadMachines(), "failed to parse machines/web/machine.toml:2: expected a comma")
+}
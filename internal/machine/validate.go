@@ -0,0 +1,102 @@
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	_ "time/tzdata" // Timezones validate the same on hosts without a zoneinfo database
+)
+
+var (
+	clockTime        = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+	clockTimeSeconds = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9](:[0-5][0-9])?$`)
+)
+
+// ValidateRequired checks for the machine.toml settings every machine needs.
+// The FQDN may come from the defaults' or site's domain instead.
+func ValidateRequired(m Config) error {
+	var errs []error
+	if m.FQDN == "" {
+		errs = append(errs, fmt.Errorf("fqdn is required (set fqdn in machine.toml or domain in the defaults)"))
+	}
+	if m.ContainerImage == "" {
+		errs = append(errs, fmt.Errorf("container_image is required"))
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateFormats checks defaults whose format would otherwise only be
+// noticed on the machine: [network] timezone, [updates] reboot_time (HH:MM)
+// and [bootc] update_time (HH:MM or HH:MM:SS)
+func ValidateFormats(defaults Defaults) error {
+	var errs []error
+	if tz := defaults.Network.Timezone; tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil || tz == "Local" {
+			errs = append(errs, fmt.Errorf("invalid [network] timezone %q (expected an IANA name such as \"America/New_York\")", tz))
+		}
+	}
+	if t := defaults.Updates.RebootTime; t != "" && !clockTime.MatchString(t) {
+		errs = append(errs, fmt.Errorf("invalid [updates] reboot_time %q (expected HH:MM)", t))
+	}
+	if t := defaults.Bootc.UpdateTime; t != "" && !clockTimeSeconds.MatchString(t) {
+		errs = append(errs, fmt.Errorf("invalid [bootc] update_time %q (expected HH:MM or HH:MM:SS)", t))
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateFleet checks rules that span machines: duplicate FQDNs, MAC
+// addresses and IP addresses, and images in the configured registry that no
+// container directory builds
+func ValidateFleet(machines []Config, workloads []WorkloadDefinition, registryURL string) []error {
+	var errs []error
+
+	errs = append(errs, duplicates(machines, "FQDN", func(m Config) string { return strings.ToLower(m.FQDN) })...)
+	errs = append(errs, duplicates(machines, "MAC address", func(m Config) string { return strings.ToLower(m.MACAddress) })...)
+	errs = append(errs, duplicates(machines, "IP address", func(m Config) string { return m.IPAddress })...)
+
+	built := make(map[string]bool, len(workloads))
+	for _, w := range workloads {
+		built[w.ContainerImage] = true
+	}
+
+	prefix := strings.TrimSuffix(registryURL, "/") + "/"
+	for _, m := range machines {
+		// Images outside our registry are external and built elsewhere
+		if m.ContainerImage == "" || registryURL == "" || !strings.HasPrefix(m.ContainerImage, prefix) {
+			continue
+		}
+		if !built[m.ContainerImage] {
+			workloadName := strings.TrimPrefix(m.ContainerImage, prefix)
+			errs = append(errs, fmt.Errorf("machine %s: image %s is not built by any container directory (expected containers/%s)", m.Name, m.ContainerImage, workloadName))
+		}
+	}
+
+	return errs
+}
+
+// duplicates reports values shared by more than one machine, ignoring empty values
+func duplicates(machines []Config, label string, value func(Config) string) []error {
+	owners := make(map[string][]string)
+	for _, m := range machines {
+		if v := value(m); v != "" {
+			owners[v] = append(owners[v], m.Name)
+		}
+	}
+
+	var values []string
+	for v, names := range owners {
+		if len(names) > 1 {
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+
+	var errs []error
+	for _, v := range values {
+		errs = append(errs, fmt.Errorf("duplicate %s %s used by machines %s", label, v, strings.Join(owners[v], ", ")))
+	}
+	return errs
+}
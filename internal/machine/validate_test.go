@@ -0,0 +1,68 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateFleet_Valid(t *testing.T) {
+	machines := []Config{
+		{Name: "web", FQDN: "web.example.com", MACAddress: "02:05:56:00:00:01", IPAddress: "10.0.0.1", ContainerImage: "ghcr.io/test/web"},
+		{Name: "db", FQDN: "db.example.com", MACAddress: "02:05:56:00:00:02", ContainerImage: "docker.io/library/postgres"},
+		{Name: "bare", FQDN: "bare.example.com"},
+	}
+	workloads := []WorkloadDefinition{{Name: "web", ContainerImage: "ghcr.io/test/web"}}
+
+	assert.Empty(t, ValidateFleet(machines, workloads, "ghcr.io/test"))
+}
+
+func TestValidateFleet_Duplicates(t *testing.T) {
+	machines := []Config{
+		{Name: "a", FQDN: "a.example.com", MACAddress: "02:05:56:00:00:01", IPAddress: "10.0.0.1"},
+		{Name: "b", FQDN: "A.example.com", MACAddress: "02:05:56:00:00:0A", IPAddress: "10.0.0.1"},
+		{Name: "c", FQDN: "c.example.com", MACAddress: "02:05:56:00:00:0a"},
+	}
+
+	errs := ValidateFleet(machines, nil, "")
+	assert.Len(t, errs, 3)
+	assert.EqualError(t, errs[0], "duplicate FQDN a.example.com used by machines a, b")
+	assert.EqualError(t, errs[1], "duplicate MAC address 02:05:56:00:00:0a used by machines b, c")
+	assert.EqualError(t, errs[2], "duplicate IP address 10.0.0.1 used by machines a, b")
+}
+
+func TestValidateFleet_UnbuiltImage(t *testing.T) {
+	machines := []Config{{Name: "web", FQDN: "web.example.com", ContainerImage: "ghcr.io/test/wbe"}}
+
+	errs := ValidateFleet(machines, []WorkloadDefinition{{Name: "web", ContainerImage: "ghcr.io/test/web"}}, "ghcr.io/test/")
+	assert.Len(t, errs, 1)
+	assert.ErrorContains(t, errs[0], "expected containers/wbe")
+}
+
+func TestValidateRequired(t *testing.T) {
+	assert.NoError(t, ValidateRequired(Config{Name: "web", FQDN: "web.example.com", ContainerImage: "ghcr.io/me/web"}))
+
+	err := ValidateRequired(Config{Name: "web"})
+	assert.ErrorContains(t, err, "fqdn is required")
+	assert.ErrorContains(t, err, "container_image is required")
+}
+
+func TestValidateFormats(t *testing.T) {
+	valid := Defaults{
+		Network: NetworkConfig{Timezone: "America/New_York"},
+		Updates: UpdateConfig{RebootTime: "03:00"},
+		Bootc:   BootcConfig{UpdateTime: "02:00:00"},
+	}
+	assert.NoError(t, ValidateFormats(valid))
+	assert.NoError(t, ValidateFormats(Defaults{}))
+
+	err := ValidateFormats(Defaults{
+		Network: NetworkConfig{Timezone: "America/Nowhere"},
+		Updates: UpdateConfig{RebootTime: "3am"},
+		Bootc:   BootcConfig{UpdateTime: "24:00"},
+	})
+	assert.ErrorContains(t, err, `invalid [network] timezone "America/Nowhere"`)
+	assert.ErrorContains(t, err, `invalid [updates] reboot_time "3am" (expected HH:MM)`)
+	assert.ErrorContains(t, err, `invalid [bootc] update_time "24:00"`)
+	assert.ErrorContains(t, ValidateFormats(Defaults{Updates: UpdateConfig{RebootTime: "03:00:00"}}), "reboot_time")
+}
@@ -0,0 +1,88 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/andreweick/iago/internal/humanize"
+)
+
+// Step is a single named stage of a pipeline
+type Step struct {
+	Name        string
+	Description string
+	Run         func(ctx context.Context) error
+}
+
+// Pipeline runs steps in order with progress output
+type Pipeline struct {
+	Steps []Step
+	Out   io.Writer
+	// OnStepComplete is called after each successful step (e.g. to persist progress)
+	OnStepComplete func(step string)
+}
+
+// StepNames returns the names of all steps in order
+func (p *Pipeline) StepNames() []string {
+	names := make([]string, len(p.Steps))
+	for i, step := range p.Steps {
+		names[i] = step.Name
+	}
+	return names
+}
+
+// Run executes the pipeline starting at the step named from (or the first step if empty)
+func (p *Pipeline) Run(ctx context.Context, from string) error {
+	start := 0
+	if from != "" {
+		start = -1
+		for i, step := range p.Steps {
+			if step.Name == from {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			return fmt.Errorf("unknown step '%s' (valid steps: %s)", from, strings.Join(p.StepNames(), ", "))
+		}
+	}
+
+	total := len(p.Steps)
+	for i, step := range p.Steps {
+		if i < start {
+			fmt.Fprintf(p.Out, "[%d/%d] %-8s skipped (--from %s)\n", i+1, total, step.Name, from)
+			continue
+		}
+
+		fmt.Fprintf(p.Out, "[%d/%d] %-8s %s\n", i+1, total, step.Name, step.Description)
+		started := time.Now()
+		if err := step.Run(ctx); err != nil {
+			fmt.Fprintf(p.Out, "      ❌ %s failed after %s\n", step.Name, humanize.Duration(time.Since(started)))
+			return &StepError{Step: step.Name, Err: err}
+		}
+		fmt.Fprintf(p.Out, "      ✓ %s done in %s\n", step.Name, humanize.Duration(time.Since(started)))
+
+		if p.OnStepComplete != nil {
+			p.OnStepComplete(step.Name)
+		}
+	}
+
+	return nil
+}
+
+// StepError identifies which step failed so callers can suggest resuming
+type StepError struct {
+	Step string
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("step '%s' failed: %v", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
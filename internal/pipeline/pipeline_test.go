@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPipeline(ran *[]string, failAt string) *Pipeline {
+	step := func(name string) Step {
+		return Step{
+			Name:        name,
+			Description: "running " + name,
+			Run: func(ctx context.Context) error {
+				*ran = append(*ran, name)
+				if name == failAt {
+					return errors.New("boom")
+				}
+				return nil
+			},
+		}
+	}
+	return &Pipeline{
+		Steps: []Step{step("build"), step("ignite"), step("deploy"), step("wait")},
+		Out:   &bytes.Buffer{},
+	}
+}
+
+func TestPipeline_RunAll(t *testing.T) {
+	var ran, completed []string
+	p := newTestPipeline(&ran, "")
+	p.OnStepComplete = func(step string) { completed = append(completed, step) }
+
+	require.NoError(t, p.Run(context.Background(), ""))
+	assert.Equal(t, []string{"build", "ignite", "deploy", "wait"}, ran)
+	assert.Equal(t, ran, completed)
+	assert.Contains(t, p.Out.(*bytes.Buffer).String(), "[1/4] build")
+}
+
+func TestPipeline_From(t *testing.T) {
+	var ran []string
+	p := newTestPipeline(&ran, "")
+
+	require.NoError(t, p.Run(context.Background(), "deploy"))
+	assert.Equal(t, []string{"deploy", "wait"}, ran)
+	assert.Contains(t, p.Out.(*bytes.Buffer).String(), "skipped (--from deploy)")
+}
+
+func TestPipeline_UnknownFrom(t *testing.T) {
+	var ran []string
+	p := newTestPipeline(&ran, "")
+
+	err := p.Run(context.Background(), "publish")
+	assert.ErrorContains(t, err, "unknown step 'publish' (valid steps: build, ignite, deploy, wait)")
+	assert.Empty(t, ran)
+}
+
+func TestPipeline_StopsOnFailure(t *testing.T) {
+	var ran []string
+	p := newTestPipeline(&ran, "ignite")
+
+	err := p.Run(context.Background(), "")
+	var stepErr *StepError
+	require.True(t, errors.As(err, &stepErr))
+	assert.Equal(t, "ignite", stepErr.Step)
+	assert.Equal(t, []string{"build", "ignite"}, ran)
+}
+
+func TestWaitForTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.NoError(t, WaitForTCP(ctx, listener.Addr().String(), 50*time.Millisecond))
+
+	addr := listener.Addr().String()
+	listener.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.ErrorContains(t, WaitForTCP(ctx, addr, 50*time.Millisecond), "timed out waiting for")
+}
@@ -0,0 +1,26 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// WaitForTCP polls addr until a TCP connection succeeds or the context expires
+func WaitForTCP(ctx context.Context, addr string, interval time.Duration) error {
+	dialer := &net.Dialer{Timeout: interval}
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %s: %w", addr, err)
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/cel-go/cel"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultDir holds the repository's policy files
+const DefaultDir = "policies"
+
+// Policy is a CEL expression that must evaluate to true for every machine
+type Policy struct {
+	Name        string   `toml:"name"`
+	Description string   `toml:"description"`
+	Expr        string   `toml:"expr"`
+	Machines    []string `toml:"machines,omitempty"` // Limit the policy to these machines (default: all)
+	Source      string   `toml:"-"`                  // File the policy was loaded from
+}
+
+type policyFile struct {
+	Policies []Policy `toml:"policy"`
+}
+
+// Input is what a policy is evaluated against. Expressions see it as the
+// variables machine, butane and ignition.
+type Input struct {
+	Machine  interface{} // the machine's machine.toml settings
+	Butane   string      // rendered butane YAML
+	Ignition []byte      // generated ignition JSON
+}
+
+// Violation reports a policy that did not hold for a machine
+type Violation struct {
+	Policy      string
+	Description string
+	Machine     string
+	Source      string
+}
+
+func (v Violation) String() string {
+	msg := fmt.Sprintf("%s: policy '%s' violated", v.Machine, v.Policy)
+	if v.Description != "" {
+		msg += ": " + v.Description
+	}
+	return msg + fmt.Sprintf(" (%s)", v.Source)
+}
+
+// Load reads every *.toml file in dir, returning no policies if dir doesn't exist
+func Load(dir string) ([]Policy, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list policies: %w", err)
+	}
+	sort.Strings(paths)
+
+	var policies []Policy
+	for _, path := range paths {
+		var file policyFile
+		if _, err := toml.DecodeFile(path, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		for _, p := range file.Policies {
+			if p.Name == "" || p.Expr == "" {
+				return nil, fmt.Errorf("%s: every [[policy]] needs a name and expr", path)
+			}
+			p.Source = path
+			policies = append(policies, p)
+		}
+	}
+	return policies, nil
+}
+
+type compiled struct {
+	Policy
+	program cel.Program
+}
+
+// Engine evaluates a compiled set of policies
+type Engine struct {
+	policies []compiled
+}
+
+// NewEngine compiles policies, failing on the first expression that doesn't
+// type-check as a boolean
+func NewEngine(policies []Policy) (*Engine, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("machine", cel.DynType),
+		cel.Variable("butane", cel.DynType),
+		cel.Variable("ignition", cel.DynType),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create policy environment: %w", err)
+	}
+
+	e := &Engine{}
+	for _, p := range policies {
+		ast, issues := env.Compile(p.Expr)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("policy '%s' (%s): %w", p.Name, p.Source, issues.Err())
+		}
+		if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+			return nil, fmt.Errorf("policy '%s' (%s): expression must return a bool, got %s", p.Name, p.Source, ast.OutputType())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("policy '%s' (%s): %w", p.Name, p.Source, err)
+		}
+		e.policies = append(e.policies, compiled{Policy: p, program: program})
+	}
+	return e, nil
+}
+
+// Len returns the number of policies in the engine
+func (e *Engine) Len() int {
+	return len(e.policies)
+}
+
+// Evaluate runs every policy that applies to machineName against input
+func (e *Engine) Evaluate(machineName string, input Input) ([]Violation, error) {
+	vars, err := input.variables()
+	if err != nil {
+		return nil, err
+	}
+
+	var violations []Violation
+	for _, p := range e.policies {
+		if !p.appliesTo(machineName) {
+			continue
+		}
+
+		out, _, err := p.program.Eval(vars)
+		if err != nil {
+			return nil, fmt.Errorf("policy '%s' failed for %s: %w", p.Name, machineName, err)
+		}
+		passed, ok := out.Value().(bool)
+		if !ok {
+			return nil, fmt.Errorf("policy '%s' returned %v for %s, expected a bool", p.Name, out.Value(), machineName)
+		}
+		if !passed {
+			violations = append(violations, Violation{
+				Policy:      p.Name,
+				Description: p.Description,
+				Machine:     machineName,
+				Source:      p.Source,
+			})
+		}
+	}
+	return violations, nil
+}
+
+func (p compiled) appliesTo(machineName string) bool {
+	if len(p.Machines) == 0 {
+		return true
+	}
+	for _, name := range p.Machines {
+		if name == machineName {
+			return true
+		}
+	}
+	return false
+}
+
+func (in Input) variables() (map[string]interface{}, error) {
+	machine, err := toMap(in.Machine)
+	if err != nil {
+		return nil, err
+	}
+
+	var butane interface{}
+	if err := yaml.Unmarshal([]byte(in.Butane), &butane); err != nil {
+		return nil, fmt.Errorf("failed to parse rendered butane: %w", err)
+	}
+
+	var ignition interface{}
+	if len(in.Ignition) > 0 {
+		if err := json.Unmarshal(in.Ignition, &ignition); err != nil {
+			return nil, fmt.Errorf("failed to parse ignition: %w", err)
+		}
+	}
+
+	return map[string]interface{}{
+		"machine":  machine,
+		"butane":   orEmpty(butane),
+		"ignition": orEmpty(integers(ignition)),
+	}, nil
+}
+
+// toMap converts a TOML-tagged struct to a map keyed like machine.toml
+func toMap(v interface{}) (map[string]interface{}, error) {
+	if v == nil {
+		return map[string]interface{}{}, nil
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, fmt.Errorf("failed to encode machine for policy input: %w", err)
+	}
+	result := map[string]interface{}{}
+	if _, err := toml.Decode(buf.String(), &result); err != nil {
+		return nil, fmt.Errorf("failed to decode machine for policy input: %w", err)
+	}
+	return result, nil
+}
+
+// integers turns whole JSON numbers back into ints so policies can compare
+// modes and ports without float literals (e.g. f.mode <= 420)
+func integers(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, child := range value {
+			value[k] = integers(child)
+		}
+	case []interface{}:
+		for i, child := range value {
+			value[i] = integers(child)
+		}
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < 1<<53 {
+			return int64(value)
+		}
+	}
+	return v
+}
+
+func orEmpty(v interface{}) interface{} {
+	if v == nil {
+		return map[string]interface{}{}
+	}
+	return v
+}
+
+// LoadEngine loads and compiles the policies in dir
+func LoadEngine(dir string) (*Engine, error) {
+	policies, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	return NewEngine(policies)
+}
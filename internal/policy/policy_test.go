@@ -0,0 +1,112 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testMachine struct {
+	Name           string `toml:"name"`
+	ContainerImage string `toml:"container_image,omitempty"`
+}
+
+const testButane = `variant: fcos
+version: 1.5.0
+systemd:
+  units:
+    - name: app.service
+      contents: |
+        [Service]
+        ExecStart=/usr/bin/podman run --privileged ghcr.io/andreweick/app
+`
+
+const testIgnition = `{
+  "ignition": {"version": "3.4.0"},
+  "storage": {"files": [
+    {"path": "/etc/app.conf", "mode": 420},
+    {"path": "/etc/secret", "mode": 438}
+  ]}
+}`
+
+func writePolicies(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "base.toml"), []byte(content), 0644))
+	return dir
+}
+
+func TestEvaluate(t *testing.T) {
+	dir := writePolicies(t, `
+[[policy]]
+name = "no-privileged"
+description = "containers must not run --privileged"
+expr = "!butane.systemd.units.exists(u, has(u.contents) && u.contents.contains('--privileged'))"
+
+[[policy]]
+name = "etc-modes"
+description = "files under /etc must be 0644 or stricter"
+expr = "ignition.storage.files.all(f, !f.path.startsWith('/etc/') || f.mode <= 420)"
+
+[[policy]]
+name = "trusted-images"
+expr = "machine.container_image.startsWith('ghcr.io/andreweick/')"
+machines = ["other"]
+`)
+
+	engine, err := LoadEngine(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 3, engine.Len())
+
+	violations, err := engine.Evaluate("web", Input{
+		Machine:  testMachine{Name: "web", ContainerImage: "docker.io/library/nginx"},
+		Butane:   testButane,
+		Ignition: []byte(testIgnition),
+	})
+	require.NoError(t, err)
+
+	require.Len(t, violations, 2, "trusted-images only applies to 'other'")
+	assert.Equal(t, "no-privileged", violations[0].Policy)
+	assert.Equal(t, "etc-modes", violations[1].Policy)
+	assert.Contains(t, violations[1].String(), "web: policy 'etc-modes' violated: files under /etc must be 0644 or stricter")
+	assert.Contains(t, violations[1].String(), "base.toml")
+}
+
+func TestEvaluate_Passing(t *testing.T) {
+	dir := writePolicies(t, `
+[[policy]]
+name = "trusted-images"
+expr = "machine.container_image.startsWith('ghcr.io/andreweick/')"
+`)
+
+	engine, err := LoadEngine(dir)
+	require.NoError(t, err)
+
+	violations, err := engine.Evaluate("web", Input{
+		Machine: testMachine{Name: "web", ContainerImage: "ghcr.io/andreweick/web"},
+		Butane:  testButane,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, violations)
+}
+
+func TestLoad_MissingDir(t *testing.T) {
+	policies, err := Load(filepath.Join(t.TempDir(), "policies"))
+
+	require.NoError(t, err)
+	assert.Empty(t, policies)
+}
+
+func TestNewEngine_Errors(t *testing.T) {
+	_, err := LoadEngine(writePolicies(t, "[[policy]]\nname = \"x\"\n"))
+	assert.ErrorContains(t, err, "needs a name and expr")
+
+	_, err = LoadEngine(writePolicies(t, "[[policy]]\nname = \"x\"\nexpr = \"machine.name +\"\n"))
+	assert.ErrorContains(t, err, "policy 'x'")
+
+	_, err = LoadEngine(writePolicies(t, "[[policy]]\nname = \"x\"\nexpr = \"1 + 2\"\n"))
+	assert.ErrorContains(t, err, "must return a bool")
+}
@@ -0,0 +1,121 @@
+package pxe
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/andreweick/iago/internal/machine"
+)
+
+// DefaultArch is the architecture netbooted when [pxe] arch is unset
+const DefaultArch = "x86_64"
+
+// buildsURL is where Fedora CoreOS publishes release artifacts
+const buildsURL = "https://builds.coreos.fedoraproject.org/prod/streams"
+
+// Boot is what a netbooting machine loads: the live PXE kernel and initramfs,
+// and the kernel arguments that point it at the rootfs and its ignition
+type Boot struct {
+	Kernel     string
+	Initramfs  string
+	KernelArgs []string
+}
+
+// NewBoot resolves the artifacts and kernel arguments for m, which fetches its
+// ignition from ignitionURL. With install_device set the live system installs
+// Fedora CoreOS to that disk and reboots into it; otherwise it runs from RAM
+// and applies the ignition itself.
+func NewBoot(m machine.Config, cfg machine.PXEConfig, stream, ignitionURL string) (Boot, error) {
+	if ignitionURL == "" {
+		return Boot{}, fmt.Errorf("no ignition URL: set [pxe] base_url in defaults.toml or pass --base-url")
+	}
+	arch := cfg.Arch
+	if arch == "" {
+		arch = DefaultArch
+	}
+	base := cfg.ArtifactsURL
+	if base == "" {
+		if stream == "" {
+			stream = "stable"
+		}
+		base = fmt.Sprintf("%s/%s/builds/%s/%s", buildsURL, stream, cfg.Version, arch)
+	}
+	artifact := func(name, pattern string) (string, error) {
+		if name == "" {
+			if cfg.Version == "" {
+				return "", fmt.Errorf("set [pxe] version to the Fedora CoreOS release to netboot, or name the %s artifact", pattern)
+			}
+			name = fmt.Sprintf("fedora-coreos-%s-live-%s", cfg.Version, strings.ReplaceAll(pattern, "ARCH", arch))
+		}
+		if strings.Contains(name, "://") {
+			return name, nil
+		}
+		return strings.TrimRight(base, "/") + "/" + name, nil
+	}
+
+	kernel, err := artifact(cfg.Kernel, "kernel-ARCH")
+	if err != nil {
+		return Boot{}, err
+	}
+	initramfs, err := artifact(cfg.Initramfs, "initramfs.ARCH.img")
+	if err != nil {
+		return Boot{}, err
+	}
+	rootfs, err := artifact(cfg.Rootfs, "rootfs.ARCH.img")
+	if err != nil {
+		return Boot{}, err
+	}
+
+	args := []string{"coreos.live.rootfs_url=" + rootfs}
+	if cfg.InstallDevice != "" {
+		args = append(args, "coreos.inst.install_dev="+cfg.InstallDevice, "coreos.inst.ignition_url="+ignitionURL)
+	} else {
+		args = append(args, "ignition.firstboot", "ignition.platform.id=metal", "ignition.config.url="+ignitionURL)
+	}
+	args = append(args, cfg.KernelArgs...)
+	return Boot{Kernel: kernel, Initramfs: initramfs, KernelArgs: args}, nil
+}
+
+// IgnitionURL is where m's ignition is served below baseURL
+func IgnitionURL(baseURL string, m machine.Config) string {
+	if baseURL == "" {
+		return ""
+	}
+	return strings.TrimRight(baseURL, "/") + "/" + url.PathEscape(m.Name) + ".ign"
+}
+
+// Script returns the iPXE script that boots b
+func (b Boot) Script() string {
+	var s strings.Builder
+	s.WriteString("#!ipxe\n")
+	fmt.Fprintf(&s, "kernel %s initrd=main %s\n", b.Kernel, strings.Join(b.KernelArgs, " "))
+	fmt.Fprintf(&s, "initrd --name main %s\n", b.Initramfs)
+	s.WriteString("boot\n")
+	return s.String()
+}
+
+// Handler serves one machine's ignition at /<name>.ign and iPXE script at
+// /<name>.ipxe, and nothing else
+func Handler(name string, ignition, script []byte, logf func(format string, args ...any)) http.Handler {
+	files := map[string]struct {
+		content     []byte
+		contentType string
+	}{
+		"/" + name + ".ign":  {ignition, "application/vnd.coreos.ignition+json"},
+		"/" + name + ".ipxe": {script, "text/plain; charset=utf-8"},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, ok := files[r.URL.Path]
+		if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			logf("%s %s %s: not served\n", r.RemoteAddr, r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		logf("%s %s %s\n", r.RemoteAddr, r.Method, r.URL.Path)
+		w.Header().Set("Content-Type", file.contentType)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(file.content)
+	})
+}
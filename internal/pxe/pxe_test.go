@@ -0,0 +1,82 @@
+package pxe
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBoot(t *testing.T) {
+	m := machine.Config{Name: "nas"}
+	ignitionURL := IgnitionURL("http://10.0.0.5:8080/", m)
+	assert.Equal(t, "http://10.0.0.5:8080/nas.ign", ignitionURL)
+
+	boot, err := NewBoot(m, machine.PXEConfig{Version: "40.20240416.3.1", KernelArgs: []string{"console=ttyS0"}}, "stable", ignitionURL)
+	require.NoError(t, err)
+	base := "https://builds.coreos.fedoraproject.org/prod/streams/stable/builds/40.20240416.3.1/x86_64/fedora-coreos-40.20240416.3.1-live-"
+	assert.Equal(t, base+"kernel-x86_64", boot.Kernel)
+	assert.Equal(t, base+"initramfs.x86_64.img", boot.Initramfs)
+	assert.Equal(t, []string{
+		"coreos.live.rootfs_url=" + base + "rootfs.x86_64.img",
+		"ignition.firstboot",
+		"ignition.platform.id=metal",
+		"ignition.config.url=http://10.0.0.5:8080/nas.ign",
+		"console=ttyS0",
+	}, boot.KernelArgs)
+
+	assert.Equal(t, "#!ipxe\n"+
+		"kernel "+base+"kernel-x86_64 initrd=main coreos.live.rootfs_url="+base+"rootfs.x86_64.img ignition.firstboot ignition.platform.id=metal ignition.config.url=http://10.0.0.5:8080/nas.ign console=ttyS0\n"+
+		"initrd --name main "+base+"initramfs.x86_64.img\n"+
+		"boot\n", boot.Script())
+}
+
+func TestNewBoot_InstallFromMirror(t *testing.T) {
+	cfg := machine.PXEConfig{
+		ArtifactsURL:  "http://mirror.lab/fcos/",
+		Arch:          "aarch64",
+		Kernel:        "kernel",
+		Initramfs:     "initramfs.img",
+		Rootfs:        "https://cdn.lab/rootfs.img",
+		InstallDevice: "/dev/nvme0n1",
+	}
+	boot, err := NewBoot(machine.Config{Name: "pi"}, cfg, "", "http://10.0.0.5/pi.ign")
+	require.NoError(t, err)
+	assert.Equal(t, "http://mirror.lab/fcos/kernel", boot.Kernel)
+	assert.Equal(t, "http://mirror.lab/fcos/initramfs.img", boot.Initramfs)
+	assert.Equal(t, []string{
+		"coreos.live.rootfs_url=https://cdn.lab/rootfs.img",
+		"coreos.inst.install_dev=/dev/nvme0n1",
+		"coreos.inst.ignition_url=http://10.0.0.5/pi.ign",
+	}, boot.KernelArgs)
+
+	_, err = NewBoot(machine.Config{Name: "pi"}, machine.PXEConfig{}, "stable", "http://10.0.0.5/pi.ign")
+	assert.ErrorContains(t, err, "set [pxe] version")
+	_, err = NewBoot(machine.Config{Name: "pi"}, machine.PXEConfig{Version: "40.20240416.3.1"}, "stable", "")
+	assert.ErrorContains(t, err, "no ignition URL")
+}
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(Handler("nas", []byte(`{"ignition":{}}`), []byte("#!ipxe\n"), func(string, ...any) {}))
+	defer server.Close()
+
+	for path, expected := range map[string]string{"/nas.ign": `{"ignition":{}}`, "/nas.ipxe": "#!ipxe\n"} {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, expected, string(body))
+	}
+
+	for _, path := range []string{"/other.ign", "/", "/../output/ignition/nas.ign"} {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+	}
+}
@@ -0,0 +1,306 @@
+package sbom
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SBOM formats
+const (
+	FormatCycloneDX = "cyclonedx"
+	FormatSPDX      = "spdx"
+)
+
+// Media types of each format's JSON document, used as the artifact type of
+// SBOMs attached to images
+const (
+	MediaTypeCycloneDX = "application/vnd.cyclonedx+json"
+	MediaTypeSPDX      = "application/spdx+json"
+)
+
+// ValidateFormat checks that format is a supported SBOM format
+func ValidateFormat(format string) error {
+	if format != FormatCycloneDX && format != FormatSPDX {
+		return fmt.Errorf("unknown SBOM format %q, expected %s or %s", format, FormatCycloneDX, FormatSPDX)
+	}
+	return nil
+}
+
+// MediaType returns the media type of format's documents
+func MediaType(format string) string {
+	if format == FormatSPDX {
+		return MediaTypeSPDX
+	}
+	return MediaTypeCycloneDX
+}
+
+// Extension returns the conventional file extension of format's documents
+func Extension(format string) string {
+	if format == FormatSPDX {
+		return ".spdx.json"
+	}
+	return ".cdx.json"
+}
+
+// Subject is the image an SBOM describes
+type Subject struct {
+	Name   string // e.g. registry.lab:5000/web
+	Digest string // Manifest digest, e.g. sha256:...
+}
+
+// Encode writes inv as an SBOM document in format, describing subject and
+// created at the given time
+func (inv *Inventory) Encode(format string, subject Subject, created time.Time) ([]byte, error) {
+	if err := ValidateFormat(format); err != nil {
+		return nil, err
+	}
+	var doc any
+	if format == FormatSPDX {
+		doc = inv.spdx(subject, created)
+	} else {
+		doc = inv.cycloneDX(subject, created)
+	}
+	// Package URLs join qualifiers with '&', which shouldn't read as \u0026
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return nil, fmt.Errorf("failed to encode SBOM: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// PURL returns the package URL of p, installed in distro
+func (p Package) PURL(distro Distro) string {
+	namespace, name := "", p.Name
+	qualifiers := url.Values{}
+	switch p.Type {
+	case TypeDeb, TypeRPM, TypeAPK:
+		namespace = distro.ID
+		if namespace == "" && p.Type == TypeAPK {
+			namespace = "alpine"
+		}
+		if p.Arch != "" {
+			qualifiers.Set("arch", p.Arch)
+		}
+		if distro.ID != "" && distro.VersionID != "" {
+			qualifiers.Set("distro", distro.ID+"-"+distro.VersionID)
+		}
+	case TypeGolang:
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			namespace, name = name[:i], name[i+1:]
+		}
+	case TypeNPM:
+		if scope, rest, ok := strings.Cut(name, "/"); ok && strings.HasPrefix(scope, "@") {
+			namespace, name = scope, rest
+		}
+	case TypePyPI:
+		name = strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+	}
+
+	var b strings.Builder
+	b.WriteString("pkg:" + p.Type + "/")
+	if namespace != "" {
+		for _, segment := range strings.Split(namespace, "/") {
+			b.WriteString(escapePURL(segment) + "/")
+		}
+	}
+	b.WriteString(escapePURL(name))
+	if p.Version != "" {
+		b.WriteString("@" + escapePURL(p.Version))
+	}
+	if len(qualifiers) > 0 {
+		b.WriteString("?" + qualifiers.Encode())
+	}
+	return b.String()
+}
+
+// escapePURL percent-encodes a package URL segment; purl reserves ':' and '@'
+// too, which path escaping leaves alone
+func escapePURL(s string) string {
+	return strings.NewReplacer(":", "%3A", "@", "%40").Replace(url.PathEscape(s))
+}
+
+// documentID is a UUID derived from the subject, so an image's SBOM keeps
+// its identity when regenerated
+func documentID(format string, subject Subject) string {
+	sum := sha256.Sum256([]byte(format + "\x00" + subject.Name + "\x00" + subject.Digest))
+	sum[6] = sum[6]&0x0f | 0x50 // Version 5 style: name based
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+type cdxDocument struct {
+	BOMFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type"`
+	BOMRef     string        `json:"bom-ref,omitempty"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	Licenses   []cdxLicense  `json:"licenses,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxLicense struct {
+	License struct {
+		Name string `json:"name"`
+	} `json:"license"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func (inv *Inventory) cycloneDX(subject Subject, created time.Time) cdxDocument {
+	doc := cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + documentID(FormatCycloneDX, subject),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: created.UTC().Format(time.RFC3339),
+			Tools:     cdxTools{Components: []cdxComponent{{Type: "application", Name: "iago"}}},
+			Component: cdxComponent{Type: "container", BOMRef: subject.Name + "@" + subject.Digest, Name: subject.Name, Version: subject.Digest},
+		},
+		Components: []cdxComponent{},
+	}
+	if inv.Distro.ID != "" {
+		doc.Components = append(doc.Components, cdxComponent{
+			Type:    "operating-system",
+			BOMRef:  "os:" + inv.Distro.ID,
+			Name:    inv.Distro.ID,
+			Version: inv.Distro.VersionID,
+		})
+	}
+	for _, p := range inv.Packages {
+		purl := p.PURL(inv.Distro)
+		c := cdxComponent{
+			Type:       "library",
+			BOMRef:     purl,
+			Name:       p.Name,
+			Version:    p.Version,
+			PURL:       purl,
+			Properties: []cdxProperty{{Name: "iago:package:location", Value: p.Location}},
+		}
+		if p.License != "" {
+			var l cdxLicense
+			l.License.Name = p.License
+			c.Licenses = []cdxLicense{l}
+		}
+		doc.Components = append(doc.Components, c)
+	}
+	return doc
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	SourceInfo       string            `json:"sourceInfo,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+func (inv *Inventory) spdx(subject Subject, created time.Time) spdxDocument {
+	const imageID = "SPDXRef-Image"
+	id := documentID(FormatSPDX, subject)
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              subject.Name + "@" + subject.Digest,
+		DocumentNamespace: "https://spdx.org/spdxdocs/iago/" + url.PathEscape(subject.Name) + "-" + id,
+		CreationInfo: spdxCreationInfo{
+			Created:  created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: iago"},
+		},
+		Packages: []spdxPackage{{
+			Name:             subject.Name,
+			SPDXID:           imageID,
+			VersionInfo:      subject.Digest,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			CopyrightText:    "NOASSERTION",
+		}},
+		Relationships: []spdxRelationship{{SPDXElementID: "SPDXRef-DOCUMENT", RelationshipType: "DESCRIBES", RelatedSPDXElement: imageID}},
+	}
+	for i, p := range inv.Packages {
+		pkgID := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+		// Package databases hold free-form license text, not SPDX expressions
+		doc.Packages = append(doc.Packages, spdxPackage{
+			Name:             p.Name,
+			SPDXID:           pkgID,
+			VersionInfo:      p.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			CopyrightText:    "NOASSERTION",
+			SourceInfo:       "found in " + p.Location,
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  p.PURL(inv.Distro),
+			}},
+		})
+		doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: imageID, RelationshipType: "CONTAINS", RelatedSPDXElement: pkgID})
+	}
+	return doc
+}
@@ -0,0 +1,89 @@
+package sbom
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackage_PURL(t *testing.T) {
+	debian := Distro{ID: "debian", VersionID: "12"}
+	for _, tc := range []struct {
+		pkg    Package
+		distro Distro
+		want   string
+	}{
+		{Package{Type: TypeDeb, Name: "bash", Version: "5.2.15-2+b7", Arch: "amd64"}, debian, "pkg:deb/debian/bash@5.2.15-2+b7?arch=amd64&distro=debian-12"},
+		{Package{Type: TypeRPM, Name: "shadow-utils", Version: "2:4.15.1-1.fc40", Arch: "x86_64"}, Distro{ID: "fedora", VersionID: "40"}, "pkg:rpm/fedora/shadow-utils@2%3A4.15.1-1.fc40?arch=x86_64&distro=fedora-40"},
+		{Package{Type: TypeAPK, Name: "musl", Version: "1.2.5-r0"}, Distro{}, "pkg:apk/alpine/musl@1.2.5-r0"},
+		{Package{Type: TypeGolang, Name: "github.com/spf13/cobra", Version: "v1.8.0"}, debian, "pkg:golang/github.com/spf13/cobra@v1.8.0"},
+		{Package{Type: TypeGolang, Name: "stdlib", Version: "go1.22.1"}, debian, "pkg:golang/stdlib@go1.22.1"},
+		{Package{Type: TypeNPM, Name: "@types/node", Version: "20.11.5"}, debian, "pkg:npm/%40types/node@20.11.5"},
+		{Package{Type: TypePyPI, Name: "Requests_OAuthlib", Version: "1.3.1"}, debian, "pkg:pypi/requests-oauthlib@1.3.1"},
+	} {
+		assert.Equal(t, tc.want, tc.pkg.PURL(tc.distro))
+	}
+}
+
+func TestInventory_Encode(t *testing.T) {
+	inv := &Inventory{
+		Distro: Distro{ID: "alpine", VersionID: "3.20.0"},
+		Packages: []Package{
+			{Type: TypeAPK, Name: "musl", Version: "1.2.5-r0", Arch: "x86_64", License: "MIT", Location: "lib/apk/db/installed"},
+		},
+	}
+	subject := Subject{Name: "registry.lab:5000/web", Digest: "sha256:abc"}
+	created := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("cyclonedx", func(t *testing.T) {
+		out, err := inv.Encode(FormatCycloneDX, subject, created)
+		require.NoError(t, err)
+		var doc map[string]any
+		require.NoError(t, json.Unmarshal(out, &doc))
+		assert.Equal(t, "CycloneDX", doc["bomFormat"])
+		assert.Equal(t, "1.5", doc["specVersion"])
+		assert.Regexp(t, `^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, doc["serialNumber"])
+		metadata := doc["metadata"].(map[string]any)
+		assert.Equal(t, "2026-10-01T12:00:00Z", metadata["timestamp"])
+		assert.Equal(t, "sha256:abc", metadata["component"].(map[string]any)["version"])
+
+		components := doc["components"].([]any)
+		require.Len(t, components, 2)
+		assert.Equal(t, "operating-system", components[0].(map[string]any)["type"])
+		musl := components[1].(map[string]any)
+		assert.Equal(t, "pkg:apk/alpine/musl@1.2.5-r0?arch=x86_64&distro=alpine-3.20.0", musl["purl"])
+		assert.Equal(t, "MIT", musl["licenses"].([]any)[0].(map[string]any)["license"].(map[string]any)["name"])
+
+		// Regenerating the SBOM of the same image keeps its serial number
+		again, err := inv.Encode(FormatCycloneDX, subject, created.Add(time.Hour))
+		require.NoError(t, err)
+		var doc2 map[string]any
+		require.NoError(t, json.Unmarshal(again, &doc2))
+		assert.Equal(t, doc["serialNumber"], doc2["serialNumber"])
+	})
+
+	t.Run("spdx", func(t *testing.T) {
+		out, err := inv.Encode(FormatSPDX, subject, created)
+		require.NoError(t, err)
+		var doc map[string]any
+		require.NoError(t, json.Unmarshal(out, &doc))
+		assert.Equal(t, "SPDX-2.3", doc["spdxVersion"])
+		assert.Equal(t, "registry.lab:5000/web@sha256:abc", doc["name"])
+
+		packages := doc["packages"].([]any)
+		require.Len(t, packages, 2)
+		musl := packages[1].(map[string]any)
+		assert.Equal(t, "SPDXRef-Package-1", musl["SPDXID"])
+		assert.Equal(t, "pkg:apk/alpine/musl@1.2.5-r0?arch=x86_64&distro=alpine-3.20.0", musl["externalRefs"].([]any)[0].(map[string]any)["referenceLocator"])
+
+		relationships := doc["relationships"].([]any)
+		require.Len(t, relationships, 2)
+		assert.Equal(t, map[string]any{"spdxElementId": "SPDXRef-Image", "relationshipType": "CONTAINS", "relatedSpdxElement": "SPDXRef-Package-1"}, relationships[1])
+	})
+
+	_, err := inv.Encode("syft", subject, created)
+	assert.ErrorContains(t, err, `unknown SBOM format "syft"`)
+}
@@ -0,0 +1,372 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// Package types, as in package URLs
+const (
+	TypeDeb    = "deb"
+	TypeAPK    = "apk"
+	TypeRPM    = "rpm"
+	TypeGolang = "golang"
+	TypePyPI   = "pypi"
+	TypeNPM    = "npm"
+)
+
+// maxBinarySize is the largest executable checked for Go build information
+const maxBinarySize = 256 << 20
+
+// rpmDatabases are where images keep the RPM database, newest layout first
+var rpmDatabases = []string{"usr/lib/sysimage/rpm", "var/lib/rpm"}
+
+// lookPath finds rpm; replaced in tests
+var lookPath = exec.LookPath
+
+// Package is a piece of software found in an image
+type Package struct {
+	Type     string
+	Name     string
+	Version  string
+	Arch     string
+	License  string
+	Location string // File the package was found in
+}
+
+// Distro is the operating system of an image, from os-release
+type Distro struct {
+	ID         string
+	VersionID  string
+	PrettyName string
+}
+
+// Inventory is what a scan found in an image
+type Inventory struct {
+	Distro   Distro
+	Packages []Package
+	Warnings []string // Package databases that couldn't be read
+}
+
+// Scan lists the packages installed in img's filesystem
+func Scan(img v1.Image) (*Inventory, error) {
+	fs := mutate.Extract(img)
+	defer fs.Close()
+	return ScanTar(fs)
+}
+
+// ScanTar lists the packages installed in a flattened image filesystem
+func ScanTar(r io.Reader) (*Inventory, error) {
+	inv := &Inventory{}
+	rpmRoot := ""
+	defer func() {
+		if rpmRoot != "" {
+			os.RemoveAll(rpmRoot)
+		}
+	}()
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image filesystem: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+
+		switch {
+		case name == "etc/os-release" || (name == "usr/lib/os-release" && inv.Distro.ID == ""):
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", name, err)
+			}
+			inv.Distro = parseOSRelease(content)
+		case name == "var/lib/dpkg/status" || strings.HasPrefix(name, "var/lib/dpkg/status.d/"):
+			inv.Packages = append(inv.Packages, parseDpkgStatus(tr, name)...)
+		case name == "lib/apk/db/installed":
+			inv.Packages = append(inv.Packages, parseAPKInstalled(tr, name)...)
+		case isRPMDatabase(name):
+			if rpmRoot == "" {
+				if rpmRoot, err = os.MkdirTemp("", "iago-sbom-rpm-"); err != nil {
+					return nil, fmt.Errorf("failed to create RPM database directory: %w", err)
+				}
+			}
+			if err := writeFile(filepath.Join(rpmRoot, filepath.FromSlash(name)), tr); err != nil {
+				return nil, err
+			}
+		case strings.HasSuffix(name, ".dist-info/METADATA") || strings.HasSuffix(name, ".egg-info/PKG-INFO"):
+			if p, ok := parsePythonMetadata(tr, name); ok {
+				inv.Packages = append(inv.Packages, p)
+			}
+		case isNPMManifest(name):
+			if p, ok := parsePackageJSON(tr, name); ok {
+				inv.Packages = append(inv.Packages, p)
+			}
+		case header.Mode&0111 != 0 && header.Size > 4 && header.Size <= maxBinarySize:
+			inv.Packages = append(inv.Packages, scanGoBinary(tr, name)...)
+		}
+	}
+
+	if rpmRoot != "" {
+		packages, err := queryRPM(rpmRoot)
+		if err != nil {
+			inv.Warnings = append(inv.Warnings, err.Error())
+		}
+		inv.Packages = append(inv.Packages, packages...)
+	}
+
+	inv.Packages = dedupe(inv.Packages)
+	return inv, nil
+}
+
+func parseOSRelease(content []byte) Distro {
+	var d Distro
+	for _, line := range strings.Split(string(content), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			d.ID = value
+		case "VERSION_ID":
+			d.VersionID = value
+		case "PRETTY_NAME":
+			d.PrettyName = value
+		}
+	}
+	return d
+}
+
+// parseStanzas splits "Key: value" paragraphs separated by blank lines, as in
+// dpkg's status file and Python package metadata; continuation lines are dropped
+func parseStanzas(r io.Reader, separator string, each func(fields map[string]string) bool) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	fields := map[string]string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(fields) > 0 && !each(fields) {
+				return
+			}
+			fields = map[string]string{}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, separator); ok {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	if len(fields) > 0 {
+		each(fields)
+	}
+}
+
+func parseDpkgStatus(r io.Reader, location string) []Package {
+	var packages []Package
+	parseStanzas(r, ":", func(fields map[string]string) bool {
+		// Removed packages stay in the database as "deinstall ok config-files"
+		if fields["Package"] != "" && (fields["Status"] == "" || strings.HasSuffix(fields["Status"], " installed")) {
+			packages = append(packages, Package{
+				Type:     TypeDeb,
+				Name:     fields["Package"],
+				Version:  fields["Version"],
+				Arch:     fields["Architecture"],
+				Location: location,
+			})
+		}
+		return true
+	})
+	return packages
+}
+
+func parseAPKInstalled(r io.Reader, location string) []Package {
+	var packages []Package
+	parseStanzas(r, ":", func(fields map[string]string) bool {
+		if fields["P"] != "" {
+			packages = append(packages, Package{
+				Type:     TypeAPK,
+				Name:     fields["P"],
+				Version:  fields["V"],
+				Arch:     fields["A"],
+				License:  fields["L"],
+				Location: location,
+			})
+		}
+		return true
+	})
+	return packages
+}
+
+func parsePythonMetadata(r io.Reader, location string) (Package, bool) {
+	var p Package
+	// Only the header matters; the description follows the first blank line
+	parseStanzas(r, ":", func(fields map[string]string) bool {
+		p = Package{Type: TypePyPI, Name: fields["Name"], Version: fields["Version"], License: fields["License"], Location: location}
+		return false
+	})
+	return p, p.Name != "" && p.Version != ""
+}
+
+// isNPMManifest reports whether name is the package.json of an installed npm
+// package: node_modules/<name>/package.json or node_modules/@scope/<name>/package.json
+func isNPMManifest(name string) bool {
+	if path.Base(name) != "package.json" {
+		return false
+	}
+	dir := path.Dir(path.Dir(name))
+	if strings.HasPrefix(path.Base(dir), "@") {
+		dir = path.Dir(dir)
+	}
+	return path.Base(dir) == "node_modules"
+}
+
+func parsePackageJSON(r io.Reader, location string) (Package, bool) {
+	var manifest struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		License any    `json:"license"`
+	}
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil || manifest.Name == "" || manifest.Version == "" {
+		return Package{}, false
+	}
+	license, _ := manifest.License.(string)
+	return Package{Type: TypeNPM, Name: manifest.Name, Version: manifest.Version, License: license, Location: location}, true
+}
+
+// scanGoBinary lists the main module, dependencies and standard library of a
+// Go executable; other files return nothing
+func scanGoBinary(r io.Reader, location string) []Package {
+	content, err := io.ReadAll(r)
+	if err != nil || !bytes.HasPrefix(content, []byte("\x7fELF")) {
+		return nil
+	}
+	info, err := buildinfo.Read(bytes.NewReader(content))
+	if err != nil {
+		return nil
+	}
+
+	packages := []Package{{Type: TypeGolang, Name: "stdlib", Version: info.GoVersion, Location: location}}
+	if info.Main.Path != "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		packages = append(packages, Package{Type: TypeGolang, Name: info.Main.Path, Version: info.Main.Version, Location: location})
+	}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		packages = append(packages, Package{Type: TypeGolang, Name: dep.Path, Version: dep.Version, Location: location})
+	}
+	return packages
+}
+
+func isRPMDatabase(name string) bool {
+	for _, dir := range rpmDatabases {
+		if path.Dir(name) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// queryRPM lists the packages in the RPM database copied under root with the
+// workstation's rpm, which reads every database format
+func queryRPM(root string) ([]Package, error) {
+	rpm, err := lookPath("rpm")
+	if err != nil {
+		return nil, fmt.Errorf("the image has an RPM database, but rpm isn't installed to read it; RPM packages are not listed")
+	}
+	var dbPath string
+	for _, dir := range rpmDatabases {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(dir))); err == nil {
+			dbPath = "/" + dir
+			break
+		}
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(rpm, "--root", root, "--dbpath", dbPath, "--query", "--all",
+		"--queryformat", `%{NAME}\t%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\t%{ARCH}\t%{LICENSE}\n`)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("rpm failed to read the image's RPM database: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var packages []Package
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 || fields[0] == "gpg-pubkey" {
+			continue
+		}
+		arch := fields[2]
+		if arch == "(none)" {
+			arch = ""
+		}
+		packages = append(packages, Package{Type: TypeRPM, Name: fields[0], Version: fields[1], Arch: arch, License: fields[3], Location: strings.TrimPrefix(dbPath, "/")})
+	}
+	return packages, nil
+}
+
+func writeFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// dedupe drops repeats of the same package, e.g. a Go module in several
+// binaries, keeping the first, and sorts the rest by type, name and version
+func dedupe(packages []Package) []Package {
+	seen := make(map[[4]string]bool)
+	var unique []Package
+	for _, p := range packages {
+		key := [4]string{p.Type, p.Name, p.Version, p.Arch}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, p)
+	}
+	sort.SliceStable(unique, func(i, j int) bool {
+		a, b := unique[i], unique[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	return unique
+}
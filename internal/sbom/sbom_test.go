@@ -0,0 +1,154 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testFile is a file in a test image filesystem
+type testFile struct {
+	name    string
+	content []byte
+	mode    int64
+}
+
+func writeTar(t *testing.T, files ...testFile) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		mode := f.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: f.name, Mode: mode, Size: int64(len(f.content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write(f.content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return &buf
+}
+
+func TestScanTar(t *testing.T) {
+	dpkg := `Package: bash
+Status: install ok installed
+Architecture: amd64
+Version: 5.2.15-2+b7
+Description: GNU Bourne Again SHell
+ Bash is an sh-compatible command language interpreter.
+
+Package: old
+Status: deinstall ok config-files
+Version: 1.0
+
+Package: libc6
+Status: install ok installed
+Architecture: amd64
+Version: 2.36-9+deb12u7
+`
+	inv, err := ScanTar(writeTar(t,
+		testFile{name: "./etc/os-release", content: []byte("PRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\nID=debian\nVERSION_ID=\"12\"\n")},
+		testFile{name: "var/lib/dpkg/status", content: []byte(dpkg)},
+		testFile{name: "usr/lib/python3/dist-packages/Requests_OAuthlib-1.3.1.dist-info/METADATA", content: []byte("Metadata-Version: 2.1\nName: requests_oauthlib\nVersion: 1.3.1\nLicense: ISC\n\nName: not-a-header\n")},
+		testFile{name: "app/node_modules/@types/node/package.json", content: []byte(`{"name": "@types/node", "version": "20.11.5", "license": "MIT"}`)},
+		testFile{name: "app/node_modules/left-pad/package.json", content: []byte(`{"name": "left-pad", "version": "1.3.0"}`)},
+		testFile{name: "app/package.json", content: []byte(`{"name": "app", "version": "0.1.0"}`)},
+		testFile{name: "usr/local/bin/script", content: []byte("#!/bin/sh\necho hi\n"), mode: 0755},
+	))
+	require.NoError(t, err)
+
+	assert.Equal(t, Distro{ID: "debian", VersionID: "12", PrettyName: "Debian GNU/Linux 12 (bookworm)"}, inv.Distro)
+	assert.Equal(t, []Package{
+		{Type: TypeDeb, Name: "bash", Version: "5.2.15-2+b7", Arch: "amd64", Location: "var/lib/dpkg/status"},
+		{Type: TypeDeb, Name: "libc6", Version: "2.36-9+deb12u7", Arch: "amd64", Location: "var/lib/dpkg/status"},
+		{Type: TypeNPM, Name: "@types/node", Version: "20.11.5", License: "MIT", Location: "app/node_modules/@types/node/package.json"},
+		{Type: TypeNPM, Name: "left-pad", Version: "1.3.0", Location: "app/node_modules/left-pad/package.json"},
+		{Type: TypePyPI, Name: "requests_oauthlib", Version: "1.3.1", License: "ISC", Location: "usr/lib/python3/dist-packages/Requests_OAuthlib-1.3.1.dist-info/METADATA"},
+	}, inv.Packages)
+	assert.Empty(t, inv.Warnings)
+}
+
+func TestScanTar_APK(t *testing.T) {
+	inv, err := ScanTar(writeTar(t,
+		testFile{name: "usr/lib/os-release", content: []byte("ID=alpine\nVERSION_ID=3.20.0\n")},
+		testFile{name: "lib/apk/db/installed", content: []byte("C:Q1abc=\nP:musl\nV:1.2.5-r0\nA:x86_64\nL:MIT\n\nP:zlib\nV:1.3.1-r1\nA:x86_64\nL:Zlib\n")},
+	))
+	require.NoError(t, err)
+	assert.Equal(t, "alpine", inv.Distro.ID)
+	assert.Equal(t, []Package{
+		{Type: TypeAPK, Name: "musl", Version: "1.2.5-r0", Arch: "x86_64", License: "MIT", Location: "lib/apk/db/installed"},
+		{Type: TypeAPK, Name: "zlib", Version: "1.3.1-r1", Arch: "x86_64", License: "Zlib", Location: "lib/apk/db/installed"},
+	}, inv.Packages)
+}
+
+func TestScanTar_GoBinary(t *testing.T) {
+	// The test binary is a Go executable with build information
+	executable, err := os.Executable()
+	require.NoError(t, err)
+	content, err := os.ReadFile(executable)
+	require.NoError(t, err)
+	if !bytes.HasPrefix(content, []byte("\x7fELF")) {
+		t.Skip("test binary isn't ELF")
+	}
+
+	inv, err := ScanTar(writeTar(t, testFile{name: "usr/bin/app", content: content, mode: 0755}))
+	require.NoError(t, err)
+	var names []string
+	for _, p := range inv.Packages {
+		assert.Equal(t, TypeGolang, p.Type)
+		assert.Equal(t, "usr/bin/app", p.Location)
+		names = append(names, p.Name)
+	}
+	assert.Contains(t, names, "stdlib")
+	assert.Contains(t, names, "github.com/stretchr/testify")
+}
+
+func TestScanTar_RPM(t *testing.T) {
+	image := writeTar(t,
+		testFile{name: "etc/os-release", content: []byte("ID=fedora\nVERSION_ID=40\n")},
+		testFile{name: "usr/lib/sysimage/rpm/rpmdb.sqlite", content: []byte("not really sqlite")},
+	)
+
+	t.Run("rpm installed", func(t *testing.T) {
+		dir := t.TempDir()
+		rpm := filepath.Join(dir, "rpm")
+		// Prints what rpm --query would, after checking the database was copied
+		script := "#!/bin/sh\n" +
+			"while [ \"$1\" != --root ]; do shift; done\n" +
+			"test -f \"$2/usr/lib/sysimage/rpm/rpmdb.sqlite\" || exit 1\n" +
+			"printf 'bash\\t5.2.26-3.fc40\\tx86_64\\tGPL-3.0-or-later\\n'\n" +
+			"printf 'gpg-pubkey\\ta15b79cc-63d04c2c\\t(none)\\tpubkey\\n'\n" +
+			"printf 'shadow-utils\\t2:4.15.1-1.fc40\\tx86_64\\tBSD-3-Clause\\n'\n"
+		require.NoError(t, os.WriteFile(rpm, []byte(script), 0755))
+		original := lookPath
+		lookPath = func(string) (string, error) { return rpm, nil }
+		t.Cleanup(func() { lookPath = original })
+
+		inv, err := ScanTar(bytes.NewReader(image.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, []Package{
+			{Type: TypeRPM, Name: "bash", Version: "5.2.26-3.fc40", Arch: "x86_64", License: "GPL-3.0-or-later", Location: "usr/lib/sysimage/rpm"},
+			{Type: TypeRPM, Name: "shadow-utils", Version: "2:4.15.1-1.fc40", Arch: "x86_64", License: "BSD-3-Clause", Location: "usr/lib/sysimage/rpm"},
+		}, inv.Packages)
+		assert.Empty(t, inv.Warnings)
+	})
+
+	t.Run("rpm missing", func(t *testing.T) {
+		original := lookPath
+		lookPath = func(string) (string, error) { return "", exec.ErrNotFound }
+		t.Cleanup(func() { lookPath = original })
+
+		inv, err := ScanTar(bytes.NewReader(image.Bytes()))
+		require.NoError(t, err)
+		assert.Empty(t, inv.Packages)
+		require.Len(t, inv.Warnings, 1)
+		assert.Contains(t, inv.Warnings[0], "rpm isn't installed")
+	})
+}
@@ -0,0 +1,116 @@
+package scaffold
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AdoptOptions describes a machine provisioned outside iago
+type AdoptOptions struct {
+	ScaffoldOptions
+	IPAddress        string
+	NetworkInterface string
+	BaseImage        string            // Image the host boots; the Containerfile starts from it
+	Files            map[string][]byte // Copied from the host into machines/<name>/adopted/, by relative path
+	Notes            []string          // Items needing manual reconciliation, written to ADOPTED.md
+}
+
+// CreateAdoptedScaffold writes machines/<name>/ and containers/<name>/ for an
+// existing host: a machine.toml marked adopted, the standard butane template,
+// copies of the host's units under adopted/, and ADOPTED.md listing what
+// still needs reconciling by hand
+func (s *Scaffolder) CreateAdoptedScaffold(opts AdoptOptions) error {
+	// The name and files come from the remote host, so they mustn't escape
+	// machines/ and containers/
+	name := opts.MachineName
+	if name == "" || name != filepath.Base(name) || strings.Contains(name, "..") || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid machine name %q", name)
+	}
+	for file := range opts.Files {
+		if !filepath.IsLocal(filepath.FromSlash(file)) {
+			return fmt.Errorf("invalid adopted file path %q", file)
+		}
+	}
+
+	machineDir := filepath.Join("machines", opts.MachineName)
+	containerDir := filepath.Join("containers", opts.MachineName)
+	for _, dir := range []string{machineDir, containerDir} {
+		if _, err := os.Stat(dir); err == nil {
+			return fmt.Errorf("%s already exists", dir)
+		}
+	}
+
+	if err := s.createMachineConfig(machineDir, opts.ScaffoldOptions); err != nil {
+		return fmt.Errorf("failed to add machine to config: %w", err)
+	}
+	if err := appendAdoptedConfig(filepath.Join(machineDir, "machine.toml"), opts); err != nil {
+		return err
+	}
+
+	if err := s.createContainerFiles(containerDir, opts.ScaffoldOptions); err != nil {
+		return fmt.Errorf("failed to create container structure: %w", err)
+	}
+	if opts.BaseImage != "" {
+		containerfile := fmt.Sprintf("# Adopted from %s; starts from the image it was running\nFROM %s\n", opts.FQDN, opts.BaseImage)
+		if err := os.WriteFile(filepath.Join(containerDir, "Containerfile"), []byte(containerfile), 0644); err != nil {
+			return fmt.Errorf("failed to write Containerfile: %w", err)
+		}
+	}
+
+	for name, content := range opts.Files {
+		target := filepath.Join(machineDir, "adopted", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", target, err)
+		}
+		if err := os.WriteFile(target, content, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", target, err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(machineDir, "ADOPTED.md"), []byte(adoptedNotes(opts)), 0644); err != nil {
+		return fmt.Errorf("failed to write ADOPTED.md: %w", err)
+	}
+	return nil
+}
+
+// appendAdoptedConfig adds what was learned from the host to machine.toml
+func appendAdoptedConfig(path string, opts AdoptOptions) error {
+	var extra strings.Builder
+	if opts.IPAddress != "" {
+		fmt.Fprintf(&extra, "\nip_address = %q", opts.IPAddress)
+	}
+	if opts.NetworkInterface != "" {
+		fmt.Fprintf(&extra, "\nnetwork_interface = %q", opts.NetworkInterface)
+	}
+	extra.WriteString("\nadopted = true # Provisioned outside iago; see ADOPTED.md\n")
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open machine.toml: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(extra.String()); err != nil {
+		return fmt.Errorf("failed to write machine.toml: %w", err)
+	}
+	return nil
+}
+
+func adoptedNotes(opts AdoptOptions) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s (adopted)\n\n", opts.MachineName)
+	fmt.Fprintf(&b, "Adopted from %s on %s. iago didn't provision this host, so the generated\n", opts.FQDN, time.Now().Format("2006-01-02"))
+	b.WriteString("config is a best-effort starting point. Reconcile the items below, then\n")
+	b.WriteString("re-provision the machine (or use `iago deploy`) and remove `adopted = true`\n")
+	b.WriteString("from machine.toml.\n\n")
+	if len(opts.Notes) == 0 {
+		b.WriteString("Nothing found that needs reconciling.\n")
+		return b.String()
+	}
+	for _, note := range opts.Notes {
+		fmt.Fprintf(&b, "- [ ] %s\n", note)
+	}
+	return b.String()
+}
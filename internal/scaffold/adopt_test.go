@@ -0,0 +1,89 @@
+package scaffold
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScaffolder_CreateAdoptedScaffold(t *testing.T) {
+	tempDir := t.TempDir()
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+	require.NoError(t, os.MkdirAll("containers", 0755))
+	require.NoError(t, os.WriteFile("containers/bootc-container-creation-prompt.md", []byte("prompt"), 0644))
+
+	scaffolder := NewScaffolder(machine.Defaults{
+		ContainerRegistry: machine.ContainerRegistryConfig{URL: "registry.example.com"},
+	})
+	opts := AdoptOptions{
+		ScaffoldOptions: ScaffoldOptions{
+			MachineName: "legacy",
+			FQDN:        "legacy.example.com",
+			MACAddress:  "52:54:00:12:34:56",
+		},
+		IPAddress:        "10.0.0.9",
+		NetworkInterface: "ens18",
+		BaseImage:        "quay.io/fedora/fedora-coreos:stable",
+		Files:            map[string][]byte{"units/app.service": []byte("[Service]\nExecStart=/bin/true\n")},
+		Notes:            []string{"Unit app.service is copied to adopted/units/app.service"},
+	}
+	require.NoError(t, scaffolder.CreateAdoptedScaffold(opts))
+
+	var cfg machine.Config
+	_, err = toml.DecodeFile(filepath.Join("machines", "legacy", "machine.toml"), &cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "legacy.example.com", cfg.FQDN)
+	assert.Equal(t, "registry.example.com/legacy", cfg.ContainerImage)
+	assert.Equal(t, "52:54:00:12:34:56", cfg.MACAddress)
+	assert.Equal(t, "10.0.0.9", cfg.IPAddress)
+	assert.Equal(t, "ens18", cfg.NetworkInterface)
+	assert.True(t, cfg.Adopted)
+
+	assert.FileExists(t, filepath.Join("machines", "legacy", "butane.yaml.tmpl"))
+	unit, err := os.ReadFile(filepath.Join("machines", "legacy", "adopted", "units", "app.service"))
+	require.NoError(t, err)
+	assert.Equal(t, "[Service]\nExecStart=/bin/true\n", string(unit))
+
+	containerfile, err := os.ReadFile(filepath.Join("containers", "legacy", "Containerfile"))
+	require.NoError(t, err)
+	assert.Contains(t, string(containerfile), "FROM quay.io/fedora/fedora-coreos:stable\n")
+
+	notes, err := os.ReadFile(filepath.Join("machines", "legacy", "ADOPTED.md"))
+	require.NoError(t, err)
+	assert.Contains(t, string(notes), "- [ ] Unit app.service is copied to adopted/units/app.service\n")
+
+	err = scaffolder.CreateAdoptedScaffold(opts)
+	assert.ErrorContains(t, err, "already exists")
+}
+
+func TestScaffolder_CreateAdoptedScaffold_InvalidNames(t *testing.T) {
+	tempDir := t.TempDir()
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	scaffolder := NewScaffolder(machine.Defaults{})
+	for _, name := range []string{"", "../etc", "a/b", ".hidden", "..", "web..01"} {
+		err := scaffolder.CreateAdoptedScaffold(AdoptOptions{ScaffoldOptions: ScaffoldOptions{MachineName: name}})
+		assert.ErrorContains(t, err, "invalid machine name", name)
+	}
+	err = scaffolder.CreateAdoptedScaffold(AdoptOptions{
+		ScaffoldOptions: ScaffoldOptions{MachineName: "legacy"},
+		Files:           map[string][]byte{"../../.bashrc": []byte("x")},
+	})
+	assert.ErrorContains(t, err, "invalid adopted file path")
+	assert.NoDirExists(t, "machines")
+}
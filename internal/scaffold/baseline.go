@@ -0,0 +1,171 @@
+package scaffold
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Version identifies the scaffold template generation; bump it whenever
+// MachineTemplate changes so machines can tell they are behind
+const Version = 2
+
+// BaselineDir holds, per machine, the scaffold a template was created from
+const BaselineDir = ".scaffold"
+
+// Baseline is the scaffold a machine's template was created from or last updated to
+type Baseline struct {
+	Version  int
+	Template string
+}
+
+// UpdateResult describes the outcome of merging scaffold changes into a machine
+type UpdateResult struct {
+	UpToDate  bool
+	Conflicts int
+}
+
+func baselinePaths(machineDir string) (template, version string) {
+	return filepath.Join(machineDir, BaselineDir, "butane.yaml.tmpl"), filepath.Join(machineDir, BaselineDir, "version")
+}
+
+func writeBaseline(machineDir, template string) error {
+	templatePath, versionPath := baselinePaths(machineDir)
+	if err := os.MkdirAll(filepath.Dir(templatePath), 0755); err != nil {
+		return fmt.Errorf("failed to create scaffold baseline directory: %w", err)
+	}
+	if err := os.WriteFile(templatePath, []byte(template), 0644); err != nil {
+		return fmt.Errorf("failed to write scaffold baseline: %w", err)
+	}
+	if err := os.WriteFile(versionPath, []byte(strconv.Itoa(Version)+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write scaffold version: %w", err)
+	}
+	return nil
+}
+
+// LoadBaseline reads the scaffold baseline recorded for a machine
+func LoadBaseline(machineName string) (*Baseline, error) {
+	templatePath, versionPath := baselinePaths(filepath.Join("machines", machineName))
+
+	template, err := os.ReadFile(templatePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no scaffold baseline recorded for machine '%s' (created before baselines were stored); compare with 'iago scaffold diff' and merge by hand", machineName)
+		}
+		return nil, fmt.Errorf("failed to read scaffold baseline: %w", err)
+	}
+
+	version, err := os.ReadFile(versionPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scaffold version: %w", err)
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(version)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid scaffold version in %s: %w", versionPath, err)
+	}
+
+	return &Baseline{Version: v, Template: string(template)}, nil
+}
+
+// Diff returns a unified diff from the current scaffold to the machine's template
+func Diff(machineName string) (string, error) {
+	machinePath := filepath.Join("machines", machineName, "butane.yaml.tmpl")
+	if _, err := os.Stat(machinePath); err != nil {
+		return "", fmt.Errorf("failed to read machine template: %w", err)
+	}
+
+	scaffoldDir, err := os.MkdirTemp("", "iago-scaffold-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(scaffoldDir)
+
+	scaffoldPath := filepath.Join(scaffoldDir, "butane.yaml.tmpl")
+	if err := os.WriteFile(scaffoldPath, []byte(MachineTemplate(machineName)), 0644); err != nil {
+		return "", fmt.Errorf("failed to write scaffold: %w", err)
+	}
+
+	out, err := runGit("diff", "--no-index", "--no-color", "--", scaffoldPath, machinePath)
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		return "", err
+	}
+
+	// Label the temporary file as the scaffold rather than a temp path
+	return strings.ReplaceAll(string(out), strings.TrimPrefix(filepath.ToSlash(scaffoldPath), "/"), "scaffold/butane.yaml.tmpl"), nil
+}
+
+// Update merges changes between the machine's scaffold baseline and the current
+// scaffold into its template (a 3-way merge). Conflicts are left as markers in
+// the template; the baseline always advances to the current scaffold.
+func Update(machineName string) (*UpdateResult, error) {
+	baseline, err := LoadBaseline(machineName)
+	if err != nil {
+		return nil, err
+	}
+
+	current := MachineTemplate(machineName)
+	if baseline.Template == current {
+		return &UpdateResult{UpToDate: true}, nil
+	}
+
+	machineDir := filepath.Join("machines", machineName)
+	machinePath := filepath.Join(machineDir, "butane.yaml.tmpl")
+	baselinePath, _ := baselinePaths(machineDir)
+
+	scaffoldFile, err := os.CreateTemp("", "iago-scaffold-*.tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(scaffoldFile.Name())
+	if _, err := scaffoldFile.WriteString(current); err != nil {
+		scaffoldFile.Close()
+		return nil, fmt.Errorf("failed to write scaffold: %w", err)
+	}
+	scaffoldFile.Close()
+
+	// git merge-file exits with the number of conflicts
+	merged, err := runGit("merge-file", "-p",
+		"-L", machineName, "-L", fmt.Sprintf("scaffold v%d", baseline.Version), "-L", fmt.Sprintf("scaffold v%d", Version),
+		machinePath, baselinePath, scaffoldFile.Name())
+	conflicts := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 && exitErr.ExitCode() < 128 {
+		conflicts = exitErr.ExitCode()
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(machinePath, merged, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write merged template: %w", err)
+	}
+	if err := writeBaseline(machineDir, current); err != nil {
+		return nil, err
+	}
+
+	return &UpdateResult{Conflicts: conflicts}, nil
+}
+
+func runGit(args ...string) ([]byte, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("git is required for scaffold diff and update: %w", err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() < 128 && stderr.Len() == 0 {
+			return out, err
+		}
+		return out, fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,112 @@
+package scaffold
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andreweick/iago/internal/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupScaffoldedMachine(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	tempDir := t.TempDir()
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	scaffolder := NewScaffolder(machine.Defaults{})
+	require.NoError(t, scaffolder.CreateMachineConfigOnly(ScaffoldOptions{MachineName: "web", FQDN: "web.example.com"}))
+	return filepath.Join("machines", "web", "butane.yaml.tmpl")
+}
+
+func TestLoadBaseline(t *testing.T) {
+	setupScaffoldedMachine(t)
+
+	baseline, err := LoadBaseline("web")
+	require.NoError(t, err)
+	assert.Equal(t, Version, baseline.Version)
+	assert.Equal(t, MachineTemplate("web"), baseline.Template)
+
+	_, err = LoadBaseline("missing")
+	assert.ErrorContains(t, err, "no scaffold baseline recorded")
+}
+
+func TestDiff(t *testing.T) {
+	templatePath := setupScaffoldedMachine(t)
+
+	diff, err := Diff("web")
+	require.NoError(t, err)
+	assert.Empty(t, diff, "a fresh scaffold has not diverged")
+
+	content, err := os.ReadFile(templatePath)
+	require.NoError(t, err)
+	modified := strings.Replace(string(content), "storage:\n", "storage:\n  # site-specific change\n", 1)
+	require.NoError(t, os.WriteFile(templatePath, []byte(modified), 0644))
+
+	diff, err = Diff("web")
+	require.NoError(t, err)
+	assert.Contains(t, diff, "+  # site-specific change")
+	assert.Contains(t, diff, "scaffold/butane.yaml.tmpl")
+	assert.NotContains(t, diff, os.TempDir())
+}
+
+func TestUpdate_ThreeWayMerge(t *testing.T) {
+	templatePath := setupScaffoldedMachine(t)
+
+	result, err := Update("web")
+	require.NoError(t, err)
+	assert.True(t, result.UpToDate)
+
+	// Simulate a machine created from an older scaffold that lacked the log directory,
+	// then customized locally
+	baselinePath := filepath.Join("machines", "web", BaselineDir, "butane.yaml.tmpl")
+	logDir := "    - path: /var/log/iago\n      mode: \"0755\"\n"
+	old := strings.Replace(MachineTemplate("web"), logDir, "", 1)
+	require.NotEqual(t, old, MachineTemplate("web"))
+	require.NoError(t, os.WriteFile(baselinePath, []byte(old), 0644))
+	customized := strings.Replace(old, "version: 1.5.0\n", "version: 1.5.0\n# customized\n", 1)
+	require.NoError(t, os.WriteFile(templatePath, []byte(customized), 0644))
+
+	result, err = Update("web")
+	require.NoError(t, err)
+	assert.False(t, result.UpToDate)
+	assert.Zero(t, result.Conflicts)
+
+	merged, err := os.ReadFile(templatePath)
+	require.NoError(t, err)
+	assert.Contains(t, string(merged), "# customized", "local changes are kept")
+	assert.Contains(t, string(merged), logDir, "scaffold improvements are merged")
+
+	baseline, err := LoadBaseline("web")
+	require.NoError(t, err)
+	assert.Equal(t, MachineTemplate("web"), baseline.Template, "baseline advances to the current scaffold")
+}
+
+func TestUpdate_Conflict(t *testing.T) {
+	templatePath := setupScaffoldedMachine(t)
+
+	baselinePath := filepath.Join("machines", "web", BaselineDir, "butane.yaml.tmpl")
+	old := strings.Replace(MachineTemplate("web"), "version: 1.5.0", "version: 1.4.0", 1)
+	require.NoError(t, os.WriteFile(baselinePath, []byte(old), 0644))
+	require.NoError(t, os.WriteFile(templatePath, []byte(strings.Replace(old, "version: 1.4.0", "version: 1.6.0", 1)), 0644))
+
+	result, err := Update("web")
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Conflicts)
+
+	merged, err := os.ReadFile(templatePath)
+	require.NoError(t, err)
+	assert.Contains(t, string(merged), "<<<<<<< web")
+}
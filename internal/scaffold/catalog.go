@@ -0,0 +1,144 @@
+package scaffold
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+//go:embed roles templates
+var catalogFiles embed.FS
+
+// Entry is a container scaffold in one of iago's catalogs
+type Entry struct {
+	Name        string
+	Description string
+}
+
+// catalog is a directory of embedded container scaffolds, one per
+// subdirectory. Each has a Containerfile whose first line describes it, and
+// its files are Go templates given the container name as .Name.
+type catalog struct {
+	dir  string
+	kind string // Named in messages
+}
+
+var (
+	// roleCatalog scaffolds a kind of workload, picked with iago init --role
+	roleCatalog = catalog{dir: "roles", kind: "role"}
+	// templateCatalog scaffolds a particular application, picked with iago init --template
+	templateCatalog = catalog{dir: "templates", kind: "template"}
+)
+
+// Roles returns the available container roles, sorted by name
+func Roles() ([]Entry, error) {
+	return roleCatalog.list()
+}
+
+// GetRole returns a role by name
+func GetRole(name string) (Entry, error) {
+	return roleCatalog.get(name)
+}
+
+// Templates returns the available application templates, sorted by name
+func Templates() ([]Entry, error) {
+	return templateCatalog.list()
+}
+
+// GetTemplate returns an application template by name
+func GetTemplate(name string) (Entry, error) {
+	return templateCatalog.get(name)
+}
+
+// list returns the catalog's entries, sorted by name
+func (c catalog) list() ([]Entry, error) {
+	dirs, err := catalogFiles.ReadDir(c.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s scaffolds: %w", c.kind, err)
+	}
+
+	var entries []Entry
+	for _, dir := range dirs {
+		entry, err := c.get(dir.Name())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// get returns an entry by name
+func (c catalog) get(name string) (Entry, error) {
+	content, err := catalogFiles.ReadFile(path.Join(c.dir, name, "Containerfile"))
+	if err != nil {
+		var names []string
+		dirs, _ := catalogFiles.ReadDir(c.dir)
+		for _, dir := range dirs {
+			names = append(names, dir.Name())
+		}
+		return Entry{}, fmt.Errorf("unknown %s '%s' (available: %s)", c.kind, name, strings.Join(names, ", "))
+	}
+
+	entry := Entry{Name: name}
+	firstLine, _, _ := strings.Cut(string(content), "\n")
+	if strings.HasPrefix(firstLine, descriptionPrefix) {
+		entry.Description = strings.TrimSpace(strings.TrimPrefix(firstLine, descriptionPrefix))
+	}
+	return entry, nil
+}
+
+const descriptionPrefix = "# description:"
+
+// render writes the entry's scaffold into containerDir, e.g. a Containerfile,
+// example config files, systemd units and a tests directory
+func (c catalog) render(name, containerDir string, opts ScaffoldOptions) error {
+	if _, err := c.get(name); err != nil {
+		return err
+	}
+
+	root := path.Join(c.dir, name)
+	return fs.WalkDir(catalogFiles, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := catalogFiles.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(p, root+"/")
+		if rel == "Containerfile" {
+			// The description only names the entry in iago's listing
+			_, content, _ = bytes.Cut(content, []byte("\n"))
+		}
+
+		tmpl, err := template.New(rel).Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("failed to parse %s file %s: %w", c.kind, p, err)
+		}
+		var rendered bytes.Buffer
+		if err := tmpl.Execute(&rendered, struct{ Name string }{opts.MachineName}); err != nil {
+			return fmt.Errorf("failed to render %s file %s: %w", c.kind, p, err)
+		}
+
+		target := filepath.Join(containerDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", target, err)
+		}
+		mode := os.FileMode(0644)
+		if strings.HasSuffix(rel, ".sh") {
+			mode = 0755
+		}
+		return os.WriteFile(target, rendered.Bytes(), mode)
+	})
+}
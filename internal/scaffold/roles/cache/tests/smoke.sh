@@ -0,0 +1,21 @@
+#!/bin/bash
+# Smoke test for the {{ .Name }} image: boots it with systemd and checks Valkey answers
+# Usage: containers/{{ .Name }}/tests/smoke.sh [image]
+set -euo pipefail
+
+IMAGE="${1:-localhost/{{ .Name }}:latest}"
+NAME="{{ .Name }}-smoke-$$"
+
+podman run -d --rm --name "$NAME" "$IMAGE" /sbin/init >/dev/null
+trap 'podman rm -f "$NAME" >/dev/null' EXIT
+
+for _ in $(seq 1 30); do
+    if podman exec "$NAME" valkey-cli ping 2>/dev/null | grep -q PONG; then
+        echo "{{ .Name }}: Valkey answers on port 6379"
+        exit 0
+    fi
+    sleep 2
+done
+
+echo "{{ .Name }}: Valkey did not answer on port 6379" >&2
+exit 1
@@ -0,0 +1,16 @@
+#!/bin/bash
+# Initialise the PostgreSQL data directory on first boot and include the
+# settings shipped in /etc/{{ .Name }}
+set -euo pipefail
+
+DATA=/var/lib/pgsql/data
+LOG_PREFIX="[$(date '+%Y-%m-%d %H:%M:%S')] [{{ .Name }}-init]"
+
+if [ -f "$DATA/PG_VERSION" ]; then
+    echo "$LOG_PREFIX data directory already initialised"
+    exit 0
+fi
+
+postgresql-setup --initdb
+echo "include_if_exists = '/etc/{{ .Name }}/postgresql.conf'" >> "$DATA/postgresql.conf"
+echo "$LOG_PREFIX initialised $DATA"
@@ -0,0 +1,21 @@
+#!/bin/bash
+# Smoke test for the {{ .Name }} image: boots it with systemd and checks PostgreSQL accepts connections
+# Usage: containers/{{ .Name }}/tests/smoke.sh [image]
+set -euo pipefail
+
+IMAGE="${1:-localhost/{{ .Name }}:latest}"
+NAME="{{ .Name }}-smoke-$$"
+
+podman run -d --rm --name "$NAME" "$IMAGE" /sbin/init >/dev/null
+trap 'podman rm -f "$NAME" >/dev/null' EXIT
+
+for _ in $(seq 1 30); do
+    if podman exec "$NAME" runuser -u postgres -- pg_isready -q 2>/dev/null; then
+        echo "{{ .Name }}: PostgreSQL accepts connections"
+        exit 0
+    fi
+    sleep 2
+done
+
+echo "{{ .Name }}: PostgreSQL did not become ready" >&2
+exit 1
@@ -0,0 +1,21 @@
+#!/bin/bash
+# Smoke test for the {{ .Name }} image: boots it with systemd and checks the site answers
+# Usage: containers/{{ .Name }}/tests/smoke.sh [image]
+set -euo pipefail
+
+IMAGE="${1:-localhost/{{ .Name }}:latest}"
+NAME="{{ .Name }}-smoke-$$"
+
+podman run -d --rm --name "$NAME" "$IMAGE" /sbin/init >/dev/null
+trap 'podman rm -f "$NAME" >/dev/null' EXIT
+
+for _ in $(seq 1 30); do
+    if podman exec "$NAME" curl -fsS http://localhost:8080/healthz >/dev/null 2>&1; then
+        echo "{{ .Name }}: nginx answers on port 8080"
+        exit 0
+    fi
+    sleep 2
+done
+
+echo "{{ .Name }}: nginx did not answer on port 8080" >&2
+exit 1
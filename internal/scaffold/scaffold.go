@@ -13,6 +13,8 @@ type ScaffoldOptions struct {
 	FQDN        string
 	MACAddress  string
 	OutputDir   string
+	Role        string // Container role scaffold (see Roles); empty writes a bare Containerfile
+	Template    string // Application scaffold (see Templates), used instead of Role
 }
 
 type Scaffolder struct {
@@ -93,6 +95,13 @@ func (s *Scaffolder) createContainerFiles(containerDir string, opts ScaffoldOpti
 }
 
 func (s *Scaffolder) createContainerfile(containerDir string, opts ScaffoldOptions) error {
+	switch {
+	case opts.Template != "":
+		return templateCatalog.render(opts.Template, containerDir, opts)
+	case opts.Role != "":
+		return roleCatalog.render(opts.Role, containerDir, opts)
+	}
+
 	containerfile := "FROM quay.io/fedora/fedora-bootc:42\n"
 
 	return os.WriteFile(filepath.Join(containerDir, "Containerfile"), []byte(containerfile), 0644)
@@ -144,34 +153,22 @@ mac_address = "%s"`, opts.MACAddress)
 	return nil
 }
 
-func (s *Scaffolder) addToOldMachinesConfig(opts ScaffoldOptions) error {
-	configEntry := fmt.Sprintf(`
-# Added by iago init
-[[machines]]
-name = "%s"
-fqdn = "%s"`, opts.MachineName, opts.FQDN)
-
-	if opts.MACAddress != "" {
-		configEntry += fmt.Sprintf(`
-mac_address = "%s"`, opts.MACAddress)
-	}
-
-	configEntry += "\n"
+func (s *Scaffolder) createMachineButaneScaffold(machineDir string, opts ScaffoldOptions) error {
+	scaffoldContent := MachineTemplate(opts.MachineName)
 
-	// Append to machines.toml
-	f, err := os.OpenFile("config/machines.toml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
+	machineButanePath := filepath.Join(machineDir, "butane.yaml.tmpl")
+	if err := os.WriteFile(machineButanePath, []byte(scaffoldContent), 0644); err != nil {
 		return err
 	}
-	defer f.Close()
 
-	_, err = f.WriteString(configEntry)
-	return err
+	// Keep the pristine scaffold so later scaffold improvements can be merged
+	return writeBaseline(machineDir, scaffoldContent)
 }
 
-func (s *Scaffolder) createMachineButaneScaffold(machineDir string, opts ScaffoldOptions) error {
-	// Create a complete butane template file for the machine
-	scaffoldContent := fmt.Sprintf(`variant: fcos
+// MachineTemplate returns the current scaffold butane template for a machine
+func MachineTemplate(machineName string) string {
+	// A complete butane template file for the machine
+	return fmt.Sprintf(`variant: fcos
 version: 1.5.0
 passwd:
   users:
@@ -188,7 +185,9 @@ passwd:
 {{ range .Admin.Groups }}        - {{ . }}
 {{ end }}
       password_hash: "{{ .Admin.PasswordHash }}"
-
+{{ if .AdminSSHKeys }}      ssh_authorized_keys:
+{{ range .AdminSSHKeys }}        - {{ . }}
+{{ end }}{{ end }}
 storage:
   directories:
     - path: /etc/iago
@@ -383,10 +382,7 @@ systemd:
         Type=oneshot
         ExecStart=/usr/local/bin/bootc-update.sh
         StandardOutput=journal
-        StandardError=journal`, opts.MachineName)
-
-	machineButanePath := filepath.Join(machineDir, "butane.yaml.tmpl")
-	return os.WriteFile(machineButanePath, []byte(scaffoldContent), 0644)
+        StandardError=journal`, machineName)
 }
 
 func (s *Scaffolder) generateIgnition(opts ScaffoldOptions) error {
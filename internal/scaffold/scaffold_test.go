@@ -3,6 +3,7 @@ package scaffold
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/andreweick/iago/internal/machine"
@@ -398,3 +399,112 @@ func TestScaffolder_CreateMachineConfigOnly_WithoutMAC(t *testing.T) {
 	assert.Contains(t, contentStr, `container_image = "localhost:5000/no-mac-machine"`)
 	assert.NotContains(t, contentStr, "mac_address", "should not contain MAC address when not provided")
 }
+
+func TestScaffolder_CreateContainerScaffoldOnly_Role(t *testing.T) {
+	tempDir := t.TempDir()
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+	require.NoError(t, os.MkdirAll("containers", 0755))
+	require.NoError(t, os.WriteFile("containers/bootc-container-creation-prompt.md", []byte("prompt"), 0644))
+
+	scaffolder := NewScaffolder(machine.Defaults{})
+	require.NoError(t, scaffolder.CreateContainerScaffoldOnly(ScaffoldOptions{MachineName: "pg", Role: "db"}))
+
+	containerfile, err := os.ReadFile(filepath.Join("containers", "pg", "Containerfile"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(containerfile), "FROM quay.io/fedora/fedora-bootc:42\n"), "the role description isn't written")
+	assert.Contains(t, string(containerfile), "COPY containers/pg/config/postgresql.conf /etc/pg/postgresql.conf")
+	assert.Contains(t, string(containerfile), "RUN systemctl enable pg-initdb.service postgresql.service")
+	assert.True(t, strings.HasSuffix(string(containerfile), "RUN bootc container lint\n"))
+
+	conf, err := os.ReadFile(filepath.Join("containers", "pg", "config", "postgresql.conf"))
+	require.NoError(t, err)
+	assert.Contains(t, string(conf), "hba_file = '/etc/pg/pg_hba.conf'")
+
+	info, err := os.Stat(filepath.Join("containers", "pg", "tests", "smoke.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	assert.FileExists(t, filepath.Join("containers", "pg", "pg-prompt.md"))
+
+	err = scaffolder.CreateContainerScaffoldOnly(ScaffoldOptions{MachineName: "x", Role: "queue"})
+	assert.ErrorContains(t, err, "unknown role 'queue' (available: cache, db, web)")
+}
+
+func TestScaffolder_CreateContainerScaffoldOnly_Template(t *testing.T) {
+	tempDir := t.TempDir()
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+	require.NoError(t, os.MkdirAll("containers", 0755))
+	require.NoError(t, os.WriteFile("containers/bootc-container-creation-prompt.md", []byte("prompt"), 0644))
+
+	scaffolder := NewScaffolder(machine.Defaults{})
+	require.NoError(t, scaffolder.CreateContainerScaffoldOnly(ScaffoldOptions{MachineName: "proxy", Template: "caddy"}))
+
+	containerfile, err := os.ReadFile(filepath.Join("containers", "proxy", "Containerfile"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(containerfile), "FROM quay.io/fedora/fedora-bootc:42\n"), "the template description isn't written")
+	assert.Contains(t, string(containerfile), "COPY containers/proxy/config/Caddyfile /etc/caddy/Caddyfile")
+	assert.Contains(t, string(containerfile), "RUN systemctl enable caddy.service")
+	assert.FileExists(t, filepath.Join("containers", "proxy", "config", "Caddyfile"))
+
+	info, err := os.Stat(filepath.Join("containers", "proxy", "tests", "smoke.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	err = scaffolder.CreateContainerScaffoldOnly(ScaffoldOptions{MachineName: "x", Template: "nextcloud"})
+	assert.ErrorContains(t, err, "unknown template 'nextcloud'")
+}
+
+func TestRoles(t *testing.T) {
+	roles, err := Roles()
+	require.NoError(t, err)
+	require.Len(t, roles, 3)
+	assertCatalogRenders(t, roleCatalog, roles)
+}
+
+func TestTemplates(t *testing.T) {
+	templates, err := Templates()
+	require.NoError(t, err)
+	names := make([]string, 0, len(templates))
+	for _, template := range templates {
+		names = append(names, template.Name)
+	}
+	assert.Equal(t, []string{"caddy", "compose", "immich", "postgres", "wireguard"}, names)
+	assertCatalogRenders(t, templateCatalog, templates)
+
+	_, err = GetTemplate("nextcloud")
+	assert.ErrorContains(t, err, "unknown template 'nextcloud' (available: caddy, compose, immich, postgres, wireguard)")
+}
+
+// assertCatalogRenders renders every entry and checks the result builds
+func assertCatalogRenders(t *testing.T, c catalog, entries []Entry) {
+	t.Helper()
+	tempDir := t.TempDir()
+	for _, entry := range entries {
+		assert.NotEmpty(t, entry.Description, entry.Name)
+
+		dir := filepath.Join(tempDir, entry.Name)
+		require.NoError(t, c.render(entry.Name, dir, ScaffoldOptions{MachineName: "app"}))
+
+		// Every file the Containerfile copies from the build context is generated
+		containerfile, err := os.ReadFile(filepath.Join(dir, "Containerfile"))
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(string(containerfile), "RUN bootc container lint\n"), entry.Name)
+		for _, line := range strings.Split(string(containerfile), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 3 && fields[0] == "COPY" && strings.HasPrefix(fields[1], "containers/app/") {
+				_, err := os.Stat(filepath.Join(dir, strings.TrimPrefix(fields[1], "containers/app/")))
+				assert.NoError(t, err, "%s: %s", entry.Name, line)
+			}
+		}
+		assert.FileExists(t, filepath.Join(dir, "tests", "smoke.sh"))
+	}
+}
@@ -0,0 +1,21 @@
+#!/bin/bash
+# Smoke test for the {{ .Name }} image: boots it with systemd and checks Caddy answers its health check
+# Usage: containers/{{ .Name }}/tests/smoke.sh [image]
+set -euo pipefail
+
+IMAGE="${1:-localhost/{{ .Name }}:latest}"
+NAME="{{ .Name }}-smoke-$$"
+
+podman run -d --rm --name "$NAME" "$IMAGE" /sbin/init >/dev/null
+trap 'podman rm -f "$NAME" >/dev/null' EXIT
+
+for _ in $(seq 1 30); do
+    if podman exec "$NAME" curl -fsS http://localhost/healthz 2>/dev/null | grep -q ok; then
+        echo "{{ .Name }}: Caddy answers on port 80"
+        exit 0
+    fi
+    sleep 2
+done
+
+echo "{{ .Name }}: Caddy did not answer on port 80" >&2
+exit 1
@@ -0,0 +1,11 @@
+#!/bin/bash
+# Smoke test for the {{ .Name }} image: checks compose.yaml parses and the stack's service is enabled
+# (running the stack needs podman on the booted machine)
+# Usage: containers/{{ .Name }}/tests/smoke.sh [image]
+set -euo pipefail
+
+IMAGE="${1:-localhost/{{ .Name }}:latest}"
+
+podman run --rm "$IMAGE" podman-compose -f /etc/{{ .Name }}/compose.yaml config >/dev/null
+podman run --rm "$IMAGE" systemctl is-enabled {{ .Name }}-compose.service >/dev/null
+echo "{{ .Name }}: compose.yaml parses and {{ .Name }}-compose.service is enabled"
@@ -0,0 +1,16 @@
+#!/bin/bash
+# Smoke test for the {{ .Name }} image: checks systemd generates a service from every Immich quadlet
+# (running Immich itself needs podman on the booted machine)
+# Usage: containers/{{ .Name }}/tests/smoke.sh [image]
+set -euo pipefail
+
+IMAGE="${1:-localhost/{{ .Name }}:latest}"
+
+UNITS=$(podman run --rm "$IMAGE" /usr/lib/systemd/system-generators/podman-system-generator --dryrun 2>/dev/null)
+for service in immich-server immich-machine-learning immich-postgres immich-valkey immich-network; do
+    if ! grep -q "^---${service}.service---" <<<"$UNITS"; then
+        echo "{{ .Name }}: no ${service}.service generated" >&2
+        exit 1
+    fi
+done
+echo "{{ .Name }}: every Immich quadlet generates a service"
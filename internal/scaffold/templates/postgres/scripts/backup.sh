@@ -0,0 +1,15 @@
+#!/bin/bash
+# Dump every database of {{ .Name }} to /var/lib/pgsql/backups, keeping
+# BACKUP_KEEP days (default 7)
+set -euo pipefail
+
+DIR=/var/lib/pgsql/backups
+KEEP="${BACKUP_KEEP:-7}"
+LOG_PREFIX="[$(date '+%Y-%m-%d %H:%M:%S')] [{{ .Name }}-backup]"
+
+mkdir -p "$DIR"
+FILE="$DIR/{{ .Name }}-$(date '+%Y%m%d-%H%M%S').sql.gz"
+pg_dumpall | gzip > "$FILE.tmp"
+mv "$FILE.tmp" "$FILE"
+find "$DIR" -name '{{ .Name }}-*.sql.gz' -mtime "+$KEEP" -delete
+echo "$LOG_PREFIX wrote $FILE"
@@ -0,0 +1,15 @@
+#!/bin/bash
+# Generate the WireGuard key pair for {{ .Name }} on first boot and print the
+# public key so it can be given to peers
+set -euo pipefail
+
+DIR=/var/lib/{{ .Name }}
+LOG_PREFIX="[$(date '+%Y-%m-%d %H:%M:%S')] [{{ .Name }}-keygen]"
+
+if [ ! -f "$DIR/private.key" ]; then
+    install -d -m 700 "$DIR"
+    (umask 077 && wg genkey > "$DIR/private.key")
+    echo "$LOG_PREFIX generated a new private key"
+fi
+wg pubkey < "$DIR/private.key" > "$DIR/public.key"
+echo "$LOG_PREFIX public key: $(cat "$DIR/public.key")"
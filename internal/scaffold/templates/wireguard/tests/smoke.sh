@@ -0,0 +1,22 @@
+#!/bin/bash
+# Smoke test for the {{ .Name }} image: boots it with systemd and checks the key pair is generated
+# (the tunnel itself needs the host's kernel module, so it isn't brought up here)
+# Usage: containers/{{ .Name }}/tests/smoke.sh [image]
+set -euo pipefail
+
+IMAGE="${1:-localhost/{{ .Name }}:latest}"
+NAME="{{ .Name }}-smoke-$$"
+
+podman run -d --rm --name "$NAME" "$IMAGE" /sbin/init >/dev/null
+trap 'podman rm -f "$NAME" >/dev/null' EXIT
+
+for _ in $(seq 1 30); do
+    if podman exec "$NAME" test -s /var/lib/{{ .Name }}/public.key 2>/dev/null; then
+        echo "{{ .Name }}: WireGuard key pair generated"
+        exit 0
+    fi
+    sleep 2
+done
+
+echo "{{ .Name }}: WireGuard key pair was not generated" >&2
+exit 1
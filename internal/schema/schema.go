@@ -0,0 +1,144 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/andreweick/iago/internal/machine"
+)
+
+// draft is the JSON Schema dialect emitted by Generate
+const draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema document or subschema
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+}
+
+// Targets maps schema names accepted by `iago schema` to the config struct they describe
+var Targets = map[string]struct {
+	Title string
+	Value interface{}
+}{
+	"machine":  {Title: "iago machine.toml", Value: machine.Config{}},
+	"defaults": {Title: "iago config/defaults.toml", Value: machine.Defaults{}},
+}
+
+// Generate builds a JSON schema for a config struct using its toml tags.
+// Fields tagged `jsonschema:"required"` are required and `jsonschema:"enum=a|b"`
+// restricts a string to the listed values.
+func Generate(title string, v interface{}) (*Schema, error) {
+	t := reflect.TypeOf(v)
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot generate schema for %s: expected a struct", t.Kind())
+	}
+
+	s, err := typeSchema(t)
+	if err != nil {
+		return nil, err
+	}
+	s.Schema = draft
+	s.Title = title
+	return s, nil
+}
+
+// Marshal generates the named target schema as indented JSON
+func Marshal(name string) ([]byte, error) {
+	target, ok := Targets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema '%s' (expected machine or defaults)", name)
+	}
+
+	s, err := Generate(target.Title, target.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s schema: %w", name, err)
+	}
+	return append(content, '\n'), nil
+}
+
+func typeSchema(t reflect.Type) (*Schema, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}, nil
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}, nil
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.Slice, reflect.Array:
+		items, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		values, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Interface:
+		return &Schema{}, nil
+	case reflect.Struct:
+		return structSchema(t)
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+func structSchema(t reflect.Type) (*Schema, error) {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop, err := typeSchema(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s.%s: %w", t.Name(), field.Name, err)
+		}
+
+		for _, option := range strings.Split(field.Tag.Get("jsonschema"), ",") {
+			switch {
+			case option == "required":
+				s.Required = append(s.Required, name)
+			case strings.HasPrefix(option, "enum="):
+				prop.Enum = strings.Split(strings.TrimPrefix(option, "enum="), "|")
+			}
+		}
+
+		s.Properties[name] = prop
+	}
+
+	return s, nil
+}
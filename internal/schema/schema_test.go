@@ -0,0 +1,60 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	type nested struct {
+		Hosts []string `toml:"hosts"`
+	}
+	type config struct {
+		Name    string            `toml:"name" jsonschema:"required"`
+		Mode    string            `toml:"mode,omitempty" jsonschema:"enum=a|b"`
+		Count   int               `toml:"count"`
+		Enabled bool              `toml:"enabled"`
+		Nested  nested            `toml:"nested"`
+		Labels  map[string]string `toml:"labels"`
+		Skipped string            `toml:"-"`
+		hidden  string
+	}
+
+	s, err := Generate("test", config{})
+	require.NoError(t, err)
+
+	assert.Equal(t, draft, s.Schema)
+	assert.Equal(t, "object", s.Type)
+	assert.Equal(t, false, s.AdditionalProperties)
+	assert.Equal(t, []string{"name"}, s.Required)
+	assert.Equal(t, []string{"a", "b"}, s.Properties["mode"].Enum)
+	assert.Equal(t, "integer", s.Properties["count"].Type)
+	assert.Equal(t, "boolean", s.Properties["enabled"].Type)
+	assert.Equal(t, "array", s.Properties["nested"].Properties["hosts"].Type)
+	assert.Equal(t, &Schema{Type: "string"}, s.Properties["labels"].AdditionalProperties)
+	assert.NotContains(t, s.Properties, "Skipped")
+	assert.NotContains(t, s.Properties, "hidden")
+
+	_, err = Generate("bad", "not a struct")
+	assert.Error(t, err)
+}
+
+func TestMarshal(t *testing.T) {
+	content, err := Marshal("machine")
+	require.NoError(t, err)
+
+	var parsed map[string]interface{}
+	require.NoError(t, json.Unmarshal(content, &parsed))
+	assert.Contains(t, parsed["properties"], "fqdn")
+	assert.ElementsMatch(t, []interface{}{"name"}, parsed["required"])
+
+	content, err = Marshal("defaults")
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"container_registry"`)
+
+	_, err = Marshal("unknown")
+	assert.ErrorContains(t, err, "unknown schema")
+}
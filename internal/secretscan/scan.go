@@ -0,0 +1,262 @@
+// Package secretscan finds plaintext secrets committed to a fleet repository
+// by accident: in templates, machine.toml files, container sources and the
+// generated ignition files.
+package secretscan
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/vincent-petithory/dataurl"
+)
+
+// DefaultDirs are the directories iago scan-secrets and validate inspect
+var DefaultDirs = []string{"config", "machines", "containers", "output"}
+
+// AllowMarker on a line suppresses findings on it, for test fixtures and
+// documented example values
+const AllowMarker = "iago:allow-secret"
+
+// skipDirs hold copies of files scanned elsewhere, or nothing iago writes
+var skipDirs = map[string]bool{".git": true, "node_modules": true, filepath.Join("output", "archive"): true}
+
+// maxFileSize skips images, tarballs and other large artifacts
+const maxFileSize = 5 << 20
+
+// minEntropy is the Shannon entropy in bits per character above which a value
+// assigned to a secret-sounding key looks random rather than a placeholder
+const minEntropy = 3.5
+
+// Finding is a likely secret
+type Finding struct {
+	Path  string `json:"path" yaml:"path"` // File, or ignition file and the path inside it
+	Line  int    `json:"line" yaml:"line"`
+	Rule  string `json:"rule" yaml:"rule"`
+	Match string `json:"match" yaml:"match"` // Redacted
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", f.Path, f.Line, f.Rule, f.Match)
+}
+
+type rule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// rules match well-known credential formats
+var rules = []rule{
+	{"AWS access key ID", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"AWS secret access key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[=:]\s*["']?[A-Za-z0-9/+=]{40}\b`)},
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{"Stripe key", regexp.MustCompile(`\b[rs]k_live_[A-Za-z0-9]{20,}\b`)},
+	{"1Password service account token", regexp.MustCompile(`\bops_[A-Za-z0-9_-]{40,}\b`)},
+	{"private key", regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )?PRIVATE KEY-----`)},
+	{"age secret key", regexp.MustCompile(`\bAGE-SECRET-KEY-1[02-9AC-HJ-NP-Z]{58}\b`)},
+}
+
+// assignment matches a secret-sounding key given a literal value
+var assignment = regexp.MustCompile(`(?i)\b[\w.-]*(?:password|passwd|secret|token|api_?key|access_?key|private_?key|credential)s?["']?\s*[=:]\s*["']?([^\s"'#]{12,})`)
+
+// Scan inspects every file under dirs, skipping directories that don't exist
+func Scan(dirs []string) ([]Finding, error) {
+	var findings []Finding
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == dir {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if d.IsDir() {
+				if skipDirs[path] || skipDirs[d.Name()] {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || info.Size() > maxFileSize {
+				return err
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			if strings.HasSuffix(path, ".ign") {
+				findings = append(findings, ScanIgnition(path, content)...)
+			} else {
+				findings = append(findings, ScanContent(path, content)...)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return findings, nil
+}
+
+// ScanContent inspects one text file. Binary content is skipped.
+func ScanContent(path string, content []byte) []Finding {
+	if bytes.IndexByte(content, 0) >= 0 {
+		return nil
+	}
+
+	var findings []Finding
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxFileSize)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if strings.Contains(line, AllowMarker) {
+			continue
+		}
+		matched := false
+		for _, r := range rules {
+			if match := r.pattern.FindString(line); match != "" {
+				findings = append(findings, Finding{Path: path, Line: lineNumber, Rule: r.name, Match: redact(match)})
+				matched = true
+			}
+		}
+		if matched {
+			continue
+		}
+		for _, m := range assignment.FindAllStringSubmatch(line, -1) {
+			if value := m[1]; looksLikeSecret(value) {
+				findings = append(findings, Finding{Path: path, Line: lineNumber, Rule: "high-entropy secret assignment", Match: redact(m[0])})
+			}
+		}
+	}
+	return findings
+}
+
+// ScanIgnition inspects the inline file contents and units of an ignition
+// config, reporting findings as <file>:<path inside the config>. Files that
+// aren't valid ignition are scanned as text.
+func ScanIgnition(path string, content []byte) []Finding {
+	var config struct {
+		Storage struct {
+			Files []struct {
+				Path     string `json:"path"`
+				Contents struct {
+					Source      *string `json:"source"`
+					Compression *string `json:"compression"`
+				} `json:"contents"`
+			} `json:"files"`
+		} `json:"storage"`
+		Systemd struct {
+			Units []struct {
+				Name     string  `json:"name"`
+				Contents *string `json:"contents"`
+				Dropins  []struct {
+					Name     string  `json:"name"`
+					Contents *string `json:"contents"`
+				} `json:"dropins"`
+			} `json:"units"`
+		} `json:"systemd"`
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return ScanContent(path, content)
+	}
+
+	var findings []Finding
+	for _, f := range config.Storage.Files {
+		if f.Contents.Source == nil {
+			continue
+		}
+		decoded, err := decodeDataURL(*f.Contents.Source, f.Contents.Compression)
+		if err != nil {
+			continue
+		}
+		findings = append(findings, ScanContent(path+":"+f.Path, decoded)...)
+	}
+	for _, u := range config.Systemd.Units {
+		if u.Contents != nil {
+			findings = append(findings, ScanContent(path+":"+u.Name, []byte(*u.Contents))...)
+		}
+		for _, d := range u.Dropins {
+			if d.Contents != nil {
+				findings = append(findings, ScanContent(path+":"+u.Name+".d/"+d.Name, []byte(*d.Contents))...)
+			}
+		}
+	}
+	// Users' password hashes and SSH keys aren't secrets; nothing else in an
+	// ignition config holds free text
+	return findings
+}
+
+// decodeDataURL decodes an inline data: source, gunzipping it when compressed
+func decodeDataURL(source string, compression *string) ([]byte, error) {
+	if !strings.HasPrefix(source, "data:") {
+		return nil, fmt.Errorf("not an inline source")
+	}
+	decoded, err := dataurl.DecodeString(source)
+	if err != nil {
+		return nil, err
+	}
+	if compression == nil || *compression == "" {
+		return decoded.Data, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(decoded.Data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// looksLikeSecret reports whether an assigned value is random enough to be a
+// real credential rather than a reference, template or placeholder
+func looksLikeSecret(value string) bool {
+	for _, prefix := range []string{"$", "op://", "ENC[", "http://", "https://", "/", "./", "~/", "%"} {
+		if strings.HasPrefix(value, prefix) {
+			return false
+		}
+	}
+	lower := strings.ToLower(value)
+	for _, placeholder := range []string{"${", "$(", "{{", "example", "changeme", "mock", "placeholder", "dummy", "xxxx", "your-", "your_"} {
+		if strings.Contains(lower, placeholder) {
+			return false
+		}
+	}
+	return entropy(value) >= minEntropy
+}
+
+// entropy is the Shannon entropy of s in bits per character
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	total := 0
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+	var bits float64
+	for _, n := range counts {
+		p := float64(n) / float64(total)
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
+
+// redact keeps enough of a match to find it, but not to use it
+func redact(match string) string {
+	if len(match) <= 12 {
+		return strings.Repeat("*", len(match))
+	}
+	return match[:8] + strings.Repeat("*", 8)
+}
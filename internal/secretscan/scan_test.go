@@ -0,0 +1,100 @@
+package secretscan
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vincent-petithory/dataurl"
+)
+
+// Credentials are assembled at runtime so this file doesn't trip scanners itself
+var (
+	awsKeyID    = "AKIA" + "IOSFODNN7EXAMPLE"
+	githubToken = "ghp_" + strings.Repeat("a1B2c3D4e5", 4)
+	privateKey  = "-----BEGIN OPENSSH " + "PRIVATE KEY-----"
+	ageKey      = "AGE-SECRET-KEY-" + "1" + strings.Repeat("QPZRY9X8GF", 5) + "2TVDW0S3"
+)
+
+func TestScanContent(t *testing.T) {
+	content := strings.Join([]string{
+		"[user]",
+		`password_hash = "$6$AdHNLeqwqojfhuki$3APvnKEXh5Qn2PR8QPHy"`,
+		`aws_access_key_id = "` + awsKeyID + `"`,
+		"GITHUB_TOKEN=" + githubToken,
+		privateKey,
+		ageKey,
+		`api_key = "Zq8vN2xLr5TbW9pKc3Hs"`,
+		`api_key = "aaaaaaaaaaaaaaaa"`,
+		`registry_token = "op://iago/registry/credential"`,
+		"API_TOKEN=mock-api-token-for-${SERVICE_NAME}",
+		`db_password = "Zq8vN2xLr5TbW9pKc3Hs" # iago:allow-secret`,
+	}, "\n")
+
+	findings := ScanContent("machines/web/machine.toml", []byte(content))
+	var got []string
+	for _, f := range findings {
+		got = append(got, fmt.Sprintf("%d %s", f.Line, f.Rule))
+	}
+	assert.Equal(t, []string{
+		"3 AWS access key ID",
+		"4 GitHub token",
+		"5 private key",
+		"6 age secret key",
+		"7 high-entropy secret assignment",
+	}, got, "hashes, low-entropy values, references, placeholders and allowed lines aren't reported")
+
+	for _, f := range findings {
+		assert.NotContains(t, f.Match, githubToken)
+		assert.Contains(t, f.String(), "machines/web/machine.toml:")
+	}
+
+	assert.Empty(t, ScanContent("containers/web/logo.png", []byte("\x89PNG\x00"+awsKeyID)), "binary files are skipped")
+}
+
+func TestScanIgnition(t *testing.T) {
+	ignition := fmt.Sprintf(`{
+  "ignition": {"version": "3.4.0"},
+  "storage": {"files": [
+    {"path": "/etc/iago/containers/web.env", "contents": {"source": %q}},
+    {"path": "/etc/motd", "contents": {"source": %q}}
+  ]},
+  "systemd": {"units": [
+    {"name": "web.service", "contents": "[Service]\nEnvironment=GITHUB_TOKEN=%s\n"}
+  ]}
+}`, dataurl.EncodeBytes([]byte("PORT=80\nAWS_KEY="+awsKeyID+"\n")), dataurl.EncodeBytes([]byte("hello\n")), githubToken)
+
+	findings := ScanIgnition("output/ignition/web.ign", []byte(ignition))
+	require.Len(t, findings, 2)
+	assert.Equal(t, "output/ignition/web.ign:/etc/iago/containers/web.env", findings[0].Path)
+	assert.Equal(t, 2, findings[0].Line, "lines count within the decoded file")
+	assert.Equal(t, "output/ignition/web.ign:web.service", findings[1].Path)
+}
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	write("machines/web/machine.toml", "name = \"web\"\n")
+	write("containers/web/config/app.env", "TOKEN="+githubToken+"\n")
+	write("output/archive/web/20260101/web.ign", "TOKEN="+githubToken+"\n")
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	findings, err := Scan(DefaultDirs)
+	require.NoError(t, err, "missing directories are skipped")
+	require.Len(t, findings, 1, "archived generations aren't scanned twice")
+	assert.Equal(t, filepath.Join("containers", "web", "config", "app.env"), findings[0].Path)
+}
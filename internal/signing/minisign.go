@@ -0,0 +1,303 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// SignatureExt is appended to a signed file's name to find its signature
+const SignatureExt = ".minisig"
+
+// PasswordEnv supplies the password for an encrypted secret key
+const PasswordEnv = "IAGO_SIGNING_KEY_PASSWORD"
+
+// Signatures and keys use the minisign format so `minisign -V` can verify
+// them on provisioning servers that don't have iago installed.
+var (
+	algLegacy    = [2]byte{'E', 'd'} // signs the raw content
+	algPrehashed = [2]byte{'E', 'D'} // signs the BLAKE2b-512 of the content
+	kdfNone      = [2]byte{0, 0}
+	kdfScrypt    = [2]byte{'S', 'c'}
+	checksumAlg  = [2]byte{'B', '2'}
+)
+
+// ErrSignatureInvalid means the content doesn't match the signature or key
+var ErrSignatureInvalid = errors.New("signature verification failed")
+
+// PublicKey verifies signatures made by the matching secret key
+type PublicKey struct {
+	ID  [8]byte
+	Key ed25519.PublicKey
+}
+
+// PrivateKey signs content
+type PrivateKey struct {
+	ID  [8]byte
+	Key ed25519.PrivateKey
+}
+
+// Signature is a parsed .minisig file
+type Signature struct {
+	Algorithm      [2]byte
+	KeyID          [8]byte
+	Signature      []byte
+	TrustedComment string
+	GlobalSig      []byte
+}
+
+// KeyIDString formats a key ID the way minisign prints it
+func KeyIDString(id [8]byte) string {
+	reversed := make([]byte, 8)
+	for i := range id {
+		reversed[i] = id[7-i]
+	}
+	return fmt.Sprintf("%X", reversed)
+}
+
+// ParsePublicKey parses a minisign public key file (or the bare base64 line)
+func ParsePublicKey(content []byte) (PublicKey, error) {
+	raw, err := decodeKeyLine(content)
+	if err != nil {
+		return PublicKey{}, fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(raw) != 42 || !bytes.Equal(raw[:2], algLegacy[:]) {
+		return PublicKey{}, fmt.Errorf("invalid public key: unsupported format")
+	}
+
+	var pk PublicKey
+	copy(pk.ID[:], raw[2:10])
+	pk.Key = ed25519.PublicKey(append([]byte(nil), raw[10:]...))
+	return pk, nil
+}
+
+// ParsePrivateKey parses a minisign secret key file, decrypting it with password if needed
+func ParsePrivateKey(content []byte, password string) (PrivateKey, error) {
+	raw, err := decodeKeyLine(content)
+	if err != nil {
+		return PrivateKey{}, fmt.Errorf("invalid secret key: %w", err)
+	}
+	// sig alg (2) + kdf alg (2) + checksum alg (2) + salt (32) + opslimit (8) + memlimit (8) + keynum (104)
+	if len(raw) != 158 || !bytes.Equal(raw[:2], algLegacy[:]) || !bytes.Equal(raw[4:6], checksumAlg[:]) {
+		return PrivateKey{}, fmt.Errorf("invalid secret key: unsupported format")
+	}
+
+	keynum := append([]byte(nil), raw[54:]...)
+	var kdf [2]byte
+	copy(kdf[:], raw[2:4])
+	switch kdf {
+	case kdfNone:
+	case kdfScrypt:
+		if password == "" {
+			return PrivateKey{}, fmt.Errorf("secret key is encrypted; set %s", PasswordEnv)
+		}
+		salt := raw[6:38]
+		opsLimit := binary.LittleEndian.Uint64(raw[38:46])
+		memLimit := binary.LittleEndian.Uint64(raw[46:54])
+		stream, err := deriveKeyStream(password, salt, opsLimit, memLimit, len(keynum))
+		if err != nil {
+			return PrivateKey{}, err
+		}
+		for i := range keynum {
+			keynum[i] ^= stream[i]
+		}
+	default:
+		return PrivateKey{}, fmt.Errorf("invalid secret key: unsupported key derivation")
+	}
+
+	// keynum: key ID (8) + ed25519 secret key (64) + checksum (32)
+	checksum := blake2b.Sum256(append(append(algLegacy[:2:2], keynum[:8]...), keynum[8:72]...))
+	if !bytes.Equal(checksum[:], keynum[72:]) {
+		if kdf == kdfScrypt {
+			return PrivateKey{}, fmt.Errorf("wrong password for secret key")
+		}
+		return PrivateKey{}, fmt.Errorf("invalid secret key: checksum mismatch")
+	}
+
+	var sk PrivateKey
+	copy(sk.ID[:], keynum[:8])
+	sk.Key = ed25519.PrivateKey(append([]byte(nil), keynum[8:72]...))
+	return sk, nil
+}
+
+// Public returns the public half of the key
+func (sk PrivateKey) Public() PublicKey {
+	return PublicKey{ID: sk.ID, Key: sk.Key.Public().(ed25519.PublicKey)}
+}
+
+// Sign returns a prehashed minisign signature file for content
+func (sk PrivateKey) Sign(content []byte, trustedComment string) []byte {
+	if trustedComment == "" {
+		trustedComment = fmt.Sprintf("timestamp:%d", time.Now().Unix())
+	}
+
+	digest := blake2b.Sum512(content)
+	sig := ed25519.Sign(sk.Key, digest[:])
+	globalSig := ed25519.Sign(sk.Key, append(append([]byte(nil), sig...), trustedComment...))
+
+	blob := append(append(algPrehashed[:2:2], sk.ID[:]...), sig...)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "untrusted comment: signature from iago secret key %s\n", KeyIDString(sk.ID))
+	buf.WriteString(base64.StdEncoding.EncodeToString(blob) + "\n")
+	fmt.Fprintf(&buf, "trusted comment: %s\n", trustedComment)
+	buf.WriteString(base64.StdEncoding.EncodeToString(globalSig) + "\n")
+	return buf.Bytes()
+}
+
+// ParseSignature parses a .minisig file
+func ParseSignature(content []byte) (Signature, error) {
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return Signature{}, fmt.Errorf("invalid signature: expected 4 lines")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 74 {
+		return Signature{}, fmt.Errorf("invalid signature encoding")
+	}
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return Signature{}, fmt.Errorf("invalid trusted comment signature encoding")
+	}
+
+	var sig Signature
+	copy(sig.Algorithm[:], raw[:2])
+	copy(sig.KeyID[:], raw[2:10])
+	sig.Signature = raw[10:]
+	sig.TrustedComment = strings.TrimPrefix(lines[2], "trusted comment: ")
+	sig.GlobalSig = globalSig
+	return sig, nil
+}
+
+// Verify checks content against a signature file, returning the trusted comment
+func (pk PublicKey) Verify(content, signature []byte) (string, error) {
+	sig, err := ParseSignature(signature)
+	if err != nil {
+		return "", err
+	}
+	if sig.KeyID != pk.ID {
+		return "", fmt.Errorf("%w: signed by key %s, expected %s", ErrSignatureInvalid, KeyIDString(sig.KeyID), KeyIDString(pk.ID))
+	}
+
+	message := content
+	switch sig.Algorithm {
+	case algPrehashed:
+		digest := blake2b.Sum512(content)
+		message = digest[:]
+	case algLegacy:
+	default:
+		return "", fmt.Errorf("invalid signature: unsupported algorithm")
+	}
+
+	if !ed25519.Verify(pk.Key, message, sig.Signature) {
+		return "", ErrSignatureInvalid
+	}
+	if !ed25519.Verify(pk.Key, append(append([]byte(nil), sig.Signature...), sig.TrustedComment...), sig.GlobalSig) {
+		return "", fmt.Errorf("%w: trusted comment was modified", ErrSignatureInvalid)
+	}
+	return sig.TrustedComment, nil
+}
+
+// GenerateKey creates an unencrypted key pair, returning the public and secret key files
+func GenerateKey() (publicKey, secretKey []byte, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key ID: %w", err)
+	}
+
+	pubBlob := append(append(algLegacy[:2:2], id[:]...), pub...)
+	publicKey = []byte(fmt.Sprintf("untrusted comment: iago public key %s\n%s\n", KeyIDString(id), base64.StdEncoding.EncodeToString(pubBlob)))
+
+	checksum := blake2b.Sum256(append(append(algLegacy[:2:2], id[:]...), priv...))
+	secBlob := append([]byte(nil), algLegacy[:]...)
+	secBlob = append(secBlob, kdfNone[:]...)
+	secBlob = append(secBlob, checksumAlg[:]...)
+	secBlob = append(secBlob, make([]byte, 48)...) // unused salt, opslimit and memlimit
+	secBlob = append(secBlob, id[:]...)
+	secBlob = append(secBlob, priv...)
+	secBlob = append(secBlob, checksum[:]...)
+	secretKey = []byte(fmt.Sprintf("untrusted comment: iago secret key (unencrypted)\n%s\n", base64.StdEncoding.EncodeToString(secBlob)))
+
+	return publicKey, secretKey, nil
+}
+
+// LoadPrivateKey reads a secret key file, taking the password from the environment
+func LoadPrivateKey(path string) (PrivateKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return PrivateKey{}, fmt.Errorf("failed to read secret key %s: %w", path, err)
+	}
+	return ParsePrivateKey(content, os.Getenv(PasswordEnv))
+}
+
+// LoadPublicKey reads a public key file
+func LoadPublicKey(path string) (PublicKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return PublicKey{}, fmt.Errorf("failed to read public key %s: %w", path, err)
+	}
+	return ParsePublicKey(content)
+}
+
+// decodeKeyLine returns the base64 payload of a key file, skipping comment lines
+func decodeKeyLine(content []byte) ([]byte, error) {
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		return base64.StdEncoding.DecodeString(line)
+	}
+	return nil, fmt.Errorf("no key data")
+}
+
+// deriveKeyStream reproduces libsodium's scrypt parameter choice for minisign keys
+func deriveKeyStream(password string, salt []byte, opsLimit, memLimit uint64, length int) ([]byte, error) {
+	const r = 8
+	if opsLimit < 32768 {
+		opsLimit = 32768
+	}
+
+	var logN uint
+	p := uint64(1)
+	if opsLimit < memLimit/32 {
+		maxN := opsLimit / (r * 4)
+		for logN = 1; logN < 63; logN++ {
+			if uint64(1)<<logN > maxN/2 {
+				break
+			}
+		}
+	} else {
+		maxN := memLimit / (r * 128)
+		for logN = 1; logN < 63; logN++ {
+			if uint64(1)<<logN > maxN/2 {
+				break
+			}
+		}
+		maxRP := (opsLimit / 4) / (uint64(1) << logN)
+		if maxRP > 0x3fffffff {
+			maxRP = 0x3fffffff
+		}
+		p = maxRP / r
+	}
+
+	stream, err := scrypt.Key([]byte(password), salt, 1<<logN, r, int(p), length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive secret key: %w", err)
+	}
+	return stream, nil
+}
@@ -0,0 +1,101 @@
+package signing
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	pubFile, secFile, err := GenerateKey()
+	require.NoError(t, err)
+
+	sk, err := ParsePrivateKey(secFile, "")
+	require.NoError(t, err)
+	pk, err := ParsePublicKey(pubFile)
+	require.NoError(t, err)
+	assert.Equal(t, sk.Public(), pk)
+
+	content := []byte(`{"ignition":{"version":"3.4.0"}}`)
+	sig := sk.Sign(content, "file:web.ign")
+
+	comment, err := pk.Verify(content, sig)
+	require.NoError(t, err)
+	assert.Equal(t, "file:web.ign", comment)
+
+	_, err = pk.Verify([]byte(`{"ignition":{"version":"3.4.0"},"passwd":{}}`), sig)
+	assert.ErrorIs(t, err, ErrSignatureInvalid)
+
+	otherPub, _, err := GenerateKey()
+	require.NoError(t, err)
+	other, err := ParsePublicKey(otherPub)
+	require.NoError(t, err)
+	_, err = other.Verify(content, sig)
+	assert.ErrorContains(t, err, "signed by key")
+}
+
+func TestVerify_TamperedTrustedComment(t *testing.T) {
+	pubFile, secFile, err := GenerateKey()
+	require.NoError(t, err)
+	sk, err := ParsePrivateKey(secFile, "")
+	require.NoError(t, err)
+	pk, err := ParsePublicKey(pubFile)
+	require.NoError(t, err)
+
+	content := []byte("config")
+	sig := sk.Sign(content, "file:a.ign")
+	tampered := []byte(replaceLine(string(sig), 2, "trusted comment: file:b.ign"))
+
+	_, err = pk.Verify(content, tampered)
+	assert.ErrorContains(t, err, "trusted comment was modified")
+}
+
+func TestParsePrivateKey_Encrypted(t *testing.T) {
+	_, secFile, err := GenerateKey()
+	require.NoError(t, err)
+	raw, err := decodeKeyLine(secFile)
+	require.NoError(t, err)
+
+	// Re-encrypt with cheap scrypt limits, as minisign would with its own
+	copy(raw[2:4], kdfScrypt[:])
+	copy(raw[6:38], []byte("0123456789abcdef0123456789abcdef"))
+	binary.LittleEndian.PutUint64(raw[38:46], 32768)
+	binary.LittleEndian.PutUint64(raw[46:54], 1<<20)
+	stream, err := deriveKeyStream("hunter2", raw[6:38], 32768, 1<<20, 104)
+	require.NoError(t, err)
+	for i := range stream {
+		raw[54+i] ^= stream[i]
+	}
+	encrypted := []byte("untrusted comment: encrypted\n" + base64.StdEncoding.EncodeToString(raw) + "\n")
+
+	_, err = ParsePrivateKey(encrypted, "")
+	assert.ErrorContains(t, err, PasswordEnv)
+
+	_, err = ParsePrivateKey(encrypted, "wrong")
+	assert.ErrorContains(t, err, "wrong password")
+
+	_, err = ParsePrivateKey(encrypted, "hunter2")
+	assert.NoError(t, err)
+}
+
+func TestParseSignature_Invalid(t *testing.T) {
+	_, err := ParseSignature([]byte("not a signature"))
+	assert.ErrorContains(t, err, "invalid signature")
+}
+
+func replaceLine(s string, index int, line string) string {
+	lines := strings.Split(s, "\n")
+	lines[index] = line
+	return strings.Join(lines, "\n")
+}
+
+func TestParsePublicKey_Minisign(t *testing.T) {
+	// Public key published in the minisign README
+	pk, err := ParsePublicKey([]byte("untrusted comment: minisign public key E7620F1842B4E81F\nRWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y7GFO3\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "E7620F1842B4E81F", KeyIDString(pk.ID))
+}
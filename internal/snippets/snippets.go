@@ -0,0 +1,59 @@
+package snippets
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+//go:embed catalog/*.yaml
+var catalog embed.FS
+
+const descriptionPrefix = "# description:"
+
+// Snippet is a curated butane fragment that machines can opt into by name
+type Snippet struct {
+	Name        string
+	Description string
+	Content     string // butane YAML, rendered as a Go template with the machine's data
+}
+
+// List returns all snippets in the embedded catalog, sorted by name
+func List() ([]Snippet, error) {
+	entries, err := catalog.ReadDir("catalog")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snippet catalog: %w", err)
+	}
+
+	var result []Snippet
+	for _, entry := range entries {
+		snippet, err := Get(strings.TrimSuffix(entry.Name(), ".yaml"))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, snippet)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// Get returns a snippet by name
+func Get(name string) (Snippet, error) {
+	content, err := catalog.ReadFile(path.Join("catalog", name+".yaml"))
+	if err != nil {
+		return Snippet{}, fmt.Errorf("unknown snippet '%s' (see 'iago snippets list')", name)
+	}
+
+	snippet := Snippet{Name: name, Content: string(content)}
+	firstLine, _, _ := strings.Cut(snippet.Content, "\n")
+	if strings.HasPrefix(firstLine, descriptionPrefix) {
+		snippet.Description = strings.TrimSpace(strings.TrimPrefix(firstLine, descriptionPrefix))
+	}
+
+	return snippet, nil
+}
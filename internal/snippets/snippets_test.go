@@ -0,0 +1,43 @@
+package snippets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestList(t *testing.T) {
+	all, err := List()
+	require.NoError(t, err)
+	require.NotEmpty(t, all)
+
+	for i, snippet := range all {
+		assert.NotEmpty(t, snippet.Description, "snippet %s should have a description", snippet.Name)
+		if i > 0 {
+			assert.Less(t, all[i-1].Name, snippet.Name, "snippets should be sorted by name")
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	snippet, err := Get("autologin")
+	require.NoError(t, err)
+	assert.Equal(t, "autologin", snippet.Name)
+	assert.Contains(t, snippet.Content, "{{ .User.Username }}")
+
+	_, err = Get("does-not-exist")
+	assert.ErrorContains(t, err, "unknown snippet 'does-not-exist'")
+}
+
+func TestCatalogIsValidYAML(t *testing.T) {
+	all, err := List()
+	require.NoError(t, err)
+
+	for _, snippet := range all {
+		var parsed map[string]interface{}
+		assert.NoError(t, yaml.Unmarshal([]byte(snippet.Content), &parsed), "snippet %s should be valid YAML", snippet.Name)
+		assert.NotContains(t, parsed, "variant", "snippet %s should only contain mergeable sections", snippet.Name)
+	}
+}
@@ -0,0 +1,60 @@
+package state
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Provenance traces a pushed image back to the source and build that produced it
+type Provenance struct {
+	GitCommit       string    `json:"git_commit,omitempty"` // Last commit touching the container directory
+	GitDirty        bool      `json:"git_dirty,omitempty"`  // Uncommitted changes in the container directory
+	BuilderHost     string    `json:"builder_host"`
+	BuiltAt         time.Time `json:"built_at"`
+	Duration        string    `json:"duration"`
+	BaseImage       string    `json:"base_image,omitempty"`
+	BaseImageDigest string    `json:"base_image_digest,omitempty"`
+	ImageDigest     string    `json:"image_digest,omitempty"`
+	Flags           []string  `json:"flags,omitempty"`
+	Source          string    `json:"source,omitempty"` // Image pushed with --from; set when iago did not build it
+}
+
+// NewProvenance captures git and host details for a build of contextPath
+// started at startedAt. Git details are left empty outside a repository.
+func NewProvenance(contextPath string, startedAt time.Time, flags []string) *Provenance {
+	p := &Provenance{
+		BuiltAt:  startedAt.UTC(),
+		Duration: time.Since(startedAt).Round(time.Millisecond).String(),
+		Flags:    flags,
+	}
+
+	if host, err := os.Hostname(); err == nil {
+		p.BuilderHost = host
+	}
+
+	if out, err := exec.Command("git", "log", "-1", "--format=%H", "--", contextPath).Output(); err == nil {
+		p.GitCommit = strings.TrimSpace(string(out))
+	}
+	if out, err := exec.Command("git", "status", "--porcelain", "--", contextPath).Output(); err == nil {
+		p.GitDirty = len(strings.TrimSpace(string(out))) > 0
+	}
+
+	return p
+}
+
+// ExternalProvenance records a push of an image iago did not build, read from
+// source. It carries no git details: the container directory did not produce it.
+func ExternalProvenance(source string, startedAt time.Time, flags []string) *Provenance {
+	p := &Provenance{
+		BuiltAt:  startedAt.UTC(),
+		Duration: time.Since(startedAt).Round(time.Millisecond).String(),
+		Flags:    flags,
+		Source:   source,
+	}
+	if host, err := os.Hostname(); err == nil {
+		p.BuilderHost = host
+	}
+	return p
+}
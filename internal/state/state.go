@@ -0,0 +1,104 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/andreweick/iago/internal/store"
+)
+
+// State records what iago has done so commands can skip redundant work
+type State struct {
+	Workloads map[string]WorkloadState `json:"workloads,omitempty"`
+	Machines  map[string]MachineState  `json:"machines,omitempty"`
+}
+
+// WorkloadState tracks the last pushed build of a workload
+type WorkloadState struct {
+	ContextDigest string      `json:"context_digest"`
+	ImageRef      string      `json:"image_ref"`
+	PushedAt      time.Time   `json:"pushed_at"`
+	Provenance    *Provenance `json:"provenance,omitempty"`
+}
+
+// MachineState tracks the last pipeline step completed for a machine
+type MachineState struct {
+	LastStep  string    `json:"last_step"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Load reads workload and machine records from the store
+func Load(st store.Store) (*State, error) {
+	s := &State{
+		Workloads: make(map[string]WorkloadState),
+		Machines:  make(map[string]MachineState),
+	}
+
+	err := st.ForEach(store.BucketWorkloads, func(key string, value []byte) error {
+		var ws WorkloadState
+		if err := json.Unmarshal(value, &ws); err != nil {
+			return fmt.Errorf("failed to parse workload state %s: %w", key, err)
+		}
+		s.Workloads[key] = ws
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = st.ForEach(store.BucketMachines, func(key string, value []byte) error {
+		var ms MachineState
+		if err := json.Unmarshal(value, &ms); err != nil {
+			return fmt.Errorf("failed to parse machine state %s: %w", key, err)
+		}
+		s.Machines[key] = ms
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Save writes every workload and machine record to the store
+func (s *State) Save(st store.Store) error {
+	for name, ws := range s.Workloads {
+		content, err := json.Marshal(ws)
+		if err != nil {
+			return fmt.Errorf("failed to encode workload state %s: %w", name, err)
+		}
+		if err := st.Put(store.BucketWorkloads, name, content); err != nil {
+			return err
+		}
+	}
+	for name, ms := range s.Machines {
+		content, err := json.Marshal(ms)
+		if err != nil {
+			return fmt.Errorf("failed to encode machine state %s: %w", name, err)
+		}
+		if err := st.Put(store.BucketMachines, name, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordPush stores the context digest, image and provenance of a successful push
+func (s *State) RecordPush(workload, contextDigest, imageRef string, provenance *Provenance) {
+	s.Workloads[workload] = WorkloadState{
+		ContextDigest: contextDigest,
+		ImageRef:      imageRef,
+		PushedAt:      time.Now().UTC(),
+		Provenance:    provenance,
+	}
+}
+
+// RecordStep stores the last completed pipeline step for a machine
+func (s *State) RecordStep(machineName, step string) {
+	s.Machines[machineName] = MachineState{
+		LastStep:  step,
+		UpdatedAt: time.Now().UTC(),
+	}
+}
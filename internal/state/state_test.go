@@ -0,0 +1,97 @@
+package state
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andreweick/iago/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openStore(t *testing.T) *store.BoltStore {
+	db, err := store.Open(filepath.Join(t.TempDir(), ".iago", "iago.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestLoad_EmptyStore(t *testing.T) {
+	s, err := Load(openStore(t))
+
+	require.NoError(t, err)
+	assert.Empty(t, s.Workloads)
+	assert.Empty(t, s.Machines)
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	db := openStore(t)
+
+	s, err := Load(db)
+	require.NoError(t, err)
+	s.RecordPush("it-tools", "sha256:abc", "ghcr.io/test/it-tools:latest", nil)
+	s.RecordStep("it-tools", "deploy")
+	require.NoError(t, s.Save(db))
+
+	loaded, err := Load(db)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:abc", loaded.Workloads["it-tools"].ContextDigest)
+	assert.Equal(t, "ghcr.io/test/it-tools:latest", loaded.Workloads["it-tools"].ImageRef)
+	assert.False(t, loaded.Workloads["it-tools"].PushedAt.IsZero())
+	assert.Equal(t, "deploy", loaded.Machines["it-tools"].LastStep)
+}
+
+func TestLoad_InvalidRecord(t *testing.T) {
+	db := openStore(t)
+	require.NoError(t, db.Put(store.BucketWorkloads, "it-tools", []byte("{not json")))
+
+	_, err := Load(db)
+	assert.ErrorContains(t, err, "failed to parse workload state it-tools")
+}
+
+func TestNewProvenance(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	git("init", "-q")
+	require.NoError(t, os.MkdirAll(filepath.Join(repo, "containers", "app"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "containers", "app", "Containerfile"), []byte("FROM alpine\n"), 0644))
+	git("add", "-A")
+	git("commit", "-qm", "add app")
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(repo))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(oldWd))
+	})
+
+	p := NewProvenance("containers/app", time.Now().Add(-time.Second), []string{"--sign=true"})
+	assert.Len(t, p.GitCommit, 40)
+	assert.False(t, p.GitDirty)
+	assert.NotEmpty(t, p.BuilderHost)
+	assert.NotEqual(t, "0s", p.Duration)
+	assert.Equal(t, []string{"--sign=true"}, p.Flags)
+
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "containers", "app", "extra.conf"), []byte("x"), 0644))
+	assert.True(t, NewProvenance("containers/app", time.Now(), nil).GitDirty)
+}
+
+func TestExternalProvenance(t *testing.T) {
+	p := ExternalProvenance("oci-archive:app.tar", time.Now().Add(-time.Second), []string{"--sign=true"})
+	assert.Equal(t, "oci-archive:app.tar", p.Source)
+	assert.Empty(t, p.GitCommit)
+	assert.False(t, p.GitDirty)
+	assert.NotEmpty(t, p.Duration)
+	assert.Equal(t, []string{"--sign=true"}, p.Flags)
+}
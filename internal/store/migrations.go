@@ -0,0 +1,113 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// LegacyStatePath is the JSON state file iago used before the store existed,
+// relative to the store's directory
+const LegacyStatePath = "state.json"
+
+// migration upgrades the schema by one version inside a single transaction;
+// dir is the directory holding the database
+type migration struct {
+	description string
+	apply       func(tx *bolt.Tx, dir string) error
+}
+
+// migrations are applied in order; append new ones, never reorder or edit old ones
+var migrations = []migration{
+	{"create workload and machine buckets", createBuckets},
+	{"import " + LegacyStatePath, importLegacyState},
+	{"create frozen bucket", createFrozenBucket},
+}
+
+// LatestSchemaVersion is the schema version after all migrations are applied
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// migrate applies every migration newer than the database's schema version
+func (s *BoltStore) migrate(dir string) error {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("store schema version %d is newer than this iago supports (%d); upgrade iago", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		m := migrations[i]
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			if err := m.apply(tx, dir); err != nil {
+				return err
+			}
+			meta, err := tx.CreateBucketIfNotExists([]byte(bucketMeta))
+			if err != nil {
+				return err
+			}
+			return meta.Put([]byte("schema_version"), []byte(strconv.Itoa(i+1)))
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply store migration %d (%s): %w", i+1, m.description, err)
+		}
+	}
+	return nil
+}
+
+func createBuckets(tx *bolt.Tx, _ string) error {
+	for _, name := range []string{BucketWorkloads, BucketMachines} {
+		if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createFrozenBucket(tx *bolt.Tx, _ string) error {
+	_, err := tx.CreateBucketIfNotExists([]byte(BucketFrozen))
+	return err
+}
+
+// importLegacyState copies records from .iago/state.json; the file is left in
+// place so older iago versions keep working, but is no longer read
+func importLegacyState(tx *bolt.Tx, dir string) error {
+	content, err := os.ReadFile(filepath.Join(dir, LegacyStatePath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var legacy struct {
+		Workloads map[string]json.RawMessage `json:"workloads"`
+		Machines  map[string]json.RawMessage `json:"machines"`
+	}
+	if err := json.Unmarshal(content, &legacy); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", LegacyStatePath, err)
+	}
+
+	for bucket, entries := range map[string]map[string]json.RawMessage{
+		BucketWorkloads: legacy.Workloads,
+		BucketMachines:  legacy.Machines,
+	} {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		for key, value := range entries {
+			if err := b.Put([]byte(key), value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// DefaultPath is the embedded database holding workstation-local records
+const DefaultPath = ".iago/iago.db"
+
+// Buckets used by iago; values are JSON documents
+const (
+	BucketWorkloads = "workloads" // Last pushed build per workload
+	BucketMachines  = "machines"  // Last pipeline step per machine
+	BucketFrozen    = "frozen"    // Secrets and fetched content iago freeze keeps off the repository
+	bucketMeta      = "meta"      // Schema version; not exported
+)
+
+// Store is a bucketed key/value store. Get returns nil for missing keys.
+type Store interface {
+	Get(bucket, key string) ([]byte, error)
+	Put(bucket, key string, value []byte) error
+	Delete(bucket, key string) error
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+	Buckets() ([]string, error)
+	SchemaVersion() (int, error)
+	Close() error
+}
+
+// Dump is the portable form of a store used by export and import
+type Dump struct {
+	SchemaVersion int                                   `json:"schema_version"`
+	Buckets       map[string]map[string]json.RawMessage `json:"buckets"`
+}
+
+// Export writes every bucket of s to w as a JSON Dump
+func Export(s Store, w io.Writer) error {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	buckets, err := s.Buckets()
+	if err != nil {
+		return err
+	}
+
+	dump := Dump{SchemaVersion: version, Buckets: make(map[string]map[string]json.RawMessage)}
+	for _, bucket := range buckets {
+		if bucket == bucketMeta {
+			continue
+		}
+		entries := make(map[string]json.RawMessage)
+		err := s.ForEach(bucket, func(key string, value []byte) error {
+			if !json.Valid(value) {
+				return fmt.Errorf("%s/%s is not a JSON document", bucket, key)
+			}
+			entries[key] = append(json.RawMessage(nil), value...)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to export bucket %s: %w", bucket, err)
+		}
+		dump.Buckets[bucket] = entries
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(dump); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	return nil
+}
+
+// Import reads a Dump from r into s, replacing entries with the same key.
+// It returns the number of entries written.
+func Import(s Store, r io.Reader) (int, error) {
+	var dump Dump
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return 0, fmt.Errorf("failed to parse export: %w", err)
+	}
+
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	if dump.SchemaVersion > version {
+		return 0, fmt.Errorf("export has schema version %d but this iago only understands up to %d; upgrade iago", dump.SchemaVersion, version)
+	}
+
+	buckets := make([]string, 0, len(dump.Buckets))
+	for bucket := range dump.Buckets {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	count := 0
+	for _, bucket := range buckets {
+		if bucket == bucketMeta {
+			continue
+		}
+		for key, value := range dump.Buckets[bucket] {
+			if err := s.Put(bucket, key, value); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen_AppliesMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".iago", "iago.db")
+	db, err := Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	version, err := db.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, LatestSchemaVersion(), version)
+
+	buckets, err := db.Buckets()
+	require.NoError(t, err)
+	assert.Contains(t, buckets, BucketWorkloads)
+	assert.Contains(t, buckets, BucketMachines)
+	assert.Contains(t, buckets, BucketFrozen)
+}
+
+func TestOpen_ImportsLegacyState(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), ".iago")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, LegacyStatePath), []byte(`{
+  "workloads": {"it-tools": {"context_digest": "sha256:abc", "image_ref": "ghcr.io/test/it-tools:latest"}},
+  "machines": {"it-tools": {"last_step": "deploy"}}
+}`), 0644))
+
+	db, err := Open(filepath.Join(dir, "iago.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	value, err := db.Get(BucketWorkloads, "it-tools")
+	require.NoError(t, err)
+	assert.Contains(t, string(value), "sha256:abc")
+	value, err = db.Get(BucketMachines, "it-tools")
+	require.NoError(t, err)
+	assert.Contains(t, string(value), "deploy")
+}
+
+func TestOpen_ReopenKeepsData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iago.db")
+	db, err := Open(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Put(BucketMachines, "web", []byte(`{"last_step":"wait"}`)))
+	require.NoError(t, db.Close())
+
+	db, err = Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+	value, err := db.Get(BucketMachines, "web")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"last_step":"wait"}`, string(value))
+
+	require.NoError(t, db.Delete(BucketMachines, "web"))
+	value, err = db.Get(BucketMachines, "web")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+func TestInspect(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), ".iago")
+	_, err := Inspect(filepath.Join(dir, "iago.db"))
+	assert.True(t, os.IsNotExist(err))
+	assert.NoDirExists(t, dir, "inspecting doesn't create the store")
+
+	db, err := Open(filepath.Join(dir, "iago.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	version, err := Inspect(filepath.Join(dir, "iago.db"))
+	require.NoError(t, err)
+	assert.Equal(t, LatestSchemaVersion(), version)
+}
+
+func TestExportImport(t *testing.T) {
+	src, err := Open(filepath.Join(t.TempDir(), "src.db"))
+	require.NoError(t, err)
+	defer src.Close()
+	require.NoError(t, src.Put(BucketWorkloads, "it-tools", []byte(`{"image_ref":"ghcr.io/test/it-tools:latest"}`)))
+	require.NoError(t, src.Put(BucketMachines, "web", []byte(`{"last_step":"deploy"}`)))
+
+	var buf bytes.Buffer
+	require.NoError(t, Export(src, &buf))
+	assert.NotContains(t, buf.String(), bucketMeta, "schema bookkeeping should not be exported")
+
+	dst, err := Open(filepath.Join(t.TempDir(), "dst.db"))
+	require.NoError(t, err)
+	defer dst.Close()
+	count, err := Import(dst, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	value, err := dst.Get(BucketMachines, "web")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"last_step":"deploy"}`, string(value))
+}
+
+func TestImport_NewerSchema(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "iago.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = Import(db, strings.NewReader(`{"schema_version": 999, "buckets": {}}`))
+	assert.ErrorContains(t, err, "upgrade iago")
+}
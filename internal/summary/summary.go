@@ -0,0 +1,126 @@
+package summary
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Status is the outcome of one row in a summary
+type Status string
+
+const (
+	StatusOK      Status = "ok"
+	StatusFailed  Status = "failed"
+	StatusSkipped Status = "skipped"
+)
+
+// Result is one machine, workload or check in a summary
+type Result struct {
+	Name     string `json:"name" yaml:"name"`
+	Status   Status `json:"status" yaml:"status"`
+	Detail   string `json:"detail,omitempty" yaml:"detail,omitempty"`
+	Artifact string `json:"artifact,omitempty" yaml:"artifact,omitempty"` // Path of the file produced, if any
+}
+
+// Summary is a markdown report of a fleet-wide command for CI step summaries
+type Summary struct {
+	Title   string
+	Results []Result
+}
+
+// New starts a summary with the given heading
+func New(title string) *Summary {
+	return &Summary{Title: title}
+}
+
+// Add records a result
+func (s *Summary) Add(name string, status Status, detail, artifact string) {
+	s.Results = append(s.Results, Result{Name: name, Status: status, Detail: detail, Artifact: artifact})
+}
+
+// Failed reports whether any result failed
+func (s *Summary) Failed() bool {
+	for _, r := range s.Results {
+		if r.Status == StatusFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// Markdown renders the summary as a heading, a count line and a results table
+func (s *Summary) Markdown() string {
+	var buf bytes.Buffer
+	counts := map[Status]int{}
+	for _, r := range s.Results {
+		counts[r.Status]++
+	}
+
+	icon := "✅"
+	if s.Failed() {
+		icon = "❌"
+	}
+	fmt.Fprintf(&buf, "### %s %s\n\n", icon, s.Title)
+	fmt.Fprintf(&buf, "%d ok, %d failed, %d skipped\n\n", counts[StatusOK], counts[StatusFailed], counts[StatusSkipped])
+
+	if len(s.Results) > 0 {
+		buf.WriteString("| | Name | Detail | Artifact |\n")
+		buf.WriteString("|---|---|---|---|\n")
+		for _, r := range s.Results {
+			artifact := ""
+			if r.Artifact != "" {
+				artifact = "`" + r.Artifact + "`"
+			}
+			fmt.Fprintf(&buf, "| %s | %s | %s | %s |\n", statusIcon(r.Status), escape(r.Name), escape(r.Detail), artifact)
+		}
+		buf.WriteString("\n")
+	}
+
+	if link := runLink(); link != "" {
+		fmt.Fprintf(&buf, "Artifacts: [workflow run](%s)\n\n", link)
+	}
+	return buf.String()
+}
+
+// Write appends the summary to path in a single write, so concurrent jobs
+// sharing a step summary file don't interleave their tables
+func (s *Summary) Write(path string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open summary file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write([]byte(s.Markdown())); err != nil {
+		return fmt.Errorf("failed to write summary file %s: %w", path, err)
+	}
+	return nil
+}
+
+// runLink points at the GitHub Actions run when running there
+func runLink() string {
+	server, repo, runID := os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID")
+	if server == "" || repo == "" || runID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/actions/runs/%s", server, repo, runID)
+}
+
+func statusIcon(status Status) string {
+	switch status {
+	case StatusOK:
+		return "✅"
+	case StatusFailed:
+		return "❌"
+	default:
+		return "⏭️"
+	}
+}
+
+// escape keeps details on one table row
+func escape(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
@@ -0,0 +1,56 @@
+package summary
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarkdown(t *testing.T) {
+	t.Setenv("GITHUB_SERVER_URL", "https://github.com")
+	t.Setenv("GITHUB_REPOSITORY", "andreweick/iago")
+	t.Setenv("GITHUB_RUN_ID", "42")
+
+	s := New("iago ignite --all")
+	s.Add("web", StatusOK, "", "output/ignition/web.ign")
+	s.Add("db", StatusFailed, "bad | template\nline 2", "")
+
+	md := s.Markdown()
+	assert.True(t, s.Failed())
+	assert.Contains(t, md, "### ❌ iago ignite --all")
+	assert.Contains(t, md, "1 ok, 1 failed, 0 skipped")
+	assert.Contains(t, md, "| ✅ | web |  | `output/ignition/web.ign` |")
+	assert.Contains(t, md, "| ❌ | db | bad \\| template<br>line 2 |  |")
+	assert.Contains(t, md, "https://github.com/andreweick/iago/actions/runs/42")
+}
+
+func TestWrite_ConcurrentAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.md")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := New("job")
+			for j := 0; j < 20; j++ {
+				s.Add("machine", StatusOK, "", "")
+			}
+			assert.NoError(t, s.Write(path))
+		}()
+	}
+	wg.Wait()
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	sections := strings.Split(strings.TrimSpace(string(content)), "### ")
+	assert.Len(t, sections, 9) // leading empty element plus one per job
+	for _, section := range sections[1:] {
+		assert.Equal(t, 20, strings.Count(section, "| ✅ | machine |"), "each job's table should be contiguous")
+	}
+}
@@ -0,0 +1,260 @@
+package sysext
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/andreweick/iago/internal/fleet"
+)
+
+// Extension kinds, named after the systemd tool that merges them: a sysext
+// extends /usr and /opt, a confext /etc
+const (
+	KindSysext  = "sysext"
+	KindConfext = "confext"
+)
+
+// Files the pull mechanism installs itself. They stay in the ignition only, so
+// a bad image can't break fetching the next one.
+const (
+	PullUnit        = "iago-sysext-pull"
+	PullScriptPath  = "/usr/local/bin/iago-sysext-pull"
+	CertificatePath = "/etc/verity.d/iago-sysext.crt"
+)
+
+// SumsFile lists the images of a machine with their SHA-256 checksums
+const SumsFile = "SHA256SUMS"
+
+// SumsSignatureFile is SumsFile signed with the images' signing key, as
+// 'openssl dgst -sha256 -sign' writes it. Hosts check it before trusting the
+// list, which also decides what they remove.
+const SumsSignatureFile = SumsFile + ".sig"
+
+// ImagePolicy is what hosts merge images with: the root and /usr partitions
+// must carry a dm-verity signature from a certificate in /etc/verity.d
+const ImagePolicy = "root=signed+absent:usr=signed+absent"
+
+var lookPath = exec.LookPath
+
+// ImageName is the name hosts install a machine's images as; systemd requires
+// it to match the image's extension-release file
+func ImageName(machineName string) string {
+	return "iago-" + machineName
+}
+
+// Extensions is a machine's configuration split into the images that carry it
+type Extensions struct {
+	Name    string
+	Sysext  []fleet.File // Under /usr and /opt
+	Confext []fleet.File // Under /etc, including systemd units and drop-ins
+	Skipped []string     // Paths extensions can't carry; they only change by re-provisioning
+}
+
+// FromIgnition collects the files a machine's ignition writes inline into
+// extensions. Remote sources are fetched at provisioning and aren't included.
+func FromIgnition(machineName string, ignitionJSON []byte) (*Extensions, error) {
+	files, err := fleet.InlineFiles(ignitionJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	x := &Extensions{Name: ImageName(machineName)}
+	for _, f := range files {
+		switch {
+		case f.Path == CertificatePath, f.Path == PullScriptPath, strings.HasPrefix(f.Path, "/etc/systemd/system/"+PullUnit+"."):
+			// The pull mechanism
+		case fleet.HoldsSecret(f.Path):
+			// Credentials stay in the ignition, which is only served to the machine
+			x.Skipped = append(x.Skipped, f.Path)
+		case strings.HasPrefix(f.Path, "/etc/"):
+			x.Confext = append(x.Confext, f)
+		case strings.HasPrefix(f.Path, "/usr/local/"):
+			// A symlink to /var/usrlocal on Fedora CoreOS, outside what a sysext merges
+			x.Skipped = append(x.Skipped, f.Path)
+		case strings.HasPrefix(f.Path, "/usr/"), strings.HasPrefix(f.Path, "/opt/"):
+			x.Sysext = append(x.Sysext, f)
+		default:
+			x.Skipped = append(x.Skipped, f.Path)
+		}
+	}
+	return x, nil
+}
+
+// Files returns the files of one kind of image
+func (x *Extensions) Files(kind string) []fleet.File {
+	if kind == KindSysext {
+		return x.Sysext
+	}
+	return x.Confext
+}
+
+// Stage writes the files of one kind of image, with its extension-release
+// file, under dir and returns how many there are. Nothing is written when
+// there are none.
+func (x *Extensions) Stage(kind, dir string) (int, error) {
+	files := x.Files(kind)
+	if len(files) == 0 {
+		return 0, nil
+	}
+
+	release := filepath.Join("usr", "lib", "extension-release.d", "extension-release."+x.Name)
+	if kind == KindConfext {
+		release = filepath.Join("etc", "extension-release.d", "extension-release."+x.Name)
+	}
+	// ID=_any lets the image merge on any OS release, as the files came from this machine's own ignition
+	if err := writeFile(filepath.Join(dir, release), []byte("ID=_any\n"), 0644); err != nil {
+		return 0, err
+	}
+	for _, f := range files {
+		if err := writeFile(filepath.Join(dir, filepath.FromSlash(f.Path)), f.Content, os.FileMode(f.Mode)); err != nil {
+			return 0, err
+		}
+	}
+	return len(files), nil
+}
+
+func writeFile(path string, content []byte, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, content, mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return os.Chmod(path, mode)
+}
+
+// Builder packs staged files into signed dm-verity images with systemd-repart
+type Builder struct {
+	command     string
+	key         string
+	certificate string
+	stderr      io.Writer
+}
+
+// NewBuilder finds systemd-repart (systemd 255 or later) on PATH. Images are
+// signed with the PEM key and certificate.
+func NewBuilder(key, certificate string, stderr io.Writer) (*Builder, error) {
+	if key == "" || certificate == "" {
+		return nil, fmt.Errorf("[sysext] signing_key and certificate are required to sign images (e.g. openssl req -new -x509 -newkey rsa:4096 -nodes -keyout sysext.key -out sysext.crt -days 3650 -subj /CN=iago-sysext)")
+	}
+	path, err := lookPath("systemd-repart")
+	if err != nil {
+		return nil, fmt.Errorf("systemd-repart not found: building images needs Linux with systemd 255 or later")
+	}
+	return &Builder{command: path, key: key, certificate: certificate, stderr: stderr}, nil
+}
+
+// Build writes the image of one kind with the files staged in dir to output
+func (b *Builder) Build(ctx context.Context, kind, dir, output string) error {
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	// systemd-repart refuses to replace an image
+	if err := os.Remove(output); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove old image: %w", err)
+	}
+	args := []string{
+		"--make-ddi=" + kind,
+		"--copy-source=" + dir,
+		"--private-key=" + b.key,
+		"--certificate=" + b.certificate,
+		"--offline=yes",
+		"--empty=create",
+		"--size=auto",
+		output,
+	}
+	cmd := exec.CommandContext(ctx, b.command, args...)
+	cmd.Stdout = b.stderr
+	cmd.Stderr = b.stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("systemd-repart failed to build the %s image: %w", kind, err)
+	}
+	return nil
+}
+
+// SignSums writes SumsSignatureFile in dir, signing its SumsFile with the
+// key the images are signed with
+func (b *Builder) SignSums(dir string) error {
+	content, err := os.ReadFile(filepath.Join(dir, SumsFile))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", SumsFile, err)
+	}
+	signer, err := loadSigner(b.key)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(content)
+	signature, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("failed to sign %s: %w", SumsFile, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, SumsSignatureFile), signature, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", SumsSignatureFile, err)
+	}
+	return nil
+}
+
+// loadSigner reads an RSA or ECDSA PEM private key
+func loadSigner(path string) (crypto.Signer, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read [sysext] signing_key: %w", err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("[sysext] signing_key %s is not a PEM key", path)
+	}
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse [sysext] signing_key: %w", err)
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("[sysext] signing_key must be an RSA or ECDSA key, got %T", key)
+	}
+}
+
+// WriteSums writes SumsFile in dir for the images there, in sha256sum format.
+// Hosts remove an extension whose image it doesn't list.
+func WriteSums(dir string, images []string) error {
+	sort.Strings(images)
+	var b strings.Builder
+	for _, image := range images {
+		content, err := os.ReadFile(filepath.Join(dir, image))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", image, err)
+		}
+		sum := sha256.Sum256(content)
+		fmt.Fprintf(&b, "%s  %s\n", hex.EncodeToString(sum[:]), image)
+	}
+	if err := os.WriteFile(filepath.Join(dir, SumsFile), []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", SumsFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package sysext
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const ignitionJSON = `{
+  "ignition": {"version": "3.4.0"},
+  "storage": {"files": [
+    {"path": "/etc/motd", "mode": 420, "contents": {"source": "data:,welcome%0A"}},
+    {"path": "/opt/tools/check.sh", "mode": 493, "contents": {"source": "data:,%23!%2Fbin%2Fsh%0A"}},
+    {"path": "/var/lib/app/seed", "contents": {"source": "data:,seed"}},
+    {"path": "/etc/remote.conf", "contents": {"source": "https://example.com/remote.conf"}},
+    {"path": "/usr/local/bin/iago-sysext-pull", "mode": 493, "contents": {"source": "data:,pull"}},
+    {"path": "/etc/verity.d/iago-sysext.crt", "contents": {"source": "data:,cert"}},
+    {"path": "/etc/iago/secrets/db-password", "mode": 384, "contents": {"source": "data:,hunter2"}},
+    {"path": "/etc/iago/age/identity.key", "mode": 384, "contents": {"source": "data:,AGE-SECRET-KEY"}},
+    {"path": "/etc/containers/auth.json", "mode": 384, "contents": {"source": "data:,%7B%7D"}}
+  ]},
+  "systemd": {"units": [
+    {"name": "app.service", "enabled": true, "contents": "[Service]\nExecStart=/opt/tools/check.sh\n"},
+    {"name": "iago-sysext-pull.timer", "enabled": true, "contents": "[Timer]\n"}
+  ]}
+}`
+
+func TestFromIgnition(t *testing.T) {
+	x, err := FromIgnition("web", []byte(ignitionJSON))
+	require.NoError(t, err)
+	assert.Equal(t, "iago-web", x.Name)
+
+	var confext, sysext []string
+	for _, f := range x.Confext {
+		confext = append(confext, f.Path)
+	}
+	for _, f := range x.Sysext {
+		sysext = append(sysext, f.Path)
+	}
+	assert.Equal(t, []string{"/etc/motd", "/etc/systemd/system/app.service"}, confext, "remote sources and the pull mechanism stay in the ignition")
+	assert.Equal(t, []string{"/opt/tools/check.sh"}, sysext)
+	assert.Equal(t, []string{"/etc/containers/auth.json", "/etc/iago/age/identity.key", "/etc/iago/secrets/db-password", "/var/lib/app/seed"}, x.Skipped, "credentials stay out of the images")
+}
+
+func TestExtensions_Stage(t *testing.T) {
+	x, err := FromIgnition("web", []byte(ignitionJSON))
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	count, err := x.Stage(KindConfext, filepath.Join(dir, "confext"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	release, err := os.ReadFile(filepath.Join(dir, "confext/etc/extension-release.d/extension-release.iago-web"))
+	require.NoError(t, err)
+	assert.Equal(t, "ID=_any\n", string(release))
+	motd, err := os.ReadFile(filepath.Join(dir, "confext/etc/motd"))
+	require.NoError(t, err)
+	assert.Equal(t, "welcome\n", string(motd))
+
+	_, err = x.Stage(KindSysext, filepath.Join(dir, "sysext"))
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "sysext/usr/lib/extension-release.d/extension-release.iago-web"))
+	info, err := os.Stat(filepath.Join(dir, "sysext/opt/tools/check.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	x.Sysext = nil
+	count, err = x.Stage(KindSysext, filepath.Join(dir, "empty"))
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	assert.NoDirExists(t, filepath.Join(dir, "empty"))
+}
+
+func TestBuilder(t *testing.T) {
+	_, err := NewBuilder("", "sysext.crt", io.Discard)
+	assert.ErrorContains(t, err, "signing_key and certificate are required")
+
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "calls.log")
+	script := "#!/bin/sh\necho \"$@\" >> " + logPath + "\nfor arg; do out=$arg; done\necho image > \"$out\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "systemd-repart"), []byte(script), 0755))
+	original := lookPath
+	lookPath = func(file string) (string, error) {
+		if file == "systemd-repart" {
+			return filepath.Join(dir, file), nil
+		}
+		return "", exec.ErrNotFound
+	}
+	t.Cleanup(func() { lookPath = original })
+
+	builder, err := NewBuilder("sysext.key", "sysext.crt", io.Discard)
+	require.NoError(t, err)
+	output := filepath.Join(dir, "out", "web")
+	require.NoError(t, builder.Build(context.Background(), KindConfext, "staging/confext", filepath.Join(output, "confext.raw")))
+
+	calls, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Equal(t, "--make-ddi=confext --copy-source=staging/confext --private-key=sysext.key --certificate=sysext.crt --offline=yes --empty=create --size=auto "+filepath.Join(output, "confext.raw"), strings.TrimSpace(string(calls)))
+
+	require.NoError(t, WriteSums(output, []string{"confext.raw"}))
+	sums, err := os.ReadFile(filepath.Join(output, SumsFile))
+	require.NoError(t, err)
+	// sha256 of "image\n"
+	assert.Equal(t, "254eddf15d9534e3b20c55469077aa2f24f167aa4b897a36381d3e251e4829c2  confext.raw\n", string(sums))
+}
+
+func TestBuilder_SignSums(t *testing.T) {
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	keyPath := filepath.Join(dir, "sysext.key")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+	sums := []byte("254eddf15d9534e3b20c55469077aa2f24f167aa4b897a36381d3e251e4829c2  confext.raw\n")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, SumsFile), sums, 0644))
+
+	builder := &Builder{key: keyPath}
+	require.NoError(t, builder.SignSums(dir))
+	signature, err := os.ReadFile(filepath.Join(dir, SumsSignatureFile))
+	require.NoError(t, err)
+	digest := sha256.Sum256(sums)
+	assert.True(t, ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature))
+
+	builder = &Builder{key: filepath.Join(dir, SumsFile)}
+	assert.ErrorContains(t, builder.SignSums(dir), "is not a PEM key")
+}
@@ -0,0 +1,198 @@
+package tomledit
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+var (
+	bareKey  = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	datePart = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+// scanner walks TOML source one statement at a time
+type scanner struct {
+	src string
+	pos int
+}
+
+func (s *scanner) peek() byte {
+	return s.src[s.pos]
+}
+
+func (s *scanner) skipSpace() {
+	for s.pos < len(s.src) && (s.src[s.pos] == ' ' || s.src[s.pos] == '\t') {
+		s.pos++
+	}
+}
+
+// skipLine moves past the rest of the line, including its newline
+func (s *scanner) skipLine() {
+	if i := strings.IndexByte(s.src[s.pos:], '\n'); i >= 0 {
+		s.pos += i + 1
+		return
+	}
+	s.pos = len(s.src)
+}
+
+// key reads a possibly dotted, possibly quoted key
+func (s *scanner) key() []string {
+	var parts []string
+	for {
+		s.skipSpace()
+		start := s.pos
+		var part string
+		switch {
+		case s.pos >= len(s.src):
+		case s.peek() == '"':
+			s.skipString()
+			unquoted, err := strconv.Unquote(s.src[start:s.pos])
+			if err != nil {
+				unquoted = s.src[start+1 : s.pos-1]
+			}
+			part = unquoted
+		case s.peek() == '\'':
+			s.skipString()
+			part = s.src[start+1 : s.pos-1]
+		default:
+			for s.pos < len(s.src) && isBareKeyChar(s.src[s.pos]) {
+				s.pos++
+			}
+			part = s.src[start:s.pos]
+		}
+		parts = append(parts, part)
+
+		s.skipSpace()
+		if s.pos < len(s.src) && s.peek() == '.' {
+			s.pos++
+			continue
+		}
+		return parts
+	}
+}
+
+// value moves past a value: a string, an array or inline table (which may
+// span lines and hold comments), or a scalar
+func (s *scanner) value() {
+	start := s.pos
+	depth := 0
+	for s.pos < len(s.src) {
+		c := s.src[s.pos]
+		switch {
+		case c == '"' || c == '\'':
+			s.skipString()
+			if depth == 0 {
+				return
+			}
+			continue
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+			if depth <= 0 {
+				s.pos++
+				return
+			}
+		case c == '#' && depth > 0:
+			for s.pos < len(s.src) && s.src[s.pos] != '\n' {
+				s.pos++
+			}
+			continue
+		case depth == 0 && c == ' ':
+			// A space may separate the date and time of a datetime
+			if datePart.MatchString(s.src[start:s.pos]) && s.pos+1 < len(s.src) && isDigit(s.src[s.pos+1]) {
+				break
+			}
+			return
+		case depth == 0 && (c == '\t' || c == '\n' || c == '\r' || c == '#'):
+			return
+		}
+		s.pos++
+	}
+}
+
+// skipString moves past a basic, literal or multi-line string starting at pos
+func (s *scanner) skipString() {
+	quote := s.src[s.pos]
+	delim := string([]byte{quote})
+	if strings.HasPrefix(s.src[s.pos:], strings.Repeat(delim, 3)) {
+		delim = strings.Repeat(delim, 3)
+	}
+	s.pos += len(delim)
+
+	for s.pos < len(s.src) {
+		if quote == '"' && s.src[s.pos] == '\\' {
+			s.pos += 2
+			continue
+		}
+		if strings.HasPrefix(s.src[s.pos:], delim) {
+			s.pos += len(delim)
+			// A multi-line string may end with up to two more quotes
+			for extra := 0; len(delim) == 3 && extra < 2 && s.pos < len(s.src) && s.src[s.pos] == quote; extra++ {
+				s.pos++
+			}
+			return
+		}
+		s.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c == '_' || c == '-' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// parseKeyPath splits a dotted key such as `network.ip` or `vars."my.key"`
+func parseKeyPath(key string) ([]string, error) {
+	s := &scanner{src: key}
+	path := s.key()
+	if s.pos != len(key) || slices.Contains(path, "") {
+		return nil, fmt.Errorf("invalid key %q", key)
+	}
+	return path, nil
+}
+
+func formatKey(part string) string {
+	if bareKey.MatchString(part) {
+		return part
+	}
+	return strconv.Quote(part)
+}
+
+func formatKeyPath(path []string) string {
+	parts := make([]string, len(path))
+	for i, part := range path {
+		parts[i] = formatKey(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// encodeValue writes value as TOML, the way the rest of iago's TOML is encoded
+func encodeValue(value any) (string, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(map[string]any{"v": value}); err != nil {
+		return "", err
+	}
+	encoded := strings.TrimSuffix(buf.String(), "\n")
+	if !strings.HasPrefix(encoded, "v = ") || strings.Contains(encoded, "\n") {
+		return "", fmt.Errorf("only strings, numbers, booleans, times and arrays of them can be set, not %T", value)
+	}
+	return strings.TrimPrefix(encoded, "v = "), nil
+}
+
+func equalPath(a, b []string) bool {
+	return slices.Equal(a, b)
+}
+
+func hasPrefix(path, prefix []string) bool {
+	return len(path) >= len(prefix) && slices.Equal(path[:len(prefix)], prefix)
+}
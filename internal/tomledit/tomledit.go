@@ -0,0 +1,451 @@
+// Package tomledit edits TOML files in place. A Document keeps the file's
+// source text and changes only the bytes an edit touches, so comments, blank
+// lines, key order and formatting in hand-written machine.toml and
+// defaults.toml files survive commands that rewrite them.
+package tomledit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Document is a TOML file held as source text
+type Document struct {
+	src string
+}
+
+// Parse checks that content is valid TOML and returns it as a Document
+func Parse(content []byte) (*Document, error) {
+	var v map[string]any
+	if _, err := toml.Decode(string(content), &v); err != nil {
+		return nil, err
+	}
+	return &Document{src: string(content)}, nil
+}
+
+// Load reads and parses a TOML file
+func Load(path string) (*Document, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	doc, err := Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return doc, nil
+}
+
+// Bytes returns the document's source text
+func (d *Document) Bytes() []byte {
+	return []byte(d.src)
+}
+
+// Save writes the document to path through a temporary file, so an
+// interrupted write never leaves a truncated config behind. An existing
+// file's permissions are kept.
+func (d *Document) Save(path string) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(d.src); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// Decode decodes the document into v, like toml.Decode
+func (d *Document) Decode(v any) error {
+	_, err := toml.Decode(d.src, v)
+	return err
+}
+
+// Get returns the value at a dotted key path such as "network.ip"
+func (d *Document) Get(key string) (any, bool) {
+	path, err := parseKeyPath(key)
+	if err != nil {
+		return nil, false
+	}
+	var value any = map[string]any{}
+	if err := d.Decode(&value); err != nil {
+		return nil, false
+	}
+	for _, part := range path {
+		table, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = table[part]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Line returns the line a dotted key path is defined on, as a key/value or a
+// table header. Keys it can't find on their own line, such as those inside
+// inline tables, report the line of the nearest enclosing key or table; it
+// returns 0 when the document defines none of them.
+func (d *Document) Line(key string) int {
+	path, err := parseKeyPath(key)
+	if err != nil {
+		return 0
+	}
+	stmts := d.scan()
+	for ; len(path) > 0; path = path[:len(path)-1] {
+		for _, s := range stmts {
+			if s.kind != kindTrivia && equalPath(s.path, path) {
+				return strings.Count(d.src[:s.start], "\n") + 1
+			}
+		}
+	}
+	return 0
+}
+
+// Set sets the value at a dotted key path. An existing value is replaced in
+// place, keeping any comment after it; a new key is added after the last key
+// of its table, and a missing table is appended to the end of the document.
+// Values must be strings, numbers, booleans, times or arrays of them.
+func (d *Document) Set(key string, value any) error {
+	path, err := parseKeyPath(key)
+	if err != nil {
+		return err
+	}
+	encoded, err := encodeValue(value)
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", key, err)
+	}
+
+	stmts := d.scan()
+	if kv := findKeyValue(stmts, path); kv != nil {
+		return d.replace(kv.valueStart, kv.valueEnd, encoded)
+	}
+
+	parent, last := path[:len(path)-1], path[len(path)-1]
+	offset, prefix, found := insertionPoint(stmts, parent)
+	if found {
+		line := formatKeyPath(append(prefix, last)) + " = " + encoded + "\n"
+		return d.replace(offset, offset, d.lineBreakBefore(offset)+line)
+	}
+
+	// The table doesn't exist yet: a root key goes before the first table,
+	// anything else gets a new table at the end
+	if len(parent) == 0 {
+		if offset, ok := firstHeader(stmts); ok {
+			return d.replace(offset, offset, formatKey(last)+" = "+encoded+"\n\n")
+		}
+		return d.replace(len(d.src), len(d.src), d.lineBreakBefore(len(d.src))+formatKey(last)+" = "+encoded+"\n")
+	}
+	section := "[" + formatKeyPath(parent) + "]\n" + formatKey(last) + " = " + encoded + "\n"
+	if strings.TrimSpace(d.src) != "" {
+		section = "\n" + section
+	}
+	return d.replace(len(d.src), len(d.src), d.lineBreakBefore(len(d.src))+section)
+}
+
+// Delete removes a key, or a whole table with its sub-tables, and reports
+// whether it existed
+func (d *Document) Delete(key string) (bool, error) {
+	path, err := parseKeyPath(key)
+	if err != nil {
+		return false, err
+	}
+
+	stmts := d.scan()
+	if kv := findKeyValue(stmts, path); kv != nil {
+		return true, d.replace(kv.start, kv.end, "")
+	}
+	for i, s := range stmts {
+		if s.kind == kindTable && equalPath(s.path, path) {
+			start, end := sectionBounds(stmts, i)
+			return true, d.remove(start, end)
+		}
+	}
+	return false, nil
+}
+
+// Entries returns each [[name]] array-of-tables entry as a standalone
+// document: the entry's keys at the top level, sub-tables such as
+// [name.network] rebased to [network], and the comments directly above the
+// entry kept with it
+func (d *Document) Entries(name string) ([]*Document, error) {
+	path, err := parseKeyPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	stmts := d.scan()
+	var entries []*Document
+	for i, s := range stmts {
+		if s.kind != kindArrayTable || !equalPath(s.path, path) {
+			continue
+		}
+		start, end := sectionBounds(stmts, i)
+
+		var buf strings.Builder
+		for _, t := range stmts {
+			if t.start < start || t.start >= end {
+				continue
+			}
+			switch {
+			case t.start == s.start:
+				// The entry's own header has no place in the standalone document
+			case t.isHeader() && hasPrefix(t.path, path):
+				open, close := "[", "]"
+				if t.kind == kindArrayTable {
+					open, close = "[[", "]]"
+				}
+				buf.WriteString(open + formatKeyPath(t.path[len(path):]) + close + d.src[t.headerEnd:t.end])
+			default:
+				buf.WriteString(d.src[t.start:t.end])
+			}
+		}
+		entry := strings.TrimRight(buf.String(), "\n") + "\n"
+		entries = append(entries, &Document{src: entry})
+	}
+	return entries, nil
+}
+
+// RemoveEntry removes the index-th [[name]] entry, with its sub-tables and
+// the comments directly above it
+func (d *Document) RemoveEntry(name string, index int) error {
+	path, err := parseKeyPath(name)
+	if err != nil {
+		return err
+	}
+
+	stmts := d.scan()
+	n := 0
+	for i, s := range stmts {
+		if s.kind != kindArrayTable || !equalPath(s.path, path) {
+			continue
+		}
+		if n == index {
+			start, end := sectionBounds(stmts, i)
+			return d.remove(start, end)
+		}
+		n++
+	}
+	return fmt.Errorf("no [[%s]] entry %d", name, index)
+}
+
+// replace swaps src[start:end] for text, keeping the document unchanged if
+// the result is not valid TOML
+func (d *Document) replace(start, end int, text string) error {
+	updated := d.src[:start] + text + d.src[end:]
+	var v map[string]any
+	if _, err := toml.Decode(updated, &v); err != nil {
+		return fmt.Errorf("edit would make the document invalid: %w", err)
+	}
+	d.src = updated
+	return nil
+}
+
+// remove deletes src[start:end] and collapses the blank lines left around it
+func (d *Document) remove(start, end int) error {
+	before := strings.TrimRight(d.src[:start], "\n")
+	after := strings.TrimLeft(d.src[end:], "\n")
+	switch {
+	case before == "":
+		return d.replace(0, len(d.src), after)
+	case after == "":
+		return d.replace(0, len(d.src), before+"\n")
+	}
+	return d.replace(0, len(d.src), before+"\n\n"+after)
+}
+
+// lineBreakBefore returns "\n" when inserting at offset would otherwise
+// continue a line that has no newline yet
+func (d *Document) lineBreakBefore(offset int) string {
+	if offset > 0 && d.src[offset-1] != '\n' {
+		return "\n"
+	}
+	return ""
+}
+
+type stmtKind int
+
+const (
+	kindTrivia stmtKind = iota // blank line or comment
+	kindKeyValue
+	kindTable
+	kindArrayTable
+)
+
+// stmt is one statement of the source: [start, end) covers its lines
+// including the trailing newline
+type stmt struct {
+	kind       stmtKind
+	start, end int
+	comment    bool     // a trivia line holding a comment
+	path       []string // table path, or the full path of a key (table + key)
+	table      []string // for key/values, the table the key is written in
+	array      bool     // for key/values, whether that table is an array entry
+	valueStart int
+	valueEnd   int
+	headerEnd  int // for headers, the offset just past the closing bracket
+}
+
+// scan splits the source into statements. The source is known to be valid
+// TOML, so the scanner only needs to find boundaries, not report errors.
+func (d *Document) scan() []stmt {
+	s := &scanner{src: d.src}
+	var stmts []stmt
+	var table []string
+	var array bool
+
+	for s.pos < len(s.src) {
+		st := stmt{start: s.pos}
+		s.skipSpace()
+		switch {
+		case s.pos >= len(s.src) || s.peek() == '\n' || s.peek() == '\r' || s.peek() == '#':
+			st.kind = kindTrivia
+			st.comment = s.pos < len(s.src) && s.peek() == '#'
+		case s.peek() == '[':
+			st.kind = kindTable
+			s.pos++
+			if s.peek() == '[' {
+				st.kind = kindArrayTable
+				s.pos++
+			}
+			st.path = s.key()
+			s.skipSpace()
+			for s.pos < len(s.src) && s.peek() == ']' {
+				s.pos++
+			}
+			st.headerEnd = s.pos
+			table, array = st.path, st.kind == kindArrayTable
+		default:
+			st.kind = kindKeyValue
+			st.table, st.array = table, array
+			st.path = append(append([]string{}, table...), s.key()...)
+			s.skipSpace()
+			s.pos++ // '='
+			s.skipSpace()
+			st.valueStart = s.pos
+			s.value()
+			st.valueEnd = s.pos
+		}
+		s.skipLine()
+		st.end = s.pos
+		stmts = append(stmts, st)
+	}
+	return stmts
+}
+
+// findKeyValue finds the key/value defining path outside array entries
+func findKeyValue(stmts []stmt, path []string) *stmt {
+	for i := range stmts {
+		if stmts[i].kind == kindKeyValue && !stmts[i].array && equalPath(stmts[i].path, path) {
+			return &stmts[i]
+		}
+	}
+	return nil
+}
+
+// insertionPoint finds where a new key of table parent goes: after the last
+// key written in it, or after its header when it has none. prefix is the
+// dotted key prefix the new key needs there, for tables defined by dotted keys.
+func insertionPoint(stmts []stmt, parent []string) (offset int, prefix []string, found bool) {
+	if len(parent) == 0 {
+		for _, s := range stmts {
+			if s.isHeader() {
+				break
+			}
+			if s.kind == kindKeyValue {
+				offset, found = s.end, true
+			}
+		}
+		return offset, nil, found
+	}
+
+	for i, s := range stmts {
+		if s.kind != kindTable || !equalPath(s.path, parent) {
+			continue
+		}
+		offset = s.end
+		for _, t := range stmts[i+1:] {
+			if t.isHeader() {
+				break
+			}
+			if t.kind == kindKeyValue {
+				offset = t.end
+			}
+		}
+		return offset, nil, true
+	}
+
+	// A table defined by dotted keys, e.g. build.backend under [container_registry]
+	for _, s := range stmts {
+		if s.kind == kindKeyValue && !s.array && len(s.path) > len(parent) && hasPrefix(s.path, parent) && len(s.table) <= len(parent) {
+			offset, prefix, found = s.end, parent[len(s.table):], true
+		}
+	}
+	return offset, prefix, found
+}
+
+// sectionBounds returns the extent of the table at stmts[i]: from the
+// comments directly above its header to the comments directly above the next
+// header that isn't one of its sub-tables
+func sectionBounds(stmts []stmt, i int) (int, int) {
+	start := leadingComments(stmts, i)
+	end := stmts[len(stmts)-1].end
+	for j := i + 1; j < len(stmts); j++ {
+		t := stmts[j]
+		if !t.isHeader() {
+			continue
+		}
+		if len(t.path) > len(stmts[i].path) && hasPrefix(t.path, stmts[i].path) {
+			continue
+		}
+		end = leadingComments(stmts, j)
+		break
+	}
+	return start, end
+}
+
+// leadingComments returns where the comment block directly above stmts[i] starts
+func leadingComments(stmts []stmt, i int) int {
+	start := stmts[i].start
+	for j := i - 1; j >= 0 && stmts[j].comment; j-- {
+		start = stmts[j].start
+	}
+	return start
+}
+
+// firstHeader returns where the first table, with the comments above it, starts
+func firstHeader(stmts []stmt) (int, bool) {
+	for i, s := range stmts {
+		if s.isHeader() {
+			return leadingComments(stmts, i), true
+		}
+	}
+	return 0, false
+}
+
+func (s stmt) isHeader() bool {
+	return s.kind == kindTable || s.kind == kindArrayTable
+}
@@ -0,0 +1,218 @@
+package tomledit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const machineTOML = `# Reverse proxy for the lab
+name = "caddy"   # must match the directory
+fqdn = "caddy.lab.example.com"
+tags = [
+  "web",   # public
+  "proxy",
+]
+
+# Static addressing
+[network]
+ip = "10.0.0.5"
+gateway = "10.0.0.1"
+
+[container_registry]
+url = "ghcr.io"
+build.backend = "simple"
+
+[[units]]
+name = "a.service"
+contents = """
+[Service]
+ExecStart=/bin/true # not a TOML comment
+"""
+`
+
+func parse(t *testing.T, content string) *Document {
+	t.Helper()
+	doc, err := Parse([]byte(content))
+	require.NoError(t, err)
+	return doc
+}
+
+func TestSet_ReplacesInPlace(t *testing.T) {
+	doc := parse(t, machineTOML)
+
+	require.NoError(t, doc.Set("name", "web"))
+	require.NoError(t, doc.Set("network.ip", "10.0.0.6"))
+	require.NoError(t, doc.Set("tags", []string{"web"}))
+	require.NoError(t, doc.Set("container_registry.build.backend", "podman"))
+
+	out := string(doc.Bytes())
+	assert.Contains(t, out, "# Reverse proxy for the lab\nname = \"web\"   # must match the directory\n")
+	assert.Contains(t, out, "tags = [\"web\"]\n\n# Static addressing\n[network]\nip = \"10.0.0.6\"\ngateway")
+	assert.Contains(t, out, "build.backend = \"podman\"\n")
+	assert.Contains(t, out, "ExecStart=/bin/true # not a TOML comment\n")
+
+	value, ok := doc.Get("network.ip")
+	require.True(t, ok)
+	assert.Equal(t, "10.0.0.6", value)
+}
+
+func TestSet_AddsKeys(t *testing.T) {
+	doc := parse(t, machineTOML)
+
+	require.NoError(t, doc.Set("mac", "52:54:00:12:34:56"))
+	require.NoError(t, doc.Set("network.dns", []string{"10.0.0.1"}))
+	require.NoError(t, doc.Set("container_registry.build.platforms", []string{"linux/amd64"}))
+	require.NoError(t, doc.Set("updates.strategy", "periodic"))
+
+	out := string(doc.Bytes())
+	assert.Contains(t, out, "  \"proxy\",\n]\nmac = \"52:54:00:12:34:56\"\n\n# Static addressing")
+	assert.Contains(t, out, "gateway = \"10.0.0.1\"\ndns = [\"10.0.0.1\"]\n")
+	assert.Contains(t, out, "build.backend = \"simple\"\nbuild.platforms = [\"linux/amd64\"]\n")
+	assert.Contains(t, out, "\"\"\"\n\n[updates]\nstrategy = \"periodic\"\n")
+
+	var decoded struct {
+		Mac     string `toml:"mac"`
+		Updates struct {
+			Strategy string `toml:"strategy"`
+		} `toml:"updates"`
+	}
+	require.NoError(t, doc.Decode(&decoded))
+	assert.Equal(t, "52:54:00:12:34:56", decoded.Mac)
+	assert.Equal(t, "periodic", decoded.Updates.Strategy)
+}
+
+func TestSet_RootKeyBeforeFirstTable(t *testing.T) {
+	doc := parse(t, "# header\n[network]\nip = \"10.0.0.5\"\n")
+	require.NoError(t, doc.Set("name", "web"))
+	assert.Equal(t, "name = \"web\"\n\n# header\n[network]\nip = \"10.0.0.5\"\n", string(doc.Bytes()))
+
+	empty := parse(t, "")
+	require.NoError(t, empty.Set("network.ip", "10.0.0.5"))
+	assert.Equal(t, "[network]\nip = \"10.0.0.5\"\n", string(empty.Bytes()))
+}
+
+func TestSet_Invalid(t *testing.T) {
+	doc := parse(t, machineTOML)
+	before := string(doc.Bytes())
+
+	assert.Error(t, doc.Set("network", "flat"), "a table can't become a string")
+	assert.Error(t, doc.Set("network", map[string]string{"ip": "x"}), "tables can't be set as values")
+	assert.Error(t, doc.Set("bad key", "x"))
+	assert.Equal(t, before, string(doc.Bytes()), "failed edits leave the document alone")
+}
+
+func TestDelete(t *testing.T) {
+	doc := parse(t, machineTOML)
+
+	found, err := doc.Delete("fqdn")
+	require.NoError(t, err)
+	assert.True(t, found)
+	found, err = doc.Delete("network")
+	require.NoError(t, err)
+	assert.True(t, found)
+	found, err = doc.Delete("missing")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	out := string(doc.Bytes())
+	assert.NotContains(t, out, "fqdn")
+	assert.NotContains(t, out, "Static addressing", "comments above a table go with it")
+	assert.Contains(t, out, "  \"proxy\",\n]\n\n[container_registry]\n")
+}
+
+const legacyTOML = `# Lab machines
+
+# The proxy
+[[machines]]
+name = "caddy"
+fqdn = "caddy.lab.example.com" # public name
+
+[machines.network]
+ip = "10.0.0.5"
+
+[[machines.units]]
+name = "a.service"
+
+[[machines]]
+name = "tools"
+
+[defaults]
+x = 1
+`
+
+func TestEntries(t *testing.T) {
+	doc := parse(t, legacyTOML)
+
+	entries, err := doc.Entries("machines")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, `# The proxy
+name = "caddy"
+fqdn = "caddy.lab.example.com" # public name
+
+[network]
+ip = "10.0.0.5"
+
+[[units]]
+name = "a.service"
+`, string(entries[0].Bytes()))
+	assert.Equal(t, "name = \"tools\"\n", string(entries[1].Bytes()))
+}
+
+func TestRemoveEntry(t *testing.T) {
+	doc := parse(t, legacyTOML)
+	require.NoError(t, doc.RemoveEntry("machines", 0))
+	assert.Equal(t, "# Lab machines\n\n[[machines]]\nname = \"tools\"\n\n[defaults]\nx = 1\n", string(doc.Bytes()))
+
+	require.NoError(t, doc.RemoveEntry("machines", 0))
+	assert.Equal(t, "# Lab machines\n\n[defaults]\nx = 1\n", string(doc.Bytes()))
+	assert.Error(t, doc.RemoveEntry("machines", 0))
+}
+
+func TestSave_KeepsPermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "defaults.toml")
+	require.NoError(t, os.WriteFile(path, []byte("# secrets\nkey = \"a\"\n"), 0600))
+
+	doc, err := Load(path)
+	require.NoError(t, err)
+	require.NoError(t, doc.Set("key", "b"))
+	require.NoError(t, doc.Save(path))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "# secrets\nkey = \"b\"\n", string(content))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files are left behind")
+}
+
+func TestParse_Invalid(t *testing.T) {
+	_, err := Parse([]byte("name = \n"))
+	assert.Error(t, err)
+}
+
+func TestScan_Datetime(t *testing.T) {
+	doc := parse(t, "built = 1979-05-27 07:32:00Z # local\nname = \"a\"\n")
+	require.NoError(t, doc.Set("name", "b"))
+	require.NoError(t, doc.Set("built", "now"))
+	assert.Equal(t, "built = \"now\" # local\nname = \"b\"\n", string(doc.Bytes()))
+}
+
+func TestLine(t *testing.T) {
+	doc := parse(t, machineTOML)
+	assert.Equal(t, 2, doc.Line("name"))
+	assert.Equal(t, 10, doc.Line("network"))
+	assert.Equal(t, 12, doc.Line("network.gateway"))
+	assert.Equal(t, 16, doc.Line("container_registry.build.backend"))
+	assert.Equal(t, 20, doc.Line("units.contents"))
+	assert.Equal(t, 10, doc.Line("network.mask"), "undefined keys fall back to their table")
+	assert.Zero(t, doc.Line("vars"))
+}
@@ -0,0 +1,167 @@
+package validator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// DefaultDir holds the repository's validator executables
+const DefaultDir = "config/validators"
+
+// DefaultTimeout is how long a validator may run for one machine
+const DefaultTimeout = 30 * time.Second
+
+// Finding severities; errors fail validation, warnings are only reported
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Validator is an executable that checks one machine's rendered configuration.
+// It's given an Input as JSON on stdin and prints an Output as JSON on stdout.
+type Validator struct {
+	Name string
+	Path string
+}
+
+// Input is what a validator reads on stdin
+type Input struct {
+	Name     string          `json:"name"`
+	Machine  map[string]any  `json:"machine"`  // The machine's machine.toml settings, keyed as in machine.toml
+	Butane   string          `json:"butane"`   // Rendered butane YAML
+	Ignition json.RawMessage `json:"ignition"` // Generated ignition
+}
+
+// NewInput returns the input for a machine, its machine.toml settings and
+// its rendered configuration
+func NewInput(name string, machine any, butane string, ignition []byte) (Input, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(machine); err != nil {
+		return Input{}, fmt.Errorf("failed to encode machine for validator input: %w", err)
+	}
+	settings := map[string]any{}
+	if _, err := toml.Decode(buf.String(), &settings); err != nil {
+		return Input{}, fmt.Errorf("failed to decode machine for validator input: %w", err)
+	}
+	if len(ignition) == 0 {
+		ignition = []byte("{}")
+	}
+	return Input{Name: name, Machine: settings, Butane: butane, Ignition: ignition}, nil
+}
+
+// Output is what a validator prints on stdout; no output means no findings
+type Output struct {
+	Findings []Finding `json:"findings"`
+}
+
+// Finding is one problem a validator reports
+type Finding struct {
+	Severity  string `json:"severity,omitempty"` // SeverityError (default) or SeverityWarning
+	Message   string `json:"message"`
+	Path      string `json:"path,omitempty"` // Where in the config, e.g. "storage.files[2]"
+	Validator string `json:"-"`
+	Machine   string `json:"-"`
+}
+
+// Failed reports whether the finding fails validation
+func (f Finding) Failed() bool {
+	return f.Severity != SeverityWarning
+}
+
+func (f Finding) String() string {
+	msg := fmt.Sprintf("%s: %s", f.Machine, f.Message)
+	if f.Path != "" {
+		msg += fmt.Sprintf(" at %s", f.Path)
+	}
+	return msg + fmt.Sprintf(" (validator %s)", f.Validator)
+}
+
+// Load returns the executables in dir, sorted by name, or none if dir doesn't
+// exist. Hidden files and files without an execute bit, such as a README, are
+// skipped.
+func Load(dir string) ([]Validator, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	var validators []Validator
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+		}
+		if info.Mode()&0111 == 0 {
+			continue
+		}
+		path, err := filepath.Abs(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", entry.Name(), err)
+		}
+		validators = append(validators, Validator{Name: entry.Name(), Path: path})
+	}
+	sort.Slice(validators, func(i, j int) bool { return validators[i].Name < validators[j].Name })
+	return validators, nil
+}
+
+// Run runs the validator on input and returns its findings. A validator that
+// exits non-zero without printing findings, prints something other than an
+// Output, or runs longer than timeout fails.
+func (v Validator) Run(ctx context.Context, input Input, timeout time.Duration) ([]Finding, error) {
+	stdin, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode validator input: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, v.Path)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("validator %s timed out after %s for %s", v.Name, timeout, input.Name)
+	}
+
+	var output Output
+	if len(bytes.TrimSpace(stdout.Bytes())) > 0 {
+		if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+			return nil, fmt.Errorf("validator %s printed invalid output for %s: %w", v.Name, input.Name, err)
+		}
+	}
+	if runErr != nil && len(output.Findings) == 0 {
+		return nil, fmt.Errorf("validator %s failed for %s: %w: %s", v.Name, input.Name, runErr, strings.TrimSpace(stderr.String()))
+	}
+
+	for i := range output.Findings {
+		f := &output.Findings[i]
+		if f.Severity == "" {
+			f.Severity = SeverityError
+		}
+		if f.Severity != SeverityError && f.Severity != SeverityWarning {
+			return nil, fmt.Errorf("validator %s returned severity %q for %s, expected %s or %s", v.Name, f.Severity, input.Name, SeverityError, SeverityWarning)
+		}
+		f.Validator = v.Name
+		f.Machine = input.Name
+	}
+	return output.Findings, nil
+}
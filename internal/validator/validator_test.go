@@ -0,0 +1,97 @@
+package validator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeValidator writes a shell script validator to dir
+func writeValidator(t *testing.T, dir, name, script string) Validator {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755))
+	return Validator{Name: name, Path: path}
+}
+
+func TestLoad(t *testing.T) {
+	validators, err := Load(filepath.Join(t.TempDir(), "missing"))
+	require.NoError(t, err)
+	assert.Empty(t, validators)
+
+	dir := t.TempDir()
+	writeValidator(t, dir, "20-ntp", "true\n")
+	writeValidator(t, dir, "10-hostname", "true\n")
+	writeValidator(t, dir, ".hidden", "true\n")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("# Validators\n"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "lib"), 0755))
+
+	validators, err = Load(dir)
+	require.NoError(t, err)
+	require.Len(t, validators, 2)
+	assert.Equal(t, "10-hostname", validators[0].Name)
+	assert.Equal(t, "20-ntp", validators[1].Name)
+	assert.True(t, filepath.IsAbs(validators[0].Path))
+}
+
+func TestNewInput(t *testing.T) {
+	type machine struct {
+		Name string `toml:"name"`
+		Site string `toml:"site,omitempty"`
+	}
+	input, err := NewInput("web-01", machine{Name: "web-01", Site: "home"}, "variant: fcos\n", nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"name": "web-01", "site": "home"}, input.Machine)
+	assert.JSONEq(t, "{}", string(input.Ignition))
+}
+
+func TestValidator_Run(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	input := Input{Name: "web-01", Machine: map[string]any{"name": "web-01"}, Butane: "variant: fcos\n", Ignition: []byte(`{"ignition":{}}`)}
+
+	// The input arrives on stdin
+	echo := writeValidator(t, dir, "echo", `input=$(cat)
+case "$input" in *'"name":"web-01"'*'"butane":"variant: fcos\n"'*) ;; *) exit 3 ;; esac
+`)
+	findings, err := echo.Run(ctx, input, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, findings)
+
+	report := writeValidator(t, dir, "ntp", `cat <<'JSON'
+{"findings": [
+  {"message": "no NTP servers", "path": "storage.files"},
+  {"severity": "warning", "message": "chrony is the default"}
+]}
+JSON
+exit 1
+`)
+	findings, err = report.Run(ctx, input, time.Minute)
+	require.NoError(t, err, "findings explain a non-zero exit")
+	require.Len(t, findings, 2)
+	assert.True(t, findings[0].Failed())
+	assert.Equal(t, "web-01: no NTP servers at storage.files (validator ntp)", findings[0].String())
+	assert.False(t, findings[1].Failed())
+
+	crash := writeValidator(t, dir, "crash", "echo boom >&2\nexit 2\n")
+	_, err = crash.Run(ctx, input, time.Minute)
+	assert.ErrorContains(t, err, "validator crash failed for web-01")
+	assert.ErrorContains(t, err, "boom")
+
+	garbage := writeValidator(t, dir, "garbage", "echo not json\n")
+	_, err = garbage.Run(ctx, input, time.Minute)
+	assert.ErrorContains(t, err, "invalid output")
+
+	severity := writeValidator(t, dir, "severity", `echo '{"findings": [{"severity": "fatal", "message": "x"}]}'`+"\n")
+	_, err = severity.Run(ctx, input, time.Minute)
+	assert.ErrorContains(t, err, `severity "fatal"`)
+
+	slow := writeValidator(t, dir, "slow", "exec sleep 10\n")
+	_, err = slow.Run(ctx, input, 50*time.Millisecond)
+	assert.ErrorContains(t, err, "timed out")
+}